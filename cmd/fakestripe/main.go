@@ -0,0 +1,30 @@
+// Command fakestripe serves a fake Stripe API for local development. Point the app at it with
+// STRIPE_API_BASE and give both the same STRIPE_WEBHOOK_SECRET.
+//
+//	go run ./cmd/fakestripe -addr :12111 -webhook http://localhost:8080/payments/webhook
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+)
+
+func main() {
+	addr := flag.String("addr", ":12111", "address to listen on")
+	webhookURL := flag.String("webhook", "http://localhost:8080/payments/webhook", "URL webhook events are sent to")
+	secret := flag.String("secret", os.Getenv("STRIPE_WEBHOOK_SECRET"), "webhook signing secret (defaults to $STRIPE_WEBHOOK_SECRET)")
+	flag.Parse()
+
+	if *secret == "" {
+		log.Fatal("A webhook signing secret is required")
+	}
+
+	log.Printf("Fake Stripe listening on %s, sending webhooks to %s", *addr, *webhookURL)
+	if err := http.ListenAndServe(*addr, helpers.NewFakeStripeServer(*secret, *webhookURL)); err != nil {
+		log.Fatal("Server error:", err)
+	}
+}
@@ -0,0 +1,86 @@
+// Command rankeval replays historical swipes through the discovery ranking pipeline and reports
+// how well it orders likes ahead of passes, next to the old most-recently-active ordering.
+//
+//	go run ./cmd/rankeval -days 30 -k 10 -weights "distance=0.3,reciprocal=0.2"
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+)
+
+func main() {
+	days := flag.Int("days", 30, "number of days of swipe history to replay")
+	minSwipes := flag.Int("min-swipes", 5, "minimum swipes for a user-day to count as a session")
+	k := flag.Int("k", 10, "cutoff for precision@k and NDCG@k")
+	weightSpec := flag.String("weights", os.Getenv(services.RankingWeightsEnv), "feature weights as name=weight,... (defaults to $"+services.RankingWeightsEnv+")")
+	flag.Parse()
+
+	weights, err := services.ParseRankingWeights(*weightSpec)
+	if err != nil {
+		log.Fatal("Invalid weights: ", err)
+	}
+
+	db, err := helpers.ConnectToDatabase()
+	if err != nil {
+		log.Fatal("Error connecting to the database: ", err)
+	}
+
+	// The evaluation only reads from the database, so the repositories need no cache
+	dbHandler := helpers.NewGormDBHandler(db)
+	profileRepo := repository.NewProfileRepositoryWithGormDBAndRedis(dbHandler, nil)
+	locationRepo := repository.NewLocationRepositoryWithGormDBAndRedis(dbHandler, nil, profileRepo)
+	swipeHistoryRepo := repository.NewSwipeHistoryRepositoryWithGormDBAndRedis(dbHandler, nil)
+
+	evaluator := services.NewRankingEvaluator(swipeHistoryRepo, profileRepo, locationRepo)
+	sessions, err := evaluator.LoadSessions(time.Now().AddDate(0, 0, -*days), *minSwipes)
+	if err != nil {
+		log.Fatal("Error loading swipe history: ", err)
+	}
+	if len(sessions) == 0 {
+		log.Fatalf("No sessions with at least %d swipes, a like and a pass in the last %d days", *minSwipes, *days)
+	}
+
+	printComparison(os.Stdout, weights, *k, compareRankings(sessions, weights, *k))
+}
+
+// namedReport is the evaluation of one ranking, labelled for the comparison table
+type namedReport struct {
+	name   string
+	report services.EvaluationReport
+}
+
+// compareRankings evaluates the old most-recently-active ordering and the weighted pipeline on
+// the same sessions
+func compareRankings(sessions []services.EvaluationSession, weights services.RankingWeights, k int) []namedReport {
+	baseline := services.NewRankingPipeline(nil, services.RankingWeights{services.FeatureRecency: 1}, services.DefaultFeatures(), nil)
+	candidate := services.NewRankingPipeline(nil, weights, services.DefaultFeatures(), services.DefaultReRankers())
+
+	return []namedReport{
+		{"recency (baseline)", services.EvaluateRanking(baseline, sessions, k)},
+		{"weighted", services.EvaluateRanking(candidate, sessions, k)},
+	}
+}
+
+// printComparison writes the totals of the sessions and a table of each ranking's metrics
+func printComparison(out io.Writer, weights services.RankingWeights, k int, reports []namedReport) {
+	first := reports[0].report
+	fmt.Fprintf(out, "%d sessions, %d swipes, %d likes\n", first.Sessions, first.Swipes, first.Likes)
+	fmt.Fprintf(out, "weights: %v\n\n", weights)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ranking\tP@%d\tNDCG@%d\tMRR\tAUC\n", k, k)
+	for _, r := range reports {
+		fmt.Fprintf(w, "%s\t%.4f\t%.4f\t%.4f\t%.4f\n", r.name, r.report.PrecisionAtK, r.report.NDCGAtK, r.report.MRR, r.report.AUC)
+	}
+	w.Flush()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+)
+
+func TestCompareRankings(t *testing.T) {
+	// The like is the least recently active candidate, but the only one who liked the viewer first
+	sessions := []services.EvaluationSession{{
+		Viewer: &services.Viewer{UserID: 1},
+		Candidates: []services.Candidate{
+			{Location: repository.LocationWithDistance{UserID: 2}, Swipes: 10},
+			{Location: repository.LocationWithDistance{UserID: 3}, LikedViewer: true},
+		},
+		Liked: map[int]bool{2: false, 3: true},
+	}}
+	weights := services.RankingWeights{services.FeatureReciprocalLike: 1}
+
+	reports := compareRankings(sessions, weights, 1)
+	if len(reports) != 2 || reports[0].name != "recency (baseline)" || reports[1].name != "weighted" {
+		t.Fatalf("expected the baseline then the weighted ranking, got %+v", reports)
+	}
+	if baseline := reports[0].report; baseline.Sessions != 1 || baseline.PrecisionAtK != 0 {
+		t.Errorf("expected the baseline to keep the pass first, got %+v", baseline)
+	}
+	if weighted := reports[1].report; weighted.Sessions != 1 || weighted.PrecisionAtK != 1 || weighted.AUC != 1 {
+		t.Errorf("expected the weighted ranking to put the like first, got %+v", weighted)
+	}
+}
+
+func TestPrintComparison(t *testing.T) {
+	reports := []namedReport{
+		{"recency (baseline)", services.EvaluationReport{Sessions: 3, Swipes: 40, Likes: 12, K: 5, PrecisionAtK: 0.2, NDCGAtK: 0.25, MRR: 0.3, AUC: 0.5}},
+		{"weighted", services.EvaluationReport{Sessions: 3, Swipes: 40, Likes: 12, K: 5, PrecisionAtK: 0.4, NDCGAtK: 0.45, MRR: 0.6, AUC: 0.75}},
+	}
+
+	var out bytes.Buffer
+	printComparison(&out, services.RankingWeights{services.FeatureDistance: 1}, 5, reports)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"3 sessions, 40 swipes, 12 likes",
+		"weights: map[distance:1]",
+		"",
+		"ranking             P@5     NDCG@5  MRR     AUC",
+		"recency (baseline)  0.2000  0.2500  0.3000  0.5000",
+		"weighted            0.4000  0.4500  0.6000  0.7500",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines, got:\n%s", len(want), out.String())
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d: expected %q, got %q", i+1, want[i], lines[i])
+		}
+	}
+}
@@ -0,0 +1,52 @@
+-- V10__create_plan_and_subscription_tables.sql
+CREATE TABLE IF NOT EXISTS "Plan" (
+    "PlanID" SERIAL PRIMARY KEY,
+    "Code" VARCHAR(30) NOT NULL UNIQUE,
+    "Name" VARCHAR(50) NOT NULL,
+    "Rank" INT NOT NULL,
+    "PriceCents" INT NOT NULL,
+    "Currency" VARCHAR(3) NOT NULL DEFAULT 'USD',
+    "PeriodMonths" INT NOT NULL DEFAULT 1,
+    "UnlimitedLikes" BOOLEAN NOT NULL DEFAULT false,
+    "SeeWhoLikesYou" BOOLEAN NOT NULL DEFAULT false,
+    -- -1 means unlimited
+    "RewindsPerDay" INT NOT NULL DEFAULT 0,
+    "Passport" BOOLEAN NOT NULL DEFAULT false,
+    "BoostsPerMonth" INT NOT NULL DEFAULT 0,
+    "MessageBeforeMatch" BOOLEAN NOT NULL DEFAULT false,
+    "IsActive" BOOLEAN NOT NULL DEFAULT true
+);
+
+INSERT INTO "Plan" ("Code", "Name", "Rank", "PriceCents", "UnlimitedLikes", "SeeWhoLikesYou", "RewindsPerDay", "Passport", "BoostsPerMonth", "MessageBeforeMatch") VALUES
+    ('plus', 'Plus', 1, 999, true, false, 5, true, 0, false),
+    ('gold', 'Gold', 2, 1999, true, true, -1, true, 1, false),
+    ('platinum', 'Platinum', 3, 2999, true, true, -1, true, 3, true)
+ON CONFLICT ("Code") DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS "Subscription" (
+    "SubscriptionID" SERIAL PRIMARY KEY,
+    "UserID" INT NOT NULL,
+    "PlanID" INT NOT NULL,
+    "Status" VARCHAR(20) NOT NULL,
+    "CurrentPeriodStart" TIMESTAMP NOT NULL,
+    "CurrentPeriodEnd" TIMESTAMP NOT NULL,
+    "CanceledAt" TIMESTAMP,
+    "CreatedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    "UpdatedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY ("UserID") REFERENCES "User"("UserID"),
+    FOREIGN KEY ("PlanID") REFERENCES "Plan"("PlanID")
+);
+
+-- A user has at most one subscription that is not expired
+CREATE UNIQUE INDEX IF NOT EXISTS "UQ_Subscription_User_Current" ON "Subscription" ("UserID") WHERE "Status" <> 'Expired';
+
+-- Existing premium users keep their remaining time on Gold, which covers every feature premium had
+INSERT INTO "Subscription" ("UserID", "PlanID", "Status", "CurrentPeriodStart", "CurrentPeriodEnd")
+SELECT u."UserID", p."PlanID", 'Active', COALESCE(u."PremiumStartDate", NOW()), u."PremiumEndDate"
+FROM "User" u
+JOIN "Plan" p ON p."Code" = 'gold'
+WHERE u."PremiumStatus" = 'Premium' AND u."PremiumEndDate" > NOW();
+
+ALTER TABLE "User" DROP COLUMN IF EXISTS "PremiumStatus";
+ALTER TABLE "User" DROP COLUMN IF EXISTS "PremiumStartDate";
+ALTER TABLE "User" DROP COLUMN IF EXISTS "PremiumEndDate";
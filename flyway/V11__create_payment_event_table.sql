@@ -0,0 +1,34 @@
+-- V11__create_payment_event_table.sql
+ALTER TABLE "Subscription" ADD COLUMN IF NOT EXISTS "Provider" VARCHAR(20);
+ALTER TABLE "Subscription" ADD COLUMN IF NOT EXISTS "ProviderSubscriptionID" VARCHAR(100);
+ALTER TABLE "Subscription" ADD COLUMN IF NOT EXISTS "ProviderCustomerID" VARCHAR(100);
+
+CREATE UNIQUE INDEX IF NOT EXISTS "UQ_Subscription_Provider" ON "Subscription" ("Provider", "ProviderSubscriptionID")
+    WHERE "ProviderSubscriptionID" IS NOT NULL;
+
+-- Ledger of payment provider webhooks; the unique event ID makes redelivered events no-ops
+CREATE TABLE IF NOT EXISTS "PaymentEvent" (
+    "PaymentEventID" SERIAL PRIMARY KEY,
+    "Provider" VARCHAR(20) NOT NULL,
+    "ProviderEventID" VARCHAR(100) NOT NULL,
+    "EventType" VARCHAR(40) NOT NULL,
+    "ProviderEventType" VARCHAR(100) NOT NULL,
+    "UserID" INT,
+    "SubscriptionID" INT,
+    "ProviderSubscriptionID" VARCHAR(100),
+    "ProviderChargeID" VARCHAR(100),
+    "AmountCents" INT NOT NULL DEFAULT 0,
+    "Currency" VARCHAR(3),
+    "Payload" TEXT NOT NULL,
+    "Status" VARCHAR(20) NOT NULL,
+    "Error" TEXT,
+    "ReceivedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    "ProcessedAt" TIMESTAMP,
+    "UpdatedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE ("Provider", "ProviderEventID"),
+    FOREIGN KEY ("SubscriptionID") REFERENCES "Subscription"("SubscriptionID")
+);
+
+-- Refunds and chargebacks are traced back to a subscription through the charge of its invoice
+CREATE INDEX IF NOT EXISTS "IDX_PaymentEvent_Charge" ON "PaymentEvent" ("Provider", "ProviderChargeID");
+CREATE INDEX IF NOT EXISTS "IDX_PaymentEvent_User" ON "PaymentEvent" ("UserID", "ReceivedAt" DESC);
@@ -0,0 +1,9 @@
+-- V12__subscription_lifecycle.sql
+-- Past due subscriptions keep their plan until "GraceUntil" while the provider retries the renewal
+ALTER TABLE "Subscription" ADD COLUMN IF NOT EXISTS "GraceUntil" TIMESTAMP;
+-- The end of access the last expiry reminder was sent for, so each reminder is sent once
+ALTER TABLE "Subscription" ADD COLUMN IF NOT EXISTS "ReminderSentFor" TIMESTAMP;
+
+-- The scheduler scans non-expired subscriptions by the end of their period
+CREATE INDEX IF NOT EXISTS "IDX_Subscription_PeriodEnd" ON "Subscription" ("CurrentPeriodEnd")
+    WHERE "Status" <> 'Expired';
@@ -0,0 +1,57 @@
+-- V13__create_inventory_tables.sql
+ALTER TABLE "Plan" ADD COLUMN IF NOT EXISTS "SuperLikesPerWeek" INT NOT NULL DEFAULT 0;
+UPDATE "Plan" SET "SuperLikesPerWeek" = 3 WHERE "Code" = 'plus';
+UPDATE "Plan" SET "SuperLikesPerWeek" = 5 WHERE "Code" IN ('gold', 'platinum');
+
+CREATE TABLE IF NOT EXISTS "ConsumablePack" (
+    "PackID" SERIAL PRIMARY KEY,
+    "Code" VARCHAR(30) NOT NULL UNIQUE,
+    "Name" VARCHAR(50) NOT NULL,
+    "ItemType" VARCHAR(20) NOT NULL,
+    "Quantity" INT NOT NULL CHECK ("Quantity" > 0),
+    "PriceCents" INT NOT NULL,
+    "Currency" VARCHAR(3) NOT NULL DEFAULT 'USD',
+    "IsActive" BOOLEAN NOT NULL DEFAULT true
+);
+
+INSERT INTO "ConsumablePack" ("Code", "Name", "ItemType", "Quantity", "PriceCents") VALUES
+    ('super_like_5', '5 Super Likes', 'super_like', 5, 499),
+    ('super_like_15', '15 Super Likes', 'super_like', 15, 1199),
+    ('boost_1', '1 Boost', 'boost', 1, 399),
+    ('boost_5', '5 Boosts', 'boost', 5, 1499),
+    ('rewind_10', '10 Rewinds', 'rewind', 10, 299)
+ON CONFLICT ("Code") DO NOTHING;
+
+-- Each grant of items is a lot; the unique source makes granting the same allowance or purchase
+-- twice a no-op, and the check keeps concurrent consumers from overdrawing a lot
+CREATE TABLE IF NOT EXISTS "InventoryLot" (
+    "LotID" SERIAL PRIMARY KEY,
+    "UserID" INT NOT NULL,
+    "ItemType" VARCHAR(20) NOT NULL,
+    "Source" VARCHAR(100) NOT NULL,
+    "Granted" INT NOT NULL,
+    "Remaining" INT NOT NULL CHECK ("Remaining" >= 0),
+    "ExpiresAt" TIMESTAMP,
+    "CreatedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE ("UserID", "ItemType", "Source"),
+    FOREIGN KEY ("UserID") REFERENCES "User"("UserID") ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS "IDX_InventoryLot_Available" ON "InventoryLot" ("UserID", "ItemType", "ExpiresAt")
+    WHERE "Remaining" > 0;
+
+CREATE TABLE IF NOT EXISTS "Boost" (
+    "BoostID" SERIAL PRIMARY KEY,
+    "UserID" INT NOT NULL,
+    "LotID" INT,
+    "StartsAt" TIMESTAMP NOT NULL,
+    "EndsAt" TIMESTAMP NOT NULL,
+    FOREIGN KEY ("UserID") REFERENCES "User"("UserID") ON DELETE CASCADE,
+    FOREIGN KEY ("LotID") REFERENCES "InventoryLot"("LotID") ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS "IDX_Boost_User_EndsAt" ON "Boost" ("UserID", "EndsAt");
+
+-- Pack purchases are one-off payments, which refunds and disputes refer to by payment
+ALTER TABLE "PaymentEvent" ADD COLUMN IF NOT EXISTS "ProviderPaymentID" VARCHAR(100);
+CREATE INDEX IF NOT EXISTS "IDX_PaymentEvent_Payment" ON "PaymentEvent" ("Provider", "ProviderPaymentID");
@@ -0,0 +1,5 @@
+-- V14__likes_received_index.sql
+-- Likes received are looked up by the swiped user among unmatched swipes
+CREATE INDEX IF NOT EXISTS "IDX_SwipeHistory_LikesReceived"
+    ON "SwipeHistory" ("SwipedUserID", "SwiperUserID")
+    WHERE "IsMatched" = false;
@@ -0,0 +1,3 @@
+-- V15__user_time_zone.sql
+-- Daily limits reset at midnight in the user's IANA time zone; empty means UTC
+ALTER TABLE "User" ADD COLUMN IF NOT EXISTS "TimeZone" VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+-- V16__notification_inbox_indexes.sql
+-- The inbox pages through a user's notifications newest first
+CREATE INDEX IF NOT EXISTS "IDX_Notification_Inbox" ON "Notification" ("UserID", "NotificationID" DESC);
+
+-- Unread counts and unread filters only look at unread notifications
+CREATE INDEX IF NOT EXISTS "IDX_Notification_Unread" ON "Notification" ("UserID") WHERE "IsRead" IS DISTINCT FROM true;
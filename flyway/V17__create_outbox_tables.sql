@@ -0,0 +1,26 @@
+-- V17__create_outbox_tables.sql
+-- Domain events written in the same transaction as the change they describe, waiting to be
+-- published to RabbitMQ by the outbox relay
+CREATE TABLE IF NOT EXISTS "OutboxEvent" (
+    "OutboxEventID" BIGSERIAL PRIMARY KEY,
+    "EventName" VARCHAR(50) NOT NULL,
+    "Payload" JSONB NOT NULL,
+    "CreatedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    "AvailableAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    "Attempts" INT NOT NULL DEFAULT 0,
+    "LastError" TEXT,
+    "PublishedAt" TIMESTAMP,
+    "DeadAt" TIMESTAMP
+);
+
+-- The relay only looks at events that still have to be published
+CREATE INDEX IF NOT EXISTS "IDX_OutboxEvent_Pending" ON "OutboxEvent" ("AvailableAt", "OutboxEventID")
+    WHERE "PublishedAt" IS NULL AND "DeadAt" IS NULL;
+
+-- Events each consumer has handled; the primary key makes redeliveries no-ops
+CREATE TABLE IF NOT EXISTS "ProcessedEvent" (
+    "Consumer" VARCHAR(50) NOT NULL,
+    "EventID" VARCHAR(50) NOT NULL,
+    "ProcessedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY ("Consumer", "EventID")
+);
@@ -0,0 +1,34 @@
+-- V18__create_push_delivery_tables.sql
+-- Devices registered for push notifications; a token belongs to whichever user last registered it
+CREATE TABLE IF NOT EXISTS "DeviceToken" (
+    "DeviceTokenID" SERIAL PRIMARY KEY,
+    "UserID" INT NOT NULL,
+    "Platform" VARCHAR(10) NOT NULL,
+    "Token" VARCHAR(255) NOT NULL,
+    "CreatedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    "LastSeenAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT "UniqueDeviceToken" UNIQUE ("Token"),
+    FOREIGN KEY ("UserID") REFERENCES "User"("UserID")
+);
+
+CREATE INDEX IF NOT EXISTS "IDX_DeviceToken_UserID" ON "DeviceToken" ("UserID");
+
+-- Sending each notification on channels besides the in-app inbox
+CREATE TABLE IF NOT EXISTS "NotificationDelivery" (
+    "NotificationID" INT NOT NULL,
+    "Channel" VARCHAR(20) NOT NULL,
+    "UserID" INT NOT NULL,
+    "Status" VARCHAR(20) NOT NULL,
+    "Attempts" INT NOT NULL DEFAULT 0,
+    "NextAttemptAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    "LastError" TEXT,
+    "DeliveredAt" TIMESTAMP,
+    "CreatedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    "UpdatedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY ("NotificationID", "Channel"),
+    FOREIGN KEY ("NotificationID") REFERENCES "Notification"("NotificationID") ON DELETE CASCADE
+);
+
+-- The delivery worker only looks at deliveries still waiting to be sent
+CREATE INDEX IF NOT EXISTS "IDX_NotificationDelivery_Pending" ON "NotificationDelivery" ("NextAttemptAt")
+    WHERE "Status" = 'Pending';
@@ -0,0 +1,16 @@
+-- V19__create_notification_preferences_table.sql
+-- How each user wants to be notified; users without a row get everything on every channel
+CREATE TABLE IF NOT EXISTS "NotificationPreferences" (
+    "UserID" INT PRIMARY KEY,
+    "Muted" JSONB NOT NULL DEFAULT '{}'::jsonb,
+    "QuietHoursStart" VARCHAR(5),
+    "QuietHoursEnd" VARCHAR(5),
+    "DigestEnabled" BOOLEAN NOT NULL DEFAULT false,
+    "DigestTime" VARCHAR(5) NOT NULL DEFAULT '18:00',
+    "UpdatedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY ("UserID") REFERENCES "User"("UserID")
+);
+
+-- Digests are claimed per user
+CREATE INDEX IF NOT EXISTS "IDX_NotificationDelivery_Digest" ON "NotificationDelivery" ("UserID", "NextAttemptAt")
+    WHERE "Status" = 'Digest';
@@ -0,0 +1,10 @@
+-- V20__profile_view_local_date.sql
+-- "DateOnly" was generated from the server date of "Timestamp", but a profile counts as shown for
+-- the rest of the viewer's own day. It now holds the viewer's local date, written by the app, so
+-- "UniqueViewPerDay" keys views on the day they were made for.
+ALTER TABLE "ProfileView" ALTER COLUMN "DateOnly" DROP EXPRESSION;
+ALTER TABLE "ProfileView" ALTER COLUMN "DateOnly" SET DEFAULT CURRENT_DATE;
+ALTER TABLE "ProfileView" ALTER COLUMN "DateOnly" SET NOT NULL;
+
+-- Discovery looks up the profiles shown to the viewer on their current day
+CREATE INDEX IF NOT EXISTS "IDX_ProfileView_ViewerDate" ON "ProfileView" ("ViewerUserID", "DateOnly");
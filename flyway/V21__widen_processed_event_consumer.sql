@@ -0,0 +1,4 @@
+-- V21__widen_processed_event_consumer.sql
+-- Consumers also record each handler and side effect of an event they completed, keyed
+-- "<queue>/<handler>/<effect>", so a redelivery after a partial failure only redoes the rest
+ALTER TABLE "ProcessedEvent" ALTER COLUMN "Consumer" TYPE VARCHAR(150);
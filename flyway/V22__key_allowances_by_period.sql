@@ -0,0 +1,13 @@
+-- V22__key_allowances_by_period.sql
+-- Plan allowances are now one lot per user and period whatever the plan ("allowance:<period>"),
+-- so a plan change tops the period's allowance up instead of granting it again. Rename the lots
+-- of current periods so they are not granted again either; where a plan change already granted a
+-- period twice, the largest lot takes the new name and the others run out with their period.
+UPDATE "InventoryLot"
+SET "Source" = regexp_replace("Source", '^plan:[^:]*:', 'allowance:')
+WHERE "LotID" IN (
+    SELECT DISTINCT ON ("UserID", "ItemType", regexp_replace("Source", '^plan:[^:]*:', 'allowance:')) "LotID"
+    FROM "InventoryLot"
+    WHERE "Source" LIKE 'plan:%' AND "ExpiresAt" > NOW()
+    ORDER BY "UserID", "ItemType", regexp_replace("Source", '^plan:[^:]*:', 'allowance:'), "Granted" DESC, "LotID"
+);
@@ -0,0 +1,12 @@
+-- V23__data_export_jobs.sql
+-- Exports are built by a worker on every instance claiming them from this table. A claimed export
+-- is leased to its instance, which renews the lease while it builds the archive; an export whose
+-- lease ran out, e.g. because its instance restarted, is claimed again until it runs out of attempts.
+ALTER TABLE "DataExport" ADD COLUMN IF NOT EXISTS "Attempts" INT NOT NULL DEFAULT 0;
+ALTER TABLE "DataExport" ADD COLUMN IF NOT EXISTS "LeasedUntil" TIMESTAMP;
+
+-- Exports left running by an instance before the upgrade are claimable at once
+UPDATE "DataExport" SET "LeasedUntil" = "RequestedAt" WHERE "Status" IN ('Pending', 'Processing');
+
+CREATE INDEX IF NOT EXISTS "IDX_DataExport_Claimable" ON "DataExport" ("LeasedUntil")
+    WHERE "Status" IN ('Pending', 'Processing');
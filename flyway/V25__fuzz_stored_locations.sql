@@ -0,0 +1,20 @@
+-- V25__fuzz_stored_locations.sql
+-- Positions written before they were fuzzed are still exact. The fuzzing grid is keyed by the
+-- server secret, so it cannot be applied here: IsFuzzed marks the rows already fuzzed, and the
+-- location retention job fuzzes the others a batch at a time. Rows written from now on are marked
+-- by the application, so rows written by instances not yet upgraded are caught up too.
+ALTER TABLE "Locationhistory" ADD COLUMN IF NOT EXISTS "IsFuzzed" BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE "CurrentLocation" ADD COLUMN IF NOT EXISTS "IsFuzzed" BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE "Passport" ADD COLUMN IF NOT EXISTS "IsFuzzed" BOOLEAN NOT NULL DEFAULT false;
+
+-- A passport to a city is placed on the city's centre, which reveals nothing about the user
+UPDATE "Passport" SET "IsFuzzed" = true WHERE COALESCE("PlaceName", '') <> '';
+
+-- Serve the walk over the rows left to fuzz; coarse history points are already snapped to a
+-- coarser grid and are left as they are
+CREATE INDEX IF NOT EXISTS "IDX_Locationhistory_Unfuzzed" ON "Locationhistory" ("LocationID")
+    WHERE NOT "IsFuzzed" AND NOT "IsCoarse";
+CREATE INDEX IF NOT EXISTS "IDX_CurrentLocation_Unfuzzed" ON "CurrentLocation" ("UserID")
+    WHERE NOT "IsFuzzed";
+CREATE INDEX IF NOT EXISTS "IDX_Passport_Unfuzzed" ON "Passport" ("UserID")
+    WHERE NOT "IsFuzzed";
@@ -0,0 +1,14 @@
+-- V26__spatial_location_indexes.sql
+-- Nearby search finds positions through a GiST index on their point on the Earth's surface,
+-- from the cube and earthdistance extensions shipped with PostgreSQL. The B-tree on
+-- ("Latitude", "Longitude") could only range-scan the latitude band and read every longitude in
+-- it; the GiST index narrows both at once, and needs no special case at the antimeridian or the
+-- poles. Both extensions are trusted, so the migration user only needs CREATE on the database.
+CREATE EXTENSION IF NOT EXISTS cube;
+CREATE EXTENSION IF NOT EXISTS earthdistance;
+
+CREATE INDEX IF NOT EXISTS "IDX_CurrentLocation_Earth" ON "CurrentLocation" USING gist (ll_to_earth("Latitude", "Longitude"));
+CREATE INDEX IF NOT EXISTS "IDX_Passport_Earth" ON "Passport" USING gist (ll_to_earth("Latitude", "Longitude"));
+
+DROP INDEX IF EXISTS "IDX_CurrentLocation_LatLon";
+DROP INDEX IF EXISTS "IDX_Passport_LatLon";
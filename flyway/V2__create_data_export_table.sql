@@ -0,0 +1,14 @@
+-- V2__create_data_export_table.sql
+CREATE TABLE IF NOT EXISTS "DataExport" (
+    "ExportID" SERIAL PRIMARY KEY,
+    "UserID" INT NOT NULL,
+    "Status" VARCHAR(20) NOT NULL,
+    "ArchiveKey" VARCHAR(255),
+    "ErrorMessage" TEXT,
+    "RequestedAt" TIMESTAMP NOT NULL,
+    "CompletedAt" TIMESTAMP,
+    "ExpiresAt" TIMESTAMP,
+    FOREIGN KEY ("UserID") REFERENCES "User"("UserID")
+);
+
+CREATE INDEX IF NOT EXISTS "IDX_DataExport_UserID" ON "DataExport" ("UserID");
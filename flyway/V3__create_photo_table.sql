@@ -0,0 +1,19 @@
+-- V3__create_photo_table.sql
+CREATE TABLE IF NOT EXISTS "Photo" (
+    "PhotoID" SERIAL PRIMARY KEY,
+    "UserID" INT NOT NULL,
+    "Position" INT NOT NULL,
+    "StorageKey" VARCHAR(255) NOT NULL,
+    "ThumbnailKey" VARCHAR(255) NOT NULL,
+    "ContentType" VARCHAR(50) NOT NULL,
+    "Width" INT,
+    "Height" INT,
+    "SizeBytes" BIGINT,
+    "CreatedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY ("UserID") REFERENCES "User"("UserID")
+);
+
+CREATE INDEX IF NOT EXISTS "IDX_Photo_UserID_Position" ON "Photo" ("UserID", "Position");
+
+-- Profile photos are now listed from the Photo table, so the column only needs room for a JSON array of URLs
+ALTER TABLE "Profile" ALTER COLUMN "Photos" TYPE TEXT;
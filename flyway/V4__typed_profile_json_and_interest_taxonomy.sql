@@ -0,0 +1,73 @@
+-- V4__typed_profile_json_and_interest_taxonomy.sql
+
+-- Casts text to jsonb, falling back to a default for empty or malformed legacy values
+CREATE OR REPLACE FUNCTION knoxs_try_jsonb(value TEXT, fallback JSONB) RETURNS JSONB AS $$
+BEGIN
+    IF value IS NULL OR btrim(value) = '' THEN
+        RETURN fallback;
+    END IF;
+    RETURN value::jsonb;
+EXCEPTION WHEN others THEN
+    RETURN fallback;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+-- The model maps these columns to jsonb, but V1 created them as VARCHAR
+ALTER TABLE "Profile"
+    ALTER COLUMN "Photos" TYPE JSONB USING knoxs_try_jsonb("Photos", '[]'::jsonb),
+    ALTER COLUMN "Interests" TYPE JSONB USING knoxs_try_jsonb("Interests", '[]'::jsonb),
+    ALTER COLUMN "SocialMediaAccounts" TYPE JSONB USING knoxs_try_jsonb("SocialMediaAccounts", '{}'::jsonb);
+
+-- Legacy values that were not of the expected JSON shape are reset
+UPDATE "Profile" SET "Photos" = '[]'::jsonb WHERE jsonb_typeof("Photos") <> 'array';
+UPDATE "Profile" SET "Interests" = '[]'::jsonb WHERE jsonb_typeof("Interests") <> 'array';
+UPDATE "Profile" SET "SocialMediaAccounts" = '{}'::jsonb WHERE jsonb_typeof("SocialMediaAccounts") <> 'object';
+
+ALTER TABLE "Profile"
+    ALTER COLUMN "Photos" SET DEFAULT '[]'::jsonb,
+    ALTER COLUMN "Interests" SET DEFAULT '[]'::jsonb,
+    ALTER COLUMN "SocialMediaAccounts" SET DEFAULT '{}'::jsonb;
+
+DROP FUNCTION knoxs_try_jsonb(TEXT, JSONB);
+
+-- Supports "Interests" @> '["hiking"]' lookups for users sharing an interest
+CREATE INDEX IF NOT EXISTS "IDX_Profile_Interests" ON "Profile" USING GIN ("Interests" jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS "IDX_Profile_UserID" ON "Profile" ("UserID");
+
+CREATE TABLE IF NOT EXISTS "Interest" (
+    "InterestID" SERIAL PRIMARY KEY,
+    "Slug" VARCHAR(50) NOT NULL UNIQUE,
+    "Name" VARCHAR(100) NOT NULL,
+    "Category" VARCHAR(50) NOT NULL,
+    "IsActive" BOOLEAN DEFAULT true
+);
+
+INSERT INTO "Interest" ("Slug", "Name", "Category") VALUES
+    ('art', 'Art', 'Creativity'),
+    ('baking', 'Baking', 'Food & Drink'),
+    ('board-games', 'Board games', 'Entertainment'),
+    ('camping', 'Camping', 'Outdoors'),
+    ('cooking', 'Cooking', 'Food & Drink'),
+    ('cycling', 'Cycling', 'Sports'),
+    ('dancing', 'Dancing', 'Creativity'),
+    ('fashion', 'Fashion', 'Creativity'),
+    ('fitness', 'Fitness', 'Sports'),
+    ('football', 'Football', 'Sports'),
+    ('gaming', 'Gaming', 'Entertainment'),
+    ('gardening', 'Gardening', 'Outdoors'),
+    ('hiking', 'Hiking', 'Outdoors'),
+    ('movies', 'Movies', 'Entertainment'),
+    ('music', 'Music', 'Entertainment'),
+    ('photography', 'Photography', 'Creativity'),
+    ('podcasts', 'Podcasts', 'Entertainment'),
+    ('reading', 'Reading', 'Culture'),
+    ('running', 'Running', 'Sports'),
+    ('singing', 'Singing', 'Creativity'),
+    ('swimming', 'Swimming', 'Sports'),
+    ('technology', 'Technology', 'Culture'),
+    ('travel', 'Travel', 'Outdoors'),
+    ('volunteering', 'Volunteering', 'Culture'),
+    ('wine', 'Wine', 'Food & Drink'),
+    ('writing', 'Writing', 'Creativity'),
+    ('yoga', 'Yoga', 'Sports')
+ON CONFLICT ("Slug") DO NOTHING;
@@ -0,0 +1,15 @@
+-- V5__create_block_table_and_incognito.sql
+CREATE TABLE IF NOT EXISTS "Block" (
+    "BlockID" SERIAL PRIMARY KEY,
+    "BlockerUserID" INT NOT NULL,
+    "BlockedUserID" INT NOT NULL,
+    "Timestamp" TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT "UniqueBlock" UNIQUE ("BlockerUserID", "BlockedUserID"),
+    FOREIGN KEY ("BlockerUserID") REFERENCES "User"("UserID"),
+    FOREIGN KEY ("BlockedUserID") REFERENCES "User"("UserID")
+);
+
+CREATE INDEX IF NOT EXISTS "IDX_Block_BlockedUserID" ON "Block" ("BlockedUserID");
+
+-- Incognito users are only shown to people they have liked
+ALTER TABLE "User" ADD COLUMN IF NOT EXISTS "IsIncognito" BOOLEAN DEFAULT false;
@@ -0,0 +1,18 @@
+-- V6__create_discovery_preferences_table.sql
+ALTER TABLE "User" ADD COLUMN IF NOT EXISTS "BirthDate" DATE;
+
+CREATE TABLE IF NOT EXISTS "DiscoveryPreferences" (
+    "UserID" INT PRIMARY KEY,
+    "MinAge" INT NOT NULL DEFAULT 18,
+    "MaxAge" INT NOT NULL DEFAULT 100,
+    "Genders" JSONB NOT NULL DEFAULT '[]'::jsonb,
+    "MaxDistanceKm" DOUBLE PRECISION NOT NULL DEFAULT 0,
+    "MinHeight" INT NOT NULL DEFAULT 0,
+    "MaxHeight" INT NOT NULL DEFAULT 0,
+    "RelationshipGoals" JSONB NOT NULL DEFAULT '[]'::jsonb,
+    "Languages" JSONB NOT NULL DEFAULT '[]'::jsonb,
+    "UpdatedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY ("UserID") REFERENCES "User"("UserID"),
+    CONSTRAINT "CHK_DiscoveryPreferences_Age" CHECK ("MinAge" <= "MaxAge"),
+    CONSTRAINT "CHK_DiscoveryPreferences_Height" CHECK ("MinHeight" <= "MaxHeight")
+);
@@ -0,0 +1,21 @@
+-- V7__create_current_location_table.sql
+CREATE TABLE IF NOT EXISTS "CurrentLocation" (
+    "UserID" INT PRIMARY KEY,
+    "LocationID" INT NOT NULL,
+    "Latitude" DOUBLE PRECISION NOT NULL,
+    "Longitude" DOUBLE PRECISION NOT NULL,
+    "Timestamp" TIMESTAMP NOT NULL,
+    FOREIGN KEY ("UserID") REFERENCES "User"("UserID")
+);
+
+-- Serves the bounding-box prefilter of nearby search
+CREATE INDEX IF NOT EXISTS "IDX_CurrentLocation_LatLon" ON "CurrentLocation" ("Latitude", "Longitude");
+
+CREATE INDEX IF NOT EXISTS "IDX_Locationhistory_User_Timestamp" ON "Locationhistory" ("UserID", "Timestamp" DESC);
+
+INSERT INTO "CurrentLocation" ("UserID", "LocationID", "Latitude", "Longitude", "Timestamp")
+SELECT DISTINCT ON ("UserID") "UserID", "LocationID", "Latitude", "Longitude", "Timestamp"
+FROM "Locationhistory"
+WHERE "Latitude" IS NOT NULL AND "Longitude" IS NOT NULL
+ORDER BY "UserID", "Timestamp" DESC
+ON CONFLICT ("UserID") DO NOTHING;
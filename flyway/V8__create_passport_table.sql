@@ -0,0 +1,13 @@
+-- V8__create_passport_table.sql
+CREATE TABLE IF NOT EXISTS "Passport" (
+    "UserID" INT PRIMARY KEY,
+    "PlaceName" VARCHAR(100),
+    "Latitude" DOUBLE PRECISION NOT NULL,
+    "Longitude" DOUBLE PRECISION NOT NULL,
+    "ExpiresAt" TIMESTAMP NOT NULL,
+    "CreatedAt" TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY ("UserID") REFERENCES "User"("UserID")
+);
+
+-- Serves the bounding-box prefilter of nearby search for travelling users
+CREATE INDEX IF NOT EXISTS "IDX_Passport_LatLon" ON "Passport" ("Latitude", "Longitude");
@@ -0,0 +1,5 @@
+-- V9__location_history_retention.sql
+ALTER TABLE "Locationhistory" ADD COLUMN IF NOT EXISTS "IsCoarse" BOOLEAN NOT NULL DEFAULT false;
+
+-- Serves the purge of points older than the retention period
+CREATE INDEX IF NOT EXISTS "IDX_Locationhistory_Timestamp" ON "Locationhistory" ("Timestamp");
@@ -0,0 +1,153 @@
+// handlers/billing_handlers.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+)
+
+// maxWebhookBytes bounds the size of a webhook payload
+const maxWebhookBytes = 1 << 20
+
+type BillingHandlers struct {
+	billingService *services.BillingService
+	redisHelper    *helpers.RedisHelper
+}
+
+// NewBillingHandlers creates a new instance of BillingHandlers
+func NewBillingHandlers(billingService *services.BillingService, redisHelper *helpers.RedisHelper) *BillingHandlers {
+	return &BillingHandlers{
+		billingService: billingService,
+		redisHelper:    redisHelper,
+	}
+}
+
+// Checkout starts the purchase of a plan and returns the payment page to redirect the user to
+func (h *BillingHandlers) Checkout(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Plan string `json:"plan"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestPayload); err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	session, err := h.billingService.Checkout(int(userID), requestPayload.Plan)
+	if errors.Is(err, services.ErrPlanNotFound) {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Plan not found", nil, err.Error()))
+		return
+	}
+	if errors.Is(err, services.ErrAlreadySubscribed) {
+		helpers.SendJSONResponse(w, http.StatusConflict, helpers.GenerateResponse(false, http.StatusConflict, "Already subscribed", nil, err.Error()))
+		return
+	}
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadGateway, helpers.GenerateResponse(false, http.StatusBadGateway, "Error creating checkout session", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusCreated, helpers.GenerateResponse(true, http.StatusCreated, "Checkout session created successfully", session, nil))
+}
+
+// CheckoutPack starts the purchase of a pack of consumable items and returns the payment page to
+// redirect the user to
+func (h *BillingHandlers) CheckoutPack(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Pack string `json:"pack"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestPayload); err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	session, err := h.billingService.CheckoutPack(int(userID), requestPayload.Pack)
+	if errors.Is(err, services.ErrPackNotFound) {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Pack not found", nil, err.Error()))
+		return
+	}
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadGateway, helpers.GenerateResponse(false, http.StatusBadGateway, "Error creating checkout session", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusCreated, helpers.GenerateResponse(true, http.StatusCreated, "Checkout session created successfully", session, nil))
+}
+
+// Webhook receives signed events from the payment provider. Any non-2xx response makes the
+// provider deliver the event again later.
+func (h *BillingHandlers) Webhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBytes))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	err = h.billingService.HandleWebhook(payload, r.Header.Get(h.billingService.SignatureHeader()))
+	if errors.Is(err, helpers.ErrInvalidWebhookSignature) {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid signature", nil, err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Error handling payment webhook: %v", err)
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error handling webhook", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Webhook received", nil, nil))
+}
@@ -0,0 +1,113 @@
+// handlers/block_handlers.go
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+type BlockHandlers struct {
+	blockRepo   repository.BlockRepository
+	userRepo    repository.UserRepository
+	redisHelper *helpers.RedisHelper
+}
+
+// NewBlockHandlers creates a new instance of BlockHandlers
+func NewBlockHandlers(blockRepo repository.BlockRepository, userRepo repository.UserRepository, redisHelper *helpers.RedisHelper) *BlockHandlers {
+	return &BlockHandlers{
+		blockRepo:   blockRepo,
+		userRepo:    userRepo,
+		redisHelper: redisHelper,
+	}
+}
+
+// BlockUser hides the caller and the given user from each other
+func (h *BlockHandlers) BlockUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	blockedUserID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid user ID", nil, err.Error()))
+		return
+	}
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	if blockedUserID == int(userID) {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Cannot block yourself", nil, ""))
+		return
+	}
+
+	if _, err := h.userRepo.GetUserByID(blockedUserID); err != nil {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "User not found", nil, err.Error()))
+		return
+	}
+
+	if err := h.blockRepo.CreateBlock(int(userID), blockedUserID); err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error blocking user", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "User blocked successfully", nil, nil))
+}
+
+// UnblockUser removes a block the caller placed on the given user
+func (h *BlockHandlers) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	blockedUserID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid user ID", nil, err.Error()))
+		return
+	}
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	if err := h.blockRepo.DeleteBlock(int(userID), blockedUserID); err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error unblocking user", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "User unblocked successfully", nil, nil))
+}
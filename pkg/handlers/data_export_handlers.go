@@ -0,0 +1,163 @@
+// handlers/data_export_handlers.go
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+)
+
+// downloadLinkTTL is how long a signed download link stays valid
+const downloadLinkTTL = time.Hour
+
+type DataExportHandlers struct {
+	exportRepo    repository.DataExportRepository
+	exportService *services.DataExportService
+	redisHelper   *helpers.RedisHelper
+}
+
+// NewDataExportHandlers creates a new instance of DataExportHandlers
+func NewDataExportHandlers(exportRepo repository.DataExportRepository, exportService *services.DataExportService, redisHelper *helpers.RedisHelper) *DataExportHandlers {
+	return &DataExportHandlers{
+		exportRepo:    exportRepo,
+		exportService: exportService,
+		redisHelper:   redisHelper,
+	}
+}
+
+// RequestExport starts building a personal data archive for the caller
+func (h *DataExportHandlers) RequestExport(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	export, err := h.exportService.RequestExport(int(userID))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error requesting data export", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusAccepted, helpers.GenerateResponse(true, http.StatusAccepted, "Data export requested successfully", export, nil))
+}
+
+// GetExport returns the status of an export and, once it is ready, a signed download link
+func (h *DataExportHandlers) GetExport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid export ID", nil, err.Error()))
+		return
+	}
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	export, err := h.exportRepo.GetDataExportByID(id)
+	if err != nil || export.UserID != int(userID) {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Data export not found", nil, ""))
+		return
+	}
+
+	response := map[string]interface{}{
+		"export": export,
+	}
+
+	if export.Status == models.DataExportStatusReady && time.Now().Before(export.ExpiresAt) {
+		// The link never outlives the archive itself
+		linkExpiry := time.Now().Add(downloadLinkTTL)
+		if linkExpiry.After(export.ExpiresAt) {
+			linkExpiry = export.ExpiresAt
+		}
+		response["downloadURL"] = helpers.SignOwnedURL(exportDownloadPath(export.ExportID), export.UserID, linkExpiry)
+		response["downloadURLExpiresAt"] = linkExpiry
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Data export retrieved successfully", response, nil))
+}
+
+// DownloadExport streams the archive to holders of a valid signed link
+func (h *DataExportHandlers) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid export ID", nil, err.Error()))
+		return
+	}
+
+	// Links are signed for the export's owner, so an unknown export is reported like a bad link
+	export, err := h.exportRepo.GetDataExportByID(id)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusForbidden, helpers.GenerateResponse(false, http.StatusForbidden, "Invalid download link", nil, ""))
+		return
+	}
+
+	query := r.URL.Query()
+	err = helpers.VerifyOwnedSignedURL(exportDownloadPath(id), export.UserID, query.Get(helpers.ExpiresParam), query.Get(helpers.SignatureParam))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusForbidden, helpers.GenerateResponse(false, http.StatusForbidden, "Invalid download link", nil, err.Error()))
+		return
+	}
+
+	archive, err := h.exportService.OpenArchive(export)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusGone, helpers.GenerateResponse(false, http.StatusGone, "Data export is no longer available", nil, err.Error()))
+		return
+	}
+	defer archive.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="knoxsdating-export-%d.zip"`, export.ExportID))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, archive); err != nil {
+		log.Printf("Error streaming data export %d: %v", export.ExportID, err)
+	}
+}
+
+func exportDownloadPath(exportID int) string {
+	return fmt.Sprintf("/exports/%d/download", exportID)
+}
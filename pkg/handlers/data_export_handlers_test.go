@@ -0,0 +1,173 @@
+// handlers/data_export_handlers_test.go
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+	"gorm.io/gorm"
+)
+
+// exportRepo keeps exports in memory and claims every due one
+type exportRepo struct {
+	repository.DataExportRepository
+	mu      sync.Mutex
+	exports []models.DataExport
+}
+
+func (r *exportRepo) CreateDataExport(export *models.DataExport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	export.ExportID = len(r.exports) + 1
+	r.exports = append(r.exports, *export)
+	return nil
+}
+
+func (r *exportRepo) GetDataExportByID(exportID int) (*models.DataExport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if exportID < 1 || exportID > len(r.exports) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	export := r.exports[exportID-1]
+	return &export, nil
+}
+
+func (r *exportRepo) UpdateDataExport(export *models.DataExport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.exports[export.ExportID-1] = *export
+	return nil
+}
+
+func (r *exportRepo) ClaimDataExports(now time.Time, lease time.Duration, limit int) ([]models.DataExport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var claimed []models.DataExport
+	for i := range r.exports {
+		if r.exports[i].Status == models.DataExportStatusPending && len(claimed) < limit {
+			r.exports[i].Status = models.DataExportStatusProcessing
+			r.exports[i].Attempts++
+			claimed = append(claimed, r.exports[i])
+		}
+	}
+	return claimed, nil
+}
+
+func (r *exportRepo) GetUserData(userID int) (*repository.UserData, error) {
+	return &repository.UserData{User: &models.User{UserID: userID}}, nil
+}
+
+// noNotifications drops the notifications created
+type noNotifications struct {
+	repository.NotificationRepository
+}
+
+func (noNotifications) CreateNotification(notification *models.Notification) error {
+	return nil
+}
+
+func TestDataExportHandlers_RequestBuildAndDownload(t *testing.T) {
+	t.Setenv(helpers.URLSigningKeyEnv, "test-url-signing-key")
+	exports := &exportRepo{}
+	service := services.NewDataExportService(exports, noNotifications{}, helpers.NewFileSystemBlobStore(t.TempDir()), services.DefaultDataExportPolicy())
+	handlers := NewDataExportHandlers(exports, service, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/users/export", handlers.RequestExport).Methods("POST")
+	router.HandleFunc("/users/export/{id:[0-9]+}", handlers.GetExport).Methods("GET")
+	router.HandleFunc("/exports/{id:[0-9]+}/download", handlers.DownloadExport).Methods("GET")
+
+	owner, _ := helpers.GenerateToken(models.User{UserID: 1})
+	other, _ := helpers.GenerateToken(models.User{UserID: 2})
+
+	if response := serve(router, "POST", "/users/export", ""); response.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", response.Code)
+	}
+	response := serve(router, "POST", "/users/export", owner)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", response.Code, response.Body)
+	}
+	var requested struct {
+		Data models.DataExport `json:"data"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &requested); err != nil || requested.Data.Status != models.DataExportStatusPending {
+		t.Fatalf("expected a pending export, got %s", response.Body)
+	}
+	exportPath := fmt.Sprintf("/users/export/%d", requested.Data.ExportID)
+
+	// getExport returns the export's status and download link as seen by the owner
+	getExport := func() (string, string) {
+		t.Helper()
+		response := serve(router, "GET", exportPath, owner)
+		if response.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+		}
+		var body struct {
+			Data struct {
+				Export      models.DataExport `json:"export"`
+				DownloadURL string            `json:"downloadURL"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		return body.Data.Export.Status, body.Data.DownloadURL
+	}
+
+	if status, link := getExport(); status != models.DataExportStatusPending || link != "" {
+		t.Fatalf("expected a pending export without a link, got %s and %q", status, link)
+	}
+	if report, err := service.RunOnce(context.Background()); err != nil || report.Ready != 1 {
+		t.Fatalf("expected the export built, got %+v (%v)", report, err)
+	}
+	status, link := getExport()
+	if status != models.DataExportStatusReady || link == "" {
+		t.Fatalf("expected a ready export with a link, got %s and %q", status, link)
+	}
+
+	// Someone else's export is not found
+	if response := serve(router, "GET", exportPath, other); response.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another user's export, got %d", response.Code)
+	}
+
+	// The signed link downloads the archive without a token
+	response = serve(router, "GET", link, "")
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("expected the archive, got %d: %s", response.Code, response.Body)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(response.Body.Bytes()), int64(response.Body.Len()))
+	if err != nil || len(archive.File) == 0 {
+		t.Fatalf("expected a ZIP archive, got %v", err)
+	}
+
+	parsed, _ := url.Parse(link)
+	if response := serve(router, "GET", parsed.Path, ""); response.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without a signature, got %d", response.Code)
+	}
+
+	// A link signed for anyone but the owner does not download the archive
+	forged := helpers.SignOwnedURL(parsed.Path, 2, time.Now().Add(time.Minute))
+	if response := serve(router, "GET", forged, ""); response.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a link signed for another user, got %d", response.Code)
+	}
+
+	// Nor does any link once the signing key is gone
+	t.Setenv(helpers.URLSigningKeyEnv, "")
+	if response := serve(router, "GET", link, ""); response.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without a signing key, got %d", response.Code)
+	}
+}
@@ -0,0 +1,159 @@
+// handlers/discovery_handlers.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+)
+
+type DiscoveryHandlers struct {
+	preferencesRepo repository.DiscoveryPreferencesRepository
+	redisHelper     *helpers.RedisHelper
+}
+
+// NewDiscoveryHandlers creates a new instance of DiscoveryHandlers
+func NewDiscoveryHandlers(preferencesRepo repository.DiscoveryPreferencesRepository, redisHelper *helpers.RedisHelper) *DiscoveryHandlers {
+	return &DiscoveryHandlers{
+		preferencesRepo: preferencesRepo,
+		redisHelper:     redisHelper,
+	}
+}
+
+// GetPreferences returns the caller's discovery preferences, or the defaults if none are saved
+func (h *DiscoveryHandlers) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	preferences, err := h.preferencesRepo.GetPreferences(int(userID))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching discovery preferences", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Discovery preferences fetched successfully", preferences, nil))
+}
+
+// UpdatePreferences creates or replaces the caller's discovery preferences
+func (h *DiscoveryHandlers) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	preferences := models.DefaultDiscoveryPreferences(int(userID))
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(preferences); err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	preferences.UserID = int(userID)
+
+	if errs := validatePreferences(preferences); len(errs) > 0 {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid discovery preferences", nil, errs))
+		return
+	}
+
+	if err := h.preferencesRepo.SavePreferences(preferences); err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error saving discovery preferences", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Discovery preferences saved successfully", preferences, nil))
+}
+
+// DeletePreferences resets the caller's discovery preferences to the defaults
+func (h *DiscoveryHandlers) DeletePreferences(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	if err := h.preferencesRepo.DeletePreferences(int(userID)); err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error resetting discovery preferences", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Discovery preferences reset successfully", models.DefaultDiscoveryPreferences(int(userID)), nil))
+}
+
+// validatePreferences combines the tag rules with the cross-field checks
+func validatePreferences(preferences *models.DiscoveryPreferences) helpers.ValidationErrors {
+	errs := helpers.ValidateStruct(preferences)
+	for _, gender := range preferences.Genders {
+		if !helpers.IsEnumValue(helpers.GenderEnum, gender) {
+			errs = append(errs, helpers.FieldError{Field: "Genders", Message: gender + " is not a valid gender"})
+		}
+	}
+	for _, goal := range preferences.RelationshipGoals {
+		if !helpers.IsEnumValue(helpers.RelationshipGoalEnum, goal) {
+			errs = append(errs, helpers.FieldError{Field: "RelationshipGoals", Message: goal + " is not a valid relationshipGoal"})
+		}
+	}
+	for _, language := range preferences.Languages {
+		if !helpers.IsEnumValue(helpers.LanguageEnum, language) {
+			errs = append(errs, helpers.FieldError{Field: "Languages", Message: language + " is not a valid language"})
+		}
+	}
+	if message := services.ValidatePreferences(preferences); message != "" {
+		errs = append(errs, helpers.FieldError{Field: "DiscoveryPreferences", Message: message})
+	}
+	return errs
+}
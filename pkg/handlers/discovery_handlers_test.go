@@ -0,0 +1,137 @@
+// handlers/discovery_handlers_test.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// preferencesRepo keeps discovery preferences in memory, defaulting like the database one
+type preferencesRepo struct {
+	repository.DiscoveryPreferencesRepository
+	saved map[int]models.DiscoveryPreferences
+}
+
+func (r *preferencesRepo) GetPreferences(userID int) (*models.DiscoveryPreferences, error) {
+	if preferences, ok := r.saved[userID]; ok {
+		return &preferences, nil
+	}
+	return models.DefaultDiscoveryPreferences(userID), nil
+}
+
+func (r *preferencesRepo) SavePreferences(preferences *models.DiscoveryPreferences) error {
+	r.saved[preferences.UserID] = *preferences
+	return nil
+}
+
+func (r *preferencesRepo) DeletePreferences(userID int) error {
+	delete(r.saved, userID)
+	return nil
+}
+
+func newDiscoveryRouter() (*mux.Router, *preferencesRepo) {
+	preferences := &preferencesRepo{saved: map[int]models.DiscoveryPreferences{}}
+	handlers := NewDiscoveryHandlers(preferences, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/discovery/preferences", handlers.GetPreferences).Methods("GET")
+	router.HandleFunc("/discovery/preferences", handlers.UpdatePreferences).Methods("PUT")
+	router.HandleFunc("/discovery/preferences", handlers.DeletePreferences).Methods("DELETE")
+	return router, preferences
+}
+
+// servePreferences sends the request with the body and decodes the preferences or errors returned
+func servePreferences(t *testing.T, router http.Handler, method, body, token string) (int, models.DiscoveryPreferences, helpers.ValidationErrors) {
+	t.Helper()
+	request := httptest.NewRequest(method, "/discovery/preferences", strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	var response struct {
+		Data  models.DiscoveryPreferences `json:"data"`
+		Error helpers.ValidationErrors    `json:"error"`
+	}
+	if recorder.Code == http.StatusOK || recorder.Code == http.StatusBadRequest {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("invalid response %s: %v", recorder.Body, err)
+		}
+	}
+	return recorder.Code, response.Data, response.Error
+}
+
+func TestDiscoveryHandlers_SaveAndResetPreferences(t *testing.T) {
+	router, saved := newDiscoveryRouter()
+	token, _ := helpers.GenerateToken(models.User{UserID: 1})
+
+	if code, _, _ := servePreferences(t, router, "GET", "", "invalid"); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a valid token, got %d", code)
+	}
+
+	// Users who saved nothing get the full age range and no dealbreakers
+	code, preferences, _ := servePreferences(t, router, "GET", "", token)
+	if code != http.StatusOK || preferences.UserID != 1 || preferences.MinAge != models.MinDiscoveryAge ||
+		preferences.MaxAge != models.MaxDiscoveryAge || len(preferences.Genders) != 0 {
+		t.Fatalf("expected the default preferences, got %d and %+v", code, preferences)
+	}
+
+	body := `{"userID": 2, "minAge": 25, "maxAge": 35, "genders": ["Female"], "maxDistanceKm": 30,
+		"minHeight": 160, "maxHeight": 185, "relationshipGoals": ["Long-term partner"], "languages": ["English", "Hindi"]}`
+	if code, preferences, errs := servePreferences(t, router, "PUT", body, token); code != http.StatusOK || preferences.UserID != 1 {
+		t.Fatalf("expected the preferences saved for the caller, got %d, %+v and %v", code, preferences, errs)
+	}
+	stored, ok := saved.saved[1]
+	if !ok || stored.MinAge != 25 || stored.MaxAge != 35 || stored.MaxDistanceKm != 30 || stored.MaxHeight != 185 ||
+		len(stored.Languages) != 2 || !stored.Genders.Contains("Female") {
+		t.Fatalf("expected the preferences stored, got %+v", saved.saved)
+	}
+	if _, ok := saved.saved[2]; ok {
+		t.Fatal("expected the user ID of the payload ignored")
+	}
+
+	// Fields left out keep their defaults rather than becoming dealbreakers
+	if code, preferences, _ := servePreferences(t, router, "PUT", `{"genders": ["Male"]}`, token); code != http.StatusOK ||
+		preferences.MinAge != models.MinDiscoveryAge || preferences.MaxAge != models.MaxDiscoveryAge || preferences.MaxHeight != 0 {
+		t.Fatalf("expected the other preferences reset to the defaults, got %d and %+v", code, preferences)
+	}
+
+	code, preferences, _ = servePreferences(t, router, "DELETE", "", token)
+	if code != http.StatusOK || preferences.MinAge != models.MinDiscoveryAge || len(saved.saved) != 0 {
+		t.Fatalf("expected the preferences reset, got %d, %+v and %+v", code, preferences, saved.saved)
+	}
+}
+
+func TestDiscoveryHandlers_RejectsInvalidPreferences(t *testing.T) {
+	router, saved := newDiscoveryRouter()
+	token, _ := helpers.GenerateToken(models.User{UserID: 1})
+
+	cases := []struct {
+		body  string
+		field string
+	}{
+		{`{"minAge": 16, "maxAge": 30}`, "MinAge"},
+		{`{"minAge": 40, "maxAge": 30}`, "DiscoveryPreferences"},
+		{`{"minHeight": 190, "maxHeight": 170}`, "DiscoveryPreferences"},
+		{`{"maxDistanceKm": -5}`, "DiscoveryPreferences"},
+		{`{"genders": ["Robot"]}`, "Genders"},
+		{`{"relationshipGoals": ["Pen pals"]}`, "RelationshipGoals"},
+		{`{"languages": ["Klingon"]}`, "Languages"},
+	}
+	for _, c := range cases {
+		code, _, errs := servePreferences(t, router, "PUT", c.body, token)
+		if code != http.StatusBadRequest || len(errs) != 1 || errs[0].Field != c.field {
+			t.Errorf("%s: expected an error on %s, got %d and %v", c.body, c.field, code, errs)
+		}
+	}
+	if len(saved.saved) != 0 {
+		t.Errorf("expected nothing saved, got %+v", saved.saved)
+	}
+}
@@ -0,0 +1,124 @@
+// handlers/interest_handlers.go
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+)
+
+type InterestHandlers struct {
+	interestRepo         repository.InterestRepository
+	profileRepo          repository.ProfileRepository
+	publicProfileService *services.PublicProfileService
+	entitlementsService  *services.EntitlementsService
+	redisHelper          *helpers.RedisHelper
+}
+
+// NewInterestHandlers creates a new instance of InterestHandlers
+func NewInterestHandlers(interestRepo repository.InterestRepository, profileRepo repository.ProfileRepository, publicProfileService *services.PublicProfileService, entitlementsService *services.EntitlementsService, redisHelper *helpers.RedisHelper) *InterestHandlers {
+	return &InterestHandlers{
+		interestRepo:         interestRepo,
+		profileRepo:          profileRepo,
+		publicProfileService: publicProfileService,
+		entitlementsService:  entitlementsService,
+		redisHelper:          redisHelper,
+	}
+}
+
+// GetInterests lists the interest taxonomy profiles can pick from
+func (h *InterestHandlers) GetInterests(w http.ResponseWriter, r *http.Request) {
+	interests, err := h.interestRepo.GetActiveInterests()
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching interests", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Interests retrieved successfully", interests, nil))
+}
+
+// GetProfilesByInterest lists the public profiles of other users sharing the given interest, as
+// far as the caller may see them
+func (h *InterestHandlers) GetProfilesByInterest(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+	if !helpers.IsEnumValue(helpers.InterestEnum, slug) {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Interest not found", nil, ""))
+		return
+	}
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	entitlements, err := h.entitlementsService.Entitlements(int(userID))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching entitlements", nil, err.Error()))
+		return
+	}
+
+	page, pageSize := paginationParams(r)
+	profiles, err := h.profileRepo.GetProfilesByInterest(slug, int(userID), page, pageSize)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching profiles", nil, err.Error()))
+		return
+	}
+
+	// Only the public projection of each profile is returned, in the order of the page
+	userIDs := make([]int, 0, len(profiles))
+	for _, profile := range profiles {
+		userIDs = append(userIDs, profile.UserID)
+	}
+	projected, err := h.publicProfileService.GetPublicProfiles(int(userID), userIDs, services.ViewerTierFor(entitlements))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching profiles", nil, err.Error()))
+		return
+	}
+	publicProfiles := make([]*services.PublicProfile, 0, len(userIDs))
+	for _, id := range userIDs {
+		if profile, ok := projected[id]; ok {
+			publicProfiles = append(publicProfiles, profile)
+		}
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Profiles retrieved successfully", publicProfiles, nil))
+}
+
+// paginationParams reads the page and pageSize query parameters, defaulting to the first page of 20
+func paginationParams(r *http.Request) (int, int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if err != nil || pageSize < 1 {
+		pageSize = 20
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+
+	return page, pageSize
+}
@@ -0,0 +1,103 @@
+// handlers/inventory_handlers.go
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+)
+
+type InventoryHandlers struct {
+	inventoryService *services.InventoryService
+	redisHelper      *helpers.RedisHelper
+}
+
+// NewInventoryHandlers creates a new instance of InventoryHandlers
+func NewInventoryHandlers(inventoryService *services.InventoryService, redisHelper *helpers.RedisHelper) *InventoryHandlers {
+	return &InventoryHandlers{
+		inventoryService: inventoryService,
+		redisHelper:      redisHelper,
+	}
+}
+
+// GetInventory returns how many super likes, boosts and rewinds the caller has, and their boost
+func (h *InventoryHandlers) GetInventory(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	wallet, err := h.inventoryService.Wallet(int(userID))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching inventory", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Inventory fetched successfully", wallet, nil))
+}
+
+// GetPacks lists the packs of consumable items that can be purchased
+func (h *InventoryHandlers) GetPacks(w http.ResponseWriter, r *http.Request) {
+	packs, err := h.inventoryService.Packs()
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching packs", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Packs fetched successfully", packs, nil))
+}
+
+// StartBoost spends one of the caller's boosts to raise their visibility in discovery
+func (h *InventoryHandlers) StartBoost(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	boost, err := h.inventoryService.StartBoost(int(userID))
+	if errors.Is(err, services.ErrNoItemsLeft) {
+		helpers.SendJSONResponse(w, http.StatusForbidden, helpers.GenerateResponse(false, http.StatusForbidden, "No boosts left", nil, err.Error()))
+		return
+	}
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error starting boost", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusCreated, helpers.GenerateResponse(true, http.StatusCreated, "Boost started successfully", boost, nil))
+}
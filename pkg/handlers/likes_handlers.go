@@ -0,0 +1,90 @@
+// handlers/likes_handlers.go
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+)
+
+type LikesHandlers struct {
+	likesService *services.LikesService
+	redisHelper  *helpers.RedisHelper
+}
+
+// NewLikesHandlers creates a new instance of LikesHandlers
+func NewLikesHandlers(likesService *services.LikesService, redisHelper *helpers.RedisHelper) *LikesHandlers {
+	return &LikesHandlers{
+		likesService: likesService,
+		redisHelper:  redisHelper,
+	}
+}
+
+// GetLikesReceived returns a page of the users who liked the caller. Without a plan that includes
+// seeing who likes you, the caller only gets the count and blurred previews.
+func (h *LikesHandlers) GetLikesReceived(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	page, pageSize := paginationParams(r)
+	likes, err := h.likesService.LikesReceived(int(userID), page, pageSize)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching likes", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Likes fetched successfully", likes, nil))
+}
+
+// GetLikePreview serves the blurred photo preview of a like to holders of a valid signed link
+func (h *LikesHandlers) GetLikePreview(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid like ID", nil, err.Error()))
+		return
+	}
+
+	query := r.URL.Query()
+	err = helpers.VerifySignedURL(services.LikePreviewPath(id), query.Get(helpers.ExpiresParam), query.Get(helpers.SignatureParam))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusForbidden, helpers.GenerateResponse(false, http.StatusForbidden, "Invalid preview link", nil, err.Error()))
+		return
+	}
+
+	preview, err := h.likesService.BlurredPreview(id)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Preview not found", nil, err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(preview); err != nil {
+		log.Printf("Error writing like preview %d: %v", id, err)
+	}
+}
@@ -0,0 +1,193 @@
+// handlers/likes_handlers_test.go
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+	"gorm.io/gorm"
+)
+
+// likesSwipeRepo serves a fixed page of likes and the swipes behind them
+type likesSwipeRepo struct {
+	repository.SwipeHistoryRepository
+	likes  []repository.LikeReceived
+	swipes map[int]*models.SwipeHistory
+}
+
+func (r *likesSwipeRepo) GetLikesReceived(userID, page, pageSize int) ([]repository.LikeReceived, int64, error) {
+	return r.likes, int64(len(r.likes)), nil
+}
+
+func (r *likesSwipeRepo) GetSwipeByID(swipeID int) (*models.SwipeHistory, error) {
+	if swipe, ok := r.swipes[swipeID]; ok {
+		return swipe, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// freeSubscriptionRepo has no subscriptions, so every user is on the free plan
+type freeSubscriptionRepo struct {
+	repository.SubscriptionRepository
+}
+
+func (freeSubscriptionRepo) GetCurrentSubscription(userID int, now time.Time) (*models.Subscription, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+// likesPhotoRepo gives user 2 a single photo
+type likesPhotoRepo struct {
+	repository.PhotoRepository
+}
+
+func (likesPhotoRepo) GetPhotosByUserID(userID int) ([]models.Photo, error) {
+	if userID != 2 {
+		return nil, nil
+	}
+	return []models.Photo{{PhotoID: 1, UserID: 2, ThumbnailKey: "photos/2/1_thumb.jpg"}}, nil
+}
+
+// newLikesRouter routes the likes endpoints for free users who were liked by user 2 and passed
+// on by user 3
+func newLikesRouter(t *testing.T) *mux.Router {
+	t.Helper()
+	blobStore := helpers.NewFileSystemBlobStore(t.TempDir())
+	thumbnail := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 32; y++ {
+			thumbnail.SetRGBA(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, thumbnail, nil); err != nil {
+		t.Fatalf("encoding the thumbnail failed: %v", err)
+	}
+	if err := blobStore.Put("photos/2/1_thumb.jpg", &encoded, "image/jpeg"); err != nil {
+		t.Fatalf("storing the thumbnail failed: %v", err)
+	}
+
+	swipes := &likesSwipeRepo{
+		likes: []repository.LikeReceived{{LikeID: 21, UserID: 2, Super: true, LikedAt: time.Now()}},
+		swipes: map[int]*models.SwipeHistory{
+			21: {SwipeHistoryEntityID: 21, SwiperUserID: 2, SwipedUserID: 1, SwipeDirection: models.SwipeDirectionSuper},
+			22: {SwipeHistoryEntityID: 22, SwiperUserID: 3, SwipedUserID: 1, SwipeDirection: models.SwipeDirectionLeft},
+		},
+	}
+	redis := &mocks.MockRedisHandler{}
+	likesService := services.NewLikesService(
+		swipes,
+		nil,
+		services.NewPhotoService(likesPhotoRepo{}, blobStore, redis),
+		services.NewEntitlementsService(freeSubscriptionRepo{}, redis),
+	)
+	handlers := NewLikesHandlers(likesService, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/likes/received", handlers.GetLikesReceived).Methods("GET")
+	router.HandleFunc("/likes/previews/{id:[0-9]+}", handlers.GetLikePreview).Methods("GET")
+	return router
+}
+
+func serve(router http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestLikesHandlers_FreeUsersOnlyGetBlurredPreviews(t *testing.T) {
+	t.Setenv(helpers.URLSigningKeyEnv, "test-url-signing-key")
+	router := newLikesRouter(t)
+	token, err := helpers.GenerateToken(models.User{UserID: 1})
+	if err != nil {
+		t.Fatalf("generating a token failed: %v", err)
+	}
+
+	if response := serve(router, "GET", "/likes/received", ""); response.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", response.Code)
+	}
+
+	response := serve(router, "GET", "/likes/received", token)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	var body struct {
+		Data struct {
+			Total   int                      `json:"total"`
+			Blurred bool                     `json:"blurred"`
+			Likes   []map[string]interface{} `json:"likes"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if !body.Data.Blurred || body.Data.Total != 1 || len(body.Data.Likes) != 1 {
+		t.Fatalf("expected one blurred like, got %s", response.Body)
+	}
+	like := body.Data.Likes[0]
+	if _, ok := like["profile"]; ok {
+		t.Fatalf("expected no profile for a free user, got %v", like)
+	}
+	if _, ok := like["likedAt"]; ok {
+		t.Fatalf("expected no like time for a free user, got %v", like)
+	}
+	previewURL, _ := like["previewURL"].(string)
+	if previewURL == "" {
+		t.Fatalf("expected a preview link, got %v", like)
+	}
+
+	// The signed link serves the blurred preview without a token
+	response = serve(router, "GET", previewURL, "")
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("expected the preview, got %d: %s", response.Code, response.Body)
+	}
+	preview, err := jpeg.Decode(response.Body)
+	if err != nil {
+		t.Fatalf("expected a JPEG preview: %v", err)
+	}
+	if preview.Bounds().Dx() != 32 || preview.Bounds().Dy() != 32 {
+		t.Errorf("expected a 32x32 preview, got %v", preview.Bounds())
+	}
+
+	// A tampered or reused signature is refused
+	link, _ := url.Parse(previewURL)
+	query := link.Query()
+	if response := serve(router, "GET", "/likes/previews/22?"+query.Encode(), ""); response.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for another like's signature, got %d", response.Code)
+	}
+	signature := []byte(query.Get(helpers.SignatureParam))
+	if signature[0] == '0' {
+		signature[0] = '1'
+	} else {
+		signature[0] = '0'
+	}
+	query.Set(helpers.SignatureParam, string(signature))
+	if response := serve(router, "GET", link.Path+"?"+query.Encode(), ""); response.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a tampered signature, got %d", response.Code)
+	}
+
+	// A validly signed link to a pass serves nothing
+	if response := serve(router, "GET", helpers.SignURL(services.LikePreviewPath(22), time.Now().Add(time.Minute)), ""); response.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a pass, got %d", response.Code)
+	}
+	if response := serve(router, "GET", link.Path, ""); response.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without a signature, got %d", response.Code)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -13,20 +14,28 @@ import (
 	"github.com/metabbe3/knoxsdating/pkg/helpers"
 	"github.com/metabbe3/knoxsdating/pkg/models"
 	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+	"gorm.io/gorm"
 )
 
 type LocationHandlers struct {
-	locationRepo repository.LocationRepository
-	userRepo     repository.UserRepository
-	redisHelper  *helpers.RedisHelper
+	locationRepo        repository.LocationRepository
+	userRepo            repository.UserRepository
+	discoveryService    *services.DiscoveryService
+	entitlementsService *services.EntitlementsService
+	retentionPolicy     services.LocationRetentionPolicy
+	redisHelper         *helpers.RedisHelper
 }
 
 // NewLocationHandlers creates a new instance of LocationHandlers
-func NewLocationHandlers(locationRepo repository.LocationRepository, userRepo repository.UserRepository, redisHelper *helpers.RedisHelper) *LocationHandlers {
+func NewLocationHandlers(locationRepo repository.LocationRepository, userRepo repository.UserRepository, discoveryService *services.DiscoveryService, entitlementsService *services.EntitlementsService, retentionPolicy services.LocationRetentionPolicy, redisHelper *helpers.RedisHelper) *LocationHandlers {
 	return &LocationHandlers{
-		locationRepo: locationRepo,
-		userRepo:     userRepo,
-		redisHelper:  redisHelper,
+		locationRepo:        locationRepo,
+		userRepo:            userRepo,
+		discoveryService:    discoveryService,
+		entitlementsService: entitlementsService,
+		retentionPolicy:     retentionPolicy,
+		redisHelper:         redisHelper,
 	}
 }
 
@@ -65,15 +74,15 @@ func (h *LocationHandlers) CreateLocationHistory(w http.ResponseWriter, r *http.
 	// Set the UserID field of the location struct
 	location.UserID = int(userID)
 
-	// Fetch the user data from the user repository
-	user, err := h.userRepo.GetUserByID(int(userID))
+	// Fetch the user's entitlements
+	entitlements, err := h.entitlementsService.Entitlements(int(userID))
 	if err != nil {
-		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching user data", nil, err.Error()))
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching entitlements", nil, err.Error()))
 		return
 	}
 
-	// Check if the user is premium
-	isPremium := user.PremiumStatus == "Premium"
+	// Any paid plan may record several locations a day, including past ones
+	isPremium := entitlements.IsPaid()
 
 	// If the user is not premium, set the Timestamp to the current time
 	if !isPremium {
@@ -116,7 +125,8 @@ func (h *LocationHandlers) updateOrCreateLocationInRedis(userID float64, locatio
 	return err
 }
 
-// GetNearbyLocations handles the request to get nearby locations with pagination
+// GetNearbyLocations handles the request to get the next page of nearby locations the caller has not
+// been shown today
 func (h *LocationHandlers) GetNearbyLocations(w http.ResponseWriter, r *http.Request) {
 	// Validate JWT token
 	tokenString := r.Header.Get("Authorization")
@@ -142,7 +152,6 @@ func (h *LocationHandlers) GetNearbyLocations(w http.ResponseWriter, r *http.Req
 	// Decode JSON payload
 	var requestPayload struct {
 		MaxDistance float64 `json:"maxDistance"`
-		Page        int     `json:"page"`
 		PageSize    int     `json:"pageSize"`
 	}
 
@@ -152,40 +161,103 @@ func (h *LocationHandlers) GetNearbyLocations(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Fetch user's location from Redis
-	var userLocation models.LocationHistory
-	if err := h.redisHelper.Get("location:"+strconv.Itoa(int(userID)), &userLocation); err != nil {
-		// If not found in Redis, fetch from the database
-		locationHistory, err := h.locationRepo.GetLocationHistoryByUserID(int(userID))
-		if err != nil {
-			helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching user's location", nil, err.Error()))
-			return
-		}
+	// Fetch nearby locations matching both sides' discovery preferences
+	// The search starts from the caller's location, which they may not have reported yet
+	nearbyLocations, err := h.discoveryService.FindNearby(int(userID), requestPayload.MaxDistance, requestPayload.PageSize)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "User's location not found", nil, ""))
+		return
+	}
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching nearby locations", nil, err.Error()))
+		return
+	}
 
-		// Check if the user's location is available in the database
-		if len(locationHistory) == 0 {
-			helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "User's location not found", nil, ""))
-			return
-		}
+	// Send the response
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Nearby locations fetched successfully", nearbyLocations, nil))
+}
 
-		// Use the latest location from the database
-		userLocation = locationHistory[0]
+// LocationHistoryResponse is a page of the caller's stored locations and the policy they are kept under
+type LocationHistoryResponse struct {
+	Locations []models.LocationHistory         `json:"locations"`
+	Total     int64                            `json:"total"`
+	Page      int                              `json:"page"`
+	PageSize  int                              `json:"pageSize"`
+	Retention services.LocationRetentionPolicy `json:"retention"`
+}
 
-		// Store the user's location in Redis for future use
-		err = h.redisHelper.Set("location:"+strconv.Itoa(int(userID)), userLocation, time.Hour*24)
-		if err != nil {
-			log.Printf("Error storing user's location in Redis: %v", err)
-		}
+// GetLocationHistory handles the request to see which of the caller's locations are stored
+func (h *LocationHandlers) GetLocationHistory(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
 	}
 
-	// Fetch nearby locations
-	nearbyLocations, err := h.locationRepo.GetNearbyLocations(int(userID), requestPayload.MaxDistance, requestPayload.Page, requestPayload.PageSize)
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
 
+	page, pageSize := paginationParams(r)
+	locations, total, err := h.locationRepo.GetLocationHistoryPage(int(userID), page, pageSize)
 	if err != nil {
-		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching nearby locations", nil, err.Error()))
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching location history", nil, err.Error()))
 		return
 	}
 
-	// Send the response
-	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Nearby locations fetched successfully", nearbyLocations, nil))
+	response := LocationHistoryResponse{
+		Locations: locations,
+		Total:     total,
+		Page:      page,
+		PageSize:  pageSize,
+		Retention: h.retentionPolicy,
+	}
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Location history fetched successfully", response, nil))
+}
+
+// DeleteLocationHistory handles the request to erase every stored location of the caller
+func (h *LocationHandlers) DeleteLocationHistory(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	if err := h.locationRepo.DeleteLocationHistory(int(userID)); err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error deleting location history", nil, err.Error()))
+		return
+	}
+
+	// Drop the cached latest location as well
+	if err := h.redisHelper.Delete("location:" + strconv.Itoa(int(userID))); err != nil {
+		log.Printf("Error deleting location from Redis: %v", err)
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Location history deleted successfully", nil, nil))
 }
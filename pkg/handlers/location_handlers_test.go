@@ -0,0 +1,119 @@
+// handlers/location_handlers_test.go
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+	"gorm.io/gorm"
+)
+
+// discoveryUserRepo knows every user, on UTC
+type discoveryUserRepo struct {
+	repository.UserRepository
+}
+
+func (discoveryUserRepo) GetUserByID(userID int) (*models.User, error) {
+	return &models.User{UserID: userID}, nil
+}
+
+// noProfilesRepo has no profiles
+type noProfilesRepo struct {
+	repository.ProfileRepository
+}
+
+func (noProfilesRepo) GetProfileByUserID(userID int) (*models.Profile, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (noProfilesRepo) GetProfilesByUserIDs(userIDs []int) (map[int]*models.Profile, error) {
+	return map[int]*models.Profile{}, nil
+}
+
+// nearbyLocationRepo finds the same users near everyone who has reported a location
+type nearbyLocationRepo struct {
+	repository.LocationRepository
+	located map[int]bool
+	nearby  []repository.LocationWithDistance
+}
+
+func (r *nearbyLocationRepo) GetNearbyCandidates(userID int, filter repository.DiscoveryFilter, limit int) ([]repository.LocationWithDistance, error) {
+	if !r.located[userID] {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return r.nearby, nil
+}
+
+func (r *nearbyLocationRepo) LogShownProfiles(userID int, shownUserIDs []int, shownAt time.Time) error {
+	return nil
+}
+
+// quietSwipeRepo has no swipes
+type quietSwipeRepo struct {
+	repository.SwipeHistoryRepository
+}
+
+func (quietSwipeRepo) GetSwipeStats(userIDs []int) (map[int]repository.SwipeStats, error) {
+	return map[int]repository.SwipeStats{}, nil
+}
+
+func (quietSwipeRepo) GetLikersAmong(swipedUserID int, swiperUserIDs []int) (map[int]string, error) {
+	return map[int]string{}, nil
+}
+
+// noBoostsRepo has no boosts running
+type noBoostsRepo struct {
+	repository.InventoryRepository
+}
+
+func (noBoostsRepo) GetBoostedAmong(userIDs []int, now time.Time) (map[int]bool, error) {
+	return map[int]bool{}, nil
+}
+
+func TestLocationHandlers_GetNearbyLocationsSearchesFromTheStoredLocation(t *testing.T) {
+	locations := &nearbyLocationRepo{
+		located: map[int]bool{1: true},
+		nearby:  []repository.LocationWithDistance{{UserID: 3, Distance: 2, Timestamp: time.Now()}},
+	}
+	preferences := &preferencesRepo{saved: map[int]models.DiscoveryPreferences{}}
+	discovery := services.NewDiscoveryService(discoveryUserRepo{}, noProfilesRepo{}, preferences, locations, quietSwipeRepo{}, noBoostsRepo{}, services.DefaultRankingWeights())
+	// No Redis: the caller's location is read by the search itself
+	handlers := NewLocationHandlers(locations, discoveryUserRepo{}, discovery, nil, services.DefaultLocationRetentionPolicy(), nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/locations/nearby", handlers.GetNearbyLocations).Methods("POST")
+
+	nearby := func(userID int) *httptest.ResponseRecorder {
+		token, _ := helpers.GenerateToken(models.User{UserID: userID})
+		request := httptest.NewRequest("POST", "/locations/nearby", bytes.NewBufferString(`{"maxDistance": 10, "pageSize": 5}`))
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := nearby(1)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	var body struct {
+		Data []repository.LocationWithDistance `json:"data"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil || len(body.Data) != 1 || body.Data[0].UserID != 3 {
+		t.Errorf("expected user 3 nearby, got %s (%v)", response.Body, err)
+	}
+
+	// Users who never reported a location have nowhere to search from
+	if response := nearby(2); response.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without a location, got %d: %s", response.Code, response.Body)
+	}
+}
@@ -0,0 +1,80 @@
+// handlers/message_handlers.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+)
+
+type MessageHandlers struct {
+	messageService *services.MessageService
+	redisHelper    *helpers.RedisHelper
+}
+
+// sendMessageRequest is the body of POST /messages
+type sendMessageRequest struct {
+	ReceiverUserID int    `json:"receiverUserID"`
+	MessageContent string `json:"messageContent"`
+}
+
+// NewMessageHandlers creates a new instance of MessageHandlers
+func NewMessageHandlers(messageService *services.MessageService, redisHelper *helpers.RedisHelper) *MessageHandlers {
+	return &MessageHandlers{
+		messageService: messageService,
+		redisHelper:    redisHelper,
+	}
+}
+
+// SendMessage sends a direct message from the caller to a user they matched with
+func (h *MessageHandlers) SendMessage(w http.ResponseWriter, r *http.Request) {
+	var request sendMessageRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&request); err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	message, err := h.messageService.SendMessage(int(userID), request.ReceiverUserID, request.MessageContent)
+	if errors.Is(err, services.ErrInvalidMessage) {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid message", nil, err.Error()))
+		return
+	}
+	if errors.Is(err, services.ErrCannotMessage) {
+		helpers.SendJSONResponse(w, http.StatusForbidden, helpers.GenerateResponse(false, http.StatusForbidden, "Cannot message this user", nil, err.Error()))
+		return
+	}
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error sending message", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusCreated, helpers.GenerateResponse(true, http.StatusCreated, "Message sent successfully", message, nil))
+}
@@ -1,34 +1,46 @@
-// Import the required packages
+// handlers/notification_handlers.go
 package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
 	"github.com/metabbe3/knoxsdating/pkg/helpers"
 	"github.com/metabbe3/knoxsdating/pkg/models"
 	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
 )
 
 type NotificationHandlers struct {
-	notificationRepo repository.NotificationRepository
-	redisHelper      *helpers.RedisHelper
+	notificationRepo    repository.NotificationRepository
+	notificationService *services.NotificationService
+	redisHelper         *helpers.RedisHelper
 }
 
-func NewNotificationHandlers(repo repository.NotificationRepository, redisHelper *helpers.RedisHelper) *NotificationHandlers {
+func NewNotificationHandlers(repo repository.NotificationRepository, notificationService *services.NotificationService, redisHelper *helpers.RedisHelper) *NotificationHandlers {
 	return &NotificationHandlers{
-		notificationRepo: repo,
-		redisHelper:      redisHelper,
+		notificationRepo:    repo,
+		notificationService: notificationService,
+		redisHelper:         redisHelper,
 	}
 }
 
+// CreateNotification adds a notification to any user's inbox. Notifications are created by the
+// server in reaction to domain events, so only admins may create them by hand.
 func (h *NotificationHandlers) CreateNotification(w http.ResponseWriter, r *http.Request) {
 	var notification models.Notification
 
+	if !helpers.IsAdminRequest(r) {
+		helpers.SendJSONResponse(w, http.StatusForbidden, helpers.GenerateResponse(false, http.StatusForbidden, "Only admins can create notifications", nil, ""))
+		return
+	}
+
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&notification); err != nil {
 		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
@@ -37,30 +49,186 @@ func (h *NotificationHandlers) CreateNotification(w http.ResponseWriter, r *http
 
 	defer r.Body.Close()
 
+	if notification.UserID <= 0 || notification.NotificationType == "" {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, "userID and notificationType are required"))
+		return
+	}
+
+	notification.NotificationID = 0
+	notification.IsRead = false
+	notification.Timestamp = time.Now()
+
+	err := h.notificationRepo.CreateNotification(&notification)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error creating notification", nil, err.Error()))
+		log.Printf("Error creating notification: %v", err)
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusCreated, helpers.GenerateResponse(true, http.StatusCreated, "Notification created successfully", notification, nil))
+}
+
+// GetNotifications returns a page of the caller's notifications, newest first. The cursor query
+// parameter takes the nextCursor of the previous page, limit sets the page size and unread=true
+// leaves out notifications already read.
+func (h *NotificationHandlers) GetNotifications(w http.ResponseWriter, r *http.Request) {
 	// Validate JWT token
 	tokenString := r.Header.Get("Authorization")
-	log.Print(tokenString)
-	_, err := helpers.ValidateToken(tokenString)
+	token, err := helpers.ValidateToken(tokenString)
 	if err != nil {
 		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
 		return
 	}
 
-	err = h.notificationRepo.CreateNotification(&notification)
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	query := r.URL.Query()
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid limit", nil, "limit must be a positive number"))
+			return
+		}
+	}
+	unreadOnly := query.Get("unread") == "true"
+
+	page, err := h.notificationService.Inbox(int(userID), query.Get("cursor"), unreadOnly, limit)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid cursor", nil, err.Error()))
+		return
+	}
 	if err != nil {
-		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error creating notification", nil, err.Error()))
-		log.Printf("Error creating notification: %v", err)
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching notifications", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Notifications retrieved successfully", page, nil))
+}
+
+// GetUnreadCount returns how many unread notifications the caller has
+func (h *NotificationHandlers) GetUnreadCount(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
 		return
 	}
 
-	// Cache notification data in Redis
-	err = h.redisHelper.Set("notification:"+strconv.Itoa(notification.NotificationID), notification, time.Hour*24)
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	count, err := h.notificationService.UnreadCount(int(userID))
 	if err != nil {
-		log.Printf("Error caching notification data in Redis: %v", err)
-		// Handle error, e.g., log it
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error counting notifications", nil, err.Error()))
+		return
 	}
 
-	helpers.SendJSONResponse(w, http.StatusCreated, helpers.GenerateResponse(true, http.StatusCreated, "Notification created successfully", notification, nil))
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Unread count retrieved successfully", map[string]interface{}{
+		"unreadCount": count,
+	}, nil))
+}
+
+// MarkRead marks the caller's notifications listed in ids as read
+func (h *NotificationHandlers) MarkRead(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		IDs []int `json:"ids"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestPayload); err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	marked, err := h.notificationService.MarkRead(int(userID), requestPayload.IDs)
+	if errors.Is(err, services.ErrTooManyNotificationIDs) {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Too many notifications", nil, err.Error()))
+		return
+	}
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error marking notifications as read", nil, err.Error()))
+		return
+	}
+
+	h.sendReadResult(w, int(userID), marked)
+}
+
+// MarkAllRead marks all of the caller's notifications as read
+func (h *NotificationHandlers) MarkAllRead(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	marked, err := h.notificationService.MarkAllRead(int(userID))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error marking notifications as read", nil, err.Error()))
+		return
+	}
+
+	h.sendReadResult(w, int(userID), marked)
 }
 
 func (h *NotificationHandlers) GetNotificationByID(w http.ResponseWriter, r *http.Request) {
@@ -73,40 +241,41 @@ func (h *NotificationHandlers) GetNotificationByID(w http.ResponseWriter, r *htt
 
 	// Validate JWT token
 	tokenString := r.Header.Get("Authorization")
-	log.Print(tokenString)
-	_, err = helpers.ValidateToken(tokenString)
+	token, err := helpers.ValidateToken(tokenString)
 	if err != nil {
 		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
 		return
 	}
 
-	// Try to get notification data from Redis first
-	var cachedNotification models.Notification
-	err = h.redisHelper.Get("notification:"+strconv.Itoa(id), &cachedNotification)
-	if err == nil {
-		// Use cached notification data if available
-		helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Notification retrieved successfully", cachedNotification, nil))
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
 		return
 	}
 
-	// If not found in Redis, fetch from the database
-	notification, err := h.notificationRepo.GetNotificationByID(id)
-	if err != nil {
-		log.Printf("Error fetching notification: %v", err)
-		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching notification", nil, err.Error()))
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
 		return
 	}
 
-	// Cache fetched notification data in Redis
-	err = h.redisHelper.Set("notification:"+strconv.Itoa(id), notification, time.Hour*24)
+	notification, err := h.notificationService.Get(int(userID), id)
+	if errors.Is(err, services.ErrNotificationNotFound) {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Notification not found", nil, err.Error()))
+		return
+	}
 	if err != nil {
-		log.Printf("Error caching notification data in Redis: %v", err)
-		// Handle error, e.g., log it
+		log.Printf("Error fetching notification: %v", err)
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching notification", nil, err.Error()))
+		return
 	}
 
 	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Notification retrieved successfully", notification, nil))
 }
 
+// UpdateNotification marks one of the caller's notifications as read or unread; nothing else about
+// a notification can be changed
 func (h *NotificationHandlers) UpdateNotification(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
@@ -117,36 +286,51 @@ func (h *NotificationHandlers) UpdateNotification(w http.ResponseWriter, r *http
 
 	// Validate JWT token
 	tokenString := r.Header.Get("Authorization")
-	log.Print(tokenString)
-	_, err = helpers.ValidateToken(tokenString)
+	token, err := helpers.ValidateToken(tokenString)
 	if err != nil {
 		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
 		return
 	}
 
-	var notification models.Notification
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	var requestPayload struct {
+		IsRead *bool `json:"IsRead"`
+	}
 
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&notification); err != nil {
+	if err := decoder.Decode(&requestPayload); err != nil {
 		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
 		return
 	}
 
 	defer r.Body.Close()
 
-	notification.NotificationID = id
-	err = h.notificationRepo.UpdateNotification(&notification)
-	if err != nil {
-		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error updating notification", nil, err.Error()))
-		log.Printf("Error updating notification: %v", err)
+	if requestPayload.IsRead == nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, "IsRead is required"))
 		return
 	}
 
-	// Update cached notification data in Redis
-	err = h.redisHelper.Set("notification:"+strconv.Itoa(id), notification, time.Hour*24)
+	notification, err := h.notificationService.SetRead(int(userID), id, *requestPayload.IsRead)
+	if errors.Is(err, services.ErrNotificationNotFound) {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Notification not found", nil, err.Error()))
+		return
+	}
 	if err != nil {
-		log.Printf("Error updating cached notification data in Redis: %v", err)
-		// Handle error, e.g., log it
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error updating notification", nil, err.Error()))
+		log.Printf("Error updating notification: %v", err)
+		return
 	}
 
 	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Notification updated successfully", notification, nil))
@@ -162,30 +346,30 @@ func (h *NotificationHandlers) DeleteNotification(w http.ResponseWriter, r *http
 
 	// Validate JWT token
 	tokenString := r.Header.Get("Authorization")
-	log.Print(tokenString)
-	_, err = helpers.ValidateToken(tokenString)
+	token, err := helpers.ValidateToken(tokenString)
 	if err != nil {
 		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
 		return
 	}
 
-	// Get notification data from Redis before deletion
-	var cachedNotification models.Notification
-	err = h.redisHelper.Get("notification:"+strconv.Itoa(id), &cachedNotification)
-	if err != nil {
-		log.Printf("Error fetching cached notification data from Redis: %v", err)
-		// Handle error, e.g., log it
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
 	}
 
-	// Delete notification data from Redis
-	err = h.redisHelper.Delete("notification:" + strconv.Itoa(id))
-	if err != nil {
-		log.Printf("Error deleting cached notification data in Redis: %v", err)
-		// Handle error, e.g., log it
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
 	}
 
-	// Delete notification from the database
-	err = h.notificationRepo.DeleteNotification(&cachedNotification)
+	err = h.notificationService.Delete(int(userID), id)
+	if errors.Is(err, services.ErrNotificationNotFound) {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Notification not found", nil, err.Error()))
+		return
+	}
 	if err != nil {
 		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error deleting notification", nil, err.Error()))
 		log.Printf("Error deleting notification: %v", err)
@@ -194,3 +378,17 @@ func (h *NotificationHandlers) DeleteNotification(w http.ResponseWriter, r *http
 
 	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Notification deleted successfully", nil, nil))
 }
+
+// sendReadResult reports how many notifications were marked as read and how many are left unread
+func (h *NotificationHandlers) sendReadResult(w http.ResponseWriter, userID int, marked int64) {
+	unread, err := h.notificationService.UnreadCount(userID)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error counting notifications", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Notifications marked as read", map[string]interface{}{
+		"marked":      marked,
+		"unreadCount": unread,
+	}, nil))
+}
@@ -0,0 +1,154 @@
+// handlers/notification_preferences_handlers.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+type NotificationPreferencesHandlers struct {
+	preferencesRepo repository.NotificationPreferencesRepository
+	redisHelper     *helpers.RedisHelper
+}
+
+// NewNotificationPreferencesHandlers creates a new instance of NotificationPreferencesHandlers
+func NewNotificationPreferencesHandlers(preferencesRepo repository.NotificationPreferencesRepository, redisHelper *helpers.RedisHelper) *NotificationPreferencesHandlers {
+	return &NotificationPreferencesHandlers{
+		preferencesRepo: preferencesRepo,
+		redisHelper:     redisHelper,
+	}
+}
+
+// GetPreferences returns the caller's notification preferences, or the defaults if none are saved
+func (h *NotificationPreferencesHandlers) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	preferences, err := h.preferencesRepo.GetPreferences(int(userID))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching notification preferences", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Notification preferences fetched successfully", preferences, nil))
+}
+
+// UpdatePreferences creates or replaces the caller's notification preferences
+func (h *NotificationPreferencesHandlers) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	preferences := models.DefaultNotificationPreferences(int(userID))
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(preferences); err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	preferences.UserID = int(userID)
+
+	if errs := validateNotificationPreferences(preferences); len(errs) > 0 {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid notification preferences", nil, errs))
+		return
+	}
+
+	if err := h.preferencesRepo.SavePreferences(preferences); err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error saving notification preferences", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Notification preferences saved successfully", preferences, nil))
+}
+
+// DeletePreferences resets the caller's notification preferences to the defaults
+func (h *NotificationPreferencesHandlers) DeletePreferences(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	if err := h.preferencesRepo.DeletePreferences(int(userID)); err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error resetting notification preferences", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Notification preferences reset successfully", models.DefaultNotificationPreferences(int(userID)), nil))
+}
+
+// validateNotificationPreferences combines the tag rules with the checks on the muted channels and
+// quiet hours
+func validateNotificationPreferences(preferences *models.NotificationPreferences) helpers.ValidationErrors {
+	errs := helpers.ValidateStruct(preferences)
+	for notificationType, channels := range preferences.Muted {
+		if notificationType == "" || len(notificationType) > 50 {
+			errs = append(errs, helpers.FieldError{Field: "Muted", Message: "notification types must be 1 to 50 characters"})
+		}
+		for _, channel := range channels {
+			if !helpers.IsEnumValue(helpers.DeliveryChannelEnum, channel) {
+				errs = append(errs, helpers.FieldError{Field: "Muted", Message: channel + " is not a valid deliveryChannel"})
+			}
+		}
+	}
+	if (preferences.QuietHoursStart == "") != (preferences.QuietHoursEnd == "") {
+		errs = append(errs, helpers.FieldError{Field: "QuietHours", Message: "quietHoursStart and quietHoursEnd must be set together"})
+	}
+	return errs
+}
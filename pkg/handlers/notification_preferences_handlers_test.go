@@ -0,0 +1,28 @@
+// handlers/notification_preferences_handlers_test.go
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+)
+
+func TestValidateNotificationPreferences_OnlyAcceptsKnownChannels(t *testing.T) {
+	preferences := models.DefaultNotificationPreferences(1)
+	preferences.Muted = models.NotificationMutes{
+		"Matched": {models.DeliveryChannelInApp, models.DeliveryChannelPush},
+		"*":       {models.DeliveryChannelPush},
+		// Email is not sent yet, but its mutes are kept for when it is
+		"New Message": {models.DeliveryChannelEmail},
+	}
+	if errs := validateNotificationPreferences(preferences); len(errs) != 0 {
+		t.Fatalf("expected the in-app, push and email channels accepted, got %v", errs)
+	}
+
+	preferences.Muted["New Message"] = []string{"sms"}
+	errs := validateNotificationPreferences(preferences)
+	if len(errs) != 1 || errs[0].Field != "Muted" || !strings.Contains(errs[0].Message, "sms") {
+		t.Fatalf("expected the unknown channel rejected, got %v", errs)
+	}
+}
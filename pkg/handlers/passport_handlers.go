@@ -0,0 +1,204 @@
+// handlers/passport_handlers.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultPassportDuration is how long a passport lasts when no expiry is given
+	DefaultPassportDuration = 7 * 24 * time.Hour
+	// MaxPassportDuration is the longest a passport can be set for at once
+	MaxPassportDuration = 30 * 24 * time.Hour
+)
+
+type PassportHandlers struct {
+	passportRepo        repository.PassportRepository
+	entitlementsService *services.EntitlementsService
+	redisHelper         *helpers.RedisHelper
+}
+
+// NewPassportHandlers creates a new instance of PassportHandlers
+func NewPassportHandlers(passportRepo repository.PassportRepository, entitlementsService *services.EntitlementsService, redisHelper *helpers.RedisHelper) *PassportHandlers {
+	return &PassportHandlers{
+		passportRepo:        passportRepo,
+		entitlementsService: entitlementsService,
+		redisHelper:         redisHelper,
+	}
+}
+
+// SetPassport moves a premium user's discovery location to a city or coordinates until it expires
+func (h *PassportHandlers) SetPassport(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		City      string     `json:"city"`
+		Latitude  *float64   `json:"latitude"`
+		Longitude *float64   `json:"longitude"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestPayload); err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	entitlements, err := h.entitlementsService.Entitlements(int(userID))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching entitlements", nil, err.Error()))
+		return
+	}
+
+	// Passport is a premium feature
+	if !entitlements.Passport {
+		helpers.SendJSONResponse(w, http.StatusForbidden, helpers.GenerateResponse(false, http.StatusForbidden, "Passport is not included in your plan", nil, ""))
+		return
+	}
+
+	passport := models.Passport{UserID: int(userID), IsFuzzed: true}
+	switch {
+	case requestPayload.City != "":
+		city, ok := helpers.LookupCity(requestPayload.City)
+		if !ok {
+			helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Unknown city", nil, "pick a city from GET /passport/cities or send coordinates"))
+			return
+		}
+		passport.PlaceName = city.Name
+		passport.Latitude = city.Latitude
+		passport.Longitude = city.Longitude
+	case requestPayload.Latitude != nil && requestPayload.Longitude != nil:
+		if *requestPayload.Latitude < -90 || *requestPayload.Latitude > 90 || *requestPayload.Longitude < -180 || *requestPayload.Longitude > 180 {
+			helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid coordinates", nil, ""))
+			return
+		}
+		passport.Latitude, passport.Longitude = helpers.FuzzLocation(int(userID), *requestPayload.Latitude, *requestPayload.Longitude)
+	default:
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Either city or latitude and longitude are required", nil, ""))
+		return
+	}
+
+	now := time.Now()
+	passport.ExpiresAt = now.Add(DefaultPassportDuration)
+	if requestPayload.ExpiresAt != nil {
+		if !requestPayload.ExpiresAt.After(now) || requestPayload.ExpiresAt.Sub(now) > MaxPassportDuration {
+			helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "expiresAt must be in the next 30 days", nil, ""))
+			return
+		}
+		passport.ExpiresAt = *requestPayload.ExpiresAt
+	}
+
+	// The passport ends with the subscription
+	if entitlements.ExpiresAt != nil && entitlements.ExpiresAt.Before(passport.ExpiresAt) {
+		passport.ExpiresAt = *entitlements.ExpiresAt
+	}
+
+	if err := h.passportRepo.SetPassport(&passport); err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error setting passport", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Passport set successfully", passport, nil))
+}
+
+// GetPassport returns the caller's active passport
+func (h *PassportHandlers) GetPassport(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	passport, err := h.passportRepo.GetActivePassport(int(userID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "No active passport", nil, ""))
+		return
+	}
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching passport", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Passport fetched successfully", passport, nil))
+}
+
+// DeletePassport returns the caller to their real location
+func (h *PassportHandlers) DeletePassport(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	if err := h.passportRepo.DeletePassport(int(userID)); err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error removing passport", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Passport removed successfully", nil, nil))
+}
+
+// GetPassportCities lists the places a passport can be set to by name
+func (h *PassportHandlers) GetPassportCities(w http.ResponseWriter, r *http.Request) {
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Passport cities fetched successfully", helpers.PassportCities, nil))
+}
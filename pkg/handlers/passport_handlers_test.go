@@ -0,0 +1,169 @@
+// handlers/passport_handlers_test.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+	"gorm.io/gorm"
+)
+
+// premiumSubscriptionRepo puts user 2 on a plan with Passport until periodEnd; everyone else is free
+type premiumSubscriptionRepo struct {
+	repository.SubscriptionRepository
+	periodEnd time.Time
+}
+
+func (r premiumSubscriptionRepo) GetCurrentSubscription(userID int, now time.Time) (*models.Subscription, error) {
+	if userID != 2 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &models.Subscription{
+		UserID: 2, PlanID: 1, Status: models.SubscriptionStatusActive, CurrentPeriodEnd: r.periodEnd,
+		Plan: models.Plan{PlanID: 1, Code: "plus", Name: "Plus", UnlimitedLikes: true, Passport: true, IsActive: true},
+	}, nil
+}
+
+// memoryPassportRepo keeps passports by user ID
+type memoryPassportRepo struct {
+	repository.PassportRepository
+	passports map[int]models.Passport
+}
+
+func (r *memoryPassportRepo) SetPassport(passport *models.Passport) error {
+	r.passports[passport.UserID] = *passport
+	return nil
+}
+
+func (r *memoryPassportRepo) GetActivePassport(userID int) (*models.Passport, error) {
+	passport, ok := r.passports[userID]
+	if !ok || !passport.IsActive(time.Now()) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &passport, nil
+}
+
+func (r *memoryPassportRepo) DeletePassport(userID int) error {
+	delete(r.passports, userID)
+	return nil
+}
+
+func newPassportRouter(periodEnd time.Time) (*mux.Router, *memoryPassportRepo) {
+	passports := &memoryPassportRepo{passports: map[int]models.Passport{}}
+	entitlements := services.NewEntitlementsService(premiumSubscriptionRepo{periodEnd: periodEnd}, &mocks.MockRedisHandler{})
+	handlers := NewPassportHandlers(passports, entitlements, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/passport", handlers.SetPassport).Methods("PUT")
+	router.HandleFunc("/passport", handlers.GetPassport).Methods("GET")
+	router.HandleFunc("/passport", handlers.DeletePassport).Methods("DELETE")
+	return router, passports
+}
+
+func setPassport(router http.Handler, body, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("PUT", "/passport", strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestPassportHandlers_OnlyPremiumUsersTravel(t *testing.T) {
+	router, passports := newPassportRouter(time.Now().AddDate(0, 1, 0))
+	free, _ := helpers.GenerateToken(models.User{UserID: 1})
+
+	if recorder := setPassport(router, `{"city": "Bangkok"}`, free); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected free users refused, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if len(passports.passports) != 0 {
+		t.Fatalf("expected no passport set, got %+v", passports.passports)
+	}
+	if recorder := serve(router, "GET", "/passport", free); recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected no active passport, got %d", recorder.Code)
+	}
+}
+
+func TestPassportHandlers_SetsACityOrFuzzedCoordinates(t *testing.T) {
+	router, passports := newPassportRouter(time.Now().AddDate(0, 1, 0))
+	premium, _ := helpers.GenerateToken(models.User{UserID: 2})
+
+	// A city is placed on its centre and lasts the default duration
+	if recorder := setPassport(router, `{"city": " bangkok "}`, premium); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the passport set, got %d: %s", recorder.Code, recorder.Body)
+	}
+	passport := passports.passports[2]
+	if passport.PlaceName != "Bangkok" || passport.Latitude != 13.7563 || passport.Longitude != 100.5018 || !passport.IsFuzzed {
+		t.Errorf("expected the passport on Bangkok's centre, got %+v", passport)
+	}
+	if left := time.Until(passport.ExpiresAt); left <= DefaultPassportDuration-time.Minute || left > DefaultPassportDuration {
+		t.Errorf("expected the passport to last %v, got %v", DefaultPassportDuration, left)
+	}
+
+	recorder := serve(router, "GET", "/passport", premium)
+	var response struct {
+		Data models.Passport `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusOK || response.Data.PlaceName != "Bangkok" {
+		t.Fatalf("expected the active passport, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	// Coordinates are fuzzed like real locations before they are stored
+	if recorder := setPassport(router, `{"latitude": 48.8584, "longitude": 2.2945}`, premium); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the passport moved, got %d: %s", recorder.Code, recorder.Body)
+	}
+	latitude, longitude := helpers.FuzzLocation(2, 48.8584, 2.2945)
+	passport = passports.passports[2]
+	if passport.PlaceName != "" || passport.Latitude != latitude || passport.Longitude != longitude || !passport.IsFuzzed {
+		t.Errorf("expected the passport on the fuzzed coordinates, got %+v", passport)
+	}
+
+	if recorder := serve(router, "DELETE", "/passport", premium); recorder.Code != http.StatusOK || len(passports.passports) != 0 {
+		t.Fatalf("expected the passport removed, got %d and %+v", recorder.Code, passports.passports)
+	}
+}
+
+func TestPassportHandlers_RejectsInvalidPlaces(t *testing.T) {
+	router, passports := newPassportRouter(time.Now().AddDate(0, 1, 0))
+	premium, _ := helpers.GenerateToken(models.User{UserID: 2})
+
+	for _, body := range []string{
+		`{"city": "Atlantis"}`,
+		`{"latitude": 91, "longitude": 0}`,
+		`{"latitude": 0, "longitude": -181}`,
+		`{"latitude": 10}`,
+		`{}`,
+		`{"city": "Bangkok", "expiresAt": "` + time.Now().Add(-time.Hour).Format(time.RFC3339) + `"}`,
+		`{"city": "Bangkok", "expiresAt": "` + time.Now().Add(MaxPassportDuration+time.Hour).Format(time.RFC3339) + `"}`,
+	} {
+		if recorder := setPassport(router, body, premium); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, recorder.Code)
+		}
+	}
+	if len(passports.passports) != 0 {
+		t.Errorf("expected no passport set, got %+v", passports.passports)
+	}
+}
+
+func TestPassportHandlers_PassportEndsWithTheSubscription(t *testing.T) {
+	periodEnd := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	router, passports := newPassportRouter(periodEnd)
+	premium, _ := helpers.GenerateToken(models.User{UserID: 2})
+
+	expiresAt := time.Now().Add(10 * 24 * time.Hour).Format(time.RFC3339)
+	if recorder := setPassport(router, `{"city": "Bali", "expiresAt": "`+expiresAt+`"}`, premium); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the passport set, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if passport := passports.passports[2]; !passport.ExpiresAt.Equal(periodEnd) {
+		t.Errorf("expected the passport to end with the subscription at %v, got %v", periodEnd, passport.ExpiresAt)
+	}
+}
@@ -0,0 +1,275 @@
+// handlers/photo_handlers.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+)
+
+type PhotoHandlers struct {
+	photoRepo            repository.PhotoRepository
+	photoService         *services.PhotoService
+	publicProfileService *services.PublicProfileService
+	redisHelper          *helpers.RedisHelper
+}
+
+// NewPhotoHandlers creates a new instance of PhotoHandlers
+func NewPhotoHandlers(photoRepo repository.PhotoRepository, photoService *services.PhotoService, publicProfileService *services.PublicProfileService, redisHelper *helpers.RedisHelper) *PhotoHandlers {
+	return &PhotoHandlers{
+		photoRepo:            photoRepo,
+		photoService:         photoService,
+		publicProfileService: publicProfileService,
+		redisHelper:          redisHelper,
+	}
+}
+
+// UploadPhoto handles a multipart upload with the image in the "photo" field
+func (h *PhotoHandlers) UploadPhoto(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	// Allow some room above the photo limit for the multipart envelope
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxPhotoUploadSize+1<<20)
+	file, _, err := r.FormFile("photo")
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid photo upload", nil, err.Error()))
+		return
+	}
+	defer file.Close()
+
+	photo, err := h.photoService.UploadPhoto(int(userID), file)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrPhotoTooLarge):
+			helpers.SendJSONResponse(w, http.StatusRequestEntityTooLarge, helpers.GenerateResponse(false, http.StatusRequestEntityTooLarge, "Photo is too large", nil, err.Error()))
+		case errors.Is(err, services.ErrUnsupportedPhotoType), errors.Is(err, services.ErrPhotoDimensions):
+			helpers.SendJSONResponse(w, http.StatusUnsupportedMediaType, helpers.GenerateResponse(false, http.StatusUnsupportedMediaType, "Unsupported photo", nil, err.Error()))
+		case errors.Is(err, repository.ErrPhotoLimitReached):
+			helpers.SendJSONResponse(w, http.StatusConflict, helpers.GenerateResponse(false, http.StatusConflict, "Photo limit reached", nil, err.Error()))
+		default:
+			helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error uploading photo", nil, err.Error()))
+		}
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusCreated, helpers.GenerateResponse(true, http.StatusCreated, "Photo uploaded successfully", photo, nil))
+}
+
+// GetPhotos lists the caller's photos in display order
+func (h *PhotoHandlers) GetPhotos(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	photos, err := h.photoRepo.GetPhotosByUserID(int(userID))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching photos", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Photos retrieved successfully", photos, nil))
+}
+
+// ReorderPhotos sets the display order of the caller's photos
+func (h *PhotoHandlers) ReorderPhotos(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		PhotoIDs []int `json:"photoIDs"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestPayload); err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	photos, err := h.photoService.ReorderPhotos(int(userID), requestPayload.PhotoIDs)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Error reordering photos", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Photos reordered successfully", photos, nil))
+}
+
+// DeletePhoto removes one of the caller's photos
+func (h *PhotoHandlers) DeletePhoto(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid photo ID", nil, err.Error()))
+		return
+	}
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	err = h.photoService.DeletePhoto(int(userID), id)
+	if errors.Is(err, repository.ErrMinimumPhotos) {
+		helpers.SendJSONResponse(w, http.StatusConflict, helpers.GenerateResponse(false, http.StatusConflict, "Cannot delete the last photo", nil, err.Error()))
+		return
+	}
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Error deleting photo", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Photo deleted successfully", nil, nil))
+}
+
+// GetPhoto streams a stored photo
+func (h *PhotoHandlers) GetPhoto(w http.ResponseWriter, r *http.Request) {
+	h.servePhoto(w, r, false)
+}
+
+// GetPhotoThumbnail streams the thumbnail of a stored photo
+func (h *PhotoHandlers) GetPhotoThumbnail(w http.ResponseWriter, r *http.Request) {
+	h.servePhoto(w, r, true)
+}
+
+func (h *PhotoHandlers) servePhoto(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid photo ID", nil, err.Error()))
+		return
+	}
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	photo, err := h.photoRepo.GetPhotoByID(id)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Photo not found", nil, err.Error()))
+		return
+	}
+
+	// Only the owner and viewers allowed to see the owner's profile get the photo. Hidden photos
+	// are reported as missing, like hidden profiles.
+	visible, err := h.publicProfileService.CanView(int(userID), photo.UserID)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching photo", nil, err.Error()))
+		return
+	}
+	if !visible {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Photo not found", nil, ""))
+		return
+	}
+
+	body, err := h.photoService.OpenPhoto(photo, thumbnail)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Photo not found", nil, err.Error()))
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", photo.ContentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("Error streaming photo %d: %v", photo.PhotoID, err)
+	}
+}
@@ -3,27 +3,37 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
 	"github.com/metabbe3/knoxsdating/pkg/helpers"
 	"github.com/metabbe3/knoxsdating/pkg/models"
 	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+	"gorm.io/gorm"
 )
 
 type ProfileHandlers struct {
-	profileRepo repository.ProfileRepository
-	redisHelper *helpers.RedisHelper
+	profileRepo          repository.ProfileRepository
+	photoRepo            repository.PhotoRepository
+	publicProfileService *services.PublicProfileService
+	entitlementsService  *services.EntitlementsService
+	redisHelper          *helpers.RedisHelper
 }
 
 // NewProfileHandlers creates a new instance of ProfileHandlers
-func NewProfileHandlers(profileRepo repository.ProfileRepository, redisHelper *helpers.RedisHelper) *ProfileHandlers {
+func NewProfileHandlers(profileRepo repository.ProfileRepository, photoRepo repository.PhotoRepository, publicProfileService *services.PublicProfileService, entitlementsService *services.EntitlementsService, redisHelper *helpers.RedisHelper) *ProfileHandlers {
 	return &ProfileHandlers{
-		profileRepo: profileRepo,
-		redisHelper: redisHelper,
+		profileRepo:          profileRepo,
+		photoRepo:            photoRepo,
+		publicProfileService: publicProfileService,
+		entitlementsService:  entitlementsService,
+		redisHelper:          redisHelper,
 	}
 }
 
@@ -70,7 +80,7 @@ func (h *ProfileHandlers) CreateProfile(w http.ResponseWriter, r *http.Request)
 	// Get the existing profile of the user
 
 	existingProfile, err := h.profileRepo.GetProfileByUserID(user.UserID)
-	if err != nil {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching existing profile", nil, err.Error()))
 		return
 	}
@@ -80,6 +90,11 @@ func (h *ProfileHandlers) CreateProfile(w http.ResponseWriter, r *http.Request)
 		// Update the existing profile with user input
 		updateProfile(existingProfile, &profile)
 
+		if errs := helpers.ValidateProfileUpdate(existingProfile); len(errs) > 0 {
+			helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid profile input", nil, errs))
+			return
+		}
+
 		// Update the profile in the database
 		err = h.profileRepo.UpdateProfile(existingProfile)
 		if err != nil {
@@ -92,12 +107,30 @@ func (h *ProfileHandlers) CreateProfile(w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			log.Printf("Error setting profile data in Redis: %v", err)
 		}
+		services.InvalidatePublicProfile(h.redisHelper, existingProfile.UserID)
 
 		helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Profile updated successfully", existingProfile, nil))
 	} else {
 		// Associate the profile with the user
 		profile.UserID = user.UserID
 
+		// Photos are managed through the photo endpoints; list any uploaded before the profile existed
+		photos, err := h.photoRepo.GetPhotosByUserID(user.UserID)
+		if err != nil {
+			helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching photos", nil, err.Error()))
+			return
+		}
+		photoIDs := make([]int, 0, len(photos))
+		for _, photo := range photos {
+			photoIDs = append(photoIDs, photo.PhotoID)
+		}
+		profile.Photos = repository.PhotoURLs(photoIDs)
+
+		if errs := helpers.ValidateProfile(&profile); len(errs) > 0 {
+			helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid profile input", nil, errs))
+			return
+		}
+
 		// Create a new profile for the user
 		err = h.profileRepo.CreateProfile(&profile)
 		if err != nil {
@@ -124,36 +157,33 @@ func (h *ProfileHandlers) GetProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the user email from the token claims
+	// Extract user ID from token claims
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
 		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
 		return
 	}
-	email, ok := claims["email"].(string)
-	if !ok {
-		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing email from token claims", nil, ""))
-		return
-	}
 
-	// Fetch the user data from Redis
-	var user models.User
-	err = h.redisHelper.Get("user:"+email, &user)
-	if err != nil {
-		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching user from Redis", nil, err.Error()))
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
 		return
 	}
 
 	// Check if the profile data is in Redis based on userID
 	var cachedProfile models.Profile
-	err = h.redisHelper.Get("profile:"+strconv.Itoa(user.UserID), &cachedProfile)
+	err = h.redisHelper.Get("profile:"+strconv.Itoa(int(userID)), &cachedProfile)
 	if err == nil {
 		helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Profile retrieved successfully", cachedProfile, nil))
 		return
 	}
 
 	// Fetch the profile data from the database by userID
-	profile, err := h.profileRepo.GetProfileByUserID(user.UserID)
+	profile, err := h.profileRepo.GetProfileByUserID(int(userID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Profile not found", nil, err.Error()))
+		return
+	}
 	if err != nil {
 		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching profile", nil, err.Error()))
 		return
@@ -168,19 +198,65 @@ func (h *ProfileHandlers) GetProfile(w http.ResponseWriter, r *http.Request) {
 	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Profile retrieved successfully", profile, nil))
 }
 
-func updateProfile(profile *models.Profile, userInput *models.Profile) {
-	// Check if the user input contains the "Photos" field
-	if userInput.Photos != "" {
-		profile.Photos = userInput.Photos
+// GetPublicProfile returns another user's profile filtered to what the caller may see
+func (h *ProfileHandlers) GetPublicProfile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	targetUserID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid user ID", nil, err.Error()))
+		return
 	}
 
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	entitlements, err := h.entitlementsService.Entitlements(int(userID))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching entitlements", nil, err.Error()))
+		return
+	}
+
+	profile, err := h.publicProfileService.GetPublicProfile(int(userID), targetUserID, services.ViewerTierFor(entitlements))
+	if errors.Is(err, services.ErrProfileNotVisible) {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Profile not found", nil, err.Error()))
+		return
+	}
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching profile", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Profile retrieved successfully", profile, nil))
+}
+
+func updateProfile(profile *models.Profile, userInput *models.Profile) {
+	// Photos are not taken from user input; they are managed through the photo endpoints
+
 	// Check if the user input contains the "AboutMe" field
 	if userInput.AboutMe != "" {
 		profile.AboutMe = userInput.AboutMe
 	}
 
 	// Check if the user input contains the "Interests" field
-	if userInput.Interests != "" {
+	if userInput.Interests != nil {
 		profile.Interests = userInput.Interests
 	}
 
@@ -210,7 +286,7 @@ func updateProfile(profile *models.Profile, userInput *models.Profile) {
 	}
 
 	// Check if the user input contains the "SocialMediaAccounts" field
-	if userInput.SocialMediaAccounts != "" {
+	if userInput.SocialMediaAccounts != nil {
 		profile.SocialMediaAccounts = userInput.SocialMediaAccounts
 	}
 }
@@ -0,0 +1,170 @@
+// handlers/push_handlers.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+)
+
+type PushHandlers struct {
+	pushService         *services.PushService
+	notificationService *services.NotificationService
+	redisHelper         *helpers.RedisHelper
+}
+
+// NewPushHandlers creates a new instance of PushHandlers
+func NewPushHandlers(pushService *services.PushService, notificationService *services.NotificationService, redisHelper *helpers.RedisHelper) *PushHandlers {
+	return &PushHandlers{
+		pushService:         pushService,
+		notificationService: notificationService,
+		redisHelper:         redisHelper,
+	}
+}
+
+// RegisterDevice registers the caller's device for push notifications. The body names the
+// platform, android or ios, and the token the platform's push provider gave the app.
+func (h *PushHandlers) RegisterDevice(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	var request struct {
+		Platform string `json:"platform"`
+		Token    string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	device := &models.DeviceToken{UserID: int(userID), Platform: request.Platform, Token: request.Token}
+	if errs := helpers.ValidateStruct(device); len(errs) > 0 {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid device", nil, errs))
+		return
+	}
+
+	if err := h.pushService.RegisterDevice(device); err != nil {
+		log.Printf("Error registering device: %v", err)
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error registering device", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Device registered successfully", device, nil))
+}
+
+// UnregisterDevice stops push notifications to one of the caller's devices, e.g. on sign out
+func (h *PushHandlers) UnregisterDevice(w http.ResponseWriter, r *http.Request) {
+	deviceToken := mux.Vars(r)["token"]
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	deleted, err := h.pushService.UnregisterDevice(int(userID), deviceToken)
+	if err != nil {
+		log.Printf("Error unregistering device: %v", err)
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error unregistering device", nil, err.Error()))
+		return
+	}
+	if !deleted {
+		helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Device not found", nil, ""))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Device unregistered successfully", nil, nil))
+}
+
+// GetNotificationDeliveries returns how one of the caller's notifications was delivered on each
+// channel besides the inbox
+func (h *PushHandlers) GetNotificationDeliveries(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid notification ID", nil, err.Error()))
+		return
+	}
+
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	if _, err := h.notificationService.Get(int(userID), id); err != nil {
+		if errors.Is(err, services.ErrNotificationNotFound) {
+			helpers.SendJSONResponse(w, http.StatusNotFound, helpers.GenerateResponse(false, http.StatusNotFound, "Notification not found", nil, err.Error()))
+			return
+		}
+		log.Printf("Error fetching notification: %v", err)
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching notification", nil, err.Error()))
+		return
+	}
+
+	deliveries, err := h.pushService.Deliveries(id)
+	if err != nil {
+		log.Printf("Error fetching notification deliveries: %v", err)
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching notification deliveries", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Notification deliveries retrieved successfully", deliveries, nil))
+}
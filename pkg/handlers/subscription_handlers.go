@@ -0,0 +1,66 @@
+// handlers/subscription_handlers.go
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/services"
+)
+
+type SubscriptionHandlers struct {
+	entitlementsService *services.EntitlementsService
+	redisHelper         *helpers.RedisHelper
+}
+
+// NewSubscriptionHandlers creates a new instance of SubscriptionHandlers
+func NewSubscriptionHandlers(entitlementsService *services.EntitlementsService, redisHelper *helpers.RedisHelper) *SubscriptionHandlers {
+	return &SubscriptionHandlers{
+		entitlementsService: entitlementsService,
+		redisHelper:         redisHelper,
+	}
+}
+
+// GetPlans lists the plans that can be purchased and what each one includes
+func (h *SubscriptionHandlers) GetPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := h.entitlementsService.Plans()
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching plans", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Plans fetched successfully", plans, nil))
+}
+
+// GetEntitlements returns the caller's plan and the features it grants
+func (h *SubscriptionHandlers) GetEntitlements(w http.ResponseWriter, r *http.Request) {
+	// Validate JWT token
+	tokenString := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(tokenString)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusUnauthorized, helpers.GenerateResponse(false, http.StatusUnauthorized, "Invalid token", nil, err.Error()))
+		return
+	}
+
+	// Extract user ID from token claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing token claims", nil, ""))
+		return
+	}
+
+	userID, ok := claims[helpers.UserIDKey].(float64)
+	if !ok {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user ID from token claims", nil, ""))
+		return
+	}
+
+	entitlements, err := h.entitlementsService.Entitlements(int(userID))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching entitlements", nil, err.Error()))
+		return
+	}
+
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Entitlements fetched successfully", entitlements, nil))
+}
@@ -3,6 +3,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -12,18 +13,37 @@ import (
 	"github.com/metabbe3/knoxsdating/pkg/helpers"
 	"github.com/metabbe3/knoxsdating/pkg/models"
 	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
 )
 
 type SwipeHistoryHandler struct {
-	swipeHistoryRepo repository.SwipeHistoryRepository
-	redisHelper      *helpers.RedisHelper
+	swipeHistoryRepo    repository.SwipeHistoryRepository
+	profileRepo         repository.ProfileRepository
+	notificationRepo    repository.NotificationRepository
+	entitlementsService *services.EntitlementsService
+	inventoryService    *services.InventoryService
+	rewindService       *services.RewindService
+	quotaService        *services.QuotaService
+	redisHelper         *helpers.RedisHelper
+}
+
+// MatchWithProfile is a matched user together with their profile
+type MatchWithProfile struct {
+	User    models.User     `json:"user"`
+	Profile *models.Profile `json:"profile,omitempty"`
 }
 
 // NewLocationHandlers creates a new instance of LocationHandlers
-func NewSwipeHistoryHandlers(swipeHistoryRepo repository.SwipeHistoryRepository, redisHelper *helpers.RedisHelper) *SwipeHistoryHandler {
+func NewSwipeHistoryHandlers(swipeHistoryRepo repository.SwipeHistoryRepository, profileRepo repository.ProfileRepository, notificationRepo repository.NotificationRepository, entitlementsService *services.EntitlementsService, inventoryService *services.InventoryService, rewindService *services.RewindService, quotaService *services.QuotaService, redisHelper *helpers.RedisHelper) *SwipeHistoryHandler {
 	return &SwipeHistoryHandler{
-		swipeHistoryRepo: swipeHistoryRepo,
-		redisHelper:      redisHelper,
+		swipeHistoryRepo:    swipeHistoryRepo,
+		profileRepo:         profileRepo,
+		notificationRepo:    notificationRepo,
+		entitlementsService: entitlementsService,
+		inventoryService:    inventoryService,
+		rewindService:       rewindService,
+		quotaService:        quotaService,
+		redisHelper:         redisHelper,
 	}
 }
 
@@ -39,6 +59,11 @@ func (h *SwipeHistoryHandler) SaveSwipe(w http.ResponseWriter, r *http.Request)
 	}
 	defer r.Body.Close()
 
+	if swipe.SwipeDirection != models.SwipeDirectionLeft && !models.IsLike(swipe.SwipeDirection) {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid swipe direction", nil, "swipeDirection must be left, right or super"))
+		return
+	}
+
 	tokenString := r.Header.Get("Authorization")
 	token, err := helpers.ValidateToken(tokenString)
 	if err != nil {
@@ -59,16 +84,54 @@ func (h *SwipeHistoryHandler) SaveSwipe(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	premiumStatus, ok := claims[helpers.PremiumStatusKey]
-	if !ok {
-		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error parsing user Premium Status from token claims", nil, ""))
+	entitlements, err := h.entitlementsService.Entitlements(int(userID))
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error fetching entitlements", nil, err.Error()))
 		return
 	}
 
 	swipe.SwiperUserID = int(userID)
 
-	isMatched, err := h.swipeHistoryRepo.SaveSwipe(&swipe, premiumStatus)
+	// Likes count against the swiper's daily quota, which resets at midnight in their time zone
+	var likes *services.Quota
+	if swipe.SwipeDirection == models.SwipeDirectionRight {
+		likes, err = h.quotaService.Use(int(userID), services.QuotaLikes, entitlements.DailyLikes)
+	} else {
+		likes, err = h.quotaService.Get(int(userID), services.QuotaLikes, entitlements.DailyLikes)
+	}
+	if errors.Is(err, services.ErrQuotaExceeded) {
+		helpers.SendJSONResponse(w, http.StatusTooManyRequests, helpers.GenerateResponse(false, http.StatusTooManyRequests, "Daily like limit reached", likes, err.Error()))
+		return
+	}
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error checking daily likes", nil, err.Error()))
+		return
+	}
+
+	// A super like costs one from the swiper's inventory, given back if the swipe is not saved
+	var credit *services.Credit
+	if swipe.SwipeDirection == models.SwipeDirectionSuper {
+		credit, err = h.inventoryService.Consume(int(userID), models.ItemSuperLike)
+		if errors.Is(err, services.ErrNoItemsLeft) {
+			helpers.SendJSONResponse(w, http.StatusForbidden, helpers.GenerateResponse(false, http.StatusForbidden, "No super likes left", nil, err.Error()))
+			return
+		}
+		if err != nil {
+			helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error using super like", nil, err.Error()))
+			return
+		}
+	}
+
+	isMatched, err := h.swipeHistoryRepo.SaveSwipe(&swipe)
 	if err != nil {
+		if refundErr := h.inventoryService.Refund(credit); refundErr != nil {
+			log.Printf("Error returning super like to user %d: %v", int(userID), refundErr)
+		}
+		if swipe.SwipeDirection == models.SwipeDirectionRight {
+			if _, releaseErr := h.quotaService.Release(int(userID), services.QuotaLikes, entitlements.DailyLikes); releaseErr != nil {
+				log.Printf("Error returning like to user %d: %v", int(userID), releaseErr)
+			}
+		}
 		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Failed to save swipe history", nil, err.Error()))
 		return
 	}
@@ -80,6 +143,10 @@ func (h *SwipeHistoryHandler) SaveSwipe(w http.ResponseWriter, r *http.Request)
 		// Handle the error as needed (e.g., log, but don't affect the HTTP response)
 	}
 
+	if swipe.SwipeDirection == models.SwipeDirectionSuper {
+		h.notifySuperLike(swipe.SwipedUserID)
+	}
+
 	// Determine the match status based on IsMatched field
 	matchStatus := "Not Matched"
 	if isMatched {
@@ -95,23 +162,18 @@ func (h *SwipeHistoryHandler) SaveSwipe(w http.ResponseWriter, r *http.Request)
 	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Swipe history saved successfully", map[string]interface{}{
 		"swipe":       swipe,
 		"matchStatus": matchStatus,
+		"likes":       likes,
 	}, nil))
 }
 
-// GetMatches handles retrieving matches for a user
+// GetMatches handles retrieving matches for a user. Users who liked the caller without a match
+// are listed by GET /likes/received instead.
 func (h *SwipeHistoryHandler) GetMatches(w http.ResponseWriter, r *http.Request) {
-	// Decode JSON payload
-	var requestBody struct {
-		MatchType string `json:"matchType"`
+	matchType := r.URL.Query().Get("matchType")
+	if matchType == "" {
+		matchType = "all"
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&requestBody); err != nil {
-		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid input", nil, err.Error()))
-		return
-	}
-	defer r.Body.Close()
-
 	tokenString := r.Header.Get("Authorization")
 	token, err := helpers.ValidateToken(tokenString)
 	if err != nil {
@@ -133,17 +195,35 @@ func (h *SwipeHistoryHandler) GetMatches(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Call the repository method to get matches
-	matches, err := h.swipeHistoryRepo.GetMatches(int(userID), requestBody.MatchType)
+	matches, err := h.swipeHistoryRepo.GetMatches(int(userID), matchType)
 	if err != nil {
 		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Failed to retrieve matches", nil, err.Error()))
 		return
 	}
 
+	// Load every matched user's profile in one batch
+	matchedUserIDs := make([]int, 0, len(matches))
+	for _, match := range matches {
+		matchedUserIDs = append(matchedUserIDs, match.UserID)
+	}
+	profiles, err := h.profileRepo.GetProfilesByUserIDs(matchedUserIDs)
+	if err != nil {
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Failed to retrieve match profiles", nil, err.Error()))
+		return
+	}
+
+	results := make([]MatchWithProfile, 0, len(matches))
+	for _, match := range matches {
+		match.Password = ""
+		results = append(results, MatchWithProfile{User: match, Profile: profiles[match.UserID]})
+	}
+
 	// Use helpers.SendJSONResponse for the response
-	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Matches retrieved successfully", matches, nil))
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Matches retrieved successfully", results, nil))
 }
 
-// RedoSwipe handles redoing a swipe
+// RedoSwipe undoes the caller's most recent left swipe, if it is recent enough, and returns the
+// profile it passed on
 func (h *SwipeHistoryHandler) RedoSwipe(w http.ResponseWriter, r *http.Request) {
 	// Extract user ID from JWT token
 	tokenString := r.Header.Get("Authorization")
@@ -166,56 +246,34 @@ func (h *SwipeHistoryHandler) RedoSwipe(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Retrieve redo result from Redis cache
-	var redoResult string
-	err = h.redisHelper.Get("redo:"+strconv.Itoa(int(userID)), &redoResult)
-	if err != nil {
-		// Handle the error, e.g., log it
-		log.Printf("Error retrieving redo result from Redis: %v", err)
-		redoResult = "" // Reset redoResult to proceed with the database operation
-	}
-
-	if redoResult == "error" {
-		// If redo is not allowed, you may choose to log the event or return a specific response
-		log.Println("Redo not allowed from Redis. Proceeding with database operation.")
+	rewind, err := h.rewindService.Rewind(int(userID))
+	switch {
+	case errors.Is(err, services.ErrNoItemsLeft):
+		helpers.SendJSONResponse(w, http.StatusForbidden, helpers.GenerateResponse(false, http.StatusForbidden, "No rewinds left", nil, err.Error()))
+		return
+	case errors.Is(err, services.ErrNothingToRewind):
+		helpers.SendJSONResponse(w, http.StatusConflict, helpers.GenerateResponse(false, http.StatusConflict, "Nothing to rewind", nil, err.Error()))
+		return
+	case err != nil:
+		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Failed to redo swipe", nil, err.Error()))
+		return
 	}
 
-	// Update Redis cache with redo status
-	err = h.redisHelper.Set("redo:"+strconv.Itoa(int(userID)), "error", 0)
-	if err != nil {
-		// Handle the error, e.g., log it
-		log.Printf("Error updating Redis with redo status: %v", err)
-		// Note: You may choose to continue with the operation or return an error response here
-	}
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Redo swipe successful", rewind, nil))
+}
 
-	// Call the repository method to perform the redo swipe
-	originalSwipe, profiles, err := h.swipeHistoryRepo.RedoSwipe(int(userID))
-	if err != nil {
-		// Handle the error, e.g., log it
-		log.Printf("Error redoing swipe: %v", err)
-
-		// If Redis did not have the result, you may want to attempt a database operation here
-		if redoResult == "" {
-			originalSwipe, profiles, err = h.swipeHistoryRepo.RedoSwipe(int(userID))
-			if err != nil {
-				// Handle the error from the database operation
-				log.Printf("Error redoing swipe from DB: %v", err)
-				helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Failed to redo swipe", nil, err.Error()))
-				return
-			}
-		} else {
-			helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Failed to redo swipe", nil, err.Error()))
-			return
-		}
+// notifySuperLike tells the user they were super liked. The swiper is not named; they are shown
+// first in the user's discovery feed instead.
+func (h *SwipeHistoryHandler) notifySuperLike(swipedUserID int) {
+	notification := &models.Notification{
+		UserID:           swipedUserID,
+		NotificationType: services.NotificationTypeSuperLike,
+		Message:          "Someone super liked you! They are at the front of your discovery feed.",
+		Timestamp:        time.Now(),
 	}
-
-	// Use helpers.SendJSONResponse for the response
-	responsePayload := map[string]interface{}{
-		"originalSwipe": originalSwipe,
-		"profiles":      profiles,
+	if err := h.notificationRepo.CreateNotification(notification); err != nil {
+		log.Printf("Error creating super like notification for user %d: %v", swipedUserID, err)
 	}
-
-	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Redo swipe successful", responsePayload, nil))
 }
 
 // updateOrCreateSwipeHistoryInRedis updates or creates swipe history data in Redis
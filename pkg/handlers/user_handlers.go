@@ -38,8 +38,8 @@ func (h *UserHandlers) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	// Validate input
-	if err := validateUserInput(&user); err != nil {
-		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid user input", nil, err.Error()))
+	if errs := validateUserInput(&user); len(errs) > 0 {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid user input", nil, errs))
 		return
 	}
 
@@ -145,8 +145,8 @@ func (h *UserHandlers) Login(w http.ResponseWriter, r *http.Request) {
 func (h *UserHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 
-	// Declare and initialize the secretKey variable
-	secretKey := r.Header.Get("SecretKey")
+	// Admin requests update the same profile fields but never change the password
+	isAdmin := helpers.IsAdminRequest(r)
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&user); err != nil {
 		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
@@ -183,11 +183,14 @@ func (h *UserHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if secretKey == "adminSecretKey" {
+	if isAdmin {
 		// Update the user data based on the input
 		if user.Gender != "" {
 			existingUser.Gender = user.Gender
 		}
+		if user.BirthDate != nil {
+			existingUser.BirthDate = user.BirthDate
+		}
 		if user.Company != "" {
 			existingUser.Company = user.Company
 		}
@@ -197,11 +200,17 @@ func (h *UserHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		if user.JobTitle != "" {
 			existingUser.JobTitle = user.JobTitle
 		}
+		if user.TimeZone != "" {
+			existingUser.TimeZone = user.TimeZone
+		}
 	} else {
 		// Update the user data based on the input, excluding certain fields
 		if user.Gender != "" {
 			existingUser.Gender = user.Gender
 		}
+		if user.BirthDate != nil {
+			existingUser.BirthDate = user.BirthDate
+		}
 		if user.Company != "" {
 			existingUser.Company = user.Company
 		}
@@ -211,11 +220,21 @@ func (h *UserHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		if user.JobTitle != "" {
 			existingUser.JobTitle = user.JobTitle
 		}
+		if user.TimeZone != "" {
+			existingUser.TimeZone = user.TimeZone
+		}
 
 		// Check if the password is provided in the input
 		if user.Password != "" {
 			// Check if the password is already hashed
 			if !strings.HasPrefix(user.Password, "$2a$") {
+				if len(user.Password) < 8 {
+					helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid user input", nil, helpers.ValidationErrors{
+						{Field: "Password", Message: "must be at least 8 characters"},
+					}))
+					return
+				}
+
 				// Hash the new password
 				hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 				if err != nil {
@@ -230,6 +249,12 @@ func (h *UserHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Validate the merged user with the same rules for admin and self edits
+	if errs := helpers.ValidateUser(existingUser); len(errs) > 0 {
+		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid user input", nil, errs))
+		return
+	}
+
 	// Update the user by email
 	err = h.userRepo.UpdateUser(existingUser)
 	if err != nil {
@@ -254,11 +279,14 @@ func (h *UserHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "User updated successfully", existingUser, nil))
 }
 
-func (h *UserHandlers) UpdatePremium(w http.ResponseWriter, r *http.Request) {
-	var user models.User
+// UpdateIncognito turns incognito mode on or off; incognito users are only shown to people they have liked
+func (h *UserHandlers) UpdateIncognito(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Enabled bool `json:"enabled"`
+	}
 
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&user); err != nil {
+	if err := decoder.Decode(&requestPayload); err != nil {
 		helpers.SendJSONResponse(w, http.StatusBadRequest, helpers.GenerateResponse(false, http.StatusBadRequest, "Invalid request payload", nil, err.Error()))
 		return
 	}
@@ -292,69 +320,37 @@ func (h *UserHandlers) UpdatePremium(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the user is already a premium user
-	if existingUser.PremiumStatus == "Free" {
-		// Set the PremiumStatus to "Premium"
-		existingUser.PremiumStatus = "Premium"
-
-		// Set the PremiumStartDate to the current date
-		currentTime := time.Now()
-		existingUser.PremiumStartDate = currentTime
-
-		existingUser.PremiumEndDate = currentTime.AddDate(0, 1, 0)
+	existingUser.IsIncognito = requestPayload.Enabled
 
-	} else if existingUser.PremiumStatus == "Premium" {
-		// Add 1 month to the current PremiumEndDate
-		// Set the PremiumEndDate to 1 month from the current date or the token's end date + 1 month
-		existingUser.PremiumEndDate = existingUser.PremiumEndDate.AddDate(0, 1, 0)
-	}
-
-	// Update the user by email
 	err = h.userRepo.UpdateUser(existingUser)
 	if err != nil {
 		helpers.SendJSONResponse(w, http.StatusInternalServerError, helpers.GenerateResponse(false, http.StatusInternalServerError, "Error updating user", nil, err.Error()))
 		return
 	}
 
-	// Delete user data from Redis on update
-	err = h.redisHelper.Delete("user:" + existingUser.Email)
-	if err != nil {
-		log.Printf("Error delete  data in Redis: %v", err)
-		// Handle error, e.g., log it
-	}
-
 	// Add back updated data to Redis
 	err = h.redisHelper.Set("user:"+existingUser.Email, existingUser, time.Hour*24)
 	if err != nil {
-		log.Printf("Error set  data in Redis: %v", err)
-		// Handle error, e.g., log it
+		log.Printf("Error setting data in Redis: %v", err)
 	}
 
-	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "User updated successfully", existingUser, nil))
+	// Only the new state is returned, never the stored user with its password hash and private fields
+	response := map[string]interface{}{
+		"incognito": existingUser.IsIncognito,
+	}
+	helpers.SendJSONResponse(w, http.StatusOK, helpers.GenerateResponse(true, http.StatusOK, "Incognito mode updated successfully", response, nil))
 }
 
-func validateUserInput(user *models.User) error {
-	// Validate email
-	if !helpers.IsValidEmail(user.Email) {
-		return helpers.ValidationError("Invalid email")
-	}
+func validateUserInput(user *models.User) helpers.ValidationErrors {
+	// Validate the declared field rules (email, username, gender, ...)
+	errs := helpers.ValidateUser(user)
 
-	// Validate password
+	// Validate password before it is hashed
 	if len(user.Password) < 8 {
-		return helpers.ValidationError("Password must be at least 8 characters long")
+		errs = append(errs, helpers.FieldError{Field: "Password", Message: "must be at least 8 characters"})
 	}
 
-	// Validate username
-	if len(user.Username) == 0 {
-		return helpers.ValidationError("Username is required")
-	}
-
-	// Validate gender
-	if len(user.Gender) > 0 && (user.Gender != "Male" && user.Gender != "Female") {
-		return helpers.ValidationError("Invalid gender")
-	}
-
-	return nil
+	return errs
 }
 
 func validateLoginInput(credentials *models.Credentials) error {
@@ -0,0 +1,72 @@
+// handlers/user_handlers_test.go
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// storedUserRepo holds a single user looked up by email
+type storedUserRepo struct {
+	repository.UserRepository
+	user models.User
+}
+
+func (r *storedUserRepo) GetUserByEmail(email string) (*models.User, error) {
+	user := r.user
+	return &user, nil
+}
+
+func (r *storedUserRepo) UpdateUser(user *models.User) error {
+	r.user = *user
+	return nil
+}
+
+func TestUserHandlers_UpdateIncognitoReturnsOnlyTheNewState(t *testing.T) {
+	users := &storedUserRepo{user: models.User{UserID: 1, Email: "ann@example.com", Password: "$2a$10$hash", Company: "Acme"}}
+	// Nothing listens there; the cache write fails and is only logged
+	unreachable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 10 * time.Millisecond, MaxRetries: -1})
+	defer unreachable.Close()
+	handlers := NewUserHandlers(users, helpers.NewRedisHelper(unreachable).(*helpers.RedisHelper))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/users/incognito", handlers.UpdateIncognito).Methods("PUT")
+
+	token, _ := helpers.GenerateToken(users.user)
+	request := httptest.NewRequest("PUT", "/users/incognito", bytes.NewBufferString(`{"enabled":true}`))
+	request.Header.Set("Authorization", "Bearer "+token)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	if !users.user.IsIncognito {
+		t.Errorf("expected incognito mode stored")
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(body.Data) != 1 || body.Data["incognito"] != true {
+		t.Errorf("expected only the incognito state, got %v", body.Data)
+	}
+	for _, private := range []string{"$2a$10$hash", "ann@example.com", "Acme"} {
+		if bytes.Contains(response.Body.Bytes(), []byte(private)) {
+			t.Errorf("expected %q left out of the response", private)
+		}
+	}
+}
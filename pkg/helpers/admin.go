@@ -0,0 +1,25 @@
+// helpers/admin.go
+package helpers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+)
+
+const (
+	// AdminSecretKeyHeader carries the admin secret on requests made by operators
+	AdminSecretKeyHeader = "SecretKey"
+	// AdminSecretKeyEnv names the admin secret. While it is unset no request is an admin request.
+	AdminSecretKeyEnv = "ADMIN_SECRET_KEY"
+)
+
+// IsAdminRequest reports whether the request carries the admin secret
+func IsAdminRequest(r *http.Request) bool {
+	expected := os.Getenv(AdminSecretKeyEnv)
+	if expected == "" {
+		return false
+	}
+	given := r.Header.Get(AdminSecretKeyHeader)
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
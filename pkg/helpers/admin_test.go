@@ -0,0 +1,34 @@
+// helpers/admin_test.go
+package helpers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsAdminRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		want   bool
+	}{
+		{"matching secret", "s3cret", "s3cret", true},
+		{"wrong secret", "s3cret", "other", false},
+		{"missing header", "s3cret", "", false},
+		{"no secret configured", "", "", false},
+		{"old default with no secret configured", "", "adminSecretKey", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(AdminSecretKeyEnv, tt.secret)
+			request := httptest.NewRequest("POST", "/notifications", nil)
+			if tt.header != "" {
+				request.Header.Set(AdminSecretKeyHeader, tt.header)
+			}
+			if got := IsAdminRequest(request); got != tt.want {
+				t.Errorf("IsAdminRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
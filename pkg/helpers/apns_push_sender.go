@@ -0,0 +1,166 @@
+// helpers/apns_push_sender.go
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+)
+
+// apnsTokenLifetime is how long a provider token is reused. APNs rejects tokens older than an
+// hour and throttles clients that refresh more than every 20 minutes.
+const apnsTokenLifetime = 50 * time.Minute
+
+// APNsConfig holds the settings for the Apple Push Notification service, using token based
+// authentication with a .p8 signing key
+type APNsConfig struct {
+	KeyID      string
+	TeamID     string
+	PrivateKey string // PEM encoded .p8 key
+	Topic      string // the app's bundle ID
+	APIBase    string // defaults to https://api.push.apple.com; use https://api.sandbox.push.apple.com for development builds
+}
+
+// APNsPushSender is a PushSender for iOS devices backed by APNs
+type APNsPushSender struct {
+	config APNsConfig
+	key    *ecdsa.PrivateKey
+	client *http.Client
+	now    func() time.Time
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// NewAPNsPushSender creates a new APNsPushSender
+func NewAPNsPushSender(config APNsConfig) (*APNsPushSender, error) {
+	if config.APIBase == "" {
+		config.APIBase = "https://api.push.apple.com"
+	}
+	key, err := parseECPrivateKey([]byte(config.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs private key: %w", err)
+	}
+	return &APNsPushSender{
+		config: config,
+		key:    key,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}, nil
+}
+
+func (s *APNsPushSender) Platform() string {
+	return models.DevicePlatformIOS
+}
+
+// Send sends the message to the device as an alert. Tokens APNs reports as bad or unregistered are
+// invalid.
+func (s *APNsPushSender) Send(ctx context.Context, message PushMessage) error {
+	token, err := s.providerToken()
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": message.Title, "body": message.Body},
+			"sound": "default",
+		},
+	}
+	for key, value := range message.Data {
+		payload[key] = value
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(s.config.APIBase, "/") + "/3/device/" + message.Token
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("apns-topic", s.config.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	var failure struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.Unmarshal(raw, &failure)
+	switch {
+	case resp.StatusCode == http.StatusGone, failure.Reason == "BadDeviceToken", failure.Reason == "Unregistered", failure.Reason == "DeviceTokenNotForTopic":
+		return fmt.Errorf("apns %s: %w", failure.Reason, ErrInvalidDeviceToken)
+	case failure.Reason == "ExpiredProviderToken" || failure.Reason == "InvalidProviderToken":
+		s.mu.Lock()
+		s.token = ""
+		s.mu.Unlock()
+	}
+	return fmt.Errorf("apns send failed with status %d: %s", resp.StatusCode, failure.Reason)
+}
+
+// providerToken returns the signed JWT APNs authenticates requests with, signing a new one when
+// the current one is due for renewal
+func (s *APNsPushSender) providerToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.token != "" && now.Sub(s.issuedAt) < apnsTokenLifetime {
+		return s.token, nil
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": s.config.TeamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = s.config.KeyID
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		return "", err
+	}
+	s.token = signed
+	s.issuedAt = now
+	return signed, nil
+}
+
+// parseECPrivateKey parses a PEM encoded PKCS8 or SEC 1 elliptic curve private key
+func parseECPrivateKey(pemKey []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		key, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("key is not an elliptic curve key")
+		}
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
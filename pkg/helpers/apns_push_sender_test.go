@@ -0,0 +1,165 @@
+// helpers/apns_push_sender_test.go
+package helpers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// fakeAPNs is the APNs API. It answers a push by the device token: "ok" is delivered, "gone",
+// "bad" and "other-app" are invalid tokens and anything else is unavailable. Provider tokens
+// issued before expiredBefore are refused as expired.
+type fakeAPNs struct {
+	key           *ecdsa.PublicKey
+	tokens        map[string]bool
+	expiredBefore int64
+}
+
+func newFakeAPNs(t *testing.T) (*fakeAPNs, *APNsPushSender, *time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeAPNs{key: &key.PublicKey, tokens: map[string]bool{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	sender, err := NewAPNsPushSender(APNsConfig{
+		KeyID:      "ABC123DEFG",
+		TeamID:     "DEF123GHIJ",
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		Topic:      "com.knoxs.dating",
+		APIBase:    server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	sender.now = func() time.Time { return now }
+	return fake, sender, &now
+}
+
+func (f *fakeAPNs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, reason string) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"reason": %q}`, reason)
+	}
+
+	// The provider token is signed with the team's key and names it; its times follow the
+	// sender's clock, not this one
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "bearer ")
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{ValidMethods: []string{"ES256"}, SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(bearer, claims, func(token *jwt.Token) (interface{}, error) {
+		return f.key, nil
+	})
+	if err != nil || token.Header["kid"] != "ABC123DEFG" || claims["iss"] != "DEF123GHIJ" {
+		fail(http.StatusForbidden, "InvalidProviderToken")
+		return
+	}
+	if issuedAt, _ := claims["iat"].(float64); int64(issuedAt) < f.expiredBefore {
+		fail(http.StatusForbidden, "ExpiredProviderToken")
+		return
+	}
+	f.tokens[bearer] = true
+
+	var payload struct {
+		APS struct {
+			Alert map[string]string `json:"alert"`
+		} `json:"aps"`
+		NotificationID string `json:"notificationID"`
+	}
+	json.NewDecoder(r.Body).Decode(&payload)
+	if r.Header.Get("apns-topic") != "com.knoxs.dating" || r.Header.Get("apns-push-type") != "alert" || payload.APS.Alert["title"] != "Matched" || payload.NotificationID != "7" {
+		fail(http.StatusBadRequest, "BadMessage")
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/3/device/") {
+	case "ok":
+	case "gone":
+		fail(http.StatusGone, "Unregistered")
+	case "bad":
+		fail(http.StatusBadRequest, "BadDeviceToken")
+	case "other-app":
+		fail(http.StatusBadRequest, "DeviceTokenNotForTopic")
+	default:
+		fail(http.StatusServiceUnavailable, "ServiceUnavailable")
+	}
+}
+
+func apnsMessage(token string) PushMessage {
+	return PushMessage{Token: token, Title: "Matched", Body: "You matched with user2!", Data: map[string]string{"notificationID": "7"}}
+}
+
+func TestAPNsPushSender_MapsTheResponses(t *testing.T) {
+	_, sender, _ := newFakeAPNs(t)
+
+	cases := []struct {
+		token   string
+		invalid bool
+		ok      bool
+	}{
+		{token: "ok", ok: true},
+		{token: "gone", invalid: true},
+		{token: "bad", invalid: true},
+		{token: "other-app", invalid: true},
+		{token: "busy"},
+	}
+	for _, c := range cases {
+		err := sender.Send(context.Background(), apnsMessage(c.token))
+		if c.ok != (err == nil) || c.invalid != errors.Is(err, ErrInvalidDeviceToken) {
+			t.Errorf("%s: unexpected error %v", c.token, err)
+		}
+	}
+}
+
+func TestAPNsPushSender_RenewsTheProviderToken(t *testing.T) {
+	fake, sender, now := newFakeAPNs(t)
+
+	for i := 0; i < 3; i++ {
+		if err := sender.Send(context.Background(), apnsMessage("ok")); err != nil {
+			t.Fatalf("send failed: %v", err)
+		}
+		*now = now.Add(10 * time.Minute)
+	}
+	if len(fake.tokens) != 1 {
+		t.Fatalf("expected the provider token reused, got %d tokens", len(fake.tokens))
+	}
+
+	// Within the hour APNs accepts it, the token is signed again
+	*now = now.Add(25 * time.Minute)
+	if err := sender.Send(context.Background(), apnsMessage("ok")); err != nil || len(fake.tokens) != 2 {
+		t.Fatalf("expected a new provider token, got %d tokens (%v)", len(fake.tokens), err)
+	}
+
+	// A token APNs refuses as expired fails the send and is signed again for the next one
+	fake.expiredBefore = now.Add(time.Second).Unix()
+	*now = now.Add(time.Second)
+	if err := sender.Send(context.Background(), apnsMessage("ok")); err == nil || errors.Is(err, ErrInvalidDeviceToken) || !strings.Contains(err.Error(), "ExpiredProviderToken") {
+		t.Fatalf("expected the send to fail for the provider token, got %v", err)
+	}
+	if err := sender.Send(context.Background(), apnsMessage("ok")); err != nil || len(fake.tokens) != 3 {
+		t.Fatalf("expected the provider token signed again, got %d tokens (%v)", len(fake.tokens), err)
+	}
+}
@@ -0,0 +1,56 @@
+// helpers/cities.go
+package helpers
+
+import "strings"
+
+// City is a named place a Passport can be set to
+type City struct {
+	Name      string  `json:"name"`
+	Country   string  `json:"country"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// PassportCities are the places users can pick by name; any other place can be set by coordinates
+var PassportCities = []City{
+	{"Jakarta", "Indonesia", -6.2088, 106.8456},
+	{"Surabaya", "Indonesia", -7.2575, 112.7521},
+	{"Bandung", "Indonesia", -6.9175, 107.6191},
+	{"Bali", "Indonesia", -8.6705, 115.2126},
+	{"Yogyakarta", "Indonesia", -7.7956, 110.3695},
+	{"Singapore", "Singapore", 1.3521, 103.8198},
+	{"Kuala Lumpur", "Malaysia", 3.1390, 101.6869},
+	{"Bangkok", "Thailand", 13.7563, 100.5018},
+	{"Manila", "Philippines", 14.5995, 120.9842},
+	{"Ho Chi Minh City", "Vietnam", 10.8231, 106.6297},
+	{"Mumbai", "India", 19.0760, 72.8777},
+	{"Delhi", "India", 28.7041, 77.1025},
+	{"Bengaluru", "India", 12.9716, 77.5946},
+	{"Chennai", "India", 13.0827, 80.2707},
+	{"Kolkata", "India", 22.5726, 88.3639},
+	{"Hyderabad", "India", 17.3850, 78.4867},
+	{"Dubai", "United Arab Emirates", 25.2048, 55.2708},
+	{"Tokyo", "Japan", 35.6762, 139.6503},
+	{"Seoul", "South Korea", 37.5665, 126.9780},
+	{"Hong Kong", "China", 22.3193, 114.1694},
+	{"Sydney", "Australia", -33.8688, 151.2093},
+	{"Melbourne", "Australia", -37.8136, 144.9631},
+	{"London", "United Kingdom", 51.5072, -0.1276},
+	{"Paris", "France", 48.8566, 2.3522},
+	{"Berlin", "Germany", 52.5200, 13.4050},
+	{"Amsterdam", "Netherlands", 52.3676, 4.9041},
+	{"New York", "United States", 40.7128, -74.0060},
+	{"Los Angeles", "United States", 34.0522, -118.2437},
+	{"San Francisco", "United States", 37.7749, -122.4194},
+	{"Toronto", "Canada", 43.6532, -79.3832},
+}
+
+// LookupCity finds a passport city by name, ignoring case
+func LookupCity(name string) (City, bool) {
+	for _, city := range PassportCities {
+		if strings.EqualFold(city.Name, strings.TrimSpace(name)) {
+			return city, true
+		}
+	}
+	return City{}, false
+}
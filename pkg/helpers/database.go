@@ -24,6 +24,8 @@ type DatabaseHandler interface {
 	Find(dest interface{}, conds ...interface{}) *gorm.DB
 	Raw(query string, values ...interface{}) *gorm.DB // Add Raw method
 	Omit(columns ...string) *gorm.DB                  // Add Omit method
+	Transaction(fc func(tx *gorm.DB) error) error     // Add Transaction method
+	Exec(sql string, values ...interface{}) *gorm.DB  // Add Exec method
 }
 
 // GormDBHandler is the concrete implementation of DatabaseHandler for gorm.DB
@@ -106,6 +108,16 @@ func (g *GormDBHandler) Omit(columns ...string) *gorm.DB {
 	return g.db.Omit(columns...)
 }
 
+// Transaction runs fc inside a database transaction, rolling back if it returns an error
+func (g *GormDBHandler) Transaction(fc func(tx *gorm.DB) error) error {
+	return g.db.Transaction(fc)
+}
+
+// Exec runs a raw statement that returns no rows, such as a batched UPDATE or DELETE
+func (g *GormDBHandler) Exec(sql string, values ...interface{}) *gorm.DB {
+	return g.db.Exec(sql, values...)
+}
+
 // NewGormDBHandler creates a new GormDBHandler
 func NewGormDBHandler(db *gorm.DB) DatabaseHandler {
 	return &GormDBHandler{db: db}
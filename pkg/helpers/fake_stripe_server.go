@@ -0,0 +1,525 @@
+// helpers/fake_stripe_server.go
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeStripeServer imitates the parts of the Stripe API the app uses, for local development and
+// tests. Checkout sessions are created over HTTP like the real API. Paying happens when the
+// checkout URL is opened; renewing, failing a renewal, cancelling, refunding and disputing are
+// triggered by method calls or POST /_fake/subscriptions/{id}/{renew,fail,cancel,end,refund,dispute}.
+// Subscriptions cancelled through the API with DELETE /v1/subscriptions/{id} end immediately.
+// One-off payments for packs are refunded with POST /_fake/payments/{id}/refund. Each sends the
+// same signed webhook events Stripe would.
+type FakeStripeServer struct {
+	// Deliver sends a signed webhook; by default it POSTs to the webhook URL
+	Deliver func(payload []byte, signature string) error
+
+	secret     string
+	webhookURL string
+	client     *http.Client
+	now        func() time.Time
+
+	mu            sync.Mutex
+	sequence      int
+	sessions      map[string]*fakeCheckoutSession
+	subscriptions map[string]*fakeSubscription
+	payments      map[string]*fakePayment
+	deliveries    []FakeWebhookDelivery
+}
+
+// FakeWebhookDelivery is a webhook sent by the fake server, kept so tests can replay it
+type FakeWebhookDelivery struct {
+	Type      string
+	Payload   []byte
+	Signature string
+}
+
+type fakeCheckoutSession struct {
+	id           string
+	userID       string
+	customer     string
+	email        string
+	plan         string
+	pack         string
+	currency     string
+	amount       int
+	periodMonths int
+	completed    bool
+}
+
+type fakeSubscription struct {
+	id           string
+	customer     string
+	userID       string
+	plan         string
+	currency     string
+	amount       int
+	periodMonths int
+	periodEnd    time.Time
+	lastCharge   string
+	deleted      bool
+}
+
+// fakePayment is a one-off payment, for a pack
+type fakePayment struct {
+	id       string
+	charge   string
+	customer string
+	currency string
+	amount   int
+}
+
+// NewFakeStripeServer creates a fake that signs webhooks with the secret and sends them to webhookURL
+func NewFakeStripeServer(webhookSecret, webhookURL string) *FakeStripeServer {
+	server := &FakeStripeServer{
+		secret:        webhookSecret,
+		webhookURL:    webhookURL,
+		client:        &http.Client{Timeout: 30 * time.Second},
+		now:           time.Now,
+		sessions:      make(map[string]*fakeCheckoutSession),
+		subscriptions: make(map[string]*fakeSubscription),
+		payments:      make(map[string]*fakePayment),
+	}
+	server.Deliver = server.post
+	return server
+}
+
+func (s *FakeStripeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/checkout/sessions":
+		s.createCheckoutSession(w, r)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v1/subscriptions/"):
+		s.deleteSubscription(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/checkout/"):
+		// Opening the hosted checkout page pays immediately
+		if _, err := s.CompleteCheckout(strings.TrimPrefix(r.URL.Path, "/checkout/")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("Payment complete"))
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/_fake/subscriptions/"):
+		s.control(w, r)
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/_fake/payments/") && strings.HasSuffix(r.URL.Path, "/refund"):
+		paymentID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/_fake/payments/"), "/refund")
+		if err := s.RefundPayment(paymentID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// control triggers subscription events over HTTP, e.g. POST /_fake/subscriptions/{id}/renew
+func (s *FakeStripeServer) control(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/_fake/subscriptions/"), "/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	actions := map[string]func(string) error{
+		"renew":   s.RenewSubscription,
+		"fail":    s.FailRenewal,
+		"cancel":  s.CancelSubscription,
+		"end":     s.EndSubscription,
+		"refund":  s.RefundLastCharge,
+		"dispute": s.DisputeLastCharge,
+	}
+	run, ok := actions[action]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err := run(subscriptionID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *FakeStripeServer) createCheckoutSession(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		http.Error(w, `{"error":{"message":"missing API key"}}`, http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	amount, err := strconv.Atoi(r.PostForm.Get("line_items[0][price_data][unit_amount]"))
+	mode := r.PostForm.Get("mode")
+	if err != nil || (mode != "subscription" && mode != "payment") || r.PostForm.Get("client_reference_id") == "" {
+		http.Error(w, `{"error":{"message":"invalid checkout session"}}`, http.StatusBadRequest)
+		return
+	}
+	periodMonths, err := strconv.Atoi(r.PostForm.Get("line_items[0][price_data][recurring][interval_count]"))
+	if err != nil || periodMonths < 1 {
+		periodMonths = 1
+	}
+
+	s.mu.Lock()
+	session := &fakeCheckoutSession{
+		id:           s.nextID("cs"),
+		userID:       r.PostForm.Get("client_reference_id"),
+		customer:     r.PostForm.Get("customer"),
+		email:        r.PostForm.Get("customer_email"),
+		plan:         r.PostForm.Get("metadata[plan]"),
+		pack:         r.PostForm.Get("metadata[pack]"),
+		currency:     r.PostForm.Get("line_items[0][price_data][currency]"),
+		amount:       amount,
+		periodMonths: periodMonths,
+	}
+	s.sessions[session.id] = session
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CheckoutSession{ID: session.id, URL: "http://" + r.Host + "/checkout/" + session.id})
+}
+
+// deleteSubscription cancels a subscription through the API, which ends it immediately and sends
+// customer.subscription.deleted like Stripe
+func (s *FakeStripeServer) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		http.Error(w, `{"error":{"message":"missing API key"}}`, http.StatusUnauthorized)
+		return
+	}
+	subscriptionID := strings.TrimPrefix(r.URL.Path, "/v1/subscriptions/")
+
+	s.mu.Lock()
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok || subscription.deleted {
+		s.mu.Unlock()
+		http.Error(w, `{"error":{"code":"resource_missing","message":"No such subscription"}}`, http.StatusNotFound)
+		return
+	}
+	subscription.deleted = true
+	s.mu.Unlock()
+
+	if err := s.EndSubscription(subscriptionID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": subscriptionID, "object": "subscription", "status": "canceled"})
+}
+
+// CompleteCheckout pays for the session, starting a subscription, and returns the subscription ID.
+// Sessions for a pack are paid once and return the payment intent ID instead.
+func (s *FakeStripeServer) CompleteCheckout(sessionID string) (string, error) {
+	s.mu.Lock()
+	session, ok := s.sessions[sessionID]
+	if !ok || session.completed {
+		s.mu.Unlock()
+		return "", fmt.Errorf("unknown or completed checkout session %q", sessionID)
+	}
+	session.completed = true
+
+	if session.pack != "" {
+		payment := &fakePayment{
+			id:       s.nextID("pi"),
+			charge:   s.nextID("ch"),
+			customer: s.nextID("cus"),
+			currency: session.currency,
+			amount:   session.amount,
+		}
+		s.payments[payment.id] = payment
+		s.mu.Unlock()
+
+		return payment.id, s.send("checkout.session.completed", map[string]interface{}{
+			"id":                  session.id,
+			"object":              "checkout.session",
+			"mode":                "payment",
+			"client_reference_id": session.userID,
+			"customer":            payment.customer,
+			"customer_email":      session.email,
+			"payment_intent":      payment.id,
+			"amount_total":        session.amount,
+			"currency":            session.currency,
+			"metadata":            map[string]string{"userID": session.userID, "pack": session.pack},
+		})
+	}
+
+	now := s.now()
+	customer := session.customer
+	if customer == "" {
+		customer = s.nextID("cus")
+	}
+	subscription := &fakeSubscription{
+		id:           s.nextID("sub"),
+		customer:     customer,
+		userID:       session.userID,
+		plan:         session.plan,
+		currency:     session.currency,
+		amount:       session.amount,
+		periodMonths: session.periodMonths,
+		periodEnd:    now.AddDate(0, session.periodMonths, 0),
+	}
+	s.subscriptions[subscription.id] = subscription
+	s.mu.Unlock()
+
+	err := s.send("checkout.session.completed", map[string]interface{}{
+		"id":                  session.id,
+		"object":              "checkout.session",
+		"mode":                "subscription",
+		"client_reference_id": session.userID,
+		"customer":            subscription.customer,
+		"customer_email":      session.email,
+		"subscription":        subscription.id,
+		"amount_total":        session.amount,
+		"currency":            session.currency,
+		"metadata":            map[string]string{"userID": session.userID, "plan": session.plan},
+	})
+	if err != nil {
+		return subscription.id, err
+	}
+	return subscription.id, s.payInvoice(subscription.id, "subscription_create", now)
+}
+
+// RenewSubscription charges the next period of the subscription
+func (s *FakeStripeServer) RenewSubscription(subscriptionID string) error {
+	subscription, err := s.subscription(subscriptionID)
+	if err != nil {
+		return err
+	}
+	if subscription.deleted {
+		return fmt.Errorf("subscription %q has been cancelled", subscriptionID)
+	}
+	return s.payInvoice(subscription.id, "subscription_cycle", subscription.periodEnd)
+}
+
+// FailRenewal declines the charge for the next period of the subscription. Stripe keeps retrying
+// it; a later RenewSubscription stands for a retry that succeeded.
+func (s *FakeStripeServer) FailRenewal(subscriptionID string) error {
+	subscription, err := s.subscription(subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	invoiceID := s.nextID("in")
+	s.mu.Unlock()
+
+	periodStart := subscription.periodEnd
+	return s.send("invoice.payment_failed", map[string]interface{}{
+		"id":             invoiceID,
+		"object":         "invoice",
+		"customer":       subscription.customer,
+		"subscription":   subscription.id,
+		"amount_due":     subscription.amount,
+		"amount_paid":    0,
+		"currency":       subscription.currency,
+		"billing_reason": "subscription_cycle",
+		"attempt_count":  1,
+		"subscription_details": map[string]interface{}{
+			"metadata": map[string]string{"userID": subscription.userID, "plan": subscription.plan},
+		},
+		"lines": map[string]interface{}{
+			"data": []map[string]interface{}{
+				{"period": map[string]int64{"start": periodStart.Unix(), "end": periodStart.AddDate(0, subscription.periodMonths, 0).Unix()}},
+			},
+		},
+	})
+}
+
+// CancelSubscription sets the subscription to end with the current period
+func (s *FakeStripeServer) CancelSubscription(subscriptionID string) error {
+	subscription, err := s.subscription(subscriptionID)
+	if err != nil {
+		return err
+	}
+	return s.send("customer.subscription.updated", map[string]interface{}{
+		"id":                   subscription.id,
+		"object":               "subscription",
+		"customer":             subscription.customer,
+		"cancel_at_period_end": true,
+		"current_period_end":   subscription.periodEnd.Unix(),
+		"metadata":             map[string]string{"userID": subscription.userID, "plan": subscription.plan},
+	})
+}
+
+// EndSubscription ends the subscription immediately
+func (s *FakeStripeServer) EndSubscription(subscriptionID string) error {
+	subscription, err := s.subscription(subscriptionID)
+	if err != nil {
+		return err
+	}
+	return s.send("customer.subscription.deleted", map[string]interface{}{
+		"id":       subscription.id,
+		"object":   "subscription",
+		"customer": subscription.customer,
+		"metadata": map[string]string{"userID": subscription.userID, "plan": subscription.plan},
+	})
+}
+
+// RefundLastCharge refunds the latest charge of the subscription in full
+func (s *FakeStripeServer) RefundLastCharge(subscriptionID string) error {
+	subscription, err := s.subscription(subscriptionID)
+	if err != nil {
+		return err
+	}
+	return s.send("charge.refunded", map[string]interface{}{
+		"id":              subscription.lastCharge,
+		"object":          "charge",
+		"customer":        subscription.customer,
+		"amount":          subscription.amount,
+		"amount_refunded": subscription.amount,
+		"currency":        subscription.currency,
+		"refunded":        true,
+	})
+}
+
+// RefundPayment refunds a one-off payment in full
+func (s *FakeStripeServer) RefundPayment(paymentID string) error {
+	s.mu.Lock()
+	payment, ok := s.payments[paymentID]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown payment %q", paymentID)
+	}
+	return s.send("charge.refunded", map[string]interface{}{
+		"id":              payment.charge,
+		"object":          "charge",
+		"customer":        payment.customer,
+		"payment_intent":  payment.id,
+		"amount":          payment.amount,
+		"amount_refunded": payment.amount,
+		"currency":        payment.currency,
+		"refunded":        true,
+	})
+}
+
+// DisputeLastCharge opens a chargeback on the latest charge of the subscription
+func (s *FakeStripeServer) DisputeLastCharge(subscriptionID string) error {
+	subscription, err := s.subscription(subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	disputeID := s.nextID("dp")
+	s.mu.Unlock()
+
+	return s.send("charge.dispute.created", map[string]interface{}{
+		"id":       disputeID,
+		"object":   "dispute",
+		"charge":   subscription.lastCharge,
+		"amount":   subscription.amount,
+		"currency": subscription.currency,
+		"reason":   "fraudulent",
+	})
+}
+
+// Deliveries returns every webhook sent so far, oldest first
+func (s *FakeStripeServer) Deliveries() []FakeWebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]FakeWebhookDelivery(nil), s.deliveries...)
+}
+
+// payInvoice charges one period of the subscription starting at periodStart
+func (s *FakeStripeServer) payInvoice(subscriptionID, reason string, periodStart time.Time) error {
+	s.mu.Lock()
+	subscription := s.subscriptions[subscriptionID]
+	invoiceID, chargeID := s.nextID("in"), s.nextID("ch")
+	periodEnd := periodStart.AddDate(0, subscription.periodMonths, 0)
+	subscription.periodEnd = periodEnd
+	subscription.lastCharge = chargeID
+	snapshot := *subscription
+	s.mu.Unlock()
+	subscription = &snapshot
+
+	return s.send("invoice.paid", map[string]interface{}{
+		"id":             invoiceID,
+		"object":         "invoice",
+		"customer":       subscription.customer,
+		"subscription":   subscription.id,
+		"charge":         chargeID,
+		"amount_paid":    subscription.amount,
+		"currency":       subscription.currency,
+		"billing_reason": reason,
+		"subscription_details": map[string]interface{}{
+			"metadata": map[string]string{"userID": subscription.userID, "plan": subscription.plan},
+		},
+		"lines": map[string]interface{}{
+			"data": []map[string]interface{}{
+				{"period": map[string]int64{"start": periodStart.Unix(), "end": periodEnd.Unix()}},
+			},
+		},
+	})
+}
+
+// send wraps the object in a signed event and delivers it
+func (s *FakeStripeServer) send(eventType string, object map[string]interface{}) error {
+	s.mu.Lock()
+	eventID := s.nextID("evt")
+	s.mu.Unlock()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":      eventID,
+		"object":  "event",
+		"type":    eventType,
+		"created": s.now().Unix(),
+		"data":    map[string]interface{}{"object": object},
+	})
+	if err != nil {
+		return err
+	}
+	signature := SignStripePayload(payload, s.secret, s.now())
+
+	s.mu.Lock()
+	s.deliveries = append(s.deliveries, FakeWebhookDelivery{Type: eventType, Payload: payload, Signature: signature})
+	s.mu.Unlock()
+
+	return s.Deliver(payload, signature)
+}
+
+func (s *FakeStripeServer) post(payload []byte, signature string) error {
+	req, err := http.NewRequest(http.MethodPost, s.webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(StripeSignatureHeader, signature)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook delivery failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// subscription returns a copy of the subscription, safe to read without the lock
+func (s *FakeStripeServer) subscription(subscriptionID string) (*fakeSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		return nil, fmt.Errorf("unknown subscription %q", subscriptionID)
+	}
+	snapshot := *subscription
+	return &snapshot, nil
+}
+
+// nextID returns a new Stripe-style ID; callers hold the lock
+func (s *FakeStripeServer) nextID(prefix string) string {
+	s.sequence++
+	return fmt.Sprintf("%s_fake%06d", prefix, s.sequence)
+}
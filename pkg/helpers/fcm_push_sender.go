@@ -0,0 +1,206 @@
+// helpers/fcm_push_sender.go
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+)
+
+// fcmScope is the OAuth scope for sending messages through FCM
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCMConfig holds the settings for Firebase Cloud Messaging, taken from a service account
+type FCMConfig struct {
+	ProjectID   string
+	ClientEmail string
+	PrivateKey  string // PEM encoded RSA key of the service account
+	TokenURL    string // defaults to https://oauth2.googleapis.com/token
+	APIBase     string // defaults to https://fcm.googleapis.com
+}
+
+// FCMConfigFromServiceAccount reads the config from a service account JSON key file
+func FCMConfigFromServiceAccount(serviceAccount []byte) (FCMConfig, error) {
+	var key struct {
+		ProjectID   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(serviceAccount, &key); err != nil {
+		return FCMConfig{}, fmt.Errorf("invalid FCM service account: %w", err)
+	}
+	return FCMConfig{
+		ProjectID:   key.ProjectID,
+		ClientEmail: key.ClientEmail,
+		PrivateKey:  key.PrivateKey,
+		TokenURL:    key.TokenURI,
+	}, nil
+}
+
+// FCMPushSender is a PushSender for Android devices backed by the FCM HTTP v1 API
+type FCMPushSender struct {
+	config FCMConfig
+	key    *rsa.PrivateKey
+	client *http.Client
+	now    func() time.Time
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewFCMPushSender creates a new FCMPushSender
+func NewFCMPushSender(config FCMConfig) (*FCMPushSender, error) {
+	if config.TokenURL == "" {
+		config.TokenURL = "https://oauth2.googleapis.com/token"
+	}
+	if config.APIBase == "" {
+		config.APIBase = "https://fcm.googleapis.com"
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(config.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FCM private key: %w", err)
+	}
+	return &FCMPushSender{
+		config: config,
+		key:    key,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}, nil
+}
+
+func (s *FCMPushSender) Platform() string {
+	return models.DevicePlatformAndroid
+}
+
+// fcmError is the error body of the FCM API
+type fcmError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// Send sends the message to the device. Tokens FCM reports as unregistered are invalid.
+func (s *FCMPushSender) Send(ctx context.Context, message PushMessage) error {
+	accessToken, err := s.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        message.Token,
+			"notification": map[string]string{"title": message.Title, "body": message.Body},
+			"data":         message.Data,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/projects/%s/messages:send", strings.TrimSuffix(s.config.APIBase, "/"), url.PathEscape(s.config.ProjectID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var failure fcmError
+	_ = json.Unmarshal(raw, &failure)
+	for _, detail := range failure.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" {
+			return fmt.Errorf("fcm: %w", ErrInvalidDeviceToken)
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("fcm: %w", ErrInvalidDeviceToken)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		s.mu.Lock()
+		s.accessToken = ""
+		s.mu.Unlock()
+	}
+	return fmt.Errorf("fcm send failed with status %d: %s", resp.StatusCode, strings.TrimSpace(failure.Error.Message))
+}
+
+// token returns an OAuth access token for FCM, exchanging a signed service account assertion for
+// a new one shortly before the current one expires
+func (s *FCMPushSender) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.accessToken != "" && now.Before(s.expiresAt.Add(-time.Minute)) {
+		return s.accessToken, nil
+	}
+
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   s.config.ClientEmail,
+		"scope": fcmScope,
+		"aud":   s.config.TokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(s.key)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("fcm token exchange failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var grant struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&grant); err != nil {
+		return "", err
+	}
+	s.accessToken = grant.AccessToken
+	s.expiresAt = now.Add(time.Duration(grant.ExpiresIn) * time.Second)
+	return s.accessToken, nil
+}
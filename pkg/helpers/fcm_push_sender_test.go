@@ -0,0 +1,169 @@
+// helpers/fcm_push_sender_test.go
+package helpers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// fakeFCM is an FCM API and OAuth token endpoint. It answers a send by the device token: "ok" is
+// delivered, "unregistered" and "gone" are invalid tokens and anything else is unavailable.
+type fakeFCM struct {
+	key       *rsa.PublicKey
+	server    *httptest.Server
+	exchanges int
+	revoked   bool
+}
+
+func newFakeFCM(t *testing.T) (*fakeFCM, *FCMPushSender, *time.Time) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	fake := &fakeFCM{key: &key.PublicKey}
+	fake.server = httptest.NewServer(fake)
+	t.Cleanup(fake.server.Close)
+
+	sender, err := NewFCMPushSender(FCMConfig{
+		ProjectID:   "knoxs",
+		ClientEmail: "push@knoxs.iam.gserviceaccount.com",
+		PrivateKey:  string(privateKey),
+		TokenURL:    fake.server.URL + "/token",
+		APIBase:     fake.server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	sender.now = func() time.Time { return now }
+	return fake, sender, &now
+}
+
+func (f *fakeFCM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/token":
+		// The assertion is signed by the service account for the FCM scope; its times follow the
+		// sender's clock, not this one
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			http.Error(w, "unsupported grant", http.StatusBadRequest)
+			return
+		}
+		claims := jwt.MapClaims{}
+		parser := &jwt.Parser{ValidMethods: []string{"RS256"}, SkipClaimsValidation: true}
+		if _, err := parser.ParseWithClaims(r.FormValue("assertion"), claims, func(token *jwt.Token) (interface{}, error) {
+			return f.key, nil
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if claims["iss"] != "push@knoxs.iam.gserviceaccount.com" || claims["scope"] != fcmScope || claims["aud"] != f.server.URL+"/token" {
+			http.Error(w, "invalid assertion", http.StatusUnauthorized)
+			return
+		}
+		f.exchanges++
+		f.revoked = false
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": fmt.Sprintf("access-%d", f.exchanges), "expires_in": 3600})
+
+	case "/v1/projects/knoxs/messages:send":
+		if r.Header.Get("Authorization") != fmt.Sprintf("Bearer access-%d", f.exchanges) || f.revoked {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": {"code": 401, "message": "Request had invalid authentication credentials.", "status": "UNAUTHENTICATED"}}`)
+			return
+		}
+		var body struct {
+			Message struct {
+				Token        string            `json:"token"`
+				Notification map[string]string `json:"notification"`
+			} `json:"message"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		switch body.Message.Token {
+		case "ok":
+			if body.Message.Notification["title"] != "Matched" {
+				http.Error(w, "missing notification", http.StatusBadRequest)
+			}
+		case "unregistered":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": {"code": 400, "message": "Requested entity was not found.", "status": "INVALID_ARGUMENT",
+				"details": [{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "UNREGISTERED"}]}}`)
+		case "gone":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"code": 404, "message": "Requested entity was not found.", "status": "NOT_FOUND"}}`)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"error": {"code": 503, "message": "The service is currently unavailable.", "status": "UNAVAILABLE"}}`)
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestFCMPushSender_MapsTheResponses(t *testing.T) {
+	_, sender, _ := newFakeFCM(t)
+	message := PushMessage{Title: "Matched", Body: "You matched with user2!"}
+
+	cases := []struct {
+		token   string
+		invalid bool
+		ok      bool
+	}{
+		{token: "ok", ok: true},
+		{token: "unregistered", invalid: true},
+		{token: "gone", invalid: true},
+		{token: "busy"},
+	}
+	for _, c := range cases {
+		message.Token = c.token
+		err := sender.Send(context.Background(), message)
+		if c.ok != (err == nil) || c.invalid != errors.Is(err, ErrInvalidDeviceToken) {
+			t.Errorf("%s: unexpected error %v", c.token, err)
+		}
+	}
+}
+
+func TestFCMPushSender_ReusesTheAccessTokenUntilItExpires(t *testing.T) {
+	fake, sender, now := newFakeFCM(t)
+	message := PushMessage{Token: "ok", Title: "Matched"}
+
+	for i := 0; i < 3; i++ {
+		if err := sender.Send(context.Background(), message); err != nil {
+			t.Fatalf("send failed: %v", err)
+		}
+	}
+	if fake.exchanges != 1 {
+		t.Fatalf("expected one token exchange, got %d", fake.exchanges)
+	}
+
+	// A minute before it expires, the token is exchanged for a new one
+	*now = now.Add(59 * time.Minute)
+	if err := sender.Send(context.Background(), message); err != nil || fake.exchanges != 2 {
+		t.Fatalf("expected a new token, got %d exchanges (%v)", fake.exchanges, err)
+	}
+
+	// A token FCM no longer accepts fails the send and is exchanged on the next one
+	fake.revoked = true
+	if err := sender.Send(context.Background(), message); err == nil || errors.Is(err, ErrInvalidDeviceToken) || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected the send to fail for the credentials, got %v", err)
+	}
+	if err := sender.Send(context.Background(), message); err != nil || fake.exchanges != 3 {
+		t.Fatalf("expected the token exchanged again, got %d exchanges (%v)", fake.exchanges, err)
+	}
+}
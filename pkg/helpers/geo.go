@@ -0,0 +1,38 @@
+// helpers/geo.go
+package helpers
+
+import (
+	"math"
+	"strconv"
+)
+
+// EarthRadiusKm is the mean Earth radius used for distance calculations
+const EarthRadiusKm = 6371.0
+
+// HaversineDistance returns the great-circle distance in kilometres between two coordinates
+func HaversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := radians(lat2 - lat1)
+	dLon := radians(lon2 - lon1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(radians(lat1))*math.Cos(radians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return EarthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// distanceBuckets are the upper bounds, in kilometres, of the distances shown to other users
+var distanceBuckets = []float64{1, 5, 10, 25, 50, 100}
+
+// DistanceBucket describes a distance coarsely so exact positions are never revealed
+func DistanceBucket(km float64) string {
+	for _, limit := range distanceBuckets {
+		if km < limit {
+			return "Less than " + strconv.FormatFloat(limit, 'f', -1, 64) + " km away"
+		}
+	}
+	return "More than " + strconv.FormatFloat(distanceBuckets[len(distanceBuckets)-1], 'f', -1, 64) + " km away"
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
@@ -0,0 +1,225 @@
+// helpers/idempotency.go
+package helpers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// IdempotencyKeyHeader is the request header a client sets to make a retried request safe
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set on responses replayed from an earlier request with the same key
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+const (
+	// idempotencyTTL is how long a response is kept for replaying
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyLockTTL bounds how long a request holds its key after its instance stops renewing
+	// it, e.g. because it died; the lock is renewed every third of it while the request runs
+	idempotencyLockTTL = 30 * time.Second
+	// idempotencyWait is how long a duplicate waits for the request holding its key to finish
+	idempotencyWait = 10 * time.Second
+	// idempotencyPollInterval is how often a waiting duplicate checks the key again
+	idempotencyPollInterval = 100 * time.Millisecond
+	// maxIdempotencyKeyLength bounds the keys clients may send
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotentResponse is the response stored for replaying, with a fingerprint of the request that
+// produced it
+type idempotentResponse struct {
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// IdempotencyMiddleware makes POST, PUT and DELETE requests that carry an Idempotency-Key header
+// safe to retry. The first response for a user's key is stored in Redis for 24 hours and replayed,
+// status and body, to any request repeating the key. A duplicate arriving while the first request
+// is still running waits for it to finish. Reusing a key for a different request is rejected with
+// 422, and server errors are not stored so that a retry can succeed.
+//
+// Keys are scoped to the user of the bearer token; requests without a valid token are passed on
+// untouched and left to the handler to reject. The body is read to fingerprint the request, so
+// bodies over maxBodyBytes are rejected with 413 before anything is buffered past the limit.
+func IdempotencyMiddleware(redis RedisHandler, maxBodyBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodDelete) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				SendJSONResponse(w, http.StatusBadRequest, GenerateResponse(false, http.StatusBadRequest, "Invalid idempotency key", nil, fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength)))
+				return
+			}
+
+			claims, err := ReadAndDecryptToken(r.Header.Get("Authorization"))
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			userID, ok := claims[UserIDKey].(float64)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+			r.Body.Close()
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				SendJSONResponse(w, http.StatusRequestEntityTooLarge, GenerateResponse(false, http.StatusRequestEntityTooLarge, "Request body too large", nil, fmt.Sprintf("requests with an %s must be at most %d bytes", IdempotencyKeyHeader, maxBodyBytes)))
+				return
+			}
+			if err != nil {
+				SendJSONResponse(w, http.StatusBadRequest, GenerateResponse(false, http.StatusBadRequest, "Invalid request body", nil, err.Error()))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			storeKey := fmt.Sprintf("idempotency:%d:%s", int(userID), key)
+			fingerprint := requestFingerprint(r, body)
+			token := lockToken()
+
+			// Serialise requests with the same key: the first one runs, the others wait and replay
+			// its response
+			deadline := time.Now().Add(idempotencyWait)
+			for {
+				var stored idempotentResponse
+				if err := redis.Get(storeKey, &stored); err == nil {
+					replayIdempotentResponse(w, &stored, fingerprint)
+					return
+				}
+
+				held, err := redis.AcquireLock("lock:"+storeKey, token, idempotencyLockTTL)
+				if err != nil {
+					SendJSONResponse(w, http.StatusServiceUnavailable, GenerateResponse(false, http.StatusServiceUnavailable, "Error checking idempotency key", nil, err.Error()))
+					return
+				}
+				if held {
+					break
+				}
+				if time.Now().After(deadline) {
+					SendJSONResponse(w, http.StatusConflict, GenerateResponse(false, http.StatusConflict, "A request with this idempotency key is still in progress", nil, ""))
+					return
+				}
+				time.Sleep(idempotencyPollInterval)
+			}
+			stopRenewing := renewLock(redis, "lock:"+storeKey, token, idempotencyLockTTL)
+			defer func() {
+				stopRenewing()
+				if err := redis.ReleaseLock("lock:"+storeKey, token); err != nil {
+					log.Printf("Error releasing idempotency key %s: %v", storeKey, err)
+				}
+			}()
+
+			// A request that finished between the last check and taking the lock stored its response
+			var stored idempotentResponse
+			if err := redis.Get(storeKey, &stored); err == nil {
+				replayIdempotentResponse(w, &stored, fingerprint)
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			if recorder.status >= http.StatusInternalServerError {
+				return
+			}
+			stored = idempotentResponse{
+				Fingerprint: fingerprint,
+				Status:      recorder.status,
+				Header:      http.Header{"Content-Type": w.Header().Values("Content-Type")},
+				Body:        recorder.body.Bytes(),
+			}
+			if err := redis.Set(storeKey, stored, idempotencyTTL); err != nil {
+				log.Printf("Error storing response for idempotency key %s: %v", storeKey, err)
+			}
+		})
+	}
+}
+
+// renewLock extends the held lock by ttl every third of ttl until the returned function is called,
+// so a slow request keeps its key for as long as it runs
+func renewLock(redis RedisHandler, key, token string, ttl time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if held, err := redis.AcquireLock(key, token, ttl); err != nil || !held {
+					log.Printf("Error renewing lock %s: held %v, %v", key, held, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// requestFingerprint identifies the request a key was first used for
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// replayIdempotentResponse writes the stored response, unless the key was used for another request
+func replayIdempotentResponse(w http.ResponseWriter, stored *idempotentResponse, fingerprint string) {
+	if stored.Fingerprint != fingerprint {
+		SendJSONResponse(w, http.StatusUnprocessableEntity, GenerateResponse(false, http.StatusUnprocessableEntity, "Idempotency key reused for a different request", nil, ""))
+		return
+	}
+	for name, values := range stored.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(stored.Status)
+	if _, err := w.Write(stored.Body); err != nil {
+		log.Printf("Error replaying idempotent response: %v", err)
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy of its status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
@@ -0,0 +1,202 @@
+// helpers/idempotency_test.go
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+)
+
+// newMemoryRedis returns a RedisHandler keeping values and locks in memory, without expiry
+func newMemoryRedis() *mocks.MockRedisHandler {
+	var mu sync.Mutex
+	values := make(map[string][]byte)
+	locks := make(map[string]string)
+	return &mocks.MockRedisHandler{
+		GetFunc: func(key string, dest interface{}) error {
+			mu.Lock()
+			defer mu.Unlock()
+			value, ok := values[key]
+			if !ok {
+				return errors.New("redis: nil")
+			}
+			return json.Unmarshal(value, dest)
+		},
+		SetFunc: func(key string, value interface{}, expiration time.Duration) error {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			values[key] = encoded
+			return nil
+		},
+		AcquireLockFunc: func(key, token string, ttl time.Duration) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if holder, ok := locks[key]; ok && holder != token {
+				return false, nil
+			}
+			locks[key] = token
+			return true, nil
+		},
+		ReleaseLockFunc: func(key, token string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if locks[key] == token {
+				delete(locks, key)
+			}
+			return nil
+		},
+	}
+}
+
+func idempotentRequest(t *testing.T, token, key, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/swipes", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(IdempotencyKeyHeader, key)
+	return req
+}
+
+func TestIdempotencyMiddleware_ReplaysTheFirstResponse(t *testing.T) {
+	token, err := GenerateToken(models.User{UserID: 5})
+	if err != nil {
+		t.Fatalf("token failed: %v", err)
+	}
+
+	var calls int32
+	release := make(chan struct{})
+	handler := IdempotencyMiddleware(newMemoryRedis(), 1<<10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		<-release
+		SendJSONResponse(w, http.StatusCreated, GenerateResponse(true, http.StatusCreated, "created", n, nil))
+	}))
+
+	// Concurrent duplicates run the handler once and all get its response
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 3)
+	for i := range responses {
+		responses[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			handler.ServeHTTP(w, idempotentRequest(t, token, "swipe-1", `{"swipedUserID":7}`))
+		}(responses[i])
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("expected the handler to run once, ran %d times", calls)
+	}
+	replayed := 0
+	for _, w := range responses {
+		if w.Code != http.StatusCreated || w.Body.String() != responses[0].Body.String() {
+			t.Fatalf("expected every response to be the original, got %d %s", w.Code, w.Body.String())
+		}
+		if w.Header().Get(IdempotentReplayedHeader) == "true" {
+			replayed++
+		}
+	}
+	if replayed != 2 {
+		t.Fatalf("expected 2 replayed responses, got %d", replayed)
+	}
+
+	// The same key for a different request is rejected
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, idempotentRequest(t, token, "swipe-1", `{"swipedUserID":8}`))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a reused key, got %d", w.Code)
+	}
+
+	// Keys belong to the user: another user's request with the same key runs
+	other, _ := GenerateToken(models.User{UserID: 6})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, idempotentRequest(t, other, "swipe-1", `{"swipedUserID":7}`))
+	if w.Code != http.StatusCreated || calls != 2 {
+		t.Fatalf("expected another user's request to run, got %d after %d calls", w.Code, calls)
+	}
+}
+
+func TestIdempotencyMiddleware_DoesNotStoreServerErrors(t *testing.T) {
+	token, _ := GenerateToken(models.User{UserID: 5})
+
+	var calls int32
+	handler := IdempotencyMiddleware(newMemoryRedis(), 1<<10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			SendJSONResponse(w, http.StatusInternalServerError, GenerateResponse(false, http.StatusInternalServerError, "failed", nil, ""))
+			return
+		}
+		SendJSONResponse(w, http.StatusOK, GenerateResponse(true, http.StatusOK, "ok", nil, nil))
+	}))
+
+	for _, want := range []int{http.StatusInternalServerError, http.StatusOK, http.StatusOK} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, idempotentRequest(t, token, "checkout-1", `{}`))
+		if w.Code != want {
+			t.Fatalf("expected %d, got %d", want, w.Code)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expected the retry after the error to run and later ones to replay, got %d calls", calls)
+	}
+}
+
+func TestIdempotencyMiddleware_RejectsBodiesOverTheLimit(t *testing.T) {
+	token, _ := GenerateToken(models.User{UserID: 5})
+
+	var calls int32
+	handler := IdempotencyMiddleware(newMemoryRedis(), 16)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		SendJSONResponse(w, http.StatusOK, GenerateResponse(true, http.StatusOK, "ok", nil, nil))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, idempotentRequest(t, token, "upload-1", strings.Repeat("x", 17)))
+	if w.Code != http.StatusRequestEntityTooLarge || calls != 0 {
+		t.Fatalf("expected 413 without running the handler, got %d after %d calls", w.Code, calls)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, idempotentRequest(t, token, "upload-1", strings.Repeat("x", 16)))
+	if w.Code != http.StatusOK || calls != 1 {
+		t.Fatalf("expected a body at the limit to run, got %d after %d calls", w.Code, calls)
+	}
+}
+
+func TestRenewLock_ExtendsTheLockUntilStopped(t *testing.T) {
+	var renewals int32
+	redis := &mocks.MockRedisHandler{
+		AcquireLockFunc: func(key, token string, ttl time.Duration) (bool, error) {
+			if key != "lock:k" || token != "me" || ttl != 30*time.Millisecond {
+				t.Errorf("unexpected renewal of %s by %s for %v", key, token, ttl)
+			}
+			atomic.AddInt32(&renewals, 1)
+			return true, nil
+		},
+	}
+
+	stop := renewLock(redis, "lock:k", "me", 30*time.Millisecond)
+	time.Sleep(55 * time.Millisecond)
+	stop()
+	renewed := atomic.LoadInt32(&renewals)
+	if renewed < 2 {
+		t.Fatalf("expected the lock to be renewed every 10ms, got %d renewals", renewed)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if got := atomic.LoadInt32(&renewals); got != renewed {
+		t.Fatalf("expected no renewals after stopping, got %d more", got-renewed)
+	}
+}
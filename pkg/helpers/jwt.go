@@ -20,9 +20,6 @@ const (
 	EmailKey              = "email"
 	VerificationStatusKey = "verificationStatus"
 	VerificationBadgeKey  = "verificationBadge"
-	PremiumStatusKey      = "premiumStatus"
-	PremiumStartDateKey   = "premiumStartDate"
-	PremiumEndDateKey     = "premiumEndDate"
 	GenderKey             = "gender"
 	CompanyKey            = "company"
 	SchoolKey             = "school"
@@ -38,9 +35,6 @@ func GenerateToken(user models.User) (string, error) {
 		EmailKey:              user.Email,
 		VerificationStatusKey: user.VerificationStatus,
 		VerificationBadgeKey:  user.VerificationBadge,
-		PremiumStatusKey:      user.PremiumStatus,
-		PremiumStartDateKey:   user.PremiumStartDate,
-		PremiumEndDateKey:     user.PremiumEndDate,
 		GenderKey:             user.Gender,
 		CompanyKey:            user.Company,
 		SchoolKey:             user.School,
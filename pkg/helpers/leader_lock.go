@@ -0,0 +1,56 @@
+// helpers/leader_lock.go
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"time"
+)
+
+// LeaderLock elects one instance out of several to run a background job. The leader holds a Redis
+// key that expires unless it keeps renewing it, so another instance takes over if it dies.
+type LeaderLock struct {
+	redis RedisHandler
+	key   string
+	token string
+	ttl   time.Duration
+}
+
+// NewLeaderLock creates a LeaderLock on the given Redis key. The lease lasts ttl from each renewal.
+func NewLeaderLock(redis RedisHandler, key string, ttl time.Duration) *LeaderLock {
+	return &LeaderLock{
+		redis: redis,
+		key:   key,
+		token: lockToken(),
+		ttl:   ttl,
+	}
+}
+
+// Hold takes or renews the leadership and reports whether this instance is the leader
+func (l *LeaderLock) Hold() bool {
+	held, err := l.redis.AcquireLock(l.key, l.token, l.ttl)
+	if err != nil {
+		log.Printf("Error acquiring lock %s: %v", l.key, err)
+		return false
+	}
+	return held
+}
+
+// Release hands the leadership over to another instance
+func (l *LeaderLock) Release() {
+	if err := l.redis.ReleaseLock(l.key, l.token); err != nil {
+		log.Printf("Error releasing lock %s: %v", l.key, err)
+	}
+}
+
+// lockToken identifies this process as the holder of a lock
+func lockToken() string {
+	hostname, _ := os.Hostname()
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return hostname + "-" + time.Now().Format(time.RFC3339Nano)
+	}
+	return hostname + "-" + hex.EncodeToString(random)
+}
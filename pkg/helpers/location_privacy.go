@@ -0,0 +1,85 @@
+// helpers/location_privacy.go
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+	"math"
+	"os"
+	"strconv"
+)
+
+const (
+	// LocationFuzzRadiusEnv overrides DefaultLocationFuzzRadiusKm
+	LocationFuzzRadiusEnv = "LOCATION_FUZZ_RADIUS_KM"
+	// DefaultLocationFuzzRadiusKm is how precisely, at best, a stored location can be recovered
+	DefaultLocationFuzzRadiusKm = 1.0
+	// LocationFuzzKeyEnv names the private key each user's fuzzing grid is derived from. It must
+	// differ from the other keys so a leaked link or token key does not reveal the grids.
+	LocationFuzzKeyEnv = "LOCATION_FUZZ_KEY"
+)
+
+// kmPerDegreeLatitude is the length of one degree of latitude
+const kmPerDegreeLatitude = EarthRadiusKm * math.Pi / 180
+
+var locationFuzzRadiusKm = loadLocationFuzzRadius()
+
+func loadLocationFuzzRadius() float64 {
+	value := os.Getenv(LocationFuzzRadiusEnv)
+	if value == "" {
+		return DefaultLocationFuzzRadiusKm
+	}
+
+	radius, err := strconv.ParseFloat(value, 64)
+	if err != nil || radius <= 0 {
+		log.Printf("Invalid %s %q, using %v km", LocationFuzzRadiusEnv, value, DefaultLocationFuzzRadiusKm)
+		return DefaultLocationFuzzRadiusKm
+	}
+	return radius
+}
+
+// LocationFuzzRadiusKm returns the configured fuzzing radius
+func LocationFuzzRadiusKm() float64 {
+	return locationFuzzRadiusKm
+}
+
+// FuzzLocation coarsens a reported position before it is stored, using the configured radius
+func FuzzLocation(userID int, lat, lon float64) (float64, float64) {
+	return FuzzLocationWithRadius(userID, lat, lon, locationFuzzRadiusKm)
+}
+
+// FuzzLocationWithRadius snaps the position to the centre of a grid cell whose sides are twice
+// the radius. Each user's grid is shifted by a stable secret offset, so cell boundaries cannot be
+// guessed, while every position inside a cell is stored identically: repeated reports cannot be
+// averaged and distances measured to the stored point cannot locate the user more precisely
+// than the cell.
+func FuzzLocationWithRadius(userID int, lat, lon, radiusKm float64) (float64, float64) {
+	cellKm := 2 * radiusKm
+	latOffset, lonOffset := locationGridOffset(userID)
+
+	latCell := cellKm / kmPerDegreeLatitude
+	row := math.Floor((lat+90)/latCell - latOffset)
+	fuzzedLat := -90 + (row+latOffset+0.5)*latCell
+	fuzzedLat = math.Max(-90, math.Min(90, fuzzedLat))
+
+	// Longitude cells are sized for the row's latitude so they stay roughly square
+	lonCell := math.Min(cellKm/(kmPerDegreeLatitude*math.Max(math.Cos(radians(fuzzedLat)), 0.01)), 360)
+	col := math.Floor((lon+180)/lonCell - lonOffset)
+	fuzzedLon := -180 + (col+lonOffset+0.5)*lonCell
+	fuzzedLon = math.Mod(fuzzedLon+540, 360) - 180
+
+	return fuzzedLat, fuzzedLon
+}
+
+// locationGridOffset derives the user's grid offset, as fractions of a cell, from the fuzzing key
+func locationGridOffset(userID int) (float64, float64) {
+	mac := hmac.New(sha256.New, []byte(os.Getenv(LocationFuzzKeyEnv)))
+	mac.Write([]byte("location-fuzz:" + strconv.Itoa(userID)))
+	sum := mac.Sum(nil)
+
+	latOffset := float64(binary.BigEndian.Uint64(sum[0:8])>>11) / (1 << 53)
+	lonOffset := float64(binary.BigEndian.Uint64(sum[8:16])>>11) / (1 << 53)
+	return latOffset, lonOffset
+}
@@ -0,0 +1,165 @@
+// helpers/location_privacy_test.go
+package helpers
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestFuzzLocationIsStable(t *testing.T) {
+	lat, lon := -6.2088, 106.8456
+
+	fuzzedLat, fuzzedLon := FuzzLocationWithRadius(7, lat, lon, 1)
+	for i := 0; i < 10; i++ {
+		againLat, againLon := FuzzLocationWithRadius(7, lat, lon, 1)
+		if againLat != fuzzedLat || againLon != fuzzedLon {
+			t.Fatalf("fuzzing is not stable: (%f, %f) then (%f, %f)", fuzzedLat, fuzzedLon, againLat, againLon)
+		}
+	}
+
+	// Other users have differently placed grids
+	differs := false
+	for userID := 8; userID < 20; userID++ {
+		otherLat, otherLon := FuzzLocationWithRadius(userID, lat, lon, 1)
+		if otherLat != fuzzedLat || otherLon != fuzzedLon {
+			differs = true
+		}
+	}
+	if !differs {
+		t.Error("expected users to have different grid offsets")
+	}
+}
+
+func TestFuzzLocationStaysNearby(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for _, radius := range []float64{0.5, 1, 5} {
+		for i := 0; i < 10000; i++ {
+			lat := rng.Float64()*160 - 80
+			lon := rng.Float64()*360 - 180
+			fuzzedLat, fuzzedLon := FuzzLocationWithRadius(rng.Intn(1000), lat, lon, radius)
+
+			// The stored point is the centre of a cell with sides of twice the radius
+			if distance := HaversineDistance(lat, lon, fuzzedLat, fuzzedLon); distance > radius*math.Sqrt2*1.01 {
+				t.Fatalf("(%f, %f) moved %f km with radius %v", lat, lon, distance, radius)
+			}
+		}
+	}
+}
+
+// TestTrilaterationCannotBeatFuzzRadius plays an attacker who spoofs their own location to many
+// vantage points around a victim and measures the distance to the victim from each. Even with
+// exact distances, rather than the buckets the API returns, the attacker recovers only the stored
+// cell centre: every position in the victim's cell produces the same observations, and some of
+// those positions are further than the radius from anything the attacker can compute.
+func TestTrilaterationCannotBeatFuzzRadius(t *testing.T) {
+	const radius = 1.0
+	const victimID = 42
+	victimLat, victimLon := -6.2088, 106.8456
+
+	storedLat, storedLon := FuzzLocationWithRadius(victimID, victimLat, victimLon, radius)
+
+	// Twelve vantage points on a 3 km circle around the victim, plus one on top of them
+	vantages := [][2]float64{{victimLat, victimLon}}
+	for i := 0; i < 12; i++ {
+		bearing := float64(i) * math.Pi / 6
+		vantages = append(vantages, [2]float64{
+			victimLat + 3/kmPerDegreeLatitude*math.Cos(bearing),
+			victimLon + 3/(kmPerDegreeLatitude*math.Cos(radians(victimLat)))*math.Sin(bearing),
+		})
+	}
+
+	observe := func(lat, lon float64) ([]float64, []string) {
+		fuzzedLat, fuzzedLon := FuzzLocationWithRadius(victimID, lat, lon, radius)
+		distances := make([]float64, len(vantages))
+		buckets := make([]string, len(vantages))
+		for i, vantage := range vantages {
+			distances[i] = HaversineDistance(vantage[0], vantage[1], fuzzedLat, fuzzedLon)
+			buckets[i] = DistanceBucket(distances[i])
+		}
+		return distances, buckets
+	}
+
+	distances, buckets := observe(victimLat, victimLon)
+	estimateLat, estimateLon := trilaterate(vantages, distances)
+
+	// With exact distances the attacker does find the stored point...
+	if miss := HaversineDistance(estimateLat, estimateLon, storedLat, storedLon); miss > 0.05 {
+		t.Fatalf("trilateration should recover the stored point, missed by %f km", miss)
+	}
+
+	// ...but the stored point is shared by every position in the victim's cell
+	worstError, cellSamples := 0.0, 0
+	for dy := -20; dy <= 20; dy++ {
+		for dx := -20; dx <= 20; dx++ {
+			lat := storedLat + float64(dy)*0.1*radius/kmPerDegreeLatitude
+			lon := storedLon + float64(dx)*0.1*radius/(kmPerDegreeLatitude*math.Cos(radians(storedLat)))
+			if cellLat, cellLon := FuzzLocationWithRadius(victimID, lat, lon, radius); cellLat != storedLat || cellLon != storedLon {
+				continue
+			}
+			cellSamples++
+
+			sampleDistances, sampleBuckets := observe(lat, lon)
+			for i := range vantages {
+				if sampleDistances[i] != distances[i] || sampleBuckets[i] != buckets[i] {
+					t.Fatalf("position (%f, %f) in the same cell is distinguishable from vantage %d", lat, lon, i)
+				}
+			}
+
+			if miss := HaversineDistance(lat, lon, estimateLat, estimateLon); miss > worstError {
+				worstError = miss
+			}
+		}
+	}
+
+	if cellSamples < 100 {
+		t.Fatalf("expected to sample the whole cell, got %d positions", cellSamples)
+	}
+	if worstError < radius {
+		t.Errorf("a victim anywhere in the cell should be up to at least %v km from the estimate, worst was %f km", radius, worstError)
+	}
+}
+
+// trilaterate finds the least-squares position matching the distances, on a local flat projection
+func trilaterate(points [][2]float64, distances []float64) (float64, float64) {
+	originLat, originLon := points[0][0], points[0][1]
+	kmPerDegreeLongitude := kmPerDegreeLatitude * math.Cos(radians(originLat))
+	project := func(lat, lon float64) (float64, float64) {
+		return (lon - originLon) * kmPerDegreeLongitude, (lat - originLat) * kmPerDegreeLatitude
+	}
+
+	// Subtracting the first circle equation from the others leaves a linear system A p = b
+	x0, y0 := project(points[0][0], points[0][1])
+	var ata [2][2]float64
+	var atb [2]float64
+	for i := 1; i < len(points); i++ {
+		xi, yi := project(points[i][0], points[i][1])
+		a := [2]float64{2 * (xi - x0), 2 * (yi - y0)}
+		b := xi*xi - x0*x0 + yi*yi - y0*y0 - distances[i]*distances[i] + distances[0]*distances[0]
+		for r := 0; r < 2; r++ {
+			for c := 0; c < 2; c++ {
+				ata[r][c] += a[r] * a[c]
+			}
+			atb[r] += a[r] * b
+		}
+	}
+
+	det := ata[0][0]*ata[1][1] - ata[0][1]*ata[1][0]
+	x := (atb[0]*ata[1][1] - atb[1]*ata[0][1]) / det
+	y := (atb[1]*ata[0][0] - atb[0]*ata[1][0]) / det
+	return originLat + y/kmPerDegreeLatitude, originLon + x/kmPerDegreeLongitude
+}
+
+func TestFuzzLocationGridDependsOnTheFuzzKey(t *testing.T) {
+	lat, lon := -6.2088, 106.8456
+
+	t.Setenv(LocationFuzzKeyEnv, "first-fuzz-key")
+	firstLat, firstLon := FuzzLocationWithRadius(7, lat, lon, 1)
+	t.Setenv(LocationFuzzKeyEnv, "second-fuzz-key")
+	secondLat, secondLon := FuzzLocationWithRadius(7, lat, lon, 1)
+
+	// The grid is only as secret as its key
+	if firstLat == secondLat && firstLon == secondLon {
+		t.Errorf("expected another key to move the grid, got (%f, %f) both times", firstLat, firstLon)
+	}
+}
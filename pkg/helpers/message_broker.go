@@ -0,0 +1,157 @@
+// helpers/message_broker.go
+package helpers
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrUnknownQueue is returned when consuming a queue the broker was not configured with
+var ErrUnknownQueue = errors.New("unknown queue")
+
+// BrokerMessage is a message published to or delivered by the message broker. ID is unique per
+// message, so consumers can recognise redeliveries, and Type names what the body holds.
+// Attempts counts the deliveries to the consumer, including this one.
+type BrokerMessage struct {
+	ID       string
+	Type     string
+	Body     []byte
+	Attempts int
+}
+
+// MessageHandler handles a delivered message. Returning an error has the message delivered again
+// later, until the broker gives up and dead-letters it.
+type MessageHandler func(message BrokerMessage) error
+
+// MessageBroker delivers every published message to each of its queues
+type MessageBroker interface {
+	// Publish returns once the broker has safely stored the message
+	Publish(ctx context.Context, message BrokerMessage) error
+	// Consume handles the messages of the queue, one at a time, until the context is cancelled or
+	// the connection to the broker is lost
+	Consume(ctx context.Context, queue string, handler MessageHandler) error
+	Close() error
+}
+
+// MemoryBroker is a MessageBroker keeping its queues in memory, for development and tests. Like
+// RabbitMQBroker, it retries a failing message up to MaxDeliveries times and then dead-letters it.
+type MemoryBroker struct {
+	MaxDeliveries int
+
+	mu        sync.Mutex
+	queues    map[string][]BrokerMessage
+	dead      map[string][]BrokerMessage
+	published []BrokerMessage
+	notify    chan struct{}
+}
+
+// NewMemoryBroker creates a MemoryBroker with the given queues
+func NewMemoryBroker(maxDeliveries int, queues ...string) *MemoryBroker {
+	broker := &MemoryBroker{
+		MaxDeliveries: maxDeliveries,
+		queues:        make(map[string][]BrokerMessage, len(queues)),
+		dead:          make(map[string][]BrokerMessage),
+		notify:        make(chan struct{}),
+	}
+	for _, queue := range queues {
+		broker.queues[queue] = nil
+	}
+	return broker
+}
+
+// Publish adds the message to every queue
+func (b *MemoryBroker) Publish(ctx context.Context, message BrokerMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	message.Attempts = 0
+	b.published = append(b.published, message)
+	for queue := range b.queues {
+		b.queues[queue] = append(b.queues[queue], message)
+	}
+	b.wake()
+	return nil
+}
+
+// Consume handles the messages of the queue as they arrive until the context is cancelled
+func (b *MemoryBroker) Consume(ctx context.Context, queue string, handler MessageHandler) error {
+	for {
+		b.mu.Lock()
+		notify := b.notify
+		b.mu.Unlock()
+
+		if _, err := b.Drain(queue, handler); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-notify:
+		}
+	}
+}
+
+// Drain handles the messages waiting in the queue, including retries of those that fail, until it
+// is empty, and returns how many deliveries it made
+func (b *MemoryBroker) Drain(queue string, handler MessageHandler) (int, error) {
+	deliveries := 0
+	for {
+		b.mu.Lock()
+		waiting, ok := b.queues[queue]
+		if !ok {
+			b.mu.Unlock()
+			return deliveries, ErrUnknownQueue
+		}
+		if len(waiting) == 0 {
+			b.mu.Unlock()
+			return deliveries, nil
+		}
+		message := waiting[0]
+		b.queues[queue] = waiting[1:]
+		b.mu.Unlock()
+
+		message.Attempts++
+		deliveries++
+		err := handler(message)
+
+		if err != nil {
+			b.mu.Lock()
+			if message.Attempts < b.MaxDeliveries {
+				b.queues[queue] = append(b.queues[queue], message)
+			} else {
+				b.dead[queue] = append(b.dead[queue], message)
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// Published returns the messages published so far
+func (b *MemoryBroker) Published() []BrokerMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]BrokerMessage(nil), b.published...)
+}
+
+// DeadLetters returns the messages of the queue that were given up on
+func (b *MemoryBroker) DeadLetters(queue string) []BrokerMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]BrokerMessage(nil), b.dead[queue]...)
+}
+
+// Close does nothing; a MemoryBroker holds no connections
+func (b *MemoryBroker) Close() error {
+	return nil
+}
+
+// wake tells consumers waiting for messages that some have arrived; the lock must be held
+func (b *MemoryBroker) wake() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
@@ -11,6 +11,14 @@ type MockRedisHandler struct {
 	SetFunc    func(key string, value interface{}, expiration time.Duration) error
 	GetFunc    func(key string, dest interface{}) error
 	DeleteFunc func(key string) error
+	MGetFunc   func(keys []string) ([][]byte, error)
+	MSetFunc   func(values map[string]interface{}, expiration time.Duration) error
+
+	AcquireLockFunc func(key, token string, ttl time.Duration) (bool, error)
+	ReleaseLockFunc func(key, token string) error
+
+	IncrementCounterFunc func(key string, by, limit int64, expiresAt time.Time) (int64, bool, error)
+	AdjustCounterFunc    func(key string, by int64) error
 }
 
 // Set implements the Set method from RedisHandler
@@ -36,3 +44,53 @@ func (m *MockRedisHandler) Delete(key string) error {
 	}
 	return nil
 }
+
+// MGet implements the MGet method from RedisHandler; without MGetFunc every key is a miss
+func (m *MockRedisHandler) MGet(keys []string) ([][]byte, error) {
+	if m.MGetFunc != nil {
+		return m.MGetFunc(keys)
+	}
+	return make([][]byte, len(keys)), nil
+}
+
+// MSet implements the MSet method from RedisHandler
+func (m *MockRedisHandler) MSet(values map[string]interface{}, expiration time.Duration) error {
+	if m.MSetFunc != nil {
+		return m.MSetFunc(values, expiration)
+	}
+	return nil
+}
+
+// AcquireLock implements the AcquireLock method from RedisHandler; without AcquireLockFunc the
+// lock is always granted
+func (m *MockRedisHandler) AcquireLock(key, token string, ttl time.Duration) (bool, error) {
+	if m.AcquireLockFunc != nil {
+		return m.AcquireLockFunc(key, token, ttl)
+	}
+	return true, nil
+}
+
+// ReleaseLock implements the ReleaseLock method from RedisHandler
+func (m *MockRedisHandler) ReleaseLock(key, token string) error {
+	if m.ReleaseLockFunc != nil {
+		return m.ReleaseLockFunc(key, token)
+	}
+	return nil
+}
+
+// IncrementCounter implements the IncrementCounter method from RedisHandler; without
+// IncrementCounterFunc every increment is allowed
+func (m *MockRedisHandler) IncrementCounter(key string, by, limit int64, expiresAt time.Time) (int64, bool, error) {
+	if m.IncrementCounterFunc != nil {
+		return m.IncrementCounterFunc(key, by, limit, expiresAt)
+	}
+	return by, true, nil
+}
+
+// AdjustCounter implements the AdjustCounter method from RedisHandler
+func (m *MockRedisHandler) AdjustCounter(key string, by int64) error {
+	if m.AdjustCounterFunc != nil {
+		return m.AdjustCounterFunc(key, by)
+	}
+	return nil
+}
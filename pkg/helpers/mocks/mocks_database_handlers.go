@@ -19,7 +19,8 @@ type DatabaseHandler interface {
 	Model(value interface{}) *gorm.DB // Add Model method
 	Find(dest interface{}, conds ...interface{}) *gorm.DB
 	Raw(query string, values ...interface{}) *gorm.DB // Add Raw method
-
+	Transaction(fc func(tx *gorm.DB) error) error     // Add Transaction method
+	Exec(sql string, values ...interface{}) *gorm.DB  // Add Exec method
 }
 
 // MockDatabaseHandler is a mock implementation of the DatabaseHandler interface
@@ -38,6 +39,8 @@ type MockDatabaseHandler struct {
 	FindFunc              func(dest interface{}, conds ...interface{}) *gorm.DB
 	RawFunc               func(query string, values ...interface{}) *gorm.DB // Add Raw method
 	OmitFunc              func(columns ...string) *gorm.DB                   // Add Omit method
+	TransactionFunc       func(fc func(tx *gorm.DB) error) error             // Add Transaction method
+	ExecFunc              func(sql string, values ...interface{}) *gorm.DB   // Add Exec method
 }
 
 // ConnectToDatabase implements the ConnectToDatabase method from DatabaseHandler
@@ -143,3 +146,19 @@ func (m *MockDatabaseHandler) Raw(query string, values ...interface{}) *gorm.DB
 	}
 	return nil
 }
+
+// Transaction implements the Transaction method from DatabaseHandler
+func (m *MockDatabaseHandler) Transaction(fc func(tx *gorm.DB) error) error {
+	if m.TransactionFunc != nil {
+		return m.TransactionFunc(fc)
+	}
+	return nil
+}
+
+// Exec implements the Exec method from DatabaseHandler
+func (m *MockDatabaseHandler) Exec(sql string, values ...interface{}) *gorm.DB {
+	if m.ExecFunc != nil {
+		return m.ExecFunc(sql, values...)
+	}
+	return nil
+}
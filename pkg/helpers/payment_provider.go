@@ -0,0 +1,92 @@
+// helpers/payment_provider.go
+package helpers
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidWebhookSignature is returned when a webhook payload is not signed by the provider
+var ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+
+// Payment event types, normalised from the provider's own event names
+const (
+	// PaymentEventCheckoutCompleted is the first payment of a new subscription
+	PaymentEventCheckoutCompleted = "checkout_completed"
+	// PaymentEventInvoicePaid is a paid invoice, either the first one or a renewal
+	PaymentEventInvoicePaid = "invoice_paid"
+	// PaymentEventPackPurchased is the payment of a one-off purchase of a consumable pack
+	PaymentEventPackPurchased = "pack_purchased"
+	// PaymentEventPaymentFailed is a renewal payment that was declined; the provider retries it
+	PaymentEventPaymentFailed = "payment_failed"
+	// PaymentEventSubscriptionCanceled is a subscription set to end with the current period
+	PaymentEventSubscriptionCanceled = "subscription_canceled"
+	// PaymentEventSubscriptionEnded is a subscription that has ended
+	PaymentEventSubscriptionEnded = "subscription_ended"
+	// PaymentEventRefunded is a charge refunded to the customer
+	PaymentEventRefunded = "refunded"
+	// PaymentEventChargeback is a charge disputed by the customer with their bank
+	PaymentEventChargeback = "chargeback"
+	// PaymentEventIgnored is an event the application does not act on
+	PaymentEventIgnored = "ignored"
+)
+
+// CheckoutRequest describes the subscription a user wants to buy, or the pack of consumable items
+// when PackCode is set. Packs are paid once and PeriodMonths is ignored. PlanName is the name shown
+// on the payment page. CustomerID is the provider's customer to charge when the user already is
+// one, e.g. for an upgrade, so credit for their old plan is applied to the new one.
+type CheckoutRequest struct {
+	UserID        int
+	CustomerID    string
+	CustomerEmail string
+	PlanCode      string
+	PackCode      string
+	PlanName      string
+	PriceCents    int
+	Currency      string
+	PeriodMonths  int
+	SuccessURL    string
+	CancelURL     string
+}
+
+// CheckoutSession is a hosted payment page the user is redirected to
+type CheckoutSession struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// PaymentEvent is a verified webhook event from the payment provider. Fields the event does not
+// carry are left empty.
+type PaymentEvent struct {
+	ID                     string
+	Type                   string
+	ProviderType           string
+	UserID                 int
+	PlanCode               string
+	PackCode               string
+	ProviderSubscriptionID string
+	ProviderCustomerID     string
+	ProviderChargeID       string
+	ProviderPaymentID      string // a one-off payment, e.g. a Stripe payment intent
+	AmountCents            int
+	Currency               string
+	// FullRefund is set on refunds that return the whole charge
+	FullRefund  bool
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	OccurredAt  time.Time
+	Payload     []byte
+}
+
+// PaymentProvider takes payments for subscriptions and reports what happened to them via webhooks
+type PaymentProvider interface {
+	Name() string
+	// SignatureHeader is the request header carrying the webhook signature
+	SignatureHeader() string
+	CreateCheckoutSession(req CheckoutRequest) (*CheckoutSession, error)
+	// ParseWebhook verifies the signature of a webhook delivery and normalises the event
+	ParseWebhook(payload []byte, signature string) (*PaymentEvent, error)
+	// CancelSubscription ends the subscription now so it is not billed again, crediting the
+	// customer for the unused part of the period. Subscriptions that no longer exist are ignored.
+	CancelSubscription(providerSubscriptionID string) error
+}
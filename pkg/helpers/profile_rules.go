@@ -0,0 +1,79 @@
+// helpers/profile_rules.go
+package helpers
+
+import (
+	"fmt"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+)
+
+// Names of the enumerations referenced by `enum=` rules on the models
+const (
+	ZodiacEnum           = "zodiac"
+	LanguageEnum         = "language"
+	GenderEnum           = "gender"
+	RelationshipGoalEnum = "relationshipGoal"
+	InterestEnum         = "interest"
+	DevicePlatformEnum   = "devicePlatform"
+	DeliveryChannelEnum  = "deliveryChannel"
+)
+
+// DefaultInterests is the interest taxonomy used until the curated list is loaded from the
+// Interest table; it matches the rows seeded by the V4 migration.
+var DefaultInterests = []string{
+	"art", "baking", "board-games", "camping", "cooking", "cycling", "dancing", "fashion",
+	"fitness", "football", "gaming", "gardening", "hiking", "movies", "music", "photography",
+	"podcasts", "reading", "running", "singing", "swimming", "technology", "travel", "volunteering",
+	"wine", "writing", "yoga",
+}
+
+// socialMediaDomains lists the supported platforms and the hosts their profile links may point to
+var socialMediaDomains = map[string][]string{
+	"instagram": {"instagram.com"},
+	"facebook":  {"facebook.com", "fb.com"},
+	"twitter":   {"twitter.com", "x.com"},
+	"tiktok":    {"tiktok.com"},
+	"linkedin":  {"linkedin.com"},
+	"spotify":   {"open.spotify.com"},
+}
+
+func init() {
+	RegisterEnum(ZodiacEnum,
+		"Aries", "Taurus", "Gemini", "Cancer", "Leo", "Virgo",
+		"Libra", "Scorpio", "Sagittarius", "Capricorn", "Aquarius", "Pisces",
+	)
+	RegisterEnum(LanguageEnum,
+		"English", "Hindi", "Bengali", "Tamil", "Telugu", "Marathi", "Urdu", "Indonesian",
+		"Spanish", "French", "German", "Portuguese", "Arabic", "Mandarin", "Japanese", "Korean",
+	)
+	RegisterEnum(GenderEnum, "Male", "Female")
+	RegisterEnum(RelationshipGoalEnum,
+		"Long-term partner", "Long-term, open to short", "Short-term, open to long",
+		"Short-term fun", "New friends", "Still figuring it out",
+	)
+	RegisterEnum(InterestEnum, DefaultInterests...)
+	RegisterEnum(DevicePlatformEnum, models.DevicePlatformAndroid, models.DevicePlatformIOS)
+	RegisterEnum(DeliveryChannelEnum, models.DeliveryChannelInApp, models.DeliveryChannelPush, models.DeliveryChannelEmail)
+}
+
+// ValidateProfile checks a profile being created against the documented product rules, including
+// the photo it must have
+func ValidateProfile(profile *models.Profile) ValidationErrors {
+	errs := ValidateStruct(profile)
+	if len(profile.Photos) < models.MinProfilePhotos {
+		errs = append(errs, FieldError{Field: "Photos", Message: fmt.Sprintf("must contain at least %d", models.MinProfilePhotos)})
+	}
+	return errs
+}
+
+// ValidateProfileUpdate checks the edits to an existing profile. Photos are not edited through the
+// profile, and profiles created before a photo was required may have none, so only their number
+// is limited.
+func ValidateProfileUpdate(profile *models.Profile) ValidationErrors {
+	return ValidateStruct(profile)
+}
+
+// ValidateUser checks the editable user fields; passwords are checked separately before hashing
+func ValidateUser(user *models.User) ValidationErrors {
+	return ValidateStruct(user)
+}
@@ -0,0 +1,77 @@
+// helpers/push_sender.go
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// ErrInvalidDeviceToken is returned when the provider reports that a device token will never
+// work again, e.g. because the app was uninstalled; the token should be deleted
+var ErrInvalidDeviceToken = errors.New("invalid device token")
+
+// PushMessage is a push notification to a single device
+type PushMessage struct {
+	Token string
+	Title string
+	Body  string
+	Data  map[string]string
+}
+
+// PushSender sends push notifications to the devices of one platform. Errors wrapping
+// ErrInvalidDeviceToken are permanent; other errors may succeed when retried.
+type PushSender interface {
+	Platform() string
+	Send(ctx context.Context, message PushMessage) error
+}
+
+// FakePushSender is a PushSender that records the messages it is given instead of sending them,
+// for development and tests. Tokens in InvalidTokens are rejected as invalid, and Fail, when set,
+// decides whether a send fails.
+type FakePushSender struct {
+	InvalidTokens map[string]bool
+	Fail          func(message PushMessage) error
+	Log           bool
+
+	platform string
+	mu       sync.Mutex
+	sent     []PushMessage
+}
+
+// NewFakePushSender creates a FakePushSender for the platform
+func NewFakePushSender(platform string) *FakePushSender {
+	return &FakePushSender{platform: platform, InvalidTokens: make(map[string]bool)}
+}
+
+func (s *FakePushSender) Platform() string {
+	return s.platform
+}
+
+func (s *FakePushSender) Send(ctx context.Context, message PushMessage) error {
+	if s.InvalidTokens[message.Token] {
+		return fmt.Errorf("fake %s push: %w", s.platform, ErrInvalidDeviceToken)
+	}
+	if s.Fail != nil {
+		if err := s.Fail(message); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.sent = append(s.sent, message)
+	s.mu.Unlock()
+	if s.Log {
+		log.Printf("Fake %s push to %s: %s: %s", s.platform, message.Token, message.Title, message.Body)
+	}
+	return nil
+}
+
+// Sent returns the messages sent so far
+func (s *FakePushSender) Sent() []PushMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PushMessage(nil), s.sent...)
+}
@@ -0,0 +1,288 @@
+// helpers/rabbitmq_broker.go
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// attemptsHeader counts the deliveries of a message that is being retried
+const attemptsHeader = "x-attempts"
+
+// RabbitMQConfig holds the settings for RabbitMQ
+type RabbitMQConfig struct {
+	URL           string
+	Exchange      string        // defaults to knoxsdating.events
+	Queues        []string      // bound to every message published to the exchange
+	MaxDeliveries int           // defaults to 5
+	RetryDelay    time.Duration // defaults to 30s
+}
+
+// RabbitMQBroker is a MessageBroker backed by RabbitMQ. Messages are published to a topic exchange,
+// routed by type, and every configured queue receives all of them. A message whose handler fails
+// waits RetryDelay in the queue's retry queue before being delivered again; after MaxDeliveries
+// it is dead-lettered to the queue's dead letter queue, named after it with a .dead suffix.
+type RabbitMQBroker struct {
+	config RabbitMQConfig
+
+	mu        sync.Mutex
+	conn      *amqp.Connection
+	publisher *amqp.Channel
+}
+
+// NewRabbitMQBroker creates a new RabbitMQBroker. It connects on first use, and again whenever the
+// connection is lost.
+func NewRabbitMQBroker(config RabbitMQConfig) *RabbitMQBroker {
+	if config.Exchange == "" {
+		config.Exchange = "knoxsdating.events"
+	}
+	if config.MaxDeliveries <= 0 {
+		config.MaxDeliveries = 5
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = 30 * time.Second
+	}
+	return &RabbitMQBroker{config: config}
+}
+
+// Publish publishes the message to the exchange and waits for RabbitMQ to confirm it
+func (b *RabbitMQBroker) Publish(ctx context.Context, message BrokerMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.publisher == nil || b.publisher.IsClosed() {
+		conn, err := b.connection()
+		if err != nil {
+			return err
+		}
+		publisher, err := confirmChannel(conn)
+		if err != nil {
+			return err
+		}
+		b.publisher = publisher
+	}
+
+	return publishConfirmed(ctx, b.publisher, b.config.Exchange, message.Type, amqp.Publishing{
+		MessageId:    message.ID,
+		Type:         message.Type,
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Body:         message.Body,
+	})
+}
+
+// Consume handles the messages of the queue one at a time, acknowledging each once it is handled,
+// retried or dead-lettered
+func (b *RabbitMQBroker) Consume(ctx context.Context, queue string, handler MessageHandler) error {
+	if !b.hasQueue(queue) {
+		return ErrUnknownQueue
+	}
+
+	b.mu.Lock()
+	conn, err := b.connection()
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	channel, err := confirmChannel(conn)
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	if err := channel.Qos(1, 0, false); err != nil {
+		return err
+	}
+	deliveries, err := channel.ConsumeWithContext(ctx, queue, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case delivery, ok := <-deliveries:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("rabbitmq: consumer of %s closed", queue)
+			}
+			if err := b.handle(ctx, channel, queue, delivery, handler); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle runs the handler on the delivery, then acknowledges it, retries it or dead-letters it
+func (b *RabbitMQBroker) handle(ctx context.Context, channel *amqp.Channel, queue string, delivery amqp.Delivery, handler MessageHandler) error {
+	attempts := deliveryAttempts(delivery) + 1
+	err := handler(BrokerMessage{
+		ID:       delivery.MessageId,
+		Type:     delivery.Type,
+		Body:     delivery.Body,
+		Attempts: attempts,
+	})
+	if err == nil {
+		return delivery.Ack(false)
+	}
+
+	if attempts >= b.config.MaxDeliveries {
+		log.Printf("Dead-lettering message %s from %s after %d attempts: %v", delivery.MessageId, queue, attempts, err)
+		return delivery.Nack(false, false)
+	}
+
+	// The retry queue hands the message back to the queue once it expires there
+	retry := amqp.Publishing{
+		MessageId:    delivery.MessageId,
+		Type:         delivery.Type,
+		ContentType:  delivery.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    delivery.Timestamp,
+		Headers:      amqp.Table{attemptsHeader: int32(attempts)},
+		Body:         delivery.Body,
+	}
+	if publishErr := publishConfirmed(ctx, channel, "", retryQueue(queue), retry); publishErr != nil {
+		log.Printf("Error scheduling retry of message %s from %s: %v", delivery.MessageId, queue, publishErr)
+		return delivery.Nack(false, true)
+	}
+	return delivery.Ack(false)
+}
+
+// Close closes the connection to RabbitMQ
+func (b *RabbitMQBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil || b.conn.IsClosed() {
+		return nil
+	}
+	return b.conn.Close()
+}
+
+// connection returns the open connection, dialling and declaring the topology when there is none;
+// the lock must be held
+func (b *RabbitMQBroker) connection() (*amqp.Connection, error) {
+	if b.conn != nil && !b.conn.IsClosed() {
+		return b.conn, nil
+	}
+
+	conn, err := amqp.Dial(b.config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("rabbitmq: %w", err)
+	}
+	if err := b.declareTopology(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	b.conn = conn
+	b.publisher = nil
+	return conn, nil
+}
+
+// declareTopology declares the exchanges and, for each queue, its retry and dead letter queues
+func (b *RabbitMQBroker) declareTopology(conn *amqp.Connection) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	deadExchange := b.config.Exchange + ".dead"
+	if err := channel.ExchangeDeclare(b.config.Exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if err := channel.ExchangeDeclare(deadExchange, amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		return err
+	}
+
+	for _, queue := range b.config.Queues {
+		if _, err := channel.QueueDeclare(queue, true, false, false, false, amqp.Table{
+			"x-dead-letter-exchange":    deadExchange,
+			"x-dead-letter-routing-key": queue,
+		}); err != nil {
+			return err
+		}
+		if err := channel.QueueBind(queue, "#", b.config.Exchange, false, nil); err != nil {
+			return err
+		}
+
+		if _, err := channel.QueueDeclare(retryQueue(queue), true, false, false, false, amqp.Table{
+			"x-message-ttl":             b.config.RetryDelay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		}); err != nil {
+			return err
+		}
+
+		if _, err := channel.QueueDeclare(queue+".dead", true, false, false, false, nil); err != nil {
+			return err
+		}
+		if err := channel.QueueBind(queue+".dead", queue, deadExchange, false, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *RabbitMQBroker) hasQueue(queue string) bool {
+	for _, configured := range b.config.Queues {
+		if configured == queue {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmChannel opens a channel in confirm mode
+func confirmChannel(conn *amqp.Connection) (*amqp.Channel, error) {
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := channel.Confirm(false); err != nil {
+		channel.Close()
+		return nil, err
+	}
+	return channel, nil
+}
+
+// publishConfirmed publishes on a confirm mode channel and waits for RabbitMQ to take the message
+func publishConfirmed(ctx context.Context, channel *amqp.Channel, exchange, key string, publishing amqp.Publishing) error {
+	confirmation, err := channel.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, publishing)
+	if err != nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return errors.New("rabbitmq: message was not accepted")
+	}
+	return nil
+}
+
+// deliveryAttempts returns how many times the delivery was handled before
+func deliveryAttempts(delivery amqp.Delivery) int {
+	switch attempts := delivery.Headers[attemptsHeader].(type) {
+	case int32:
+		return int(attempts)
+	case int64:
+		return int(attempts)
+	default:
+		return 0
+	}
+}
+
+func retryQueue(queue string) string {
+	return queue + ".retry"
+}
@@ -14,6 +14,12 @@ type RedisHandler interface {
 	Get(key string, dest interface{}) error
 	Set(key string, value interface{}, expiration time.Duration) error
 	Delete(key string) error
+	MGet(keys []string) ([][]byte, error)
+	MSet(values map[string]interface{}, expiration time.Duration) error
+	AcquireLock(key, token string, ttl time.Duration) (bool, error)
+	ReleaseLock(key, token string) error
+	IncrementCounter(key string, by, limit int64, expiresAt time.Time) (int64, bool, error)
+	AdjustCounter(key string, by int64) error
 }
 
 // RedisHelper is the concrete implementation of RedisHandler
@@ -67,3 +73,130 @@ func (rh *RedisHelper) Delete(key string) error {
 
 	return nil
 }
+
+// MGet fetches several keys in one round trip; the result has one entry per key, nil for misses
+func (rh *RedisHelper) MGet(keys []string) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	ctx := context.Background()
+	results, err := rh.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range results {
+		if value, ok := result.(string); ok {
+			values[i] = []byte(value)
+		}
+	}
+	return values, nil
+}
+
+// MSet stores several JSON-encoded values with the same expiration in one pipelined round trip
+func (rh *RedisHelper) MSet(values map[string]interface{}, expiration time.Duration) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	pipe := rh.client.Pipeline()
+	for key, value := range values {
+		jsonValue, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, key, jsonValue, expiration)
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// extendOrAcquireLockScript takes the lock when it is free and extends it when the caller already
+// holds it
+var extendOrAcquireLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) and 1 or 0
+`)
+
+// releaseLockScript deletes the lock only when the caller still holds it
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// AcquireLock takes the lock for the holder identified by token, or extends it when the holder
+// already has it. It reports false while another holder has the lock.
+func (rh *RedisHelper) AcquireLock(key, token string, ttl time.Duration) (bool, error) {
+	ctx := context.Background()
+	held, err := extendOrAcquireLockScript.Run(ctx, rh.client, []string{key}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return held == 1, nil
+}
+
+// ReleaseLock gives up the lock if the holder identified by token still has it
+func (rh *RedisHelper) ReleaseLock(key, token string) error {
+	ctx := context.Background()
+	return releaseLockScript.Run(ctx, rh.client, []string{key}, token).Err()
+}
+
+// incrementCounterScript adds ARGV[1] to the counter unless that would take it past the limit in
+// ARGV[2] (negative for none), never below zero, and makes it expire at ARGV[3] (Unix ms). It
+// returns whether the counter was changed and its value.
+var incrementCounterScript = redis.NewScript(`
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+local by = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+if by > 0 and limit >= 0 and count + by > limit then
+    return {0, count}
+end
+if by < 0 and count + by < 0 then
+    by = -count
+end
+if by ~= 0 then
+    count = redis.call("INCRBY", KEYS[1], by)
+    redis.call("PEXPIREAT", KEYS[1], ARGV[3])
+end
+return {1, count}
+`)
+
+// IncrementCounter atomically adds by to the counter at key and returns its new value. It reports
+// false and leaves the counter unchanged when that would take it past limit; a negative limit
+// means there is none. Negative increments never take the counter below zero, and an increment of
+// zero reads it. The counter expires at expiresAt.
+func (rh *RedisHelper) IncrementCounter(key string, by, limit int64, expiresAt time.Time) (int64, bool, error) {
+	ctx := context.Background()
+	result, err := incrementCounterScript.Run(ctx, rh.client, []string{key}, by, limit, expiresAt.UnixMilli()).Int64Slice()
+	if err != nil {
+		return 0, false, err
+	}
+	return result[1], result[0] == 1, nil
+}
+
+// adjustCounterScript adds ARGV[1] to the counter if it exists, never taking it below zero
+var adjustCounterScript = redis.NewScript(`
+local count = redis.call("GET", KEYS[1])
+if not count then
+    return 0
+end
+if tonumber(count) + tonumber(ARGV[1]) < 0 then
+    return redis.call("SET", KEYS[1], 0, "KEEPTTL") and 0
+end
+return redis.call("INCRBY", KEYS[1], ARGV[1])
+`)
+
+// AdjustCounter atomically adds by to a cached counter, keeping its expiry. A counter that is not
+// cached is left alone, so it is recounted from the source of truth when next read.
+func (rh *RedisHelper) AdjustCounter(key string, by int64) error {
+	ctx := context.Background()
+	return adjustCounterScript.Run(ctx, rh.client, []string{key}, by).Err()
+}
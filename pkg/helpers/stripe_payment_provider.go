@@ -0,0 +1,318 @@
+// helpers/stripe_payment_provider.go
+package helpers
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeSignatureHeader is the header carrying the webhook signature
+const StripeSignatureHeader = "Stripe-Signature"
+
+// stripeSignatureTolerance is how old a signed webhook may be before it is rejected as a replay
+const stripeSignatureTolerance = 5 * time.Minute
+
+// StripeConfig holds the settings for the Stripe API
+type StripeConfig struct {
+	APIBase       string // defaults to https://api.stripe.com; point it at a FakeStripeServer locally
+	SecretKey     string
+	WebhookSecret string
+}
+
+// StripePaymentProvider is a PaymentProvider backed by Stripe Checkout and Stripe webhooks
+type StripePaymentProvider struct {
+	config StripeConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewStripePaymentProvider creates a new StripePaymentProvider
+func NewStripePaymentProvider(config StripeConfig) PaymentProvider {
+	if config.APIBase == "" {
+		config.APIBase = "https://api.stripe.com"
+	}
+	return &StripePaymentProvider{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+		now:    time.Now,
+	}
+}
+
+func (p *StripePaymentProvider) Name() string {
+	return "stripe"
+}
+
+func (p *StripePaymentProvider) SignatureHeader() string {
+	return StripeSignatureHeader
+}
+
+// CreateCheckoutSession creates a hosted checkout page for a recurring subscription, or for a
+// one-off payment when a pack is bought. The user ID and plan or pack are attached to the session
+// and its subscription or payment so webhooks can be traced back.
+func (p *StripePaymentProvider) CreateCheckoutSession(req CheckoutRequest) (*CheckoutSession, error) {
+	userID := strconv.Itoa(req.UserID)
+	form := url.Values{
+		"client_reference_id":                           {userID},
+		"success_url":                                   {req.SuccessURL},
+		"cancel_url":                                    {req.CancelURL},
+		"line_items[0][quantity]":                       {"1"},
+		"line_items[0][price_data][currency]":           {strings.ToLower(req.Currency)},
+		"line_items[0][price_data][unit_amount]":        {strconv.Itoa(req.PriceCents)},
+		"line_items[0][price_data][product_data][name]": {req.PlanName},
+		"metadata[userID]":                              {userID},
+	}
+	if req.PackCode != "" {
+		form.Set("mode", "payment")
+		form.Set("metadata[pack]", req.PackCode)
+		form.Set("payment_intent_data[metadata][userID]", userID)
+		form.Set("payment_intent_data[metadata][pack]", req.PackCode)
+	} else {
+		form.Set("mode", "subscription")
+		form.Set("line_items[0][price_data][recurring][interval]", "month")
+		form.Set("line_items[0][price_data][recurring][interval_count]", strconv.Itoa(req.PeriodMonths))
+		form.Set("metadata[plan]", req.PlanCode)
+		form.Set("subscription_data[metadata][userID]", userID)
+		form.Set("subscription_data[metadata][plan]", req.PlanCode)
+	}
+	if req.CustomerID != "" {
+		form.Set("customer", req.CustomerID)
+	} else if req.CustomerEmail != "" {
+		form.Set("customer_email", req.CustomerEmail)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(p.config.APIBase, "/")+"/v1/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.config.SecretKey)
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("stripe checkout failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var session CheckoutSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// CancelSubscription cancels the subscription immediately with proration, which leaves the unused
+// time as credit on the customer's balance. Stripe answers 404 for subscriptions already deleted.
+func (p *StripePaymentProvider) CancelSubscription(providerSubscriptionID string) error {
+	endpoint := strings.TrimSuffix(p.config.APIBase, "/") + "/v1/subscriptions/" + url.PathEscape(providerSubscriptionID) + "?prorate=true"
+	httpReq, err := http.NewRequest(http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.config.SecretKey)
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("stripe cancellation failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// stripeEvent is the envelope of a Stripe webhook event
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// stripeObject holds the fields used from the objects of the handled event types
+type stripeObject struct {
+	ID                string            `json:"id"`
+	ClientReferenceID string            `json:"client_reference_id"`
+	Mode              string            `json:"mode"`
+	PaymentIntent     string            `json:"payment_intent"`
+	Customer          string            `json:"customer"`
+	Subscription      string            `json:"subscription"`
+	Charge            string            `json:"charge"`
+	Currency          string            `json:"currency"`
+	Amount            int               `json:"amount"`
+	AmountTotal       int               `json:"amount_total"`
+	AmountPaid        int               `json:"amount_paid"`
+	AmountRefunded    int               `json:"amount_refunded"`
+	Refunded          bool              `json:"refunded"`
+	CancelAtPeriodEnd bool              `json:"cancel_at_period_end"`
+	CurrentPeriodEnd  int64             `json:"current_period_end"`
+	Metadata          map[string]string `json:"metadata"`
+
+	SubscriptionDetails struct {
+		Metadata map[string]string `json:"metadata"`
+	} `json:"subscription_details"`
+	Lines struct {
+		Data []struct {
+			Period struct {
+				Start int64 `json:"start"`
+				End   int64 `json:"end"`
+			} `json:"period"`
+		} `json:"data"`
+	} `json:"lines"`
+}
+
+// ParseWebhook verifies the Stripe-Signature header and maps the event to a PaymentEvent
+func (p *StripePaymentProvider) ParseWebhook(payload []byte, signature string) (*PaymentEvent, error) {
+	if err := VerifyStripeSignature(payload, signature, p.config.WebhookSecret, p.now()); err != nil {
+		return nil, err
+	}
+
+	var envelope stripeEvent
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+	var object stripeObject
+	if len(envelope.Data.Object) > 0 {
+		if err := json.Unmarshal(envelope.Data.Object, &object); err != nil {
+			return nil, fmt.Errorf("invalid webhook object: %w", err)
+		}
+	}
+
+	event := &PaymentEvent{
+		ID:                 envelope.ID,
+		Type:               PaymentEventIgnored,
+		ProviderType:       envelope.Type,
+		ProviderCustomerID: object.Customer,
+		Currency:           strings.ToUpper(object.Currency),
+		OccurredAt:         time.Unix(envelope.Created, 0),
+		Payload:            payload,
+	}
+
+	metadata := object.Metadata
+	switch envelope.Type {
+	case "checkout.session.completed":
+		event.Type = PaymentEventCheckoutCompleted
+		event.ProviderSubscriptionID = object.Subscription
+		event.AmountCents = object.AmountTotal
+		if object.Mode == "payment" {
+			event.Type = PaymentEventPackPurchased
+			event.ProviderPaymentID = object.PaymentIntent
+		}
+		if object.ClientReferenceID != "" {
+			metadata = map[string]string{"userID": object.ClientReferenceID, "plan": object.Metadata["plan"], "pack": object.Metadata["pack"]}
+		}
+	case "invoice.paid":
+		event.Type = PaymentEventInvoicePaid
+		event.ProviderSubscriptionID = object.Subscription
+		event.ProviderChargeID = object.Charge
+		event.AmountCents = object.AmountPaid
+		metadata = object.SubscriptionDetails.Metadata
+		if len(object.Lines.Data) > 0 {
+			period := object.Lines.Data[0].Period
+			event.PeriodStart = time.Unix(period.Start, 0)
+			event.PeriodEnd = time.Unix(period.End, 0)
+		}
+	case "invoice.payment_failed":
+		event.Type = PaymentEventPaymentFailed
+		event.ProviderSubscriptionID = object.Subscription
+		metadata = object.SubscriptionDetails.Metadata
+	case "customer.subscription.updated":
+		// Only cancellations at the end of the period are acted on
+		if object.CancelAtPeriodEnd {
+			event.Type = PaymentEventSubscriptionCanceled
+		}
+		event.ProviderSubscriptionID = object.ID
+		event.PeriodEnd = unixOrZero(object.CurrentPeriodEnd)
+	case "customer.subscription.deleted":
+		event.Type = PaymentEventSubscriptionEnded
+		event.ProviderSubscriptionID = object.ID
+	case "charge.refunded":
+		event.Type = PaymentEventRefunded
+		event.ProviderChargeID = object.ID
+		event.ProviderPaymentID = object.PaymentIntent
+		event.AmountCents = object.AmountRefunded
+		event.FullRefund = object.Refunded
+	case "charge.dispute.created":
+		event.Type = PaymentEventChargeback
+		event.ProviderChargeID = object.Charge
+		event.ProviderPaymentID = object.PaymentIntent
+		event.AmountCents = object.Amount
+	}
+
+	if userID, err := strconv.Atoi(metadata["userID"]); err == nil {
+		event.UserID = userID
+	}
+	event.PlanCode = metadata["plan"]
+	event.PackCode = metadata["pack"]
+
+	return event, nil
+}
+
+// VerifyStripeSignature checks a "t=<unix>,v1=<hex hmac>" header against the payload. The HMAC
+// covers "<t>.<payload>", and deliveries older than five minutes are rejected.
+func VerifyStripeSignature(payload []byte, header, secret string, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 || secret == "" {
+		return ErrInvalidWebhookSignature
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidWebhookSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return ErrInvalidWebhookSignature
+	}
+
+	expected := hmacSHA256([]byte(secret), timestamp+"."+string(payload))
+	for _, signature := range signatures {
+		decoded, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidWebhookSignature
+}
+
+// SignStripePayload returns a Stripe-Signature header value for the payload
+func SignStripePayload(payload []byte, secret string, at time.Time) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(hmacSHA256([]byte(secret), timestamp+"."+string(payload)))
+}
+
+func unixOrZero(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
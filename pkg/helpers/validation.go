@@ -0,0 +1,270 @@
+// helpers/validation.go
+package helpers
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+)
+
+// FieldError describes a validation failure on a single field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is the list of field errors returned in Response.Error
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldError := range e {
+		messages = append(messages, fieldError.Field+": "+fieldError.Message)
+	}
+	return "validation error: " + strings.Join(messages, "; ")
+}
+
+// validationRule checks a single field value; param is the text after "=" in the tag
+type validationRule func(value reflect.Value, param string) string
+
+var (
+	rulesMu sync.RWMutex
+	rules   = map[string]validationRule{}
+
+	enumsMu sync.RWMutex
+	enums   = map[string]map[string]bool{}
+)
+
+// RegisterValidationRule adds a named rule usable in `validate` struct tags
+func RegisterValidationRule(name string, rule func(value reflect.Value, param string) string) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules[name] = rule
+}
+
+// RegisterEnum defines the allowed values for an `enum=<name>` rule, replacing any previous values
+func RegisterEnum(name string, values ...string) {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+
+	enumsMu.Lock()
+	defer enumsMu.Unlock()
+	enums[name] = set
+}
+
+// IsEnumValue reports whether value is one of the registered values of the named enum
+func IsEnumValue(name, value string) bool {
+	enumsMu.RLock()
+	defer enumsMu.RUnlock()
+	return enums[name][value]
+}
+
+// ValidateStruct checks every field of the struct against the rules in its `validate` tag.
+// Rules are comma separated, e.g. `validate:"required,max=500"`. Fields holding their zero
+// value are only checked by the "required" rule, so partial updates validate cleanly.
+func ValidateStruct(s interface{}) ValidationErrors {
+	value := reflect.Indirect(reflect.ValueOf(s))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs ValidationErrors
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+
+		fieldValue := value.Field(i)
+		for _, ruleSpec := range strings.Split(tag, ",") {
+			name, param, _ := strings.Cut(ruleSpec, "=")
+
+			if name == "required" {
+				if fieldValue.IsZero() {
+					errs = append(errs, FieldError{Field: field.Name, Message: "is required"})
+					break
+				}
+				continue
+			}
+			if fieldValue.IsZero() {
+				break
+			}
+
+			rulesMu.RLock()
+			rule, ok := rules[name]
+			rulesMu.RUnlock()
+			if !ok {
+				panic(fmt.Sprintf("unknown validation rule %q on %s.%s", name, valueType.Name(), field.Name))
+			}
+
+			if message := rule(fieldValue, param); message != "" {
+				errs = append(errs, FieldError{Field: field.Name, Message: message})
+				break
+			}
+		}
+	}
+
+	return errs
+}
+
+func init() {
+	RegisterValidationRule("min", func(value reflect.Value, param string) string {
+		limit, _ := strconv.Atoi(param)
+		switch value.Kind() {
+		case reflect.String:
+			if utf8.RuneCountInString(value.String()) < limit {
+				return fmt.Sprintf("must be at least %d characters", limit)
+			}
+		case reflect.Int, reflect.Int64:
+			if value.Int() < int64(limit) {
+				return fmt.Sprintf("must be at least %d", limit)
+			}
+		}
+		return ""
+	})
+
+	RegisterValidationRule("max", func(value reflect.Value, param string) string {
+		limit, _ := strconv.Atoi(param)
+		switch value.Kind() {
+		case reflect.String:
+			if utf8.RuneCountInString(value.String()) > limit {
+				return fmt.Sprintf("must be at most %d characters", limit)
+			}
+		case reflect.Int, reflect.Int64:
+			if value.Int() > int64(limit) {
+				return fmt.Sprintf("must be at most %d", limit)
+			}
+		}
+		return ""
+	})
+
+	RegisterValidationRule("enum", func(value reflect.Value, param string) string {
+		if !IsEnumValue(param, value.String()) {
+			return fmt.Sprintf("is not a valid %s", param)
+		}
+		return ""
+	})
+
+	RegisterValidationRule("email", func(value reflect.Value, param string) string {
+		if !IsValidEmail(value.String()) {
+			return "must be a valid email address"
+		}
+		return ""
+	})
+
+	RegisterValidationRule("timezone", func(value reflect.Value, param string) string {
+		if _, err := time.LoadLocation(value.String()); err != nil || value.String() == "Local" {
+			return "must be an IANA time zone such as Europe/Paris"
+		}
+		return ""
+	})
+
+	RegisterValidationRule("clock", func(value reflect.Value, param string) string {
+		if _, ok := models.ParseClock(value.String()); !ok {
+			return "must be a time of day such as 22:30"
+		}
+		return ""
+	})
+
+	RegisterValidationRule("age", func(value reflect.Value, param string) string {
+		birthDate, ok := reflect.Indirect(value).Interface().(time.Time)
+		if !ok {
+			return "must be a date"
+		}
+
+		now := time.Now()
+		if birthDate.After(now) {
+			return "must be in the past"
+		}
+		if limit, err := strconv.Atoi(param); err == nil && models.AgeOn(birthDate, now) < limit {
+			return fmt.Sprintf("must be at least %d years ago", limit)
+		}
+		return ""
+	})
+
+	RegisterValidationRule("count", func(value reflect.Value, param string) string {
+		if value.Kind() != reflect.Slice {
+			return "must be a list"
+		}
+
+		minItems, maxItems, _ := strings.Cut(param, ":")
+		if limit, err := strconv.Atoi(minItems); err == nil && value.Len() < limit {
+			return fmt.Sprintf("must contain at least %d", limit)
+		}
+		if limit, err := strconv.Atoi(maxItems); err == nil && value.Len() > limit {
+			return fmt.Sprintf("must contain at most %d", limit)
+		}
+		return ""
+	})
+
+	RegisterValidationRule("interests", func(value reflect.Value, param string) string {
+		interests, ok := value.Interface().(models.StringList)
+		if !ok {
+			return "must be a list of interests"
+		}
+
+		if limit, err := strconv.Atoi(param); err == nil && len(interests) > limit {
+			return fmt.Sprintf("must have at most %d interests", limit)
+		}
+
+		seen := make(map[string]bool, len(interests))
+		for _, interest := range interests {
+			if !IsEnumValue(InterestEnum, interest) {
+				return fmt.Sprintf("%q is not a recognised interest", interest)
+			}
+			if seen[interest] {
+				return fmt.Sprintf("%q is listed more than once", interest)
+			}
+			seen[interest] = true
+		}
+		return ""
+	})
+
+	RegisterValidationRule("socials", func(value reflect.Value, param string) string {
+		accounts, ok := value.Interface().(models.SocialMediaAccounts)
+		if !ok {
+			return "must map platform to profile URL"
+		}
+
+		for platform, link := range accounts {
+			domains, ok := socialMediaDomains[platform]
+			if !ok {
+				return fmt.Sprintf("%q is not a supported platform", platform)
+			}
+			if !isProfileURL(link, domains) {
+				return fmt.Sprintf("%s must be an https link to a %s profile", platform, platform)
+			}
+		}
+		return ""
+	})
+}
+
+// isProfileURL checks that link is an https URL with a path on one of the allowed domains
+func isProfileURL(link string, domains []string) bool {
+	parsed, err := url.Parse(link)
+	if err != nil || parsed.Scheme != "https" || parsed.User != nil {
+		return false
+	}
+	if strings.Trim(parsed.Path, "/") == "" {
+		return false
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	for _, domain := range domains {
+		if host == domain {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+// helpers/validation_test.go
+package helpers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+)
+
+func Test_ValidateProfile(t *testing.T) {
+	valid := models.Profile{
+		Photos:              models.StringList{"/photos/1"},
+		AboutMe:             "Coffee first, then hiking.",
+		Interests:           models.StringList{"hiking", "music"},
+		RelationshipGoals:   "Long-term partner",
+		Height:              172,
+		Language:            "English",
+		ZodiacSign:          "Leo",
+		SocialMediaAccounts: models.SocialMediaAccounts{"instagram": "https://www.instagram.com/knoxs"},
+	}
+
+	// Positive Test Case
+	if errs := ValidateProfile(&valid); len(errs) > 0 {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+
+	// Negative Test Cases
+	tests := []struct {
+		name   string
+		modify func(p *models.Profile)
+		field  string
+	}{
+		{"missing photos", func(p *models.Profile) { p.Photos = nil }, "Photos"},
+		{"no photos", func(p *models.Profile) { p.Photos = models.StringList{} }, "Photos"},
+		{"too many photos", func(p *models.Profile) {
+			p.Photos = models.StringList{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
+		}, "Photos"},
+		{"long description", func(p *models.Profile) { p.AboutMe = strings.Repeat("a", 501) }, "AboutMe"},
+		{"unknown interest", func(p *models.Profile) { p.Interests = models.StringList{"skydiving-on-mars"} }, "Interests"},
+		{"duplicate interest", func(p *models.Profile) { p.Interests = models.StringList{"music", "music"} }, "Interests"},
+		{"unknown zodiac", func(p *models.Profile) { p.ZodiacSign = "Ophiuchus" }, "ZodiacSign"},
+		{"height out of range", func(p *models.Profile) { p.Height = 20 }, "Height"},
+		{"unsupported platform", func(p *models.Profile) {
+			p.SocialMediaAccounts = models.SocialMediaAccounts{"myspace": "https://myspace.com/knoxs"}
+		}, "SocialMediaAccounts"},
+		{"wrong domain", func(p *models.Profile) {
+			p.SocialMediaAccounts = models.SocialMediaAccounts{"instagram": "https://evil.example/knoxs"}
+		}, "SocialMediaAccounts"},
+		{"plain http", func(p *models.Profile) {
+			p.SocialMediaAccounts = models.SocialMediaAccounts{"instagram": "http://instagram.com/knoxs"}
+		}, "SocialMediaAccounts"},
+	}
+
+	for _, tt := range tests {
+		profile := valid
+		tt.modify(&profile)
+
+		errs := ValidateProfile(&profile)
+		if len(errs) != 1 || errs[0].Field != tt.field {
+			t.Errorf("%s: expected one error on %s, got %v", tt.name, tt.field, errs)
+		}
+	}
+}
+
+func Test_ValidateProfileUpdate(t *testing.T) {
+	// A profile created before a photo was required
+	photoless := models.Profile{
+		UserID:            1,
+		AboutMe:           "Coffee first, then hiking.",
+		RelationshipGoals: "Long-term partner",
+		Height:            172,
+	}
+
+	// Editing it does not require adding a photo first
+	edited := photoless
+	edited.AboutMe = "Tea now, still hiking."
+	edited.Interests = models.StringList{"hiking", "yoga"}
+	if errs := ValidateProfileUpdate(&edited); len(errs) > 0 {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+	edited.Photos = models.StringList{}
+	if errs := ValidateProfileUpdate(&edited); len(errs) > 0 {
+		t.Errorf("Expected an empty photo list accepted, got %v", errs)
+	}
+
+	// but creating one does
+	if errs := ValidateProfile(&photoless); len(errs) != 1 || errs[0].Field != "Photos" {
+		t.Errorf("Expected one error on Photos, got %v", errs)
+	}
+
+	// The other rules still apply to the edits
+	edited.ZodiacSign = "Ophiuchus"
+	if errs := ValidateProfileUpdate(&edited); len(errs) != 1 || errs[0].Field != "ZodiacSign" {
+		t.Errorf("Expected one error on ZodiacSign, got %v", errs)
+	}
+	edited.ZodiacSign = ""
+	edited.Photos = models.StringList{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
+	if errs := ValidateProfileUpdate(&edited); len(errs) != 1 || errs[0].Field != "Photos" {
+		t.Errorf("Expected one error on Photos, got %v", errs)
+	}
+}
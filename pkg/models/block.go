@@ -0,0 +1,17 @@
+// models/block.go
+package models
+
+import "time"
+
+// Block records that one user has blocked another; blocked pairs never see each other
+type Block struct {
+	BlockID       int       `gorm:"column:BlockID;primaryKey" json:"blockID"`
+	BlockerUserID int       `gorm:"column:BlockerUserID;not null" json:"blockerUserID"`
+	BlockedUserID int       `gorm:"column:BlockedUserID;not null" json:"blockedUserID"`
+	Timestamp     time.Time `gorm:"column:Timestamp;type:timestamp" json:"timestamp"`
+}
+
+// TableName specifies the table name for the Block model
+func (Block) TableName() string {
+	return "Block"
+}
@@ -0,0 +1,32 @@
+// models/current_location.go
+package models
+
+import "time"
+
+// CurrentLocation is the latest reported position of a user, one row per user. Nearby search
+// reads this table instead of the full Locationhistory.
+type CurrentLocation struct {
+	UserID     int       `json:"userID" gorm:"column:UserID;primaryKey"`
+	LocationID int       `json:"locationID" gorm:"column:LocationID"`
+	Latitude   float64   `json:"latitude" gorm:"column:Latitude"`
+	Longitude  float64   `json:"longitude" gorm:"column:Longitude"`
+	Timestamp  time.Time `json:"timestamp" gorm:"column:Timestamp"`
+	IsFuzzed   bool      `json:"-" gorm:"column:IsFuzzed"`
+}
+
+// TableName specifies the table name for the CurrentLocation model
+func (CurrentLocation) TableName() string {
+	return "CurrentLocation"
+}
+
+// LocationHistory returns the history entry the current location was taken from
+func (c CurrentLocation) LocationHistory() LocationHistory {
+	return LocationHistory{
+		LocationID: c.LocationID,
+		UserID:     c.UserID,
+		Latitude:   c.Latitude,
+		Longitude:  c.Longitude,
+		Timestamp:  c.Timestamp,
+		IsFuzzed:   c.IsFuzzed,
+	}
+}
@@ -0,0 +1,33 @@
+// models/data_export.go
+package models
+
+import "time"
+
+// Data export statuses
+const (
+	DataExportStatusPending    = "Pending"
+	DataExportStatusProcessing = "Processing"
+	DataExportStatusReady      = "Ready"
+	DataExportStatusFailed     = "Failed"
+)
+
+// DataExport tracks a user's request for a copy of their personal data. Pending and processing
+// exports are leased to the instance building them until LeasedUntil; once it passes, another
+// instance may claim the export and count another attempt.
+type DataExport struct {
+	ExportID     int       `gorm:"column:ExportID;primaryKey" json:"exportID"`
+	UserID       int       `gorm:"column:UserID;not null" json:"userID"`
+	Status       string    `gorm:"column:Status;size:20;not null" json:"status"`
+	ArchiveKey   string    `gorm:"column:ArchiveKey;size:255" json:"-"`
+	ErrorMessage string    `gorm:"column:ErrorMessage;type:text" json:"errorMessage,omitempty"`
+	RequestedAt  time.Time `gorm:"column:RequestedAt;type:timestamp" json:"requestedAt"`
+	CompletedAt  time.Time `gorm:"column:CompletedAt;type:timestamp" json:"completedAt"`
+	ExpiresAt    time.Time `gorm:"column:ExpiresAt;type:timestamp" json:"expiresAt"`
+	Attempts     int       `gorm:"column:Attempts;not null;default:0" json:"-"`
+	LeasedUntil  time.Time `gorm:"column:LeasedUntil;type:timestamp" json:"-"`
+}
+
+// TableName specifies the table name for the DataExport model
+func (DataExport) TableName() string {
+	return "DataExport"
+}
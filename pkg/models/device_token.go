@@ -0,0 +1,26 @@
+// models/device_token.go
+package models
+
+import "time"
+
+// Device platforms, each with its own push provider
+const (
+	DevicePlatformAndroid = "android"
+	DevicePlatformIOS     = "ios"
+)
+
+// DeviceToken is a device registered to receive push notifications for a user. A token belongs to
+// one device, so registering it again moves it to the user now signed in on that device.
+type DeviceToken struct {
+	DeviceTokenID int       `gorm:"column:DeviceTokenID;primaryKey" json:"deviceTokenID"`
+	UserID        int       `gorm:"column:UserID;not null" json:"userID"`
+	Platform      string    `gorm:"column:Platform;size:10;not null" json:"platform" validate:"required,enum=devicePlatform"`
+	Token         string    `gorm:"column:Token;size:255;not null;unique" json:"token" validate:"required,max=255"`
+	CreatedAt     time.Time `gorm:"column:CreatedAt;type:timestamp;not null" json:"createdAt"`
+	LastSeenAt    time.Time `gorm:"column:LastSeenAt;type:timestamp;not null" json:"lastSeenAt"`
+}
+
+// TableName specifies the table name for the DeviceToken model
+func (DeviceToken) TableName() string {
+	return "DeviceToken"
+}
@@ -0,0 +1,44 @@
+// models/discovery_preferences.go
+package models
+
+import "time"
+
+// Bounds of the age range users can search for
+const (
+	MinDiscoveryAge = 18
+	MaxDiscoveryAge = 100
+)
+
+// DiscoveryPreferences describes who a user wants to see in discovery. Empty lists and zero
+// limits mean "no preference"; anything set is treated as a dealbreaker in both directions.
+type DiscoveryPreferences struct {
+	UserID            int        `gorm:"column:UserID;primaryKey" json:"userID"`
+	MinAge            int        `gorm:"column:MinAge;not null" json:"minAge" validate:"min=18,max=100"`
+	MaxAge            int        `gorm:"column:MaxAge;not null" json:"maxAge" validate:"min=18,max=100"`
+	Genders           StringList `gorm:"column:Genders;type:jsonb" json:"genders"`
+	MaxDistanceKm     float64    `gorm:"column:MaxDistanceKm" json:"maxDistanceKm"`
+	MinHeight         int        `gorm:"column:MinHeight" json:"minHeight" validate:"min=100,max=250"`
+	MaxHeight         int        `gorm:"column:MaxHeight" json:"maxHeight" validate:"min=100,max=250"`
+	RelationshipGoals StringList `gorm:"column:RelationshipGoals;type:jsonb" json:"relationshipGoals"`
+	Languages         StringList `gorm:"column:Languages;type:jsonb" json:"languages"`
+	UpdatedAt         time.Time  `gorm:"column:UpdatedAt;type:timestamp" json:"updatedAt"`
+}
+
+// TableName specifies the table name for the DiscoveryPreferences model
+func (DiscoveryPreferences) TableName() string {
+	return "DiscoveryPreferences"
+}
+
+// DefaultDiscoveryPreferences returns the preferences used for users who have not set any
+func DefaultDiscoveryPreferences(userID int) *DiscoveryPreferences {
+	return &DiscoveryPreferences{
+		UserID: userID,
+		MinAge: MinDiscoveryAge,
+		MaxAge: MaxDiscoveryAge,
+	}
+}
+
+// HasAgeRange reports whether the age range is narrower than the default
+func (p *DiscoveryPreferences) HasAgeRange() bool {
+	return p.MinAge > MinDiscoveryAge || p.MaxAge < MaxDiscoveryAge
+}
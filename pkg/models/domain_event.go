@@ -0,0 +1,137 @@
+// models/domain_event.go
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Names of the domain events
+const (
+	EventSwipeRecorded       = "SwipeRecorded"
+	EventMatchCreated        = "MatchCreated"
+	EventMessageSent         = "MessageSent"
+	EventPremiumActivated    = "PremiumActivated"
+	EventSubscriptionChanged = "SubscriptionChanged"
+	EventNotificationCreated = "NotificationCreated"
+)
+
+// DomainEvent is something that happened in the app that other parts of it may react to
+type DomainEvent interface {
+	EventName() string
+}
+
+// DecodeDomainEvent decodes the JSON payload of the event with the given name
+func DecodeDomainEvent(name string, payload []byte) (DomainEvent, error) {
+	var event DomainEvent
+	var err error
+	switch name {
+	case EventSwipeRecorded:
+		var swipe SwipeRecorded
+		err = json.Unmarshal(payload, &swipe)
+		event = swipe
+	case EventMatchCreated:
+		var match MatchCreated
+		err = json.Unmarshal(payload, &match)
+		event = match
+	case EventMessageSent:
+		var message MessageSent
+		err = json.Unmarshal(payload, &message)
+		event = message
+	case EventPremiumActivated:
+		var activated PremiumActivated
+		err = json.Unmarshal(payload, &activated)
+		event = activated
+	case EventSubscriptionChanged:
+		var changed SubscriptionChanged
+		err = json.Unmarshal(payload, &changed)
+		event = changed
+	case EventNotificationCreated:
+		var created NotificationCreated
+		err = json.Unmarshal(payload, &created)
+		event = created
+	default:
+		return nil, fmt.Errorf("unknown domain event %q", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s event: %w", name, err)
+	}
+	return event, nil
+}
+
+// SwipeRecorded is published when a user swipes on a profile
+type SwipeRecorded struct {
+	SwipeID        int       `json:"swipeID"`
+	SwiperUserID   int       `json:"swiperUserID"`
+	SwipedUserID   int       `json:"swipedUserID"`
+	SwipeDirection string    `json:"swipeDirection"`
+	SwipedAt       time.Time `json:"swipedAt"`
+}
+
+func (SwipeRecorded) EventName() string {
+	return EventSwipeRecorded
+}
+
+// MatchCreated is published when a like completes a match. UserID made the like that completed it.
+type MatchCreated struct {
+	UserID        int       `json:"userID"`
+	MatchedUserID int       `json:"matchedUserID"`
+	MatchedAt     time.Time `json:"matchedAt"`
+}
+
+func (MatchCreated) EventName() string {
+	return EventMatchCreated
+}
+
+// MessageSent is published when a user sends a direct message
+type MessageSent struct {
+	MessageID      int       `json:"messageID"`
+	SenderUserID   int       `json:"senderUserID"`
+	ReceiverUserID int       `json:"receiverUserID"`
+	SentAt         time.Time `json:"sentAt"`
+}
+
+func (MessageSent) EventName() string {
+	return EventMessageSent
+}
+
+// PremiumActivated is published when a user's paid subscription starts
+type PremiumActivated struct {
+	UserID         int       `json:"userID"`
+	SubscriptionID int       `json:"subscriptionID"`
+	PlanCode       string    `json:"planCode"`
+	PlanName       string    `json:"planName"`
+	PeriodEnd      time.Time `json:"periodEnd"`
+}
+
+func (PremiumActivated) EventName() string {
+	return EventPremiumActivated
+}
+
+// SubscriptionChanged is published whenever a subscription is created or its status or period
+// changes
+type SubscriptionChanged struct {
+	SubscriptionID   int       `json:"subscriptionID"`
+	UserID           int       `json:"userID"`
+	PlanID           int       `json:"planID"`
+	Status           string    `json:"status"`
+	CurrentPeriodEnd time.Time `json:"currentPeriodEnd"`
+}
+
+func (SubscriptionChanged) EventName() string {
+	return EventSubscriptionChanged
+}
+
+// NotificationCreated is published when a notification is added to a user's inbox
+type NotificationCreated struct {
+	NotificationID   int       `json:"notificationID"`
+	UserID           int       `json:"userID"`
+	NotificationType string    `json:"notificationType"`
+	Message          string    `json:"message"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+func (NotificationCreated) EventName() string {
+	return EventNotificationCreated
+}
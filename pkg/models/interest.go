@@ -0,0 +1,16 @@
+// models/interest.go
+package models
+
+// Interest is an entry of the curated interest taxonomy that profiles pick from
+type Interest struct {
+	InterestID int    `gorm:"column:InterestID;primaryKey" json:"interestID"`
+	Slug       string `gorm:"column:Slug;size:50;not null;unique" json:"slug"`
+	Name       string `gorm:"column:Name;size:100;not null" json:"name"`
+	Category   string `gorm:"column:Category;size:50;not null" json:"category"`
+	IsActive   bool   `gorm:"column:IsActive;default:true" json:"isActive"`
+}
+
+// TableName specifies the table name for the Interest model
+func (Interest) TableName() string {
+	return "Interest"
+}
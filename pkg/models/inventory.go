@@ -0,0 +1,77 @@
+// models/inventory.go
+package models
+
+import "time"
+
+// Consumable item types held in a user's inventory
+const (
+	ItemSuperLike = "super_like"
+	ItemBoost     = "boost"
+	ItemRewind    = "rewind"
+)
+
+// ItemTypes lists every consumable item type
+var ItemTypes = []string{ItemSuperLike, ItemBoost, ItemRewind}
+
+// BoostDuration is how long one boost raises the user's visibility in discovery
+const BoostDuration = 30 * time.Minute
+
+// ConsumablePack is a purchasable bundle of consumable items
+type ConsumablePack struct {
+	PackID     int    `gorm:"column:PackID;primaryKey" json:"packID"`
+	Code       string `gorm:"column:Code;size:30;not null;unique" json:"code"`
+	Name       string `gorm:"column:Name;size:50;not null" json:"name"`
+	ItemType   string `gorm:"column:ItemType;size:20;not null" json:"itemType"`
+	Quantity   int    `gorm:"column:Quantity;not null" json:"quantity"`
+	PriceCents int    `gorm:"column:PriceCents;not null" json:"priceCents"`
+	Currency   string `gorm:"column:Currency;size:3;not null" json:"currency"`
+	IsActive   bool   `gorm:"column:IsActive;default:true" json:"-"`
+}
+
+// TableName specifies the table name for the ConsumablePack model
+func (ConsumablePack) TableName() string {
+	return "ConsumablePack"
+}
+
+// InventoryLot is one grant of consumable items, from a plan allowance or a purchase. Items are
+// consumed from the lot that expires first; plan allowances expire with their period, purchases
+// never do.
+type InventoryLot struct {
+	LotID    int    `gorm:"column:LotID;primaryKey" json:"lotID"`
+	UserID   int    `gorm:"column:UserID;not null" json:"userID"`
+	ItemType string `gorm:"column:ItemType;size:20;not null" json:"itemType"`
+	// Source identifies the grant, e.g. "allowance:week:2024-W10" or "purchase:stripe:pi_1", so it
+	// is granted only once
+	Source    string     `gorm:"column:Source;size:100;not null" json:"source"`
+	Granted   int        `gorm:"column:Granted;not null" json:"granted"`
+	Remaining int        `gorm:"column:Remaining;not null" json:"remaining"`
+	ExpiresAt *time.Time `gorm:"column:ExpiresAt;type:timestamp" json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `gorm:"column:CreatedAt;type:timestamp" json:"createdAt"`
+}
+
+// TableName specifies the table name for the InventoryLot model
+func (InventoryLot) TableName() string {
+	return "InventoryLot"
+}
+
+// Boost is a period during which the user is shown higher up in other users' discovery feeds
+type Boost struct {
+	BoostID  int       `gorm:"column:BoostID;primaryKey" json:"boostID"`
+	UserID   int       `gorm:"column:UserID;not null" json:"userID"`
+	LotID    *int      `gorm:"column:LotID" json:"-"`
+	StartsAt time.Time `gorm:"column:StartsAt;type:timestamp;not null" json:"startsAt"`
+	EndsAt   time.Time `gorm:"column:EndsAt;type:timestamp;not null" json:"endsAt"`
+}
+
+// TableName specifies the table name for the Boost model
+func (Boost) TableName() string {
+	return "Boost"
+}
+
+// Wallet is what a user holds of each consumable item
+type Wallet struct {
+	UserID int `json:"userID"`
+	// Balances maps item types to the items available now, or UnlimitedQuota
+	Balances    map[string]int `json:"balances"`
+	ActiveBoost *Boost         `json:"activeBoost,omitempty"`
+}
@@ -0,0 +1,98 @@
+// models/json_types.go
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// StringList is a list of strings stored as a jsonb array
+type StringList []string
+
+// Scan implements sql.Scanner
+func (l *StringList) Scan(value interface{}) error {
+	data, err := jsonBytes(value)
+	if err != nil || data == nil {
+		*l = nil
+		return err
+	}
+	return json.Unmarshal(data, l)
+}
+
+// Value implements driver.Valuer; a nil list is stored as an empty array
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	return string(data), err
+}
+
+// Contains reports whether the list holds the given value
+func (l StringList) Contains(value string) bool {
+	for _, item := range l {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SocialMediaAccounts maps a platform name to the user's profile URL, stored as a jsonb object
+type SocialMediaAccounts map[string]string
+
+// Scan implements sql.Scanner
+func (a *SocialMediaAccounts) Scan(value interface{}) error {
+	data, err := jsonBytes(value)
+	if err != nil || data == nil {
+		*a = nil
+		return err
+	}
+	return json.Unmarshal(data, a)
+}
+
+// Value implements driver.Valuer; a nil map is stored as an empty object
+func (a SocialMediaAccounts) Value() (driver.Value, error) {
+	if a == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(a)
+	return string(data), err
+}
+
+// NotificationMutes maps a notification type to the channels it is muted on, stored as a jsonb
+// object
+type NotificationMutes map[string]StringList
+
+// Scan implements sql.Scanner
+func (m *NotificationMutes) Scan(value interface{}) error {
+	data, err := jsonBytes(value)
+	if err != nil || data == nil {
+		*m = nil
+		return err
+	}
+	return json.Unmarshal(data, m)
+}
+
+// Value implements driver.Valuer; a nil map is stored as an empty object
+func (m NotificationMutes) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+func jsonBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("cannot scan %T into a JSON field", value)
+	}
+}
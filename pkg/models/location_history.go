@@ -11,6 +11,10 @@ type LocationHistory struct {
 	Latitude   float64   `json:"latitude" db:"Latitude" gorm:"column:Latitude"`
 	Longitude  float64   `json:"longitude" db:"Longitude" gorm:"column:Longitude"`
 	Timestamp  time.Time `json:"timestamp" db:"Timestamp" gorm:"column:Timestamp"`
+	// IsCoarse marks older points that retention has reduced to a coarse cell
+	IsCoarse bool `json:"isCoarse" db:"IsCoarse" gorm:"column:IsCoarse;default:false"`
+	// IsFuzzed marks points stored fuzzed; others were stored exact and are yet to be fuzzed
+	IsFuzzed bool `json:"-" db:"IsFuzzed" gorm:"column:IsFuzzed"`
 }
 
 // Set the table name for the LocationHistory model
@@ -3,11 +3,11 @@ package models
 import "time"
 
 type Message struct {
-	MessageID      int       `gorm:"primaryKey"`
-	SenderUserID   int       `gorm:"not null"`
-	ReceiverUserID int       `gorm:"not null"`
-	MessageContent string    `gorm:"type:text;not null"`
-	Timestamp      time.Time `gorm:"type:timestamp"`
+	MessageID      int       `gorm:"column:MessageID;primaryKey" json:"messageID"`
+	SenderUserID   int       `gorm:"column:SenderUserID;not null" json:"senderUserID"`
+	ReceiverUserID int       `gorm:"column:ReceiverUserID;not null" json:"receiverUserID"`
+	MessageContent string    `gorm:"column:MessageContent;type:text;not null" json:"messageContent"`
+	Timestamp      time.Time `gorm:"column:Timestamp;type:timestamp" json:"timestamp"`
 }
 
 // Set the table name for the LocationHistory model
@@ -0,0 +1,46 @@
+// models/notification_delivery.go
+package models
+
+import "time"
+
+// Channels a notification is delivered on. Every notification is recorded in the in-app inbox;
+// the other channels are tracked by a NotificationDelivery. Sending email is out of scope for
+// now: email mutes are stored with the other preferences, ready for when it is added.
+const (
+	DeliveryChannelInApp = "in-app"
+	DeliveryChannelPush  = "push"
+	DeliveryChannelEmail = "email"
+)
+
+// Notification delivery statuses
+const (
+	// DeliveryStatusPending is waiting to be sent, or to be retried after a failure
+	DeliveryStatusPending = "Pending"
+	// DeliveryStatusDigest is waiting to be sent with the user's next digest
+	DeliveryStatusDigest = "Digest"
+	// DeliveryStatusSent reached at least one of the user's devices
+	DeliveryStatusSent = "Sent"
+	// DeliveryStatusSkipped was not sent because the user has no devices to send it to or muted it
+	DeliveryStatusSkipped = "Skipped"
+	// DeliveryStatusFailed was given up on after too many failed attempts
+	DeliveryStatusFailed = "Failed"
+)
+
+// NotificationDelivery tracks sending a notification on a channel
+type NotificationDelivery struct {
+	NotificationID int        `gorm:"column:NotificationID;primaryKey" json:"notificationID"`
+	Channel        string     `gorm:"column:Channel;primaryKey;size:20" json:"channel"`
+	UserID         int        `gorm:"column:UserID;not null" json:"-"`
+	Status         string     `gorm:"column:Status;size:20;not null" json:"status"`
+	Attempts       int        `gorm:"column:Attempts;not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"column:NextAttemptAt;type:timestamp;not null" json:"nextAttemptAt"`
+	LastError      string     `gorm:"column:LastError;type:text" json:"lastError,omitempty"`
+	DeliveredAt    *time.Time `gorm:"column:DeliveredAt;type:timestamp" json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time  `gorm:"column:CreatedAt;type:timestamp;not null" json:"createdAt"`
+	UpdatedAt      time.Time  `gorm:"column:UpdatedAt;type:timestamp;not null" json:"updatedAt"`
+}
+
+// TableName specifies the table name for the NotificationDelivery model
+func (NotificationDelivery) TableName() string {
+	return "NotificationDelivery"
+}
@@ -0,0 +1,101 @@
+// models/notification_preferences.go
+package models
+
+import "time"
+
+// AllNotificationTypes mutes a channel for every notification type when used as a key of
+// NotificationPreferences.Muted
+const AllNotificationTypes = "*"
+
+// DefaultDigestTime is when the daily digest is sent to users who have not chosen a time
+const DefaultDigestTime = "18:00"
+
+// NotificationPreferences controls how a user is notified. Muted lists the channels each
+// notification type is muted on. Quiet hours hold back pushes between QuietHoursStart and
+// QuietHoursEnd in the user's time zone, and may span midnight; they are off unless both are set.
+// With DigestEnabled, low priority notifications are batched into one push a day at DigestTime.
+// Notifications are always recorded in the inbox, whatever the preferences.
+type NotificationPreferences struct {
+	UserID          int               `gorm:"column:UserID;primaryKey" json:"userID"`
+	Muted           NotificationMutes `gorm:"column:Muted;type:jsonb" json:"muted"`
+	QuietHoursStart string            `gorm:"column:QuietHoursStart;size:5" json:"quietHoursStart" validate:"clock"`
+	QuietHoursEnd   string            `gorm:"column:QuietHoursEnd;size:5" json:"quietHoursEnd" validate:"clock"`
+	DigestEnabled   bool              `gorm:"column:DigestEnabled;not null" json:"digestEnabled"`
+	DigestTime      string            `gorm:"column:DigestTime;size:5;not null" json:"digestTime" validate:"required,clock"`
+	UpdatedAt       time.Time         `gorm:"column:UpdatedAt;type:timestamp" json:"updatedAt"`
+}
+
+// TableName specifies the table name for the NotificationPreferences model
+func (NotificationPreferences) TableName() string {
+	return "NotificationPreferences"
+}
+
+// DefaultNotificationPreferences returns the preferences used for users who have not set any:
+// everything on every channel, no quiet hours and no digest
+func DefaultNotificationPreferences(userID int) *NotificationPreferences {
+	return &NotificationPreferences{
+		UserID:     userID,
+		Muted:      NotificationMutes{},
+		DigestTime: DefaultDigestTime,
+	}
+}
+
+// IsMuted reports whether the notification type is muted on the channel, by itself or along with
+// every other type
+func (p *NotificationPreferences) IsMuted(notificationType, channel string) bool {
+	return p.Muted[notificationType].Contains(channel) || p.Muted[AllNotificationTypes].Contains(channel)
+}
+
+// QuietUntil returns when the quiet hours around now end, or the zero time when now is outside
+// them or they are off
+func (p *NotificationPreferences) QuietUntil(now time.Time, location *time.Location) time.Time {
+	start, startOK := ParseClock(p.QuietHoursStart)
+	end, endOK := ParseClock(p.QuietHoursEnd)
+	if !startOK || !endOK || start == end {
+		return time.Time{}
+	}
+
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	switch {
+	case start < end && minute >= start && minute < end:
+		return atClock(local, 0, end)
+	case start > end && minute >= start:
+		return atClock(local, 1, end)
+	case start > end && minute < end:
+		return atClock(local, 0, end)
+	}
+	return time.Time{}
+}
+
+// NextDigestAt returns when the next digest after now is due
+func (p *NotificationPreferences) NextDigestAt(now time.Time, location *time.Location) time.Time {
+	digest, ok := ParseClock(p.DigestTime)
+	if !ok {
+		digest, _ = ParseClock(DefaultDigestTime)
+	}
+
+	local := now.In(location)
+	next := atClock(local, 0, digest)
+	if !next.After(now) {
+		next = atClock(local, 1, digest)
+	}
+	return next
+}
+
+// ParseClock parses a time of day written as HH:MM into minutes after midnight
+func ParseClock(clock string) (int, bool) {
+	if len(clock) != 5 {
+		return 0, false
+	}
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, false
+	}
+	return parsed.Hour()*60 + parsed.Minute(), true
+}
+
+// atClock returns the given minute after midnight, days after the local date of t
+func atClock(t time.Time, days, minute int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+days, minute/60, minute%60, 0, 0, t.Location())
+}
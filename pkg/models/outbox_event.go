@@ -0,0 +1,56 @@
+// models/outbox_event.go
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxEvent is a domain event recorded in the same transaction as the change it describes. The
+// outbox relay publishes it to the message broker, retrying until it is published or, after too
+// many failed attempts, marking it dead.
+type OutboxEvent struct {
+	OutboxEventID int64      `gorm:"column:OutboxEventID;primaryKey" json:"outboxEventID"`
+	EventName     string     `gorm:"column:EventName;size:50;not null" json:"eventName"`
+	Payload       string     `gorm:"column:Payload;type:jsonb;not null" json:"payload"`
+	CreatedAt     time.Time  `gorm:"column:CreatedAt;type:timestamp;not null" json:"createdAt"`
+	AvailableAt   time.Time  `gorm:"column:AvailableAt;type:timestamp;not null" json:"availableAt"`
+	Attempts      int        `gorm:"column:Attempts;not null;default:0" json:"attempts"`
+	LastError     string     `gorm:"column:LastError;type:text" json:"lastError,omitempty"`
+	PublishedAt   *time.Time `gorm:"column:PublishedAt;type:timestamp" json:"publishedAt,omitempty"`
+	DeadAt        *time.Time `gorm:"column:DeadAt;type:timestamp" json:"deadAt,omitempty"`
+}
+
+// TableName specifies the table name for the OutboxEvent model
+func (OutboxEvent) TableName() string {
+	return "OutboxEvent"
+}
+
+// NewOutboxEvent returns the outbox entry of the event, ready to be published at once
+func NewOutboxEvent(event DomainEvent, now time.Time) (*OutboxEvent, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxEvent{
+		EventName:   event.EventName(),
+		Payload:     string(payload),
+		CreatedAt:   now,
+		AvailableAt: now,
+	}, nil
+}
+
+// ProcessedEvent records that a consumer has handled a published event, so redeliveries of it are
+// skipped. The handlers and side effects completed while handling it are recorded under
+// "<consumer>/<handler>" and "<consumer>/<handler>/<effect>", so a retry after a partial failure
+// does not repeat them.
+type ProcessedEvent struct {
+	Consumer    string    `gorm:"column:Consumer;primaryKey;size:150" json:"consumer"`
+	EventID     string    `gorm:"column:EventID;primaryKey;size:50" json:"eventID"`
+	ProcessedAt time.Time `gorm:"column:ProcessedAt;type:timestamp;not null" json:"processedAt"`
+}
+
+// TableName specifies the table name for the ProcessedEvent model
+func (ProcessedEvent) TableName() string {
+	return "ProcessedEvent"
+}
@@ -0,0 +1,27 @@
+// models/passport.go
+package models
+
+import "time"
+
+// Passport is a premium user's virtual location. While it has not expired, the user searches and
+// is found from here instead of their real location, which is still recorded.
+type Passport struct {
+	UserID    int       `gorm:"column:UserID;primaryKey" json:"userID"`
+	PlaceName string    `gorm:"column:PlaceName;size:100" json:"placeName,omitempty"`
+	Latitude  float64   `gorm:"column:Latitude;not null" json:"latitude"`
+	Longitude float64   `gorm:"column:Longitude;not null" json:"longitude"`
+	ExpiresAt time.Time `gorm:"column:ExpiresAt;type:timestamp;not null" json:"expiresAt"`
+	CreatedAt time.Time `gorm:"column:CreatedAt;type:timestamp" json:"createdAt"`
+	// IsFuzzed marks passports placed on a fuzzed position or a city's centre
+	IsFuzzed bool `gorm:"column:IsFuzzed" json:"-"`
+}
+
+// TableName specifies the table name for the Passport model
+func (Passport) TableName() string {
+	return "Passport"
+}
+
+// IsActive reports whether the passport is still in effect at the given time
+func (p *Passport) IsActive(now time.Time) bool {
+	return now.Before(p.ExpiresAt)
+}
@@ -0,0 +1,41 @@
+// models/payment_event.go
+package models
+
+import "time"
+
+// Payment event ledger statuses
+const (
+	PaymentEventStatusReceived   = "Received"
+	PaymentEventStatusProcessing = "Processing"
+	PaymentEventStatusProcessed  = "Processed"
+	PaymentEventStatusIgnored    = "Ignored"
+	PaymentEventStatusFailed     = "Failed"
+)
+
+// PaymentEvent is an entry in the ledger of webhook events received from the payment provider.
+// Every delivery is recorded once, with its raw payload and what processing it did.
+type PaymentEvent struct {
+	PaymentEventID         int        `gorm:"column:PaymentEventID;primaryKey" json:"paymentEventID"`
+	Provider               string     `gorm:"column:Provider;size:20;not null" json:"provider"`
+	ProviderEventID        string     `gorm:"column:ProviderEventID;size:100;not null" json:"providerEventID"`
+	EventType              string     `gorm:"column:EventType;size:40;not null" json:"eventType"`
+	ProviderEventType      string     `gorm:"column:ProviderEventType;size:100;not null" json:"providerEventType"`
+	UserID                 *int       `gorm:"column:UserID" json:"userID,omitempty"`
+	SubscriptionID         *int       `gorm:"column:SubscriptionID" json:"subscriptionID,omitempty"`
+	ProviderSubscriptionID string     `gorm:"column:ProviderSubscriptionID;size:100" json:"providerSubscriptionID,omitempty"`
+	ProviderChargeID       string     `gorm:"column:ProviderChargeID;size:100" json:"providerChargeID,omitempty"`
+	ProviderPaymentID      string     `gorm:"column:ProviderPaymentID;size:100" json:"providerPaymentID,omitempty"`
+	AmountCents            int        `gorm:"column:AmountCents" json:"amountCents"`
+	Currency               string     `gorm:"column:Currency;size:3" json:"currency,omitempty"`
+	Payload                string     `gorm:"column:Payload;type:text;not null" json:"-"`
+	Status                 string     `gorm:"column:Status;size:20;not null" json:"status"`
+	Error                  string     `gorm:"column:Error;type:text" json:"error,omitempty"`
+	ReceivedAt             time.Time  `gorm:"column:ReceivedAt;type:timestamp;not null" json:"receivedAt"`
+	ProcessedAt            *time.Time `gorm:"column:ProcessedAt;type:timestamp" json:"processedAt,omitempty"`
+	UpdatedAt              time.Time  `gorm:"column:UpdatedAt;type:timestamp;not null" json:"updatedAt"`
+}
+
+// TableName specifies the table name for the PaymentEvent model
+func (PaymentEvent) TableName() string {
+	return "PaymentEvent"
+}
@@ -0,0 +1,29 @@
+// models/photo.go
+package models
+
+import "time"
+
+// Limits on the number of photos a profile may have
+const (
+	MinProfilePhotos = 1
+	MaxProfilePhotos = 9
+)
+
+// Photo holds the metadata of a profile photo; the image itself lives in the blob store.
+type Photo struct {
+	PhotoID      int       `gorm:"column:PhotoID;primaryKey" json:"photoID"`
+	UserID       int       `gorm:"column:UserID;not null" json:"userID"`
+	Position     int       `gorm:"column:Position;not null" json:"position"`
+	StorageKey   string    `gorm:"column:StorageKey;size:255;not null" json:"-"`
+	ThumbnailKey string    `gorm:"column:ThumbnailKey;size:255;not null" json:"-"`
+	ContentType  string    `gorm:"column:ContentType;size:50;not null" json:"contentType"`
+	Width        int       `gorm:"column:Width" json:"width"`
+	Height       int       `gorm:"column:Height" json:"height"`
+	SizeBytes    int64     `gorm:"column:SizeBytes" json:"sizeBytes"`
+	CreatedAt    time.Time `gorm:"column:CreatedAt;type:timestamp" json:"createdAt"`
+}
+
+// TableName specifies the table name for the Photo model
+func (Photo) TableName() string {
+	return "Photo"
+}
@@ -2,17 +2,17 @@
 package models
 
 type Profile struct {
-	ProfileID           int    `gorm:"column:ProfileID;primaryKey"`
-	UserID              int    `gorm:"column:UserID;not null"`
-	Photos              string `gorm:"column:Photos;type:jsonb"`
-	AboutMe             string `gorm:"column:AboutMe;type:text"`
-	Interests           string `gorm:"column:Interests;type:jsonb"`
-	RelationshipGoals   string `gorm:"column:RelationshipGoals;type:text"`
-	Height              int    `gorm:"column:Height;type:int"`
-	Language            string `gorm:"column:Language;size:50"`
-	ZodiacSign          string `gorm:"column:ZodiacSign;size:50"`
-	EducationDetails    string `gorm:"column:EducationDetails;type:text"`
-	SocialMediaAccounts string `gorm:"column:SocialMediaAccounts;type:jsonb"`
+	ProfileID           int                 `gorm:"column:ProfileID;primaryKey"`
+	UserID              int                 `gorm:"column:UserID;not null"`
+	Photos              StringList          `gorm:"column:Photos;type:jsonb" validate:"count=:9"`
+	AboutMe             string              `gorm:"column:AboutMe;type:text" validate:"max=500"`
+	Interests           StringList          `gorm:"column:Interests;type:jsonb" validate:"interests=10"`
+	RelationshipGoals   string              `gorm:"column:RelationshipGoals;type:text" validate:"enum=relationshipGoal"`
+	Height              int                 `gorm:"column:Height;type:int" validate:"min=100,max=250"`
+	Language            string              `gorm:"column:Language;size:50" validate:"enum=language"`
+	ZodiacSign          string              `gorm:"column:ZodiacSign;size:50" validate:"enum=zodiac"`
+	EducationDetails    string              `gorm:"column:EducationDetails;type:text" validate:"max=255"`
+	SocialMediaAccounts SocialMediaAccounts `gorm:"column:SocialMediaAccounts;type:jsonb" validate:"socials"`
 }
 
 // Set the table name for the LocationHistory model
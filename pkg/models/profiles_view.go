@@ -3,6 +3,8 @@ package models
 
 import "time"
 
+// ProfileView records that a profile was shown to a viewer. DateOnly is the viewer's local date;
+// a profile is recorded at most once per viewer and day.
 type ProfileView struct {
 	ID           int       `gorm:"column:ProfileViewID;primaryKey"`
 	ViewerUserID int       `gorm:"column:ViewerUserID;uniqueIndex:UniqueProfileView;not null"`
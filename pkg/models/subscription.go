@@ -0,0 +1,155 @@
+// models/subscription.go
+package models
+
+import "time"
+
+// Subscription statuses. A canceled subscription keeps its entitlements until the period ends, and
+// a past due one, whose renewal failed, until its grace period ends.
+const (
+	SubscriptionStatusActive   = "Active"
+	SubscriptionStatusCanceled = "Canceled"
+	SubscriptionStatusPastDue  = "PastDue"
+	SubscriptionStatusExpired  = "Expired"
+)
+
+// PlanFree is the plan code reported for users without a subscription
+const PlanFree = "free"
+
+// UnlimitedQuota marks a per-day allowance without a limit
+const UnlimitedQuota = -1
+
+// FreeDailyLikes is how many profiles a user without unlimited likes may swipe per day
+const FreeDailyLikes = 10
+
+// FreeRewindsPerDay is how many swipes a user without a subscription may take back per day
+const FreeRewindsPerDay = 1
+
+// FreeSuperLikesPerWeek is how many super likes a user without a subscription gets each week
+const FreeSuperLikesPerWeek = 1
+
+// Plan is a purchasable subscription tier and the entitlements it grants
+type Plan struct {
+	PlanID             int    `gorm:"column:PlanID;primaryKey" json:"planID"`
+	Code               string `gorm:"column:Code;size:30;not null;unique" json:"code"`
+	Name               string `gorm:"column:Name;size:50;not null" json:"name"`
+	Rank               int    `gorm:"column:Rank;not null" json:"rank"`
+	PriceCents         int    `gorm:"column:PriceCents;not null" json:"priceCents"`
+	Currency           string `gorm:"column:Currency;size:3;not null" json:"currency"`
+	PeriodMonths       int    `gorm:"column:PeriodMonths;not null" json:"periodMonths"`
+	UnlimitedLikes     bool   `gorm:"column:UnlimitedLikes" json:"unlimitedLikes"`
+	SeeWhoLikesYou     bool   `gorm:"column:SeeWhoLikesYou" json:"seeWhoLikesYou"`
+	RewindsPerDay      int    `gorm:"column:RewindsPerDay" json:"rewindsPerDay"`
+	SuperLikesPerWeek  int    `gorm:"column:SuperLikesPerWeek" json:"superLikesPerWeek"`
+	Passport           bool   `gorm:"column:Passport" json:"passport"`
+	BoostsPerMonth     int    `gorm:"column:BoostsPerMonth" json:"boostsPerMonth"`
+	MessageBeforeMatch bool   `gorm:"column:MessageBeforeMatch" json:"messageBeforeMatch"`
+	IsActive           bool   `gorm:"column:IsActive;default:true" json:"-"`
+}
+
+// TableName specifies the table name for the Plan model
+func (Plan) TableName() string {
+	return "Plan"
+}
+
+// Subscription is a user's purchase of a plan for the current billing period
+type Subscription struct {
+	SubscriptionID     int        `gorm:"column:SubscriptionID;primaryKey" json:"subscriptionID"`
+	UserID             int        `gorm:"column:UserID;not null" json:"userID"`
+	PlanID             int        `gorm:"column:PlanID;not null" json:"planID"`
+	Status             string     `gorm:"column:Status;size:20;not null" json:"status"`
+	CurrentPeriodStart time.Time  `gorm:"column:CurrentPeriodStart;type:timestamp;not null" json:"currentPeriodStart"`
+	CurrentPeriodEnd   time.Time  `gorm:"column:CurrentPeriodEnd;type:timestamp;not null" json:"currentPeriodEnd"`
+	CanceledAt         *time.Time `gorm:"column:CanceledAt;type:timestamp" json:"canceledAt,omitempty"`
+	GraceUntil         *time.Time `gorm:"column:GraceUntil;type:timestamp" json:"graceUntil,omitempty"`
+	// ReminderSentFor is the end of access the last expiry reminder was sent for
+	ReminderSentFor *time.Time `gorm:"column:ReminderSentFor;type:timestamp" json:"-"`
+
+	// Provider references, empty for subscriptions granted without a payment
+	Provider               string `gorm:"column:Provider;size:20" json:"-"`
+	ProviderSubscriptionID string `gorm:"column:ProviderSubscriptionID;size:100" json:"-"`
+	ProviderCustomerID     string `gorm:"column:ProviderCustomerID;size:100" json:"-"`
+
+	CreatedAt time.Time `gorm:"column:CreatedAt;type:timestamp" json:"createdAt"`
+	UpdatedAt time.Time `gorm:"column:UpdatedAt;type:timestamp" json:"updatedAt"`
+
+	Plan Plan `gorm:"foreignKey:PlanID;references:PlanID" json:"plan"`
+}
+
+// TableName specifies the table name for the Subscription model
+func (Subscription) TableName() string {
+	return "Subscription"
+}
+
+// AccessEndsAt returns when the subscription stops granting its plan unless it is renewed
+func (s *Subscription) AccessEndsAt() time.Time {
+	if s.Status == SubscriptionStatusPastDue && s.GraceUntil != nil && s.GraceUntil.After(s.CurrentPeriodEnd) {
+		return *s.GraceUntil
+	}
+	return s.CurrentPeriodEnd
+}
+
+// IsActive reports whether the subscription still grants its plan at the given time
+func (s *Subscription) IsActive(now time.Time) bool {
+	return s.Status != SubscriptionStatusExpired && now.Before(s.AccessEndsAt())
+}
+
+// Renews reports whether the payment provider is expected to renew the subscription at the end of
+// the period
+func (s *Subscription) Renews() bool {
+	return s.Status == SubscriptionStatusActive && s.ProviderSubscriptionID != ""
+}
+
+// Entitlements are the features a user may use right now, derived from their active plan
+type Entitlements struct {
+	UserID             int        `json:"userID"`
+	Plan               string     `json:"plan"`
+	PlanName           string     `json:"planName"`
+	ExpiresAt          *time.Time `json:"expiresAt,omitempty"`
+	UnlimitedLikes     bool       `json:"unlimitedLikes"`
+	DailyLikes         int        `json:"dailyLikes"`
+	SeeWhoLikesYou     bool       `json:"seeWhoLikesYou"`
+	RewindsPerDay      int        `json:"rewindsPerDay"`
+	SuperLikesPerWeek  int        `json:"superLikesPerWeek"`
+	Passport           bool       `json:"passport"`
+	BoostsPerMonth     int        `json:"boostsPerMonth"`
+	MessageBeforeMatch bool       `json:"messageBeforeMatch"`
+}
+
+// FreeEntitlements returns what a user without a subscription may do
+func FreeEntitlements(userID int) *Entitlements {
+	return &Entitlements{
+		UserID:            userID,
+		Plan:              PlanFree,
+		PlanName:          "Free",
+		DailyLikes:        FreeDailyLikes,
+		RewindsPerDay:     FreeRewindsPerDay,
+		SuperLikesPerWeek: FreeSuperLikesPerWeek,
+	}
+}
+
+// PlanEntitlements returns what the plan grants until the given time
+func PlanEntitlements(userID int, plan *Plan, expiresAt time.Time) *Entitlements {
+	entitlements := &Entitlements{
+		UserID:             userID,
+		Plan:               plan.Code,
+		PlanName:           plan.Name,
+		ExpiresAt:          &expiresAt,
+		UnlimitedLikes:     plan.UnlimitedLikes,
+		DailyLikes:         FreeDailyLikes,
+		SeeWhoLikesYou:     plan.SeeWhoLikesYou,
+		RewindsPerDay:      plan.RewindsPerDay,
+		SuperLikesPerWeek:  plan.SuperLikesPerWeek,
+		Passport:           plan.Passport,
+		BoostsPerMonth:     plan.BoostsPerMonth,
+		MessageBeforeMatch: plan.MessageBeforeMatch,
+	}
+	if plan.UnlimitedLikes {
+		entitlements.DailyLikes = UnlimitedQuota
+	}
+	return entitlements
+}
+
+// IsPaid reports whether the user has any paid plan
+func (e *Entitlements) IsPaid() bool {
+	return e.Plan != PlanFree
+}
@@ -5,6 +5,19 @@ import (
 	"time"
 )
 
+// Swipe directions. A super like is a like that the swiped user is told about, and costs a
+// super like from the swiper's inventory.
+const (
+	SwipeDirectionLeft  = "left"
+	SwipeDirectionRight = "right"
+	SwipeDirectionSuper = "super"
+)
+
+// IsLike reports whether the swipe direction expresses interest
+func IsLike(direction string) bool {
+	return direction == SwipeDirectionRight || direction == SwipeDirectionSuper
+}
+
 type SwipeHistory struct {
 	SwipeHistoryEntityID int       `gorm:"column:SwipeHistoryEntityID;primaryKey;autoIncrement" json:"swipeHistoryEntityID"`
 	SwiperUserID         int       `gorm:"column:SwiperUserID" json:"swiperUserID"`
@@ -3,20 +3,51 @@ package models
 import "time"
 
 type User struct {
-	UserID             int       `gorm:"column:UserID;primaryKey"`
-	Username           string    `gorm:"column:Username;not null;unique"`
-	Email              string    `gorm:"column:Email;not null;unique"`
-	Password           string    `gorm:"column:Password;not null"`
-	VerificationStatus bool      `gorm:"column:VerificationStatus;default:false"`
-	VerificationBadge  bool      `gorm:"column:VerificationBadge;default:false"`
-	PremiumStatus      string    `gorm:"column:PremiumStatus;default:'Free';not null"`
-	PremiumStartDate   time.Time `gorm:"column:PremiumStartDate;type:timestamp"`
-	PremiumEndDate     time.Time `gorm:"column:PremiumEndDate;type:timestamp"`
-	Gender             string    `gorm:"column:Gender;size:20"`
-	Company            string    `gorm:"column:Company;size:255"`
-	School             string    `gorm:"column:School;size:255"`
-	JobTitle           string    `gorm:"column:JobTitle;size:255"`
-	VerifiedBadge      bool      `gorm:"column:VerifiedBadge;default:false"`
+	UserID             int        `gorm:"column:UserID;primaryKey"`
+	Username           string     `gorm:"column:Username;not null;unique" validate:"required,min=3,max=30"`
+	Email              string     `gorm:"column:Email;not null;unique" validate:"required,email"`
+	Password           string     `gorm:"column:Password;not null"`
+	VerificationStatus bool       `gorm:"column:VerificationStatus;default:false"`
+	VerificationBadge  bool       `gorm:"column:VerificationBadge;default:false"`
+	BirthDate          *time.Time `gorm:"column:BirthDate;type:date" validate:"age=18"`
+	Gender             string     `gorm:"column:Gender;size:20" validate:"enum=gender"`
+	Company            string     `gorm:"column:Company;size:255" validate:"max=100"`
+	School             string     `gorm:"column:School;size:255" validate:"max=100"`
+	JobTitle           string     `gorm:"column:JobTitle;size:255" validate:"max=100"`
+	VerifiedBadge      bool       `gorm:"column:VerifiedBadge;default:false"`
+	IsIncognito        bool       `gorm:"column:IsIncognito;default:false"`
+	TimeZone           string     `gorm:"column:TimeZone;size:64" validate:"timezone"`
+}
+
+// Age returns the user's age in whole years, or nil when the birth date is unknown
+func (u *User) Age(now time.Time) *int {
+	if u.BirthDate == nil {
+		return nil
+	}
+	age := AgeOn(*u.BirthDate, now)
+	return &age
+}
+
+// Location returns the user's time zone, which decides when their daily limits reset. Users who
+// have not set one are on UTC.
+func (u *User) Location() *time.Location {
+	if u.TimeZone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(u.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// AgeOn returns the age in whole years of someone born on birthDate
+func AgeOn(birthDate, now time.Time) int {
+	age := now.Year() - birthDate.Year()
+	if now.Month() < birthDate.Month() || (now.Month() == birthDate.Month() && now.Day() < birthDate.Day()) {
+		age--
+	}
+	return age
 }
 
 // Set the table name for the LocationHistory model
@@ -0,0 +1,104 @@
+// block_repository.go
+package repository
+
+import (
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm/clause"
+)
+
+type BlockRepository interface {
+	CreateBlock(blockerUserID, blockedUserID int) error
+	DeleteBlock(blockerUserID, blockedUserID int) error
+	IsBlocked(userID1, userID2 int) (bool, error)
+	GetBlockedAmong(userID int, otherUserIDs []int) (map[int]bool, error)
+}
+
+type blockRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewBlockRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) BlockRepository {
+	return &blockRepository{db: db, redis: redis}
+}
+
+func (r *blockRepository) CreateBlock(blockerUserID, blockedUserID int) error {
+	block := models.Block{
+		BlockerUserID: blockerUserID,
+		BlockedUserID: blockedUserID,
+		Timestamp:     time.Now(),
+	}
+
+	// Blocking someone twice is a no-op
+	result := r.db.Model(&models.Block{}).Clauses(clause.OnConflict{DoNothing: true}).Create(&block)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *blockRepository) DeleteBlock(blockerUserID, blockedUserID int) error {
+	result := r.db.Where(`"BlockerUserID" = ? AND "BlockedUserID" = ?`, blockerUserID, blockedUserID).Delete(&models.Block{})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// IsBlocked reports whether either user has blocked the other
+func (r *blockRepository) IsBlocked(userID1, userID2 int) (bool, error) {
+	var count int64
+	result := r.db.Model(&models.Block{}).
+		Where(`("BlockerUserID" = ? AND "BlockedUserID" = ?) OR ("BlockerUserID" = ? AND "BlockedUserID" = ?)`, userID1, userID2, userID2, userID1).
+		Count(&count)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return count > 0, nil
+}
+
+// GetBlockedAmong returns which of the other users have blocked the user or been blocked by them
+func (r *blockRepository) GetBlockedAmong(userID int, otherUserIDs []int) (map[int]bool, error) {
+	blocked := make(map[int]bool)
+	if len(otherUserIDs) == 0 {
+		return blocked, nil
+	}
+
+	var blocks []models.Block
+	result := r.db.Where(`("BlockerUserID" = ? AND "BlockedUserID" IN ?) OR ("BlockedUserID" = ? AND "BlockerUserID" IN ?)`, userID, otherUserIDs, userID, otherUserIDs).
+		Find(&blocks)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for _, block := range blocks {
+		if block.BlockerUserID == userID {
+			blocked[block.BlockedUserID] = true
+		} else {
+			blocked[block.BlockerUserID] = true
+		}
+	}
+	return blocked, nil
+}
+
+// visibleTo is the SQL condition, on the given user ID column, that leaves out users the viewer
+// blocked or was blocked by, and incognito users who have not liked the viewer. It is the query
+// form of PublicProfileService's visibility check, for listings that page in the database.
+func visibleTo(userIDColumn string, viewerUserID int) (string, []interface{}) {
+	condition := `NOT EXISTS (
+            SELECT 1 FROM "Block" vb
+            WHERE (vb."BlockerUserID" = ? AND vb."BlockedUserID" = ` + userIDColumn + `)
+                OR (vb."BlockerUserID" = ` + userIDColumn + ` AND vb."BlockedUserID" = ?))
+        AND (NOT EXISTS (SELECT 1 FROM "User" vu WHERE vu."UserID" = ` + userIDColumn + ` AND vu."IsIncognito")
+            OR EXISTS (
+                SELECT 1 FROM "SwipeHistory" vs
+                WHERE vs."SwiperUserID" = ` + userIDColumn + ` AND vs."SwipedUserID" = ? AND vs."SwipeDirection" IN (?)))`
+	return condition, []interface{}{viewerUserID, viewerUserID, viewerUserID, likeDirections}
+}
+
+// NewBlockRepositoryWithGormDBAndRedis creates a new BlockRepository with GormDB and Redis
+func NewBlockRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) BlockRepository {
+	return NewBlockRepository(db, redis)
+}
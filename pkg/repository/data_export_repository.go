@@ -0,0 +1,153 @@
+// data_export_repository.go
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+)
+
+type DataExportRepository interface {
+	CreateDataExport(export *models.DataExport) error
+	GetDataExportByID(exportID int) (*models.DataExport, error)
+	UpdateDataExport(export *models.DataExport) error
+	ClaimDataExports(now time.Time, lease time.Duration, limit int) ([]models.DataExport, error)
+	RenewDataExportLease(exportID int, until time.Time) error
+	GetUserData(userID int) (*UserData, error)
+}
+
+// UserData holds every row stored about a user, as included in a personal data export
+type UserData struct {
+	User            *models.User             `json:"user"`
+	Profile         *models.Profile          `json:"profile"`
+	Photos          []models.Photo           `json:"photos"`
+	SwipeHistory    []models.SwipeHistory    `json:"swipeHistory"`
+	Matches         []models.Match           `json:"matches"`
+	Messages        []models.Message         `json:"messages"`
+	Notifications   []models.Notification    `json:"notifications"`
+	LocationHistory []models.LocationHistory `json:"locationHistory"`
+	ProfileViews    []models.ProfileView     `json:"profileViews"`
+}
+
+type dataExportRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewDataExportRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) DataExportRepository {
+	return &dataExportRepository{db: db, redis: redis}
+}
+
+func (r *dataExportRepository) CreateDataExport(export *models.DataExport) error {
+	result := r.db.Create(export)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *dataExportRepository) GetDataExportByID(exportID int) (*models.DataExport, error) {
+	var export models.DataExport
+	result := r.db.First(&export, exportID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &export, nil
+}
+
+func (r *dataExportRepository) UpdateDataExport(export *models.DataExport) error {
+	result := r.db.Save(export)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// ClaimDataExports returns up to limit pending exports, and processing ones whose lease ran out,
+// oldest first, marks them processing and counts an attempt for each. They are leased to the
+// caller until now plus lease. Exports claimed by other instances are skipped.
+func (r *dataExportRepository) ClaimDataExports(now time.Time, lease time.Duration, limit int) ([]models.DataExport, error) {
+	var exports []models.DataExport
+	result := r.db.Raw(`
+    UPDATE "DataExport" SET "Status" = ?, "LeasedUntil" = ?, "Attempts" = "Attempts" + 1
+    WHERE "ExportID" IN (
+        SELECT "ExportID" FROM "DataExport"
+        WHERE "Status" IN (?, ?) AND "LeasedUntil" <= ?
+        ORDER BY "ExportID"
+        LIMIT ?
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING *`, models.DataExportStatusProcessing, now.Add(lease),
+		models.DataExportStatusPending, models.DataExportStatusProcessing, now, limit).Scan(&exports)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	sort.Slice(exports, func(i, j int) bool { return exports[i].ExportID < exports[j].ExportID })
+	return exports, nil
+}
+
+// RenewDataExportLease extends the lease of an export still being processed
+func (r *dataExportRepository) RenewDataExportLease(exportID int, until time.Time) error {
+	return r.db.Model(&models.DataExport{}).
+		Where(`"ExportID" = ? AND "Status" = ?`, exportID, models.DataExportStatusProcessing).
+		Update("LeasedUntil", until).Error
+}
+
+// GetUserData collects all rows belonging to the user across the tables we store
+func (r *dataExportRepository) GetUserData(userID int) (*UserData, error) {
+	data := &UserData{}
+
+	var user models.User
+	if result := r.db.First(&user, userID); result.Error != nil {
+		return nil, result.Error
+	}
+	// The password hash is a credential, not personal data, and is never exported
+	user.Password = ""
+	data.User = &user
+
+	var profiles []models.Profile
+	if result := r.db.Where(`"Profile"."UserID" = ?`, userID).Limit(1).Find(&profiles); result.Error != nil {
+		return nil, result.Error
+	}
+	if len(profiles) > 0 {
+		data.Profile = &profiles[0]
+	}
+
+	if result := r.db.Where(`"Photo"."UserID" = ?`, userID).Order(`"Position"`).Find(&data.Photos); result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result := r.db.Where(`"SwipeHistory"."SwiperUserID" = ?`, userID).Order(`"Timestamp"`).Find(&data.SwipeHistory); result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result := r.db.Where(`"Match"."UserID1" = ? OR "Match"."UserID2" = ?`, userID, userID).Order(`"Timestamp"`).Find(&data.Matches); result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result := r.db.Where(`"Message"."SenderUserID" = ? OR "Message"."ReceiverUserID" = ?`, userID, userID).Order(`"Timestamp"`).Find(&data.Messages); result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result := r.db.Where(`"Notification"."UserID" = ?`, userID).Order(`"Timestamp"`).Find(&data.Notifications); result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result := r.db.Where(`"Locationhistory"."UserID" = ?`, userID).Order(`"Timestamp"`).Find(&data.LocationHistory); result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result := r.db.Where(`"ProfileView"."ViewerUserID" = ?`, userID).Order(`"Timestamp"`).Find(&data.ProfileViews); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return data, nil
+}
+
+// NewDataExportRepositoryWithGormDBAndRedis creates a new DataExportRepository with GormDB and Redis
+func NewDataExportRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) DataExportRepository {
+	return NewDataExportRepository(db, redis)
+}
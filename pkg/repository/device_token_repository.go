@@ -0,0 +1,71 @@
+// device_token_repository.go
+package repository
+
+import (
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+)
+
+type DeviceTokenRepository interface {
+	RegisterToken(token *models.DeviceToken) error
+	DeleteToken(userID int, token string) (bool, error)
+	GetTokens(userID int) ([]models.DeviceToken, error)
+	DeleteTokens(tokens []string) error
+}
+
+type deviceTokenRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewDeviceTokenRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) DeviceTokenRepository {
+	return &deviceTokenRepository{db: db, redis: redis}
+}
+
+// RegisterToken saves the device token for the user. A token that is already registered is moved
+// to the user, as the device is now theirs, and its LastSeenAt is refreshed.
+func (r *deviceTokenRepository) RegisterToken(token *models.DeviceToken) error {
+	now := time.Now()
+	return r.db.Raw(`
+    INSERT INTO "DeviceToken" ("UserID", "Platform", "Token", "CreatedAt", "LastSeenAt")
+    VALUES (?, ?, ?, ?, ?)
+    ON CONFLICT ("Token") DO UPDATE SET
+        "UserID" = EXCLUDED."UserID",
+        "Platform" = EXCLUDED."Platform",
+        "LastSeenAt" = EXCLUDED."LastSeenAt"
+    RETURNING *`, token.UserID, token.Platform, token.Token, now, now).Scan(token).Error
+}
+
+// DeleteToken unregisters one of the user's devices and reports whether it was registered
+func (r *deviceTokenRepository) DeleteToken(userID int, token string) (bool, error) {
+	result := r.db.Where(`"UserID" = ? AND "Token" = ?`, userID, token).Delete(&models.DeviceToken{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// GetTokens returns the devices registered for the user, most recently seen first
+func (r *deviceTokenRepository) GetTokens(userID int) ([]models.DeviceToken, error) {
+	var tokens []models.DeviceToken
+	result := r.db.Where(`"UserID" = ?`, userID).Order(`"LastSeenAt" DESC`).Find(&tokens)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return tokens, nil
+}
+
+// DeleteTokens drops tokens the push providers reported as invalid, whoever they belong to
+func (r *deviceTokenRepository) DeleteTokens(tokens []string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	return r.db.Where(`"Token" IN (?)`, tokens).Delete(&models.DeviceToken{}).Error
+}
+
+// NewDeviceTokenRepositoryWithGormDBAndRedis creates a new DeviceTokenRepository with GormDB and Redis
+func NewDeviceTokenRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) DeviceTokenRepository {
+	return NewDeviceTokenRepository(db, redis)
+}
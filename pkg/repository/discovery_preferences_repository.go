@@ -0,0 +1,74 @@
+// discovery_preferences_repository.go
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DiscoveryPreferencesRepository interface {
+	GetPreferences(userID int) (*models.DiscoveryPreferences, error)
+	SavePreferences(preferences *models.DiscoveryPreferences) error
+	DeletePreferences(userID int) error
+}
+
+type discoveryPreferencesRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewDiscoveryPreferencesRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) DiscoveryPreferencesRepository {
+	return &discoveryPreferencesRepository{db: db, redis: redis}
+}
+
+// GetPreferences returns the user's preferences, or the defaults when none have been saved
+func (r *discoveryPreferencesRepository) GetPreferences(userID int) (*models.DiscoveryPreferences, error) {
+	var preferences models.DiscoveryPreferences
+	result := r.db.Where(`"DiscoveryPreferences"."UserID" = ?`, userID).First(&preferences)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return models.DefaultDiscoveryPreferences(userID), nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &preferences, nil
+}
+
+// SavePreferences creates or replaces the user's preferences
+func (r *discoveryPreferencesRepository) SavePreferences(preferences *models.DiscoveryPreferences) error {
+	preferences.UpdatedAt = time.Now()
+	if preferences.Genders == nil {
+		preferences.Genders = models.StringList{}
+	}
+	if preferences.RelationshipGoals == nil {
+		preferences.RelationshipGoals = models.StringList{}
+	}
+	if preferences.Languages == nil {
+		preferences.Languages = models.StringList{}
+	}
+
+	result := r.db.Model(&models.DiscoveryPreferences{}).Clauses(clause.OnConflict{UpdateAll: true}).Create(preferences)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// DeletePreferences resets the user to the default preferences
+func (r *discoveryPreferencesRepository) DeletePreferences(userID int) error {
+	result := r.db.Where(`"UserID" = ?`, userID).Delete(&models.DiscoveryPreferences{})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// NewDiscoveryPreferencesRepositoryWithGormDBAndRedis creates a new DiscoveryPreferencesRepository with GormDB and Redis
+func NewDiscoveryPreferencesRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) DiscoveryPreferencesRepository {
+	return NewDiscoveryPreferencesRepository(db, redis)
+}
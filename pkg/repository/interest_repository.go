@@ -0,0 +1,34 @@
+// interest_repository.go
+package repository
+
+import (
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+)
+
+type InterestRepository interface {
+	GetActiveInterests() ([]models.Interest, error)
+}
+
+type interestRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewInterestRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) InterestRepository {
+	return &interestRepository{db: db, redis: redis}
+}
+
+func (r *interestRepository) GetActiveInterests() ([]models.Interest, error) {
+	var interests []models.Interest
+	result := r.db.Where(`"Interest"."IsActive" = true`).Order(`"Category", "Name"`).Find(&interests)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return interests, nil
+}
+
+// NewInterestRepositoryWithGormDBAndRedis creates a new InterestRepository with GormDB and Redis
+func NewInterestRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) InterestRepository {
+	return NewInterestRepository(db, redis)
+}
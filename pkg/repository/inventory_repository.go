@@ -0,0 +1,235 @@
+// inventory_repository.go
+package repository
+
+import (
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type InventoryRepository interface {
+	GetActivePacks() ([]models.ConsumablePack, error)
+	GetPackByCode(code string) (*models.ConsumablePack, error)
+	GrantLot(lot *models.InventoryLot) (bool, error)
+	TopUpLot(lot *models.InventoryLot) error
+	RevokeLot(userID int, source string) error
+	ConsumeItem(userID int, itemType string, now time.Time) (int, error)
+	ReturnItem(lotID int) error
+	GetBalances(userID int, now time.Time) (map[string]int, error)
+	StartBoost(userID int, lotID *int, duration time.Duration, now time.Time) (*models.Boost, error)
+	GetActiveBoost(userID int, now time.Time) (*models.Boost, error)
+	GetBoostedAmong(userIDs []int, now time.Time) (map[int]bool, error)
+}
+
+type inventoryRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewInventoryRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) InventoryRepository {
+	return &inventoryRepository{db: db, redis: redis}
+}
+
+// GetActivePacks returns the packs that can be purchased
+func (r *inventoryRepository) GetActivePacks() ([]models.ConsumablePack, error) {
+	var packs []models.ConsumablePack
+	result := r.db.Where(`"ConsumablePack"."IsActive" = ?`, true).Order(`"ItemType", "Quantity"`).Find(&packs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return packs, nil
+}
+
+// GetPackByCode returns the pack with the given code
+func (r *inventoryRepository) GetPackByCode(code string) (*models.ConsumablePack, error) {
+	var pack models.ConsumablePack
+	result := r.db.First(&pack, `"ConsumablePack"."Code" = ?`, code)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &pack, nil
+}
+
+// GrantLot adds the lot to the user's inventory. It reports false when a lot with the same source
+// was granted before, which leaves the inventory unchanged.
+func (r *inventoryRepository) GrantLot(lot *models.InventoryLot) (bool, error) {
+	if lot.CreatedAt.IsZero() {
+		lot.CreatedAt = time.Now()
+	}
+	lot.Remaining = lot.Granted
+
+	result := r.db.Model(&models.InventoryLot{}).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "UserID"}, {Name: "ItemType"}, {Name: "Source"}},
+			DoNothing: true,
+		}).
+		Create(lot)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// TopUpLot grants the lot, or raises the user's lot with the same source to lot.Granted items if it
+// was granted fewer, adding the difference to what is left of it. It never takes items back.
+func (r *inventoryRepository) TopUpLot(lot *models.InventoryLot) error {
+	if lot.CreatedAt.IsZero() {
+		lot.CreatedAt = time.Now()
+	}
+
+	result := r.db.Exec(`
+    INSERT INTO "InventoryLot" ("UserID", "ItemType", "Source", "Granted", "Remaining", "ExpiresAt", "CreatedAt")
+    VALUES (?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT ("UserID", "ItemType", "Source") DO UPDATE
+    SET "Remaining" = "InventoryLot"."Remaining" + EXCLUDED."Granted" - "InventoryLot"."Granted",
+        "Granted" = EXCLUDED."Granted"
+    WHERE EXCLUDED."Granted" > "InventoryLot"."Granted"`,
+		lot.UserID, lot.ItemType, lot.Source, lot.Granted, lot.Granted, lot.ExpiresAt, lot.CreatedAt)
+	return result.Error
+}
+
+// RevokeLot takes back whatever is left of the user's lots with the given source, e.g. after a refund
+func (r *inventoryRepository) RevokeLot(userID int, source string) error {
+	result := r.db.Exec(`
+    UPDATE "InventoryLot" SET "Remaining" = 0
+    WHERE "UserID" = ? AND "Source" = ?`, userID, source)
+	return result.Error
+}
+
+// ConsumeItem takes one item from the user's lot that expires first and returns the lot's ID, or
+// 0 when the user has none left. The decrement is a single conditional UPDATE on a row locked for
+// the purpose, so concurrent consumers never take the same last item.
+func (r *inventoryRepository) ConsumeItem(userID int, itemType string, now time.Time) (int, error) {
+	// A lot emptied by a concurrent consumer while this one waited for its lock matches nothing,
+	// so look again while any lot still has items
+	for attempt := 0; attempt < 3; attempt++ {
+		var lotIDs []int
+		result := r.db.Raw(`
+    UPDATE "InventoryLot" SET "Remaining" = "Remaining" - 1
+    WHERE "LotID" = (
+        SELECT "LotID" FROM "InventoryLot"
+        WHERE "UserID" = ? AND "ItemType" = ? AND "Remaining" > 0
+            AND ("ExpiresAt" IS NULL OR "ExpiresAt" > ?)
+        ORDER BY "ExpiresAt" ASC NULLS LAST, "LotID"
+        LIMIT 1
+        FOR UPDATE
+    ) AND "Remaining" > 0
+    RETURNING "LotID"`, userID, itemType, now).Scan(&lotIDs)
+		if result.Error != nil {
+			return 0, result.Error
+		}
+		if len(lotIDs) > 0 {
+			return lotIDs[0], nil
+		}
+
+		balances, err := r.GetBalances(userID, now)
+		if err != nil {
+			return 0, err
+		}
+		if balances[itemType] == 0 {
+			return 0, nil
+		}
+	}
+	return 0, nil
+}
+
+// ReturnItem puts back an item taken by ConsumeItem when the action it paid for failed
+func (r *inventoryRepository) ReturnItem(lotID int) error {
+	result := r.db.Exec(`
+    UPDATE "InventoryLot" SET "Remaining" = "Remaining" + 1
+    WHERE "LotID" = ? AND "Remaining" < "Granted"`, lotID)
+	return result.Error
+}
+
+// GetBalances returns how many unexpired items of each type the user has; types they have none
+// of are absent from the map
+func (r *inventoryRepository) GetBalances(userID int, now time.Time) (map[string]int, error) {
+	var rows []struct {
+		ItemType string `gorm:"column:ItemType"`
+		Balance  int    `gorm:"column:Balance"`
+	}
+	result := r.db.Model(&models.InventoryLot{}).
+		Select(`"ItemType", SUM("Remaining") AS "Balance"`).
+		Where(`"UserID" = ? AND "Remaining" > 0 AND ("ExpiresAt" IS NULL OR "ExpiresAt" > ?)`, userID, now).
+		Group(`"ItemType"`).
+		Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	balances := make(map[string]int, len(rows))
+	for _, row := range rows {
+		balances[row.ItemType] = row.Balance
+	}
+	return balances, nil
+}
+
+// StartBoost boosts the user for the duration, starting when their current boost ends so boosts
+// used back to back add up
+func (r *inventoryRepository) StartBoost(userID int, lotID *int, duration time.Duration, now time.Time) (*models.Boost, error) {
+	boost := &models.Boost{UserID: userID, LotID: lotID}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Serialise boosts of the same user
+		if err := tx.Exec(`SELECT 1 FROM "User" WHERE "UserID" = ? FOR UPDATE`, userID).Error; err != nil {
+			return err
+		}
+
+		startsAt := now
+		var latest models.Boost
+		result := tx.Where(`"UserID" = ? AND "EndsAt" > ?`, userID, now).Order(`"EndsAt" DESC`).Limit(1).Find(&latest)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			startsAt = latest.EndsAt
+		}
+
+		boost.StartsAt = startsAt
+		boost.EndsAt = startsAt.Add(duration)
+		return tx.Create(boost).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return boost, nil
+}
+
+// GetActiveBoost returns the boost the user has running or queued, or gorm.ErrRecordNotFound
+func (r *inventoryRepository) GetActiveBoost(userID int, now time.Time) (*models.Boost, error) {
+	var boost models.Boost
+	result := r.db.Where(`"Boost"."UserID" = ? AND "Boost"."EndsAt" > ?`, userID, now).Order(`"StartsAt"`).First(&boost)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &boost, nil
+}
+
+// GetBoostedAmong reports which of the given users are boosted right now
+func (r *inventoryRepository) GetBoostedAmong(userIDs []int, now time.Time) (map[int]bool, error) {
+	boosted := make(map[int]bool)
+	if len(userIDs) == 0 {
+		return boosted, nil
+	}
+
+	var ids []int
+	result := r.db.Model(&models.Boost{}).
+		Where(`"UserID" IN (?) AND "StartsAt" <= ? AND "EndsAt" > ?`, userIDs, now, now).
+		Distinct().
+		Pluck("UserID", &ids)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	for _, id := range ids {
+		boosted[id] = true
+	}
+	return boosted, nil
+}
+
+// NewInventoryRepositoryWithGormDBAndRedis creates a new InventoryRepository with GormDB and Redis
+func NewInventoryRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) InventoryRepository {
+	return NewInventoryRepository(db, redis)
+}
@@ -3,17 +3,47 @@ package repository
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/metabbe3/knoxsdating/pkg/helpers"
 	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type LocationRepository interface {
 	CreateLocationHistory(location *models.LocationHistory, isPremium bool) error
 	GetLocationHistoryByUserID(userID int) ([]models.LocationHistory, error)
-	GetNearbyLocations(userID int, maxDistance float64, page, pageSize int) ([]LocationWithDistance, error)
+	GetLocationHistoryPage(userID, page, pageSize int) ([]models.LocationHistory, int64, error)
+	DeleteLocationHistory(userID int) error
+	PurgeLocationsBefore(cutoff time.Time, batchSize int) (int64, error)
+	CoarsenLocationHistory(keepLatest int, cellKm float64, afterID, batchSize int) (int64, int, error)
+	MergeCoarseLocations(afterID, batchSize int) (int64, int, error)
+	FuzzLocationHistory(afterID, batchSize int) (int64, int, error)
+	FuzzCurrentLocations(afterUserID, batchSize int) (int64, int, error)
+	FuzzPassports(afterUserID, batchSize int) (int64, int, error)
+	GetLatestLocation(userID int) (*models.LocationHistory, error)
+	GetDiscoveryLocation(userID int) (*models.LocationHistory, bool, error)
+	GetDiscoveryLocations(userIDs []int) (map[int]*models.LocationHistory, error)
+	GetNearbyCandidates(userID int, filter DiscoveryFilter, limit int) ([]LocationWithDistance, error)
+	LogShownProfiles(userID int, shownUserIDs []int, shownAt time.Time) error
+}
+
+// DiscoveryFilter carries the caller's preferences and the attributes other users' preferences
+// are checked against, so that a candidate is only shown when both sides would accept each other.
+type DiscoveryFilter struct {
+	MaxDistance      float64
+	Preferences      *models.DiscoveryPreferences
+	Gender           string
+	Age              *int
+	Height           int
+	RelationshipGoal string
+	Language         string
+	ShownOn          time.Time // the caller's current time in their time zone; profiles shown to them on that local day are left out
 }
 
 type locationRepository struct {
@@ -22,24 +52,19 @@ type locationRepository struct {
 	profileRepo ProfileRepository
 }
 
-// NearbyLocation represents the structure of nearby locations
-type NearbyLocation struct {
-	LocationID int       `json:"locationID"`
-	UserID     int       `json:"userID"`
-	Latitude   float64   `json:"latitude"`
-	Longitude  float64   `json:"longitude"`
-	Timestamp  time.Time `json:"timestamp"`
-	Distance   float64   `json:"distance"`
-}
-
+// LocationWithDistance is a nearby user. Coordinates and the exact distance are only used
+// internally; responses carry the distance bucket instead.
 type LocationWithDistance struct {
-	LocationID int             `json:"locationID"`
-	UserID     int             `json:"userID"`
-	Latitude   float64         `json:"latitude"`
-	Longitude  float64         `json:"longitude"`
-	Timestamp  time.Time       `json:"timestamp"`
-	Distance   float64         `json:"distance"`
-	Profile    *models.Profile `json:"profile,omitempty" gorm:"foreignKey:UserID"`
+	LocationID     int             `json:"locationID"`
+	UserID         int             `json:"userID"`
+	Latitude       float64         `json:"-"`
+	Longitude      float64         `json:"-"`
+	Timestamp      time.Time       `json:"timestamp"`
+	Distance       float64         `json:"-"`
+	DistanceBucket string          `json:"distance" gorm:"-"`
+	Traveling      bool            `json:"traveling"`
+	Score          float64         `json:"score" gorm:"-"`
+	Profile        *models.Profile `json:"profile,omitempty" gorm:"foreignKey:UserID"`
 }
 
 func NewLocationRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler, profileRepo ProfileRepository) LocationRepository {
@@ -66,12 +91,38 @@ func (r *locationRepository) CreateLocationHistory(location *models.LocationHist
 		return errors.New("location history already created for the user today")
 	}
 
-	// Create the location history entry
-	result = r.db.Create(location)
-	if result.Error != nil {
-		return result.Error
+	if location.Timestamp.IsZero() {
+		location.Timestamp = time.Now()
 	}
-	return nil
+
+	// Only the fuzzed position is ever stored
+	location.Latitude, location.Longitude = helpers.FuzzLocation(location.UserID, location.Latitude, location.Longitude)
+	location.IsFuzzed = true
+
+	// Create the location history entry and move the user's current location to it
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(location).Error; err != nil {
+			return err
+		}
+
+		current := models.CurrentLocation{
+			UserID:     location.UserID,
+			LocationID: location.LocationID,
+			Latitude:   location.Latitude,
+			Longitude:  location.Longitude,
+			Timestamp:  location.Timestamp,
+			IsFuzzed:   true,
+		}
+
+		// Premium users may record past locations; those must not replace a newer position
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "UserID"}},
+			DoUpdates: clause.AssignmentColumns([]string{"LocationID", "Latitude", "Longitude", "Timestamp", "IsFuzzed"}),
+			Where: clause.Where{Exprs: []clause.Expression{
+				clause.Expr{SQL: `"CurrentLocation"."Timestamp" <= excluded."Timestamp"`},
+			}},
+		}).Create(&current).Error
+	})
 }
 
 func (r *locationRepository) GetLocationHistoryByUserID(userID int) ([]models.LocationHistory, error) {
@@ -83,146 +134,457 @@ func (r *locationRepository) GetLocationHistoryByUserID(userID int) ([]models.Lo
 	return locationHistory, nil
 }
 
-func (r *locationRepository) GetNearbyLocations(userID int, maxDistance float64, page, pageSize int) ([]LocationWithDistance, error) {
-	// Get the user's location
-	var userLocation models.LocationHistory
-	result := r.db.Where(`"Locationhistory"."UserID" = ?`, userID).Last(&userLocation)
+// GetLocationHistoryPage returns a page of the user's stored points, newest first, and the total
+func (r *locationRepository) GetLocationHistoryPage(userID, page, pageSize int) ([]models.LocationHistory, int64, error) {
+	var total int64
+	result := r.db.Model(&models.LocationHistory{}).Where(`"Locationhistory"."UserID" = ?`, userID).Count(&total)
 	if result.Error != nil {
-		return nil, result.Error
+		return nil, 0, result.Error
 	}
 
-	log.Print(userLocation.UserID, userLocation.Latitude, userLocation.Longitude)
-	var nearbyLocations []LocationWithDistance
-
-	// Get the profiles that have been shown to the user on the current day
-	shownProfiles, err := r.getShownProfiles(userID)
-	if err != nil {
-		return nil, err
+	var locationHistory []models.LocationHistory
+	result = r.db.Where(`"Locationhistory"."UserID" = ?`, userID).
+		Order(`"Timestamp" DESC`).
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&locationHistory)
+	if result.Error != nil {
+		return nil, 0, result.Error
 	}
+	return locationHistory, total, nil
+}
 
-	// Initialize shownProfiles as an empty slice if it's nil
-	if shownProfiles == nil {
-		shownProfiles = []int{}
-	}
+// DeleteLocationHistory removes every stored point of the user, including their current location
+func (r *locationRepository) DeleteLocationHistory(userID int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(`"UserID" = ?`, userID).Delete(&models.CurrentLocation{}).Error; err != nil {
+			return err
+		}
+		return tx.Where(`"UserID" = ?`, userID).Delete(&models.LocationHistory{}).Error
+	})
+}
 
-	// Conditionally include NOT IN clause
-	var notInClause string
-	var notInParams []interface{}
-	if len(shownProfiles) > 0 {
-		notInClause = `AND "Locationhistory"."UserID" NOT IN (?)`
-		notInParams = append(notInParams, shownProfiles)
+// PurgeLocationsBefore hard-deletes up to batchSize points recorded before the cutoff, and current
+// locations that old. Rows are locked individually and rows locked by others are skipped, so
+// batches never block writers or each other.
+func (r *locationRepository) PurgeLocationsBefore(cutoff time.Time, batchSize int) (int64, error) {
+	result := r.db.Exec(`
+    DELETE FROM "Locationhistory" WHERE "LocationID" IN (
+        SELECT "LocationID" FROM "Locationhistory"
+        WHERE "Timestamp" < ?
+        LIMIT ?
+        FOR UPDATE SKIP LOCKED
+    )`, cutoff, batchSize)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	deleted := result.RowsAffected
+
+	result = r.db.Exec(`
+    DELETE FROM "CurrentLocation" WHERE "UserID" IN (
+        SELECT "UserID" FROM "CurrentLocation"
+        WHERE "Timestamp" < ?
+        LIMIT ?
+        FOR UPDATE SKIP LOCKED
+    )`, cutoff, batchSize)
+	if result.Error != nil {
+		return deleted, result.Error
 	}
 
-	query := `
-    SELECT
-        "Locationhistory"."LocationID",
-        "Locationhistory"."UserID",
-        "Locationhistory"."Latitude",
-        "Locationhistory"."Longitude",
-        "Locationhistory"."Timestamp",
-        (
-            6371 * acos(
-                cos(radians(?)) * cos(radians("Latitude")) * cos(radians("Longitude") - radians(?)) +
-                sin(radians(?)) * sin(radians("Latitude"))
-            )
-        ) AS "distance"
-    FROM "Locationhistory"
-    WHERE "UserID" != ?
-    AND (
-        6371 * acos(
-            cos(radians(?)) * cos(radians("Latitude")) * cos(radians("Longitude") - radians(?)) +
-            sin(radians(?)) * sin(radians("Latitude"))
+	return deleted + result.RowsAffected, nil
+}
+
+// locationBatchResult is what a batch of the history walk changed, and the last LocationID it
+// covered; 0 once there is nothing after the batch
+type locationBatchResult struct {
+	Changed int64 `gorm:"column:Changed"`
+	LastID  int   `gorm:"column:LastID"`
+}
+
+// CoarsenLocationHistory walks the history in primary key order: of the batchSize points after
+// afterID, it snaps those beyond their user's latest keepLatest to the centre of a cellKm grid cell
+// and marks them coarse. It returns how many it coarsened and the LocationID to continue after.
+// Whether a point is among the latest is looked up in the user's index, reading at most keepLatest
+// of their newer points, so a batch never ranks the whole history. Rows locked by writers are
+// skipped until the next run.
+func (r *locationRepository) CoarsenLocationHistory(keepLatest int, cellKm float64, afterID, batchSize int) (int64, int, error) {
+	cellDegrees := cellKm / (helpers.EarthRadiusKm * math.Pi / 180)
+	var batch locationBatchResult
+	result := r.db.Raw(`
+    WITH batch AS (
+        SELECT "LocationID", "UserID", "Timestamp", "IsCoarse" FROM "Locationhistory"
+        WHERE "LocationID" > ?
+        ORDER BY "LocationID"
+        LIMIT ?
+        FOR UPDATE SKIP LOCKED
+    ), coarsened AS (
+        UPDATE "Locationhistory" l SET
+            "Latitude" = (FLOOR(l."Latitude" / ?) + 0.5) * ?,
+            "Longitude" = (FLOOR(l."Longitude" / ?) + 0.5) * ?,
+            "IsCoarse" = true
+        FROM batch b
+        WHERE l."LocationID" = b."LocationID" AND NOT b."IsCoarse" AND EXISTS (
+            SELECT 1 FROM "Locationhistory" n
+            WHERE n."UserID" = b."UserID" AND (n."Timestamp", n."LocationID") > (b."Timestamp", b."LocationID")
+            OFFSET ? LIMIT 1
         )
-    ) <= ?
-`
+        RETURNING l."LocationID"
+    )
+    SELECT (SELECT COUNT(*) FROM coarsened) AS "Changed", COALESCE((SELECT MAX("LocationID") FROM batch), 0) AS "LastID"`,
+		afterID, batchSize, cellDegrees, cellDegrees, cellDegrees, cellDegrees, keepLatest-1).Scan(&batch)
+	if result.Error != nil {
+		return 0, 0, result.Error
+	}
+	return batch.Changed, batch.LastID, nil
+}
 
-	if len(shownProfiles) > 0 {
-		query += notInClause
+// MergeCoarseLocations walks the history in primary key order: of the batchSize points after
+// afterID, it deletes the coarse points that repeat an earlier coarse point of the same user in the
+// same cell on the same day. It returns how many it deleted and the LocationID to continue after.
+func (r *locationRepository) MergeCoarseLocations(afterID, batchSize int) (int64, int, error) {
+	var batch locationBatchResult
+	result := r.db.Raw(`
+    WITH batch AS (
+        SELECT "LocationID", "UserID", "Timestamp", "Latitude", "Longitude", "IsCoarse" FROM "Locationhistory"
+        WHERE "LocationID" > ?
+        ORDER BY "LocationID"
+        LIMIT ?
+        FOR UPDATE SKIP LOCKED
+    ), merged AS (
+        DELETE FROM "Locationhistory" l
+        USING batch b
+        WHERE l."LocationID" = b."LocationID" AND b."IsCoarse" AND EXISTS (
+            SELECT 1 FROM "Locationhistory" e
+            WHERE e."UserID" = b."UserID" AND e."IsCoarse"
+                AND e."Timestamp" >= DATE_TRUNC('day', b."Timestamp")
+                AND (e."Timestamp", e."LocationID") < (b."Timestamp", b."LocationID")
+                AND e."Latitude" = b."Latitude" AND e."Longitude" = b."Longitude"
+        )
+        RETURNING l."LocationID"
+    )
+    SELECT (SELECT COUNT(*) FROM merged) AS "Changed", COALESCE((SELECT MAX("LocationID") FROM batch), 0) AS "LastID"`,
+		afterID, batchSize).Scan(&batch)
+	if result.Error != nil {
+		return 0, 0, result.Error
 	}
+	return batch.Changed, batch.LastID, nil
+}
 
-	query += `
-    ORDER BY "Timestamp" DESC
-    LIMIT ? OFFSET ?;
-`
+// exactLocation is a stored position that is yet to be fuzzed, keyed by its table's primary key
+type exactLocation struct {
+	ID        int     `gorm:"column:ID"`
+	UserID    int     `gorm:"column:UserID"`
+	Latitude  float64 `gorm:"column:Latitude"`
+	Longitude float64 `gorm:"column:Longitude"`
+}
 
-	offset := (page - 1) * pageSize
-	args := []interface{}{
-		userLocation.Latitude, userLocation.Longitude, userLocation.Latitude,
-		userID,
-		userLocation.Latitude, userLocation.Longitude, userLocation.Latitude,
-		maxDistance,
+// FuzzLocationHistory walks the history in primary key order and fuzzes the batchSize points after
+// afterID that were stored exact. Coarse points are left as they are. It returns how many it fuzzed
+// and the LocationID to continue after, 0 once none is left.
+func (r *locationRepository) FuzzLocationHistory(afterID, batchSize int) (int64, int, error) {
+	return r.fuzzExactLocations("Locationhistory", "LocationID", `AND NOT "IsCoarse"`, afterID, batchSize)
+}
+
+// FuzzCurrentLocations fuzzes the batchSize current locations after afterUserID that were stored
+// exact, and returns how many it fuzzed and the UserID to continue after, 0 once none is left
+func (r *locationRepository) FuzzCurrentLocations(afterUserID, batchSize int) (int64, int, error) {
+	return r.fuzzExactLocations("CurrentLocation", "UserID", "", afterUserID, batchSize)
+}
+
+// FuzzPassports fuzzes the batchSize passports after afterUserID that were placed on exact
+// coordinates, and returns how many it fuzzed and the UserID to continue after, 0 once none is left
+func (r *locationRepository) FuzzPassports(afterUserID, batchSize int) (int64, int, error) {
+	return r.fuzzExactLocations("Passport", "UserID", "", afterUserID, batchSize)
+}
+
+// fuzzExactLocations locks the next batch of rows of the table not marked fuzzed, snaps their
+// positions like new ones are snapped before storage, and marks them. The grid is keyed by the
+// server secret, so the positions are computed here rather than in SQL. Rows locked by writers are
+// skipped until the next run.
+func (r *locationRepository) fuzzExactLocations(table, key, filter string, afterID, batchSize int) (int64, int, error) {
+	var fuzzed int64
+	var lastID int
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var batch []exactLocation
+		result := tx.Raw(`
+    SELECT "`+key+`" AS "ID", "UserID", "Latitude", "Longitude" FROM "`+table+`"
+    WHERE "`+key+`" > ? AND NOT "IsFuzzed" `+filter+`
+    ORDER BY "`+key+`"
+    LIMIT ?
+    FOR UPDATE SKIP LOCKED`, afterID, batchSize).Scan(&batch)
+		if result.Error != nil || len(batch) == 0 {
+			return result.Error
+		}
+
+		rows := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*3)
+		for _, location := range batch {
+			latitude, longitude := helpers.FuzzLocation(location.UserID, location.Latitude, location.Longitude)
+			rows = append(rows, "(?::int, ?::double precision, ?::double precision)")
+			args = append(args, location.ID, latitude, longitude)
+		}
+		result = tx.Exec(`
+    UPDATE "`+table+`" t SET "Latitude" = v."Latitude", "Longitude" = v."Longitude", "IsFuzzed" = true
+    FROM (VALUES `+strings.Join(rows, ", ")+`) AS v("ID", "Latitude", "Longitude")
+    WHERE t."`+key+`" = v."ID"`, args...)
+		if result.Error != nil {
+			return result.Error
+		}
+		fuzzed = result.RowsAffected
+		lastID = batch[len(batch)-1].ID
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
 	}
+	return fuzzed, lastID, nil
+}
 
-	if len(shownProfiles) > 0 {
-		args = append(args, notInParams...)
+// GetLatestLocation returns the user's most recently reported location
+func (r *locationRepository) GetLatestLocation(userID int) (*models.LocationHistory, error) {
+	var current models.CurrentLocation
+	result := r.db.Where(`"CurrentLocation"."UserID" = ?`, userID).First(&current)
+	if result.Error != nil {
+		return nil, result.Error
 	}
+	location := current.LocationHistory()
+	return &location, nil
+}
 
-	args = append(args, pageSize, offset)
+// GetDiscoveryLocation returns where the user is placed in discovery: their active passport
+// location if they have one (reported as traveling), otherwise their latest real location
+func (r *locationRepository) GetDiscoveryLocation(userID int) (*models.LocationHistory, bool, error) {
+	location, err := r.GetLatestLocation(userID)
+	if err != nil {
+		return nil, false, err
+	}
 
-	result = r.db.Raw(query, args...).Find(&nearbyLocations)
+	var passport models.Passport
+	result := r.db.Where(`"Passport"."UserID" = ? AND "Passport"."ExpiresAt" > ?`, userID, time.Now()).Find(&passport)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return location, false, nil
+	}
 
-	// Log the query
-	log.Printf("Executing query: %s\n", query)
+	location.Latitude = passport.Latitude
+	location.Longitude = passport.Longitude
+	return location, true, nil
+}
 
-	// Add logging
-	log.Printf("Number of nearby locations: %d", len(nearbyLocations))
-	log.Printf("Nearby locations: %+v", nearbyLocations)
+// GetDiscoveryLocations is GetDiscoveryLocation for several users at once, keyed by user ID. The
+// timestamps are always those of the users' latest real locations. Users who never reported a
+// location are absent from the result.
+func (r *locationRepository) GetDiscoveryLocations(userIDs []int) (map[int]*models.LocationHistory, error) {
+	locations := make(map[int]*models.LocationHistory, len(userIDs))
+	if len(userIDs) == 0 {
+		return locations, nil
+	}
 
+	var current []models.CurrentLocation
+	result := r.db.Where(`"CurrentLocation"."UserID" IN ?`, userIDs).Find(&current)
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	for _, c := range current {
+		location := c.LocationHistory()
+		locations[c.UserID] = &location
+	}
 
-	// Log the profiles that are being shown to the user
-	err = r.logShownProfiles(userID, nearbyLocations)
+	var passports []models.Passport
+	result = r.db.Where(`"Passport"."UserID" IN ? AND "Passport"."ExpiresAt" > ?`, userIDs, time.Now()).Find(&passports)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for _, passport := range passports {
+		if location, ok := locations[passport.UserID]; ok {
+			location.Latitude = passport.Latitude
+			location.Longitude = passport.Longitude
+		}
+	}
+	return locations, nil
+}
+
+// GetNearbyCandidates returns up to limit users near the caller, one row per user at their latest
+// location, filtered by both sides' discovery preferences and excluding profiles already shown today
+// and users hidden from the caller by a block or incognito mode.
+// Ranking and recording which candidates were shown is left to the caller.
+func (r *locationRepository) GetNearbyCandidates(userID int, filter DiscoveryFilter, limit int) ([]LocationWithDistance, error) {
+	// Get the user's location, virtual if they are traveling
+	userLocation, _, err := r.GetDiscoveryLocation(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Fetch profiles for the shown locations
-	for i, loc := range nearbyLocations {
-		profile, err := r.profileRepo.GetProfileByUserID(loc.UserID)
-		if err == nil {
-			nearbyLocations[i].Profile = profile
+	// Get the profiles that have been shown to the user on the current day
+	shownProfiles, err := r.getShownProfiles(userID, filter.ShownOn)
+	if err != nil {
+		return nil, err
+	}
+
+	query, args := nearbyCandidatesQuery(userID, userLocation, shownProfiles, filter, limit)
+	var candidates []LocationWithDistance
+	result := r.db.Raw(query, args...).Find(&candidates)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	log.Printf("Number of nearby candidates: %d", len(candidates))
+
+	return candidates, nil
+}
+
+// nearbyCandidatesQuery builds the nearby search of GetNearbyCandidates around the caller's
+// location. Travelers are placed at their passport location.
+func nearbyCandidatesQuery(userID int, userLocation *models.LocationHistory, shownProfiles []int, filter DiscoveryFilter, limit int) (string, []interface{}) {
+	// earth_box bounds every point within the great-circle distance, which lets the GiST indexes
+	// on ll_to_earth discard far away users before the exact distance is computed. The distance is
+	// given as an angle times earth(), the radius the extension places points on.
+	maxDistance := filter.EffectiveMaxDistance()
+	nearby := `earth_box(ll_to_earth(?, ?), ? * earth()) @> ll_to_earth(%[1]s."Latitude", %[1]s."Longitude")`
+	nearbyArgs := []interface{}{userLocation.Latitude, userLocation.Longitude, maxDistance / helpers.EarthRadiusKm}
+	now := time.Now()
+
+	query := `
+    WITH "positions" AS (
+        SELECT cl."LocationID", cl."UserID", cl."Latitude", cl."Longitude", cl."Timestamp", false AS "Traveling"
+        FROM "CurrentLocation" cl
+        WHERE cl."UserID" != ? AND ` + fmt.Sprintf(nearby, "cl") + `
+        AND NOT EXISTS (SELECT 1 FROM "Passport" pp WHERE pp."UserID" = cl."UserID" AND pp."ExpiresAt" > ?)
+        UNION ALL
+        SELECT cl."LocationID", pp."UserID", pp."Latitude", pp."Longitude", cl."Timestamp", true AS "Traveling"
+        FROM "Passport" pp
+        JOIN "CurrentLocation" cl ON cl."UserID" = pp."UserID"
+        WHERE pp."UserID" != ? AND pp."ExpiresAt" > ? AND ` + fmt.Sprintf(nearby, "pp") + `
+    ), "candidates" AS (
+        SELECT
+            pos.*,
+            (
+                6371 * acos(LEAST(1, GREATEST(-1,
+                    cos(radians(?)) * cos(radians(pos."Latitude")) * cos(radians(pos."Longitude") - radians(?)) +
+                    sin(radians(?)) * sin(radians(pos."Latitude"))
+                )))
+            ) AS "distance"
+        FROM "positions" pos
+    )
+    SELECT c."LocationID", c."UserID", c."Latitude", c."Longitude", c."Timestamp", c."Traveling", c."distance"
+    FROM "candidates" c
+    JOIN "User" u ON u."UserID" = c."UserID"
+    LEFT JOIN "Profile" p ON p."UserID" = c."UserID"
+    LEFT JOIN "DiscoveryPreferences" dp ON dp."UserID" = c."UserID"
+    WHERE c."distance" <= ?
+`
+	args := []interface{}{userID}
+	args = append(args, nearbyArgs...)
+	args = append(args, now, userID, now)
+	args = append(args, nearbyArgs...)
+	args = append(args, userLocation.Latitude, userLocation.Longitude, userLocation.Latitude, maxDistance)
+
+	// Conditionally include NOT IN clause
+	if len(shownProfiles) > 0 {
+		query += `    AND c."UserID" NOT IN (?)` + "\n"
+		args = append(args, shownProfiles)
+	}
+
+	// Blocked pairs and incognito users who have not liked the caller are never candidates
+	visible, visibleArgs := visibleTo(`c."UserID"`, userID)
+	query += "    AND " + visible + "\n"
+	args = append(args, visibleArgs...)
+
+	conditions, conditionArgs := filter.conditions(now)
+	for _, condition := range conditions {
+		query += "    AND " + condition + "\n"
+	}
+	args = append(args, conditionArgs...)
+
+	query += `
+    ORDER BY c."Timestamp" DESC
+    LIMIT ?;
+`
+	args = append(args, limit)
+	return query, args
+}
+
+// EffectiveMaxDistance is the tighter of the requested distance and the caller's saved maximum, or 0 when
+// neither limits the search
+func (f DiscoveryFilter) EffectiveMaxDistance() float64 {
+	if f.Preferences != nil && f.Preferences.MaxDistanceKm > 0 && (f.MaxDistance <= 0 || f.Preferences.MaxDistanceKm < f.MaxDistance) {
+		return f.Preferences.MaxDistanceKm
+	}
+	return f.MaxDistance
+}
+
+// conditions builds the SQL predicates applying the caller's preferences to the candidate ("u", "p")
+// and the candidate's preferences ("dp") to the caller. Preferences left unset never exclude anyone.
+func (f DiscoveryFilter) conditions(now time.Time) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	// The caller's preferences
+	if prefs := f.Preferences; prefs != nil {
+		if len(prefs.Genders) > 0 {
+			conditions = append(conditions, `u."Gender" IN (?)`)
+			args = append(args, []string(prefs.Genders))
+		}
+		if prefs.HasAgeRange() {
+			// Born on or before the MinAge-th birthday and after the (MaxAge+1)-th
+			conditions = append(conditions, `u."BirthDate" <= ? AND u."BirthDate" > ?`)
+			args = append(args, now.AddDate(-prefs.MinAge, 0, 0), now.AddDate(-prefs.MaxAge-1, 0, 0))
+		}
+		if prefs.MaxHeight > 0 {
+			conditions = append(conditions, `p."Height" BETWEEN ? AND ?`)
+			args = append(args, prefs.MinHeight, prefs.MaxHeight)
+		}
+		if len(prefs.RelationshipGoals) > 0 {
+			conditions = append(conditions, `p."RelationshipGoals" IN (?)`)
+			args = append(args, []string(prefs.RelationshipGoals))
+		}
+		if len(prefs.Languages) > 0 {
+			conditions = append(conditions, `p."Language" IN (?)`)
+			args = append(args, []string(prefs.Languages))
 		}
 	}
 
-	return nearbyLocations, nil
+	// The candidate's preferences, checked against the caller
+	conditions = append(conditions,
+		`(dp."UserID" IS NULL OR dp."MaxDistanceKm" = 0 OR c."distance" <= dp."MaxDistanceKm")`,
+		`(dp."UserID" IS NULL OR jsonb_array_length(dp."Genders") = 0 OR dp."Genders" @> jsonb_build_array(?::text))`,
+		`(dp."UserID" IS NULL OR dp."MaxHeight" = 0 OR ?::int BETWEEN dp."MinHeight" AND dp."MaxHeight")`,
+		`(dp."UserID" IS NULL OR jsonb_array_length(dp."RelationshipGoals") = 0 OR dp."RelationshipGoals" @> jsonb_build_array(?::text))`,
+		`(dp."UserID" IS NULL OR jsonb_array_length(dp."Languages") = 0 OR dp."Languages" @> jsonb_build_array(?::text))`,
+	)
+	args = append(args, f.Gender, f.Height, f.RelationshipGoal, f.Language)
+
+	// A caller without a birth date only matches candidates who kept the full age range
+	minAge, maxAge := models.MinDiscoveryAge, models.MaxDiscoveryAge
+	if f.Age != nil {
+		minAge, maxAge = *f.Age, *f.Age
+	}
+	conditions = append(conditions, `(dp."UserID" IS NULL OR (dp."MinAge" <= ? AND dp."MaxAge" >= ?))`)
+	args = append(args, minAge, maxAge)
+
+	return conditions, args
 }
 
-func (r *locationRepository) getShownProfiles(userID int) ([]int, error) {
+// getShownProfiles returns the users shown to the caller on the local day of on. The day is passed
+// in rather than taken from the database clock, whose time zone is not the caller's.
+func (r *locationRepository) getShownProfiles(userID int, on time.Time) ([]int, error) {
 	var shownProfiles []int
 
-	// Fetch the profiles that have been shown to the user on the current day
 	err := r.db.Model(&models.ProfileView{}).
-		Where(`"ViewerUserID" = ? AND DATE_TRUNC('day', "DateOnly") = DATE_TRUNC('day', NOW())`, userID).
+		Where(`"ViewerUserID" = ? AND "DateOnly" = ?::date`, userID, localDate(on)).
 		Pluck("ShownUserID", &shownProfiles).
 		Error
 
 	return shownProfiles, err
 }
 
-func (r *locationRepository) logShownProfiles(userID int, locations []LocationWithDistance) error {
-	// Log the profiles that are being shown to the user on the current day
-	var viewRecords []models.ProfileView
-	currentTime := time.Now()
-
-	for _, loc := range locations {
-		viewRecord := models.ProfileView{
-			ViewerUserID: userID,
-			ShownUserID:  loc.UserID,
-			Timestamp:    currentTime,
-		}
-		viewRecords = append(viewRecords, viewRecord)
-	}
-
-	// Print debug information
-	log.Printf("Number of locations: %d", len(locations))
-	log.Printf("Number of view records: %d", len(viewRecords))
-	log.Printf("View records: %+v", viewRecords)
-
-	// Exclude the "DateOnly" column from the insert operation
-	err := r.db.Table("ProfileView").Omit("DateOnly").Create(&viewRecords).Error
-	return err
+// LogShownProfiles records that the given users were shown to the caller at shownAt, in the
+// caller's time zone, so they are not shown again until the caller's next day. Profiles already
+// logged for that day are skipped.
+func (r *locationRepository) LogShownProfiles(userID int, shownUserIDs []int, shownAt time.Time) error {
+	return insertProfileViews(r.db, userID, shownUserIDs, shownAt)
 }
 
 // NewUserRepositoryWithGormDBAndRedis creates a new ProfileRepository with GormDB and Redis
@@ -0,0 +1,127 @@
+// repository/location_repository_bench_test.go
+package repository
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// BenchmarkNearbySearch compares the old full-history haversine scan with the CurrentLocation
+// lookup through its GiST index on a generated dataset of one million users, and logs the plan of
+// the lookup. It needs a migrated PostgreSQL database, e.g.
+//
+//	LOCATION_BENCH_DSN="host=localhost user=knoxs password=knoxsdating dbname=knoxsdating sslmode=disable" \
+//	    go test ./pkg/repository -run '^$' -bench NearbySearch -v
+//
+// The dataset is created inside a transaction that is rolled back afterwards.
+func BenchmarkNearbySearch(b *testing.B) {
+	dsn := os.Getenv("LOCATION_BENCH_DSN")
+	if dsn == "" {
+		b.Skip("LOCATION_BENCH_DSN not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	seedNearbyBenchmark(b, tx)
+
+	var viewerID int
+	if err := tx.Raw(`SELECT MIN("UserID") FROM "User" WHERE "Username" LIKE 'bench_%'`).Scan(&viewerID).Error; err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("FullScan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var candidates []LocationWithDistance
+			if err := tx.Raw(legacyNearbyQuery, viewerID, viewerID, 25.0, 200).Scan(&candidates).Error; err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	explainNearbySearch(b, tx, viewerID)
+
+	b.Run("SpatialIndex", func(b *testing.B) {
+		repo := NewLocationRepository(helpers.NewGormDBHandler(tx), nil, nil)
+		for i := 0; i < b.N; i++ {
+			if _, err := repo.GetNearbyCandidates(viewerID, DiscoveryFilter{MaxDistance: 25}, 200); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// explainNearbySearch logs the plan of the viewer's nearby search and fails unless the current
+// locations are found through their GiST index
+func explainNearbySearch(b *testing.B, tx *gorm.DB, viewerID int) {
+	repo := NewLocationRepository(helpers.NewGormDBHandler(tx), nil, nil)
+	viewerLocation, _, err := repo.GetDiscoveryLocation(viewerID)
+	if err != nil {
+		b.Fatal(err)
+	}
+	query, args := nearbyCandidatesQuery(viewerID, viewerLocation, nil, DiscoveryFilter{MaxDistance: 25}, 200)
+
+	var plan []string
+	if err := tx.Raw("EXPLAIN (ANALYZE, BUFFERS) "+query, args...).Scan(&plan).Error; err != nil {
+		b.Fatal(err)
+	}
+	b.Log("\n" + strings.Join(plan, "\n"))
+	if !strings.Contains(strings.Join(plan, "\n"), `"IDX_CurrentLocation_Earth"`) {
+		b.Fatal("expected the nearby search to use the GiST index on CurrentLocation")
+	}
+}
+
+// seedNearbyBenchmark inserts one million users clustered around ten cities, each with a
+// location history entry and a current location
+func seedNearbyBenchmark(b *testing.B, tx *gorm.DB) {
+	statements := []string{
+		`INSERT INTO "User" ("Username", "Email", "Password")
+		 SELECT 'bench_' || n, 'bench_' || n || '@example.com', 'x' FROM generate_series(1, 1000000) n`,
+		`CREATE TEMP TABLE "BenchCity" ("CityID" INT, "Latitude" DOUBLE PRECISION, "Longitude" DOUBLE PRECISION) ON COMMIT DROP`,
+		`INSERT INTO "BenchCity" VALUES (0, -6.2, 106.8), (1, -7.25, 112.75), (2, 1.35, 103.82), (3, 13.75, 100.5), (4, 28.61, 77.21),
+		 (5, 19.08, 72.88), (6, 35.68, 139.69), (7, 51.51, -0.13), (8, 40.71, -74.01), (9, -33.87, 151.21)`,
+		`INSERT INTO "Locationhistory" ("UserID", "Latitude", "Longitude", "Timestamp")
+		 SELECT u."UserID", c."Latitude" + (random() - 0.5), c."Longitude" + (random() - 0.5), NOW()
+		 FROM "User" u
+		 JOIN "BenchCity" c ON c."CityID" = u."UserID" % 10
+		 WHERE u."Username" LIKE 'bench_%'`,
+		`INSERT INTO "CurrentLocation" ("UserID", "LocationID", "Latitude", "Longitude", "Timestamp")
+		 SELECT "UserID", "LocationID", "Latitude", "Longitude", "Timestamp" FROM "Locationhistory"
+		 WHERE "UserID" IN (SELECT "UserID" FROM "User" WHERE "Username" LIKE 'bench_%')`,
+		`ANALYZE "Locationhistory"`,
+		`ANALYZE "CurrentLocation"`,
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// legacyNearbyQuery is the nearby search as it was before CurrentLocation: haversine over every
+// history row, reduced to each user's latest position afterwards
+const legacyNearbyQuery = `
+WITH "viewer" AS (
+    SELECT "Latitude", "Longitude" FROM "Locationhistory" WHERE "UserID" = ? ORDER BY "Timestamp" DESC LIMIT 1
+), "candidates" AS (
+    SELECT DISTINCT ON (lh."UserID")
+        lh."LocationID", lh."UserID", lh."Latitude", lh."Longitude", lh."Timestamp",
+        6371 * acos(LEAST(1, GREATEST(-1,
+            cos(radians(v."Latitude")) * cos(radians(lh."Latitude")) * cos(radians(lh."Longitude") - radians(v."Longitude")) +
+            sin(radians(v."Latitude")) * sin(radians(lh."Latitude"))
+        ))) AS "distance"
+    FROM "Locationhistory" lh, "viewer" v
+    WHERE lh."UserID" != ?
+    ORDER BY lh."UserID", lh."Timestamp" DESC
+)
+SELECT * FROM "candidates" WHERE "distance" <= ? ORDER BY "Timestamp" DESC LIMIT ?`
@@ -0,0 +1,267 @@
+// repository/location_repository_test.go
+package repository
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm"
+)
+
+func Test_nearbyCandidatesQuery_SearchesTheSpatialIndexes(t *testing.T) {
+	origin := &models.LocationHistory{UserID: 1, Latitude: -17.7, Longitude: 179.9}
+	query, args := nearbyCandidatesQuery(1, origin, []int{7}, DiscoveryFilter{MaxDistance: 25}, 200)
+
+	if placeholders := strings.Count(query, "?"); placeholders != len(args) {
+		t.Fatalf("expected an argument per placeholder, got %d placeholders and %d arguments", placeholders, len(args))
+	}
+
+	// Both current locations and passports are matched against the box around the origin, in the
+	// expression their GiST indexes are built on
+	for _, alias := range []string{"cl", "pp"} {
+		want := `earth_box(ll_to_earth(?, ?), ? * earth()) @> ll_to_earth(` + alias + `."Latitude", ` + alias + `."Longitude")`
+		if !strings.Contains(query, want) {
+			t.Errorf("expected %s to be searched by its index, got %s", alias, query)
+		}
+	}
+	if strings.Contains(query, `"Latitude" BETWEEN`) {
+		t.Errorf("expected no latitude and longitude ranges, got %s", query)
+	}
+
+	// The box is sized by the angle the distance spans, once for each table
+	radius := 25 / helpers.EarthRadiusKm
+	boxes := 0
+	for i := 0; i+2 < len(args); i++ {
+		if args[i] == origin.Latitude && args[i+1] == origin.Longitude && args[i+2] == radius {
+			boxes++
+		}
+	}
+	if boxes != 2 {
+		t.Errorf("expected two boxes of %v radians around the origin, got args %v", radius, args)
+	}
+}
+
+// conditionArgs pairs each condition with the arguments of its placeholders
+func conditionArgs(t *testing.T, conditions []string, args []interface{}) map[string][]interface{} {
+	t.Helper()
+	paired := make(map[string][]interface{})
+	for _, condition := range conditions {
+		n := strings.Count(condition, "?")
+		if n > len(args) {
+			t.Fatalf("expected arguments for %s, got %v", condition, args)
+		}
+		paired[condition], args = args[:n], args[n:]
+	}
+	if len(args) != 0 {
+		t.Fatalf("expected no arguments left over, got %v", args)
+	}
+	return paired
+}
+
+func TestDiscoveryFilter_ChecksThePreferencesOfBothSides(t *testing.T) {
+	now := time.Date(2024, 3, 11, 12, 0, 0, 0, time.UTC)
+	age := 30
+	filter := DiscoveryFilter{
+		Preferences: &models.DiscoveryPreferences{
+			UserID: 1, MinAge: 25, MaxAge: 35, Genders: models.StringList{"Female"},
+			MinHeight: 160, MaxHeight: 185, RelationshipGoals: models.StringList{"Long-term partner"}, Languages: models.StringList{"English"},
+		},
+		Gender: "Male", Age: &age, Height: 178, RelationshipGoal: "New friends", Language: "Hindi",
+	}
+	conditions, args := filter.conditions(now)
+	paired := conditionArgs(t, conditions, args)
+
+	want := map[string][]interface{}{
+		// The caller's preferences are applied to the candidate
+		`u."Gender" IN (?)`:                        {[]string{"Female"}},
+		`u."BirthDate" <= ? AND u."BirthDate" > ?`: {now.AddDate(-25, 0, 0), now.AddDate(-36, 0, 0)},
+		`p."Height" BETWEEN ? AND ?`:               {160, 185},
+		`p."RelationshipGoals" IN (?)`:             {[]string{"Long-term partner"}},
+		`p."Language" IN (?)`:                      {[]string{"English"}},
+		// and the candidate's preferences to the caller
+		`(dp."UserID" IS NULL OR jsonb_array_length(dp."Genders") = 0 OR dp."Genders" @> jsonb_build_array(?::text))`:                     {"Male"},
+		`(dp."UserID" IS NULL OR dp."MaxHeight" = 0 OR ?::int BETWEEN dp."MinHeight" AND dp."MaxHeight")`:                                 {178},
+		`(dp."UserID" IS NULL OR jsonb_array_length(dp."RelationshipGoals") = 0 OR dp."RelationshipGoals" @> jsonb_build_array(?::text))`: {"New friends"},
+		`(dp."UserID" IS NULL OR jsonb_array_length(dp."Languages") = 0 OR dp."Languages" @> jsonb_build_array(?::text))`:                 {"Hindi"},
+		`(dp."UserID" IS NULL OR (dp."MinAge" <= ? AND dp."MaxAge" >= ?))`:                                                                {30, 30},
+		`(dp."UserID" IS NULL OR dp."MaxDistanceKm" = 0 OR c."distance" <= dp."MaxDistanceKm")`:                                           {},
+	}
+	for condition, args := range want {
+		got, ok := paired[condition]
+		if !ok {
+			t.Errorf("expected the condition %s, got %v", condition, paired)
+			continue
+		}
+		if len(got)+len(args) > 0 && !reflect.DeepEqual(got, args) {
+			t.Errorf("%s: expected %v, got %v", condition, args, got)
+		}
+	}
+}
+
+func TestDiscoveryFilter_UnsetPreferencesExcludeNobody(t *testing.T) {
+	now := time.Now()
+	filter := DiscoveryFilter{Preferences: models.DefaultDiscoveryPreferences(1)}
+	conditions, args := filter.conditions(now)
+	paired := conditionArgs(t, conditions, args)
+
+	for condition := range paired {
+		if strings.HasPrefix(condition, "u.") || strings.HasPrefix(condition, "p.") {
+			t.Errorf("expected no condition on the candidate from default preferences, got %s", condition)
+		}
+	}
+
+	// A caller without a birth date only matches candidates who kept the full age range
+	ages := paired[`(dp."UserID" IS NULL OR (dp."MinAge" <= ? AND dp."MaxAge" >= ?))`]
+	if !reflect.DeepEqual(ages, []interface{}{models.MinDiscoveryAge, models.MaxDiscoveryAge}) {
+		t.Errorf("expected the full age range required, got %v", ages)
+	}
+}
+
+func TestDiscoveryFilter_MaxDistanceIsTheTighterLimit(t *testing.T) {
+	cases := []struct {
+		requested, saved, want float64
+	}{
+		{50, 0, 50},
+		{50, 20, 20},
+		{10, 20, 10},
+		{0, 20, 20},
+	}
+	for _, c := range cases {
+		filter := DiscoveryFilter{MaxDistance: c.requested, Preferences: &models.DiscoveryPreferences{MaxDistanceKm: c.saved}}
+		if got := filter.EffectiveMaxDistance(); got != c.want {
+			t.Errorf("requested %v with %v saved: expected %v, got %v", c.requested, c.saved, c.want, got)
+		}
+	}
+	if got := (DiscoveryFilter{MaxDistance: 50}).EffectiveMaxDistance(); got != 50 {
+		t.Errorf("expected the requested distance without preferences, got %v", got)
+	}
+}
+
+// locationRowsDB serves current locations and passports from memory. Queries are built by a dry
+// run, and the rows matching their user IDs are handed back, passports only while they have not
+// expired at the time the query passed.
+func locationRowsDB(t *testing.T, current []models.CurrentLocation, passports []models.Passport) helpers.DatabaseHandler {
+	t.Helper()
+	dry := dryRunDB(t)
+	err := dry.Callback().Query().After("gorm:query").Register("test:rows", func(db *gorm.DB) {
+		var userIDs []int
+		var now time.Time
+		for _, v := range db.Statement.Vars {
+			switch v := v.(type) {
+			case int:
+				userIDs = append(userIDs, v)
+			case time.Time:
+				now = v
+			}
+		}
+
+		switch dest := db.Statement.Dest.(type) {
+		case *models.CurrentLocation, *[]models.CurrentLocation:
+			var rows []models.CurrentLocation
+			for _, c := range current {
+				if containsID(userIDs, c.UserID) {
+					rows = append(rows, c)
+				}
+			}
+			if one, ok := dest.(*models.CurrentLocation); ok {
+				if len(rows) == 0 {
+					db.AddError(gorm.ErrRecordNotFound)
+					return
+				}
+				*one, rows = rows[0], rows[:1]
+			} else {
+				*dest.(*[]models.CurrentLocation) = rows
+			}
+			db.RowsAffected = int64(len(rows))
+		case *models.Passport, *[]models.Passport:
+			var rows []models.Passport
+			for _, p := range passports {
+				if containsID(userIDs, p.UserID) && p.ExpiresAt.After(now) {
+					rows = append(rows, p)
+				}
+			}
+			if one, ok := dest.(*models.Passport); ok {
+				if len(rows) > 0 {
+					*one, rows = rows[0], rows[:1]
+				}
+			} else {
+				*dest.(*[]models.Passport) = rows
+			}
+			db.RowsAffected = int64(len(rows))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &mocks.MockDatabaseHandler{
+		WhereFunc: func(query interface{}, args ...interface{}) *gorm.DB {
+			return dry.Where(query, args...)
+		},
+	}
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_locationRepository_GetDiscoveryLocationUsesAnActivePassport(t *testing.T) {
+	reportedAt := time.Date(2024, 3, 11, 8, 0, 0, 0, time.UTC)
+	current := []models.CurrentLocation{
+		{UserID: 1, LocationID: 11, Latitude: -6.2, Longitude: 106.8, Timestamp: reportedAt},
+		{UserID: 2, LocationID: 12, Latitude: -7.3, Longitude: 112.7, Timestamp: reportedAt},
+		{UserID: 3, LocationID: 13, Latitude: 1.35, Longitude: 103.8, Timestamp: reportedAt},
+	}
+	passports := []models.Passport{
+		{UserID: 2, PlaceName: "Bangkok", Latitude: 13.7563, Longitude: 100.5018, ExpiresAt: time.Now().Add(time.Hour)},
+		{UserID: 3, PlaceName: "Manila", Latitude: 14.5995, Longitude: 120.9842, ExpiresAt: time.Now().Add(-time.Hour)},
+		{UserID: 4, PlaceName: "Delhi", Latitude: 28.7041, Longitude: 77.1025, ExpiresAt: time.Now().Add(time.Hour)},
+	}
+	repo := NewLocationRepository(locationRowsDB(t, current, passports), &mocks.MockRedisHandler{}, nil)
+
+	cases := []struct {
+		userID              int
+		latitude, longitude float64
+		traveling           bool
+	}{
+		{1, -6.2, 106.8, false},      // no passport
+		{2, 13.7563, 100.5018, true}, // an active passport
+		{3, 1.35, 103.8, false},      // a passport that has expired
+	}
+	for _, c := range cases {
+		location, traveling, err := repo.GetDiscoveryLocation(c.userID)
+		if err != nil {
+			t.Fatalf("user %d: Expected no error, got %v", c.userID, err)
+		}
+		if location.Latitude != c.latitude || location.Longitude != c.longitude || traveling != c.traveling {
+			t.Errorf("user %d: Expected (%v, %v) traveling %v, got (%v, %v) traveling %v",
+				c.userID, c.latitude, c.longitude, c.traveling, location.Latitude, location.Longitude, traveling)
+		}
+		// The passport moves the user but the time is that of their latest real location
+		if location.UserID != c.userID || !location.Timestamp.Equal(reportedAt) {
+			t.Errorf("user %d: Expected their latest real location's timestamp, got %+v", c.userID, location)
+		}
+	}
+
+	// A passport alone does not place a user who never reported a location
+	if _, _, err := repo.GetDiscoveryLocation(4); err != gorm.ErrRecordNotFound {
+		t.Errorf("Expected no discovery location for user 4, got %v", err)
+	}
+
+	locations, err := repo.GetDiscoveryLocations([]int{1, 2, 3, 4})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(locations) != 3 || locations[1].Latitude != -6.2 || locations[2].Latitude != 13.7563 || locations[3].Latitude != 1.35 {
+		t.Errorf("Expected users 1 to 3 placed like GetDiscoveryLocation places them, got %v", locations)
+	}
+}
@@ -1,34 +1,44 @@
+// message_repository.go
 package repository
 
 import (
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
 	"github.com/metabbe3/knoxsdating/pkg/models"
 	"gorm.io/gorm"
 )
 
-type MessageRepository struct {
-	db Database
+type MessageRepository interface {
+	CreateMessage(message *models.Message) error
+	GetMessageByID(messageID int) (*models.Message, error)
+	UpdateMessage(message *models.Message) error
+	DeleteMessage(message *models.Message) error
 }
 
-type Database interface {
-	Create(value interface{}) *gorm.DB
-	First(dest interface{}, conds ...interface{}) *gorm.DB
-	Save(value interface{}) *gorm.DB
-	Delete(value interface{}) *gorm.DB
+type messageRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
 }
 
-func NewMessageRepository(db Database) *MessageRepository {
-	return &MessageRepository{db: db}
+func NewMessageRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) MessageRepository {
+	return &messageRepository{db: db, redis: redis}
 }
 
-func (r *MessageRepository) CreateMessage(message *models.Message) error {
-	result := r.db.Create(message)
-	if result.Error != nil {
-		return result.Error
-	}
-	return nil
+// CreateMessage saves the message and records a MessageSent event in the outbox
+func (r *messageRepository) CreateMessage(message *models.Message) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		return writeOutbox(tx, models.MessageSent{
+			MessageID:      message.MessageID,
+			SenderUserID:   message.SenderUserID,
+			ReceiverUserID: message.ReceiverUserID,
+			SentAt:         message.Timestamp,
+		})
+	})
 }
 
-func (r *MessageRepository) GetMessageByID(messageID int) (*models.Message, error) {
+func (r *messageRepository) GetMessageByID(messageID int) (*models.Message, error) {
 	var message models.Message
 	result := r.db.First(&message, messageID)
 	if result.Error != nil {
@@ -37,7 +47,7 @@ func (r *MessageRepository) GetMessageByID(messageID int) (*models.Message, erro
 	return &message, nil
 }
 
-func (r *MessageRepository) UpdateMessage(message *models.Message) error {
+func (r *messageRepository) UpdateMessage(message *models.Message) error {
 	result := r.db.Save(message)
 	if result.Error != nil {
 		return result.Error
@@ -45,10 +55,15 @@ func (r *MessageRepository) UpdateMessage(message *models.Message) error {
 	return nil
 }
 
-func (r *MessageRepository) DeleteMessage(message *models.Message) error {
+func (r *messageRepository) DeleteMessage(message *models.Message) error {
 	result := r.db.Delete(message)
 	if result.Error != nil {
 		return result.Error
 	}
 	return nil
 }
+
+// NewMessageRepositoryWithGormDBAndRedis creates a new MessageRepository with GormDB and Redis
+func NewMessageRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) MessageRepository {
+	return NewMessageRepository(db, redis)
+}
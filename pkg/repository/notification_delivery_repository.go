@@ -0,0 +1,110 @@
+// notification_delivery_repository.go
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm/clause"
+)
+
+// NotificationDeliveryRepository tracks sending notifications on channels besides the in-app inbox
+type NotificationDeliveryRepository interface {
+	CreateDelivery(delivery *models.NotificationDelivery) error
+	ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]models.NotificationDelivery, error)
+	ClaimDigests(now time.Time, lease time.Duration, limit int) ([]models.NotificationDelivery, error)
+	SaveDelivery(delivery *models.NotificationDelivery) error
+	GetDeliveries(notificationID int) ([]models.NotificationDelivery, error)
+}
+
+type notificationDeliveryRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewNotificationDeliveryRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) NotificationDeliveryRepository {
+	return &notificationDeliveryRepository{db: db, redis: redis}
+}
+
+// CreateDelivery records a delivery to make. Creating one that already exists is a no-op, so
+// redelivered events do not send a notification twice.
+func (r *notificationDeliveryRepository) CreateDelivery(delivery *models.NotificationDelivery) error {
+	return r.db.Model(&models.NotificationDelivery{}).Clauses(clause.OnConflict{DoNothing: true}).Create(delivery).Error
+}
+
+// ClaimDeliveries returns up to limit pending deliveries that are due, oldest first, and counts an
+// attempt for each. They are leased to the caller: no one else claims them for the lease, unless
+// they are saved as due earlier. Deliveries claimed by other instances are skipped.
+func (r *notificationDeliveryRepository) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]models.NotificationDelivery, error) {
+	var deliveries []models.NotificationDelivery
+	result := r.db.Raw(`
+    UPDATE "NotificationDelivery" SET "NextAttemptAt" = ?, "Attempts" = "Attempts" + 1, "UpdatedAt" = ?
+    WHERE ("NotificationID", "Channel") IN (
+        SELECT "NotificationID", "Channel" FROM "NotificationDelivery"
+        WHERE "Status" = ? AND "NextAttemptAt" <= ?
+        ORDER BY "NextAttemptAt", "NotificationID"
+        LIMIT ?
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING *`, now.Add(lease), now, models.DeliveryStatusPending, now, limit).Scan(&deliveries)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].NotificationID < deliveries[j].NotificationID })
+	return deliveries, nil
+}
+
+// ClaimDigests returns the deliveries waiting for a digest that is due, for up to limit users, and
+// counts an attempt for each. Each user's deliveries are claimed together so they are sent as one
+// digest; they are leased like ClaimDeliveries. A user is claimed under a transaction-scoped
+// advisory lock on their ID, and users locked by another instance claiming at the same time are
+// skipped, so two instances never split a digest between them.
+func (r *notificationDeliveryRepository) ClaimDigests(now time.Time, lease time.Duration, limit int) ([]models.NotificationDelivery, error) {
+	var deliveries []models.NotificationDelivery
+	result := r.db.Raw(`
+    WITH claimed AS MATERIALIZED (
+        SELECT "UserID" FROM (
+            SELECT DISTINCT "UserID" FROM "NotificationDelivery"
+            WHERE "Status" = ? AND "NextAttemptAt" <= ?
+        ) due
+        WHERE pg_try_advisory_xact_lock(hashtext('NotificationDelivery.Digest'), "UserID")
+        LIMIT ?
+    )
+    UPDATE "NotificationDelivery" SET "NextAttemptAt" = ?, "Attempts" = "Attempts" + 1, "UpdatedAt" = ?
+    WHERE "Status" = ? AND "NextAttemptAt" <= ? AND "UserID" IN (SELECT "UserID" FROM claimed)
+    RETURNING *`, models.DeliveryStatusDigest, now, limit, now.Add(lease), now, models.DeliveryStatusDigest, now).Scan(&deliveries)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		if deliveries[i].UserID != deliveries[j].UserID {
+			return deliveries[i].UserID < deliveries[j].UserID
+		}
+		return deliveries[i].NotificationID < deliveries[j].NotificationID
+	})
+	return deliveries, nil
+}
+
+// SaveDelivery stores the outcome of an attempt
+func (r *notificationDeliveryRepository) SaveDelivery(delivery *models.NotificationDelivery) error {
+	return r.db.Save(delivery).Error
+}
+
+// GetDeliveries returns the deliveries of the notification on every channel
+func (r *notificationDeliveryRepository) GetDeliveries(notificationID int) ([]models.NotificationDelivery, error) {
+	var deliveries []models.NotificationDelivery
+	result := r.db.Where(`"NotificationID" = ?`, notificationID).Order(`"Channel"`).Find(&deliveries)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return deliveries, nil
+}
+
+// NewNotificationDeliveryRepositoryWithGormDBAndRedis creates a new NotificationDeliveryRepository with GormDB and Redis
+func NewNotificationDeliveryRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) NotificationDeliveryRepository {
+	return NewNotificationDeliveryRepository(db, redis)
+}
@@ -0,0 +1,71 @@
+// notification_preferences_repository.go
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type NotificationPreferencesRepository interface {
+	GetPreferences(userID int) (*models.NotificationPreferences, error)
+	SavePreferences(preferences *models.NotificationPreferences) error
+	DeletePreferences(userID int) error
+}
+
+type notificationPreferencesRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewNotificationPreferencesRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) NotificationPreferencesRepository {
+	return &notificationPreferencesRepository{db: db, redis: redis}
+}
+
+// GetPreferences returns the user's preferences, or the defaults when none have been saved
+func (r *notificationPreferencesRepository) GetPreferences(userID int) (*models.NotificationPreferences, error) {
+	var preferences models.NotificationPreferences
+	result := r.db.Where(`"NotificationPreferences"."UserID" = ?`, userID).First(&preferences)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return models.DefaultNotificationPreferences(userID), nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if preferences.Muted == nil {
+		preferences.Muted = models.NotificationMutes{}
+	}
+	return &preferences, nil
+}
+
+// SavePreferences creates or replaces the user's preferences
+func (r *notificationPreferencesRepository) SavePreferences(preferences *models.NotificationPreferences) error {
+	preferences.UpdatedAt = time.Now()
+	if preferences.Muted == nil {
+		preferences.Muted = models.NotificationMutes{}
+	}
+
+	result := r.db.Model(&models.NotificationPreferences{}).Clauses(clause.OnConflict{UpdateAll: true}).Create(preferences)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// DeletePreferences resets the user to the default preferences
+func (r *notificationPreferencesRepository) DeletePreferences(userID int) error {
+	result := r.db.Where(`"UserID" = ?`, userID).Delete(&models.NotificationPreferences{})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// NewNotificationPreferencesRepositoryWithGormDBAndRedis creates a new NotificationPreferencesRepository with GormDB and Redis
+func NewNotificationPreferencesRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) NotificationPreferencesRepository {
+	return NewNotificationPreferencesRepository(db, redis)
+}
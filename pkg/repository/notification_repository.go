@@ -3,22 +3,39 @@ package repository
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/metabbe3/knoxsdating/pkg/helpers"
 	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm"
 )
 
+// unreadCountTTL is how long a user's unread notification count stays cached before it is recounted
+const unreadCountTTL = 24 * time.Hour
+
 type NotificationRepository interface {
 	CreateNotification(notification *models.Notification) error
 	GetNotificationByID(notificationID int) (*models.Notification, error)
 	UpdateNotification(notification *models.Notification) error
 	DeleteNotification(notification *models.Notification) error
+	GetNotifications(userID int, query NotificationQuery) ([]models.Notification, error)
+	SetRead(userID int, notificationIDs []int, read bool) (int64, error)
+	MarkAllRead(userID int) (int64, error)
+	CountUnread(userID int) (int64, error)
 
 	// New methods for Redis
 	SaveNotificationToRedis(notification *models.Notification) error
 	GetNotificationFromRedis(notificationID int) (*models.Notification, error)
 }
 
+// NotificationQuery selects a page of a user's notifications, newest first. BeforeID is the cursor:
+// only notifications older than it are returned when it is set.
+type NotificationQuery struct {
+	BeforeID   int
+	UnreadOnly bool
+	Limit      int
+}
+
 type notificationRepository struct {
 	db    helpers.DatabaseHandler
 	redis helpers.RedisHandler
@@ -28,10 +45,23 @@ func NewNotificationRepository(db helpers.DatabaseHandler, redis helpers.RedisHa
 	return &notificationRepository{db: db, redis: redis}
 }
 
+// CreateNotification adds the notification to the user's inbox and records a NotificationCreated
+// event for its delivery to other channels
 func (r *notificationRepository) CreateNotification(notification *models.Notification) error {
-	result := r.db.Create(notification)
-	if result.Error != nil {
-		return result.Error
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(notification).Error; err != nil {
+			return err
+		}
+		return writeOutbox(tx, models.NotificationCreated{
+			NotificationID:   notification.NotificationID,
+			UserID:           notification.UserID,
+			NotificationType: notification.NotificationType,
+			Message:          notification.Message,
+			CreatedAt:        notification.Timestamp,
+		})
+	})
+	if err != nil {
+		return err
 	}
 
 	// Save to Redis after successful database creation
@@ -39,6 +69,9 @@ func (r *notificationRepository) CreateNotification(notification *models.Notific
 		// Handle Redis error (log, return an error, etc.)
 		fmt.Printf("Error saving to Redis: %v\n", err)
 	}
+	if !notification.IsRead {
+		r.adjustUnreadCount(notification.UserID, 1)
+	}
 
 	return nil
 }
@@ -92,6 +125,9 @@ func (r *notificationRepository) DeleteNotification(notification *models.Notific
 		// Handle Redis error (log, return an error, etc.)
 		fmt.Printf("Error deleting from Redis: %v\n", err)
 	}
+	if result.RowsAffected > 0 && !notification.IsRead {
+		r.adjustUnreadCount(notification.UserID, -1)
+	}
 
 	return nil
 }
@@ -112,6 +148,112 @@ func (r *notificationRepository) GetNotificationFromRedis(notificationID int) (*
 	return &notification, nil
 }
 
+// GetNotifications returns a page of the user's notifications, newest first
+func (r *notificationRepository) GetNotifications(userID int, query NotificationQuery) ([]models.Notification, error) {
+	db := r.db.Where(`"Notification"."UserID" = ?`, userID)
+	if query.BeforeID > 0 {
+		db = db.Where(`"Notification"."NotificationID" < ?`, query.BeforeID)
+	}
+	if query.UnreadOnly {
+		db = db.Where(`"Notification"."IsRead" = ?`, false)
+	}
+
+	var notifications []models.Notification
+	result := db.Order(`"NotificationID" DESC`).Limit(query.Limit).Find(&notifications)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return notifications, nil
+}
+
+// SetRead marks the user's notifications with the given IDs as read or unread and returns how many
+// changed. IDs of other users' notifications are ignored.
+func (r *notificationRepository) SetRead(userID int, notificationIDs []int, read bool) (int64, error) {
+	if len(notificationIDs) == 0 {
+		return 0, nil
+	}
+
+	var changed []int
+	result := r.db.Raw(`
+    UPDATE "Notification" SET "IsRead" = ?
+    WHERE "UserID" = ? AND "NotificationID" IN (?) AND "IsRead" IS DISTINCT FROM ?
+    RETURNING "NotificationID"`, read, userID, notificationIDs, read).Scan(&changed)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	r.forgetNotifications(changed)
+	if read {
+		r.adjustUnreadCount(userID, -int64(len(changed)))
+	} else {
+		r.adjustUnreadCount(userID, int64(len(changed)))
+	}
+	return int64(len(changed)), nil
+}
+
+// MarkAllRead marks every unread notification of the user as read and returns how many there were
+func (r *notificationRepository) MarkAllRead(userID int) (int64, error) {
+	var changed []int
+	result := r.db.Raw(`
+    UPDATE "Notification" SET "IsRead" = true
+    WHERE "UserID" = ? AND "IsRead" IS DISTINCT FROM true
+    RETURNING "NotificationID"`, userID).Scan(&changed)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	r.forgetNotifications(changed)
+	r.adjustUnreadCount(userID, -int64(len(changed)))
+	return int64(len(changed)), nil
+}
+
+// CountUnread returns how many unread notifications the user has. The count is kept in Redis and
+// adjusted as notifications are created, read and deleted; it is recounted when not cached.
+func (r *notificationRepository) CountUnread(userID int) (int64, error) {
+	var count int64
+	if err := r.redis.Get(unreadCountKey(userID), &count); err == nil {
+		return count, nil
+	}
+
+	result := r.db.Model(&models.Notification{}).
+		Where(`"UserID" = ? AND "IsRead" IS DISTINCT FROM true`, userID).
+		Count(&count)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if err := r.redis.Set(unreadCountKey(userID), count, unreadCountTTL); err != nil {
+		fmt.Printf("Error saving to Redis: %v\n", err)
+	}
+	return count, nil
+}
+
+// adjustUnreadCount keeps the cached unread count in step with a change
+func (r *notificationRepository) adjustUnreadCount(userID int, by int64) {
+	if by == 0 {
+		return
+	}
+	if err := r.redis.AdjustCounter(unreadCountKey(userID), by); err != nil {
+		// A stale count would be kept until it expires, so drop it to have it recounted
+		fmt.Printf("Error updating unread count in Redis: %v\n", err)
+		if err := r.redis.Delete(unreadCountKey(userID)); err != nil {
+			fmt.Printf("Error deleting from Redis: %v\n", err)
+		}
+	}
+}
+
+// forgetNotifications drops cached copies of notifications changed in the database
+func (r *notificationRepository) forgetNotifications(notificationIDs []int) {
+	for _, notificationID := range notificationIDs {
+		if err := r.redis.Delete(fmt.Sprintf("notification:%d", notificationID)); err != nil {
+			fmt.Printf("Error deleting from Redis: %v\n", err)
+		}
+	}
+}
+
+func unreadCountKey(userID int) string {
+	return fmt.Sprintf("notifications:unread:%d", userID)
+}
+
 // NewNotificationRepositoryWithGormDBAndRedis creates a new NotificationRepository with GormDB and Redis
 func NewNotificationRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) NotificationRepository {
 	return NewNotificationRepository(db, redis)
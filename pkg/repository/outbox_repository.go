@@ -0,0 +1,116 @@
+// repository/outbox_repository.go
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm"
+)
+
+// OutboxRepository reads the outbox for the relay publishing it, and records which published
+// events each consumer has handled. Events are written to the outbox by the repositories making
+// the changes they describe, in the same transaction.
+type OutboxRepository interface {
+	ClaimEvents(now time.Time, lease time.Duration, limit int) ([]models.OutboxEvent, error)
+	MarkPublished(outboxEventID int64, now time.Time) error
+	MarkFailed(outboxEventID int64, lastError string, retryAt time.Time) error
+	MarkDead(outboxEventID int64, lastError string, now time.Time) error
+	IsProcessed(consumer, eventID string) (bool, error)
+	MarkProcessed(consumer, eventID string, now time.Time) error
+}
+
+type outboxRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewOutboxRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) OutboxRepository {
+	return &outboxRepository{db: db, redis: redis}
+}
+
+// writeOutbox records the events in the outbox as part of the transaction, so they are published
+// if and only if the transaction commits
+func writeOutbox(tx *gorm.DB, events ...models.DomainEvent) error {
+	now := time.Now()
+	for _, event := range events {
+		entry, err := models.NewOutboxEvent(event, now)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimEvents returns up to limit events due for publishing, oldest first, and counts an attempt
+// for each. They are leased to the caller: no one else claims them for the lease, unless they
+// are marked failed earlier. Events claimed by other instances are skipped.
+func (r *outboxRepository) ClaimEvents(now time.Time, lease time.Duration, limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	result := r.db.Raw(`
+    UPDATE "OutboxEvent" SET "AvailableAt" = ?, "Attempts" = "Attempts" + 1
+    WHERE "OutboxEventID" IN (
+        SELECT "OutboxEventID" FROM "OutboxEvent"
+        WHERE "PublishedAt" IS NULL AND "DeadAt" IS NULL AND "AvailableAt" <= ?
+        ORDER BY "AvailableAt", "OutboxEventID"
+        LIMIT ?
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING *`, now.Add(lease), now, limit).Scan(&events)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].OutboxEventID < events[j].OutboxEventID })
+	return events, nil
+}
+
+// MarkPublished records that the event reached the broker
+func (r *outboxRepository) MarkPublished(outboxEventID int64, now time.Time) error {
+	return r.db.Model(&models.OutboxEvent{}).
+		Where(`"OutboxEventID" = ?`, outboxEventID).
+		Updates(map[string]interface{}{"PublishedAt": now, "LastError": ""}).Error
+}
+
+// MarkFailed records why publishing the event failed and when to try again
+func (r *outboxRepository) MarkFailed(outboxEventID int64, lastError string, retryAt time.Time) error {
+	return r.db.Model(&models.OutboxEvent{}).
+		Where(`"OutboxEventID" = ?`, outboxEventID).
+		Updates(map[string]interface{}{"AvailableAt": retryAt, "LastError": lastError}).Error
+}
+
+// MarkDead gives up on publishing the event. It stays in the outbox for inspection.
+func (r *outboxRepository) MarkDead(outboxEventID int64, lastError string, now time.Time) error {
+	return r.db.Model(&models.OutboxEvent{}).
+		Where(`"OutboxEventID" = ?`, outboxEventID).
+		Updates(map[string]interface{}{"DeadAt": now, "LastError": lastError}).Error
+}
+
+// IsProcessed reports whether the consumer has handled the event
+func (r *outboxRepository) IsProcessed(consumer, eventID string) (bool, error) {
+	var count int64
+	result := r.db.Model(&models.ProcessedEvent{}).
+		Where(`"Consumer" = ? AND "EventID" = ?`, consumer, eventID).
+		Count(&count)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return count > 0, nil
+}
+
+// MarkProcessed records that the consumer has handled the event; marking it twice is a no-op
+func (r *outboxRepository) MarkProcessed(consumer, eventID string, now time.Time) error {
+	return r.db.Exec(`
+    INSERT INTO "ProcessedEvent" ("Consumer", "EventID", "ProcessedAt") VALUES (?, ?, ?)
+    ON CONFLICT ("Consumer", "EventID") DO NOTHING`, consumer, eventID, now).Error
+}
+
+// NewOutboxRepositoryWithGormDBAndRedis creates a new OutboxRepository with GormDB and Redis
+func NewOutboxRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) OutboxRepository {
+	return NewOutboxRepository(db, redis)
+}
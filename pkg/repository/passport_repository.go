@@ -0,0 +1,78 @@
+// passport_repository.go
+package repository
+
+import (
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm/clause"
+)
+
+type PassportRepository interface {
+	SetPassport(passport *models.Passport) error
+	GetActivePassport(userID int) (*models.Passport, error)
+	GetActivePassports(userIDs []int) (map[int]*models.Passport, error)
+	DeletePassport(userID int) error
+}
+
+type passportRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewPassportRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) PassportRepository {
+	return &passportRepository{db: db, redis: redis}
+}
+
+// SetPassport creates or replaces the user's passport
+func (r *passportRepository) SetPassport(passport *models.Passport) error {
+	passport.CreatedAt = time.Now()
+	result := r.db.Model(&models.Passport{}).Clauses(clause.OnConflict{UpdateAll: true}).Create(passport)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// GetActivePassport returns the user's passport if it has not expired, or gorm.ErrRecordNotFound
+func (r *passportRepository) GetActivePassport(userID int) (*models.Passport, error) {
+	var passport models.Passport
+	result := r.db.Where(`"Passport"."UserID" = ? AND "Passport"."ExpiresAt" > ?`, userID, time.Now()).First(&passport)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &passport, nil
+}
+
+// GetActivePassports returns the unexpired passports of the given users, keyed by user ID
+func (r *passportRepository) GetActivePassports(userIDs []int) (map[int]*models.Passport, error) {
+	passports := make(map[int]*models.Passport, len(userIDs))
+	if len(userIDs) == 0 {
+		return passports, nil
+	}
+
+	var loaded []models.Passport
+	result := r.db.Where(`"Passport"."UserID" IN ? AND "Passport"."ExpiresAt" > ?`, userIDs, time.Now()).Find(&loaded)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for i := range loaded {
+		passports[loaded[i].UserID] = &loaded[i]
+	}
+	return passports, nil
+}
+
+// DeletePassport returns the user to their real location
+func (r *passportRepository) DeletePassport(userID int) error {
+	result := r.db.Where(`"UserID" = ?`, userID).Delete(&models.Passport{})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// NewPassportRepositoryWithGormDBAndRedis creates a new PassportRepository with GormDB and Redis
+func NewPassportRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) PassportRepository {
+	return NewPassportRepository(db, redis)
+}
@@ -0,0 +1,119 @@
+// payment_event_repository.go
+package repository
+
+import (
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm/clause"
+)
+
+type PaymentEventRepository interface {
+	RecordEvent(event *models.PaymentEvent) (bool, error)
+	ClaimEvent(provider, providerEventID string, staleBefore time.Time) (bool, error)
+	CompleteEvent(provider, providerEventID string, result *models.PaymentEvent) error
+	GetEvent(provider, providerEventID string) (*models.PaymentEvent, error)
+	GetSubscriptionIDForCharge(provider, chargeID string) (string, error)
+	GetPackPurchase(provider, paymentID string) (*models.PaymentEvent, error)
+}
+
+type paymentEventRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewPaymentEventRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) PaymentEventRepository {
+	return &paymentEventRepository{db: db, redis: redis}
+}
+
+// RecordEvent adds the event to the ledger and reports whether it was new; a redelivered event
+// is left as it is
+func (r *paymentEventRepository) RecordEvent(event *models.PaymentEvent) (bool, error) {
+	now := time.Now()
+	event.ReceivedAt = now
+	event.UpdatedAt = now
+
+	result := r.db.Model(&models.PaymentEvent{}).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "Provider"}, {Name: "ProviderEventID"}}, DoNothing: true}).
+		Create(event)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ClaimEvent marks the event as being processed and reports whether the caller won it. Events
+// already processed, or being processed by someone else since staleBefore, cannot be claimed.
+func (r *paymentEventRepository) ClaimEvent(provider, providerEventID string, staleBefore time.Time) (bool, error) {
+	result := r.db.Exec(`
+    UPDATE "PaymentEvent" SET "Status" = ?, "UpdatedAt" = ?
+    WHERE "Provider" = ? AND "ProviderEventID" = ?
+        AND ("Status" IN (?, ?) OR ("Status" = ? AND "UpdatedAt" < ?))`,
+		models.PaymentEventStatusProcessing, time.Now(),
+		provider, providerEventID,
+		models.PaymentEventStatusReceived, models.PaymentEventStatusFailed,
+		models.PaymentEventStatusProcessing, staleBefore)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// CompleteEvent stores the outcome of processing the event
+func (r *paymentEventRepository) CompleteEvent(provider, providerEventID string, outcome *models.PaymentEvent) error {
+	now := time.Now()
+	result := r.db.Model(&models.PaymentEvent{}).
+		Where(`"Provider" = ? AND "ProviderEventID" = ?`, provider, providerEventID).
+		Updates(map[string]interface{}{
+			"Status":         outcome.Status,
+			"Error":          outcome.Error,
+			"UserID":         outcome.UserID,
+			"SubscriptionID": outcome.SubscriptionID,
+			"ProcessedAt":    now,
+			"UpdatedAt":      now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// GetEvent returns the ledger entry of the event
+func (r *paymentEventRepository) GetEvent(provider, providerEventID string) (*models.PaymentEvent, error) {
+	var event models.PaymentEvent
+	result := r.db.First(&event, `"PaymentEvent"."Provider" = ? AND "PaymentEvent"."ProviderEventID" = ?`, provider, providerEventID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &event, nil
+}
+
+// GetSubscriptionIDForCharge returns the provider subscription whose invoice was paid with the charge
+func (r *paymentEventRepository) GetSubscriptionIDForCharge(provider, chargeID string) (string, error) {
+	var event models.PaymentEvent
+	result := r.db.Where(`"PaymentEvent"."Provider" = ? AND "PaymentEvent"."ProviderChargeID" = ? AND "PaymentEvent"."ProviderSubscriptionID" <> ''`, provider, chargeID).
+		Order(`"ReceivedAt"`).
+		First(&event)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	return event.ProviderSubscriptionID, nil
+}
+
+// GetPackPurchase returns the event that reported the purchase of a pack with the payment
+func (r *paymentEventRepository) GetPackPurchase(provider, paymentID string) (*models.PaymentEvent, error) {
+	var event models.PaymentEvent
+	result := r.db.Where(`"PaymentEvent"."Provider" = ? AND "PaymentEvent"."ProviderPaymentID" = ? AND "PaymentEvent"."EventType" = ?`, provider, paymentID, helpers.PaymentEventPackPurchased).
+		Order(`"ReceivedAt"`).
+		First(&event)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &event, nil
+}
+
+// NewPaymentEventRepositoryWithGormDBAndRedis creates a new PaymentEventRepository with GormDB and Redis
+func NewPaymentEventRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) PaymentEventRepository {
+	return NewPaymentEventRepository(db, redis)
+}
@@ -0,0 +1,206 @@
+// photo_repository.go
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrPhotoLimitReached is returned when a user already has the maximum number of photos
+	ErrPhotoLimitReached = fmt.Errorf("a profile can have at most %d photos", models.MaxProfilePhotos)
+
+	// ErrMinimumPhotos is returned when deleting a photo would leave fewer than the minimum
+	ErrMinimumPhotos = fmt.Errorf("a profile must keep at least %d photo", models.MinProfilePhotos)
+)
+
+type PhotoRepository interface {
+	CreatePhoto(photo *models.Photo) error
+	GetPhotoByID(photoID int) (*models.Photo, error)
+	GetPhotosByUserID(userID int) ([]models.Photo, error)
+	CountPhotosByUserID(userID int) (int64, error)
+	ReorderPhotos(userID int, photoIDs []int) error
+	DeletePhoto(photo *models.Photo) error
+}
+
+type photoRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewPhotoRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) PhotoRepository {
+	return &photoRepository{db: db, redis: redis}
+}
+
+// CreatePhoto appends the photo after the user's existing photos, enforcing the photo limit
+func (r *photoRepository) CreatePhoto(photo *models.Photo) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		count, err := lockAndCountPhotos(tx, photo.UserID)
+		if err != nil {
+			return err
+		}
+		if count >= models.MaxProfilePhotos {
+			return ErrPhotoLimitReached
+		}
+
+		var maxPosition int
+		err = tx.Model(&models.Photo{}).
+			Where(`"UserID" = ?`, photo.UserID).
+			Select(`COALESCE(MAX("Position"), 0)`).
+			Scan(&maxPosition).Error
+		if err != nil {
+			return err
+		}
+
+		photo.Position = maxPosition + 1
+		if err := tx.Create(photo).Error; err != nil {
+			return err
+		}
+
+		return syncProfilePhotos(tx, photo.UserID)
+	})
+}
+
+func (r *photoRepository) GetPhotoByID(photoID int) (*models.Photo, error) {
+	var photo models.Photo
+	result := r.db.First(&photo, photoID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &photo, nil
+}
+
+func (r *photoRepository) GetPhotosByUserID(userID int) ([]models.Photo, error) {
+	var photos []models.Photo
+	result := r.db.Where(`"Photo"."UserID" = ?`, userID).Order(`"Position"`).Find(&photos)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return photos, nil
+}
+
+func (r *photoRepository) CountPhotosByUserID(userID int) (int64, error) {
+	var count int64
+	result := r.db.Model(&models.Photo{}).Where(`"UserID" = ?`, userID).Count(&count)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return count, nil
+}
+
+// ReorderPhotos sets the photo positions to the order given; every photo of the user must be listed exactly once
+func (r *photoRepository) ReorderPhotos(userID int, photoIDs []int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var existingIDs []int
+		err := tx.Model(&models.Photo{}).Where(`"UserID" = ?`, userID).Pluck("PhotoID", &existingIDs).Error
+		if err != nil {
+			return err
+		}
+
+		if !sameIDs(existingIDs, photoIDs) {
+			return errors.New("photo order must list each of the user's photos exactly once")
+		}
+
+		for i, photoID := range photoIDs {
+			err := tx.Model(&models.Photo{}).
+				Where(`"PhotoID" = ? AND "UserID" = ?`, photoID, userID).
+				Update("Position", i+1).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return syncProfilePhotos(tx, userID)
+	})
+}
+
+// DeletePhoto removes the photo and closes the gap it leaves in the ordering
+func (r *photoRepository) DeletePhoto(photo *models.Photo) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		count, err := lockAndCountPhotos(tx, photo.UserID)
+		if err != nil {
+			return err
+		}
+		if count <= models.MinProfilePhotos {
+			return ErrMinimumPhotos
+		}
+
+		if err := tx.Delete(photo).Error; err != nil {
+			return err
+		}
+
+		err = tx.Model(&models.Photo{}).
+			Where(`"UserID" = ? AND "Position" > ?`, photo.UserID, photo.Position).
+			Update("Position", gorm.Expr(`"Position" - 1`)).Error
+		if err != nil {
+			return err
+		}
+
+		return syncProfilePhotos(tx, photo.UserID)
+	})
+}
+
+// lockAndCountPhotos locks the user's row so concurrent uploads and deletes are serialized, then counts their photos
+func lockAndCountPhotos(tx *gorm.DB, userID int) (int64, error) {
+	err := tx.Exec(`SELECT 1 FROM "User" WHERE "UserID" = ? FOR UPDATE`, userID).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err = tx.Model(&models.Photo{}).Where(`"UserID" = ?`, userID).Count(&count).Error
+	return count, err
+}
+
+// syncProfilePhotos keeps Profile.Photos in step with the Photo table so profile reads list the photo URLs in order
+func syncProfilePhotos(tx *gorm.DB, userID int) error {
+	var photoIDs []int
+	err := tx.Model(&models.Photo{}).Where(`"UserID" = ?`, userID).Order(`"Position"`).Pluck("PhotoID", &photoIDs).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&models.Profile{}).Where(`"UserID" = ?`, userID).Update("Photos", PhotoURLs(photoIDs)).Error
+}
+
+// PhotoURL returns the API path serving the photo
+func PhotoURL(photoID int) string {
+	return fmt.Sprintf("/photos/%d", photoID)
+}
+
+// PhotoURLs returns the list of photo URLs stored in Profile.Photos
+func PhotoURLs(photoIDs []int) models.StringList {
+	urls := make(models.StringList, 0, len(photoIDs))
+	for _, photoID := range photoIDs {
+		urls = append(urls, PhotoURL(photoID))
+	}
+	return urls
+}
+
+func sameIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	seen := make(map[int]bool, len(a))
+	for _, id := range a {
+		seen[id] = true
+	}
+	for _, id := range b {
+		if !seen[id] {
+			return false
+		}
+		delete(seen, id)
+	}
+
+	return len(seen) == 0
+}
+
+// NewPhotoRepositoryWithGormDBAndRedis creates a new PhotoRepository with GormDB and Redis
+func NewPhotoRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) PhotoRepository {
+	return NewPhotoRepository(db, redis)
+}
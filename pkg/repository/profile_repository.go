@@ -2,14 +2,24 @@
 package repository
 
 import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
 	"github.com/metabbe3/knoxsdating/pkg/helpers"
 	"github.com/metabbe3/knoxsdating/pkg/models"
 )
 
+// profileCacheTTL matches how long the profile handlers cache "profile:<userID>"
+const profileCacheTTL = 24 * time.Hour
+
 type ProfileRepository interface {
 	CreateProfile(profile *models.Profile) error
 	GetProfileByID(profileID int) (*models.Profile, error)
 	GetProfileByUserID(userID int) (*models.Profile, error)
+	GetProfilesByUserIDs(userIDs []int) (map[int]*models.Profile, error)
+	GetProfilesByInterest(interest string, viewerUserID, page, pageSize int) ([]models.Profile, error)
 	UpdateProfile(profile *models.Profile) error
 	DeleteProfile(profile *models.Profile) error
 }
@@ -42,13 +52,101 @@ func (r *profileRepository) GetProfileByID(profileID int) (*models.Profile, erro
 
 func (r *profileRepository) GetProfileByUserID(userID int) (*models.Profile, error) {
 	var profile models.Profile
-	result := r.db.First(&profile).Where("UserID = ?", userID)
+	result := r.db.Where(`"Profile"."UserID" = ?`, userID).First(&profile)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return &profile, nil
 }
 
+// GetProfilesByUserIDs loads the profiles of several users, keyed by user ID. Cached profiles are
+// read with a single MGET and the rest with one query, which is then cached. Users without a
+// profile are absent from the result.
+func (r *profileRepository) GetProfilesByUserIDs(userIDs []int) (map[int]*models.Profile, error) {
+	profiles := make(map[int]*models.Profile, len(userIDs))
+
+	var keys []string
+	var keyUserIDs []int
+	seen := make(map[int]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		keys = append(keys, profileCacheKey(userID))
+		keyUserIDs = append(keyUserIDs, userID)
+	}
+	if len(keys) == 0 {
+		return profiles, nil
+	}
+
+	cached, err := r.redis.MGet(keys)
+	if err != nil {
+		log.Printf("Error getting profile data from Redis: %v", err)
+		cached = make([][]byte, len(keys))
+	}
+
+	var missing []int
+	for i, value := range cached {
+		userID := keyUserIDs[i]
+		if value != nil {
+			var profile models.Profile
+			if err := json.Unmarshal(value, &profile); err == nil && profile.UserID == userID {
+				profiles[userID] = &profile
+				continue
+			}
+		}
+		missing = append(missing, userID)
+	}
+	if len(missing) == 0 {
+		return profiles, nil
+	}
+
+	var loaded []models.Profile
+	result := r.db.Find(&loaded, `"Profile"."UserID" IN ?`, missing)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	toCache := make(map[string]interface{}, len(loaded))
+	for i := range loaded {
+		profile := &loaded[i]
+		profiles[profile.UserID] = profile
+		toCache[profileCacheKey(profile.UserID)] = profile
+	}
+	if err := r.redis.MSet(toCache, profileCacheTTL); err != nil {
+		log.Printf("Error setting profile data in Redis: %v", err)
+	}
+
+	return profiles, nil
+}
+
+func profileCacheKey(userID int) string {
+	return "profile:" + strconv.Itoa(userID)
+}
+
+// GetProfilesByInterest returns the profiles of other users listing the interest that the viewer
+// may see, leaving out blocked pairs and incognito users who have not liked the viewer. The GIN
+// index on "Interests" serves the match.
+func (r *profileRepository) GetProfilesByInterest(interest string, viewerUserID, page, pageSize int) ([]models.Profile, error) {
+	visible, visibleArgs := visibleTo(`p."UserID"`, viewerUserID)
+	args := []interface{}{models.StringList{interest}, viewerUserID}
+	args = append(args, visibleArgs...)
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	var profiles []models.Profile
+	result := r.db.Raw(`
+    SELECT p.* FROM "Profile" p
+    WHERE p."Interests" @> ?::jsonb AND p."UserID" != ?
+        AND `+visible+`
+    ORDER BY p."ProfileID"
+    LIMIT ? OFFSET ?`, args...).Find(&profiles)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return profiles, nil
+}
+
 func (r *profileRepository) UpdateProfile(profile *models.Profile) error {
 	result := r.db.Save(profile)
 	if result.Error != nil {
@@ -0,0 +1,203 @@
+// repository/profile_repository_test.go
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_profileRepository_GetProfilesByUserIDs(t *testing.T) {
+	// Profiles stored in the database, keyed by user ID
+	stored := map[int]models.Profile{
+		1: {ProfileID: 101, UserID: 1, AboutMe: "user one"},
+		2: {ProfileID: 102, UserID: 2, AboutMe: "user two"},
+		3: {ProfileID: 103, UserID: 3, AboutMe: "user three"},
+	}
+
+	var mockDB helpers.DatabaseHandler = &mocks.MockDatabaseHandler{}
+	mockDBMock := mockDB.(*mocks.MockDatabaseHandler)
+
+	findCalls := 0
+	var queriedIDs []int
+	mockDBMock.FindFunc = func(dest interface{}, conds ...interface{}) *gorm.DB {
+		findCalls++
+		queriedIDs = conds[1].([]int)
+
+		// Return rows in reverse order, so results must be matched by UserID rather than position
+		profiles := dest.(*[]models.Profile)
+		for i := len(queriedIDs) - 1; i >= 0; i-- {
+			if profile, ok := stored[queriedIDs[i]]; ok {
+				*profiles = append(*profiles, profile)
+			}
+		}
+		return &gorm.DB{}
+	}
+
+	// User 2 is cached; user 1's cache entry holds someone else's profile and must be reloaded
+	cachedTwo, _ := json.Marshal(stored[2])
+	wrongOne, _ := json.Marshal(models.Profile{ProfileID: 999, UserID: 99})
+	cache := map[string][]byte{"profile:1": wrongOne, "profile:2": cachedTwo}
+
+	var cachedKeys []string
+	mockRedis := &mocks.MockRedisHandler{
+		MGetFunc: func(keys []string) ([][]byte, error) {
+			values := make([][]byte, len(keys))
+			for i, key := range keys {
+				values[i] = cache[key]
+			}
+			return values, nil
+		},
+		MSetFunc: func(values map[string]interface{}, expiration time.Duration) error {
+			for key := range values {
+				cachedKeys = append(cachedKeys, key)
+			}
+			return nil
+		},
+	}
+
+	repo := NewProfileRepository(mockDB, mockRedis)
+
+	// User 4 has no profile, and user 1 is requested twice
+	profiles, err := repo.GetProfilesByUserIDs([]int{3, 1, 2, 1, 4})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if findCalls != 1 {
+		t.Errorf("Expected a single database query, got %d", findCalls)
+	}
+	if len(queriedIDs) != 3 {
+		t.Errorf("Expected only cache misses to be queried, got %v", queriedIDs)
+	}
+	if len(profiles) != 3 {
+		t.Fatalf("Expected 3 profiles, got %d", len(profiles))
+	}
+
+	for userID, want := range stored {
+		got, ok := profiles[userID]
+		if !ok {
+			t.Errorf("Missing profile for user %d", userID)
+			continue
+		}
+		if got.UserID != userID || got.ProfileID != want.ProfileID || got.AboutMe != want.AboutMe {
+			t.Errorf("User %d got profile %+v, want %+v", userID, *got, want)
+		}
+	}
+	if _, ok := profiles[4]; ok {
+		t.Error("Expected no profile for user 4")
+	}
+	if len(cachedKeys) != 2 {
+		t.Errorf("Expected the two loaded profiles to be cached, got %v", cachedKeys)
+	}
+}
+
+func Test_profileRepository_GetProfilesByUserIDs_Errors(t *testing.T) {
+	var mockDB helpers.DatabaseHandler = &mocks.MockDatabaseHandler{}
+	mockDBMock := mockDB.(*mocks.MockDatabaseHandler)
+
+	// A Redis failure falls back to the database
+	mockRedis := &mocks.MockRedisHandler{
+		MGetFunc: func(keys []string) ([][]byte, error) {
+			return nil, errors.New("mocked redis error")
+		},
+	}
+	mockDBMock.FindFunc = func(dest interface{}, conds ...interface{}) *gorm.DB {
+		profiles := dest.(*[]models.Profile)
+		*profiles = append(*profiles, models.Profile{ProfileID: 105, UserID: 5})
+		return &gorm.DB{}
+	}
+
+	repo := NewProfileRepository(mockDB, mockRedis)
+	profiles, err := repo.GetProfilesByUserIDs([]int{5})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if profiles[5] == nil || profiles[5].UserID != 5 {
+		t.Errorf("Expected user 5's profile, got %+v", profiles[5])
+	}
+
+	// Database errors are returned
+	mockDBMock.FindFunc = func(dest interface{}, conds ...interface{}) *gorm.DB {
+		return &gorm.DB{Error: errors.New("mocked database error")}
+	}
+	if _, err := repo.GetProfilesByUserIDs([]int{5}); err == nil {
+		t.Error("Expected an error, got nil")
+	}
+
+	// No users means no lookups at all
+	mockDBMock.FindFunc = func(dest interface{}, conds ...interface{}) *gorm.DB {
+		t.Error("Expected no database query")
+		return &gorm.DB{}
+	}
+	profiles, err = repo.GetProfilesByUserIDs(nil)
+	if err != nil || len(profiles) != 0 {
+		t.Errorf("Expected no profiles and no error, got %v, %v", profiles, err)
+	}
+}
+
+// dryRunDB returns a PostgreSQL gorm.DB that builds statements without running them, for mocked
+// Raw queries whose result is read with Find
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func Test_profileRepository_GetProfilesByInterestHidesBlockedAndIncognitoUsers(t *testing.T) {
+	var query string
+	var args []interface{}
+	dry := dryRunDB(t)
+	mockDB := &mocks.MockDatabaseHandler{
+		RawFunc: func(sql string, values ...interface{}) *gorm.DB {
+			query, args = sql, values
+			return dry.Raw(sql, values...)
+		},
+	}
+	repo := NewProfileRepository(mockDB, &mocks.MockRedisHandler{})
+
+	if _, err := repo.GetProfilesByInterest("hiking", 5, 3, 20); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Blocks either way, and incognito users unless they liked the viewer, are left out
+	for _, want := range []string{
+		`p."Interests" @> ?::jsonb AND p."UserID" != ?`,
+		`vb."BlockerUserID" = ? AND vb."BlockedUserID" = p."UserID"`,
+		`vb."BlockerUserID" = p."UserID" AND vb."BlockedUserID" = ?`,
+		`vu."UserID" = p."UserID" AND vu."IsIncognito"`,
+		`vs."SwiperUserID" = p."UserID" AND vs."SwipedUserID" = ? AND vs."SwipeDirection" IN (?)`,
+		`LIMIT ? OFFSET ?`,
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("Expected the query to contain %q, got %s", want, query)
+		}
+	}
+	if len(args) != 8 {
+		t.Fatalf("Expected 8 arguments, got %v", args)
+	}
+	for i, want := range map[int]interface{}{1: 5, 2: 5, 3: 5, 4: 5, 6: 20, 7: 40} {
+		if args[i] != want {
+			t.Errorf("Expected argument %d to be %v, got %v", i, want, args[i])
+		}
+	}
+	if directions, ok := args[5].([]string); !ok || len(directions) != 2 {
+		t.Errorf("Expected the like directions, got %v", args[5])
+	}
+}
@@ -0,0 +1,61 @@
+// profile_view_repository.go
+package repository
+
+import (
+	"strings"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+)
+
+type ProfileViewRepository interface {
+	RecordProfileView(viewerUserID, shownUserID int, viewedAt time.Time) error
+}
+
+type profileViewRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewProfileViewRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) ProfileViewRepository {
+	return &profileViewRepository{db: db, redis: redis}
+}
+
+// RecordProfileView logs that the viewer saw the profile. viewedAt is in the viewer's time zone;
+// repeat views on the same local day are ignored.
+func (r *profileViewRepository) RecordProfileView(viewerUserID, shownUserID int, viewedAt time.Time) error {
+	return insertProfileViews(r.db, viewerUserID, []int{shownUserID}, viewedAt)
+}
+
+// insertProfileViews records that the profiles were shown to the viewer at shownAt, which must be
+// in the viewer's time zone: "DateOnly" holds the viewer's local date, so a profile shown again
+// after the viewer's midnight is a new view even when the server's date has not changed. Profiles
+// already recorded for that day are skipped.
+func insertProfileViews(db helpers.DatabaseHandler, viewerUserID int, shownUserIDs []int, shownAt time.Time) error {
+	if len(shownUserIDs) == 0 {
+		return nil
+	}
+
+	rows := make([]string, 0, len(shownUserIDs))
+	args := make([]interface{}, 0, len(shownUserIDs)*4)
+	for _, shownUserID := range shownUserIDs {
+		rows = append(rows, "(?, ?, ?, ?::date)")
+		args = append(args, viewerUserID, shownUserID, shownAt, localDate(shownAt))
+	}
+
+	return db.Exec(`
+    INSERT INTO "ProfileView" ("ViewerUserID", "ShownUserID", "Timestamp", "DateOnly")
+    VALUES `+strings.Join(rows, ", ")+`
+    ON CONFLICT ("ViewerUserID", "ShownUserID", "DateOnly") DO NOTHING`, args...).Error
+}
+
+// localDate formats the date of t in its own time zone, for comparing with DATE columns without
+// the database converting it to its session time zone first
+func localDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// NewProfileViewRepositoryWithGormDBAndRedis creates a new ProfileViewRepository with GormDB and Redis
+func NewProfileViewRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) ProfileViewRepository {
+	return NewProfileViewRepository(db, redis)
+}
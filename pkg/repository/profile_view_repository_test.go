@@ -0,0 +1,69 @@
+// repository/profile_view_repository_test.go
+package repository
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"gorm.io/gorm"
+)
+
+func Test_locationRepository_LogShownProfilesAcrossLocalMidnight(t *testing.T) {
+	type insert struct {
+		sql  string
+		args []interface{}
+	}
+	var inserts []insert
+
+	var mockDB helpers.DatabaseHandler = &mocks.MockDatabaseHandler{
+		ExecFunc: func(sql string, values ...interface{}) *gorm.DB {
+			inserts = append(inserts, insert{sql: sql, args: values})
+			return &gorm.DB{}
+		},
+	}
+	repo := NewLocationRepository(mockDB, &mocks.MockRedisHandler{}, nil)
+
+	// 23:30 and 00:30 in Los Angeles straddle the viewer's midnight, while both fall on
+	// 2024-03-02 in UTC; the second showing starts a new day for the viewer
+	losAngeles, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeMidnight := time.Date(2024, 3, 1, 23, 30, 0, 0, losAngeles)
+	afterMidnight := beforeMidnight.Add(time.Hour)
+	if beforeMidnight.UTC().YearDay() != afterMidnight.UTC().YearDay() {
+		t.Fatal("both showings should fall on the same UTC date")
+	}
+
+	for _, shownAt := range []time.Time{beforeMidnight, afterMidnight} {
+		if err := repo.LogShownProfiles(1, []int{2, 3}, shownAt); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if len(inserts) != 2 {
+		t.Fatalf("Expected one insert per showing, got %d", len(inserts))
+	}
+	for i, wantDate := range []string{"2024-03-01", "2024-03-02"} {
+		statement := inserts[i]
+		if !strings.Contains(statement.sql, `ON CONFLICT ("ViewerUserID", "ShownUserID", "DateOnly") DO NOTHING`) {
+			t.Fatalf("Expected repeat showings on a day to be ignored, got %s", statement.sql)
+		}
+		if len(statement.args) != 8 {
+			t.Fatalf("Expected a row per shown profile, got args %v", statement.args)
+		}
+		for row := 0; row < 2; row++ {
+			if date := statement.args[row*4+3]; date != wantDate {
+				t.Fatalf("Expected showing %d keyed on the viewer's date %s, got %v", i, wantDate, date)
+			}
+		}
+	}
+
+	// Nothing shown, nothing logged
+	if err := repo.LogShownProfiles(1, nil, afterMidnight); err != nil || len(inserts) != 2 {
+		t.Fatalf("Expected no insert for an empty page, got %d inserts (%v)", len(inserts), err)
+	}
+}
@@ -0,0 +1,243 @@
+// subscription_repository.go
+package repository
+
+import (
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm"
+)
+
+type SubscriptionRepository interface {
+	GetActivePlans() ([]models.Plan, error)
+	GetPlanByCode(code string) (*models.Plan, error)
+	GetCurrentSubscription(userID int, now time.Time) (*models.Subscription, error)
+	GetSubscriptionByProviderID(provider, providerSubscriptionID string) (*models.Subscription, error)
+	SaveSubscription(subscription *models.Subscription) error
+	ActivateSubscription(subscription *models.Subscription) error
+	GetSubscriptionsDueForReminder(now, remindBefore time.Time, limit int) ([]models.Subscription, error)
+	MarkReminderSent(subscriptionID int, accessEndsAt time.Time) (bool, error)
+	StartGracePeriods(now time.Time, grace time.Duration, batchSize int) ([]models.Subscription, error)
+	ExpireLapsedSubscriptions(now time.Time, batchSize int) ([]models.Subscription, error)
+}
+
+// subscriptionAccessEndsAt is the SQL form of models.Subscription.AccessEndsAt
+const subscriptionAccessEndsAt = `(CASE WHEN "Subscription"."Status" = 'PastDue' THEN GREATEST("Subscription"."CurrentPeriodEnd", "Subscription"."GraceUntil") ELSE "Subscription"."CurrentPeriodEnd" END)`
+
+// subscriptionRenews is the SQL form of models.Subscription.Renews
+const subscriptionRenews = `("Subscription"."Status" = 'Active' AND COALESCE("Subscription"."ProviderSubscriptionID", '') <> '')`
+
+type subscriptionRepository struct {
+	db    helpers.DatabaseHandler
+	redis helpers.RedisHandler
+}
+
+func NewSubscriptionRepository(db helpers.DatabaseHandler, redis helpers.RedisHandler) SubscriptionRepository {
+	return &subscriptionRepository{db: db, redis: redis}
+}
+
+// GetActivePlans returns the plans that can be purchased, cheapest tier first
+func (r *subscriptionRepository) GetActivePlans() ([]models.Plan, error) {
+	var plans []models.Plan
+	result := r.db.Where(`"Plan"."IsActive" = ?`, true).Order(`"Rank"`).Find(&plans)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return plans, nil
+}
+
+// GetPlanByCode returns the plan with the given code
+func (r *subscriptionRepository) GetPlanByCode(code string) (*models.Plan, error) {
+	var plan models.Plan
+	result := r.db.First(&plan, `"Plan"."Code" = ?`, code)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &plan, nil
+}
+
+// GetCurrentSubscription returns the user's subscription that grants its plan at the given time,
+// with the plan loaded, or gorm.ErrRecordNotFound
+func (r *subscriptionRepository) GetCurrentSubscription(userID int, now time.Time) (*models.Subscription, error) {
+	var subscription models.Subscription
+	result := r.db.Where(`"Subscription"."UserID" = ? AND "Subscription"."Status" <> ? AND `+subscriptionAccessEndsAt+` > ?`, userID, models.SubscriptionStatusExpired, now).
+		Preload("Plan").
+		First(&subscription)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &subscription, nil
+}
+
+// GetSubscriptionByProviderID returns the subscription the payment provider knows by the given ID,
+// with the plan loaded
+func (r *subscriptionRepository) GetSubscriptionByProviderID(provider, providerSubscriptionID string) (*models.Subscription, error) {
+	var subscription models.Subscription
+	result := r.db.Where(`"Subscription"."Provider" = ? AND "Subscription"."ProviderSubscriptionID" = ?`, provider, providerSubscriptionID).
+		Preload("Plan").
+		First(&subscription)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &subscription, nil
+}
+
+// SaveSubscription creates or updates the subscription and records a SubscriptionChanged event in
+// the outbox
+func (r *subscriptionRepository) SaveSubscription(subscription *models.Subscription) error {
+	now := time.Now()
+	if subscription.CreatedAt.IsZero() {
+		subscription.CreatedAt = now
+	}
+	subscription.UpdatedAt = now
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Plan").Save(subscription).Error; err != nil {
+			return err
+		}
+		return writeOutbox(tx, subscriptionChanged(subscription))
+	})
+}
+
+// ActivateSubscription saves the subscription and expires any other current subscription of the
+// user, e.g. the plan they upgraded from. PremiumActivated and SubscriptionChanged events for all
+// of them are recorded in the outbox; the subscription's plan must be loaded.
+func (r *subscriptionRepository) ActivateSubscription(subscription *models.Subscription) error {
+	now := time.Now()
+	if subscription.CreatedAt.IsZero() {
+		subscription.CreatedAt = now
+	}
+	subscription.UpdatedAt = now
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var replaced []models.Subscription
+		result := tx.Raw(`
+        UPDATE "Subscription" SET "Status" = ?, "UpdatedAt" = ?
+        WHERE "UserID" = ? AND "Status" <> ? AND "SubscriptionID" <> ?
+        RETURNING *`, models.SubscriptionStatusExpired, now, subscription.UserID, models.SubscriptionStatusExpired, subscription.SubscriptionID).Scan(&replaced)
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := tx.Omit("Plan").Save(subscription).Error; err != nil {
+			return err
+		}
+
+		events := subscriptionsChanged(replaced)
+		events = append(events, subscriptionChanged(subscription), models.PremiumActivated{
+			UserID:         subscription.UserID,
+			SubscriptionID: subscription.SubscriptionID,
+			PlanCode:       subscription.Plan.Code,
+			PlanName:       subscription.Plan.Name,
+			PeriodEnd:      subscription.CurrentPeriodEnd,
+		})
+		return writeOutbox(tx, events...)
+	})
+}
+
+// GetSubscriptionsDueForReminder returns up to limit subscriptions that will not renew on their
+// own and end between now and remindBefore, and whose users have not been reminded of that end yet
+func (r *subscriptionRepository) GetSubscriptionsDueForReminder(now, remindBefore time.Time, limit int) ([]models.Subscription, error) {
+	var subscriptions []models.Subscription
+	result := r.db.Where(`"Subscription"."Status" <> ? AND NOT `+subscriptionRenews+`
+        AND `+subscriptionAccessEndsAt+` > ? AND `+subscriptionAccessEndsAt+` <= ?
+        AND "Subscription"."ReminderSentFor" IS DISTINCT FROM `+subscriptionAccessEndsAt, models.SubscriptionStatusExpired, now, remindBefore).
+		Preload("Plan").
+		Order(`"Subscription"."CurrentPeriodEnd"`).
+		Limit(limit).
+		Find(&subscriptions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return subscriptions, nil
+}
+
+// MarkReminderSent records that the user was reminded of the given end of access. It reports
+// false when the reminder was already recorded, e.g. by another instance.
+func (r *subscriptionRepository) MarkReminderSent(subscriptionID int, accessEndsAt time.Time) (bool, error) {
+	result := r.db.Exec(`
+    UPDATE "Subscription" SET "ReminderSentFor" = ?
+    WHERE "SubscriptionID" = ? AND "ReminderSentFor" IS DISTINCT FROM ?`, accessEndsAt, subscriptionID, accessEndsAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// StartGracePeriods moves up to batchSize renewing subscriptions whose period ended without a
+// renewal payment to past due, keeping the plan for the grace period after the period end
+func (r *subscriptionRepository) StartGracePeriods(now time.Time, grace time.Duration, batchSize int) ([]models.Subscription, error) {
+	var subscriptions []models.Subscription
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Raw(`
+        UPDATE "Subscription" SET
+            "Status" = ?,
+            "GraceUntil" = "CurrentPeriodEnd" + ? * INTERVAL '1 second',
+            "UpdatedAt" = ?
+        WHERE "SubscriptionID" IN (
+            SELECT "SubscriptionID" FROM "Subscription"
+            WHERE `+subscriptionRenews+` AND "CurrentPeriodEnd" <= ?
+            LIMIT ?
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING *`, models.SubscriptionStatusPastDue, grace.Seconds(), now, now, batchSize).Scan(&subscriptions)
+		if result.Error != nil {
+			return result.Error
+		}
+		return writeOutbox(tx, subscriptionsChanged(subscriptions)...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// ExpireLapsedSubscriptions expires up to batchSize subscriptions whose access has ended and that
+// are not waiting for a renewal. Rows locked by others, e.g. a webhook renewing them, are skipped.
+func (r *subscriptionRepository) ExpireLapsedSubscriptions(now time.Time, batchSize int) ([]models.Subscription, error) {
+	var subscriptions []models.Subscription
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Raw(`
+        UPDATE "Subscription" SET "Status" = ?, "UpdatedAt" = ?
+        WHERE "SubscriptionID" IN (
+            SELECT "SubscriptionID" FROM "Subscription"
+            WHERE "Status" <> ? AND NOT `+subscriptionRenews+` AND `+subscriptionAccessEndsAt+` <= ?
+            LIMIT ?
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING *`, models.SubscriptionStatusExpired, now, models.SubscriptionStatusExpired, now, batchSize).Scan(&subscriptions)
+		if result.Error != nil {
+			return result.Error
+		}
+		return writeOutbox(tx, subscriptionsChanged(subscriptions)...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// subscriptionChanged is the SubscriptionChanged event of the subscription's current state
+func subscriptionChanged(subscription *models.Subscription) models.DomainEvent {
+	return models.SubscriptionChanged{
+		SubscriptionID:   subscription.SubscriptionID,
+		UserID:           subscription.UserID,
+		PlanID:           subscription.PlanID,
+		Status:           subscription.Status,
+		CurrentPeriodEnd: subscription.CurrentPeriodEnd,
+	}
+}
+
+// subscriptionsChanged returns a SubscriptionChanged event for each subscription
+func subscriptionsChanged(subscriptions []models.Subscription) []models.DomainEvent {
+	events := make([]models.DomainEvent, 0, len(subscriptions))
+	for i := range subscriptions {
+		events = append(events, subscriptionChanged(&subscriptions[i]))
+	}
+	return events
+}
+
+// NewSubscriptionRepositoryWithGormDBAndRedis creates a new SubscriptionRepository with GormDB and Redis
+func NewSubscriptionRepositoryWithGormDBAndRedis(db helpers.DatabaseHandler, redis helpers.RedisHandler) SubscriptionRepository {
+	return NewSubscriptionRepository(db, redis)
+}
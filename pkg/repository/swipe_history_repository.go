@@ -3,19 +3,46 @@ package repository
 
 import (
 	"errors"
-	"log"
 	"time"
 
 	"github.com/metabbe3/knoxsdating/pkg/helpers"
 	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type SwipeHistoryRepository interface {
-	SaveSwipe(swipe *models.SwipeHistory, PremiumStatus interface{}) (bool, error)
+	SaveSwipe(swipe *models.SwipeHistory) (bool, error)
 	GetMatches(userID int, matchType string) ([]models.User, error)
-	RedoSwipe(userID int) (*models.SwipeHistory, []models.User, error)
+	UndoLeftSwipe(userID int, since, today time.Time) (*models.SwipeHistory, error)
+	HasLiked(swiperUserID, swipedUserID int) (bool, error)
+	AreMatched(userID1, userID2 int) (bool, error)
+	GetSwipeStats(userIDs []int) (map[int]SwipeStats, error)
+	GetLikersAmong(swipedUserID int, swiperUserIDs []int) (map[int]string, error)
+	GetSwipesSince(since time.Time) ([]models.SwipeHistory, error)
+	GetLikesReceived(userID, page, pageSize int) ([]LikeReceived, int64, error)
+	GetSwipeByID(swipeID int) (*models.SwipeHistory, error)
 }
 
+// SwipeStats counts the swipes a user has made and how many of them were likes
+type SwipeStats struct {
+	UserID int `json:"userID"`
+	Swipes int `json:"swipes"`
+	Likes  int `json:"likes"`
+}
+
+// LikeReceived is a user who liked the swiped user and has not been matched with them yet. LikeID
+// is their latest like, so the liker can be referred to without revealing who they are.
+type LikeReceived struct {
+	LikeID  int       `gorm:"column:LikeID"`
+	UserID  int       `gorm:"column:UserID"`
+	Super   bool      `gorm:"column:Super"`
+	LikedAt time.Time `gorm:"column:LikedAt"`
+}
+
+// likeDirections are the swipe directions that count as liking the swiped user
+var likeDirections = []string{models.SwipeDirectionRight, models.SwipeDirectionSuper}
+
 type swipeHistoryRepository struct {
 	db    helpers.DatabaseHandler
 	redis helpers.RedisHandler
@@ -25,59 +52,73 @@ func NewSwipeHistoryRepository(db helpers.DatabaseHandler, redis helpers.RedisHa
 	return &swipeHistoryRepository{db: db, redis: redis}
 }
 
-// SaveSwipe saves the swipe history entry to the database and returns whether it is matched or not
-func (r *swipeHistoryRepository) SaveSwipe(swipe *models.SwipeHistory, PremiumStatus interface{}) (bool, error) {
-	// Check premium status and set the maximum allowed swipes
-	maxSwipes := 10
-	log.Println(PremiumStatus)
-	if PremiumStatus == "Premium" {
-		// If the user is premium, allow unlimited swipes
-		maxSwipes = -1
-	}
-
-	// Fetch the user's total swipes for the day
-	var totalSwipes int64
-	r.db.Model(&models.SwipeHistory{}).
-		Where(`"SwiperUserID" = ? AND DATE("Timestamp") = ?`, swipe.SwiperUserID, time.Now().UTC().Format("2006-01-02")).
-		Count(&totalSwipes)
-
-	// Check if the user has exceeded the maximum allowed swipes
-	if maxSwipes != -1 && totalSwipes >= int64(maxSwipes) {
-		return false, errors.New("maximum swipes exceeded for the day")
-	}
-
-	// Check if there is a match (opposite swipe direction from the swiped user)
-	oppositeSwipe := models.SwipeHistory{}
-	result := r.db.Where(
-		`"SwiperUserID" = ? AND "SwipedUserID" = ? AND "SwipeDirection" = ?`,
-		swipe.SwipedUserID, swipe.SwiperUserID, "right",
-	).First(&oppositeSwipe)
-	log.Print(result.RowsAffected)
-	// If there is an opposite swipe, it's a match
-	if result.RowsAffected > 0 && swipe.SwipeDirection == "right" {
+// SaveSwipe saves the swipe history entry to the database and returns whether it is matched or not.
+// A SwipeRecorded event, and a MatchCreated event when the swipe completes a match, are recorded in
+// the outbox with it. Daily limits are up to the caller.
+func (r *swipeHistoryRepository) SaveSwipe(swipe *models.SwipeHistory) (bool, error) {
+	isMatched := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Check if there is a match (opposite swipe direction from the swiped user)
+		var oppositeSwipes []models.SwipeHistory
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(`"SwiperUserID" = ? AND "SwipedUserID" = ? AND "SwipeDirection" IN (?)`, swipe.SwipedUserID, swipe.SwiperUserID, likeDirections).
+			Limit(1).
+			Find(&oppositeSwipes)
+		if result.Error != nil {
+			return result.Error
+		}
+
 		now := time.Now()
-		// Update IsMatched in both entries
-		swipe.IsMatched = true
-		oppositeSwipe.IsMatched = true
-		oppositeSwipe.Timestamp = now
 		swipe.Timestamp = now
+		events := []models.DomainEvent{}
 
-		// Reset RedoCount for both entries when it's a match
-		swipe.RedoCount = 0
+		// If there is an opposite swipe, it's a match
+		if len(oppositeSwipes) > 0 && models.IsLike(swipe.SwipeDirection) {
+			oppositeSwipe := oppositeSwipes[0]
+			// Update IsMatched in both entries
+			swipe.IsMatched = true
+			oppositeSwipe.IsMatched = true
+			oppositeSwipe.Timestamp = now
 
-		// Save the updated entries
-		r.db.Save(swipe)
-		r.db.Save(&oppositeSwipe)
+			// Reset RedoCount for both entries when it's a match
+			swipe.RedoCount = 0
 
-		return true, nil
-	}
+			// Save the updated entries
+			if err := tx.Save(swipe).Error; err != nil {
+				return err
+			}
+			if err := tx.Save(&oppositeSwipe).Error; err != nil {
+				return err
+			}
+			isMatched = true
+			events = append(events, models.MatchCreated{
+				UserID:        swipe.SwiperUserID,
+				MatchedUserID: swipe.SwipedUserID,
+				MatchedAt:     now,
+			})
+		} else if swipe.RedoCount == 0 {
+			// Create the swipe history entry if it's not a redo
+			if err := tx.Create(swipe).Error; err != nil {
+				return err
+			}
+		} else {
+			// A redo that does not complete a match changes nothing
+			return nil
+		}
 
-	// Create the swipe history entry if it's not a redo
-	if swipe.RedoCount == 0 {
-		r.db.Create(swipe)
+		events = append([]models.DomainEvent{models.SwipeRecorded{
+			SwipeID:        swipe.SwipeHistoryEntityID,
+			SwiperUserID:   swipe.SwiperUserID,
+			SwipedUserID:   swipe.SwipedUserID,
+			SwipeDirection: swipe.SwipeDirection,
+			SwipedAt:       now,
+		}}, events...)
+		return writeOutbox(tx, events...)
+	})
+	if err != nil {
+		return false, err
 	}
-
-	return false, nil
+	return isMatched, nil
 }
 
 func (r *swipeHistoryRepository) GetMatches(userID int, matchType string) ([]models.User, error) {
@@ -95,17 +136,6 @@ func (r *swipeHistoryRepository) GetMatches(userID int, matchType string) ([]mod
 			return nil, result.Error
 		}
 
-	case "liked":
-		// Retrieve matches where the current user was liked by others
-		result := r.db.Table("SwipeHistory").
-			Select(`"User".*`).
-			Joins(`JOIN "User" ON "SwipeHistory"."SwiperUserID" = "User"."UserID"`).
-			Where(`"SwipeHistory"."SwipedUserID" = ? AND "SwipeHistory"."SwipeDirection" = 'right' AND "SwipeHistory"."IsMatched" = false`, userID).
-			Scan(&matches)
-		if result.Error != nil {
-			return nil, result.Error
-		}
-
 	default:
 		return nil, errors.New("invalid match type")
 	}
@@ -113,43 +143,163 @@ func (r *swipeHistoryRepository) GetMatches(userID int, matchType string) ([]mod
 	return matches, nil
 }
 
-// RedoSwipe allows a user to redo a swipe in the database
-func (r *swipeHistoryRepository) RedoSwipe(userID int) (*models.SwipeHistory, []models.User, error) {
-	// Retrieve the latest swipe entry with RedoCount > 0
-	var originalSwipe models.SwipeHistory
-	result := r.db.Where(`"SwiperUserID" = ?`, userID).Order(`"Timestamp" DESC`).First(&originalSwipe)
+// UndoLeftSwipe deletes the user's most recent left swipe made at or after since, together with the
+// record of the profile having been shown to them on today, the user's local date, so it can appear
+// in discovery again. Views on earlier days are kept: discovery only skips profiles shown today. It
+// returns the deleted swipe, or gorm.ErrRecordNotFound when there is none to undo.
+func (r *swipeHistoryRepository) UndoLeftSwipe(userID int, since, today time.Time) (*models.SwipeHistory, error) {
+	var swipe models.SwipeHistory
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the swipe so concurrent rewinds cannot both undo it
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(`"SwiperUserID" = ? AND "SwipeDirection" = ? AND "Timestamp" >= ?`, userID, models.SwipeDirectionLeft, since).
+			Order(`"Timestamp" DESC, "SwipeHistoryEntityID" DESC`).
+			First(&swipe)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if err := tx.Delete(&models.SwipeHistory{}, `"SwipeHistoryEntityID" = ?`, swipe.SwipeHistoryEntityID).Error; err != nil {
+			return err
+		}
+		return tx.Where(`"ViewerUserID" = ? AND "ShownUserID" = ? AND "DateOnly" = ?::date`, userID, swipe.SwipedUserID, localDate(today)).
+			Delete(&models.ProfileView{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &swipe, nil
+}
+
+// HasLiked reports whether the swiper has liked or super liked the swiped user
+func (r *swipeHistoryRepository) HasLiked(swiperUserID, swipedUserID int) (bool, error) {
+	var count int64
+	result := r.db.Model(&models.SwipeHistory{}).
+		Where(`"SwiperUserID" = ? AND "SwipedUserID" = ? AND "SwipeDirection" IN (?)`, swiperUserID, swipedUserID, likeDirections).
+		Count(&count)
 	if result.Error != nil {
-		return nil, nil, result.Error
+		return false, result.Error
 	}
+	return count > 0, nil
+}
 
-	if originalSwipe.RedoCount == 0 {
-		// Perform the redo logic (e.g., go back to the previous profile)
-		// ...
+// AreMatched reports whether the two users have matched
+func (r *swipeHistoryRepository) AreMatched(userID1, userID2 int) (bool, error) {
+	var count int64
+	result := r.db.Model(&models.SwipeHistory{}).
+		Where(`"SwiperUserID" = ? AND "SwipedUserID" = ? AND "IsMatched" = true`, userID1, userID2).
+		Count(&count)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return count > 0, nil
+}
 
-		// Increment the RedoCount
-		originalSwipe.RedoCount++
+// GetSwipeStats returns swipe and like counts for each of the given swipers; users who never
+// swiped are absent from the map
+func (r *swipeHistoryRepository) GetSwipeStats(userIDs []int) (map[int]SwipeStats, error) {
+	stats := make(map[int]SwipeStats, len(userIDs))
+	if len(userIDs) == 0 {
+		return stats, nil
+	}
 
-		// Save the updated swipe entry back to the database
-		result = r.db.Save(&originalSwipe)
-		if result.Error != nil {
-			return nil, nil, result.Error
-		}
+	var rows []SwipeStats
+	result := r.db.Model(&models.SwipeHistory{}).
+		Select(`"SwiperUserID" AS "UserID", COUNT(*) AS "Swipes", COUNT(*) FILTER (WHERE "SwipeDirection" IN (?)) AS "Likes"`, likeDirections).
+		Where(`"SwiperUserID" IN (?)`, userIDs).
+		Group(`"SwiperUserID"`).
+		Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
 
-		// Retrieve the profiles and User based on the userID being swiped
-		var profiles []models.User
-		result = r.db.Table("SwipeHistory").
-			Select(`DISTINCT ON ("SwipeHistory"."SwipedUserID") "SwipeHistory"."SwipedUserID", "User".*`).
-			Joins(`JOIN "User" ON "SwipeHistory"."SwipedUserID" = "User"."UserID"`).
-			Where(`"SwipeHistory"."SwiperUserID" = ? AND "SwipeHistory"."IsMatched" = true`, userID).
-			Scan(&profiles)
-		if result.Error != nil {
-			return nil, nil, result.Error
+	for _, row := range rows {
+		stats[row.UserID] = row
+	}
+	return stats, nil
+}
+
+// GetLikersAmong returns which of the given users have liked swipedUserID, mapped to the
+// direction of their like; a super like wins over a plain one
+func (r *swipeHistoryRepository) GetLikersAmong(swipedUserID int, swiperUserIDs []int) (map[int]string, error) {
+	likers := make(map[int]string)
+	if len(swiperUserIDs) == 0 {
+		return likers, nil
+	}
+
+	var rows []struct {
+		SwiperUserID   int    `gorm:"column:SwiperUserID"`
+		SwipeDirection string `gorm:"column:SwipeDirection"`
+	}
+	result := r.db.Model(&models.SwipeHistory{}).
+		Select(`"SwiperUserID", "SwipeDirection"`).
+		Where(`"SwipedUserID" = ? AND "SwiperUserID" IN (?) AND "SwipeDirection" IN (?)`, swipedUserID, swiperUserIDs, likeDirections).
+		Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	for _, row := range rows {
+		if likers[row.SwiperUserID] != models.SwipeDirectionSuper {
+			likers[row.SwiperUserID] = row.SwipeDirection
 		}
+	}
+	return likers, nil
+}
 
-		return &originalSwipe, profiles, nil
+// GetSwipesSince returns every swipe made after since, oldest first
+func (r *swipeHistoryRepository) GetSwipesSince(since time.Time) ([]models.SwipeHistory, error) {
+	var swipes []models.SwipeHistory
+	result := r.db.Where(`"SwipeHistory"."Timestamp" >= ?`, since).Order(`"Timestamp" ASC`).Find(&swipes)
+	if result.Error != nil {
+		return nil, result.Error
 	}
+	return swipes, nil
+}
+
+// likesReceivedFrom selects the unmatched likes of the user, leaving out users who are matched with
+// them through another swipe and blocked pairs
+const likesReceivedFrom = `
+    FROM "SwipeHistory" AS s
+    WHERE s."SwipedUserID" = ? AND s."SwipeDirection" IN (?) AND s."IsMatched" = false
+        AND NOT EXISTS (
+            SELECT 1 FROM "SwipeHistory" AS m
+            WHERE m."SwiperUserID" = s."SwipedUserID" AND m."SwipedUserID" = s."SwiperUserID" AND m."IsMatched" = true)
+        AND NOT EXISTS (
+            SELECT 1 FROM "Block" AS b
+            WHERE (b."BlockerUserID" = s."SwipedUserID" AND b."BlockedUserID" = s."SwiperUserID")
+                OR (b."BlockerUserID" = s."SwiperUserID" AND b."BlockedUserID" = s."SwipedUserID"))`
 
-	return nil, nil, errors.New("no more redos available for this profile")
+// GetLikesReceived returns a page of the users who liked the user and are not matched with them,
+// most recent like first, and how many there are in total
+func (r *swipeHistoryRepository) GetLikesReceived(userID, page, pageSize int) ([]LikeReceived, int64, error) {
+	var total int64
+	result := r.db.Raw(`SELECT COUNT(DISTINCT s."SwiperUserID")`+likesReceivedFrom, userID, likeDirections).Scan(&total)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	var likes []LikeReceived
+	result = r.db.Raw(`
+    SELECT MAX(s."SwipeHistoryEntityID") AS "LikeID", s."SwiperUserID" AS "UserID",
+        BOOL_OR(s."SwipeDirection" = ?) AS "Super", MAX(s."Timestamp") AS "LikedAt"`+likesReceivedFrom+`
+    GROUP BY s."SwiperUserID"
+    ORDER BY "LikedAt" DESC, "UserID"
+    LIMIT ? OFFSET ?`, models.SwipeDirectionSuper, userID, likeDirections, pageSize, (page-1)*pageSize).Scan(&likes)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	return likes, total, nil
+}
+
+// GetSwipeByID returns the swipe with the given ID
+func (r *swipeHistoryRepository) GetSwipeByID(swipeID int) (*models.SwipeHistory, error) {
+	var swipe models.SwipeHistory
+	result := r.db.First(&swipe, `"SwipeHistory"."SwipeHistoryEntityID" = ?`, swipeID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &swipe, nil
 }
 
 // NewUserRepositoryWithGormDBAndRedis creates a new ProfileRepository with GormDB and Redis
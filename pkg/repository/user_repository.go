@@ -9,6 +9,7 @@ import (
 type UserRepository interface {
 	CreateUser(user *models.User) error
 	GetUserByID(userID int) (*models.User, error)
+	GetUsersByIDs(userIDs []int) (map[int]*models.User, error)
 	GetUserByEmail(email string) (*models.User, error)
 	GetAllUsers() ([]models.User, error)
 	DoesUserWithEmailExist(email string) (bool, error)
@@ -42,6 +43,25 @@ func (r *userRepository) GetUserByID(userID int) (*models.User, error) {
 	return &user, nil
 }
 
+// GetUsersByIDs loads several users with one query, keyed by user ID. Unknown users are absent from
+// the result.
+func (r *userRepository) GetUsersByIDs(userIDs []int) (map[int]*models.User, error) {
+	users := make(map[int]*models.User, len(userIDs))
+	if len(userIDs) == 0 {
+		return users, nil
+	}
+
+	var loaded []models.User
+	result := r.db.Find(&loaded, `"User"."UserID" IN ?`, userIDs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for i := range loaded {
+		users[loaded[i].UserID] = &loaded[i]
+	}
+	return users, nil
+}
+
 func (r *userRepository) GetUserByEmail(email string) (*models.User, error) {
 	var user models.User
 	result := r.db.First(&user, `"User"."Email" = ?`, email)
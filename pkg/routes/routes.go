@@ -2,19 +2,34 @@
 package routes
 
 import (
+	"context"
+	"log"
 	"net/http"
+	"os"
+	"strconv"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"github.com/metabbe3/knoxsdating/pkg/handlers"
 	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
 	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"github.com/metabbe3/knoxsdating/pkg/services"
 )
 
 // InitializeRoutes initializes all routes for the application
 func InitializeRoutes() *mux.Router {
 	router := mux.NewRouter()
 
+	// Signed preview and download links are only as private as their key
+	if os.Getenv(helpers.URLSigningKeyEnv) == "" {
+		log.Fatalf("%s must be set to sign preview and download links", helpers.URLSigningKeyEnv)
+	}
+	// So are fuzzed locations, whose key must not be shared with the links
+	if fuzzKey := os.Getenv(helpers.LocationFuzzKeyEnv); fuzzKey == "" || fuzzKey == os.Getenv(helpers.URLSigningKeyEnv) {
+		log.Fatalf("%s must be set, to a key other than %s, to fuzz stored locations", helpers.LocationFuzzKeyEnv, helpers.URLSigningKeyEnv)
+	}
+
 	// Connect to the database
 	db, err := helpers.ConnectToDatabase()
 	if err != nil {
@@ -33,31 +48,149 @@ func InitializeRoutes() *mux.Router {
 		panic("Invalid type assertion for redisHelper")
 	}
 
-	// For Notification handlers
-	notificationRepo := repository.NewNotificationRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
-	notificationHandlers := handlers.NewNotificationHandlers(notificationRepo, redisHelperInstance)
+	// Retried POST, PUT and DELETE requests carrying an Idempotency-Key get the first response back.
+	// Their bodies are buffered to fingerprint them, up to the size of a photo upload.
+	router.Use(helpers.IdempotencyMiddleware(redisHelper, services.MaxPhotoUploadSize+1<<20))
 
 	// For User handlers
 	userRepo := repository.NewUserRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
 	userHandlers := handlers.NewUserHandlers(userRepo, redisHelperInstance)
 
-	// For Profile handlers
+	// Repositories record domain events in the outbox along with their changes; the relay
+	// publishes them to the broker and consumers hand them to the event bus
+	outboxRepo := repository.NewOutboxRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	broker := newMessageBroker(services.NotificationsQueue, services.PushQueue)
+	eventBus := services.NewEventBus()
+	go services.NewOutboxRelay(outboxRepo, broker, services.OutboxRelayPolicyFromEnv()).Start(context.Background())
+	go services.NewEventConsumer(services.NotificationsQueue, broker, outboxRepo, eventBus).Start(context.Background())
+
+	// For Notification handlers, notifications are created from matches, messages and purchases.
+	// Users' preferences decide which channels each notification is delivered on, and when.
+	notificationRepo := repository.NewNotificationRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	notificationPreferencesRepo := repository.NewNotificationPreferencesRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	notificationService := services.NewNotificationService(notificationRepo, userRepo, notificationPreferencesRepo)
+	notificationService.Subscribe(eventBus)
+	notificationHandlers := handlers.NewNotificationHandlers(notificationRepo, notificationService, redisHelperInstance)
+	notificationPreferencesHandlers := handlers.NewNotificationPreferencesHandlers(notificationPreferencesRepo, redisHelperInstance)
+
+	// Notifications of the selected types are also pushed to the user's devices. The push queue
+	// has its own bus so failed pushes are retried apart from inbox notifications.
+	deviceTokenRepo := repository.NewDeviceTokenRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	deliveryRepo := repository.NewNotificationDeliveryRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	pushService := services.NewPushService(notificationRepo, deviceTokenRepo, deliveryRepo, notificationPreferencesRepo, userRepo, newPushSenders(), services.PushPolicyFromEnv())
+	pushBus := services.NewEventBus()
+	pushService.Subscribe(pushBus)
+	go services.NewEventConsumer(services.PushQueue, broker, outboxRepo, pushBus).Start(context.Background())
+	go pushService.Start(context.Background())
+	pushHandlers := handlers.NewPushHandlers(pushService, notificationService, redisHelperInstance)
+
+	// Blob storage for archives and uploaded photos
+	blobStore := newBlobStore()
+
+	// Repositories shared by several handlers
 	profileRepo := repository.NewProfileRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
-	profileHandlers := handlers.NewProfileHandlers(profileRepo, redisHelperInstance)
+	photoRepo := repository.NewPhotoRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	locationRepo := repository.NewLocationRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper, profileRepo)
+	swipeHistoryRepo := repository.NewSwipeHistoryRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	blockRepo := repository.NewBlockRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	profileViewRepo := repository.NewProfileViewRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	passportRepo := repository.NewPassportRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	subscriptionRepo := repository.NewSubscriptionRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	inventoryRepo := repository.NewInventoryRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+
+	// Plans and the features they grant, checked by every premium feature
+	entitlementsService := services.NewEntitlementsService(subscriptionRepo, redisHelper)
+	subscriptionHandlers := handlers.NewSubscriptionHandlers(entitlementsService, redisHelperInstance)
+
+	// Daily quotas, reset at midnight in each user's time zone
+	quotaService := services.NewQuotaService(userRepo, redisHelper)
+
+	// Super likes, boosts and rewinds granted by plans or bought in packs
+	inventoryService := services.NewInventoryService(userRepo, inventoryRepo, entitlementsService)
+	inventoryHandlers := handlers.NewInventoryHandlers(inventoryService, redisHelperInstance)
+
+	// Reminds, grants grace to and expires subscriptions on one leader-elected instance
+	subscriptionSchedulerPolicy := services.SubscriptionSchedulerPolicyFromEnv()
+	go services.NewSubscriptionScheduler(subscriptionRepo, notificationRepo, passportRepo, entitlementsService, redisHelper, subscriptionSchedulerPolicy).Start(context.Background())
+
+	// For Billing handlers
+	paymentEventRepo := repository.NewPaymentEventRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	billingService := services.NewBillingService(newPaymentProvider(), subscriptionRepo, paymentEventRepo, userRepo, entitlementsService, inventoryService, services.BillingConfig{
+		SuccessURL:  envOrDefault("CHECKOUT_SUCCESS_URL", "https://knoxsdating.app/premium/success"),
+		CancelURL:   envOrDefault("CHECKOUT_CANCEL_URL", "https://knoxsdating.app/premium"),
+		GracePeriod: subscriptionSchedulerPolicy.GracePeriod,
+	})
+	billingHandlers := handlers.NewBillingHandlers(billingService, redisHelperInstance)
+
+	// For Profile handlers
+	publicProfileService := services.NewPublicProfileService(userRepo, profileRepo, locationRepo, blockRepo, swipeHistoryRepo, profileViewRepo, passportRepo, redisHelper)
+	profileHandlers := handlers.NewProfileHandlers(profileRepo, photoRepo, publicProfileService, entitlementsService, redisHelperInstance)
+
+	// For Passport handlers
+	passportHandlers := handlers.NewPassportHandlers(passportRepo, entitlementsService, redisHelperInstance)
+
+	// For Block handlers
+	blockHandlers := handlers.NewBlockHandlers(blockRepo, userRepo, redisHelperInstance)
+
+	// For Interest handlers
+	interestRepo := repository.NewInterestRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	interestHandlers := handlers.NewInterestHandlers(interestRepo, profileRepo, publicProfileService, entitlementsService, redisHelperInstance)
+	loadInterestTaxonomy(interestRepo)
+
+	// For Photo handlers
+	photoService := services.NewPhotoService(photoRepo, blobStore, redisHelper)
+	photoHandlers := handlers.NewPhotoHandlers(photoRepo, photoService, publicProfileService, redisHelperInstance)
+
+	// For Discovery handlers
+	discoveryPreferencesRepo := repository.NewDiscoveryPreferencesRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	rankingWeights, err := services.RankingWeightsFromEnv()
+	if err != nil {
+		log.Printf("Invalid %s, using default ranking weights: %v", services.RankingWeightsEnv, err)
+	}
+	discoveryService := services.NewDiscoveryService(userRepo, profileRepo, discoveryPreferencesRepo, locationRepo, swipeHistoryRepo, inventoryRepo, rankingWeights)
+	discoveryHandlers := handlers.NewDiscoveryHandlers(discoveryPreferencesRepo, redisHelperInstance)
 
 	// For Location handlers
-	locationRepo := repository.NewLocationRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper, profileRepo)
-	locationHandlers := handlers.NewLocationHandlers(locationRepo, userRepo, redisHelperInstance)
+	locationRetentionPolicy := services.LocationRetentionPolicyFromEnv()
+	locationHandlers := handlers.NewLocationHandlers(locationRepo, userRepo, discoveryService, entitlementsService, locationRetentionPolicy, redisHelperInstance)
+
+	// Coarsen and purge old location history in the background
+	go services.NewLocationRetentionJob(locationRepo, redisHelper, locationRetentionPolicy).Start(context.Background())
 
 	// For SwipeHistory handlers
-	swipeHistoryRepo := repository.NewSwipeHistoryRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
-	swipeHistoryHandlers := handlers.NewSwipeHistoryHandlers(swipeHistoryRepo, redisHelperInstance)
+	rewindService := services.NewRewindService(userRepo, swipeHistoryRepo, inventoryService, publicProfileService, entitlementsService, services.RewindWindowFromEnv())
+	swipeHistoryHandlers := handlers.NewSwipeHistoryHandlers(swipeHistoryRepo, profileRepo, notificationRepo, entitlementsService, inventoryService, rewindService, quotaService, redisHelperInstance)
+
+	// For Message handlers
+	messageRepo := repository.NewMessageRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	messageService := services.NewMessageService(messageRepo, swipeHistoryRepo, blockRepo, entitlementsService)
+	messageHandlers := handlers.NewMessageHandlers(messageService, redisHelperInstance)
+
+	// For Likes handlers
+	likesService := services.NewLikesService(swipeHistoryRepo, publicProfileService, photoService, entitlementsService)
+	likesHandlers := handlers.NewLikesHandlers(likesService, redisHelperInstance)
+
+	// For DataExport handlers
+	dataExportRepo := repository.NewDataExportRepositoryWithGormDBAndRedis(helpers.NewGormDBHandler(db), redisHelper)
+	dataExportService := services.NewDataExportService(dataExportRepo, notificationRepo, blobStore, services.DataExportPolicyFromEnv())
+	go dataExportService.Start(context.Background())
+	dataExportHandlers := handlers.NewDataExportHandlers(dataExportRepo, dataExportService, redisHelperInstance)
 	// Add other handlers as needed
 
 	router.HandleFunc("/notifications", notificationHandlers.CreateNotification).Methods("POST")
+	router.HandleFunc("/notifications", notificationHandlers.GetNotifications).Methods("GET")
+	router.HandleFunc("/notifications/unread-count", notificationHandlers.GetUnreadCount).Methods("GET")
+	router.HandleFunc("/notifications/preferences", notificationPreferencesHandlers.GetPreferences).Methods("GET")
+	router.HandleFunc("/notifications/preferences", notificationPreferencesHandlers.UpdatePreferences).Methods("PUT")
+	router.HandleFunc("/notifications/preferences", notificationPreferencesHandlers.DeletePreferences).Methods("DELETE")
+	router.HandleFunc("/notifications/read", notificationHandlers.MarkRead).Methods("POST")
+	router.HandleFunc("/notifications/read-all", notificationHandlers.MarkAllRead).Methods("POST")
 	router.HandleFunc("/notifications/{id:[0-9]+}", notificationHandlers.GetNotificationByID).Methods("GET")
 	router.HandleFunc("/notifications/{id:[0-9]+}", notificationHandlers.UpdateNotification).Methods("PUT")
 	router.HandleFunc("/notifications/{id:[0-9]+}", notificationHandlers.DeleteNotification).Methods("DELETE")
+	router.HandleFunc("/notifications/{id:[0-9]+}/deliveries", pushHandlers.GetNotificationDeliveries).Methods("GET")
+	router.HandleFunc("/devices", pushHandlers.RegisterDevice).Methods("POST")
+	router.HandleFunc("/devices/{token}", pushHandlers.UnregisterDevice).Methods("DELETE")
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("hi"))
 	}).Methods("GET")
@@ -66,20 +199,202 @@ func InitializeRoutes() *mux.Router {
 	router.HandleFunc("/users", userHandlers.RegisterUser).Methods("POST")
 	router.HandleFunc("/users/login", userHandlers.Login).Methods("POST")
 	router.HandleFunc("/users", userHandlers.UpdateUser).Methods("PUT")
-	router.HandleFunc("/users/updatePremium", userHandlers.UpdatePremium).Methods("PUT")
+	router.HandleFunc("/users/incognito", userHandlers.UpdateIncognito).Methods("PUT")
+	router.HandleFunc("/users/entitlements", subscriptionHandlers.GetEntitlements).Methods("GET")
+	router.HandleFunc("/users/{id:[0-9]+}/profile", profileHandlers.GetPublicProfile).Methods("GET")
+	router.HandleFunc("/users/{id:[0-9]+}/block", blockHandlers.BlockUser).Methods("POST")
+	router.HandleFunc("/users/{id:[0-9]+}/block", blockHandlers.UnblockUser).Methods("DELETE")
+
+	// Personal data export routes
+	router.HandleFunc("/users/export", dataExportHandlers.RequestExport).Methods("POST")
+	router.HandleFunc("/users/export/{id:[0-9]+}", dataExportHandlers.GetExport).Methods("GET")
+	router.HandleFunc("/exports/{id:[0-9]+}/download", dataExportHandlers.DownloadExport).Methods("GET")
+
+	// Subscription routes
+	router.HandleFunc("/plans", subscriptionHandlers.GetPlans).Methods("GET")
+	router.HandleFunc("/subscriptions/checkout", billingHandlers.Checkout).Methods("POST")
+	router.HandleFunc("/payments/webhook", billingHandlers.Webhook).Methods("POST")
+
+	// Inventory routes
+	router.HandleFunc("/inventory", inventoryHandlers.GetInventory).Methods("GET")
+	router.HandleFunc("/inventory/packs", inventoryHandlers.GetPacks).Methods("GET")
+	router.HandleFunc("/inventory/packs/checkout", billingHandlers.CheckoutPack).Methods("POST")
+	router.HandleFunc("/boosts", inventoryHandlers.StartBoost).Methods("POST")
 
 	// Profile routes
 	router.HandleFunc("/profiles", profileHandlers.CreateProfile).Methods("POST")
 	router.HandleFunc("/profiles", profileHandlers.GetProfile).Methods("GET")
 
+	// Interest routes
+	router.HandleFunc("/interests", interestHandlers.GetInterests).Methods("GET")
+	router.HandleFunc("/interests/{slug}/profiles", interestHandlers.GetProfilesByInterest).Methods("GET")
+
+	// Photo routes
+	router.HandleFunc("/profiles/photos", photoHandlers.UploadPhoto).Methods("POST")
+	router.HandleFunc("/profiles/photos", photoHandlers.GetPhotos).Methods("GET")
+	router.HandleFunc("/profiles/photos/order", photoHandlers.ReorderPhotos).Methods("PUT")
+	router.HandleFunc("/profiles/photos/{id:[0-9]+}", photoHandlers.DeletePhoto).Methods("DELETE")
+	router.HandleFunc("/photos/{id:[0-9]+}", photoHandlers.GetPhoto).Methods("GET")
+	router.HandleFunc("/photos/{id:[0-9]+}/thumbnail", photoHandlers.GetPhotoThumbnail).Methods("GET")
+
 	// Location routes
 	router.HandleFunc("/locations", locationHandlers.CreateLocationHistory).Methods("POST")
 	router.HandleFunc("/locations/nearby", locationHandlers.GetNearbyLocations).Methods("POST") // New route for getting nearby locations
+	router.HandleFunc("/locations/history", locationHandlers.GetLocationHistory).Methods("GET")
+	router.HandleFunc("/locations/history", locationHandlers.DeleteLocationHistory).Methods("DELETE")
 	// Add other location routes as needed
 
+	// Passport (travel mode) routes
+	router.HandleFunc("/passport", passportHandlers.SetPassport).Methods("PUT")
+	router.HandleFunc("/passport", passportHandlers.GetPassport).Methods("GET")
+	router.HandleFunc("/passport", passportHandlers.DeletePassport).Methods("DELETE")
+	router.HandleFunc("/passport/cities", passportHandlers.GetPassportCities).Methods("GET")
+
+	// Discovery preference routes
+	router.HandleFunc("/discovery/preferences", discoveryHandlers.GetPreferences).Methods("GET")
+	router.HandleFunc("/discovery/preferences", discoveryHandlers.UpdatePreferences).Methods("PUT")
+	router.HandleFunc("/discovery/preferences", discoveryHandlers.DeletePreferences).Methods("DELETE")
+
 	router.HandleFunc("/swipes", swipeHistoryHandlers.SaveSwipe).Methods("POST")
 	router.HandleFunc("/swipes/matches", swipeHistoryHandlers.GetMatches).Methods("GET")
 	router.HandleFunc("/swipes/redo", swipeHistoryHandlers.RedoSwipe).Methods("POST")
+	router.HandleFunc("/messages", messageHandlers.SendMessage).Methods("POST")
+	router.HandleFunc("/likes/received", likesHandlers.GetLikesReceived).Methods("GET")
+	router.HandleFunc("/likes/previews/{id:[0-9]+}", likesHandlers.GetLikePreview).Methods("GET")
 
 	return router
 }
+
+// loadInterestTaxonomy replaces the built-in interest list used by profile validation with the curated table
+func loadInterestTaxonomy(interestRepo repository.InterestRepository) {
+	interests, err := interestRepo.GetActiveInterests()
+	if err != nil || len(interests) == 0 {
+		log.Printf("Using built-in interest taxonomy: %v", err)
+		return
+	}
+
+	slugs := make([]string, 0, len(interests))
+	for _, interest := range interests {
+		slugs = append(slugs, interest.Slug)
+	}
+	helpers.RegisterEnum(helpers.InterestEnum, slugs...)
+}
+
+// newBlobStore returns an S3-compatible blob store when S3_ENDPOINT is set, otherwise a filesystem store
+func newBlobStore() helpers.BlobStore {
+	if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" {
+		return helpers.NewS3BlobStore(helpers.S3Config{
+			Endpoint:  endpoint,
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	}
+
+	root := os.Getenv("BLOB_STORE_ROOT")
+	if root == "" {
+		root = "/data/blobs"
+	}
+	return helpers.NewFileSystemBlobStore(root)
+}
+
+// newMessageBroker returns a RabbitMQ broker when RABBITMQ_URL is set, otherwise an in-memory one
+// delivering events within this instance
+func newMessageBroker(queues ...string) helpers.MessageBroker {
+	maxDeliveries, err := strconv.Atoi(os.Getenv("BROKER_MAX_DELIVERIES"))
+	if err != nil || maxDeliveries <= 0 {
+		maxDeliveries = 5
+	}
+
+	if url := os.Getenv("RABBITMQ_URL"); url != "" {
+		return helpers.NewRabbitMQBroker(helpers.RabbitMQConfig{
+			URL:           url,
+			Exchange:      os.Getenv("RABBITMQ_EXCHANGE"),
+			Queues:        queues,
+			MaxDeliveries: maxDeliveries,
+		})
+	}
+	return helpers.NewMemoryBroker(maxDeliveries, queues...)
+}
+
+// newPushSenders returns the FCM sender when FCM_CREDENTIALS_FILE names a service account key and
+// the APNs sender when APNS_PRIVATE_KEY_FILE names a .p8 key. Platforms without credentials get a
+// fake sender that only logs the pushes.
+func newPushSenders() []helpers.PushSender {
+	var android, ios helpers.PushSender
+
+	if path := os.Getenv("FCM_CREDENTIALS_FILE"); path != "" {
+		sender, err := newFCMPushSender(path)
+		if err != nil {
+			log.Printf("Error configuring FCM, Android pushes will only be logged: %v", err)
+		} else {
+			android = sender
+		}
+	}
+	if path := os.Getenv("APNS_PRIVATE_KEY_FILE"); path != "" {
+		sender, err := newAPNsPushSender(path)
+		if err != nil {
+			log.Printf("Error configuring APNs, iOS pushes will only be logged: %v", err)
+		} else {
+			ios = sender
+		}
+	}
+
+	if android == nil {
+		fake := helpers.NewFakePushSender(models.DevicePlatformAndroid)
+		fake.Log = true
+		android = fake
+	}
+	if ios == nil {
+		fake := helpers.NewFakePushSender(models.DevicePlatformIOS)
+		fake.Log = true
+		ios = fake
+	}
+	return []helpers.PushSender{android, ios}
+}
+
+func newFCMPushSender(credentialsFile string) (*helpers.FCMPushSender, error) {
+	serviceAccount, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	config, err := helpers.FCMConfigFromServiceAccount(serviceAccount)
+	if err != nil {
+		return nil, err
+	}
+	if projectID := os.Getenv("FCM_PROJECT_ID"); projectID != "" {
+		config.ProjectID = projectID
+	}
+	return helpers.NewFCMPushSender(config)
+}
+
+func newAPNsPushSender(privateKeyFile string) (*helpers.APNsPushSender, error) {
+	privateKey, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return helpers.NewAPNsPushSender(helpers.APNsConfig{
+		KeyID:      os.Getenv("APNS_KEY_ID"),
+		TeamID:     os.Getenv("APNS_TEAM_ID"),
+		PrivateKey: string(privateKey),
+		Topic:      os.Getenv("APNS_TOPIC"),
+		APIBase:    os.Getenv("APNS_API_BASE"),
+	})
+}
+
+// newPaymentProvider returns the Stripe provider; set STRIPE_API_BASE to use a fake server locally
+func newPaymentProvider() helpers.PaymentProvider {
+	return helpers.NewStripePaymentProvider(helpers.StripeConfig{
+		APIBase:       os.Getenv("STRIPE_API_BASE"),
+		SecretKey:     os.Getenv("STRIPE_SECRET_KEY"),
+		WebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+	})
+}
+
+func envOrDefault(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
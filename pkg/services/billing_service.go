@@ -0,0 +1,449 @@
+// services/billing_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrPlanNotFound is returned when checking out a plan that does not exist or is not for sale
+	ErrPlanNotFound = errors.New("plan not found")
+	// ErrAlreadySubscribed is returned when the user already has the plan or a higher one
+	ErrAlreadySubscribed = errors.New("already subscribed to this plan or a higher one")
+)
+
+// paymentEventClaimTimeout is how long an event may stay in processing before another delivery
+// of it may take over, e.g. after a crash
+const paymentEventClaimTimeout = 5 * time.Minute
+
+// BillingConfig holds where the provider sends the user back to after checkout, and how long a
+// subscription whose renewal failed keeps its plan while the provider retries the payment
+type BillingConfig struct {
+	SuccessURL  string
+	CancelURL   string
+	GracePeriod time.Duration
+}
+
+// BillingService sells subscriptions and packs of consumable items through the payment provider
+// and applies its webhook events
+type BillingService struct {
+	provider            helpers.PaymentProvider
+	subscriptionRepo    repository.SubscriptionRepository
+	paymentEventRepo    repository.PaymentEventRepository
+	userRepo            repository.UserRepository
+	entitlementsService *EntitlementsService
+	inventoryService    *InventoryService
+	config              BillingConfig
+	now                 func() time.Time
+}
+
+// NewBillingService creates a new instance of BillingService
+func NewBillingService(
+	provider helpers.PaymentProvider,
+	subscriptionRepo repository.SubscriptionRepository,
+	paymentEventRepo repository.PaymentEventRepository,
+	userRepo repository.UserRepository,
+	entitlementsService *EntitlementsService,
+	inventoryService *InventoryService,
+	config BillingConfig,
+) *BillingService {
+	return &BillingService{
+		provider:            provider,
+		subscriptionRepo:    subscriptionRepo,
+		paymentEventRepo:    paymentEventRepo,
+		userRepo:            userRepo,
+		entitlementsService: entitlementsService,
+		inventoryService:    inventoryService,
+		config:              config,
+		now:                 time.Now,
+	}
+}
+
+// SignatureHeader is the request header the provider signs webhooks in
+func (s *BillingService) SignatureHeader() string {
+	return s.provider.SignatureHeader()
+}
+
+// Checkout starts the purchase of a plan and returns the provider's payment page. The
+// subscription only starts once the provider reports the payment through a webhook.
+func (s *BillingService) Checkout(userID int, planCode string) (*helpers.CheckoutSession, error) {
+	plan, err := s.subscriptionRepo.GetPlanByCode(planCode)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !plan.IsActive) {
+		return nil, ErrPlanNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.subscriptionRepo.GetCurrentSubscription(userID, s.now())
+	if err == nil && current.Plan.Rank >= plan.Rank {
+		return nil, ErrAlreadySubscribed
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// An upgrade is charged to the same customer, who is credited for the rest of the old plan
+	// when it is cancelled
+	var customerID string
+	if current != nil && current.Provider == s.provider.Name() {
+		customerID = current.ProviderCustomerID
+	}
+
+	return s.provider.CreateCheckoutSession(helpers.CheckoutRequest{
+		UserID:        userID,
+		CustomerID:    customerID,
+		CustomerEmail: user.Email,
+		PlanCode:      plan.Code,
+		PlanName:      plan.Name,
+		PriceCents:    plan.PriceCents,
+		Currency:      plan.Currency,
+		PeriodMonths:  plan.PeriodMonths,
+		SuccessURL:    s.config.SuccessURL,
+		CancelURL:     s.config.CancelURL,
+	})
+}
+
+// CheckoutPack starts the purchase of a pack of consumable items and returns the provider's
+// payment page. The items are granted once the provider reports the payment through a webhook.
+func (s *BillingService) CheckoutPack(userID int, packCode string) (*helpers.CheckoutSession, error) {
+	pack, err := s.inventoryService.Pack(packCode)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.provider.CreateCheckoutSession(helpers.CheckoutRequest{
+		UserID:        userID,
+		CustomerEmail: user.Email,
+		PackCode:      pack.Code,
+		PlanName:      pack.Name,
+		PriceCents:    pack.PriceCents,
+		Currency:      pack.Currency,
+		SuccessURL:    s.config.SuccessURL,
+		CancelURL:     s.config.CancelURL,
+	})
+}
+
+// HandleWebhook verifies a webhook delivery, records it in the ledger and applies it to the
+// subscription or pack purchase it concerns. Each event is applied at most once however often it is delivered;
+// an error means the provider should deliver it again.
+func (s *BillingService) HandleWebhook(payload []byte, signature string) error {
+	event, err := s.provider.ParseWebhook(payload, signature)
+	if err != nil {
+		return err
+	}
+
+	provider := s.provider.Name()
+	entry := &models.PaymentEvent{
+		Provider:               provider,
+		ProviderEventID:        event.ID,
+		EventType:              event.Type,
+		ProviderEventType:      event.ProviderType,
+		ProviderSubscriptionID: event.ProviderSubscriptionID,
+		ProviderChargeID:       event.ProviderChargeID,
+		ProviderPaymentID:      event.ProviderPaymentID,
+		AmountCents:            event.AmountCents,
+		Currency:               event.Currency,
+		Payload:                string(event.Payload),
+		Status:                 models.PaymentEventStatusReceived,
+	}
+	if event.UserID > 0 {
+		entry.UserID = &event.UserID
+	}
+	if _, err := s.paymentEventRepo.RecordEvent(entry); err != nil {
+		return err
+	}
+
+	claimed, err := s.paymentEventRepo.ClaimEvent(provider, event.ID, s.now().Add(-paymentEventClaimTimeout))
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		// Already applied, or being applied by a concurrent delivery
+		return nil
+	}
+
+	outcome := &models.PaymentEvent{Status: models.PaymentEventStatusProcessed, UserID: entry.UserID}
+	var subscription *models.Subscription
+	purchase, applyErr := s.packPurchaseFor(event)
+	if applyErr == nil {
+		if purchase != nil {
+			applyErr = s.applyPackEvent(event, purchase)
+		} else {
+			subscription, applyErr = s.apply(event)
+		}
+	}
+	switch {
+	case applyErr != nil:
+		outcome.Status = models.PaymentEventStatusFailed
+		outcome.Error = applyErr.Error()
+	case purchase != nil:
+		outcome.UserID = &purchase.userID
+	case subscription == nil:
+		outcome.Status = models.PaymentEventStatusIgnored
+	default:
+		outcome.UserID = &subscription.UserID
+		outcome.SubscriptionID = &subscription.SubscriptionID
+		s.entitlementsService.Invalidate(subscription.UserID)
+	}
+
+	if err := s.paymentEventRepo.CompleteEvent(provider, event.ID, outcome); err != nil {
+		log.Printf("Error completing payment event %s: %v", event.ID, err)
+		if applyErr == nil {
+			return err
+		}
+	}
+	return applyErr
+}
+
+// apply moves the subscription the event concerns to its new state and returns it, or nil when
+// the event changes nothing
+func (s *BillingService) apply(event *helpers.PaymentEvent) (*models.Subscription, error) {
+	switch event.Type {
+	case helpers.PaymentEventCheckoutCompleted, helpers.PaymentEventInvoicePaid:
+		return s.applyPayment(event)
+	case helpers.PaymentEventPaymentFailed:
+		return s.applyPaymentFailure(event)
+	case helpers.PaymentEventSubscriptionCanceled:
+		return s.applyCancellation(event)
+	case helpers.PaymentEventSubscriptionEnded:
+		subscription, err := s.subscriptionFor(event.ProviderSubscriptionID)
+		if err != nil {
+			return nil, err
+		}
+		return subscription, s.revoke(subscription)
+	case helpers.PaymentEventRefunded, helpers.PaymentEventChargeback:
+		if event.Type == helpers.PaymentEventRefunded && !event.FullRefund {
+			// Partial refunds are goodwill credits and leave the subscription running
+			return nil, nil
+		}
+		providerSubscriptionID, err := s.paymentEventRepo.GetSubscriptionIDForCharge(s.provider.Name(), event.ProviderChargeID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no paid invoice found for charge %s", event.ProviderChargeID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		subscription, err := s.subscriptionFor(providerSubscriptionID)
+		if err != nil {
+			return nil, err
+		}
+		return subscription, s.revoke(subscription)
+	default:
+		return nil, nil
+	}
+}
+
+// packPurchase is a purchase of a pack of consumable items, identified by its one-off payment
+type packPurchase struct {
+	userID    int
+	packCode  string
+	paymentID string
+}
+
+// packPurchaseFor returns the pack purchase the event concerns, or nil when it concerns a
+// subscription
+func (s *BillingService) packPurchaseFor(event *helpers.PaymentEvent) (*packPurchase, error) {
+	switch event.Type {
+	case helpers.PaymentEventPackPurchased:
+		if event.UserID <= 0 {
+			return nil, fmt.Errorf("payment %s for pack %q does not name a user", event.ProviderPaymentID, event.PackCode)
+		}
+		return &packPurchase{userID: event.UserID, packCode: event.PackCode, paymentID: event.ProviderPaymentID}, nil
+	case helpers.PaymentEventRefunded, helpers.PaymentEventChargeback:
+		if event.ProviderPaymentID == "" {
+			return nil, nil
+		}
+		purchased, err := s.paymentEventRepo.GetPackPurchase(s.provider.Name(), event.ProviderPaymentID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if purchased.UserID == nil {
+			return nil, fmt.Errorf("purchase with payment %s has no user", event.ProviderPaymentID)
+		}
+		return &packPurchase{userID: *purchased.UserID, paymentID: event.ProviderPaymentID}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// applyPackEvent grants the items of a paid pack, or takes back what is left of them after a full
+// refund or a chargeback. Items already used are not clawed back.
+func (s *BillingService) applyPackEvent(event *helpers.PaymentEvent, purchase *packPurchase) error {
+	source := fmt.Sprintf("purchase:%s:%s", s.provider.Name(), purchase.paymentID)
+	switch event.Type {
+	case helpers.PaymentEventPackPurchased:
+		return s.inventoryService.GrantPurchase(purchase.userID, purchase.packCode, source)
+	case helpers.PaymentEventRefunded:
+		if !event.FullRefund {
+			return nil
+		}
+	}
+	return s.inventoryService.RevokePurchase(purchase.userID, source)
+}
+
+// applyPayment starts the subscription on its first payment and extends it on renewals. The
+// checkout and invoice events of a purchase may arrive in either order.
+func (s *BillingService) applyPayment(event *helpers.PaymentEvent) (*models.Subscription, error) {
+	now := s.now()
+	subscription, err := s.subscriptionRepo.GetSubscriptionByProviderID(s.provider.Name(), event.ProviderSubscriptionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if event.UserID <= 0 {
+			return nil, fmt.Errorf("payment for subscription %s does not name a user", event.ProviderSubscriptionID)
+		}
+		plan, err := s.subscriptionRepo.GetPlanByCode(event.PlanCode)
+		if err != nil {
+			return nil, fmt.Errorf("payment for unknown plan %q: %w", event.PlanCode, err)
+		}
+
+		subscription = &models.Subscription{
+			UserID:                 event.UserID,
+			PlanID:                 plan.PlanID,
+			Status:                 models.SubscriptionStatusActive,
+			CurrentPeriodStart:     now,
+			CurrentPeriodEnd:       now.AddDate(0, plan.PeriodMonths, 0),
+			Provider:               s.provider.Name(),
+			ProviderSubscriptionID: event.ProviderSubscriptionID,
+			ProviderCustomerID:     event.ProviderCustomerID,
+			Plan:                   *plan,
+		}
+		if !event.PeriodEnd.IsZero() {
+			subscription.CurrentPeriodStart = event.PeriodStart
+			subscription.CurrentPeriodEnd = event.PeriodEnd
+		}
+		if err := s.cancelReplaced(subscription); err != nil {
+			return nil, err
+		}
+		return subscription, s.subscriptionRepo.ActivateSubscription(subscription)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A revoked subscription is not brought back by a late payment event. A renewal the provider
+	// still charged for it, e.g. for a plan replaced by an upgrade, is stopped and left for a refund.
+	if subscription.Status == models.SubscriptionStatusExpired {
+		if event.Type == helpers.PaymentEventInvoicePaid {
+			log.Printf("Payment %s received for ended subscription %s of user %d; cancelling it", event.ProviderChargeID, subscription.ProviderSubscriptionID, subscription.UserID)
+			return nil, s.provider.CancelSubscription(subscription.ProviderSubscriptionID)
+		}
+		return nil, nil
+	}
+
+	if event.PeriodEnd.After(subscription.CurrentPeriodEnd) {
+		subscription.CurrentPeriodStart = event.PeriodStart
+		subscription.CurrentPeriodEnd = event.PeriodEnd
+	}
+	if subscription.ProviderCustomerID == "" {
+		subscription.ProviderCustomerID = event.ProviderCustomerID
+	}
+	if subscription.Status != models.SubscriptionStatusCanceled {
+		subscription.Status = models.SubscriptionStatusActive
+		subscription.GraceUntil = nil
+	}
+	return subscription, s.subscriptionRepo.SaveSubscription(subscription)
+}
+
+// cancelReplaced cancels the provider subscription of the plan the new subscription replaces, so
+// an upgraded user is not billed for both. It runs before the new subscription is activated so a
+// failed cancellation is retried with the redelivered event.
+func (s *BillingService) cancelReplaced(subscription *models.Subscription) error {
+	current, err := s.subscriptionRepo.GetCurrentSubscription(subscription.UserID, s.now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.Provider != s.provider.Name() || current.ProviderSubscriptionID == "" || current.ProviderSubscriptionID == subscription.ProviderSubscriptionID {
+		return nil
+	}
+	if err := s.provider.CancelSubscription(current.ProviderSubscriptionID); err != nil {
+		return fmt.Errorf("cancelling replaced subscription %s: %w", current.ProviderSubscriptionID, err)
+	}
+	return nil
+}
+
+// applyPaymentFailure makes a subscription whose renewal was declined past due. It keeps its plan
+// for the grace period after the period end while the provider retries; retries that fail again
+// do not extend the grace period.
+func (s *BillingService) applyPaymentFailure(event *helpers.PaymentEvent) (*models.Subscription, error) {
+	subscription, err := s.subscriptionFor(event.ProviderSubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Status != models.SubscriptionStatusActive && subscription.Status != models.SubscriptionStatusPastDue {
+		return nil, nil
+	}
+	// The failure was for a period that has been paid since
+	if !event.PeriodEnd.IsZero() && !event.PeriodEnd.After(subscription.CurrentPeriodEnd) {
+		return nil, nil
+	}
+
+	subscription.Status = models.SubscriptionStatusPastDue
+	if subscription.GraceUntil == nil {
+		graceUntil := subscription.CurrentPeriodEnd.Add(s.config.GracePeriod)
+		subscription.GraceUntil = &graceUntil
+	}
+	return subscription, s.subscriptionRepo.SaveSubscription(subscription)
+}
+
+// applyCancellation keeps the subscription until the end of the paid period, then lets it lapse
+func (s *BillingService) applyCancellation(event *helpers.PaymentEvent) (*models.Subscription, error) {
+	subscription, err := s.subscriptionFor(event.ProviderSubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Status == models.SubscriptionStatusExpired {
+		return nil, nil
+	}
+
+	now := s.now()
+	subscription.Status = models.SubscriptionStatusCanceled
+	subscription.CanceledAt = &now
+	if !event.PeriodEnd.IsZero() {
+		subscription.CurrentPeriodEnd = event.PeriodEnd
+	}
+	return subscription, s.subscriptionRepo.SaveSubscription(subscription)
+}
+
+// revoke ends the subscription now
+func (s *BillingService) revoke(subscription *models.Subscription) error {
+	now := s.now()
+	subscription.Status = models.SubscriptionStatusExpired
+	if subscription.CurrentPeriodEnd.After(now) {
+		subscription.CurrentPeriodEnd = now
+	}
+	return s.subscriptionRepo.SaveSubscription(subscription)
+}
+
+// subscriptionFor returns the subscription with the provider's ID. Events for a subscription not
+// seen yet fail, so the provider redelivers them after the purchase events.
+func (s *BillingService) subscriptionFor(providerSubscriptionID string) (*models.Subscription, error) {
+	subscription, err := s.subscriptionRepo.GetSubscriptionByProviderID(s.provider.Name(), providerSubscriptionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("unknown subscription %s", providerSubscriptionID)
+	}
+	return subscription, err
+}
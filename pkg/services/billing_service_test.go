@@ -0,0 +1,696 @@
+// services/billing_service_test.go
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// memorySubscriptionRepo keeps plans and subscriptions in memory
+type memorySubscriptionRepo struct {
+	mu            sync.Mutex
+	plans         []models.Plan
+	subscriptions []*models.Subscription
+}
+
+func (r *memorySubscriptionRepo) GetActivePlans() ([]models.Plan, error) {
+	return r.plans, nil
+}
+
+func (r *memorySubscriptionRepo) GetPlanByCode(code string) (*models.Plan, error) {
+	for i := range r.plans {
+		if r.plans[i].Code == code {
+			plan := r.plans[i]
+			return &plan, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memorySubscriptionRepo) GetCurrentSubscription(userID int, now time.Time) (*models.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, subscription := range r.subscriptions {
+		if subscription.UserID == userID && subscription.IsActive(now) {
+			return r.withPlan(subscription), nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memorySubscriptionRepo) GetSubscriptionByProviderID(provider, providerSubscriptionID string) (*models.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, subscription := range r.subscriptions {
+		if subscription.Provider == provider && subscription.ProviderSubscriptionID == providerSubscriptionID {
+			return r.withPlan(subscription), nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memorySubscriptionRepo) SaveSubscription(subscription *models.Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.save(subscription)
+	return nil
+}
+
+func (r *memorySubscriptionRepo) ActivateSubscription(subscription *models.Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.subscriptions {
+		if other.UserID == subscription.UserID && other.SubscriptionID != subscription.SubscriptionID {
+			other.Status = models.SubscriptionStatusExpired
+		}
+	}
+	r.save(subscription)
+	return nil
+}
+
+func (r *memorySubscriptionRepo) GetSubscriptionsDueForReminder(now, remindBefore time.Time, limit int) ([]models.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []models.Subscription
+	for _, subscription := range r.subscriptions {
+		endsAt := subscription.AccessEndsAt()
+		if subscription.Status == models.SubscriptionStatusExpired || subscription.Renews() ||
+			!endsAt.After(now) || endsAt.After(remindBefore) ||
+			(subscription.ReminderSentFor != nil && subscription.ReminderSentFor.Equal(endsAt)) {
+			continue
+		}
+		if len(due) == limit {
+			break
+		}
+		due = append(due, *r.withPlan(subscription))
+	}
+	return due, nil
+}
+
+func (r *memorySubscriptionRepo) MarkReminderSent(subscriptionID int, accessEndsAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	subscription := r.subscriptions[subscriptionID-1]
+	if subscription.ReminderSentFor != nil && subscription.ReminderSentFor.Equal(accessEndsAt) {
+		return false, nil
+	}
+	subscription.ReminderSentFor = &accessEndsAt
+	return true, nil
+}
+
+func (r *memorySubscriptionRepo) StartGracePeriods(now time.Time, grace time.Duration, batchSize int) ([]models.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var changed []models.Subscription
+	for _, subscription := range r.subscriptions {
+		if len(changed) == batchSize || !subscription.Renews() || subscription.CurrentPeriodEnd.After(now) {
+			continue
+		}
+		graceUntil := subscription.CurrentPeriodEnd.Add(grace)
+		subscription.Status = models.SubscriptionStatusPastDue
+		subscription.GraceUntil = &graceUntil
+		changed = append(changed, *subscription)
+	}
+	return changed, nil
+}
+
+func (r *memorySubscriptionRepo) ExpireLapsedSubscriptions(now time.Time, batchSize int) ([]models.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var changed []models.Subscription
+	for _, subscription := range r.subscriptions {
+		if len(changed) == batchSize || subscription.Status == models.SubscriptionStatusExpired ||
+			subscription.Renews() || subscription.AccessEndsAt().After(now) {
+			continue
+		}
+		subscription.Status = models.SubscriptionStatusExpired
+		changed = append(changed, *subscription)
+	}
+	return changed, nil
+}
+
+func (r *memorySubscriptionRepo) save(subscription *models.Subscription) {
+	if subscription.SubscriptionID == 0 {
+		subscription.SubscriptionID = len(r.subscriptions) + 1
+		stored := *subscription
+		r.subscriptions = append(r.subscriptions, &stored)
+		return
+	}
+	stored := *subscription
+	r.subscriptions[subscription.SubscriptionID-1] = &stored
+}
+
+func (r *memorySubscriptionRepo) withPlan(subscription *models.Subscription) *models.Subscription {
+	copied := *subscription
+	for _, plan := range r.plans {
+		if plan.PlanID == copied.PlanID {
+			copied.Plan = plan
+		}
+	}
+	return &copied
+}
+
+// memoryPaymentEventRepo is an in-memory ledger with the same claiming rules as the database one
+type memoryPaymentEventRepo struct {
+	mu     sync.Mutex
+	events []*models.PaymentEvent
+}
+
+func (r *memoryPaymentEventRepo) find(provider, providerEventID string) *models.PaymentEvent {
+	for _, event := range r.events {
+		if event.Provider == provider && event.ProviderEventID == providerEventID {
+			return event
+		}
+	}
+	return nil
+}
+
+func (r *memoryPaymentEventRepo) RecordEvent(event *models.PaymentEvent) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.find(event.Provider, event.ProviderEventID) != nil {
+		return false, nil
+	}
+	stored := *event
+	stored.PaymentEventID = len(r.events) + 1
+	stored.UpdatedAt = time.Now()
+	r.events = append(r.events, &stored)
+	return true, nil
+}
+
+func (r *memoryPaymentEventRepo) ClaimEvent(provider, providerEventID string, staleBefore time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	event := r.find(provider, providerEventID)
+	if event == nil {
+		return false, nil
+	}
+	switch {
+	case event.Status == models.PaymentEventStatusReceived, event.Status == models.PaymentEventStatusFailed,
+		event.Status == models.PaymentEventStatusProcessing && event.UpdatedAt.Before(staleBefore):
+		event.Status = models.PaymentEventStatusProcessing
+		event.UpdatedAt = time.Now()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *memoryPaymentEventRepo) CompleteEvent(provider, providerEventID string, outcome *models.PaymentEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	event := r.find(provider, providerEventID)
+	event.Status = outcome.Status
+	event.Error = outcome.Error
+	event.UserID = outcome.UserID
+	event.SubscriptionID = outcome.SubscriptionID
+	return nil
+}
+
+func (r *memoryPaymentEventRepo) GetEvent(provider, providerEventID string) (*models.PaymentEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if event := r.find(provider, providerEventID); event != nil {
+		return event, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryPaymentEventRepo) GetSubscriptionIDForCharge(provider, chargeID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range r.events {
+		if event.Provider == provider && event.ProviderChargeID == chargeID && event.ProviderSubscriptionID != "" {
+			return event.ProviderSubscriptionID, nil
+		}
+	}
+	return "", gorm.ErrRecordNotFound
+}
+
+func (r *memoryPaymentEventRepo) GetPackPurchase(provider, paymentID string) (*models.PaymentEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range r.events {
+		if event.Provider == provider && event.ProviderPaymentID == paymentID && event.EventType == helpers.PaymentEventPackPurchased {
+			return event, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// memoryUserRepo only answers GetUserByID
+type memoryUserRepo struct {
+	repository.UserRepository
+}
+
+func (memoryUserRepo) GetUserByID(userID int) (*models.User, error) {
+	return &models.User{UserID: userID, Username: fmt.Sprintf("user%d", userID), Email: "user@example.com"}, nil
+}
+
+type billingFixture struct {
+	service       *BillingService
+	entitlements  *EntitlementsService
+	inventory     *InventoryService
+	subscriptions *memorySubscriptionRepo
+	ledger        *memoryPaymentEventRepo
+	stripe        *helpers.FakeStripeServer
+	invalidated   map[string]int
+}
+
+func newBillingFixture(t *testing.T) *billingFixture {
+	t.Helper()
+
+	fixture := &billingFixture{
+		subscriptions: &memorySubscriptionRepo{plans: []models.Plan{
+			{PlanID: 1, Code: "plus", Name: "Plus", Rank: 1, PriceCents: 999, Currency: "USD", PeriodMonths: 1, UnlimitedLikes: true, RewindsPerDay: 5, Passport: true, IsActive: true},
+			{PlanID: 2, Code: "gold", Name: "Gold", Rank: 2, PriceCents: 1999, Currency: "USD", PeriodMonths: 1, UnlimitedLikes: true, SeeWhoLikesYou: true, RewindsPerDay: models.UnlimitedQuota, Passport: true, BoostsPerMonth: 1, IsActive: true},
+		}},
+		ledger:      &memoryPaymentEventRepo{},
+		invalidated: make(map[string]int),
+	}
+
+	// Entitlements are never served from the cache, but invalidations are counted
+	redis := &mocks.MockRedisHandler{
+		GetFunc: func(key string, dest interface{}) error { return errors.New("cache miss") },
+		DeleteFunc: func(key string) error {
+			fixture.invalidated[key]++
+			return nil
+		},
+	}
+	fixture.entitlements = NewEntitlementsService(fixture.subscriptions, redis)
+	fixture.inventory = NewInventoryService(memoryUserRepo{}, &memoryInventoryRepo{packs: []models.ConsumablePack{
+		{PackID: 1, Code: "super_like_5", Name: "5 Super Likes", ItemType: models.ItemSuperLike, Quantity: 5, PriceCents: 499, Currency: "USD", IsActive: true},
+		{PackID: 2, Code: "boost_1", Name: "1 Boost", ItemType: models.ItemBoost, Quantity: 1, PriceCents: 399, Currency: "USD"},
+	}}, fixture.entitlements)
+
+	fixture.stripe = helpers.NewFakeStripeServer(testWebhookSecret, "")
+	server := httptest.NewServer(fixture.stripe)
+	t.Cleanup(server.Close)
+
+	provider := helpers.NewStripePaymentProvider(helpers.StripeConfig{
+		APIBase:       server.URL,
+		SecretKey:     "sk_test",
+		WebhookSecret: testWebhookSecret,
+	})
+	fixture.service = NewBillingService(provider, fixture.subscriptions, fixture.ledger, memoryUserRepo{}, fixture.entitlements, fixture.inventory, BillingConfig{
+		SuccessURL:  "https://example.com/success",
+		CancelURL:   "https://example.com/cancel",
+		GracePeriod: 72 * time.Hour,
+	})
+	fixture.stripe.Deliver = fixture.service.HandleWebhook
+	return fixture
+}
+
+// subscribe checks out the plan for the user and pays for it, returning the provider subscription ID
+func (f *billingFixture) subscribe(t *testing.T, userID int, plan string) string {
+	t.Helper()
+	session, err := f.service.Checkout(userID, plan)
+	if err != nil {
+		t.Fatalf("checkout failed: %v", err)
+	}
+	subscriptionID, err := f.stripe.CompleteCheckout(session.ID)
+	if err != nil {
+		t.Fatalf("completing checkout failed: %v", err)
+	}
+	return subscriptionID
+}
+
+func (f *billingFixture) entitlementsOf(t *testing.T, userID int) *models.Entitlements {
+	t.Helper()
+	entitlements, err := f.entitlements.Entitlements(userID)
+	if err != nil {
+		t.Fatalf("fetching entitlements failed: %v", err)
+	}
+	return entitlements
+}
+
+func TestBillingService_PurchaseAndRenewal(t *testing.T) {
+	f := newBillingFixture(t)
+
+	if got := f.entitlementsOf(t, 7).Plan; got != models.PlanFree {
+		t.Fatalf("expected a free user before checkout, got %q", got)
+	}
+
+	subscriptionID := f.subscribe(t, 7, "gold")
+
+	entitlements := f.entitlementsOf(t, 7)
+	if entitlements.Plan != "gold" || !entitlements.SeeWhoLikesYou || entitlements.DailyLikes != models.UnlimitedQuota {
+		t.Fatalf("expected gold entitlements after payment, got %+v", entitlements)
+	}
+	if f.invalidated["entitlements:7"] == 0 {
+		t.Error("expected the cached entitlements to be invalidated")
+	}
+	firstPeriodEnd := *entitlements.ExpiresAt
+
+	// The checkout and its invoice are both in the ledger
+	if len(f.ledger.events) != 2 {
+		t.Fatalf("expected 2 ledger entries, got %d", len(f.ledger.events))
+	}
+	for _, event := range f.ledger.events {
+		if event.Status != models.PaymentEventStatusProcessed || event.SubscriptionID == nil || event.Payload == "" {
+			t.Errorf("expected a processed ledger entry linked to the subscription, got %+v", event)
+		}
+	}
+
+	if err := f.stripe.RenewSubscription(subscriptionID); err != nil {
+		t.Fatalf("renewal failed: %v", err)
+	}
+	renewedPeriodEnd := *f.entitlementsOf(t, 7).ExpiresAt
+	if !renewedPeriodEnd.After(firstPeriodEnd) {
+		t.Fatalf("expected renewal to extend the period past %v, got %v", firstPeriodEnd, renewedPeriodEnd)
+	}
+
+	// Redelivering every event changes nothing
+	for _, delivery := range f.stripe.Deliveries() {
+		if err := f.service.HandleWebhook(delivery.Payload, delivery.Signature); err != nil {
+			t.Fatalf("redelivery of %s failed: %v", delivery.Type, err)
+		}
+	}
+	if got := *f.entitlementsOf(t, 7).ExpiresAt; !got.Equal(renewedPeriodEnd) {
+		t.Errorf("redelivery moved the period end from %v to %v", renewedPeriodEnd, got)
+	}
+	if len(f.ledger.events) != 3 || len(f.subscriptions.subscriptions) != 1 {
+		t.Errorf("expected 3 ledger entries and 1 subscription, got %d and %d", len(f.ledger.events), len(f.subscriptions.subscriptions))
+	}
+
+	// A user cannot buy the same or a lower plan again
+	if _, err := f.service.Checkout(7, "plus"); !errors.Is(err, ErrAlreadySubscribed) {
+		t.Errorf("expected ErrAlreadySubscribed, got %v", err)
+	}
+	if _, err := f.service.Checkout(7, "diamond"); !errors.Is(err, ErrPlanNotFound) {
+		t.Errorf("expected ErrPlanNotFound, got %v", err)
+	}
+}
+
+func TestBillingService_EventsOutOfOrder(t *testing.T) {
+	f := newBillingFixture(t)
+
+	// Hold back deliveries and send the invoice before the checkout
+	var held []helpers.FakeWebhookDelivery
+	f.stripe.Deliver = func(payload []byte, signature string) error {
+		held = append(held, helpers.FakeWebhookDelivery{Payload: payload, Signature: signature})
+		return nil
+	}
+	f.subscribe(t, 8, "plus")
+
+	for i := len(held) - 1; i >= 0; i-- {
+		if err := f.service.HandleWebhook(held[i].Payload, held[i].Signature); err != nil {
+			t.Fatalf("delivery failed: %v", err)
+		}
+	}
+
+	if got := f.entitlementsOf(t, 8).Plan; got != "plus" {
+		t.Errorf("expected plus after out-of-order delivery, got %q", got)
+	}
+	if len(f.subscriptions.subscriptions) != 1 {
+		t.Errorf("expected a single subscription, got %d", len(f.subscriptions.subscriptions))
+	}
+}
+
+func TestBillingService_FailedRenewalGracePeriod(t *testing.T) {
+	f := newBillingFixture(t)
+	subscriptionID := f.subscribe(t, 5, "gold")
+	periodEnd := *f.entitlementsOf(t, 5).ExpiresAt
+
+	// A declined renewal keeps the plan for the grace period, however often it is retried
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := f.stripe.FailRenewal(subscriptionID); err != nil {
+			t.Fatalf("failing the renewal failed: %v", err)
+		}
+	}
+	subscription, _ := f.subscriptions.GetSubscriptionByProviderID("stripe", subscriptionID)
+	if subscription.Status != models.SubscriptionStatusPastDue || subscription.GraceUntil == nil || !subscription.GraceUntil.Equal(periodEnd.Add(72*time.Hour)) {
+		t.Fatalf("expected a past due subscription with 72h of grace after %v, got %+v", periodEnd, subscription)
+	}
+	if entitlements := f.entitlementsOf(t, 5); entitlements.Plan != "gold" || !entitlements.ExpiresAt.Equal(*subscription.GraceUntil) {
+		t.Errorf("expected gold until the grace period ends, got %+v", entitlements)
+	}
+
+	// A retry that succeeds ends the grace period and starts the next period
+	if err := f.stripe.RenewSubscription(subscriptionID); err != nil {
+		t.Fatalf("renewal failed: %v", err)
+	}
+	subscription, _ = f.subscriptions.GetSubscriptionByProviderID("stripe", subscriptionID)
+	if subscription.Status != models.SubscriptionStatusActive || subscription.GraceUntil != nil || !subscription.CurrentPeriodEnd.After(periodEnd) {
+		t.Errorf("expected an active subscription in its next period, got %+v", subscription)
+	}
+
+	// Redelivering the failures after the successful retry changes nothing
+	for _, delivery := range f.stripe.Deliveries() {
+		if delivery.Type == "invoice.payment_failed" {
+			f.service.HandleWebhook(delivery.Payload, helpers.SignStripePayload(delivery.Payload, testWebhookSecret, time.Now()))
+		}
+	}
+	if subscription, _ = f.subscriptions.GetSubscriptionByProviderID("stripe", subscriptionID); subscription.Status != models.SubscriptionStatusActive {
+		t.Errorf("expected the subscription to stay active, got %q", subscription.Status)
+	}
+}
+
+func TestBillingService_CancellationRefundAndChargeback(t *testing.T) {
+	f := newBillingFixture(t)
+
+	// Cancelling keeps the plan until the end of the paid period
+	canceled := f.subscribe(t, 1, "gold")
+	if err := f.stripe.CancelSubscription(canceled); err != nil {
+		t.Fatalf("cancellation failed: %v", err)
+	}
+	if got := f.entitlementsOf(t, 1).Plan; got != "gold" {
+		t.Errorf("expected gold until the period ends, got %q", got)
+	}
+	if subscription, _ := f.subscriptions.GetSubscriptionByProviderID("stripe", canceled); subscription.Status != models.SubscriptionStatusCanceled || subscription.CanceledAt == nil {
+		t.Errorf("expected a canceled subscription, got %+v", subscription)
+	}
+
+	// Refunds and chargebacks revoke the plan immediately
+	refunded := f.subscribe(t, 2, "gold")
+	if err := f.stripe.RefundLastCharge(refunded); err != nil {
+		t.Fatalf("refund failed: %v", err)
+	}
+	if got := f.entitlementsOf(t, 2).Plan; got != models.PlanFree {
+		t.Errorf("expected a free user after a refund, got %q", got)
+	}
+
+	disputed := f.subscribe(t, 3, "plus")
+	if err := f.stripe.DisputeLastCharge(disputed); err != nil {
+		t.Fatalf("dispute failed: %v", err)
+	}
+	if got := f.entitlementsOf(t, 3).Plan; got != models.PlanFree {
+		t.Errorf("expected a free user after a chargeback, got %q", got)
+	}
+
+	// A renewal arriving after the refund does not bring the plan back
+	if err := f.stripe.RenewSubscription(refunded); err != nil {
+		t.Fatalf("renewal failed: %v", err)
+	}
+	if got := f.entitlementsOf(t, 2).Plan; got != models.PlanFree {
+		t.Errorf("expected the refunded subscription to stay revoked, got %q", got)
+	}
+}
+
+func TestBillingService_UpgradeCancelsTheReplacedSubscription(t *testing.T) {
+	f := newBillingFixture(t)
+	plus := f.subscribe(t, 9, "plus")
+
+	// Hold back a renewal of the old plan that the provider charged just before the upgrade
+	f.stripe.Deliver = func(payload []byte, signature string) error { return nil }
+	if err := f.stripe.RenewSubscription(plus); err != nil {
+		t.Fatalf("renewal failed: %v", err)
+	}
+	deliveries := f.stripe.Deliveries()
+	lateRenewal := deliveries[len(deliveries)-1]
+	f.stripe.Deliver = f.service.HandleWebhook
+
+	gold := f.subscribe(t, 9, "gold")
+	if got := f.entitlementsOf(t, 9).Plan; got != "gold" {
+		t.Fatalf("expected gold after the upgrade, got %q", got)
+	}
+
+	// The old plan was cancelled at the provider and is not billed again
+	old, _ := f.subscriptions.GetSubscriptionByProviderID("stripe", plus)
+	if old.Status != models.SubscriptionStatusExpired {
+		t.Errorf("expected the replaced subscription to be expired, got %+v", old)
+	}
+	if err := f.stripe.RenewSubscription(plus); err == nil {
+		t.Error("expected the replaced subscription to be cancelled at the provider")
+	}
+
+	// The new plan is charged to the same customer, who keeps the credit for the old one
+	upgraded, _ := f.subscriptions.GetSubscriptionByProviderID("stripe", gold)
+	if upgraded.ProviderCustomerID == "" || upgraded.ProviderCustomerID != old.ProviderCustomerID {
+		t.Errorf("expected the upgrade to reuse customer %q, got %q", old.ProviderCustomerID, upgraded.ProviderCustomerID)
+	}
+
+	// A late invoice of the old plan does not replace the new one
+	if err := f.service.HandleWebhook(lateRenewal.Payload, lateRenewal.Signature); err != nil {
+		t.Fatalf("late renewal failed: %v", err)
+	}
+	if got := f.entitlementsOf(t, 9).Plan; got != "gold" {
+		t.Errorf("expected gold to stay after a late renewal of plus, got %q", got)
+	}
+	event, _ := f.ledger.GetEvent("stripe", eventID(t, lateRenewal.Payload))
+	if event.Status != models.PaymentEventStatusIgnored {
+		t.Errorf("expected the late renewal to be ignored, got %+v", event)
+	}
+
+	// Redelivering every event changes nothing
+	for _, delivery := range f.stripe.Deliveries() {
+		if err := f.service.HandleWebhook(delivery.Payload, delivery.Signature); err != nil {
+			t.Fatalf("redelivery of %s failed: %v", delivery.Type, err)
+		}
+	}
+	if current, err := f.subscriptions.GetCurrentSubscription(9, time.Now()); err != nil || current.ProviderSubscriptionID != gold {
+		t.Errorf("expected gold to be the current subscription, got %+v (%v)", current, err)
+	}
+}
+
+func TestBillingService_UpgradeTopsUpAllowancesInsteadOfGrantingThemAgain(t *testing.T) {
+	f := newBillingFixture(t)
+	f.subscriptions.plans = append(f.subscriptions.plans, models.Plan{
+		PlanID: 3, Code: "platinum", Name: "Platinum", Rank: 3, PriceCents: 2999, Currency: "USD", PeriodMonths: 1,
+		UnlimitedLikes: true, SeeWhoLikesYou: true, RewindsPerDay: models.UnlimitedQuota, Passport: true, BoostsPerMonth: 2, IsActive: true,
+	})
+	wallet := func() map[string]int {
+		t.Helper()
+		wallet, err := f.inventory.Wallet(9)
+		if err != nil {
+			t.Fatalf("wallet failed: %v", err)
+		}
+		return wallet.Balances
+	}
+
+	// The free rewind used today counts against the plan's five
+	if _, err := f.inventory.Consume(9, models.ItemRewind); err != nil {
+		t.Fatalf("rewind failed: %v", err)
+	}
+	f.subscribe(t, 9, "plus")
+	if got := wallet()[models.ItemRewind]; got != 4 {
+		t.Fatalf("expected 4 rewinds left after upgrading to plus, got %d", got)
+	}
+
+	// The boost used this month counts against the platinum plan's two
+	f.subscribe(t, 9, "gold")
+	if _, err := f.inventory.StartBoost(9); err != nil {
+		t.Fatalf("boost failed: %v", err)
+	}
+	f.subscribe(t, 9, "platinum")
+	if got := wallet()[models.ItemBoost]; got != 1 {
+		t.Fatalf("expected 1 boost left after upgrading to platinum, got %d", got)
+	}
+
+	// Each period's allowance is a single lot
+	lots := map[string]int{}
+	for _, lot := range f.inventory.inventoryRepo.(*memoryInventoryRepo).lots {
+		lots[lot.ItemType+"/"+lot.Source]++
+	}
+	for lot, count := range lots {
+		if count != 1 {
+			t.Errorf("expected one %s lot, got %d", lot, count)
+		}
+	}
+}
+
+// eventID returns the ID of a webhook event
+func eventID(t *testing.T, payload []byte) string {
+	t.Helper()
+	var event struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		t.Fatalf("invalid webhook payload: %v", err)
+	}
+	return event.ID
+}
+
+func TestBillingService_PackPurchaseAndRefund(t *testing.T) {
+	f := newBillingFixture(t)
+
+	if _, err := f.service.CheckoutPack(6, "boost_1"); !errors.Is(err, ErrPackNotFound) {
+		t.Fatalf("expected packs withdrawn from sale to be refused, got %v", err)
+	}
+
+	superLikes := func() int {
+		t.Helper()
+		wallet, err := f.inventory.Wallet(6)
+		if err != nil {
+			t.Fatalf("fetching wallet failed: %v", err)
+		}
+		return wallet.Balances[models.ItemSuperLike]
+	}
+
+	session, err := f.service.CheckoutPack(6, "super_like_5")
+	if err != nil {
+		t.Fatalf("checkout failed: %v", err)
+	}
+	if got := superLikes(); got != models.FreeSuperLikesPerWeek {
+		t.Fatalf("expected no items before payment, got %d super likes", got)
+	}
+	paymentID, err := f.stripe.CompleteCheckout(session.ID)
+	if err != nil {
+		t.Fatalf("completing checkout failed: %v", err)
+	}
+	if got := superLikes(); got != models.FreeSuperLikesPerWeek+5 {
+		t.Fatalf("expected the pack to be granted, got %d super likes", got)
+	}
+
+	// Redelivering the purchase does not grant it twice
+	purchase := f.stripe.Deliveries()[0]
+	if err := f.service.HandleWebhook(purchase.Payload, purchase.Signature); err != nil {
+		t.Fatalf("redelivery failed: %v", err)
+	}
+	if got := superLikes(); got != models.FreeSuperLikesPerWeek+5 {
+		t.Fatalf("expected a redelivered purchase to be ignored, got %d super likes", got)
+	}
+	if entry, _ := f.ledger.GetPackPurchase("stripe", paymentID); entry == nil || entry.Status != models.PaymentEventStatusProcessed || entry.UserID == nil || *entry.UserID != 6 {
+		t.Fatalf("expected the purchase to be processed in the ledger, got %+v", entry)
+	}
+
+	// A refund takes back what is left of the pack
+	if _, err := f.inventory.Consume(6, models.ItemSuperLike); err != nil {
+		t.Fatalf("super like failed: %v", err)
+	}
+	if err := f.stripe.RefundPayment(paymentID); err != nil {
+		t.Fatalf("refund failed: %v", err)
+	}
+	if got := superLikes(); got != 0 {
+		t.Fatalf("expected no super likes after the refund, got %d", got)
+	}
+}
+
+func TestBillingService_RejectsUnsignedWebhooks(t *testing.T) {
+	f := newBillingFixture(t)
+	f.subscribe(t, 4, "plus")
+	delivery := f.stripe.Deliveries()[0]
+
+	tampered := append([]byte(nil), delivery.Payload...)
+	tampered[len(tampered)-2] = ' '
+
+	cases := map[string]struct {
+		payload   []byte
+		signature string
+	}{
+		"missing signature": {delivery.Payload, ""},
+		"wrong secret":      {delivery.Payload, helpers.SignStripePayload(delivery.Payload, "whsec_other", time.Now())},
+		"tampered payload":  {tampered, delivery.Signature},
+		"replayed later":    {delivery.Payload, helpers.SignStripePayload(delivery.Payload, testWebhookSecret, time.Now().Add(-time.Hour))},
+	}
+	for name, c := range cases {
+		if err := f.service.HandleWebhook(c.payload, c.signature); !errors.Is(err, helpers.ErrInvalidWebhookSignature) {
+			t.Errorf("%s: expected ErrInvalidWebhookSignature, got %v", name, err)
+		}
+	}
+	if len(f.ledger.events) != 2 {
+		t.Errorf("expected rejected webhooks to stay out of the ledger, got %d entries", len(f.ledger.events))
+	}
+}
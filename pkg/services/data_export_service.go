@@ -0,0 +1,340 @@
+// services/data_export_service.go
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// Environment variables overriding DefaultDataExportPolicy
+const (
+	DataExportIntervalEnv    = "DATA_EXPORT_INTERVAL"
+	DataExportLeaseEnv       = "DATA_EXPORT_LEASE"
+	DataExportMaxAttemptsEnv = "DATA_EXPORT_MAX_ATTEMPTS"
+)
+
+const (
+	// DataExportRetention is how long a finished archive is kept before it can no longer be downloaded
+	DataExportRetention = 7 * 24 * time.Hour
+
+	// NotificationTypeDataExportReady is sent to the user when their archive can be downloaded
+	NotificationTypeDataExportReady = "Data Export Ready"
+)
+
+// DataExportPolicy decides how often the worker looks for exports to build and how often it tries
+// an export before giving up. A claimed export is leased to its instance for Lease, renewed while
+// the archive is built, so an export whose instance stopped is taken over once the lease runs out.
+type DataExportPolicy struct {
+	Interval    time.Duration
+	Lease       time.Duration
+	MaxAttempts int
+}
+
+// DefaultDataExportPolicy returns the policy used when none is configured
+func DefaultDataExportPolicy() DataExportPolicy {
+	return DataExportPolicy{
+		Interval:    10 * time.Second,
+		Lease:       5 * time.Minute,
+		MaxAttempts: 3,
+	}
+}
+
+// DataExportPolicyFromEnv reads the policy from the environment, keeping the default for any value
+// that is unset or invalid
+func DataExportPolicyFromEnv() DataExportPolicy {
+	policy := DefaultDataExportPolicy()
+	policy.Interval = positiveDurationEnv(DataExportIntervalEnv, policy.Interval)
+	policy.Lease = positiveDurationEnv(DataExportLeaseEnv, policy.Lease)
+	policy.MaxAttempts = positiveIntEnv(DataExportMaxAttemptsEnv, policy.MaxAttempts)
+	return policy
+}
+
+// DataExportReport counts the exports handled by one worker run
+type DataExportReport struct {
+	Ready   int
+	Retried int
+	Failed  int
+}
+
+// DataExportService records export requests and builds the personal data archives in the
+// background. Every instance runs the worker: claiming skips the exports another instance builds.
+type DataExportService struct {
+	exportRepo       repository.DataExportRepository
+	notificationRepo repository.NotificationRepository
+	blobStore        helpers.BlobStore
+	policy           DataExportPolicy
+	wake             chan struct{}
+	now              func() time.Time
+}
+
+// NewDataExportService creates a new instance of DataExportService
+func NewDataExportService(exportRepo repository.DataExportRepository, notificationRepo repository.NotificationRepository, blobStore helpers.BlobStore, policy DataExportPolicy) *DataExportService {
+	return &DataExportService{
+		exportRepo:       exportRepo,
+		notificationRepo: notificationRepo,
+		blobStore:        blobStore,
+		policy:           policy,
+		wake:             make(chan struct{}, 1),
+		now:              time.Now,
+	}
+}
+
+// RequestExport records a new export request for the worker to build
+func (s *DataExportService) RequestExport(userID int) (*models.DataExport, error) {
+	now := s.now()
+	export := &models.DataExport{
+		UserID:      userID,
+		Status:      models.DataExportStatusPending,
+		RequestedAt: now,
+		LeasedUntil: now,
+	}
+
+	if err := s.exportRepo.CreateDataExport(export); err != nil {
+		return nil, err
+	}
+
+	// Start on it at once if this instance's worker is idle
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return export, nil
+}
+
+// OpenArchive returns a reader for a finished export archive
+func (s *DataExportService) OpenArchive(export *models.DataExport) (io.ReadCloser, error) {
+	if export.Status != models.DataExportStatusReady {
+		return nil, fmt.Errorf("export is not ready")
+	}
+	if s.now().After(export.ExpiresAt) {
+		return nil, fmt.Errorf("export has expired")
+	}
+
+	return s.blobStore.Get(export.ArchiveKey)
+}
+
+// Start builds the exports due on every interval, or as soon as one is requested, until the
+// context is cancelled. Exports left behind by a stopped instance are picked up on the first run.
+func (s *DataExportService) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := s.RunOnce(ctx)
+		if err != nil {
+			log.Printf("Data export worker failed: %v", err)
+		} else if report.Retried+report.Failed > 0 {
+			log.Printf("Data export worker built %d exports, will retry %d and gave up on %d", report.Ready, report.Retried, report.Failed)
+		}
+
+		// Carry on while there is work, one export at a time
+		if err == nil && report.Ready+report.Retried+report.Failed > 0 {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// RunOnce claims the oldest export due and builds it. An export claimed more than MaxAttempts
+// times, because the instances building it kept stopping, is failed without another try.
+func (s *DataExportService) RunOnce(ctx context.Context) (DataExportReport, error) {
+	var report DataExportReport
+	exports, err := s.exportRepo.ClaimDataExports(s.now(), s.policy.Lease, 1)
+	if err != nil {
+		return report, err
+	}
+
+	for i := range exports {
+		export := &exports[i]
+		if export.Attempts > s.policy.MaxAttempts {
+			log.Printf("Giving up on data export %d after %d attempts", export.ExportID, export.Attempts-1)
+			export.Status = models.DataExportStatusFailed
+			export.ErrorMessage = fmt.Sprintf("gave up after %d attempts", export.Attempts-1)
+			err = s.exportRepo.UpdateDataExport(export)
+		} else {
+			err = s.runExport(export)
+		}
+		if err != nil {
+			return report, err
+		}
+
+		switch export.Status {
+		case models.DataExportStatusReady:
+			report.Ready++
+		case models.DataExportStatusPending:
+			report.Retried++
+		default:
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+// runExport builds the archive of a claimed export and records the outcome: ready, pending for
+// another attempt, or failed once out of attempts
+func (s *DataExportService) runExport(export *models.DataExport) error {
+	archiveKey := fmt.Sprintf("exports/%d/%d.zip", export.UserID, export.ExportID)
+	stopRenewing := s.renewLease(export.ExportID)
+	err := s.buildArchive(export.UserID, archiveKey)
+	stopRenewing()
+
+	now := s.now()
+	if err != nil {
+		log.Printf("Error building data export %d: %v", export.ExportID, err)
+		export.ErrorMessage = err.Error()
+		export.Status = models.DataExportStatusFailed
+		if export.Attempts < s.policy.MaxAttempts {
+			export.Status = models.DataExportStatusPending
+			export.LeasedUntil = now
+		}
+		return s.exportRepo.UpdateDataExport(export)
+	}
+
+	export.Status = models.DataExportStatusReady
+	export.ArchiveKey = archiveKey
+	export.ErrorMessage = ""
+	export.CompletedAt = now
+	export.ExpiresAt = now.Add(DataExportRetention)
+	if err := s.exportRepo.UpdateDataExport(export); err != nil {
+		return err
+	}
+
+	// Let the user know their archive can be downloaded
+	notification := &models.Notification{
+		UserID:           export.UserID,
+		NotificationType: NotificationTypeDataExportReady,
+		Message:          fmt.Sprintf("Your data export is ready. It can be downloaded until %s.", export.ExpiresAt.Format(time.RFC1123)),
+		Timestamp:        now,
+	}
+	if err := s.notificationRepo.CreateNotification(notification); err != nil {
+		log.Printf("Error creating data export notification: %v", err)
+	}
+	return nil
+}
+
+// renewLease extends the export's lease every third of the lease until the returned function is
+// called, so no other instance takes over an archive still being built
+func (s *DataExportService) renewLease(exportID int) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.policy.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := s.exportRepo.RenewDataExportLease(exportID, s.now().Add(s.policy.Lease)); err != nil {
+					log.Printf("Error renewing the lease of data export %d: %v", exportID, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// buildArchive streams a ZIP with one JSON file per table plus the user's photos to the blob
+// store, so the archive is never held in memory as a whole
+func (s *DataExportService) buildArchive(userID int, archiveKey string) error {
+	data, err := s.exportRepo.GetUserData(userID)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		writer.CloseWithError(s.writeArchive(writer, data))
+	}()
+
+	err = s.blobStore.Put(archiveKey, reader, "application/zip")
+	// Stops the writer if the store gave up before reading the whole archive
+	reader.CloseWithError(err)
+	<-written
+	return err
+}
+
+func (s *DataExportService) writeArchive(w io.Writer, data *repository.UserData) error {
+	archive := zip.NewWriter(w)
+
+	files := map[string]interface{}{
+		"user.json":             data.User,
+		"profile.json":          data.Profile,
+		"photos.json":           data.Photos,
+		"swipe_history.json":    data.SwipeHistory,
+		"matches.json":          data.Matches,
+		"messages.json":         data.Messages,
+		"notifications.json":    data.Notifications,
+		"location_history.json": data.LocationHistory,
+		"profile_views.json":    data.ProfileViews,
+	}
+	for name, content := range files {
+		if err := writeJSONFile(archive, name, content); err != nil {
+			return err
+		}
+	}
+
+	for _, photo := range data.Photos {
+		if err := s.copyPhoto(archive, photo.StorageKey); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func (s *DataExportService) copyPhoto(archive *zip.Writer, key string) error {
+	photo, err := s.blobStore.Get(key)
+	if err == helpers.ErrBlobNotFound {
+		// A photo deleted while the export runs is simply left out
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer photo.Close()
+
+	file, err := archive.Create(path.Join("photos", path.Base(key)))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, photo)
+	return err
+}
+
+func writeJSONFile(archive *zip.Writer, name string, content interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(content)
+}
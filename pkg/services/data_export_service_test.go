@@ -0,0 +1,274 @@
+// services/data_export_service_test.go
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// memoryDataExportRepo keeps exports in memory, claiming them like the database one
+type memoryDataExportRepo struct {
+	repository.DataExportRepository
+	mu       sync.Mutex
+	exports  map[int]*models.DataExport
+	renewals int
+}
+
+func (r *memoryDataExportRepo) CreateDataExport(export *models.DataExport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	export.ExportID = len(r.exports) + 1
+	stored := *export
+	r.exports[export.ExportID] = &stored
+	return nil
+}
+
+func (r *memoryDataExportRepo) GetDataExportByID(exportID int) (*models.DataExport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	export, ok := r.exports[exportID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	found := *export
+	return &found, nil
+}
+
+func (r *memoryDataExportRepo) UpdateDataExport(export *models.DataExport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := *export
+	r.exports[export.ExportID] = &stored
+	return nil
+}
+
+func (r *memoryDataExportRepo) ClaimDataExports(now time.Time, lease time.Duration, limit int) ([]models.DataExport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var claimed []models.DataExport
+	for exportID := 1; exportID <= len(r.exports) && len(claimed) < limit; exportID++ {
+		export := r.exports[exportID]
+		due := export.Status == models.DataExportStatusPending || export.Status == models.DataExportStatusProcessing
+		if !due || export.LeasedUntil.After(now) {
+			continue
+		}
+		export.Status = models.DataExportStatusProcessing
+		export.LeasedUntil = now.Add(lease)
+		export.Attempts++
+		claimed = append(claimed, *export)
+	}
+	return claimed, nil
+}
+
+func (r *memoryDataExportRepo) RenewDataExportLease(exportID int, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.renewals++
+	r.exports[exportID].LeasedUntil = until
+	return nil
+}
+
+func (r *memoryDataExportRepo) GetUserData(userID int) (*repository.UserData, error) {
+	return &repository.UserData{
+		User:   &models.User{UserID: userID, Email: "user@example.com"},
+		Photos: []models.Photo{{PhotoID: 1, UserID: userID, StorageKey: "photos/1/1.jpg"}},
+	}, nil
+}
+
+// flakyBlobStore fails to store archives while failures are left, and takes delay to store one
+type flakyBlobStore struct {
+	helpers.BlobStore
+	failures int
+	delay    time.Duration
+}
+
+func (s *flakyBlobStore) Put(key string, body io.Reader, contentType string) error {
+	time.Sleep(s.delay)
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("store unavailable")
+	}
+	return s.BlobStore.Put(key, body, contentType)
+}
+
+type dataExportFixture struct {
+	service       *DataExportService
+	exports       *memoryDataExportRepo
+	notifications *memoryNotificationRepo
+	blobStore     *flakyBlobStore
+	now           time.Time
+}
+
+func newDataExportFixture(t *testing.T) *dataExportFixture {
+	t.Helper()
+	blobStore := &flakyBlobStore{BlobStore: helpers.NewFileSystemBlobStore(t.TempDir())}
+	if err := blobStore.Put("photos/1/1.jpg", strings.NewReader("photo"), "image/jpeg"); err != nil {
+		t.Fatalf("storing the photo failed: %v", err)
+	}
+
+	f := &dataExportFixture{
+		exports:       &memoryDataExportRepo{exports: map[int]*models.DataExport{}},
+		notifications: &memoryNotificationRepo{},
+		blobStore:     blobStore,
+		now:           time.Date(2024, 3, 11, 12, 0, 0, 0, time.UTC),
+	}
+	f.service = NewDataExportService(f.exports, f.notifications, blobStore, DefaultDataExportPolicy())
+	f.service.now = func() time.Time { return f.now }
+	return f
+}
+
+// archiveFiles returns the contents of the files in the export's archive by name
+func (f *dataExportFixture) archiveFiles(t *testing.T, exportID int) map[string]string {
+	t.Helper()
+	export, _ := f.exports.GetDataExportByID(exportID)
+	archive, err := f.service.OpenArchive(export)
+	if err != nil {
+		t.Fatalf("opening the archive failed: %v", err)
+	}
+	defer archive.Close()
+	content, _ := io.ReadAll(archive)
+
+	reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("invalid archive: %v", err)
+	}
+	files := map[string]string{}
+	for _, file := range reader.File {
+		opened, _ := file.Open()
+		data, _ := io.ReadAll(opened)
+		opened.Close()
+		files[file.Name] = string(data)
+	}
+	return files
+}
+
+func TestDataExportService_BuildsRequestedExports(t *testing.T) {
+	f := newDataExportFixture(t)
+
+	export, err := f.service.RequestExport(1)
+	if err != nil {
+		t.Fatalf("requesting the export failed: %v", err)
+	}
+	if export.Status != models.DataExportStatusPending {
+		t.Fatalf("expected a pending export, got %+v", export)
+	}
+	// The worker is woken up rather than left waiting for its interval
+	select {
+	case <-f.service.wake:
+	default:
+		t.Error("expected the worker to be woken up")
+	}
+
+	report, err := f.service.RunOnce(context.Background())
+	if err != nil || report != (DataExportReport{Ready: 1}) {
+		t.Fatalf("expected the export built, got %+v (%v)", report, err)
+	}
+	stored, _ := f.exports.GetDataExportByID(export.ExportID)
+	if stored.Status != models.DataExportStatusReady || !stored.ExpiresAt.Equal(f.now.Add(DataExportRetention)) {
+		t.Fatalf("expected a ready export, got %+v", stored)
+	}
+
+	files := f.archiveFiles(t, export.ExportID)
+	if !strings.Contains(files["user.json"], "user@example.com") || files["photos/1.jpg"] != "photo" {
+		t.Errorf("expected the user's data and photo in the archive, got %v", files)
+	}
+	if len(f.notifications.ofType(NotificationTypeDataExportReady)) != 1 {
+		t.Errorf("expected the user to be notified, got %+v", f.notifications.created)
+	}
+
+	// Nothing is left to do
+	if report, _ := f.service.RunOnce(context.Background()); report != (DataExportReport{}) {
+		t.Errorf("expected no more exports, got %+v", report)
+	}
+}
+
+func TestDataExportService_TakesOverExportsOfStoppedInstances(t *testing.T) {
+	f := newDataExportFixture(t)
+	lease := f.service.policy.Lease
+	// Export 1 was being built by an instance that stopped, export 2 is being built by a live one
+	// and export 3 stopped its instances every time it was tried
+	f.exports.exports = map[int]*models.DataExport{
+		1: {ExportID: 1, UserID: 1, Status: models.DataExportStatusProcessing, Attempts: 1, LeasedUntil: f.now.Add(-time.Minute)},
+		2: {ExportID: 2, UserID: 2, Status: models.DataExportStatusProcessing, Attempts: 1, LeasedUntil: f.now.Add(lease / 2)},
+		3: {ExportID: 3, UserID: 3, Status: models.DataExportStatusProcessing, Attempts: 3, LeasedUntil: f.now.Add(-time.Minute)},
+	}
+
+	var total DataExportReport
+	for {
+		report, err := f.service.RunOnce(context.Background())
+		if err != nil {
+			t.Fatalf("worker failed: %v", err)
+		}
+		if report == (DataExportReport{}) {
+			break
+		}
+		total.Ready += report.Ready
+		total.Failed += report.Failed
+	}
+	if total != (DataExportReport{Ready: 1, Failed: 1}) {
+		t.Fatalf("expected one export built and one given up, got %+v", total)
+	}
+
+	if export, _ := f.exports.GetDataExportByID(1); export.Status != models.DataExportStatusReady || export.Attempts != 2 {
+		t.Errorf("expected the stopped export built on a second attempt, got %+v", export)
+	}
+	if export, _ := f.exports.GetDataExportByID(2); export.Status != models.DataExportStatusProcessing || export.Attempts != 1 {
+		t.Errorf("expected the leased export left alone, got %+v", export)
+	}
+	if export, _ := f.exports.GetDataExportByID(3); export.Status != models.DataExportStatusFailed || export.ErrorMessage == "" {
+		t.Errorf("expected the export out of attempts failed, got %+v", export)
+	}
+}
+
+func TestDataExportService_RetriesFailedBuilds(t *testing.T) {
+	f := newDataExportFixture(t)
+	f.blobStore.failures = 3
+	export, _ := f.service.RequestExport(1)
+
+	// The first failures leave the export for another attempt, the last one fails it
+	for attempt := 1; attempt <= 3; attempt++ {
+		report, err := f.service.RunOnce(context.Background())
+		if err != nil {
+			t.Fatalf("worker failed: %v", err)
+		}
+		stored, _ := f.exports.GetDataExportByID(export.ExportID)
+		if attempt < 3 && (report != (DataExportReport{Retried: 1}) || stored.Status != models.DataExportStatusPending) {
+			t.Fatalf("attempt %d: expected a retry, got %+v and %+v", attempt, report, stored)
+		}
+		if attempt == 3 && (report != (DataExportReport{Failed: 1}) || stored.Status != models.DataExportStatusFailed) {
+			t.Fatalf("attempt %d: expected the export failed, got %+v and %+v", attempt, report, stored)
+		}
+		if stored.ErrorMessage != "store unavailable" {
+			t.Errorf("attempt %d: expected the error recorded, got %q", attempt, stored.ErrorMessage)
+		}
+	}
+	if _, err := f.service.OpenArchive(&models.DataExport{Status: models.DataExportStatusFailed}); err == nil {
+		t.Error("expected no archive for a failed export")
+	}
+}
+
+func TestDataExportService_RenewsTheLeaseWhileBuilding(t *testing.T) {
+	f := newDataExportFixture(t)
+	f.service.policy.Lease = 30 * time.Millisecond
+	f.blobStore.delay = 100 * time.Millisecond
+	f.service.RequestExport(1)
+
+	if report, err := f.service.RunOnce(context.Background()); err != nil || report.Ready != 1 {
+		t.Fatalf("expected the export built, got %+v (%v)", report, err)
+	}
+	if f.exports.renewals < 2 {
+		t.Errorf("expected the lease renewed while the archive was stored, got %d renewals", f.exports.renewals)
+	}
+}
@@ -0,0 +1,188 @@
+// services/discovery_service.go
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// Size of the candidate pool ranked for each request
+const discoveryPoolSize = 200
+
+// DiscoveryService finds candidates for a user, honouring both the user's and the candidates'
+// discovery preferences, and ranks them with the recommendation pipeline
+type DiscoveryService struct {
+	userRepo        repository.UserRepository
+	profileRepo     repository.ProfileRepository
+	preferencesRepo repository.DiscoveryPreferencesRepository
+	locationRepo    repository.LocationRepository
+	swipeRepo       repository.SwipeHistoryRepository
+	inventoryRepo   repository.InventoryRepository
+	pipeline        *RankingPipeline
+}
+
+// NewDiscoveryService creates a new DiscoveryService ranking with the given feature weights
+func NewDiscoveryService(
+	userRepo repository.UserRepository,
+	profileRepo repository.ProfileRepository,
+	preferencesRepo repository.DiscoveryPreferencesRepository,
+	locationRepo repository.LocationRepository,
+	swipeRepo repository.SwipeHistoryRepository,
+	inventoryRepo repository.InventoryRepository,
+	weights RankingWeights,
+) *DiscoveryService {
+	s := &DiscoveryService{
+		userRepo:        userRepo,
+		profileRepo:     profileRepo,
+		preferencesRepo: preferencesRepo,
+		locationRepo:    locationRepo,
+		swipeRepo:       swipeRepo,
+		inventoryRepo:   inventoryRepo,
+	}
+	s.pipeline = NewRankingPipeline(s, weights, DefaultFeatures(), DefaultReRankers())
+	return s
+}
+
+// FindNearby returns the next page of ranked nearby candidates that match the user's preferences
+// and whose own preferences the user matches. Returned candidates are not shown again today, so
+// each call serves the best candidates the user has not seen yet rather than an offset into the
+// ranking, which would skip candidates that moved up when earlier pages were left out.
+func (s *DiscoveryService) FindNearby(userID int, maxDistance float64, pageSize int) ([]repository.LocationWithDistance, error) {
+	filter, err := s.buildFilter(userID, maxDistance)
+	if err != nil {
+		return nil, err
+	}
+
+	viewer := &Viewer{UserID: userID, Filter: filter}
+	if profile, err := s.profileRepo.GetProfileByUserID(userID); err == nil {
+		viewer.Profile = profile
+	}
+
+	ranked, err := s.pipeline.Recommend(viewer, discoveryPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if pageSize < 1 {
+		pageSize = 20
+	}
+	end := pageSize
+	if end > len(ranked) {
+		end = len(ranked)
+	}
+
+	results := make([]repository.LocationWithDistance, 0, end)
+	shownUserIDs := make([]int, 0, end)
+	for _, candidate := range ranked[:end] {
+		location := candidate.Location
+		location.Profile = candidate.Profile
+		location.Score = candidate.Score
+		location.DistanceBucket = helpers.DistanceBucket(location.Distance)
+		results = append(results, location)
+		shownUserIDs = append(shownUserIDs, location.UserID)
+	}
+
+	if err := s.locationRepo.LogShownProfiles(userID, shownUserIDs, filter.ShownOn); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Candidates generates the ranking pool: nearby users passing both sides' preferences, with
+// their profiles, swipe behaviour and boosts attached
+func (s *DiscoveryService) Candidates(viewer *Viewer, limit int) ([]Candidate, error) {
+	locations, err := s.locationRepo.GetNearbyCandidates(viewer.UserID, viewer.Filter, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]int, 0, len(locations))
+	for _, location := range locations {
+		userIDs = append(userIDs, location.UserID)
+	}
+
+	stats, err := s.swipeRepo.GetSwipeStats(userIDs)
+	if err != nil {
+		return nil, err
+	}
+	likers, err := s.swipeRepo.GetLikersAmong(viewer.UserID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	boosted, err := s.inventoryRepo.GetBoostedAmong(userIDs, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := s.profileRepo.GetProfilesByUserIDs(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]Candidate, 0, len(locations))
+	for _, location := range locations {
+		candidate := Candidate{
+			Location:         location,
+			Profile:          profiles[location.UserID],
+			LastActive:       location.Timestamp,
+			LikedViewer:      likers[location.UserID] != "",
+			SuperLikedViewer: likers[location.UserID] == models.SwipeDirectionSuper,
+			Boosted:          boosted[location.UserID],
+			Swipes:           stats[location.UserID].Swipes,
+			Likes:            stats[location.UserID].Likes,
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+func (s *DiscoveryService) buildFilter(userID int, maxDistance float64) (repository.DiscoveryFilter, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return repository.DiscoveryFilter{}, err
+	}
+
+	preferences, err := s.preferencesRepo.GetPreferences(userID)
+	if err != nil {
+		return repository.DiscoveryFilter{}, err
+	}
+
+	filter := repository.DiscoveryFilter{
+		MaxDistance: maxDistance,
+		Preferences: preferences,
+		Gender:      user.Gender,
+		Age:         user.Age(time.Now()),
+	}
+	filter.ShownOn = time.Now().In(user.Location())
+
+	profile, err := s.profileRepo.GetProfileByUserID(userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return repository.DiscoveryFilter{}, err
+	}
+	if profile != nil {
+		filter.Height = profile.Height
+		filter.RelationshipGoal = profile.RelationshipGoals
+		filter.Language = profile.Language
+	}
+
+	return filter, nil
+}
+
+// ValidatePreferences checks the cross-field rules that struct tags cannot express
+func ValidatePreferences(preferences *models.DiscoveryPreferences) string {
+	if preferences.MinAge > preferences.MaxAge {
+		return "minAge must not be greater than maxAge"
+	}
+	if preferences.MinHeight > preferences.MaxHeight {
+		return "minHeight must not be greater than maxHeight"
+	}
+	if preferences.MaxDistanceKm < 0 {
+		return "maxDistanceKm must not be negative"
+	}
+	return ""
+}
@@ -0,0 +1,197 @@
+// services/discovery_service_test.go
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+func (r *memorySwipeRepo) GetSwipeStats(userIDs []int) (map[int]repository.SwipeStats, error) {
+	stats := make(map[int]repository.SwipeStats)
+	for _, swipe := range r.swipes {
+		stat := stats[swipe.SwiperUserID]
+		stat.UserID = swipe.SwiperUserID
+		stat.Swipes++
+		if models.IsLike(swipe.SwipeDirection) {
+			stat.Likes++
+		}
+		stats[swipe.SwiperUserID] = stat
+	}
+	return stats, nil
+}
+
+func (r *memorySwipeRepo) GetLikersAmong(swipedUserID int, swiperUserIDs []int) (map[int]string, error) {
+	likers := make(map[int]string)
+	for _, swipe := range r.swipes {
+		if swipe.SwipedUserID == swipedUserID && models.IsLike(swipe.SwipeDirection) {
+			likers[swipe.SwiperUserID] = swipe.SwipeDirection
+		}
+	}
+	return likers, nil
+}
+
+// memoryDiscoveryPreferencesRepo gives every user the default preferences
+type memoryDiscoveryPreferencesRepo struct {
+	repository.DiscoveryPreferencesRepository
+}
+
+func (memoryDiscoveryPreferencesRepo) GetPreferences(userID int) (*models.DiscoveryPreferences, error) {
+	return models.DefaultDiscoveryPreferences(userID), nil
+}
+
+// memoryProfileRepo serves profiles by user ID; users without one have not created a profile
+type memoryProfileRepo struct {
+	repository.ProfileRepository
+	profiles map[int]*models.Profile
+}
+
+func (r *memoryProfileRepo) GetProfileByUserID(userID int) (*models.Profile, error) {
+	if profile, ok := r.profiles[userID]; ok {
+		return profile, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryProfileRepo) GetProfilesByUserIDs(userIDs []int) (map[int]*models.Profile, error) {
+	profiles := make(map[int]*models.Profile)
+	for _, userID := range userIDs {
+		if profile, ok := r.profiles[userID]; ok {
+			profiles[userID] = profile
+		}
+	}
+	return profiles, nil
+}
+
+// memoryLocationRepo returns a fixed set of nearby users and leaves out those logged as shown on
+// the caller's local day, like the database one
+type memoryLocationRepo struct {
+	repository.LocationRepository
+	nearby []repository.LocationWithDistance
+	shown  map[string][]int
+}
+
+func (r *memoryLocationRepo) GetNearbyCandidates(userID int, filter repository.DiscoveryFilter, limit int) ([]repository.LocationWithDistance, error) {
+	day := filter.ShownOn.Format("2006-01-02")
+	var candidates []repository.LocationWithDistance
+	for _, location := range r.nearby {
+		if containsInt(r.shown[day], location.UserID) || len(candidates) == limit {
+			continue
+		}
+		candidates = append(candidates, location)
+	}
+	return candidates, nil
+}
+
+func (r *memoryLocationRepo) LogShownProfiles(userID int, shownUserIDs []int, shownAt time.Time) error {
+	day := shownAt.Format("2006-01-02")
+	if r.shown == nil {
+		r.shown = make(map[string][]int)
+	}
+	for _, shownUserID := range shownUserIDs {
+		if !containsInt(r.shown[day], shownUserID) {
+			r.shown[day] = append(r.shown[day], shownUserID)
+		}
+	}
+	return nil
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func nearbyUserIDs(locations []repository.LocationWithDistance) []int {
+	userIDs := make([]int, 0, len(locations))
+	for _, location := range locations {
+		userIDs = append(userIDs, location.UserID)
+	}
+	return userIDs
+}
+
+func TestDiscoveryService_FindNearbyRanksAndPagesWithoutSkipping(t *testing.T) {
+	now := time.Now()
+	locations := &memoryLocationRepo{}
+	// The source lists candidates most recently active first, which is not their ranked order
+	for i, userID := range []int{13, 12, 11, 14, 15} {
+		locations.nearby = append(locations.nearby, repository.LocationWithDistance{
+			UserID:    userID,
+			Distance:  float64(userID - 10),
+			Timestamp: now.Add(-time.Duration(i) * time.Minute),
+		})
+	}
+
+	inventory := &memoryInventoryRepo{boosts: []models.Boost{
+		{UserID: 14, StartsAt: now.Add(-time.Minute), EndsAt: now.Add(time.Hour)},
+	}}
+	swipes := &memorySwipeRepo{swipes: []models.SwipeHistory{
+		{SwiperUserID: 15, SwipedUserID: 5, SwipeDirection: models.SwipeDirectionSuper},
+	}}
+	service := NewDiscoveryService(memoryUserRepo{}, &memoryProfileRepo{}, memoryDiscoveryPreferencesRepo{}, locations, swipes, inventory, DefaultRankingWeights())
+
+	// The super like comes first, then the boost, then the rest closest first
+	var pages [][]int
+	for i := 0; i < 4; i++ {
+		page, err := service.FindNearby(5, 50, 2)
+		if err != nil {
+			t.Fatalf("find nearby failed: %v", err)
+		}
+		for _, location := range page {
+			if location.DistanceBucket == "" {
+				t.Fatalf("expected a distance bucket, got %+v", location)
+			}
+		}
+		pages = append(pages, nearbyUserIDs(page))
+	}
+
+	want := [][]int{{15, 14}, {11, 12}, {13}, {}}
+	for i := range want {
+		if len(pages[i]) != len(want[i]) {
+			t.Fatalf("page %d: expected %v, got %v", i+1, want[i], pages)
+		}
+		for j := range want[i] {
+			if pages[i][j] != want[i][j] {
+				t.Fatalf("page %d: expected %v, got %v", i+1, want[i], pages)
+			}
+		}
+	}
+
+	// Every candidate was logged as shown exactly once
+	shown := locations.shown[time.Now().Format("2006-01-02")]
+	if len(shown) != 5 {
+		t.Fatalf("expected all five candidates to be logged as shown, got %v", locations.shown)
+	}
+}
+
+func TestDiscoveryService_FindNearbyDefaultsThePageSize(t *testing.T) {
+	lastActive := time.Now().Add(-time.Hour)
+	locations := &memoryLocationRepo{}
+	for userID := 100; userID < 130; userID++ {
+		locations.nearby = append(locations.nearby, repository.LocationWithDistance{UserID: userID, Distance: 1, Timestamp: lastActive})
+	}
+	service := NewDiscoveryService(memoryUserRepo{}, &memoryProfileRepo{}, memoryDiscoveryPreferencesRepo{}, locations, &memorySwipeRepo{}, &memoryInventoryRepo{}, DefaultRankingWeights())
+
+	page, err := service.FindNearby(5, 50, 0)
+	if err != nil {
+		t.Fatalf("find nearby failed: %v", err)
+	}
+	if len(page) != 20 {
+		t.Fatalf("expected a default page of 20, got %d", len(page))
+	}
+
+	// Ties keep the source order, so the next page starts right after the first
+	page, err = service.FindNearby(5, 50, 0)
+	if err != nil {
+		t.Fatalf("find nearby failed: %v", err)
+	}
+	if len(page) != 10 || page[0].UserID != 120 {
+		t.Fatalf("expected the remaining 10 candidates from user 120, got %v", nearbyUserIDs(page))
+	}
+}
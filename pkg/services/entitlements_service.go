@@ -0,0 +1,85 @@
+// services/entitlements_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// entitlementsCacheTTL bounds how long a cached answer is trusted; it is also never kept past the
+// end of the subscription period it was derived from
+const entitlementsCacheTTL = 10 * time.Minute
+
+// EntitlementsService answers which paid features a user may use
+type EntitlementsService struct {
+	subscriptionRepo repository.SubscriptionRepository
+	redisHelper      helpers.RedisHandler
+	now              func() time.Time
+}
+
+// NewEntitlementsService creates a new instance of EntitlementsService
+func NewEntitlementsService(subscriptionRepo repository.SubscriptionRepository, redisHelper helpers.RedisHandler) *EntitlementsService {
+	return &EntitlementsService{
+		subscriptionRepo: subscriptionRepo,
+		redisHelper:      redisHelper,
+		now:              time.Now,
+	}
+}
+
+// Entitlements returns the features granted to the user by their current subscription, or the
+// free allowances when they have none
+func (s *EntitlementsService) Entitlements(userID int) (*models.Entitlements, error) {
+	key := entitlementsCacheKey(userID)
+	now := s.now()
+
+	var cached models.Entitlements
+	if err := s.redisHelper.Get(key, &cached); err == nil && cached.UserID == userID {
+		if cached.ExpiresAt == nil || now.Before(*cached.ExpiresAt) {
+			return &cached, nil
+		}
+	}
+
+	entitlements := models.FreeEntitlements(userID)
+	subscription, err := s.subscriptionRepo.GetCurrentSubscription(userID, now)
+	switch {
+	case err == nil:
+		entitlements = models.PlanEntitlements(userID, &subscription.Plan, subscription.AccessEndsAt())
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	ttl := entitlementsCacheTTL
+	if entitlements.ExpiresAt != nil {
+		if untilExpiry := entitlements.ExpiresAt.Sub(now); untilExpiry < ttl {
+			ttl = untilExpiry
+		}
+	}
+	if err := s.redisHelper.Set(key, entitlements, ttl); err != nil {
+		log.Printf("Error setting entitlements in Redis: %v", err)
+	}
+
+	return entitlements, nil
+}
+
+// Plans returns the plans that can be purchased
+func (s *EntitlementsService) Plans() ([]models.Plan, error) {
+	return s.subscriptionRepo.GetActivePlans()
+}
+
+// Invalidate drops the cached entitlements of the user; call it whenever their subscription changes
+func (s *EntitlementsService) Invalidate(userID int) {
+	if err := s.redisHelper.Delete(entitlementsCacheKey(userID)); err != nil {
+		log.Printf("Error deleting entitlements in Redis: %v", err)
+	}
+}
+
+func entitlementsCacheKey(userID int) string {
+	return fmt.Sprintf("entitlements:%d", userID)
+}
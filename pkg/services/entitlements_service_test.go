@@ -0,0 +1,182 @@
+// services/entitlements_service_test.go
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// failingSubscriptionRepo fails every lookup of a current subscription
+type failingSubscriptionRepo struct {
+	repository.SubscriptionRepository
+}
+
+func (failingSubscriptionRepo) GetCurrentSubscription(userID int, now time.Time) (*models.Subscription, error) {
+	return nil, errors.New("connection refused")
+}
+
+type entitlementsFixture struct {
+	service       *EntitlementsService
+	subscriptions *memorySubscriptionRepo
+	cache         map[string][]byte
+	ttls          map[string]time.Duration
+	now           time.Time
+}
+
+func newEntitlementsFixture() *entitlementsFixture {
+	f := &entitlementsFixture{
+		subscriptions: &memorySubscriptionRepo{plans: []models.Plan{
+			{PlanID: 1, Code: "plus", Name: "Plus", Rank: 1, UnlimitedLikes: true, RewindsPerDay: 5, Passport: true, IsActive: true},
+			{PlanID: 2, Code: "gold", Name: "Gold", Rank: 2, UnlimitedLikes: true, SeeWhoLikesYou: true, RewindsPerDay: models.UnlimitedQuota, SuperLikesPerWeek: 5, Passport: true, BoostsPerMonth: 1, IsActive: true},
+		}},
+		cache: map[string][]byte{},
+		ttls:  map[string]time.Duration{},
+		now:   time.Date(2024, 3, 11, 12, 0, 0, 0, time.UTC),
+	}
+
+	// The cache keeps values as JSON like Redis does, ignoring their TTL but recording it
+	redis := &mocks.MockRedisHandler{
+		GetFunc: func(key string, dest interface{}) error {
+			data, ok := f.cache[key]
+			if !ok {
+				return errors.New("cache miss")
+			}
+			return json.Unmarshal(data, dest)
+		},
+		SetFunc: func(key string, value interface{}, expiration time.Duration) error {
+			data, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			f.cache[key] = data
+			f.ttls[key] = expiration
+			return nil
+		},
+		DeleteFunc: func(key string) error {
+			delete(f.cache, key)
+			return nil
+		},
+	}
+	f.service = NewEntitlementsService(f.subscriptions, redis)
+	f.service.now = func() time.Time { return f.now }
+	return f
+}
+
+func (f *entitlementsFixture) subscribe(userID, planID int, status string, periodEnd time.Time, graceUntil *time.Time) {
+	f.subscriptions.SaveSubscription(&models.Subscription{
+		UserID: userID, PlanID: planID, Status: status, CurrentPeriodEnd: periodEnd, GraceUntil: graceUntil,
+	})
+}
+
+func TestEntitlementsService_ResolvesThePlanOfTheCurrentSubscription(t *testing.T) {
+	f := newEntitlementsFixture()
+	periodEnd := f.now.AddDate(0, 1, 0)
+	f.subscribe(1, 2, models.SubscriptionStatusActive, periodEnd, nil)
+
+	entitlements, err := f.service.Entitlements(1)
+	if err != nil {
+		t.Fatalf("resolving entitlements failed: %v", err)
+	}
+	want := models.Entitlements{
+		UserID: 1, Plan: "gold", PlanName: "Gold", ExpiresAt: &periodEnd,
+		UnlimitedLikes: true, DailyLikes: models.UnlimitedQuota, SeeWhoLikesYou: true,
+		RewindsPerDay: models.UnlimitedQuota, SuperLikesPerWeek: 5, Passport: true, BoostsPerMonth: 1,
+	}
+	if entitlements.ExpiresAt == nil || !entitlements.ExpiresAt.Equal(periodEnd) {
+		t.Fatalf("expected the entitlements to expire with the period, got %v", entitlements.ExpiresAt)
+	}
+	entitlements.ExpiresAt = &periodEnd
+	if *entitlements != want || !entitlements.IsPaid() {
+		t.Errorf("expected the gold plan's features, got %+v", entitlements)
+	}
+
+	// A subscription past due keeps its plan through the grace period
+	graceUntil := f.now.Add(48 * time.Hour)
+	f.subscribe(2, 1, models.SubscriptionStatusPastDue, f.now.Add(-time.Hour), &graceUntil)
+	entitlements, err = f.service.Entitlements(2)
+	if err != nil || entitlements.Plan != "plus" || !entitlements.ExpiresAt.Equal(graceUntil) {
+		t.Errorf("expected the plus plan until the end of the grace period, got %+v (%v)", entitlements, err)
+	}
+}
+
+func TestEntitlementsService_FallsBackToTheFreeTier(t *testing.T) {
+	f := newEntitlementsFixture()
+	// User 2's subscription has expired, user 3's period ended without a grace period
+	f.subscribe(2, 2, models.SubscriptionStatusExpired, f.now.AddDate(0, 1, 0), nil)
+	f.subscribe(3, 1, models.SubscriptionStatusCanceled, f.now.Add(-time.Minute), nil)
+
+	for _, userID := range []int{1, 2, 3} {
+		entitlements, err := f.service.Entitlements(userID)
+		if err != nil {
+			t.Fatalf("user %d: resolving entitlements failed: %v", userID, err)
+		}
+		if *entitlements != *models.FreeEntitlements(userID) || entitlements.IsPaid() {
+			t.Errorf("user %d: expected the free tier, got %+v", userID, entitlements)
+		}
+		// Free entitlements do not expire, so they are cached for the full TTL
+		if ttl := f.ttls[entitlementsCacheKey(userID)]; ttl != entitlementsCacheTTL {
+			t.Errorf("user %d: expected the free tier cached for %v, got %v", userID, entitlementsCacheTTL, ttl)
+		}
+	}
+
+	// Failing to look the subscription up is not mistaken for having none
+	f.service.subscriptionRepo = failingSubscriptionRepo{}
+	if entitlements, err := f.service.Entitlements(4); err == nil || errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected the lookup error returned, got %+v (%v)", entitlements, err)
+	}
+}
+
+func TestEntitlementsService_DoesNotServeEntitlementsPastTheirExpiry(t *testing.T) {
+	f := newEntitlementsFixture()
+	periodEnd := f.now.Add(3 * time.Minute)
+	f.subscribe(1, 1, models.SubscriptionStatusCanceled, periodEnd, nil)
+
+	if entitlements, err := f.service.Entitlements(1); err != nil || entitlements.Plan != "plus" {
+		t.Fatalf("expected the plus plan, got %+v (%v)", entitlements, err)
+	}
+	// The cached answer is not kept past the end of the period
+	if ttl := f.ttls[entitlementsCacheKey(1)]; ttl != 3*time.Minute {
+		t.Errorf("expected the entitlements cached until the period ends, got %v", ttl)
+	}
+
+	// Until then they are answered from the cache, even if the subscription changes meanwhile
+	f.subscriptions.subscriptions[0].PlanID = 2
+	f.now = f.now.Add(time.Minute)
+	if entitlements, _ := f.service.Entitlements(1); entitlements.Plan != "plus" {
+		t.Errorf("expected the cached plan, got %+v", entitlements)
+	}
+
+	// A cached answer that outlived its expiry, as Redis may keep it a little longer, is recomputed
+	f.now = periodEnd
+	entitlements, err := f.service.Entitlements(1)
+	if err != nil || *entitlements != *models.FreeEntitlements(1) {
+		t.Errorf("expected the free tier once the period ended, got %+v (%v)", entitlements, err)
+	}
+}
+
+func TestEntitlementsService_InvalidateDropsTheCachedAnswer(t *testing.T) {
+	f := newEntitlementsFixture()
+
+	if entitlements, _ := f.service.Entitlements(1); entitlements.IsPaid() {
+		t.Fatalf("expected the free tier, got %+v", entitlements)
+	}
+	f.subscribe(1, 2, models.SubscriptionStatusActive, f.now.AddDate(0, 1, 0), nil)
+	if entitlements, _ := f.service.Entitlements(1); entitlements.IsPaid() {
+		t.Fatalf("expected the cached free tier until invalidated, got %+v", entitlements)
+	}
+
+	f.service.Invalidate(1)
+	if _, ok := f.cache[entitlementsCacheKey(1)]; ok {
+		t.Fatal("expected the cached entitlements dropped")
+	}
+	if entitlements, _ := f.service.Entitlements(1); entitlements.Plan != "gold" {
+		t.Errorf("expected the new plan after invalidation, got %+v", entitlements)
+	}
+}
@@ -0,0 +1,92 @@
+// services/event_bus.go
+package services
+
+import (
+	"log"
+	"sync"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+)
+
+// EventHandler reacts to a domain event. Side effects that must not be repeated when the event
+// is redelivered, such as each notification a handler creates, are run through effects.
+type EventHandler func(event models.DomainEvent, effects EffectLog) error
+
+// EffectLog remembers which side effects of handling an event have completed, so a redelivery
+// after a partial failure only redoes the ones that did not
+type EffectLog interface {
+	// Once runs effect unless an earlier delivery of the event completed it under the same key
+	Once(key string, effect func() error) error
+}
+
+// runEveryTime is the EffectLog of an event dispatched without a record of earlier deliveries
+type runEveryTime struct{}
+
+func (runEveryTime) Once(key string, effect func() error) error {
+	return effect()
+}
+
+// scopedEffects prefixes the keys of the effects of one handler with its name
+type scopedEffects struct {
+	scope   string
+	effects EffectLog
+}
+
+func (e scopedEffects) Once(key string, effect func() error) error {
+	return e.effects.Once(e.scope+"/"+key, effect)
+}
+
+// subscription is a named handler
+type subscription struct {
+	name    string
+	handler EventHandler
+}
+
+// EventBus delivers each domain event to the handlers subscribed to its name, in the order they
+// subscribed. Events reach it from the message broker through an EventConsumer; the changes they
+// describe are recorded in the outbox by the repositories making them.
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]subscription
+}
+
+// NewEventBus creates a new EventBus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{handlers: make(map[string][]subscription)}
+}
+
+// Subscribe calls the handler for every event with the given name. The handler's name keys the
+// progress recorded for redeliveries, so it must be unique per event and stay the same.
+func (b *EventBus) Subscribe(eventName, handlerName string, handler EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventName] = append(b.handlers[eventName], subscription{name: handlerName, handler: handler})
+}
+
+// Dispatch delivers the event to all its subscribers, running every side effect
+func (b *EventBus) Dispatch(event models.DomainEvent) error {
+	return b.DispatchWith(event, runEveryTime{})
+}
+
+// DispatchWith delivers the event to all its subscribers and returns the first error they
+// returned. A failing handler does not keep the event from the others. Handlers, and the effects
+// within them, that completed on an earlier delivery of the event according to effects are skipped.
+func (b *EventBus) DispatchWith(event models.DomainEvent, effects EffectLog) error {
+	b.mu.RLock()
+	handlers := b.handlers[event.EventName()]
+	b.mu.RUnlock()
+
+	var firstErr error
+	for _, subscribed := range handlers {
+		err := effects.Once(subscribed.name, func() error {
+			return subscribed.handler(event, scopedEffects{scope: subscribed.name, effects: effects})
+		})
+		if err != nil {
+			log.Printf("Error handling %s event: %v", event.EventName(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
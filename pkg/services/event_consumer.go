@@ -0,0 +1,99 @@
+// services/event_consumer.go
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// NotificationsQueue is the broker queue whose events create notifications
+const NotificationsQueue = "notifications"
+
+// EventConsumer feeds the domain events delivered to a broker queue to the event bus. Delivery is
+// at least once, so each consumer records the events it has handled and acknowledges redeliveries
+// of them without handling them again. An event the bus fails to handle is retried by the broker;
+// the handlers and side effects that completed on the failed delivery are recorded too, so the
+// retry only redoes the rest.
+type EventConsumer struct {
+	queue      string
+	broker     helpers.MessageBroker
+	outboxRepo repository.OutboxRepository
+	bus        *EventBus
+	now        func() time.Time
+}
+
+// NewEventConsumer creates a consumer of the queue, which also names it in the processed events
+func NewEventConsumer(queue string, broker helpers.MessageBroker, outboxRepo repository.OutboxRepository, bus *EventBus) *EventConsumer {
+	return &EventConsumer{
+		queue:      queue,
+		broker:     broker,
+		outboxRepo: outboxRepo,
+		bus:        bus,
+		now:        time.Now,
+	}
+}
+
+// Start consumes the queue until the context is cancelled, reconnecting after the broker fails
+func (c *EventConsumer) Start(ctx context.Context) {
+	for {
+		err := c.broker.Consume(ctx, c.queue, c.Handle)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Consuming %s failed, retrying: %v", c.queue, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// Handle dispatches a delivered event to the bus unless this consumer has already handled it
+func (c *EventConsumer) Handle(message helpers.BrokerMessage) error {
+	processed, err := c.outboxRepo.IsProcessed(c.queue, message.ID)
+	if err != nil {
+		return err
+	}
+	if processed {
+		return nil
+	}
+
+	event, err := models.DecodeDomainEvent(message.Type, message.Body)
+	if err != nil {
+		return err
+	}
+	effects := &processedEffects{consumer: c, eventID: message.ID}
+	if err := c.bus.DispatchWith(event, effects); err != nil {
+		return err
+	}
+	return c.outboxRepo.MarkProcessed(c.queue, message.ID, c.now())
+}
+
+// processedEffects records the completed side effects of an event as processed events of the
+// consumer, keyed "<queue>/<handler>/<effect>"
+type processedEffects struct {
+	consumer *EventConsumer
+	eventID  string
+}
+
+func (e *processedEffects) Once(key string, effect func() error) error {
+	name := e.consumer.queue + "/" + key
+	done, err := e.consumer.outboxRepo.IsProcessed(name, e.eventID)
+	if err != nil {
+		return err
+	}
+	if done {
+		return nil
+	}
+	if err := effect(); err != nil {
+		return err
+	}
+	return e.consumer.outboxRepo.MarkProcessed(name, e.eventID, e.consumer.now())
+}
@@ -0,0 +1,293 @@
+// services/image_processing.go
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	// Longest side, in pixels, of stored photos and thumbnails
+	maxPhotoDimension     = 1600
+	maxThumbnailDimension = 320
+
+	// Images larger than this are rejected before decoding to avoid decompression bombs
+	maxSourceDimension = 10000
+
+	// Longest side, in pixels, a photo is reduced to before being blown up again as a blurred
+	// preview; too few pixels to recognise anyone by
+	blurredPreviewDimension = 8
+
+	jpegQuality = 85
+)
+
+// processedImage is a re-encoded image; re-encoding drops all EXIF metadata including GPS tags
+type processedImage struct {
+	data   []byte
+	width  int
+	height int
+}
+
+// fitWithin scales the image down so its longest side is at most maxDimension
+func fitWithin(src image.Image, maxDimension int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxDimension && height <= maxDimension {
+		return src
+	}
+
+	if width >= height {
+		height = maxInt(1, height*maxDimension/width)
+		width = maxDimension
+	} else {
+		width = maxInt(1, width*maxDimension/height)
+		height = maxDimension
+	}
+
+	return resize(src, width, height)
+}
+
+// resize scales the image to the given size, averaging the source pixels covered by each
+// destination pixel (a box filter), which gives smooth results when shrinking photos.
+func resize(src image.Image, width, height int) *image.RGBA {
+	source := toRGBA(src)
+	srcWidth, srcHeight := source.Bounds().Dx(), source.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := maxInt(y0+1, (y+1)*srcHeight/height)
+
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := maxInt(x0+1, (x+1)*srcWidth/width)
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				offset := source.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(source.Pix[offset])
+					g += uint32(source.Pix[offset+1])
+					b += uint32(source.Pix[offset+2])
+					a += uint32(source.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: uint8(a / n)})
+		}
+	}
+
+	return dst
+}
+
+// blur returns a heavily blurred copy of the image at its original size. The image is shrunk to a
+// few pixels, which throws the detail away for good, and smoothly interpolated back up.
+func blur(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	tiny := toRGBA(fitWithin(src, blurredPreviewDimension))
+	return upscaleBilinear(tiny, bounds.Dx(), bounds.Dy())
+}
+
+// upscaleBilinear enlarges the image to the given size, blending the four nearest source pixels
+func upscaleBilinear(src *image.RGBA, width, height int) *image.RGBA {
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		fy := clampFloat((float64(y)+0.5)*float64(srcHeight)/float64(height)-0.5, 0, float64(srcHeight-1))
+		y0 := int(fy)
+		y1 := minInt(y0+1, srcHeight-1)
+		wy := fy - float64(y0)
+
+		for x := 0; x < width; x++ {
+			fx := clampFloat((float64(x)+0.5)*float64(srcWidth)/float64(width)-0.5, 0, float64(srcWidth-1))
+			x0 := int(fx)
+			x1 := minInt(x0+1, srcWidth-1)
+			wx := fx - float64(x0)
+
+			var pixel [4]uint8
+			for c := 0; c < 4; c++ {
+				top := float64(src.Pix[src.PixOffset(x0, y0)+c])*(1-wx) + float64(src.Pix[src.PixOffset(x1, y0)+c])*wx
+				bottom := float64(src.Pix[src.PixOffset(x0, y1)+c])*(1-wx) + float64(src.Pix[src.PixOffset(x1, y1)+c])*wx
+				pixel[c] = uint8(top*(1-wy) + bottom*wy + 0.5)
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: pixel[0], G: pixel[1], B: pixel[2], A: pixel[3]})
+		}
+	}
+
+	return dst
+}
+
+// toRGBA converts the image to RGBA with its origin at (0, 0)
+func toRGBA(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	if rgba, ok := src.(*image.RGBA); ok && bounds.Min == (image.Point{}) {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+	return dst
+}
+
+// encodeImage encodes the image in the given format; PNG is kept for transparency, everything else becomes JPEG
+func encodeImage(img image.Image, format string) (*processedImage, error) {
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	return &processedImage{data: buf.Bytes(), width: bounds.Dx(), height: bounds.Dy()}, nil
+}
+
+// applyOrientation rotates or flips the image according to its EXIF orientation tag.
+// The tag itself is lost when re-encoding, so it must be baked into the pixels.
+func applyOrientation(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	source := toRGBA(src)
+	width, height := source.Bounds().Dx(), source.Bounds().Dy()
+
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirror horizontally
+				dx, dy = width-1-x, y
+			case 3: // rotate 180
+				dx, dy = width-1-x, height-1-y
+			case 4: // mirror vertically
+				dx, dy = x, height-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 clockwise
+				dx, dy = height-1-y, x
+			case 7: // transverse
+				dx, dy = height-1-y, width-1-x
+			case 8: // rotate 90 counter-clockwise
+				dx, dy = y, width-1-x
+			}
+			dst.SetRGBA(dx, dy, source.RGBAAt(x, y))
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag from a JPEG, returning 1 (normal) when absent
+func jpegOrientation(data []byte) int {
+	reader := bytes.NewReader(data)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(reader, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return 1
+	}
+
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(reader, marker[:]); err != nil || marker[0] != 0xFF {
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return 1
+		}
+
+		// Start of scan: no more metadata segments follow
+		if marker[1] == 0xDA {
+			return 1
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(reader, segment); err != nil {
+			return 1
+		}
+
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+	}
+}
+
+// exifOrientation finds tag 0x0112 in IFD0 of a TIFF-formatted EXIF block
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifdOffset := int(order.Uint32(tiff[4:8]))
+	if ifdOffset < 8 || ifdOffset+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifdOffset:]))
+	for i := 0; i < entries; i++ {
+		entry := ifdOffset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 1
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func clampFloat(value, low, high float64) float64 {
+	if value < low {
+		return low
+	}
+	if value > high {
+		return high
+	}
+	return value
+}
@@ -0,0 +1,134 @@
+// services/image_processing_test.go
+package services
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// checkerboard returns an image alternating black and white pixels, as much detail as there can be
+func checkerboard(bounds image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if (x+y)%2 == 0 {
+				img.SetRGBA(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+			} else {
+				img.SetRGBA(x, y, color.RGBA{A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestUpscaleBilinear_BlendsNeighbouringPixels(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.SetRGBA(0, 0, color.RGBA{A: 255})
+	src.SetRGBA(1, 0, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+
+	dst := upscaleBilinear(src, 4, 2)
+	if dst.Bounds() != image.Rect(0, 0, 4, 2) {
+		t.Fatalf("expected a 4x2 image, got %v", dst.Bounds())
+	}
+	// The edges keep the source colours and the pixels between are blended by distance
+	for y := 0; y < 2; y++ {
+		for x, want := range []uint8{0, 64, 191, 255} {
+			got := dst.RGBAAt(x, y)
+			if got.R != want || got.G != want || got.B != want || got.A != 255 {
+				t.Errorf("pixel (%d, %d): expected grey %d, got %v", x, y, want, got)
+			}
+		}
+	}
+}
+
+func TestUpscaleBilinear_KeepsASinglePixelUniform(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	src.SetRGBA(0, 0, color.RGBA{R: 10, G: 20, B: 30, A: 255})
+
+	dst := upscaleBilinear(src, 5, 3)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			if got := dst.RGBAAt(x, y); got != src.RGBAAt(0, 0) {
+				t.Fatalf("pixel (%d, %d): expected %v, got %v", x, y, src.RGBAAt(0, 0), got)
+			}
+		}
+	}
+}
+
+func TestBlur_KeepsTheSizeAndRemovesTheDetail(t *testing.T) {
+	// A source not starting at the origin comes out at the origin with the same size
+	blurred := blur(checkerboard(image.Rect(10, 10, 74, 58)))
+	if blurred.Bounds() != image.Rect(0, 0, 64, 48) {
+		t.Fatalf("expected a 64x48 image, got %v", blurred.Bounds())
+	}
+	// Every pixel is the same mid grey: nothing of the pattern is left
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			if got := blurred.RGBAAt(x, y); got.R < 120 || got.R > 135 || got.R != got.G || got.G != got.B || got.A != 255 {
+				t.Fatalf("pixel (%d, %d): expected mid grey, got %v", x, y, got)
+			}
+		}
+	}
+}
+
+func TestBlur_KeepsOnlyTheBroadShapes(t *testing.T) {
+	// Left half black, right half white
+	src := image.NewRGBA(image.Rect(0, 0, 80, 80))
+	for y := 0; y < 80; y++ {
+		for x := 40; x < 80; x++ {
+			src.SetRGBA(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+		}
+		for x := 0; x < 40; x++ {
+			src.SetRGBA(x, y, color.RGBA{A: 255})
+		}
+	}
+
+	blurred := blur(src)
+	left, right := blurred.RGBAAt(0, 40), blurred.RGBAAt(79, 40)
+	if left.R > 10 || right.R < 245 {
+		t.Fatalf("expected the halves to stay dark and light, got %v and %v", left, right)
+	}
+	// The sharp edge becomes a gradient
+	middle := blurred.RGBAAt(40, 40)
+	if middle.R < 60 || middle.R > 195 {
+		t.Errorf("expected the edge to be smoothed, got %v", middle)
+	}
+}
+
+func TestFitWithin_BoundsTheLongestSide(t *testing.T) {
+	cases := []struct {
+		width, height int
+		max           int
+		want          image.Rectangle
+	}{
+		{3200, 1600, 1600, image.Rect(0, 0, 1600, 800)},
+		{100, 4000, 1600, image.Rect(0, 0, 40, 1600)},
+		{1, 5000, 320, image.Rect(0, 0, 1, 320)},
+		{5000, 2, 320, image.Rect(0, 0, 320, 1)},
+		{640, 640, 320, image.Rect(0, 0, 320, 320)},
+	}
+	for _, c := range cases {
+		if got := fitWithin(image.NewRGBA(image.Rect(0, 0, c.width, c.height)), c.max).Bounds(); got != c.want {
+			t.Errorf("%dx%d within %d: expected %v, got %v", c.width, c.height, c.max, c.want, got)
+		}
+	}
+
+	// An image already within bounds is kept as it is
+	small := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	if fitWithin(small, 320) != image.Image(small) {
+		t.Error("expected a small image kept as it is")
+	}
+}
+
+func TestResize_AveragesTheCoveredPixels(t *testing.T) {
+	// Shrinking the checkerboard by half averages black and white pixels into grey
+	resized := resize(checkerboard(image.Rect(0, 0, 8, 8)), 4, 4)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if got := resized.RGBAAt(x, y); got.R != 127 || got.A != 255 {
+				t.Fatalf("pixel (%d, %d): expected grey 127, got %v", x, y, got)
+			}
+		}
+	}
+}
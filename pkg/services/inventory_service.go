@@ -0,0 +1,220 @@
+// services/inventory_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNoItemsLeft is returned when the user has none of the consumable item left
+	ErrNoItemsLeft = errors.New("no items of this type left")
+	// ErrPackNotFound is returned when buying a pack that does not exist or is not for sale
+	ErrPackNotFound = errors.New("pack not found")
+)
+
+// NotificationTypeSuperLike is the notification sent to a user who was super liked
+const NotificationTypeSuperLike = "Super Like"
+
+// Credit is an item taken from the inventory to pay for an action. LotID is 0 when the action was
+// free because the plan allows it without limit.
+type Credit struct {
+	ItemType string
+	LotID    int
+}
+
+// InventoryService keeps each user's consumable items: it grants their plan's allowances as they
+// come due and purchased packs, and spends items on super likes, boosts and rewinds
+type InventoryService struct {
+	userRepo            repository.UserRepository
+	inventoryRepo       repository.InventoryRepository
+	entitlementsService *EntitlementsService
+	now                 func() time.Time
+}
+
+// NewInventoryService creates a new instance of InventoryService
+func NewInventoryService(userRepo repository.UserRepository, inventoryRepo repository.InventoryRepository, entitlementsService *EntitlementsService) *InventoryService {
+	return &InventoryService{
+		userRepo:            userRepo,
+		inventoryRepo:       inventoryRepo,
+		entitlementsService: entitlementsService,
+		now:                 time.Now,
+	}
+}
+
+// Wallet returns what the user holds of each item and their current boost
+func (s *InventoryService) Wallet(userID int) (*models.Wallet, error) {
+	now := s.now()
+	entitlements, err := s.grantAllowances(userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	balances, err := s.inventoryRepo.GetBalances(userID, now)
+	if err != nil {
+		return nil, err
+	}
+	wallet := &models.Wallet{UserID: userID, Balances: make(map[string]int, len(models.ItemTypes))}
+	for _, itemType := range models.ItemTypes {
+		wallet.Balances[itemType] = balances[itemType]
+		if unlimited(entitlements, itemType) {
+			wallet.Balances[itemType] = models.UnlimitedQuota
+		}
+	}
+
+	boost, err := s.inventoryRepo.GetActiveBoost(userID, now)
+	switch {
+	case err == nil:
+		wallet.ActiveBoost = boost
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+	return wallet, nil
+}
+
+// Packs returns the packs of items that can be purchased
+func (s *InventoryService) Packs() ([]models.ConsumablePack, error) {
+	return s.inventoryRepo.GetActivePacks()
+}
+
+// Pack returns the pack with the code if it is for sale, or ErrPackNotFound
+func (s *InventoryService) Pack(code string) (*models.ConsumablePack, error) {
+	pack, err := s.inventoryRepo.GetPackByCode(code)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !pack.IsActive) {
+		return nil, ErrPackNotFound
+	}
+	return pack, err
+}
+
+// Consume takes one item of the type from the user's inventory, or returns ErrNoItemsLeft. Give
+// the credit back with Refund if the action it pays for fails.
+func (s *InventoryService) Consume(userID int, itemType string) (*Credit, error) {
+	now := s.now()
+	entitlements, err := s.grantAllowances(userID, now)
+	if err != nil {
+		return nil, err
+	}
+	if unlimited(entitlements, itemType) {
+		return &Credit{ItemType: itemType}, nil
+	}
+
+	lotID, err := s.inventoryRepo.ConsumeItem(userID, itemType, now)
+	if err != nil {
+		return nil, err
+	}
+	if lotID == 0 {
+		return nil, ErrNoItemsLeft
+	}
+	return &Credit{ItemType: itemType, LotID: lotID}, nil
+}
+
+// Refund returns a consumed item to the lot it came from
+func (s *InventoryService) Refund(credit *Credit) error {
+	if credit == nil || credit.LotID == 0 {
+		return nil
+	}
+	return s.inventoryRepo.ReturnItem(credit.LotID)
+}
+
+// StartBoost spends a boost and raises the user's visibility for models.BoostDuration, after any
+// boost they already have running
+func (s *InventoryService) StartBoost(userID int) (*models.Boost, error) {
+	credit, err := s.Consume(userID, models.ItemBoost)
+	if err != nil {
+		return nil, err
+	}
+
+	var lotID *int
+	if credit.LotID != 0 {
+		lotID = &credit.LotID
+	}
+	boost, err := s.inventoryRepo.StartBoost(userID, lotID, models.BoostDuration, s.now())
+	if err != nil {
+		if refundErr := s.Refund(credit); refundErr != nil {
+			return nil, fmt.Errorf("%w (and returning the boost failed: %v)", err, refundErr)
+		}
+		return nil, err
+	}
+	return boost, nil
+}
+
+// GrantPurchase adds a purchased pack to the user's inventory, even if it has been withdrawn from
+// sale since. The source identifies the purchase, so granting it again does nothing.
+func (s *InventoryService) GrantPurchase(userID int, packCode, source string) error {
+	pack, err := s.inventoryRepo.GetPackByCode(packCode)
+	if err != nil {
+		return fmt.Errorf("purchase of unknown pack %q: %w", packCode, err)
+	}
+	_, err = s.inventoryRepo.GrantLot(&models.InventoryLot{
+		UserID:   userID,
+		ItemType: pack.ItemType,
+		Source:   source,
+		Granted:  pack.Quantity,
+	})
+	return err
+}
+
+// RevokePurchase takes back what is left of a purchased pack, e.g. after a refund
+func (s *InventoryService) RevokePurchase(userID int, source string) error {
+	return s.inventoryRepo.RevokeLot(userID, source)
+}
+
+// grantAllowances grants the items the user's plan allows for the current day, week and month, if
+// not granted yet. Allowances do not roll over: each lot expires with its period. Periods follow
+// the calendar in the user's own time zone, like the daily quotas.
+//
+// Each period's allowance is one lot per user, whatever the plan: a plan change within the period
+// tops it up to the new plan's allowance rather than granting that again in full.
+func (s *InventoryService) grantAllowances(userID int, now time.Time) (*models.Entitlements, error) {
+	entitlements, err := s.entitlementsService.Entitlements(userID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	day, nextDay := localDay(now, user.Location())
+	week := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	month := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location())
+	isoYear, isoWeek := day.ISOWeek()
+
+	allowances := []struct {
+		itemType  string
+		quantity  int
+		period    string
+		expiresAt time.Time
+	}{
+		{models.ItemRewind, entitlements.RewindsPerDay, "day:" + day.Format("2006-01-02"), nextDay},
+		{models.ItemSuperLike, entitlements.SuperLikesPerWeek, fmt.Sprintf("week:%d-W%02d", isoYear, isoWeek), week.AddDate(0, 0, 7)},
+		{models.ItemBoost, entitlements.BoostsPerMonth, "month:" + month.Format("2006-01"), month.AddDate(0, 1, 0)},
+	}
+	for _, allowance := range allowances {
+		if allowance.quantity <= 0 {
+			continue
+		}
+		expiresAt := allowance.expiresAt
+		err := s.inventoryRepo.TopUpLot(&models.InventoryLot{
+			UserID:    userID,
+			ItemType:  allowance.itemType,
+			Source:    "allowance:" + allowance.period,
+			Granted:   allowance.quantity,
+			ExpiresAt: &expiresAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entitlements, nil
+}
+
+// unlimited reports whether the plan lets the user take the action without spending items
+func unlimited(entitlements *models.Entitlements, itemType string) bool {
+	return itemType == models.ItemRewind && entitlements.RewindsPerDay == models.UnlimitedQuota
+}
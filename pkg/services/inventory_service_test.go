@@ -0,0 +1,307 @@
+// services/inventory_service_test.go
+package services
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"gorm.io/gorm"
+)
+
+// memoryInventoryRepo keeps packs, lots and boosts in memory with the same consumption order as
+// the database one
+type memoryInventoryRepo struct {
+	mu     sync.Mutex
+	packs  []models.ConsumablePack
+	lots   []*models.InventoryLot
+	boosts []models.Boost
+}
+
+func (r *memoryInventoryRepo) GetActivePacks() ([]models.ConsumablePack, error) {
+	var packs []models.ConsumablePack
+	for _, pack := range r.packs {
+		if pack.IsActive {
+			packs = append(packs, pack)
+		}
+	}
+	return packs, nil
+}
+
+func (r *memoryInventoryRepo) GetPackByCode(code string) (*models.ConsumablePack, error) {
+	for i := range r.packs {
+		if r.packs[i].Code == code {
+			pack := r.packs[i]
+			return &pack, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryInventoryRepo) GrantLot(lot *models.InventoryLot) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.lots {
+		if other.UserID == lot.UserID && other.ItemType == lot.ItemType && other.Source == lot.Source {
+			return false, nil
+		}
+	}
+	stored := *lot
+	stored.LotID = len(r.lots) + 1
+	stored.Remaining = stored.Granted
+	r.lots = append(r.lots, &stored)
+	return true, nil
+}
+
+func (r *memoryInventoryRepo) TopUpLot(lot *models.InventoryLot) error {
+	r.mu.Lock()
+	for _, other := range r.lots {
+		if other.UserID == lot.UserID && other.ItemType == lot.ItemType && other.Source == lot.Source {
+			if lot.Granted > other.Granted {
+				other.Remaining += lot.Granted - other.Granted
+				other.Granted = lot.Granted
+			}
+			r.mu.Unlock()
+			return nil
+		}
+	}
+	r.mu.Unlock()
+	_, err := r.GrantLot(lot)
+	return err
+}
+
+func (r *memoryInventoryRepo) RevokeLot(userID int, source string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, lot := range r.lots {
+		if lot.UserID == userID && lot.Source == source {
+			lot.Remaining = 0
+		}
+	}
+	return nil
+}
+
+func (r *memoryInventoryRepo) ConsumeItem(userID int, itemType string, now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var available []*models.InventoryLot
+	for _, lot := range r.lots {
+		if lot.UserID == userID && lot.ItemType == itemType && lot.Remaining > 0 && (lot.ExpiresAt == nil || lot.ExpiresAt.After(now)) {
+			available = append(available, lot)
+		}
+	}
+	if len(available) == 0 {
+		return 0, nil
+	}
+	sort.SliceStable(available, func(i, j int) bool {
+		a, b := available[i].ExpiresAt, available[j].ExpiresAt
+		return a != nil && (b == nil || a.Before(*b))
+	})
+	available[0].Remaining--
+	return available[0].LotID, nil
+}
+
+func (r *memoryInventoryRepo) ReturnItem(lotID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, lot := range r.lots {
+		if lot.LotID == lotID && lot.Remaining < lot.Granted {
+			lot.Remaining++
+		}
+	}
+	return nil
+}
+
+func (r *memoryInventoryRepo) GetBalances(userID int, now time.Time) (map[string]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	balances := make(map[string]int)
+	for _, lot := range r.lots {
+		if lot.UserID == userID && lot.Remaining > 0 && (lot.ExpiresAt == nil || lot.ExpiresAt.After(now)) {
+			balances[lot.ItemType] += lot.Remaining
+		}
+	}
+	return balances, nil
+}
+
+func (r *memoryInventoryRepo) StartBoost(userID int, lotID *int, duration time.Duration, now time.Time) (*models.Boost, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	startsAt := now
+	for _, boost := range r.boosts {
+		if boost.UserID == userID && boost.EndsAt.After(startsAt) {
+			startsAt = boost.EndsAt
+		}
+	}
+	boost := models.Boost{BoostID: len(r.boosts) + 1, UserID: userID, LotID: lotID, StartsAt: startsAt, EndsAt: startsAt.Add(duration)}
+	r.boosts = append(r.boosts, boost)
+	return &boost, nil
+}
+
+func (r *memoryInventoryRepo) GetActiveBoost(userID int, now time.Time) (*models.Boost, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, boost := range r.boosts {
+		if boost.UserID == userID && boost.EndsAt.After(now) {
+			return &boost, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryInventoryRepo) GetBoostedAmong(userIDs []int, now time.Time) (map[int]bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	boosted := make(map[int]bool)
+	for _, boost := range r.boosts {
+		if !boost.StartsAt.After(now) && boost.EndsAt.After(now) {
+			boosted[boost.UserID] = true
+		}
+	}
+	return boosted, nil
+}
+
+// newInventoryFixture returns an inventory service for free users, or for the user with the plan
+func newInventoryFixture(plan *models.Plan, userID int) (*InventoryService, *memoryInventoryRepo) {
+	subscriptions := &memorySubscriptionRepo{}
+	if plan != nil {
+		subscriptions.plans = []models.Plan{*plan}
+		subscriptions.subscriptions = []*models.Subscription{{
+			SubscriptionID:   1,
+			UserID:           userID,
+			PlanID:           plan.PlanID,
+			Status:           models.SubscriptionStatusActive,
+			CurrentPeriodEnd: time.Now().AddDate(0, 1, 0),
+		}}
+	}
+	redis := &mocks.MockRedisHandler{
+		GetFunc: func(key string, dest interface{}) error { return errors.New("cache miss") },
+	}
+
+	inventoryRepo := &memoryInventoryRepo{}
+	return NewInventoryService(memoryUserRepo{}, inventoryRepo, NewEntitlementsService(subscriptions, redis)), inventoryRepo
+}
+
+func TestInventoryService_AllowancesArePerPeriod(t *testing.T) {
+	service, _ := newInventoryFixture(nil, 0)
+	// Sunday evening: the daily and weekly allowances both run out at midnight
+	now := time.Date(2024, 3, 10, 22, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+
+	for i := 0; i < models.FreeSuperLikesPerWeek; i++ {
+		if _, err := service.Consume(5, models.ItemSuperLike); err != nil {
+			t.Fatalf("super like %d failed: %v", i+1, err)
+		}
+	}
+	if _, err := service.Consume(5, models.ItemSuperLike); !errors.Is(err, ErrNoItemsLeft) {
+		t.Fatalf("expected the weekly super likes to run out, got %v", err)
+	}
+	if _, err := service.Consume(5, models.ItemBoost); !errors.Is(err, ErrNoItemsLeft) {
+		t.Fatalf("expected free users to have no boosts, got %v", err)
+	}
+
+	// Asking again within the week does not grant the allowance twice
+	wallet, err := service.Wallet(5)
+	if err != nil {
+		t.Fatalf("wallet failed: %v", err)
+	}
+	if wallet.Balances[models.ItemSuperLike] != 0 || wallet.Balances[models.ItemRewind] != models.FreeRewindsPerDay {
+		t.Fatalf("unexpected balances %v", wallet.Balances)
+	}
+
+	// A new ISO week brings a new allowance, and unused rewinds do not roll over
+	now = now.Add(3 * time.Hour)
+	wallet, err = service.Wallet(5)
+	if err != nil {
+		t.Fatalf("wallet failed: %v", err)
+	}
+	if wallet.Balances[models.ItemSuperLike] != models.FreeSuperLikesPerWeek || wallet.Balances[models.ItemRewind] != models.FreeRewindsPerDay {
+		t.Fatalf("expected fresh allowances on Monday, got %v", wallet.Balances)
+	}
+}
+
+func TestInventoryService_ConsumesExpiringItemsFirst(t *testing.T) {
+	service, inventoryRepo := newInventoryFixture(nil, 0)
+	inventoryRepo.packs = []models.ConsumablePack{{PackID: 1, Code: "rewind_10", ItemType: models.ItemRewind, Quantity: 10, IsActive: true}}
+	if err := service.GrantPurchase(5, "rewind_10", "purchase:stripe:pi_1"); err != nil {
+		t.Fatalf("grant failed: %v", err)
+	}
+	// Granting the same purchase again changes nothing
+	if err := service.GrantPurchase(5, "rewind_10", "purchase:stripe:pi_1"); err != nil {
+		t.Fatalf("second grant failed: %v", err)
+	}
+
+	credit, err := service.Consume(5, models.ItemRewind)
+	if err != nil {
+		t.Fatalf("rewind failed: %v", err)
+	}
+	wallet, _ := service.Wallet(5)
+	if got, want := wallet.Balances[models.ItemRewind], 10+models.FreeRewindsPerDay-1; got != want {
+		t.Fatalf("expected %d rewinds, got %d", want, got)
+	}
+	for _, lot := range inventoryRepo.lots {
+		if lot.Source == "purchase:stripe:pi_1" && lot.Remaining != 10 {
+			t.Fatalf("expected the daily allowance to be used before the purchased rewinds, got %+v", lot)
+		}
+	}
+
+	// A refunded credit goes back to the lot it came from
+	if err := service.Refund(credit); err != nil {
+		t.Fatalf("refund failed: %v", err)
+	}
+	wallet, _ = service.Wallet(5)
+	if got, want := wallet.Balances[models.ItemRewind], 10+models.FreeRewindsPerDay; got != want {
+		t.Fatalf("expected %d rewinds after the refund, got %d", want, got)
+	}
+
+	// Revoking a purchase takes back what is left of it
+	if err := service.RevokePurchase(5, "purchase:stripe:pi_1"); err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	wallet, _ = service.Wallet(5)
+	if got := wallet.Balances[models.ItemRewind]; got != models.FreeRewindsPerDay {
+		t.Fatalf("expected only the daily rewinds after the revocation, got %d", got)
+	}
+}
+
+func TestInventoryService_UnlimitedRewindsAndBoosts(t *testing.T) {
+	gold := &models.Plan{PlanID: 2, Code: "gold", Rank: 2, UnlimitedLikes: true, RewindsPerDay: models.UnlimitedQuota, BoostsPerMonth: 2, SuperLikesPerWeek: 5, IsActive: true}
+	service, _ := newInventoryFixture(gold, 8)
+	now := time.Date(2024, 3, 12, 12, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+
+	for i := 0; i < 20; i++ {
+		credit, err := service.Consume(8, models.ItemRewind)
+		if err != nil || credit.LotID != 0 {
+			t.Fatalf("expected unlimited rewinds to be free, got %+v, %v", credit, err)
+		}
+	}
+
+	// Boosts used back to back run one after the other
+	first, err := service.StartBoost(8)
+	if err != nil {
+		t.Fatalf("first boost failed: %v", err)
+	}
+	second, err := service.StartBoost(8)
+	if err != nil {
+		t.Fatalf("second boost failed: %v", err)
+	}
+	if !second.StartsAt.Equal(first.EndsAt) || second.EndsAt.Sub(second.StartsAt) != models.BoostDuration {
+		t.Fatalf("expected the second boost to follow the first, got %+v then %+v", first, second)
+	}
+	if _, err := service.StartBoost(8); !errors.Is(err, ErrNoItemsLeft) {
+		t.Fatalf("expected the monthly boosts to run out, got %v", err)
+	}
+
+	wallet, err := service.Wallet(8)
+	if err != nil {
+		t.Fatalf("wallet failed: %v", err)
+	}
+	if wallet.Balances[models.ItemRewind] != models.UnlimitedQuota || wallet.Balances[models.ItemSuperLike] != 5 || wallet.ActiveBoost == nil {
+		t.Fatalf("unexpected wallet %+v", wallet)
+	}
+}
@@ -0,0 +1,131 @@
+// services/likes_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// likePreviewLinkTTL is how long the blurred preview links given to free users stay valid
+const likePreviewLinkTTL = time.Hour
+
+// ErrLikeNotFound is returned for a blurred preview of a swipe that is not a like
+var ErrLikeNotFound = errors.New("like not found")
+
+// LikesReceived is a page of the users who liked the viewer and are not matched with them yet.
+// Blurred is set when the viewer's plan does not include seeing who likes them.
+type LikesReceived struct {
+	Total    int64          `json:"total"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"pageSize"`
+	Blurred  bool           `json:"blurred"`
+	Likes    []ReceivedLike `json:"likes"`
+}
+
+// ReceivedLike is one user who liked the viewer: their profile for viewers who may see who likes
+// them, otherwise only a signed link to a blurred preview of their photo
+type ReceivedLike struct {
+	Super      bool           `json:"super"`
+	LikedAt    *time.Time     `json:"likedAt,omitempty"`
+	Profile    *PublicProfile `json:"profile,omitempty"`
+	PreviewURL string         `json:"previewURL,omitempty"`
+}
+
+// LikesService lists the likes a user has received, as far as their plan lets them see them
+type LikesService struct {
+	swipeRepo            repository.SwipeHistoryRepository
+	publicProfileService *PublicProfileService
+	photoService         *PhotoService
+	entitlementsService  *EntitlementsService
+}
+
+// NewLikesService creates a new instance of LikesService
+func NewLikesService(
+	swipeRepo repository.SwipeHistoryRepository,
+	publicProfileService *PublicProfileService,
+	photoService *PhotoService,
+	entitlementsService *EntitlementsService,
+) *LikesService {
+	return &LikesService{
+		swipeRepo:            swipeRepo,
+		publicProfileService: publicProfileService,
+		photoService:         photoService,
+		entitlementsService:  entitlementsService,
+	}
+}
+
+// LikesReceived returns a page of the users who liked the viewer, most recent first. Users the
+// viewer has matched with drop out of the list.
+func (s *LikesService) LikesReceived(userID, page, pageSize int) (*LikesReceived, error) {
+	entitlements, err := s.entitlementsService.Entitlements(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	likes, total, err := s.swipeRepo.GetLikesReceived(userID, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &LikesReceived{
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+		Blurred:  !entitlements.SeeWhoLikesYou,
+		Likes:    make([]ReceivedLike, 0, len(likes)),
+	}
+
+	if response.Blurred {
+		expiresAt := time.Now().Add(likePreviewLinkTTL)
+		for _, like := range likes {
+			response.Likes = append(response.Likes, ReceivedLike{
+				Super:      like.Super,
+				PreviewURL: helpers.SignURL(LikePreviewPath(like.LikeID), expiresAt),
+			})
+		}
+		return response, nil
+	}
+
+	likerIDs := make([]int, 0, len(likes))
+	for _, like := range likes {
+		likerIDs = append(likerIDs, like.UserID)
+	}
+	profiles, err := s.publicProfileService.GetPublicProfiles(userID, likerIDs, ViewerTierFor(entitlements))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, like := range likes {
+		profile, ok := profiles[like.UserID]
+		if !ok {
+			continue
+		}
+		likedAt := like.LikedAt
+		response.Likes = append(response.Likes, ReceivedLike{
+			Super:   like.Super,
+			LikedAt: &likedAt,
+			Profile: profile,
+		})
+	}
+	return response, nil
+}
+
+// BlurredPreview returns the blurred photo preview of the user who made the like
+func (s *LikesService) BlurredPreview(likeID int) ([]byte, error) {
+	swipe, err := s.swipeRepo.GetSwipeByID(likeID)
+	if err != nil || !models.IsLike(swipe.SwipeDirection) {
+		return nil, ErrLikeNotFound
+	}
+	return s.photoService.BlurredPreview(swipe.SwiperUserID)
+}
+
+// LikePreviewPath returns the API path serving the blurred preview of a like. It names the like
+// rather than the liker, so the link does not give away who it is.
+func LikePreviewPath(likeID int) string {
+	return fmt.Sprintf("/likes/previews/%d", likeID)
+}
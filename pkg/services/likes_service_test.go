@@ -0,0 +1,164 @@
+// services/likes_service_test.go
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+func (r *memorySwipeRepo) GetLikesReceived(userID, page, pageSize int) ([]repository.LikeReceived, int64, error) {
+	var likes []repository.LikeReceived
+	for _, swipe := range r.swipes {
+		if swipe.SwipedUserID == userID && models.IsLike(swipe.SwipeDirection) && !swipe.IsMatched {
+			likes = append(likes, repository.LikeReceived{
+				LikeID:  swipe.SwipeHistoryEntityID,
+				UserID:  swipe.SwiperUserID,
+				Super:   swipe.SwipeDirection == models.SwipeDirectionSuper,
+				LikedAt: swipe.Timestamp,
+			})
+		}
+	}
+	sort.SliceStable(likes, func(i, j int) bool { return likes[i].LikedAt.After(likes[j].LikedAt) })
+
+	total := int64(len(likes))
+	start := minInt((page-1)*pageSize, len(likes))
+	return likes[start:minInt(start+pageSize, len(likes))], total, nil
+}
+
+func (r *memorySwipeRepo) GetSwipeByID(swipeID int) (*models.SwipeHistory, error) {
+	for _, swipe := range r.swipes {
+		if swipe.SwipeHistoryEntityID == swipeID {
+			return &swipe, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// likesPhotoRepo gives user 2 a single photo
+type likesPhotoRepo struct {
+	repository.PhotoRepository
+}
+
+func (likesPhotoRepo) GetPhotosByUserID(userID int) ([]models.Photo, error) {
+	if userID != 2 {
+		return nil, nil
+	}
+	return []models.Photo{{PhotoID: 1, UserID: 2, StorageKey: "photos/2/1.jpg", ThumbnailKey: "photos/2/1_thumb.jpg"}}, nil
+}
+
+// newLikesPhotoService returns a photo service storing blobs in a temporary directory, holding
+// the thumbnail of user 2's photo
+func newLikesPhotoService(t *testing.T) *PhotoService {
+	t.Helper()
+	blobStore := helpers.NewFileSystemBlobStore(t.TempDir())
+	thumbnail := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for x := 0; x < 32; x++ {
+		for y := 0; y < 32; y++ {
+			thumbnail.SetRGBA(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, thumbnail, nil); err != nil {
+		t.Fatalf("encoding the thumbnail failed: %v", err)
+	}
+	if err := blobStore.Put("photos/2/1_thumb.jpg", &encoded, "image/jpeg"); err != nil {
+		t.Fatalf("storing the thumbnail failed: %v", err)
+	}
+	return NewPhotoService(likesPhotoRepo{}, blobStore, &mocks.MockRedisHandler{})
+}
+
+// newLikesFixture returns a likes service for user 1 on the plan, with likes from users 2 and 3,
+// a pass from user 4 and a match with user 5
+func newLikesFixture(t *testing.T, plan *models.Plan) (*LikesService, *publicProfileFixture) {
+	t.Helper()
+	inventoryService, _ := newInventoryFixture(plan, 1)
+	f := newProjectionFixture()
+	now := time.Now()
+	f.swipes.swipes = []models.SwipeHistory{
+		{SwipeHistoryEntityID: 11, SwiperUserID: 2, SwipedUserID: 1, SwipeDirection: models.SwipeDirectionRight, Timestamp: now.Add(-time.Hour)},
+		{SwipeHistoryEntityID: 12, SwiperUserID: 3, SwipedUserID: 1, SwipeDirection: models.SwipeDirectionSuper, Timestamp: now.Add(-time.Minute)},
+		{SwipeHistoryEntityID: 13, SwiperUserID: 4, SwipedUserID: 1, SwipeDirection: models.SwipeDirectionLeft, Timestamp: now},
+		{SwipeHistoryEntityID: 14, SwiperUserID: 5, SwipedUserID: 1, SwipeDirection: models.SwipeDirectionRight, Timestamp: now, IsMatched: true},
+	}
+	for userID := 2; userID <= 5; userID++ {
+		f.profiles.profiles[userID] = &models.Profile{UserID: userID, Photos: models.StringList{"/photos/1"}}
+	}
+	return NewLikesService(f.swipes, f.service, newLikesPhotoService(t), inventoryService.entitlementsService), f
+}
+
+func TestLikesService_FreeUsersOnlyGetBlurredPreviews(t *testing.T) {
+	t.Setenv(helpers.URLSigningKeyEnv, "test-url-signing-key")
+	service, _ := newLikesFixture(t, nil)
+
+	likes, err := service.LikesReceived(1, 1, 10)
+	if err != nil {
+		t.Fatalf("likes received failed: %v", err)
+	}
+	if !likes.Blurred || likes.Total != 2 || len(likes.Likes) != 2 {
+		t.Fatalf("expected 2 blurred likes, got %+v", likes)
+	}
+	if !likes.Likes[0].Super || likes.Likes[1].Super {
+		t.Errorf("expected the super like first, got %+v", likes.Likes)
+	}
+
+	// Each like only has a signed link to its preview, which names the like and not the liker
+	for i, like := range likes.Likes {
+		if like.Profile != nil || like.LikedAt != nil {
+			t.Fatalf("expected no profile or time for a blurred like, got %+v", like)
+		}
+		link, err := url.Parse(like.PreviewURL)
+		if err != nil {
+			t.Fatalf("invalid preview link %q: %v", like.PreviewURL, err)
+		}
+		if want := LikePreviewPath(12 - i); link.Path != want {
+			t.Errorf("expected the preview link %s, got %s", want, link.Path)
+		}
+		query := link.Query()
+		if err := helpers.VerifySignedURL(link.Path, query.Get(helpers.ExpiresParam), query.Get(helpers.SignatureParam)); err != nil {
+			t.Errorf("expected a valid signed link, got %v", err)
+		}
+	}
+
+	// The preview of user 2's like is their blurred photo; a pass has no preview
+	if preview, err := service.BlurredPreview(11); err != nil || len(preview) == 0 {
+		t.Errorf("expected a blurred preview, got %d bytes (%v)", len(preview), err)
+	}
+	if _, err := service.BlurredPreview(13); !errors.Is(err, ErrLikeNotFound) {
+		t.Errorf("expected no preview for a pass, got %v", err)
+	}
+	if _, err := service.BlurredPreview(99); !errors.Is(err, ErrLikeNotFound) {
+		t.Errorf("expected no preview for an unknown swipe, got %v", err)
+	}
+}
+
+func TestLikesService_PremiumUsersSeeWhoLikesThem(t *testing.T) {
+	gold := &models.Plan{PlanID: 2, Code: "gold", Name: "Gold", Rank: 2, PeriodMonths: 1, SeeWhoLikesYou: true, IsActive: true}
+	service, f := newLikesFixture(t, gold)
+	// User 3 has blocked the viewer since liking them
+	f.blocks.blocks = [][2]int{{3, 1}}
+
+	likes, err := service.LikesReceived(1, 1, 10)
+	if err != nil {
+		t.Fatalf("likes received failed: %v", err)
+	}
+	if likes.Blurred || likes.Total != 2 || len(likes.Likes) != 1 {
+		t.Fatalf("expected user 2's like only, got %+v", likes)
+	}
+	like := likes.Likes[0]
+	if like.Profile == nil || like.Profile.UserID != 2 || like.LikedAt == nil || like.PreviewURL != "" {
+		t.Fatalf("expected user 2's profile and like time, got %+v", like)
+	}
+}
@@ -0,0 +1,210 @@
+// services/location_retention.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// locationRetentionLockKey is the Redis key of the lock electing the instance that runs the
+// retention job
+const locationRetentionLockKey = "lock:location-retention"
+
+// Environment variables overriding DefaultLocationRetentionPolicy
+const (
+	LocationHistoryKeepLatestEnv    = "LOCATION_HISTORY_KEEP_LATEST"
+	LocationHistoryCoarseCellKmEnv  = "LOCATION_HISTORY_COARSE_CELL_KM"
+	LocationHistoryRetentionDaysEnv = "LOCATION_HISTORY_RETENTION_DAYS"
+	LocationPurgeBatchSizeEnv       = "LOCATION_PURGE_BATCH_SIZE"
+	LocationPurgeIntervalEnv        = "LOCATION_PURGE_INTERVAL"
+)
+
+// LocationRetentionPolicy decides how much location history is kept: each user's latest points
+// as reported, older points only as coarse cells, and nothing older than the retention period
+type LocationRetentionPolicy struct {
+	KeepLatest    int           `json:"keepLatest"`
+	CoarseCellKm  float64       `json:"coarseCellKm"`
+	RetentionDays int           `json:"retentionDays"`
+	BatchSize     int           `json:"-"`
+	Interval      time.Duration `json:"-"`
+}
+
+// DefaultLocationRetentionPolicy returns the policy used when none is configured
+func DefaultLocationRetentionPolicy() LocationRetentionPolicy {
+	return LocationRetentionPolicy{
+		KeepLatest:    20,
+		CoarseCellKm:  10,
+		RetentionDays: 90,
+		BatchSize:     1000,
+		Interval:      time.Hour,
+	}
+}
+
+// LocationRetentionPolicyFromEnv reads the policy from the environment, keeping the default for
+// any value that is unset or invalid
+func LocationRetentionPolicyFromEnv() LocationRetentionPolicy {
+	policy := DefaultLocationRetentionPolicy()
+	policy.KeepLatest = positiveIntEnv(LocationHistoryKeepLatestEnv, policy.KeepLatest)
+	policy.RetentionDays = positiveIntEnv(LocationHistoryRetentionDaysEnv, policy.RetentionDays)
+	policy.BatchSize = positiveIntEnv(LocationPurgeBatchSizeEnv, policy.BatchSize)
+
+	if value := os.Getenv(LocationHistoryCoarseCellKmEnv); value != "" {
+		if cellKm, err := strconv.ParseFloat(value, 64); err == nil && cellKm > 0 {
+			policy.CoarseCellKm = cellKm
+		} else {
+			log.Printf("Invalid %s %q, using %v km", LocationHistoryCoarseCellKmEnv, value, policy.CoarseCellKm)
+		}
+	}
+	if value := os.Getenv(LocationPurgeIntervalEnv); value != "" {
+		if interval, err := time.ParseDuration(value); err == nil && interval > 0 {
+			policy.Interval = interval
+		} else {
+			log.Printf("Invalid %s %q, using %v", LocationPurgeIntervalEnv, value, policy.Interval)
+		}
+	}
+	return policy
+}
+
+func positiveIntEnv(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 1 {
+		log.Printf("Invalid %s %q, using %d", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// Cutoff returns the time before which points are deleted
+func (p LocationRetentionPolicy) Cutoff(now time.Time) time.Time {
+	return now.AddDate(0, 0, -p.RetentionDays)
+}
+
+// LocationRetentionReport counts the rows changed by one retention run
+type LocationRetentionReport struct {
+	Purged    int64
+	Fuzzed    int64
+	Coarsened int64
+	Merged    int64
+}
+
+// LocationRetentionJob applies the retention policy to the location history in small batches, so
+// the table stays available to writers while it runs. Only one instance runs it at a time.
+type LocationRetentionJob struct {
+	locationRepo repository.LocationRepository
+	lock         *helpers.LeaderLock
+	policy       LocationRetentionPolicy
+	pause        time.Duration
+	now          func() time.Time
+}
+
+// NewLocationRetentionJob creates a new instance of LocationRetentionJob
+func NewLocationRetentionJob(locationRepo repository.LocationRepository, redis helpers.RedisHandler, policy LocationRetentionPolicy) *LocationRetentionJob {
+	// The lease outlives a few missed runs, so a slow run keeps the lock but a dead leader is
+	// replaced soon
+	return &LocationRetentionJob{
+		locationRepo: locationRepo,
+		lock:         helpers.NewLeaderLock(redis, locationRetentionLockKey, 3*policy.Interval),
+		policy:       policy,
+		pause:        100 * time.Millisecond,
+		now:          time.Now,
+	}
+}
+
+// Start runs the job immediately and then on every interval while this instance is the leader,
+// until the context is cancelled
+func (j *LocationRetentionJob) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.policy.Interval)
+	defer ticker.Stop()
+	defer j.lock.Release()
+
+	for {
+		if j.lock.Hold() {
+			report, err := j.RunOnce(ctx)
+			if err != nil {
+				log.Printf("Location retention failed: %v", err)
+			} else if report.Purged+report.Fuzzed+report.Coarsened+report.Merged > 0 {
+				log.Printf("Location retention purged %d, fuzzed %d, coarsened %d and merged %d points", report.Purged, report.Fuzzed, report.Coarsened, report.Merged)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce deletes expired points, fuzzes positions stored exact before positions were fuzzed, then
+// walks the history by primary key to coarsen and merge old ones, a batch at a time until nothing is
+// left to do. It stops early if the leadership is lost.
+func (j *LocationRetentionJob) RunOnce(ctx context.Context) (LocationRetentionReport, error) {
+	var report LocationRetentionReport
+	cutoff := j.policy.Cutoff(j.now())
+	// walk turns a batch of a walk by primary key into a step that carries on after the last key
+	// the batch covered
+	walk := func(batch func(afterID, batchSize int) (int64, int, error)) func() (int64, bool, error) {
+		afterID := 0
+		return func() (int64, bool, error) {
+			changed, lastID, err := batch(afterID, j.policy.BatchSize)
+			afterID = lastID
+			return changed, lastID > 0, err
+		}
+	}
+
+	// Each step reports what it changed and whether there is more to do
+	steps := []struct {
+		total *int64
+		run   func() (int64, bool, error)
+	}{
+		{&report.Purged, func() (int64, bool, error) {
+			purged, err := j.locationRepo.PurgeLocationsBefore(cutoff, j.policy.BatchSize)
+			return purged, purged > 0, err
+		}},
+		{&report.Fuzzed, walk(j.locationRepo.FuzzLocationHistory)},
+		{&report.Fuzzed, walk(j.locationRepo.FuzzCurrentLocations)},
+		{&report.Fuzzed, walk(j.locationRepo.FuzzPassports)},
+		{&report.Coarsened, walk(func(afterID, batchSize int) (int64, int, error) {
+			return j.locationRepo.CoarsenLocationHistory(j.policy.KeepLatest, j.policy.CoarseCellKm, afterID, batchSize)
+		})},
+		{&report.Merged, walk(j.locationRepo.MergeCoarseLocations)},
+	}
+
+	for _, step := range steps {
+		for {
+			changed, more, err := step.run()
+			*step.total += changed
+			if err != nil {
+				return report, err
+			}
+			if !more {
+				break
+			}
+
+			// Give other writers room between batches; a cancelled run stops even when no pause is set
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			select {
+			case <-ctx.Done():
+				return report, ctx.Err()
+			case <-time.After(j.pause):
+			}
+			if !j.lock.Hold() {
+				return report, fmt.Errorf("lost the %s lock", locationRetentionLockKey)
+			}
+		}
+	}
+	return report, nil
+}
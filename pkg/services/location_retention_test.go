@@ -0,0 +1,337 @@
+// services/location_retention_test.go
+package services
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// retentionLocationRepo keeps the location history, current locations and passports in primary key
+// order and applies retention to them like the database one. afterIDs records where each coarsening
+// batch started.
+type retentionLocationRepo struct {
+	repository.LocationRepository
+	points    []models.LocationHistory
+	current   []models.CurrentLocation
+	passports []models.Passport
+	afterIDs  []int
+}
+
+func (r *retentionLocationRepo) PurgeLocationsBefore(cutoff time.Time, batchSize int) (int64, error) {
+	var kept []models.LocationHistory
+	var purged int64
+	for _, point := range r.points {
+		if point.Timestamp.Before(cutoff) && purged < int64(batchSize) {
+			purged++
+			continue
+		}
+		kept = append(kept, point)
+	}
+	r.points = kept
+	return purged, nil
+}
+
+// batch returns the indexes of up to batchSize points after afterID, and the last ID among them
+func (r *retentionLocationRepo) batch(afterID, batchSize int) ([]int, int) {
+	var indexes []int
+	lastID := 0
+	for i, point := range r.points {
+		if point.LocationID > afterID && len(indexes) < batchSize {
+			indexes = append(indexes, i)
+			lastID = point.LocationID
+		}
+	}
+	return indexes, lastID
+}
+
+func (r *retentionLocationRepo) CoarsenLocationHistory(keepLatest int, cellKm float64, afterID, batchSize int) (int64, int, error) {
+	r.afterIDs = append(r.afterIDs, afterID)
+	indexes, lastID := r.batch(afterID, batchSize)
+
+	var coarsened int64
+	for _, i := range indexes {
+		point := &r.points[i]
+		newer := 0
+		for _, other := range r.points {
+			if other.UserID == point.UserID && (other.Timestamp.After(point.Timestamp) ||
+				(other.Timestamp.Equal(point.Timestamp) && other.LocationID > point.LocationID)) {
+				newer++
+			}
+		}
+		if point.IsCoarse || newer < keepLatest {
+			continue
+		}
+		point.Latitude = math.Floor(point.Latitude*10)/10 + 0.05
+		point.Longitude = math.Floor(point.Longitude*10)/10 + 0.05
+		point.IsCoarse = true
+		coarsened++
+	}
+	return coarsened, lastID, nil
+}
+
+// fuzzBatch fuzzes up to batchSize of the positions after afterID that are not marked fuzzed, given
+// their keys in order and whether each is to be fuzzed, and returns how many it fuzzed and the last
+// key among them
+func fuzzBatch(keys []int, exact func(i int) bool, position func(i int) (int, *float64, *float64, *bool), afterID, batchSize int) (int64, int) {
+	var fuzzed int64
+	lastID := 0
+	for i, key := range keys {
+		if key <= afterID || !exact(i) || fuzzed == int64(batchSize) {
+			continue
+		}
+		userID, latitude, longitude, isFuzzed := position(i)
+		*latitude, *longitude = helpers.FuzzLocation(userID, *latitude, *longitude)
+		*isFuzzed = true
+		fuzzed++
+		lastID = key
+	}
+	return fuzzed, lastID
+}
+
+func (r *retentionLocationRepo) FuzzLocationHistory(afterID, batchSize int) (int64, int, error) {
+	keys := make([]int, len(r.points))
+	for i, point := range r.points {
+		keys[i] = point.LocationID
+	}
+	fuzzed, lastID := fuzzBatch(keys, func(i int) bool { return !r.points[i].IsFuzzed && !r.points[i].IsCoarse },
+		func(i int) (int, *float64, *float64, *bool) {
+			point := &r.points[i]
+			return point.UserID, &point.Latitude, &point.Longitude, &point.IsFuzzed
+		}, afterID, batchSize)
+	return fuzzed, lastID, nil
+}
+
+func (r *retentionLocationRepo) FuzzCurrentLocations(afterUserID, batchSize int) (int64, int, error) {
+	keys := make([]int, len(r.current))
+	for i, current := range r.current {
+		keys[i] = current.UserID
+	}
+	fuzzed, lastID := fuzzBatch(keys, func(i int) bool { return !r.current[i].IsFuzzed },
+		func(i int) (int, *float64, *float64, *bool) {
+			current := &r.current[i]
+			return current.UserID, &current.Latitude, &current.Longitude, &current.IsFuzzed
+		}, afterUserID, batchSize)
+	return fuzzed, lastID, nil
+}
+
+func (r *retentionLocationRepo) FuzzPassports(afterUserID, batchSize int) (int64, int, error) {
+	keys := make([]int, len(r.passports))
+	for i, passport := range r.passports {
+		keys[i] = passport.UserID
+	}
+	fuzzed, lastID := fuzzBatch(keys, func(i int) bool { return !r.passports[i].IsFuzzed },
+		func(i int) (int, *float64, *float64, *bool) {
+			passport := &r.passports[i]
+			return passport.UserID, &passport.Latitude, &passport.Longitude, &passport.IsFuzzed
+		}, afterUserID, batchSize)
+	return fuzzed, lastID, nil
+}
+
+func (r *retentionLocationRepo) MergeCoarseLocations(afterID, batchSize int) (int64, int, error) {
+	indexes, lastID := r.batch(afterID, batchSize)
+
+	merged := map[int]bool{}
+	for _, i := range indexes {
+		point := r.points[i]
+		for _, earlier := range r.points {
+			if point.IsCoarse && earlier.IsCoarse && earlier.UserID == point.UserID && earlier.LocationID != point.LocationID &&
+				earlier.Timestamp.Before(point.Timestamp) && earlier.Timestamp.Format("2006-01-02") == point.Timestamp.Format("2006-01-02") &&
+				earlier.Latitude == point.Latitude && earlier.Longitude == point.Longitude {
+				merged[point.LocationID] = true
+			}
+		}
+	}
+
+	var kept []models.LocationHistory
+	for _, point := range r.points {
+		if !merged[point.LocationID] {
+			kept = append(kept, point)
+		}
+	}
+	r.points = kept
+	return int64(len(merged)), lastID, nil
+}
+
+func newRetentionFixture(now time.Time) (*LocationRetentionJob, *retentionLocationRepo, *mocks.MockRedisHandler) {
+	// User 1 reported five points this morning, user 2 two; one point of user 2 is long expired
+	locations := &retentionLocationRepo{}
+	add := func(userID int, latitude float64, at time.Time) {
+		locations.points = append(locations.points, models.LocationHistory{
+			LocationID: len(locations.points) + 1, UserID: userID, Latitude: latitude, Longitude: 77.51, Timestamp: at, IsFuzzed: true,
+		})
+	}
+	morning := time.Date(now.Year(), now.Month(), now.Day(), 8, 0, 0, 0, time.UTC)
+	add(2, 12.91, now.AddDate(0, 0, -100))
+	for i := 0; i < 5; i++ {
+		add(1, 12.91+float64(i)*0.01, morning.Add(time.Duration(i)*time.Minute))
+		if i < 2 {
+			add(2, 13.5, morning.Add(time.Duration(i)*time.Minute))
+		}
+	}
+
+	redis := &mocks.MockRedisHandler{}
+	policy := LocationRetentionPolicy{KeepLatest: 2, CoarseCellKm: 10, RetentionDays: 90, BatchSize: 2, Interval: time.Hour}
+	job := NewLocationRetentionJob(locations, redis, policy)
+	job.pause = 0
+	job.now = func() time.Time { return now }
+	return job, locations, redis
+}
+
+func TestLocationRetentionJob_WalksTheHistoryInBatches(t *testing.T) {
+	now := time.Date(2024, 3, 11, 12, 0, 0, 0, time.UTC)
+	job, locations, _ := newRetentionFixture(now)
+
+	report, err := job.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("retention failed: %v", err)
+	}
+	// User 1's three oldest points fall in one cell, so two of them repeat the first
+	if report != (LocationRetentionReport{Purged: 1, Coarsened: 3, Merged: 2}) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(locations.points) != 5 {
+		t.Fatalf("expected 5 points left, got %+v", locations.points)
+	}
+	for _, point := range locations.points {
+		if point.IsCoarse != (point.UserID == 1 && point.LocationID == 2) {
+			t.Errorf("expected only user 1's oldest point to stay coarse, got %+v", point)
+		}
+	}
+
+	// Each batch carried on after the last ID of the one before, until a batch came back empty
+	want := []int{0, 3, 5, 7, 8}
+	if len(locations.afterIDs) != len(want) {
+		t.Fatalf("expected batches after %v, got %v", want, locations.afterIDs)
+	}
+	for i := range want {
+		if locations.afterIDs[i] != want[i] {
+			t.Fatalf("expected batches after %v, got %v", want, locations.afterIDs)
+		}
+	}
+
+	// A second run changes nothing
+	if report, _ := job.RunOnce(context.Background()); report != (LocationRetentionReport{}) {
+		t.Errorf("expected nothing left to do, got %+v", report)
+	}
+}
+
+func TestLocationRetentionJob_OnlyTheLeaderRuns(t *testing.T) {
+	now := time.Date(2024, 3, 11, 12, 0, 0, 0, time.UTC)
+	job, locations, redis := newRetentionFixture(now)
+
+	var released int
+	redis.AcquireLockFunc = func(key, token string, ttl time.Duration) (bool, error) {
+		return false, nil
+	}
+	redis.ReleaseLockFunc = func(key, token string) error {
+		released++
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	job.Start(ctx)
+	if len(locations.points) != 8 || len(locations.afterIDs) != 0 {
+		t.Fatalf("expected an instance without the lock to change nothing, got %+v", locations.points)
+	}
+
+	var lockTTL time.Duration
+	redis.AcquireLockFunc = func(key, token string, ttl time.Duration) (bool, error) {
+		lockTTL = ttl
+		return key == locationRetentionLockKey, nil
+	}
+	job.Start(ctx)
+	// The cancelled context stops the leader after its first batch, which purges the expired point
+	if len(locations.points) != 7 || locations.points[0].LocationID != 2 {
+		t.Errorf("expected the leader to purge the expired point, got %+v", locations.points)
+	}
+	if lockTTL <= job.policy.Interval || released != 2 {
+		t.Errorf("expected a lease longer than the interval released on stop, got %v and %d releases", lockTTL, released)
+	}
+}
+
+func TestLocationRetentionJob_StopsWhenTheLockIsLost(t *testing.T) {
+	now := time.Date(2024, 3, 11, 12, 0, 0, 0, time.UTC)
+	job, locations, redis := newRetentionFixture(now)
+
+	holds := 0
+	redis.AcquireLockFunc = func(key, token string, ttl time.Duration) (bool, error) {
+		holds++
+		return holds <= 2, nil
+	}
+
+	report, err := job.RunOnce(context.Background())
+	if err == nil || !strings.Contains(err.Error(), locationRetentionLockKey) {
+		t.Fatalf("expected the run to stop on losing the lock, got %v", err)
+	}
+	if report.Merged != 0 || len(locations.afterIDs) != 2 {
+		t.Errorf("expected the run to stop after two coarsening batches, got %+v after %v", report, locations.afterIDs)
+	}
+}
+
+func TestLocationRetentionJob_FuzzesPositionsStoredExact(t *testing.T) {
+	now := time.Date(2024, 3, 11, 12, 0, 0, 0, time.UTC)
+	job, locations, _ := newRetentionFixture(now)
+	job.policy.KeepLatest = 10
+
+	// User 3's points were stored exact before positions were fuzzed, except a coarse one
+	exact := []models.LocationHistory{
+		{LocationID: 9, UserID: 3, Latitude: 12.97161, Longitude: 77.59456, Timestamp: now.Add(-3 * time.Hour)},
+		{LocationID: 10, UserID: 3, Latitude: 12.97342, Longitude: 77.60123, Timestamp: now.Add(-2 * time.Hour)},
+		{LocationID: 11, UserID: 3, Latitude: 12.98011, Longitude: 77.61587, Timestamp: now.Add(-time.Hour)},
+	}
+	coarse := models.LocationHistory{LocationID: 12, UserID: 4, Latitude: 13.05, Longitude: 77.55, Timestamp: now.Add(-time.Hour), IsCoarse: true}
+	locations.points = append(locations.points, append(exact, coarse)...)
+	locations.current = []models.CurrentLocation{
+		{UserID: 1, LocationID: 6, Latitude: 12.95, Longitude: 77.51, Timestamp: now, IsFuzzed: true},
+		{UserID: 3, LocationID: 11, Latitude: 12.98011, Longitude: 77.61587, Timestamp: now.Add(-time.Hour)},
+	}
+	locations.passports = []models.Passport{
+		{UserID: 1, PlaceName: "Paris", Latitude: 48.8566, Longitude: 2.3522, IsFuzzed: true},
+		{UserID: 3, Latitude: 40.71277, Longitude: -74.00597},
+	}
+
+	report, err := job.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("retention failed: %v", err)
+	}
+	if report.Fuzzed != 5 {
+		t.Fatalf("expected the three points, the current location and the passport fuzzed, got %+v", report)
+	}
+
+	for _, want := range exact {
+		latitude, longitude := helpers.FuzzLocation(3, want.Latitude, want.Longitude)
+		for _, point := range locations.points {
+			if point.LocationID == want.LocationID && (!point.IsFuzzed || point.Latitude != latitude || point.Longitude != longitude) {
+				t.Errorf("expected point %d fuzzed like a new report, got %+v", want.LocationID, point)
+			}
+		}
+	}
+	if point := locations.points[len(locations.points)-1]; point != coarse {
+		t.Errorf("expected the coarse point left as it was, got %+v", point)
+	}
+	// The current location is fuzzed to the same position as the point it was taken from
+	if current := locations.current[1]; !current.IsFuzzed || current.LocationHistory() != locations.points[len(locations.points)-2] {
+		t.Errorf("expected the current location to match its fuzzed point, got %+v", current)
+	}
+	if passport := locations.passports[0]; passport.Latitude != 48.8566 || passport.Longitude != 2.3522 {
+		t.Errorf("expected the city passport left on the city, got %+v", passport)
+	}
+	if latitude, longitude := helpers.FuzzLocation(3, 40.71277, -74.00597); !locations.passports[1].IsFuzzed ||
+		locations.passports[1].Latitude != latitude || locations.passports[1].Longitude != longitude {
+		t.Errorf("expected the passport fuzzed, got %+v", locations.passports[1])
+	}
+
+	// Fuzzed positions are not fuzzed again
+	if report, _ := job.RunOnce(context.Background()); report != (LocationRetentionReport{}) {
+		t.Errorf("expected nothing left to do, got %+v", report)
+	}
+}
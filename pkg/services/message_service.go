@@ -0,0 +1,92 @@
+// services/message_service.go
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// maxMessageLength caps the length of a direct message, in characters
+const maxMessageLength = 2000
+
+var (
+	// ErrCannotMessage is returned when the sender may not message the receiver
+	ErrCannotMessage = errors.New("you can only message users you have matched with")
+	// ErrInvalidMessage is returned for an empty or overlong message
+	ErrInvalidMessage = errors.New("message must be between 1 and 2000 characters")
+)
+
+// MessageService sends direct messages between users
+type MessageService struct {
+	messageRepo         repository.MessageRepository
+	swipeRepo           repository.SwipeHistoryRepository
+	blockRepo           repository.BlockRepository
+	entitlementsService *EntitlementsService
+	now                 func() time.Time
+}
+
+// NewMessageService creates a new instance of MessageService
+func NewMessageService(
+	messageRepo repository.MessageRepository,
+	swipeRepo repository.SwipeHistoryRepository,
+	blockRepo repository.BlockRepository,
+	entitlementsService *EntitlementsService,
+) *MessageService {
+	return &MessageService{
+		messageRepo:         messageRepo,
+		swipeRepo:           swipeRepo,
+		blockRepo:           blockRepo,
+		entitlementsService: entitlementsService,
+		now:                 time.Now,
+	}
+}
+
+// SendMessage sends a message to a user the sender has matched with, or to anyone they have not
+// blocked or been blocked by when their plan allows messaging before a match
+func (s *MessageService) SendMessage(senderUserID, receiverUserID int, content string) (*models.Message, error) {
+	content = strings.TrimSpace(content)
+	if content == "" || len([]rune(content)) > maxMessageLength {
+		return nil, ErrInvalidMessage
+	}
+	if senderUserID == receiverUserID {
+		return nil, ErrCannotMessage
+	}
+
+	blocked, err := s.blockRepo.IsBlocked(senderUserID, receiverUserID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, ErrCannotMessage
+	}
+
+	matched, err := s.swipeRepo.AreMatched(senderUserID, receiverUserID)
+	if err != nil {
+		return nil, err
+	}
+	if !matched {
+		entitlements, err := s.entitlementsService.Entitlements(senderUserID)
+		if err != nil {
+			return nil, err
+		}
+		if !entitlements.MessageBeforeMatch {
+			return nil, ErrCannotMessage
+		}
+	}
+
+	message := &models.Message{
+		SenderUserID:   senderUserID,
+		ReceiverUserID: receiverUserID,
+		MessageContent: content,
+		Timestamp:      s.now(),
+	}
+	// The repository records the MessageSent event that notifies the receiver
+	if err := s.messageRepo.CreateMessage(message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
@@ -0,0 +1,128 @@
+// services/message_service_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+func (r *memorySwipeRepo) AreMatched(userID1, userID2 int) (bool, error) {
+	liked := func(from, to int) bool {
+		for _, swipe := range r.swipes {
+			if swipe.SwiperUserID == from && swipe.SwipedUserID == to && models.IsLike(swipe.SwipeDirection) {
+				return true
+			}
+		}
+		return false
+	}
+	return liked(userID1, userID2) && liked(userID2, userID1), nil
+}
+
+// memoryMessageRepo only implements CreateMessage, recording MessageSent in the outbox like the
+// real repository
+type memoryMessageRepo struct {
+	repository.MessageRepository
+	outbox  *memoryOutboxRepo
+	created []models.Message
+}
+
+func (r *memoryMessageRepo) CreateMessage(message *models.Message) error {
+	message.MessageID = len(r.created) + 1
+	r.created = append(r.created, *message)
+	return r.outbox.record(models.MessageSent{
+		MessageID:      message.MessageID,
+		SenderUserID:   message.SenderUserID,
+		ReceiverUserID: message.ReceiverUserID,
+		SentAt:         message.Timestamp,
+	})
+}
+
+// memoryBlockRepo only implements IsBlocked, in both directions
+type memoryBlockRepo struct {
+	repository.BlockRepository
+	blocks [][2]int
+}
+
+func (r *memoryBlockRepo) IsBlocked(userID1, userID2 int) (bool, error) {
+	for _, block := range r.blocks {
+		if (block[0] == userID1 && block[1] == userID2) || (block[0] == userID2 && block[1] == userID1) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestMessageService_NotifiesMatchesAndMessagesThroughTheOutbox(t *testing.T) {
+	notifications := &memoryNotificationRepo{}
+	bus := NewEventBus()
+	NewNotificationService(notifications, memoryUserRepo{}, &memoryNotificationPreferencesRepo{}).Subscribe(bus)
+
+	// Events go from the outbox through the broker to the bus
+	outbox := &memoryOutboxRepo{}
+	broker := helpers.NewMemoryBroker(3, NotificationsQueue)
+	relay := NewOutboxRelay(outbox, broker, DefaultOutboxRelayPolicy())
+	consumer := NewEventConsumer(NotificationsQueue, broker, outbox, bus)
+	deliver := func() {
+		t.Helper()
+		if _, err := relay.RunOnce(context.Background()); err != nil {
+			t.Fatalf("relay failed: %v", err)
+		}
+		if _, err := broker.Drain(NotificationsQueue, consumer.Handle); err != nil {
+			t.Fatalf("drain failed: %v", err)
+		}
+	}
+
+	swipes := &memorySwipeRepo{swipes: []models.SwipeHistory{
+		{SwiperUserID: 5, SwipedUserID: 6, SwipeDirection: models.SwipeDirectionRight},
+		{SwiperUserID: 6, SwipedUserID: 5, SwipeDirection: models.SwipeDirectionSuper},
+		{SwiperUserID: 5, SwipedUserID: 7, SwipeDirection: models.SwipeDirectionRight},
+	}}
+	messages := &memoryMessageRepo{outbox: outbox}
+	blocks := &memoryBlockRepo{}
+	inventory, _ := newInventoryFixture(nil, 0)
+	service := NewMessageService(messages, swipes, blocks, inventory.entitlementsService)
+
+	// A match tells both users
+	if err := outbox.record(models.MatchCreated{UserID: 5, MatchedUserID: 6}); err != nil {
+		t.Fatalf("record failed: %v", err)
+	}
+	deliver()
+	matched := notifications.ofType(NotificationTypeMatched)
+	if len(matched) != 2 || matched[0].UserID != 5 || matched[0].Message != "You matched with user6!" || matched[1].UserID != 6 {
+		t.Fatalf("expected both users to be told of the match, got %+v", matched)
+	}
+
+	// A message to a match is stored and tells the receiver
+	if _, err := service.SendMessage(5, 6, "  hi there  "); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	deliver()
+	if len(messages.created) != 1 || messages.created[0].MessageContent != "hi there" {
+		t.Fatalf("expected the trimmed message to be stored, got %+v", messages.created)
+	}
+	received := notifications.ofType(NotificationTypeNewMessage)
+	if len(received) != 1 || received[0].UserID != 6 || received[0].Message != "user5 sent you a message" {
+		t.Fatalf("expected the receiver to be notified, got %+v", received)
+	}
+
+	// Free users cannot message users they have not matched with, nor anyone across a block
+	if _, err := service.SendMessage(5, 7, "hi"); !errors.Is(err, ErrCannotMessage) {
+		t.Fatalf("expected an unmatched message to be refused, got %v", err)
+	}
+	blocks.blocks = append(blocks.blocks, [2]int{6, 5})
+	if _, err := service.SendMessage(5, 6, "hi again"); !errors.Is(err, ErrCannotMessage) {
+		t.Fatalf("expected a message across a block to be refused, got %v", err)
+	}
+	if _, err := service.SendMessage(6, 5, ""); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected an empty message to be refused, got %v", err)
+	}
+	deliver()
+	if len(messages.created) != 1 || len(notifications.ofType(NotificationTypeNewMessage)) != 1 {
+		t.Fatalf("expected refused messages to be neither stored nor notified")
+	}
+}
@@ -0,0 +1,247 @@
+// services/notification_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultNotificationPageSize is the inbox page size when the client does not ask for one
+	defaultNotificationPageSize = 20
+	// maxNotificationPageSize caps the inbox page size
+	maxNotificationPageSize = 100
+	// maxBulkNotificationIDs caps how many notifications one bulk request may change
+	maxBulkNotificationIDs = 100
+)
+
+// Notification types sent in reaction to domain events
+const (
+	// NotificationTypeMatched tells both users of a new match
+	NotificationTypeMatched = "Matched"
+	// NotificationTypeNewMessage tells the receiver of a direct message
+	NotificationTypeNewMessage = "New Message"
+	// NotificationTypePremiumUnlocked tells the user their paid plan has started
+	NotificationTypePremiumUnlocked = "Premium Feature Unlocked"
+)
+
+var (
+	// ErrNotificationNotFound is returned for notifications that do not exist or belong to someone else
+	ErrNotificationNotFound = errors.New("notification not found")
+	// ErrInvalidCursor is returned for an inbox cursor that was not given out by Inbox
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrTooManyNotificationIDs is returned for bulk requests naming too many notifications
+	ErrTooManyNotificationIDs = errors.New("too many notification IDs")
+)
+
+// NotificationPage is a page of a user's inbox. NextCursor fetches the following page and is empty
+// on the last one.
+type NotificationPage struct {
+	Notifications []models.Notification `json:"notifications"`
+	NextCursor    string                `json:"nextCursor,omitempty"`
+	UnreadCount   int64                 `json:"unreadCount"`
+}
+
+// NotificationService is the user's notification inbox. Users only ever see and change their own
+// notifications; those of other users are reported as not found. It also turns domain events into
+// notifications. Every notification is kept in the inbox, but those the user muted in-app are
+// marked read as they arrive so they do not add to the unread count.
+type NotificationService struct {
+	notificationRepo repository.NotificationRepository
+	userRepo         repository.UserRepository
+	preferencesRepo  repository.NotificationPreferencesRepository
+	now              func() time.Time
+}
+
+// NewNotificationService creates a new instance of NotificationService
+func NewNotificationService(notificationRepo repository.NotificationRepository, userRepo repository.UserRepository, preferencesRepo repository.NotificationPreferencesRepository) *NotificationService {
+	return &NotificationService{
+		notificationRepo: notificationRepo,
+		userRepo:         userRepo,
+		preferencesRepo:  preferencesRepo,
+		now:              time.Now,
+	}
+}
+
+// Subscribe makes the service notify users of the domain events dispatched on the bus
+func (s *NotificationService) Subscribe(bus *EventBus) {
+	bus.Subscribe(models.EventMatchCreated, "match-notifications", s.onMatchCreated)
+	bus.Subscribe(models.EventMessageSent, "message-notifications", s.onMessageSent)
+	bus.Subscribe(models.EventPremiumActivated, "premium-notifications", s.onPremiumActivated)
+	bus.Subscribe(models.EventNotificationCreated, "in-app-mutes", s.onNotificationCreated)
+}
+
+// Notify adds a notification to the user's inbox
+func (s *NotificationService) Notify(userID int, notificationType, message string) error {
+	return s.notificationRepo.CreateNotification(&models.Notification{
+		UserID:           userID,
+		NotificationType: notificationType,
+		Message:          message,
+		Timestamp:        s.now(),
+	})
+}
+
+// Inbox returns a page of the user's notifications, newest first, starting after the cursor.
+// limit is clamped to 1..100, with 0 meaning the default of 20.
+func (s *NotificationService) Inbox(userID int, cursor string, unreadOnly bool, limit int) (*NotificationPage, error) {
+	query := repository.NotificationQuery{UnreadOnly: unreadOnly, Limit: limit}
+	if query.Limit <= 0 {
+		query.Limit = defaultNotificationPageSize
+	}
+	if query.Limit > maxNotificationPageSize {
+		query.Limit = maxNotificationPageSize
+	}
+	if cursor != "" {
+		beforeID, err := strconv.Atoi(cursor)
+		if err != nil || beforeID <= 0 {
+			return nil, ErrInvalidCursor
+		}
+		query.BeforeID = beforeID
+	}
+
+	// Fetch one extra to know whether there is a next page
+	pageSize := query.Limit
+	query.Limit++
+	notifications, err := s.notificationRepo.GetNotifications(userID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &NotificationPage{Notifications: notifications}
+	if len(notifications) > pageSize {
+		page.Notifications = notifications[:pageSize]
+		page.NextCursor = strconv.Itoa(page.Notifications[pageSize-1].NotificationID)
+	}
+	if page.Notifications == nil {
+		page.Notifications = []models.Notification{}
+	}
+
+	page.UnreadCount, err = s.notificationRepo.CountUnread(userID)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Get returns one of the user's notifications
+func (s *NotificationService) Get(userID, notificationID int) (*models.Notification, error) {
+	notification, err := s.notificationRepo.GetNotificationByID(notificationID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && notification.UserID != userID) {
+		return nil, ErrNotificationNotFound
+	}
+	return notification, err
+}
+
+// SetRead marks one of the user's notifications as read or unread
+func (s *NotificationService) SetRead(userID, notificationID int, read bool) (*models.Notification, error) {
+	notification, err := s.Get(userID, notificationID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.notificationRepo.SetRead(userID, []int{notificationID}, read); err != nil {
+		return nil, err
+	}
+	notification.IsRead = read
+	return notification, nil
+}
+
+// MarkRead marks the user's notifications with the given IDs as read and returns how many were
+// unread. IDs that are not the user's are skipped.
+func (s *NotificationService) MarkRead(userID int, notificationIDs []int) (int64, error) {
+	if len(notificationIDs) > maxBulkNotificationIDs {
+		return 0, ErrTooManyNotificationIDs
+	}
+	return s.notificationRepo.SetRead(userID, notificationIDs, true)
+}
+
+// MarkAllRead marks all of the user's notifications as read and returns how many were unread
+func (s *NotificationService) MarkAllRead(userID int) (int64, error) {
+	return s.notificationRepo.MarkAllRead(userID)
+}
+
+// Delete deletes one of the user's notifications
+func (s *NotificationService) Delete(userID, notificationID int) error {
+	notification, err := s.Get(userID, notificationID)
+	if err != nil {
+		return err
+	}
+	return s.notificationRepo.DeleteNotification(notification)
+}
+
+// UnreadCount returns how many unread notifications the user has
+func (s *NotificationService) UnreadCount(userID int) (int64, error) {
+	return s.notificationRepo.CountUnread(userID)
+}
+
+// onMatchCreated tells both users who they matched with
+func (s *NotificationService) onMatchCreated(event models.DomainEvent, effects EffectLog) error {
+	match, ok := event.(models.MatchCreated)
+	if !ok {
+		return fmt.Errorf("unexpected %s event %T", event.EventName(), event)
+	}
+
+	// Each user is told once, also when telling the other one failed and the event is redelivered
+	pairs := [][2]int{{match.UserID, match.MatchedUserID}, {match.MatchedUserID, match.UserID}}
+	for _, pair := range pairs {
+		pair := pair
+		err := effects.Once(strconv.Itoa(pair[0]), func() error {
+			other, err := s.userRepo.GetUserByID(pair[1])
+			if err != nil {
+				return err
+			}
+			return s.Notify(pair[0], NotificationTypeMatched, fmt.Sprintf("You matched with %s!", other.Username))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onMessageSent tells the receiver who sent them a message
+func (s *NotificationService) onMessageSent(event models.DomainEvent, effects EffectLog) error {
+	message, ok := event.(models.MessageSent)
+	if !ok {
+		return fmt.Errorf("unexpected %s event %T", event.EventName(), event)
+	}
+
+	sender, err := s.userRepo.GetUserByID(message.SenderUserID)
+	if err != nil {
+		return err
+	}
+	return s.Notify(message.ReceiverUserID, NotificationTypeNewMessage, fmt.Sprintf("%s sent you a message", sender.Username))
+}
+
+// onPremiumActivated tells the user their plan has started and until when it runs
+func (s *NotificationService) onPremiumActivated(event models.DomainEvent, effects EffectLog) error {
+	activated, ok := event.(models.PremiumActivated)
+	if !ok {
+		return fmt.Errorf("unexpected %s event %T", event.EventName(), event)
+	}
+	return s.Notify(activated.UserID, NotificationTypePremiumUnlocked,
+		fmt.Sprintf("Your %s plan is active until %s", activated.PlanName, activated.PeriodEnd.Format("January 2, 2006")))
+}
+
+// onNotificationCreated marks the notification read when the user muted its type in-app
+func (s *NotificationService) onNotificationCreated(event models.DomainEvent, effects EffectLog) error {
+	created, ok := event.(models.NotificationCreated)
+	if !ok {
+		return fmt.Errorf("unexpected %s event %T", event.EventName(), event)
+	}
+
+	preferences, err := s.preferencesRepo.GetPreferences(created.UserID)
+	if err != nil {
+		return err
+	}
+	if !preferences.IsMuted(created.NotificationType, models.DeliveryChannelInApp) {
+		return nil
+	}
+	_, err = s.notificationRepo.SetRead(created.UserID, []int{created.NotificationID}, true)
+	return err
+}
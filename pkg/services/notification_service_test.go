@@ -0,0 +1,200 @@
+// services/notification_service_test.go
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+func (r *memoryNotificationRepo) GetNotificationByID(notificationID int) (*models.Notification, error) {
+	for _, notification := range r.created {
+		if notification.NotificationID == notificationID {
+			found := notification
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryNotificationRepo) GetNotifications(userID int, query repository.NotificationQuery) ([]models.Notification, error) {
+	var notifications []models.Notification
+	for i := len(r.created) - 1; i >= 0 && len(notifications) < query.Limit; i-- {
+		notification := r.created[i]
+		if notification.UserID == userID && (query.BeforeID == 0 || notification.NotificationID < query.BeforeID) && (!query.UnreadOnly || !notification.IsRead) {
+			notifications = append(notifications, notification)
+		}
+	}
+	return notifications, nil
+}
+
+func (r *memoryNotificationRepo) SetRead(userID int, notificationIDs []int, read bool) (int64, error) {
+	var changed int64
+	for i := range r.created {
+		for _, notificationID := range notificationIDs {
+			if r.created[i].NotificationID == notificationID && r.created[i].UserID == userID && r.created[i].IsRead != read {
+				r.created[i].IsRead = read
+				changed++
+			}
+		}
+	}
+	return changed, nil
+}
+
+func (r *memoryNotificationRepo) MarkAllRead(userID int) (int64, error) {
+	var changed int64
+	for i := range r.created {
+		if r.created[i].UserID == userID && !r.created[i].IsRead {
+			r.created[i].IsRead = true
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+func (r *memoryNotificationRepo) CountUnread(userID int) (int64, error) {
+	var count int64
+	for _, notification := range r.created {
+		if notification.UserID == userID && !notification.IsRead {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func TestNotificationService_InboxPagesAndOwnership(t *testing.T) {
+	repo := &memoryNotificationRepo{}
+	for i := 0; i < 5; i++ {
+		for _, userID := range []int{5, 6} {
+			if err := repo.CreateNotification(&models.Notification{UserID: userID, NotificationType: "Matched"}); err != nil {
+				t.Fatalf("create failed: %v", err)
+			}
+		}
+	}
+	service := NewNotificationService(repo, memoryUserRepo{}, &memoryNotificationPreferencesRepo{})
+
+	// Pages of 2 walk through the user's 5 notifications, newest first
+	var seen []int
+	cursor := ""
+	for pages := 0; pages < 3; pages++ {
+		page, err := service.Inbox(5, cursor, false, 2)
+		if err != nil {
+			t.Fatalf("inbox failed: %v", err)
+		}
+		for _, notification := range page.Notifications {
+			if notification.UserID != 5 {
+				t.Fatalf("inbox returned another user's notification %+v", notification)
+			}
+			seen = append(seen, notification.NotificationID)
+		}
+		cursor = page.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	if want := []int{9, 7, 5, 3, 1}; len(seen) != len(want) || seen[0] != want[0] || seen[4] != want[4] || cursor != "" {
+		t.Fatalf("expected notifications %v and no next page, got %v with cursor %q", want, seen, cursor)
+	}
+	if _, err := service.Inbox(5, "abc", false, 2); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected an invalid cursor error, got %v", err)
+	}
+
+	// Another user's notification cannot be read, changed or deleted
+	if _, err := service.Get(5, 2); !errors.Is(err, ErrNotificationNotFound) {
+		t.Fatalf("expected another user's notification to be hidden, got %v", err)
+	}
+	if _, err := service.SetRead(5, 2, true); !errors.Is(err, ErrNotificationNotFound) {
+		t.Fatalf("expected another user's notification to be left alone, got %v", err)
+	}
+	if err := service.Delete(5, 2); !errors.Is(err, ErrNotificationNotFound) {
+		t.Fatalf("expected another user's notification not to be deleted, got %v", err)
+	}
+
+	// Bulk reads skip other users' notifications
+	marked, err := service.MarkRead(5, []int{1, 2, 3})
+	if err != nil || marked != 2 {
+		t.Fatalf("expected 2 notifications marked, got %d (%v)", marked, err)
+	}
+	page, err := service.Inbox(5, "", true, 0)
+	if err != nil {
+		t.Fatalf("inbox failed: %v", err)
+	}
+	if len(page.Notifications) != 3 || page.UnreadCount != 3 {
+		t.Fatalf("expected 3 unread notifications, got %d (count %d)", len(page.Notifications), page.UnreadCount)
+	}
+
+	if marked, err := service.MarkAllRead(5); err != nil || marked != 3 {
+		t.Fatalf("expected 3 notifications marked, got %d (%v)", marked, err)
+	}
+	if unread, _ := service.UnreadCount(6); unread != 5 {
+		t.Fatalf("expected the other user's notifications to stay unread, got %d", unread)
+	}
+}
+
+// memoryNotificationPreferencesRepo keeps the users' notification preferences in memory
+type memoryNotificationPreferencesRepo struct {
+	repository.NotificationPreferencesRepository
+	preferences map[int]*models.NotificationPreferences
+}
+
+func (r *memoryNotificationPreferencesRepo) GetPreferences(userID int) (*models.NotificationPreferences, error) {
+	if preferences, ok := r.preferences[userID]; ok {
+		return preferences, nil
+	}
+	return models.DefaultNotificationPreferences(userID), nil
+}
+
+func (r *memoryNotificationPreferencesRepo) SavePreferences(preferences *models.NotificationPreferences) error {
+	if r.preferences == nil {
+		r.preferences = make(map[int]*models.NotificationPreferences)
+	}
+	r.preferences[preferences.UserID] = preferences
+	return nil
+}
+
+func TestNotificationService_KeepsNotificationsMutedInAppAsRead(t *testing.T) {
+	repo := &memoryNotificationRepo{}
+	preferences := &memoryNotificationPreferencesRepo{}
+	muted := models.DefaultNotificationPreferences(1)
+	muted.Muted = models.NotificationMutes{NotificationTypeMatched: {models.DeliveryChannelInApp}}
+	if err := preferences.SavePreferences(muted); err != nil {
+		t.Fatal(err)
+	}
+	service := NewNotificationService(repo, memoryUserRepo{}, preferences)
+	bus := NewEventBus()
+	service.Subscribe(bus)
+
+	for _, notification := range []models.Notification{
+		{UserID: 1, NotificationType: NotificationTypeMatched, Message: "You matched with user2!"},
+		{UserID: 1, NotificationType: NotificationTypeNewMessage, Message: "user2 sent you a message"},
+		{UserID: 2, NotificationType: NotificationTypeMatched, Message: "You matched with user1!"},
+	} {
+		if err := repo.CreateNotification(&notification); err != nil {
+			t.Fatal(err)
+		}
+		if err := bus.Dispatch(models.NotificationCreated{
+			NotificationID:   notification.NotificationID,
+			UserID:           notification.UserID,
+			NotificationType: notification.NotificationType,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := service.Inbox(1, "", false, 0)
+	if err != nil {
+		t.Fatalf("inbox failed: %v", err)
+	}
+	if len(page.Notifications) != 2 || page.UnreadCount != 1 {
+		t.Fatalf("expected both notifications kept with only the message unread, got %+v", page)
+	}
+	if match, _ := service.Get(1, 1); !match.IsRead {
+		t.Fatalf("expected the muted match to be read, got %+v", match)
+	}
+	if unread, _ := service.UnreadCount(2); unread != 1 {
+		t.Fatalf("expected the other user's match to stay unread, got %d", unread)
+	}
+}
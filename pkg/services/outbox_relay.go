@@ -0,0 +1,155 @@
+// services/outbox_relay.go
+package services
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// Environment variables overriding DefaultOutboxRelayPolicy
+const (
+	OutboxRelayIntervalEnv    = "OUTBOX_RELAY_INTERVAL"
+	OutboxRelayBatchEnv       = "OUTBOX_RELAY_BATCH_SIZE"
+	OutboxRelayMaxAttemptsEnv = "OUTBOX_RELAY_MAX_ATTEMPTS"
+)
+
+// maxOutboxRetryDelay caps the backoff between attempts to publish an event
+const maxOutboxRetryDelay = 5 * time.Minute
+
+// OutboxRelayPolicy decides how often the relay polls the outbox, how many events it publishes at
+// a time, and how often it tries to publish an event before marking it dead. Claimed events are
+// leased to the relay for Lease, after which another instance may retry them.
+type OutboxRelayPolicy struct {
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	Lease       time.Duration
+}
+
+// DefaultOutboxRelayPolicy returns the policy used when none is configured
+func DefaultOutboxRelayPolicy() OutboxRelayPolicy {
+	return OutboxRelayPolicy{
+		Interval:    time.Second,
+		BatchSize:   100,
+		MaxAttempts: 10,
+		Lease:       time.Minute,
+	}
+}
+
+// OutboxRelayPolicyFromEnv reads the policy from the environment, keeping the default for any
+// value that is unset or invalid
+func OutboxRelayPolicyFromEnv() OutboxRelayPolicy {
+	policy := DefaultOutboxRelayPolicy()
+	policy.Interval = positiveDurationEnv(OutboxRelayIntervalEnv, policy.Interval)
+	policy.BatchSize = positiveIntEnv(OutboxRelayBatchEnv, policy.BatchSize)
+	policy.MaxAttempts = positiveIntEnv(OutboxRelayMaxAttemptsEnv, policy.MaxAttempts)
+	return policy
+}
+
+// OutboxRelayReport counts the events handled by one relay run
+type OutboxRelayReport struct {
+	Published int
+	Retried   int
+	Dead      int
+}
+
+// OutboxRelay publishes the events recorded in the outbox to the message broker. Failed events
+// are retried with exponential backoff until MaxAttempts, then marked dead. Every instance runs
+// it: claiming skips the events another instance is publishing.
+type OutboxRelay struct {
+	outboxRepo repository.OutboxRepository
+	broker     helpers.MessageBroker
+	policy     OutboxRelayPolicy
+	now        func() time.Time
+}
+
+// NewOutboxRelay creates a new instance of OutboxRelay
+func NewOutboxRelay(outboxRepo repository.OutboxRepository, broker helpers.MessageBroker, policy OutboxRelayPolicy) *OutboxRelay {
+	return &OutboxRelay{
+		outboxRepo: outboxRepo,
+		broker:     broker,
+		policy:     policy,
+		now:        time.Now,
+	}
+}
+
+// Start relays the outbox on every interval until the context is cancelled. A full batch is
+// followed by the next one at once.
+func (r *OutboxRelay) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := r.RunOnce(ctx)
+		if err != nil {
+			log.Printf("Outbox relay failed: %v", err)
+		} else if report.Retried+report.Dead > 0 {
+			log.Printf("Outbox relay published %d events, will retry %d and gave up on %d", report.Published, report.Retried, report.Dead)
+		}
+
+		if err == nil && report.Published+report.Retried+report.Dead >= r.policy.BatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce publishes one batch of the events due in the outbox
+func (r *OutboxRelay) RunOnce(ctx context.Context) (OutboxRelayReport, error) {
+	var report OutboxRelayReport
+	events, err := r.outboxRepo.ClaimEvents(r.now(), r.policy.Lease, r.policy.BatchSize)
+	if err != nil {
+		return report, err
+	}
+
+	for _, event := range events {
+		publishErr := r.broker.Publish(ctx, helpers.BrokerMessage{
+			ID:   strconv.FormatInt(event.OutboxEventID, 10),
+			Type: event.EventName,
+			Body: []byte(event.Payload),
+		})
+		now := r.now()
+
+		switch {
+		case publishErr == nil:
+			err = r.outboxRepo.MarkPublished(event.OutboxEventID, now)
+			report.Published++
+		case event.Attempts >= r.policy.MaxAttempts:
+			log.Printf("Giving up on %s event %d after %d attempts: %v", event.EventName, event.OutboxEventID, event.Attempts, publishErr)
+			err = r.outboxRepo.MarkDead(event.OutboxEventID, publishErr.Error(), now)
+			report.Dead++
+		default:
+			err = r.outboxRepo.MarkFailed(event.OutboxEventID, publishErr.Error(), now.Add(outboxRetryDelay(event.Attempts)))
+			report.Retried++
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// outboxRetryDelay is the backoff after the given number of failed attempts: one second, doubling
+// with each attempt up to maxOutboxRetryDelay
+func outboxRetryDelay(attempts int) time.Duration {
+	delay := time.Second
+	for i := 1; i < attempts && delay < maxOutboxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxOutboxRetryDelay {
+		delay = maxOutboxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,245 @@
+// services/outbox_relay_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// memoryOutboxRepo keeps the outbox and the processed events in memory. record stands in for the
+// repositories writing events along with their changes.
+type memoryOutboxRepo struct {
+	repository.OutboxRepository
+	events    []models.OutboxEvent
+	processed map[string]bool
+}
+
+func (r *memoryOutboxRepo) record(event models.DomainEvent) error {
+	entry, err := models.NewOutboxEvent(event, time.Time{})
+	if err != nil {
+		return err
+	}
+	entry.OutboxEventID = int64(len(r.events) + 1)
+	r.events = append(r.events, *entry)
+	return nil
+}
+
+func (r *memoryOutboxRepo) ClaimEvents(now time.Time, lease time.Duration, limit int) ([]models.OutboxEvent, error) {
+	var claimed []models.OutboxEvent
+	for i := range r.events {
+		event := &r.events[i]
+		if len(claimed) < limit && event.PublishedAt == nil && event.DeadAt == nil && !event.AvailableAt.After(now) {
+			event.AvailableAt = now.Add(lease)
+			event.Attempts++
+			claimed = append(claimed, *event)
+		}
+	}
+	return claimed, nil
+}
+
+func (r *memoryOutboxRepo) find(outboxEventID int64) *models.OutboxEvent {
+	for i := range r.events {
+		if r.events[i].OutboxEventID == outboxEventID {
+			return &r.events[i]
+		}
+	}
+	return nil
+}
+
+func (r *memoryOutboxRepo) MarkPublished(outboxEventID int64, now time.Time) error {
+	r.find(outboxEventID).PublishedAt = &now
+	return nil
+}
+
+func (r *memoryOutboxRepo) MarkFailed(outboxEventID int64, lastError string, retryAt time.Time) error {
+	event := r.find(outboxEventID)
+	event.AvailableAt = retryAt
+	event.LastError = lastError
+	return nil
+}
+
+func (r *memoryOutboxRepo) MarkDead(outboxEventID int64, lastError string, now time.Time) error {
+	event := r.find(outboxEventID)
+	event.DeadAt = &now
+	event.LastError = lastError
+	return nil
+}
+
+func (r *memoryOutboxRepo) IsProcessed(consumer, eventID string) (bool, error) {
+	return r.processed[consumer+"/"+eventID], nil
+}
+
+func (r *memoryOutboxRepo) MarkProcessed(consumer, eventID string, now time.Time) error {
+	if r.processed == nil {
+		r.processed = make(map[string]bool)
+	}
+	r.processed[consumer+"/"+eventID] = true
+	return nil
+}
+
+// flakyBroker fails the given number of publishes before passing them on
+type flakyBroker struct {
+	*helpers.MemoryBroker
+	failures int
+}
+
+func (b *flakyBroker) Publish(ctx context.Context, message helpers.BrokerMessage) error {
+	if b.failures > 0 {
+		b.failures--
+		return errors.New("connection refused")
+	}
+	return b.MemoryBroker.Publish(ctx, message)
+}
+
+func TestOutboxRelay_RetriesWithBackoffThenGivesUp(t *testing.T) {
+	outbox := &memoryOutboxRepo{}
+	for userID := 1; userID <= 2; userID++ {
+		if err := outbox.record(models.MatchCreated{UserID: userID, MatchedUserID: 9}); err != nil {
+			t.Fatalf("record failed: %v", err)
+		}
+	}
+	broker := &flakyBroker{MemoryBroker: helpers.NewMemoryBroker(3, NotificationsQueue), failures: 1}
+	relay := NewOutboxRelay(outbox, broker, OutboxRelayPolicy{BatchSize: 10, MaxAttempts: 3, Lease: time.Minute})
+	now := time.Date(2024, 3, 11, 12, 0, 0, 0, time.UTC)
+	relay.now = func() time.Time { return now }
+
+	// The first event fails and waits a second; the second is published
+	report, err := relay.RunOnce(context.Background())
+	if err != nil || report.Published != 1 || report.Retried != 1 {
+		t.Fatalf("expected one published and one retried event, got %+v (%v)", report, err)
+	}
+	if report, _ := relay.RunOnce(context.Background()); report != (OutboxRelayReport{}) {
+		t.Fatalf("expected the failed event to wait out its backoff, got %+v", report)
+	}
+	now = now.Add(time.Second)
+	if report, _ := relay.RunOnce(context.Background()); report.Published != 1 {
+		t.Fatalf("expected the failed event to be published after its backoff, got %+v", report)
+	}
+	if published := broker.Published(); len(published) != 2 || published[0].ID != "2" || published[1].ID != "1" || published[1].Type != models.EventMatchCreated {
+		t.Fatalf("expected both events on the broker, got %+v", published)
+	}
+
+	// An event that keeps failing is marked dead after MaxAttempts
+	if err := outbox.record(models.MatchCreated{UserID: 3, MatchedUserID: 9}); err != nil {
+		t.Fatalf("record failed: %v", err)
+	}
+	broker.failures = 10
+	for attempt := 1; attempt <= 3; attempt++ {
+		now = now.Add(maxOutboxRetryDelay)
+		if _, err := relay.RunOnce(context.Background()); err != nil {
+			t.Fatalf("relay failed: %v", err)
+		}
+	}
+	dead := outbox.find(3)
+	if dead.DeadAt == nil || dead.Attempts != 3 || dead.LastError != "connection refused" {
+		t.Fatalf("expected the event to be dead after 3 attempts, got %+v", dead)
+	}
+	now = now.Add(maxOutboxRetryDelay)
+	if report, _ := relay.RunOnce(context.Background()); report != (OutboxRelayReport{}) {
+		t.Fatalf("expected dead events to be left alone, got %+v", report)
+	}
+}
+
+func TestEventConsumer_HandlesEachEventOnceAndDeadLettersFailures(t *testing.T) {
+	outbox := &memoryOutboxRepo{}
+	broker := helpers.NewMemoryBroker(3, NotificationsQueue)
+	bus := NewEventBus()
+	handled := map[int]int{}
+	bus.Subscribe(models.EventMatchCreated, "test", func(event models.DomainEvent, effects EffectLog) error {
+		match := event.(models.MatchCreated)
+		handled[match.UserID]++
+		if match.UserID == 13 {
+			return fmt.Errorf("user %d is unknown", match.UserID)
+		}
+		return nil
+	})
+	consumer := NewEventConsumer(NotificationsQueue, broker, outbox, bus)
+
+	publish := func(id string, event models.DomainEvent) {
+		entry, _ := models.NewOutboxEvent(event, time.Now())
+		if err := broker.Publish(context.Background(), helpers.BrokerMessage{ID: id, Type: entry.EventName, Body: []byte(entry.Payload)}); err != nil {
+			t.Fatalf("publish failed: %v", err)
+		}
+	}
+
+	// A redelivered event is acknowledged without being handled again
+	publish("1", models.MatchCreated{UserID: 5, MatchedUserID: 6})
+	publish("1", models.MatchCreated{UserID: 5, MatchedUserID: 6})
+	// An event that keeps failing is retried, then dead-lettered
+	publish("2", models.MatchCreated{UserID: 13, MatchedUserID: 6})
+
+	if _, err := broker.Drain(NotificationsQueue, consumer.Handle); err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	if handled[5] != 1 || handled[13] != 3 {
+		t.Fatalf("expected the duplicate to be skipped and the failure tried 3 times, got %v", handled)
+	}
+	dead := broker.DeadLetters(NotificationsQueue)
+	if len(dead) != 1 || dead[0].ID != "2" || dead[0].Attempts != 3 {
+		t.Fatalf("expected the failing event to be dead-lettered, got %+v", dead)
+	}
+	if processed, _ := outbox.IsProcessed(NotificationsQueue, "2"); processed {
+		t.Fatalf("expected the failed event not to be marked processed")
+	}
+}
+
+// flakyNotificationRepo fails to create the first notification for the given user
+type flakyNotificationRepo struct {
+	*memoryNotificationRepo
+	failUserID int
+	failed     bool
+}
+
+func (r *flakyNotificationRepo) CreateNotification(notification *models.Notification) error {
+	if notification.UserID == r.failUserID && !r.failed {
+		r.failed = true
+		return errors.New("insert failed")
+	}
+	return r.memoryNotificationRepo.CreateNotification(notification)
+}
+
+func TestEventConsumer_RedeliveryAfterPartialFailureOnlyRedoesTheRest(t *testing.T) {
+	outbox := &memoryOutboxRepo{}
+	broker := helpers.NewMemoryBroker(3, NotificationsQueue)
+	notifications := &flakyNotificationRepo{memoryNotificationRepo: &memoryNotificationRepo{}, failUserID: 6}
+	bus := NewEventBus()
+	NewNotificationService(notifications, memoryUserRepo{}, &memoryNotificationPreferencesRepo{}).Subscribe(bus)
+	consumer := NewEventConsumer(NotificationsQueue, broker, outbox, bus)
+
+	entry, _ := models.NewOutboxEvent(models.MatchCreated{UserID: 5, MatchedUserID: 6}, time.Now())
+	if err := broker.Publish(context.Background(), helpers.BrokerMessage{ID: "1", Type: entry.EventName, Body: []byte(entry.Payload)}); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+
+	// The first delivery tells user 5 and fails for user 6; the redelivery only tells user 6
+	if _, err := broker.Drain(NotificationsQueue, consumer.Handle); err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	matched := notifications.ofType(NotificationTypeMatched)
+	if len(matched) != 2 || matched[0].UserID != 5 || matched[1].UserID != 6 {
+		t.Fatalf("expected each user to be told once, got %+v", matched)
+	}
+	if dead := broker.DeadLetters(NotificationsQueue); len(dead) != 0 {
+		t.Fatalf("expected the redelivery to succeed, got %+v", dead)
+	}
+	for _, consumer := range []string{NotificationsQueue, NotificationsQueue + "/match-notifications", NotificationsQueue + "/match-notifications/5", NotificationsQueue + "/match-notifications/6"} {
+		if processed, _ := outbox.IsProcessed(consumer, "1"); !processed {
+			t.Errorf("expected %s to be recorded as processed", consumer)
+		}
+	}
+
+	// Later redeliveries change nothing
+	if err := consumer.Handle(helpers.BrokerMessage{ID: "1", Type: entry.EventName, Body: []byte(entry.Payload)}); err != nil {
+		t.Fatalf("redelivery failed: %v", err)
+	}
+	if got := len(notifications.ofType(NotificationTypeMatched)); got != 2 {
+		t.Fatalf("expected no more notifications, got %d", got)
+	}
+}
@@ -0,0 +1,207 @@
+// services/photo_service.go
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register JPEG decoder
+	_ "image/png"  // register PNG decoder
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// MaxPhotoUploadSize is the largest photo file accepted, in bytes
+const MaxPhotoUploadSize = 10 << 20
+
+var (
+	// ErrPhotoTooLarge is returned when an upload exceeds MaxPhotoUploadSize
+	ErrPhotoTooLarge = fmt.Errorf("photo must be at most %d MB", MaxPhotoUploadSize>>20)
+
+	// ErrUnsupportedPhotoType is returned for uploads that are not JPEG or PNG images
+	ErrUnsupportedPhotoType = errors.New("photo must be a JPEG or PNG image")
+
+	// ErrPhotoDimensions is returned for images too large to process safely
+	ErrPhotoDimensions = fmt.Errorf("photo dimensions must be at most %dx%d pixels", maxSourceDimension, maxSourceDimension)
+)
+
+// allowedPhotoTypes maps the sniffed content type to the output format
+var allowedPhotoTypes = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+}
+
+// PhotoService validates, cleans and stores profile photos
+type PhotoService struct {
+	photoRepo   repository.PhotoRepository
+	blobStore   helpers.BlobStore
+	redisHelper helpers.RedisHandler
+}
+
+// NewPhotoService creates a new instance of PhotoService
+func NewPhotoService(photoRepo repository.PhotoRepository, blobStore helpers.BlobStore, redisHelper helpers.RedisHandler) *PhotoService {
+	return &PhotoService{
+		photoRepo:   photoRepo,
+		blobStore:   blobStore,
+		redisHelper: redisHelper,
+	}
+}
+
+// UploadPhoto validates the image, strips its metadata, stores it with a thumbnail and appends it to the profile
+func (s *PhotoService) UploadPhoto(userID int, file io.Reader) (*models.Photo, error) {
+	data, err := io.ReadAll(io.LimitReader(file, MaxPhotoUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxPhotoUploadSize {
+		return nil, ErrPhotoTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	format, ok := allowedPhotoTypes[contentType]
+	if !ok {
+		return nil, ErrUnsupportedPhotoType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedPhotoType
+	}
+	if config.Width > maxSourceDimension || config.Height > maxSourceDimension {
+		return nil, ErrPhotoDimensions
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedPhotoType
+	}
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	// Re-encoding from decoded pixels drops EXIF, GPS and any other embedded metadata
+	full, err := encodeImage(fitWithin(img, maxPhotoDimension), format)
+	if err != nil {
+		return nil, err
+	}
+	thumbnail, err := encodeImage(fitWithin(img, maxThumbnailDimension), format)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strconv.FormatInt(time.Now().UnixNano(), 36)
+	photo := &models.Photo{
+		UserID:       userID,
+		StorageKey:   fmt.Sprintf("photos/%d/%s.%s", userID, name, format),
+		ThumbnailKey: fmt.Sprintf("photos/%d/%s_thumb.%s", userID, name, format),
+		ContentType:  contentType,
+		Width:        full.width,
+		Height:       full.height,
+		SizeBytes:    int64(len(full.data)),
+		CreatedAt:    time.Now(),
+	}
+
+	if err := s.blobStore.Put(photo.StorageKey, bytes.NewReader(full.data), contentType); err != nil {
+		return nil, err
+	}
+	if err := s.blobStore.Put(photo.ThumbnailKey, bytes.NewReader(thumbnail.data), contentType); err != nil {
+		s.deleteBlobs(photo)
+		return nil, err
+	}
+
+	if err := s.photoRepo.CreatePhoto(photo); err != nil {
+		s.deleteBlobs(photo)
+		return nil, err
+	}
+
+	s.invalidateProfileCache(userID)
+	return photo, nil
+}
+
+// ReorderPhotos sets the display order of the user's photos
+func (s *PhotoService) ReorderPhotos(userID int, photoIDs []int) ([]models.Photo, error) {
+	if err := s.photoRepo.ReorderPhotos(userID, photoIDs); err != nil {
+		return nil, err
+	}
+
+	s.invalidateProfileCache(userID)
+	return s.photoRepo.GetPhotosByUserID(userID)
+}
+
+// DeletePhoto removes one of the user's photos along with its stored files
+func (s *PhotoService) DeletePhoto(userID, photoID int) error {
+	photo, err := s.photoRepo.GetPhotoByID(photoID)
+	if err != nil || photo.UserID != userID {
+		return errors.New("photo not found")
+	}
+
+	if err := s.photoRepo.DeletePhoto(photo); err != nil {
+		return err
+	}
+
+	s.deleteBlobs(photo)
+	s.invalidateProfileCache(userID)
+	return nil
+}
+
+// OpenPhoto returns the stored image, or its thumbnail, for the given photo
+func (s *PhotoService) OpenPhoto(photo *models.Photo, thumbnail bool) (io.ReadCloser, error) {
+	key := photo.StorageKey
+	if thumbnail {
+		key = photo.ThumbnailKey
+	}
+
+	return s.blobStore.Get(key)
+}
+
+// BlurredPreview returns a blurred JPEG of the thumbnail of the user's first photo, for showing
+// that someone is there without revealing who
+func (s *PhotoService) BlurredPreview(userID int) ([]byte, error) {
+	photos, err := s.photoRepo.GetPhotosByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(photos) == 0 {
+		return nil, errors.New("photo not found")
+	}
+
+	body, err := s.blobStore.Get(photos[0].ThumbnailKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	img, _, err := image.Decode(body)
+	if err != nil {
+		return nil, err
+	}
+	preview, err := encodeImage(blur(img), "jpeg")
+	if err != nil {
+		return nil, err
+	}
+	return preview.data, nil
+}
+
+func (s *PhotoService) deleteBlobs(photo *models.Photo) {
+	for _, key := range []string{photo.StorageKey, photo.ThumbnailKey} {
+		if err := s.blobStore.Delete(key); err != nil {
+			log.Printf("Error deleting blob %s: %v", key, err)
+		}
+	}
+}
+
+// invalidateProfileCache drops the cached profile, whose Photos list has changed
+func (s *PhotoService) invalidateProfileCache(userID int) {
+	if err := s.redisHelper.Delete("profile:" + strconv.Itoa(userID)); err != nil {
+		log.Printf("Error deleting profile data in Redis: %v", err)
+	}
+	InvalidatePublicProfile(s.redisHelper, userID)
+}
@@ -0,0 +1,220 @@
+// services/photo_service_test.go
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// memoryPhotoRepo serves each user's photos in order
+type memoryPhotoRepo struct {
+	repository.PhotoRepository
+	photos map[int][]models.Photo
+}
+
+func (r *memoryPhotoRepo) GetPhotosByUserID(userID int) ([]models.Photo, error) {
+	return r.photos[userID], nil
+}
+
+func (r *memoryPhotoRepo) CreatePhoto(photo *models.Photo) error {
+	photo.PhotoID = len(r.photos[photo.UserID]) + 1
+	r.photos[photo.UserID] = append(r.photos[photo.UserID], *photo)
+	return nil
+}
+
+// newPhotoFixture returns a photo service storing blobs in a temporary directory, where user 2's
+// first photo has a checkerboard thumbnail
+func newPhotoFixture(t *testing.T) *PhotoService {
+	t.Helper()
+	blobStore := helpers.NewFileSystemBlobStore(t.TempDir())
+
+	var thumbnail bytes.Buffer
+	if err := jpeg.Encode(&thumbnail, checkerboard(image.Rect(0, 0, 64, 64)), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("encoding the thumbnail failed: %v", err)
+	}
+	if err := blobStore.Put("photos/2/1_thumb.jpg", &thumbnail, "image/jpeg"); err != nil {
+		t.Fatalf("storing the thumbnail failed: %v", err)
+	}
+
+	photos := &memoryPhotoRepo{photos: map[int][]models.Photo{
+		2: {{PhotoID: 1, UserID: 2, StorageKey: "photos/2/1.jpg", ThumbnailKey: "photos/2/1_thumb.jpg"}},
+	}}
+	return NewPhotoService(photos, blobStore, &mocks.MockRedisHandler{})
+}
+
+func TestPhotoService_BlurredPreviewHidesTheThumbnail(t *testing.T) {
+	service := newPhotoFixture(t)
+
+	preview, err := service.BlurredPreview(2)
+	if err != nil {
+		t.Fatalf("blurred preview failed: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(preview))
+	if err != nil {
+		t.Fatalf("expected a JPEG preview: %v", err)
+	}
+	if img.Bounds().Dx() != 64 || img.Bounds().Dy() != 64 {
+		t.Fatalf("expected the thumbnail's size, got %v", img.Bounds())
+	}
+
+	// The black and white squares are gone: every pixel is close to the same grey
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			for _, channel := range []uint32{r >> 8, g >> 8, b >> 8} {
+				if channel < 110 || channel > 145 {
+					t.Fatalf("pixel (%d, %d): expected grey, got %v", x, y, img.At(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestPhotoService_BlurredPreviewNeedsAPhoto(t *testing.T) {
+	service := newPhotoFixture(t)
+
+	if _, err := service.BlurredPreview(3); err == nil {
+		t.Fatal("expected an error for a user without photos")
+	}
+}
+
+// exifSegment returns a JPEG APP1 segment holding a big-endian EXIF block with the orientation and
+// a GPS IFD placing the photo at 48°51'N
+func exifSegment(orientation uint16) []byte {
+	var tiff bytes.Buffer
+	write := func(values ...interface{}) {
+		for _, value := range values {
+			binary.Write(&tiff, binary.BigEndian, value)
+		}
+	}
+	tiff.WriteString("MM")
+	write(uint16(42), uint32(8))
+	// IFD0: orientation and the offset of the GPS IFD, which follows at 8+2+2*12+4 = 38
+	write(uint16(2))
+	write(uint16(0x0112), uint16(3), uint32(1), orientation, uint16(0))
+	write(uint16(0x8825), uint16(4), uint32(1), uint32(38))
+	write(uint32(0))
+	// GPS IFD: latitude reference and the latitude, whose rationals follow at 38+2+2*12+4 = 68
+	write(uint16(2))
+	write(uint16(0x0001), uint16(2), uint32(2), []byte("N\x00\x00\x00"))
+	write(uint16(0x0002), uint16(5), uint32(3), uint32(68))
+	write(uint32(0))
+	write(uint32(48), uint32(1), uint32(51), uint32(1), uint32(0), uint32(1))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	header := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+	return append(header, segment...)
+}
+
+// jpegWithExif encodes the image as a JPEG carrying the EXIF segment right after its start marker
+func jpegWithExif(t *testing.T, img image.Image, exif []byte) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("encoding the photo failed: %v", err)
+	}
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), exif...), data[2:]...)
+}
+
+// storedImage decodes the blob stored under the key
+func storedImage(t *testing.T, service *PhotoService, key string) ([]byte, image.Image) {
+	t.Helper()
+	blob, err := service.blobStore.Get(key)
+	if err != nil {
+		t.Fatalf("reading %s failed: %v", key, err)
+	}
+	defer blob.Close()
+	data, _ := io.ReadAll(blob)
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decoding %s failed: %v", key, err)
+	}
+	return data, img
+}
+
+func TestPhotoService_UploadStripsTheMetadataAndKeepsTheOrientation(t *testing.T) {
+	service := newPhotoFixture(t)
+
+	// A landscape photo, red on the left and blue on the right, taken with the camera turned
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			if x < 20 {
+				src.SetRGBA(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				src.SetRGBA(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	upload := jpegWithExif(t, src, exifSegment(6))
+	if jpegOrientation(upload) != 6 {
+		t.Fatal("expected the upload to carry the orientation")
+	}
+
+	photo, err := service.UploadPhoto(1, bytes.NewReader(upload))
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	for _, key := range []string{photo.StorageKey, photo.ThumbnailKey} {
+		data, img := storedImage(t, service, key)
+		if bytes.Contains(data, []byte("Exif")) || bytes.Contains(data, []byte{0xFF, 0xE1}) {
+			t.Errorf("%s: expected no EXIF segment left", key)
+		}
+		if jpegOrientation(data) != 1 {
+			t.Errorf("%s: expected no orientation tag left", key)
+		}
+
+		// Turned a quarter clockwise: red at the top, blue at the bottom
+		if img.Bounds().Dx() != 20 || img.Bounds().Dy() != 40 {
+			t.Fatalf("%s: expected a 20x40 portrait, got %v", key, img.Bounds())
+		}
+		top, bottom := color.RGBAModel.Convert(img.At(10, 5)).(color.RGBA), color.RGBAModel.Convert(img.At(10, 35)).(color.RGBA)
+		if top.R < 200 || top.B > 60 || bottom.B < 200 || bottom.R > 60 {
+			t.Errorf("%s: expected red above blue, got %v and %v", key, top, bottom)
+		}
+	}
+	if photo.Width != 20 || photo.Height != 40 {
+		t.Errorf("expected the photo's size recorded as 20x40, got %dx%d", photo.Width, photo.Height)
+	}
+}
+
+func TestPhotoService_UploadBoundsThePhotoAndThumbnail(t *testing.T) {
+	service := newPhotoFixture(t)
+
+	var upload bytes.Buffer
+	jpeg.Encode(&upload, image.NewRGBA(image.Rect(0, 0, 3200, 800)), nil)
+	photo, err := service.UploadPhoto(1, &upload)
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if _, img := storedImage(t, service, photo.StorageKey); img.Bounds() != image.Rect(0, 0, maxPhotoDimension, 400) {
+		t.Errorf("expected the photo reduced to %dx400, got %v", maxPhotoDimension, img.Bounds())
+	}
+	if _, img := storedImage(t, service, photo.ThumbnailKey); img.Bounds() != image.Rect(0, 0, maxThumbnailDimension, 80) {
+		t.Errorf("expected the thumbnail reduced to %dx80, got %v", maxThumbnailDimension, img.Bounds())
+	}
+
+	// Only the header of an image too large to process safely is read
+	var huge bytes.Buffer
+	png.Encode(&huge, image.NewGray(image.Rect(0, 0, maxSourceDimension+1, 1)))
+	if _, err := service.UploadPhoto(1, &huge); !errors.Is(err, ErrPhotoDimensions) {
+		t.Errorf("expected an image over %d pixels refused, got %v", maxSourceDimension, err)
+	}
+	if _, err := service.UploadPhoto(1, bytes.NewReader([]byte("GIF89a"))); !errors.Is(err, ErrUnsupportedPhotoType) {
+		t.Errorf("expected a GIF refused, got %v", err)
+	}
+}
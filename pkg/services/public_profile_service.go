@@ -0,0 +1,367 @@
+// services/public_profile_service.go
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+const (
+	// Viewer tiers; viewers on any paid plan also see when the user was last active
+	ViewerTierFree    = "Free"
+	ViewerTierPremium = "Premium"
+
+	publicProfileCacheTTL = 10 * time.Minute
+)
+
+// ErrProfileNotVisible is returned when the profile does not exist or may not be shown to the viewer.
+// Blocked and incognito profiles are reported the same way as missing ones.
+var ErrProfileNotVisible = errors.New("profile not found")
+
+// PublicProfile is the projection of another user's profile that viewers are allowed to see.
+// It never includes email, password, exact location or premium dates.
+type PublicProfile struct {
+	UserID              int                        `json:"userID"`
+	Username            string                     `json:"username"`
+	Gender              string                     `json:"gender,omitempty"`
+	Company             string                     `json:"company,omitempty"`
+	School              string                     `json:"school,omitempty"`
+	JobTitle            string                     `json:"jobTitle,omitempty"`
+	Verified            bool                       `json:"verified"`
+	Photos              models.StringList          `json:"photos"`
+	AboutMe             string                     `json:"aboutMe,omitempty"`
+	Interests           models.StringList          `json:"interests"`
+	RelationshipGoals   string                     `json:"relationshipGoals,omitempty"`
+	Height              int                        `json:"height,omitempty"`
+	Language            string                     `json:"language,omitempty"`
+	ZodiacSign          string                     `json:"zodiacSign,omitempty"`
+	EducationDetails    string                     `json:"educationDetails,omitempty"`
+	SocialMediaAccounts models.SocialMediaAccounts `json:"socialMediaAccounts,omitempty"`
+	LastActive          string                     `json:"lastActive,omitempty"`
+
+	// Viewer-specific fields, filled in per request and never cached
+	Distance        string            `json:"distance,omitempty"`
+	Traveling       bool              `json:"traveling"`
+	TravelingTo     string            `json:"travelingTo,omitempty"`
+	SharedInterests models.StringList `json:"sharedInterests"`
+}
+
+// PublicProfileService builds privacy-filtered views of other users' profiles
+type PublicProfileService struct {
+	userRepo        repository.UserRepository
+	profileRepo     repository.ProfileRepository
+	locationRepo    repository.LocationRepository
+	blockRepo       repository.BlockRepository
+	swipeRepo       repository.SwipeHistoryRepository
+	profileViewRepo repository.ProfileViewRepository
+	passportRepo    repository.PassportRepository
+	redisHelper     helpers.RedisHandler
+}
+
+// NewPublicProfileService creates a new instance of PublicProfileService
+func NewPublicProfileService(
+	userRepo repository.UserRepository,
+	profileRepo repository.ProfileRepository,
+	locationRepo repository.LocationRepository,
+	blockRepo repository.BlockRepository,
+	swipeRepo repository.SwipeHistoryRepository,
+	profileViewRepo repository.ProfileViewRepository,
+	passportRepo repository.PassportRepository,
+	redisHelper helpers.RedisHandler,
+) *PublicProfileService {
+	return &PublicProfileService{
+		userRepo:        userRepo,
+		profileRepo:     profileRepo,
+		locationRepo:    locationRepo,
+		blockRepo:       blockRepo,
+		swipeRepo:       swipeRepo,
+		profileViewRepo: profileViewRepo,
+		passportRepo:    passportRepo,
+		redisHelper:     redisHelper,
+	}
+}
+
+// GetPublicProfile returns the target's profile as the viewer may see it and records the view
+func (s *PublicProfileService) GetPublicProfile(viewerUserID, targetUserID int, viewerTier string) (*PublicProfile, error) {
+	profiles, err := s.GetPublicProfiles(viewerUserID, []int{targetUserID}, viewerTier)
+	if err != nil {
+		return nil, err
+	}
+	profile, ok := profiles[targetUserID]
+	if !ok {
+		return nil, ErrProfileNotVisible
+	}
+
+	if viewerUserID != targetUserID {
+		s.recordView(viewerUserID, targetUserID)
+	}
+
+	return profile, nil
+}
+
+// recordView logs the view on the viewer's local day. Failing to record it does not fail the request.
+// CanView reports whether the viewer may see the target's profile and photos, under the same
+// block and incognito rules as GetPublicProfile. It records no view.
+func (s *PublicProfileService) CanView(viewerUserID, targetUserID int) (bool, error) {
+	visible, _, err := s.visibleAmong(viewerUserID, []int{targetUserID})
+	if err != nil {
+		return false, err
+	}
+	return len(visible) == 1, nil
+}
+
+func (s *PublicProfileService) recordView(viewerUserID, targetUserID int) {
+	viewer, err := s.userRepo.GetUserByID(viewerUserID)
+	if err != nil {
+		log.Printf("Error recording profile view: %v", err)
+		return
+	}
+	if err := s.profileViewRepo.RecordProfileView(viewerUserID, targetUserID, time.Now().In(viewer.Location())); err != nil {
+		log.Printf("Error recording profile view: %v", err)
+	}
+}
+
+// GetPublicProfiles returns the targets' profiles as the viewer may see them, keyed by user ID and
+// leaving out those not visible to the viewer. Listing profiles is not viewing them, so no views
+// are recorded and the profiles stay in the viewer's discovery feed. Each kind of data is loaded
+// for all targets at once, so the number of queries does not grow with the number of targets.
+func (s *PublicProfileService) GetPublicProfiles(viewerUserID int, targetUserIDs []int, viewerTier string) (map[int]*PublicProfile, error) {
+	profiles := make(map[int]*PublicProfile, len(targetUserIDs))
+	visible, users, err := s.visibleAmong(viewerUserID, targetUserIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(visible) == 0 {
+		return profiles, nil
+	}
+
+	// Locations give both the last active time and the distance, so load them up front
+	locations, err := s.locationRepo.GetDiscoveryLocations(append([]int{viewerUserID}, visible...))
+	if err != nil {
+		log.Printf("Error getting discovery locations: %v", err)
+		locations = map[int]*models.LocationHistory{}
+	}
+	projections, viewerProfile, err := s.cachedProjections(viewerUserID, visible, users, locations, viewerTier)
+	if err != nil {
+		return nil, err
+	}
+	passports, err := s.passportRepo.GetActivePassports(visible)
+	if err != nil {
+		log.Printf("Error getting passports: %v", err)
+		passports = map[int]*models.Passport{}
+	}
+
+	// Viewer-specific fields
+	for _, targetUserID := range visible {
+		profile, ok := projections[targetUserID]
+		if !ok {
+			continue
+		}
+		profile.SharedInterests = sharedInterests(viewerProfile, profile.Interests)
+		profile.Distance = distanceBucket(locations[viewerUserID], locations[targetUserID])
+		if passport, ok := passports[targetUserID]; ok {
+			profile.Traveling = true
+			profile.TravelingTo = passport.PlaceName
+		}
+		profiles[targetUserID] = profile
+	}
+	return profiles, nil
+}
+
+// ViewerTierFor returns the public profile tier of a viewer with the given entitlements
+func ViewerTierFor(entitlements *models.Entitlements) string {
+	if entitlements.IsPaid() {
+		return ViewerTierPremium
+	}
+	return ViewerTierFree
+}
+
+// InvalidatePublicProfile drops the cached projections of a user for every viewer tier
+func InvalidatePublicProfile(redisHelper helpers.RedisHandler, userID int) {
+	for _, tier := range []string{ViewerTierFree, ViewerTierPremium} {
+		if err := redisHelper.Delete(publicProfileCacheKey(userID, tier)); err != nil {
+			log.Printf("Error deleting public profile data in Redis: %v", err)
+		}
+	}
+}
+
+// visibleAmong returns the distinct targets the viewer may see, in order, and the users loaded to
+// decide it. Blocked pairs are hidden, and incognito users from anyone they have not liked; the
+// viewer can always see themselves.
+func (s *PublicProfileService) visibleAmong(viewerUserID int, targetUserIDs []int) ([]int, map[int]*models.User, error) {
+	var userIDs, otherUserIDs []int
+	seen := make(map[int]bool, len(targetUserIDs))
+	for _, targetUserID := range targetUserIDs {
+		if seen[targetUserID] {
+			continue
+		}
+		seen[targetUserID] = true
+		userIDs = append(userIDs, targetUserID)
+		if targetUserID != viewerUserID {
+			otherUserIDs = append(otherUserIDs, targetUserID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil, nil, nil
+	}
+
+	users, err := s.userRepo.GetUsersByIDs(userIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	blocked, err := s.blockRepo.GetBlockedAmong(viewerUserID, otherUserIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var incognito []int
+	for _, userID := range otherUserIDs {
+		if user, ok := users[userID]; ok && user.IsIncognito {
+			incognito = append(incognito, userID)
+		}
+	}
+	likers, err := s.swipeRepo.GetLikersAmong(viewerUserID, incognito)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	visible := make([]int, 0, len(userIDs))
+	for _, userID := range userIDs {
+		user, ok := users[userID]
+		if !ok || blocked[userID] {
+			continue
+		}
+		if _, liked := likers[userID]; user.IsIncognito && userID != viewerUserID && !liked {
+			continue
+		}
+		visible = append(visible, userID)
+	}
+	return visible, users, nil
+}
+
+// cachedProjections returns the viewer-independent part of the targets' projections, cached per
+// viewer tier, along with the viewer's own profile. Targets without a profile are left out. Cached
+// projections are read with a single MGET and the rest built from one batch of profiles.
+func (s *PublicProfileService) cachedProjections(viewerUserID int, targetUserIDs []int, users map[int]*models.User, locations map[int]*models.LocationHistory, viewerTier string) (map[int]*PublicProfile, *models.Profile, error) {
+	projections := make(map[int]*PublicProfile, len(targetUserIDs))
+
+	keys := make([]string, 0, len(targetUserIDs))
+	for _, targetUserID := range targetUserIDs {
+		keys = append(keys, publicProfileCacheKey(targetUserID, viewerTier))
+	}
+	cached, err := s.redisHelper.MGet(keys)
+	if err != nil {
+		log.Printf("Error getting public profile data from Redis: %v", err)
+		cached = make([][]byte, len(keys))
+	}
+
+	var missing []int
+	for i, value := range cached {
+		targetUserID := targetUserIDs[i]
+		if value != nil {
+			var projection PublicProfile
+			if err := json.Unmarshal(value, &projection); err == nil && projection.UserID == targetUserID {
+				projections[targetUserID] = &projection
+				continue
+			}
+		}
+		missing = append(missing, targetUserID)
+	}
+
+	profiles, err := s.profileRepo.GetProfilesByUserIDs(append(missing, viewerUserID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	toCache := make(map[string]interface{}, len(missing))
+	for _, targetUserID := range missing {
+		user, profile := users[targetUserID], profiles[targetUserID]
+		if profile == nil {
+			continue
+		}
+
+		projection := &PublicProfile{
+			UserID:              user.UserID,
+			Username:            user.Username,
+			Gender:              user.Gender,
+			Company:             user.Company,
+			School:              user.School,
+			JobTitle:            user.JobTitle,
+			Verified:            user.VerifiedBadge || user.VerificationBadge,
+			Photos:              profile.Photos,
+			AboutMe:             profile.AboutMe,
+			Interests:           profile.Interests,
+			RelationshipGoals:   profile.RelationshipGoals,
+			Height:              profile.Height,
+			Language:            profile.Language,
+			ZodiacSign:          profile.ZodiacSign,
+			EducationDetails:    profile.EducationDetails,
+			SocialMediaAccounts: profile.SocialMediaAccounts,
+		}
+		if viewerTier == ViewerTierPremium {
+			projection.LastActive = lastActive(locations[targetUserID])
+		}
+
+		projections[targetUserID] = projection
+		toCache[publicProfileCacheKey(targetUserID, viewerTier)] = projection
+	}
+	if len(toCache) > 0 {
+		if err := s.redisHelper.MSet(toCache, publicProfileCacheTTL); err != nil {
+			log.Printf("Error setting public profile data in Redis: %v", err)
+		}
+	}
+	return projections, profiles[viewerUserID], nil
+}
+
+// sharedInterests returns the interests the viewer has in common with the target
+func sharedInterests(viewerProfile *models.Profile, interests models.StringList) models.StringList {
+	shared := models.StringList{}
+	if viewerProfile == nil {
+		return shared
+	}
+
+	for _, interest := range interests {
+		if viewerProfile.Interests.Contains(interest) {
+			shared = append(shared, interest)
+		}
+	}
+	return shared
+}
+
+// distanceBucket measures between where each user is placed in discovery, so travelers appear
+// at their passport location
+func distanceBucket(viewerLocation, targetLocation *models.LocationHistory) string {
+	if viewerLocation == nil || targetLocation == nil {
+		return ""
+	}
+
+	km := helpers.HaversineDistance(viewerLocation.Latitude, viewerLocation.Longitude, targetLocation.Latitude, targetLocation.Longitude)
+	return helpers.DistanceBucket(km)
+}
+
+// lastActive describes how recently the user reported a location, at day granularity
+func lastActive(location *models.LocationHistory) string {
+	if location == nil {
+		return ""
+	}
+
+	switch since := time.Since(location.Timestamp); {
+	case since < 24*time.Hour:
+		return "Active today"
+	case since < 7*24*time.Hour:
+		return "Active this week"
+	default:
+		return "Active a while ago"
+	}
+}
+
+func publicProfileCacheKey(userID int, viewerTier string) string {
+	return fmt.Sprintf("public_profile:%d:%s", userID, viewerTier)
+}
@@ -0,0 +1,376 @@
+// services/public_profile_service_test.go
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+)
+
+// memoryUserDirectory is memoryUserRepo with some users in incognito mode and some given in full
+type memoryUserDirectory struct {
+	memoryUserRepo
+	incognito map[int]bool
+	users     map[int]models.User
+}
+
+func (r memoryUserDirectory) GetUserByID(userID int) (*models.User, error) {
+	if user, ok := r.users[userID]; ok {
+		return &user, nil
+	}
+	user, err := r.memoryUserRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	user.IsIncognito = r.incognito[userID]
+	return user, nil
+}
+
+func (r memoryUserDirectory) GetUsersByIDs(userIDs []int) (map[int]*models.User, error) {
+	users := make(map[int]*models.User, len(userIDs))
+	for _, userID := range userIDs {
+		user, err := r.GetUserByID(userID)
+		if err != nil {
+			return nil, err
+		}
+		users[userID] = user
+	}
+	return users, nil
+}
+
+func (r *memoryBlockRepo) GetBlockedAmong(userID int, otherUserIDs []int) (map[int]bool, error) {
+	blocked := make(map[int]bool)
+	for _, otherUserID := range otherUserIDs {
+		if isBlocked, _ := r.IsBlocked(userID, otherUserID); isBlocked {
+			blocked[otherUserID] = true
+		}
+	}
+	return blocked, nil
+}
+
+// memoryUserRepo loads several users like it loads one
+func (r memoryUserRepo) GetUsersByIDs(userIDs []int) (map[int]*models.User, error) {
+	users := make(map[int]*models.User, len(userIDs))
+	for _, userID := range userIDs {
+		user, err := r.GetUserByID(userID)
+		if err != nil {
+			return nil, err
+		}
+		users[userID] = user
+	}
+	return users, nil
+}
+
+// Nobody has reported a location or set a passport unless placed by the wrappers below
+func (r *memoryLocationRepo) GetDiscoveryLocations(userIDs []int) (map[int]*models.LocationHistory, error) {
+	return map[int]*models.LocationHistory{}, nil
+}
+
+func (r *memoryPassportRepo) GetActivePassports(userIDs []int) (map[int]*models.Passport, error) {
+	return map[int]*models.Passport{}, nil
+}
+
+// countingProfileRepo counts the calls loading several profiles at once
+type countingProfileRepo struct {
+	*memoryProfileRepo
+	batches int
+}
+
+func (r *countingProfileRepo) GetProfilesByUserIDs(userIDs []int) (map[int]*models.Profile, error) {
+	r.batches++
+	return r.memoryProfileRepo.GetProfilesByUserIDs(userIDs)
+}
+
+// placedLocationRepo places users in discovery where located says
+type placedLocationRepo struct {
+	*memoryLocationRepo
+	located map[int]*models.LocationHistory
+}
+
+func (r *placedLocationRepo) GetDiscoveryLocations(userIDs []int) (map[int]*models.LocationHistory, error) {
+	locations := make(map[int]*models.LocationHistory)
+	for _, userID := range userIDs {
+		if location, ok := r.located[userID]; ok {
+			locations[userID] = location
+		}
+	}
+	return locations, nil
+}
+
+// activePassportRepo serves the passports in active
+type activePassportRepo struct {
+	*memoryPassportRepo
+	active map[int]*models.Passport
+}
+
+func (r *activePassportRepo) GetActivePassports(userIDs []int) (map[int]*models.Passport, error) {
+	passports := make(map[int]*models.Passport)
+	for _, userID := range userIDs {
+		if passport, ok := r.active[userID]; ok {
+			passports[userID] = passport
+		}
+	}
+	return passports, nil
+}
+
+// memoryProfileViewRepo records the profile views
+type memoryProfileViewRepo struct {
+	views []models.ProfileView
+}
+
+func (r *memoryProfileViewRepo) RecordProfileView(viewerUserID, shownUserID int, viewedAt time.Time) error {
+	r.views = append(r.views, models.ProfileView{ViewerUserID: viewerUserID, ShownUserID: shownUserID, Timestamp: viewedAt})
+	return nil
+}
+
+type publicProfileFixture struct {
+	service   *PublicProfileService
+	users     *memoryUserDirectory
+	profiles  *countingProfileRepo
+	locations *placedLocationRepo
+	swipes    *memorySwipeRepo
+	blocks    *memoryBlockRepo
+	passports *activePassportRepo
+	views     *memoryProfileViewRepo
+	cache     map[string][]byte
+}
+
+// newProjectionFixture returns a public profile service over empty in-memory repositories and
+// a Redis cache kept in a map
+func newProjectionFixture() *publicProfileFixture {
+	fixture := &publicProfileFixture{
+		users:     &memoryUserDirectory{incognito: map[int]bool{}, users: map[int]models.User{}},
+		profiles:  &countingProfileRepo{memoryProfileRepo: &memoryProfileRepo{profiles: map[int]*models.Profile{}}},
+		locations: &placedLocationRepo{memoryLocationRepo: &memoryLocationRepo{}, located: map[int]*models.LocationHistory{}},
+		swipes:    &memorySwipeRepo{},
+		blocks:    &memoryBlockRepo{},
+		passports: &activePassportRepo{memoryPassportRepo: &memoryPassportRepo{}, active: map[int]*models.Passport{}},
+		views:     &memoryProfileViewRepo{},
+		cache:     map[string][]byte{},
+	}
+	redis := &mocks.MockRedisHandler{
+		MGetFunc: func(keys []string) ([][]byte, error) {
+			values := make([][]byte, len(keys))
+			for i, key := range keys {
+				values[i] = fixture.cache[key]
+			}
+			return values, nil
+		},
+		MSetFunc: func(values map[string]interface{}, expiration time.Duration) error {
+			for key, value := range values {
+				data, err := json.Marshal(value)
+				if err != nil {
+					return err
+				}
+				fixture.cache[key] = data
+			}
+			return nil
+		},
+	}
+	fixture.service = NewPublicProfileService(fixture.users, fixture.profiles, fixture.locations, fixture.blocks, fixture.swipes, fixture.views, fixture.passports, redis)
+	return fixture
+}
+
+func TestPublicProfileService_GetPublicProfilesHidesWhatTheViewerMayNotSee(t *testing.T) {
+	f := newProjectionFixture()
+	for userID := 1; userID <= 6; userID++ {
+		f.profiles.profiles[userID] = &models.Profile{UserID: userID, Interests: models.StringList{"hiking", "jazz"}}
+	}
+	delete(f.profiles.profiles, 6)
+	f.profiles.profiles[1].Interests = models.StringList{"jazz"}
+	// 3 blocked the viewer, 4 and 5 are incognito and only 5 liked the viewer, 6 has no profile
+	f.blocks.blocks = [][2]int{{3, 1}}
+	f.users.incognito[4] = true
+	f.users.incognito[5] = true
+	f.swipes.swipes = []models.SwipeHistory{{SwiperUserID: 5, SwipedUserID: 1, SwipeDirection: models.SwipeDirectionRight}}
+
+	profiles, err := f.service.GetPublicProfiles(1, []int{2, 3, 4, 5, 6, 2}, ViewerTierFree)
+	if err != nil {
+		t.Fatalf("get public profiles failed: %v", err)
+	}
+	if len(profiles) != 2 || profiles[2] == nil || profiles[5] == nil {
+		t.Fatalf("expected only users 2 and 5, got %v", profiles)
+	}
+	if shared := profiles[2].SharedInterests; len(shared) != 1 || shared[0] != "jazz" {
+		t.Errorf("expected jazz to be shared, got %v", shared)
+	}
+
+	// A single profile is hidden the same way as a missing one
+	if _, err := f.service.GetPublicProfile(1, 3, ViewerTierFree); err != ErrProfileNotVisible {
+		t.Errorf("expected the blocked profile not to be visible, got %v", err)
+	}
+}
+
+func TestPublicProfileService_GetPublicProfilesLoadsEveryTargetAtOnce(t *testing.T) {
+	f := newProjectionFixture()
+	now := time.Now()
+	f.locations.located[1] = &models.LocationHistory{UserID: 1, Latitude: 12.97, Longitude: 77.59, Timestamp: now}
+	targets := []int{2, 3, 4, 5}
+	for _, userID := range targets {
+		f.profiles.profiles[userID] = &models.Profile{UserID: userID, AboutMe: "hello", Photos: models.StringList{"/photos/1"}}
+		f.locations.located[userID] = &models.LocationHistory{UserID: userID, Latitude: 12.98, Longitude: 77.60, Timestamp: now.Add(-time.Hour)}
+	}
+	f.passports.active[4] = &models.Passport{UserID: 4, PlaceName: "Goa"}
+
+	profiles, err := f.service.GetPublicProfiles(1, targets, ViewerTierPremium)
+	if err != nil {
+		t.Fatalf("get public profiles failed: %v", err)
+	}
+	if f.profiles.batches != 1 {
+		t.Fatalf("expected the profiles to be loaded in one batch, got %d", f.profiles.batches)
+	}
+	for _, userID := range targets {
+		profile := profiles[userID]
+		if profile == nil || profile.Username == "" || len(profile.Photos) != 1 || profile.LastActive != "Active today" || profile.Distance == "" {
+			t.Fatalf("expected a full premium profile of user %d, got %+v", userID, profile)
+		}
+		if profile.Traveling != (userID == 4) {
+			t.Errorf("expected only user 4 to be traveling, got %+v", profile)
+		}
+	}
+	if profiles[4].TravelingTo != "Goa" {
+		t.Errorf("expected user 4 to be traveling to Goa, got %q", profiles[4].TravelingTo)
+	}
+
+	// The projections are cached per tier, so only the viewer's own profile is loaded again
+	f.profiles.profiles[2].AboutMe = "changed"
+	profiles, err = f.service.GetPublicProfiles(1, targets, ViewerTierPremium)
+	if err != nil {
+		t.Fatalf("get public profiles failed: %v", err)
+	}
+	if profiles[2].AboutMe != "hello" || profiles[4].TravelingTo != "Goa" {
+		t.Errorf("expected the cached projection with fresh viewer-specific fields, got %+v", profiles[2])
+	}
+
+	// Free viewers do not see when users were last active
+	profiles, err = f.service.GetPublicProfiles(1, targets, ViewerTierFree)
+	if err != nil {
+		t.Fatalf("get public profiles failed: %v", err)
+	}
+	if profiles[2].LastActive != "" || profiles[2].AboutMe != "changed" {
+		t.Errorf("expected a fresh free projection without last active, got %+v", profiles[2])
+	}
+}
+
+func TestPublicProfileService_GetPublicProfileLeavesOutPrivateFields(t *testing.T) {
+	f := newProjectionFixture()
+	birthDate := time.Date(1995, 6, 1, 0, 0, 0, 0, time.UTC)
+	f.users.users[2] = models.User{
+		UserID: 2, Username: "user2", Email: "user2@example.com", Password: "$2a$10$hash",
+		VerificationBadge: true, BirthDate: &birthDate, TimeZone: "Asia/Kolkata",
+	}
+	f.profiles.profiles[1] = &models.Profile{UserID: 1, Interests: models.StringList{"jazz"}}
+	f.profiles.profiles[2] = &models.Profile{UserID: 2, AboutMe: "hello", Interests: models.StringList{"hiking", "jazz"}}
+	now := time.Now()
+	f.locations.located[1] = &models.LocationHistory{UserID: 1, Latitude: 12.9716, Longitude: 77.5946, Timestamp: now}
+	f.locations.located[2] = &models.LocationHistory{UserID: 2, Latitude: 12.9352, Longitude: 77.6245, Timestamp: now}
+
+	profile, err := f.service.GetPublicProfile(1, 2, ViewerTierFree)
+	if err != nil {
+		t.Fatalf("get public profile failed: %v", err)
+	}
+	if !profile.Verified || profile.Distance != "Less than 10 km away" || len(profile.SharedInterests) != 1 {
+		t.Errorf("expected the badge, the distance bucket and the shared interest, got %+v", profile)
+	}
+
+	// Neither the response nor the cached projection carries the email, password, location or
+	// dates of the user
+	encoded, _ := json.Marshal(profile)
+	for _, data := range [][]byte{encoded, f.cache[publicProfileCacheKey(2, ViewerTierFree)]} {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatalf("invalid projection %s: %v", data, err)
+		}
+		for _, private := range []string{"email", "password", "latitude", "longitude", "birthDate", "timeZone", "expiresAt"} {
+			if _, ok := fields[private]; ok {
+				t.Errorf("expected no %s in %s", private, data)
+			}
+		}
+		for _, value := range []string{"user2@example.com", "$2a$10$hash", "12.9352", "77.6245", "1995"} {
+			if strings.Contains(string(data), value) {
+				t.Errorf("expected %s not to appear in %s", value, data)
+			}
+		}
+	}
+}
+
+func TestPublicProfileService_GetPublicProfileRecordsTheView(t *testing.T) {
+	f := newProjectionFixture()
+	f.users.users[1] = models.User{UserID: 1, Username: "user1", TimeZone: "America/Los_Angeles"}
+	for userID := 1; userID <= 3; userID++ {
+		f.profiles.profiles[userID] = &models.Profile{UserID: userID}
+	}
+	f.blocks.blocks = [][2]int{{1, 3}}
+
+	before := time.Now()
+	if _, err := f.service.GetPublicProfile(1, 2, ViewerTierFree); err != nil {
+		t.Fatalf("get public profile failed: %v", err)
+	}
+	if len(f.views.views) != 1 {
+		t.Fatalf("expected the view recorded, got %+v", f.views.views)
+	}
+	// The view is on the viewer's local day
+	view := f.views.views[0]
+	if view.ViewerUserID != 1 || view.ShownUserID != 2 || view.Timestamp.Location().String() != "America/Los_Angeles" || view.Timestamp.Before(before) {
+		t.Errorf("expected user 1's view of user 2 at their local time, got %+v", view)
+	}
+
+	// Viewing your own profile, or one you may not see, is not a view
+	if _, err := f.service.GetPublicProfile(1, 1, ViewerTierFree); err != nil {
+		t.Fatalf("get own profile failed: %v", err)
+	}
+	if _, err := f.service.GetPublicProfile(1, 3, ViewerTierFree); err != ErrProfileNotVisible {
+		t.Fatalf("expected the blocked profile not to be visible, got %v", err)
+	}
+	if len(f.views.views) != 1 {
+		t.Errorf("expected no more views, got %+v", f.views.views)
+	}
+
+	// Listing profiles does not record views either
+	if _, err := f.service.GetPublicProfiles(1, []int{2}, ViewerTierFree); err != nil || len(f.views.views) != 1 {
+		t.Errorf("expected listing not to record a view, got %+v (%v)", f.views.views, err)
+	}
+}
+
+func TestPublicProfileService_CanView(t *testing.T) {
+	f := newProjectionFixture()
+	// 2 blocked the viewer, 3 and 4 are incognito and only 4 liked the viewer, 5 is incognito too
+	f.blocks.blocks = [][2]int{{2, 1}}
+	f.users.incognito[3] = true
+	f.users.incognito[4] = true
+	f.users.incognito[5] = true
+	f.swipes.swipes = []models.SwipeHistory{{SwiperUserID: 4, SwipedUserID: 1, SwipeDirection: models.SwipeDirectionRight}}
+
+	tests := []struct {
+		name   string
+		viewer int
+		target int
+		want   bool
+	}{
+		{"own profile", 1, 1, true},
+		{"incognito owner sees themselves", 5, 5, true},
+		{"visible user", 1, 6, true},
+		{"blocked the viewer", 1, 2, false},
+		{"blocked by the viewer", 2, 1, false},
+		{"incognito without a like", 1, 3, false},
+		{"incognito who liked the viewer", 1, 4, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := f.service.CanView(tt.viewer, tt.target)
+			if err != nil {
+				t.Fatalf("CanView() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CanView(%d, %d) = %v, want %v", tt.viewer, tt.target, got, tt.want)
+			}
+		})
+	}
+	if len(f.views.views) != 0 {
+		t.Errorf("expected no views recorded, got %+v", f.views.views)
+	}
+}
@@ -0,0 +1,465 @@
+// services/push_service.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// PushQueue is the broker queue whose events are sent as push notifications
+const PushQueue = "push"
+
+// Environment variables overriding DefaultPushPolicy
+const (
+	PushNotificationTypesEnv = "PUSH_NOTIFICATION_TYPES"
+	PushIntervalEnv          = "PUSH_INTERVAL"
+	PushBatchEnv             = "PUSH_BATCH_SIZE"
+	PushMaxAttemptsEnv       = "PUSH_MAX_ATTEMPTS"
+	PushDigestTypesEnv       = "PUSH_DIGEST_TYPES"
+)
+
+// PushPolicy decides which notification types are pushed to the user's devices and which of them
+// are low priority, going into the digest of users who enabled it. It also sets how often the
+// worker looks for deliveries to send and how many it sends at a time, and how often it tries a
+// delivery before giving up. Claimed deliveries are leased to the worker for Lease.
+type PushPolicy struct {
+	Types       []string
+	DigestTypes []string
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	Lease       time.Duration
+}
+
+// DefaultPushPolicy returns the policy used when none is configured. Digest types are pushed too,
+// so each of them is also one of the pushed types.
+func DefaultPushPolicy() PushPolicy {
+	return PushPolicy{
+		Types:       []string{NotificationTypeMatched, NotificationTypeNewMessage, NotificationTypePremiumUnlocked, NotificationTypeSuperLike, NotificationTypeSubscriptionExpiring},
+		DigestTypes: []string{NotificationTypePremiumUnlocked, NotificationTypeSuperLike, NotificationTypeSubscriptionExpiring},
+		Interval:    time.Second,
+		BatchSize:   100,
+		MaxAttempts: 5,
+		Lease:       time.Minute,
+	}
+}
+
+// PushPolicyFromEnv reads the policy from the environment, keeping the default for any value that
+// is unset or invalid. PUSH_NOTIFICATION_TYPES and PUSH_DIGEST_TYPES are comma separated lists of
+// notification types.
+func PushPolicyFromEnv() PushPolicy {
+	policy := DefaultPushPolicy()
+	policy.Types = notificationTypesEnv(PushNotificationTypesEnv, policy.Types)
+	policy.DigestTypes = notificationTypesEnv(PushDigestTypesEnv, policy.DigestTypes)
+	policy.Interval = positiveDurationEnv(PushIntervalEnv, policy.Interval)
+	policy.BatchSize = positiveIntEnv(PushBatchEnv, policy.BatchSize)
+	policy.MaxAttempts = positiveIntEnv(PushMaxAttemptsEnv, policy.MaxAttempts)
+	return policy
+}
+
+func notificationTypesEnv(name string, fallback []string) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	var types []string
+	for _, notificationType := range strings.Split(value, ",") {
+		if notificationType = strings.TrimSpace(notificationType); notificationType != "" {
+			types = append(types, notificationType)
+		}
+	}
+	return types
+}
+
+// PushReport counts the deliveries handled by one worker run. Deferred deliveries were held back
+// for the user's quiet hours or digest; errored ones could not be handled and are tried again when
+// their lease runs out.
+type PushReport struct {
+	Sent     int
+	Skipped  int
+	Deferred int
+	Retried  int
+	Failed   int
+	Errored  int
+}
+
+// PushService sends notifications of the selected types to the user's devices. A delivery is
+// recorded for each such notification when it is created, and the worker sends the due ones
+// through the push provider of each device's platform. Just before sending, the worker checks the
+// user's preferences: muted notifications are skipped, and pushes are held back until the user's
+// quiet hours end. Low priority notifications of users with the digest enabled wait for the
+// digest, which sends them all in one push. A delivery is sent once any device receives it;
+// failures are retried with exponential backoff until MaxAttempts. Tokens the provider rejects as
+// invalid are deleted.
+type PushService struct {
+	notificationRepo repository.NotificationRepository
+	deviceTokenRepo  repository.DeviceTokenRepository
+	deliveryRepo     repository.NotificationDeliveryRepository
+	preferencesRepo  repository.NotificationPreferencesRepository
+	userRepo         repository.UserRepository
+	senders          map[string]helpers.PushSender
+	types            map[string]bool
+	digestTypes      map[string]bool
+	policy           PushPolicy
+	now              func() time.Time
+}
+
+// NewPushService creates a new instance of PushService with a sender for each platform
+func NewPushService(notificationRepo repository.NotificationRepository, deviceTokenRepo repository.DeviceTokenRepository, deliveryRepo repository.NotificationDeliveryRepository, preferencesRepo repository.NotificationPreferencesRepository, userRepo repository.UserRepository, senders []helpers.PushSender, policy PushPolicy) *PushService {
+	service := &PushService{
+		notificationRepo: notificationRepo,
+		deviceTokenRepo:  deviceTokenRepo,
+		deliveryRepo:     deliveryRepo,
+		preferencesRepo:  preferencesRepo,
+		userRepo:         userRepo,
+		senders:          make(map[string]helpers.PushSender),
+		types:            make(map[string]bool),
+		digestTypes:      make(map[string]bool),
+		policy:           policy,
+		now:              time.Now,
+	}
+	for _, sender := range senders {
+		service.senders[sender.Platform()] = sender
+	}
+	for _, notificationType := range policy.Types {
+		service.types[notificationType] = true
+	}
+	for _, notificationType := range policy.DigestTypes {
+		service.digestTypes[notificationType] = true
+	}
+	return service
+}
+
+// Subscribe makes the service record push deliveries for the notifications created on the bus
+func (s *PushService) Subscribe(bus *EventBus) {
+	bus.Subscribe(models.EventNotificationCreated, "push-deliveries", s.onNotificationCreated)
+}
+
+// RegisterDevice registers the device for its user's push notifications
+func (s *PushService) RegisterDevice(device *models.DeviceToken) error {
+	return s.deviceTokenRepo.RegisterToken(device)
+}
+
+// UnregisterDevice stops push notifications to one of the user's devices and reports whether it
+// was registered
+func (s *PushService) UnregisterDevice(userID int, token string) (bool, error) {
+	return s.deviceTokenRepo.DeleteToken(userID, token)
+}
+
+// Deliveries returns how the notification was delivered on each channel
+func (s *PushService) Deliveries(notificationID int) ([]models.NotificationDelivery, error) {
+	deliveries, err := s.deliveryRepo.GetDeliveries(notificationID)
+	if err != nil {
+		return nil, err
+	}
+	if deliveries == nil {
+		deliveries = []models.NotificationDelivery{}
+	}
+	return deliveries, nil
+}
+
+// Start sends the due deliveries on every interval until the context is cancelled. A full batch is
+// followed by the next one at once.
+func (s *PushService) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := s.RunOnce(ctx)
+		if err != nil {
+			log.Printf("Push delivery failed: %v", err)
+		} else if report.Retried+report.Failed+report.Errored > 0 {
+			log.Printf("Pushed %d notifications, skipped %d, deferred %d, will retry %d, gave up on %d and could not handle %d", report.Sent, report.Skipped, report.Deferred, report.Retried, report.Failed, report.Errored)
+		}
+
+		if err == nil && report.Sent+report.Skipped+report.Deferred+report.Retried+report.Failed+report.Errored >= s.policy.BatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce sends one batch of the deliveries that are due, then the digests that are due. A delivery
+// that cannot be handled is logged and left for its lease to run out, without holding up the rest.
+func (s *PushService) RunOnce(ctx context.Context) (PushReport, error) {
+	var report PushReport
+	deliveries, err := s.deliveryRepo.ClaimDeliveries(s.now(), s.policy.Lease, s.policy.BatchSize)
+	if err != nil {
+		return report, err
+	}
+	for i := range deliveries {
+		if err := s.deliver(ctx, &deliveries[i], &report); err != nil {
+			s.abandon(&deliveries[i], models.DeliveryStatusPending, err, &report)
+		}
+	}
+
+	digests, err := s.deliveryRepo.ClaimDigests(s.now(), s.policy.Lease, s.policy.BatchSize)
+	if err != nil {
+		return report, err
+	}
+	for start := 0; start < len(digests); {
+		end := start + 1
+		for end < len(digests) && digests[end].UserID == digests[start].UserID {
+			end++
+		}
+		if err := s.deliverDigest(ctx, digests[start:end], &report); err != nil {
+			for i := start; i < end; i++ {
+				s.abandon(&digests[i], models.DeliveryStatusDigest, err, &report)
+			}
+		}
+		start = end
+	}
+	return report, nil
+}
+
+// deliver sends the notification to each of the user's devices, unless the user's preferences
+// hold it back, and saves the outcome
+func (s *PushService) deliver(ctx context.Context, delivery *models.NotificationDelivery, report *PushReport) error {
+	notification, err := s.notificationRepo.GetNotificationByID(delivery.NotificationID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		report.Skipped++
+		return s.finish(delivery, models.DeliveryStatusSkipped, "notification was deleted")
+	}
+	if err != nil {
+		return err
+	}
+	preferences, location, err := s.preferences(delivery.UserID)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	if preferences.IsMuted(notification.NotificationType, models.DeliveryChannelPush) {
+		report.Skipped++
+		return s.finish(delivery, models.DeliveryStatusSkipped, "muted by the user")
+	}
+	if preferences.DigestEnabled && s.digestTypes[notification.NotificationType] {
+		report.Deferred++
+		return s.postpone(delivery, models.DeliveryStatusDigest, preferences.NextDigestAt(now, location))
+	}
+	if until := preferences.QuietUntil(now, location); !until.IsZero() {
+		report.Deferred++
+		return s.postpone(delivery, models.DeliveryStatusPending, until)
+	}
+
+	sent, sendErr, err := s.push(ctx, delivery.UserID, helpers.PushMessage{
+		Title: notification.NotificationType,
+		Body:  notification.Message,
+		Data: map[string]string{
+			"notificationID":   strconv.Itoa(notification.NotificationID),
+			"notificationType": notification.NotificationType,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return s.settle(delivery, sent, sendErr, models.DeliveryStatusPending, report)
+}
+
+// deliverDigest sends one push summing up the notifications waiting for the user's digest. Those
+// deleted or muted since are skipped, and the digest waits for the end of the user's quiet hours.
+func (s *PushService) deliverDigest(ctx context.Context, deliveries []models.NotificationDelivery, report *PushReport) error {
+	userID := deliveries[0].UserID
+	preferences, location, err := s.preferences(userID)
+	if err != nil {
+		return err
+	}
+
+	var batch []*models.NotificationDelivery
+	var notifications []*models.Notification
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		notification, err := s.notificationRepo.GetNotificationByID(delivery.NotificationID)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			report.Skipped++
+			err = s.finish(delivery, models.DeliveryStatusSkipped, "notification was deleted")
+		case err == nil && preferences.IsMuted(notification.NotificationType, models.DeliveryChannelPush):
+			report.Skipped++
+			err = s.finish(delivery, models.DeliveryStatusSkipped, "muted by the user")
+		case err == nil:
+			batch = append(batch, delivery)
+			notifications = append(notifications, notification)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if until := preferences.QuietUntil(s.now(), location); !until.IsZero() {
+		for _, delivery := range batch {
+			report.Deferred++
+			if err := s.postpone(delivery, models.DeliveryStatusDigest, until); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ids := make([]string, len(notifications))
+	for i, notification := range notifications {
+		ids[i] = strconv.Itoa(notification.NotificationID)
+	}
+	message := helpers.PushMessage{
+		Title: "Your notification digest",
+		Body:  fmt.Sprintf("You have %d new notifications", len(notifications)),
+		Data:  map[string]string{"digest": "true", "notificationIDs": strings.Join(ids, ",")},
+	}
+	if len(notifications) == 1 {
+		message.Body = notifications[0].Message
+	}
+	sent, sendErr, err := s.push(ctx, userID, message)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range batch {
+		if err := s.settle(delivery, sent, sendErr, models.DeliveryStatusDigest, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preferences returns the user's notification preferences and time zone
+func (s *PushService) preferences(userID int) (*models.NotificationPreferences, *time.Location, error) {
+	preferences, err := s.preferencesRepo.GetPreferences(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return preferences, user.Location(), nil
+}
+
+// push sends the message to each of the user's devices and deletes the tokens the providers
+// reject. It returns how many devices received it and the last error to retry, if any.
+func (s *PushService) push(ctx context.Context, userID int, message helpers.PushMessage) (sent int, sendErr error, err error) {
+	devices, err := s.deviceTokenRepo.GetTokens(userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var invalid []string
+	for _, device := range devices {
+		sender, ok := s.senders[device.Platform]
+		if !ok {
+			continue
+		}
+		message.Token = device.Token
+		err := sender.Send(ctx, message)
+		switch {
+		case err == nil:
+			sent++
+		case errors.Is(err, helpers.ErrInvalidDeviceToken):
+			invalid = append(invalid, device.Token)
+		default:
+			sendErr = err
+		}
+	}
+	if err := s.deviceTokenRepo.DeleteTokens(invalid); err != nil {
+		return 0, nil, err
+	}
+	return sent, sendErr, nil
+}
+
+// settle saves the outcome of sending the delivery to sent devices. A failed delivery is retried
+// with the given status until it runs out of attempts.
+func (s *PushService) settle(delivery *models.NotificationDelivery, sent int, sendErr error, retryStatus string, report *PushReport) error {
+	switch {
+	case sent > 0:
+		report.Sent++
+		now := s.now()
+		delivery.DeliveredAt = &now
+		return s.finish(delivery, models.DeliveryStatusSent, "")
+	case sendErr == nil:
+		report.Skipped++
+		return s.finish(delivery, models.DeliveryStatusSkipped, "no devices to send to")
+	case delivery.Attempts >= s.policy.MaxAttempts:
+		report.Failed++
+		log.Printf("Giving up on pushing notification %d after %d attempts: %v", delivery.NotificationID, delivery.Attempts, sendErr)
+		return s.finish(delivery, models.DeliveryStatusFailed, sendErr.Error())
+	default:
+		report.Retried++
+		delivery.NextAttemptAt = s.now().Add(outboxRetryDelay(delivery.Attempts))
+		return s.finish(delivery, retryStatus, sendErr.Error())
+	}
+}
+
+// abandon logs a delivery that could not be handled. It is left claimed, to be tried again once
+// its lease runs out, unless it has run out of attempts and is failed. A delivery whose status
+// changed before the error is left as it is.
+func (s *PushService) abandon(delivery *models.NotificationDelivery, claimedStatus string, err error, report *PushReport) {
+	log.Printf("Error pushing notification %d: %v", delivery.NotificationID, err)
+	if delivery.Status != claimedStatus {
+		return
+	}
+	if delivery.Attempts < s.policy.MaxAttempts {
+		report.Errored++
+		return
+	}
+
+	report.Failed++
+	if err := s.finish(delivery, models.DeliveryStatusFailed, err.Error()); err != nil {
+		log.Printf("Error failing the push of notification %d: %v", delivery.NotificationID, err)
+	}
+}
+
+// postpone holds the delivery back until the given time. Waiting does not count as an attempt.
+func (s *PushService) postpone(delivery *models.NotificationDelivery, status string, until time.Time) error {
+	delivery.Attempts--
+	delivery.NextAttemptAt = until
+	return s.finish(delivery, status, "")
+}
+
+// finish saves the delivery with its new status
+func (s *PushService) finish(delivery *models.NotificationDelivery, status, lastError string) error {
+	delivery.Status = status
+	delivery.LastError = lastError
+	delivery.UpdatedAt = s.now()
+	return s.deliveryRepo.SaveDelivery(delivery)
+}
+
+// onNotificationCreated records a push delivery for a notification of a type that is pushed
+func (s *PushService) onNotificationCreated(event models.DomainEvent, effects EffectLog) error {
+	created, ok := event.(models.NotificationCreated)
+	if !ok {
+		return fmt.Errorf("unexpected %s event %T", event.EventName(), event)
+	}
+	if !s.types[created.NotificationType] {
+		return nil
+	}
+
+	now := s.now()
+	return s.deliveryRepo.CreateDelivery(&models.NotificationDelivery{
+		NotificationID: created.NotificationID,
+		Channel:        models.DeliveryChannelPush,
+		UserID:         created.UserID,
+		Status:         models.DeliveryStatusPending,
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	})
+}
@@ -0,0 +1,361 @@
+// services/push_service_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// memoryDeviceTokenRepo keeps the registered devices in memory
+type memoryDeviceTokenRepo struct {
+	repository.DeviceTokenRepository
+	devices []models.DeviceToken
+}
+
+func (r *memoryDeviceTokenRepo) GetTokens(userID int) ([]models.DeviceToken, error) {
+	var tokens []models.DeviceToken
+	for _, device := range r.devices {
+		if device.UserID == userID {
+			tokens = append(tokens, device)
+		}
+	}
+	return tokens, nil
+}
+
+func (r *memoryDeviceTokenRepo) DeleteTokens(tokens []string) error {
+	for _, token := range tokens {
+		for i := range r.devices {
+			if r.devices[i].Token == token {
+				r.devices = append(r.devices[:i], r.devices[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// memoryDeliveryRepo keeps the deliveries in memory
+type memoryDeliveryRepo struct {
+	repository.NotificationDeliveryRepository
+	deliveries []models.NotificationDelivery
+}
+
+func (r *memoryDeliveryRepo) CreateDelivery(delivery *models.NotificationDelivery) error {
+	if r.find(delivery.NotificationID, delivery.Channel) == nil {
+		r.deliveries = append(r.deliveries, *delivery)
+	}
+	return nil
+}
+
+func (r *memoryDeliveryRepo) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]models.NotificationDelivery, error) {
+	var claimed []models.NotificationDelivery
+	for i := range r.deliveries {
+		delivery := &r.deliveries[i]
+		if len(claimed) < limit && delivery.Status == models.DeliveryStatusPending && !delivery.NextAttemptAt.After(now) {
+			delivery.NextAttemptAt = now.Add(lease)
+			delivery.Attempts++
+			claimed = append(claimed, *delivery)
+		}
+	}
+	return claimed, nil
+}
+
+func (r *memoryDeliveryRepo) ClaimDigests(now time.Time, lease time.Duration, limit int) ([]models.NotificationDelivery, error) {
+	users := make(map[int]bool)
+	var claimed []models.NotificationDelivery
+	for i := range r.deliveries {
+		delivery := &r.deliveries[i]
+		if delivery.Status != models.DeliveryStatusDigest || delivery.NextAttemptAt.After(now) || (!users[delivery.UserID] && len(users) == limit) {
+			continue
+		}
+		users[delivery.UserID] = true
+		delivery.NextAttemptAt = now.Add(lease)
+		delivery.Attempts++
+		claimed = append(claimed, *delivery)
+	}
+	return claimed, nil
+}
+
+func (r *memoryDeliveryRepo) SaveDelivery(delivery *models.NotificationDelivery) error {
+	*r.find(delivery.NotificationID, delivery.Channel) = *delivery
+	return nil
+}
+
+func (r *memoryDeliveryRepo) find(notificationID int, channel string) *models.NotificationDelivery {
+	for i := range r.deliveries {
+		if r.deliveries[i].NotificationID == notificationID && r.deliveries[i].Channel == channel {
+			return &r.deliveries[i]
+		}
+	}
+	return nil
+}
+
+func TestPushService_SendsSelectedNotificationsToEveryDevice(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	notificationRepo := &memoryNotificationRepo{}
+	deviceTokenRepo := &memoryDeviceTokenRepo{devices: []models.DeviceToken{
+		{UserID: 1, Platform: models.DevicePlatformAndroid, Token: "android-1"},
+		{UserID: 1, Platform: models.DevicePlatformIOS, Token: "ios-1"},
+		{UserID: 2, Platform: models.DevicePlatformAndroid, Token: "android-2"},
+	}}
+	deliveryRepo := &memoryDeliveryRepo{}
+
+	android := helpers.NewFakePushSender(models.DevicePlatformAndroid)
+	android.Fail = func(message helpers.PushMessage) error {
+		if message.Token == "android-2" {
+			return errors.New("fcm unavailable")
+		}
+		return nil
+	}
+	ios := helpers.NewFakePushSender(models.DevicePlatformIOS)
+	ios.InvalidTokens["ios-1"] = true
+
+	policy := DefaultPushPolicy()
+	policy.MaxAttempts = 2
+	service := NewPushService(notificationRepo, deviceTokenRepo, deliveryRepo, &memoryNotificationPreferencesRepo{}, memoryUserRepo{}, []helpers.PushSender{android, ios}, policy)
+	service.now = func() time.Time { return now }
+	bus := NewEventBus()
+	service.Subscribe(bus)
+
+	notifications := []models.Notification{
+		{UserID: 1, NotificationType: NotificationTypeMatched, Message: "You matched with user2!"},
+		{UserID: 2, NotificationType: NotificationTypeNewMessage, Message: "user1 sent you a message"},
+		{UserID: 1, NotificationType: NotificationTypeDataExportReady, Message: "Your data export is ready"},
+	}
+	for i := range notifications {
+		if err := notificationRepo.CreateNotification(&notifications[i]); err != nil {
+			t.Fatal(err)
+		}
+		created := models.NotificationCreated{
+			NotificationID:   notifications[i].NotificationID,
+			UserID:           notifications[i].UserID,
+			NotificationType: notifications[i].NotificationType,
+		}
+		// Redelivered events must not push twice
+		for j := 0; j < 2; j++ {
+			if err := bus.Dispatch(created); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(deliveryRepo.deliveries) != 2 {
+		t.Fatalf("Expected push deliveries for the match and the message only, got %+v", deliveryRepo.deliveries)
+	}
+
+	report, err := service.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (PushReport{Sent: 1, Retried: 1}) {
+		t.Fatalf("Unexpected first report %+v", report)
+	}
+	sent := android.Sent()
+	if len(sent) != 1 || sent[0].Token != "android-1" || sent[0].Body != "You matched with user2!" || sent[0].Data["notificationID"] != "1" {
+		t.Fatalf("Expected the match pushed to the valid device, got %+v", sent)
+	}
+	if devices, _ := deviceTokenRepo.GetTokens(1); len(devices) != 1 || devices[0].Token != "android-1" {
+		t.Fatalf("Expected the invalid iOS token to be deleted, got %+v", devices)
+	}
+	if match := deliveryRepo.find(1, models.DeliveryChannelPush); match.Status != models.DeliveryStatusSent || match.DeliveredAt == nil {
+		t.Fatalf("Expected the match delivery to be sent, got %+v", match)
+	}
+	message := deliveryRepo.find(2, models.DeliveryChannelPush)
+	if message.Status != models.DeliveryStatusPending || message.LastError != "fcm unavailable" || !message.NextAttemptAt.Equal(now.Add(time.Second)) {
+		t.Fatalf("Expected the message delivery to be retried in a second, got %+v", message)
+	}
+
+	// Not due yet
+	if report, _ := service.RunOnce(context.Background()); report != (PushReport{}) {
+		t.Fatalf("Expected nothing due before the backoff, got %+v", report)
+	}
+
+	now = now.Add(time.Second)
+	report, err = service.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (PushReport{Failed: 1}) {
+		t.Fatalf("Expected the message delivery to be given up on, got %+v", report)
+	}
+	if message := deliveryRepo.find(2, models.DeliveryChannelPush); message.Status != models.DeliveryStatusFailed || message.Attempts != 2 {
+		t.Fatalf("Expected the message delivery to fail after two attempts, got %+v", message)
+	}
+}
+
+// zonedUserRepo returns users living in the time zone
+type zonedUserRepo struct {
+	memoryUserRepo
+	timeZone string
+}
+
+func (r zonedUserRepo) GetUserByID(userID int) (*models.User, error) {
+	user, err := r.memoryUserRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	user.TimeZone = r.timeZone
+	return user, nil
+}
+
+func TestPushService_FollowsMutesQuietHoursAndDigest(t *testing.T) {
+	// 23:00 in Jakarta, during the user's quiet hours
+	now := time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC)
+	notificationRepo := &memoryNotificationRepo{}
+	deviceTokenRepo := &memoryDeviceTokenRepo{devices: []models.DeviceToken{
+		{UserID: 1, Platform: models.DevicePlatformAndroid, Token: "android-1"},
+	}}
+	deliveryRepo := &memoryDeliveryRepo{}
+	preferencesRepo := &memoryNotificationPreferencesRepo{}
+	preferences := models.DefaultNotificationPreferences(1)
+	preferences.Muted = models.NotificationMutes{NotificationTypeNewMessage: {models.DeliveryChannelPush}}
+	preferences.QuietHoursStart = "22:00"
+	preferences.QuietHoursEnd = "07:00"
+	preferences.DigestEnabled = true
+	preferences.DigestTime = "09:00"
+	if err := preferencesRepo.SavePreferences(preferences); err != nil {
+		t.Fatal(err)
+	}
+
+	android := helpers.NewFakePushSender(models.DevicePlatformAndroid)
+	policy := DefaultPushPolicy()
+	policy.Types = append(policy.Types, NotificationTypeSuperLike)
+	service := NewPushService(notificationRepo, deviceTokenRepo, deliveryRepo, preferencesRepo, zonedUserRepo{timeZone: "Asia/Jakarta"}, []helpers.PushSender{android}, policy)
+	service.now = func() time.Time { return now }
+	bus := NewEventBus()
+	service.Subscribe(bus)
+
+	for _, notification := range []models.Notification{
+		{UserID: 1, NotificationType: NotificationTypeMatched, Message: "You matched with user2!"},
+		{UserID: 1, NotificationType: NotificationTypeNewMessage, Message: "user2 sent you a message"},
+		{UserID: 1, NotificationType: NotificationTypePremiumUnlocked, Message: "Your Gold plan is active"},
+		{UserID: 1, NotificationType: NotificationTypeSuperLike, Message: "user3 super liked you"},
+	} {
+		if err := notificationRepo.CreateNotification(&notification); err != nil {
+			t.Fatal(err)
+		}
+		if err := bus.Dispatch(models.NotificationCreated{
+			NotificationID:   notification.NotificationID,
+			UserID:           notification.UserID,
+			NotificationType: notification.NotificationType,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := service.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (PushReport{Skipped: 1, Deferred: 3}) || len(android.Sent()) != 0 {
+		t.Fatalf("Expected nothing pushed during quiet hours, got %+v", report)
+	}
+	quietEnd := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	if match := deliveryRepo.find(1, models.DeliveryChannelPush); match.Status != models.DeliveryStatusPending || !match.NextAttemptAt.Equal(quietEnd) || match.Attempts != 0 {
+		t.Fatalf("Expected the match held until 07:00 in Jakarta, got %+v", match)
+	}
+	if message := deliveryRepo.find(2, models.DeliveryChannelPush); message.Status != models.DeliveryStatusSkipped {
+		t.Fatalf("Expected the muted message to be skipped, got %+v", message)
+	}
+	digestAt := time.Date(2024, 3, 2, 2, 0, 0, 0, time.UTC)
+	for _, notificationID := range []int{3, 4} {
+		if digest := deliveryRepo.find(notificationID, models.DeliveryChannelPush); digest.Status != models.DeliveryStatusDigest || !digest.NextAttemptAt.Equal(digestAt) {
+			t.Fatalf("Expected notification %d to wait for the 09:00 digest, got %+v", notificationID, digest)
+		}
+	}
+
+	now = quietEnd
+	if report, err := service.RunOnce(context.Background()); err != nil || report != (PushReport{Sent: 1}) {
+		t.Fatalf("Expected the match pushed once quiet hours end, got %+v (%v)", report, err)
+	}
+
+	now = digestAt
+	if report, err := service.RunOnce(context.Background()); err != nil || report != (PushReport{Sent: 2}) {
+		t.Fatalf("Expected the digest sent, got %+v (%v)", report, err)
+	}
+	sent := android.Sent()
+	if len(sent) != 2 || sent[1].Body != "You have 2 new notifications" || sent[1].Data["notificationIDs"] != "3,4" {
+		t.Fatalf("Expected the match and one digest of both low priority notifications, got %+v", sent)
+	}
+}
+
+// brokenUserRepo fails to load one user
+type brokenUserRepo struct {
+	memoryUserRepo
+	brokenUserID int
+}
+
+func (r brokenUserRepo) GetUserByID(userID int) (*models.User, error) {
+	if userID == r.brokenUserID {
+		return nil, errors.New("connection reset")
+	}
+	return r.memoryUserRepo.GetUserByID(userID)
+}
+
+func TestPushService_CarriesOnPastADeliveryItCannotHandle(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	notificationRepo := &memoryNotificationRepo{}
+	deviceTokenRepo := &memoryDeviceTokenRepo{devices: []models.DeviceToken{
+		{UserID: 1, Platform: models.DevicePlatformAndroid, Token: "android-1"},
+		{UserID: 2, Platform: models.DevicePlatformAndroid, Token: "android-2"},
+	}}
+	deliveryRepo := &memoryDeliveryRepo{}
+	android := helpers.NewFakePushSender(models.DevicePlatformAndroid)
+
+	policy := DefaultPushPolicy()
+	policy.MaxAttempts = 2
+	service := NewPushService(notificationRepo, deviceTokenRepo, deliveryRepo, &memoryNotificationPreferencesRepo{}, brokenUserRepo{brokenUserID: 2}, []helpers.PushSender{android}, policy)
+	service.now = func() time.Time { return now }
+
+	// User 2's delivery comes first in the batch
+	for _, userID := range []int{2, 1} {
+		notification := models.Notification{UserID: userID, NotificationType: NotificationTypeMatched, Message: "You have a new match!"}
+		notificationRepo.CreateNotification(&notification)
+		deliveryRepo.CreateDelivery(&models.NotificationDelivery{
+			NotificationID: notification.NotificationID, UserID: userID, Channel: models.DeliveryChannelPush,
+			Status: models.DeliveryStatusPending, NextAttemptAt: now,
+		})
+	}
+
+	report, err := service.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("Expected the batch to carry on, got %v", err)
+	}
+	if report != (PushReport{Sent: 1, Errored: 1}) {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if sent := android.Sent(); len(sent) != 1 || sent[0].Token != "android-1" {
+		t.Fatalf("Expected user 1's push sent, got %+v", sent)
+	}
+	broken := deliveryRepo.find(1, models.DeliveryChannelPush)
+	if broken.Status != models.DeliveryStatusPending || !broken.NextAttemptAt.Equal(now.Add(policy.Lease)) {
+		t.Fatalf("Expected user 2's delivery left until its lease runs out, got %+v", broken)
+	}
+
+	// Out of attempts, it is given up on
+	now = now.Add(policy.Lease)
+	if report, err := service.RunOnce(context.Background()); err != nil || report != (PushReport{Failed: 1}) {
+		t.Fatalf("Expected user 2's delivery failed, got %+v (%v)", report, err)
+	}
+	if broken := deliveryRepo.find(1, models.DeliveryChannelPush); broken.Status != models.DeliveryStatusFailed || broken.LastError != "connection reset" {
+		t.Fatalf("Expected user 2's delivery failed with the error, got %+v", broken)
+	}
+}
+
+func TestDefaultPushPolicy_DigestTypesArePushed(t *testing.T) {
+	policy := DefaultPushPolicy()
+	for _, digestType := range policy.DigestTypes {
+		pushed := false
+		for _, pushType := range policy.Types {
+			pushed = pushed || pushType == digestType
+		}
+		if !pushed {
+			t.Errorf("expected digest type %q to be pushed", digestType)
+		}
+	}
+}
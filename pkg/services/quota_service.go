@@ -0,0 +1,104 @@
+// services/quota_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// QuotaLikes is the daily quota of likes, capped by Entitlements.DailyLikes
+const QuotaLikes = "likes"
+
+// ErrQuotaExceeded is returned when the user has used up a daily quota
+var ErrQuotaExceeded = errors.New("daily quota exceeded")
+
+// Quota is how much of a daily quota the user has used. Limit and Remaining are
+// models.UnlimitedQuota when there is no limit.
+type Quota struct {
+	Name      string    `json:"name"`
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetsAt  time.Time `json:"resetsAt"`
+}
+
+// QuotaService counts what users do each day in Redis. A day runs from midnight to midnight in the
+// user's own time zone, and each counter expires at the end of the day it counts.
+type QuotaService struct {
+	userRepo repository.UserRepository
+	redis    helpers.RedisHandler
+	now      func() time.Time
+}
+
+// NewQuotaService creates a new instance of QuotaService
+func NewQuotaService(userRepo repository.UserRepository, redis helpers.RedisHandler) *QuotaService {
+	return &QuotaService{
+		userRepo: userRepo,
+		redis:    redis,
+		now:      time.Now,
+	}
+}
+
+// Use counts one use of the quota, or returns ErrQuotaExceeded with the quota unchanged when the
+// user has reached the limit for today
+func (s *QuotaService) Use(userID int, name string, limit int) (*Quota, error) {
+	quota, changed, err := s.increment(userID, name, limit, 1)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return quota, ErrQuotaExceeded
+	}
+	return quota, nil
+}
+
+// Release gives back a use counted by Use, e.g. when the action it was for failed
+func (s *QuotaService) Release(userID int, name string, limit int) (*Quota, error) {
+	quota, _, err := s.increment(userID, name, limit, -1)
+	return quota, err
+}
+
+// Get returns how much of the quota the user has used today
+func (s *QuotaService) Get(userID int, name string, limit int) (*Quota, error) {
+	quota, _, err := s.increment(userID, name, limit, 0)
+	return quota, err
+}
+
+func (s *QuotaService) increment(userID int, name string, limit, by int) (*Quota, bool, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	start, end := localDay(s.now(), user.Location())
+
+	// The key names the local date, so a counter never outlives its day even if the user moves to
+	// another time zone
+	key := fmt.Sprintf("quota:%s:%d:%s", name, userID, start.Format("2006-01-02"))
+	used, changed, err := s.redis.IncrementCounter(key, int64(by), int64(limit), end)
+	if err != nil {
+		return nil, false, err
+	}
+
+	quota := &Quota{Name: name, Limit: limit, Used: int(used), Remaining: models.UnlimitedQuota, ResetsAt: end}
+	if limit != models.UnlimitedQuota {
+		quota.Remaining = limit - quota.Used
+		if quota.Remaining < 0 {
+			quota.Remaining = 0
+		}
+	}
+	return quota, changed, nil
+}
+
+// localDay returns the midnights starting and ending the day of now in the location. Days are not
+// always 24 hours long: they follow daylight saving time changes.
+func localDay(now time.Time, location *time.Location) (time.Time, time.Time) {
+	local := now.In(location)
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+	end := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, location)
+	return start, end
+}
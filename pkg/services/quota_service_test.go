@@ -0,0 +1,115 @@
+// services/quota_service_test.go
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// timeZoneUserRepo answers GetUserByID with users in the given time zone
+type timeZoneUserRepo struct {
+	repository.UserRepository
+	timeZone string
+}
+
+func (r timeZoneUserRepo) GetUserByID(userID int) (*models.User, error) {
+	return &models.User{UserID: userID, TimeZone: r.timeZone}, nil
+}
+
+// newQuotaFixture returns a quota service on a settable clock whose Redis counters expire on that
+// clock, like the IncrementCounter script
+func newQuotaFixture(timeZone string, now *time.Time) *QuotaService {
+	type counter struct {
+		value     int64
+		expiresAt time.Time
+	}
+	counters := make(map[string]*counter)
+	redis := &mocks.MockRedisHandler{
+		IncrementCounterFunc: func(key string, by, limit int64, expiresAt time.Time) (int64, bool, error) {
+			c, ok := counters[key]
+			if !ok || !now.Before(c.expiresAt) {
+				c = &counter{}
+				counters[key] = c
+			}
+			if by > 0 && limit >= 0 && c.value+by > limit {
+				return c.value, false, nil
+			}
+			if c.value += by; c.value < 0 {
+				c.value = 0
+			}
+			if by != 0 {
+				c.expiresAt = expiresAt
+			}
+			return c.value, true, nil
+		},
+	}
+
+	service := NewQuotaService(timeZoneUserRepo{timeZone: timeZone}, redis)
+	service.now = func() time.Time { return *now }
+	return service
+}
+
+func TestQuotaService_ResetsAtLocalMidnight(t *testing.T) {
+	// 23:30 in New York is already the next day in UTC
+	now := time.Date(2024, 3, 12, 3, 30, 0, 0, time.UTC)
+	service := newQuotaFixture("America/New_York", &now)
+
+	for i := 0; i < 2; i++ {
+		if _, err := service.Use(5, QuotaLikes, 2); err != nil {
+			t.Fatalf("like %d failed: %v", i+1, err)
+		}
+	}
+	quota, err := service.Use(5, QuotaLikes, 2)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected the quota to be exceeded, got %v", err)
+	}
+	newYork, _ := time.LoadLocation("America/New_York")
+	if want := time.Date(2024, 3, 12, 0, 0, 0, 0, newYork); !quota.ResetsAt.Equal(want) || quota.Remaining != 0 {
+		t.Fatalf("expected no likes left until %v, got %+v", want, quota)
+	}
+
+	// Past local midnight the quota is fresh, and a released use is given back
+	now = now.Add(31 * time.Minute)
+	if _, err := service.Use(5, QuotaLikes, 2); err != nil {
+		t.Fatalf("like after midnight failed: %v", err)
+	}
+	quota, err = service.Release(5, QuotaLikes, 2)
+	if err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+	if quota.Used != 0 || quota.Remaining != 2 {
+		t.Fatalf("expected the whole quota back, got %+v", quota)
+	}
+}
+
+func TestQuotaService_DaylightSavingDaysAndUnlimited(t *testing.T) {
+	// Clocks go forward on 10 March 2024 in New York, making the day 23 hours long
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	service := newQuotaFixture("America/New_York", &now)
+
+	quota, err := service.Use(5, QuotaLikes, models.UnlimitedQuota)
+	if err != nil {
+		t.Fatalf("like failed: %v", err)
+	}
+	if quota.Remaining != models.UnlimitedQuota || quota.Used != 1 {
+		t.Fatalf("expected an unlimited quota with one use, got %+v", quota)
+	}
+	if want := time.Date(2024, 3, 11, 4, 0, 0, 0, time.UTC); !quota.ResetsAt.Equal(want) {
+		t.Fatalf("expected the quota to reset at %v, got %v", want, quota.ResetsAt)
+	}
+
+	// Users without a time zone are on UTC
+	utc := newQuotaFixture("", &now)
+	quota, err = utc.Get(5, QuotaLikes, 10)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if want := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC); !quota.ResetsAt.Equal(want) || quota.Remaining != 10 {
+		t.Fatalf("expected 10 likes until %v, got %+v", want, quota)
+	}
+}
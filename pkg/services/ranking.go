@@ -0,0 +1,414 @@
+// services/ranking.go
+package services
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// Names of the built-in ranking features, used as keys in RankingWeights
+const (
+	FeatureDistance          = "distance"
+	FeatureSharedInterests   = "interests"
+	FeatureRelationshipGoals = "relationshipGoals"
+	FeatureRecency           = "recency"
+	FeatureCompleteness      = "completeness"
+	FeatureReciprocalLike    = "reciprocal"
+)
+
+// RankingWeightsEnv holds weights overriding the defaults, e.g. "distance=0.4,recency=0.1"
+const RankingWeightsEnv = "RANKING_WEIGHTS"
+
+// Viewer is the user the feed is being ranked for
+type Viewer struct {
+	UserID  int
+	Profile *models.Profile
+	Filter  repository.DiscoveryFilter
+}
+
+// Candidate is a user that may be shown to the viewer, with the data the features score on
+type Candidate struct {
+	Location repository.LocationWithDistance
+	Profile  *models.Profile
+
+	// LastActive is when the candidate last reported a location
+	LastActive time.Time
+	// LikedViewer is set when the candidate has already liked the viewer, SuperLikedViewer when
+	// that like was a super like
+	LikedViewer      bool
+	SuperLikedViewer bool
+	// Boosted is set while the candidate has a boost running
+	Boosted bool
+	// Swipes and Likes are the candidate's lifetime swipe counts
+	Swipes int
+	Likes  int
+}
+
+// ScoredCandidate is a candidate with its weighted score and the per-feature values behind it
+type ScoredCandidate struct {
+	Candidate
+	Score    float64
+	Features map[string]float64
+}
+
+// CandidateSource generates the pool of candidates to rank for a viewer
+type CandidateSource interface {
+	Candidates(viewer *Viewer, limit int) ([]Candidate, error)
+}
+
+// Feature scores one aspect of how good a candidate is for the viewer, in the range [0, 1]
+type Feature interface {
+	Name() string
+	Score(viewer *Viewer, candidate *Candidate) float64
+}
+
+// ReRanker adjusts the order of the scored candidates, e.g. to add variety
+type ReRanker interface {
+	ReRank(viewer *Viewer, ranked []ScoredCandidate) []ScoredCandidate
+}
+
+// RankingWeights maps feature names to their weight in the final score
+type RankingWeights map[string]float64
+
+// DefaultRankingWeights returns the weights used when none are configured
+func DefaultRankingWeights() RankingWeights {
+	return RankingWeights{
+		FeatureDistance:          0.25,
+		FeatureSharedInterests:   0.20,
+		FeatureRelationshipGoals: 0.15,
+		FeatureRecency:           0.15,
+		FeatureCompleteness:      0.10,
+		FeatureReciprocalLike:    0.15,
+	}
+}
+
+// ParseRankingWeights overrides the default weights with a "name=weight,..." list
+func ParseRankingWeights(spec string) (RankingWeights, error) {
+	weights := DefaultRankingWeights()
+	if strings.TrimSpace(spec) == "" {
+		return weights, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid ranking weight %q, expected name=weight", pair)
+		}
+		if _, known := weights[name]; !known {
+			return nil, fmt.Errorf("unknown ranking feature %q", name)
+		}
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight for %s: %q", name, value)
+		}
+		weights[name] = weight
+	}
+	return weights, nil
+}
+
+// RankingWeightsFromEnv reads RANKING_WEIGHTS, falling back to the defaults if it is unset or invalid
+func RankingWeightsFromEnv() (RankingWeights, error) {
+	weights, err := ParseRankingWeights(os.Getenv(RankingWeightsEnv))
+	if err != nil {
+		return DefaultRankingWeights(), err
+	}
+	return weights, nil
+}
+
+// DefaultFeatures returns the built-in features
+func DefaultFeatures() []Feature {
+	return []Feature{
+		distanceFeature{},
+		sharedInterestsFeature{},
+		relationshipGoalsFeature{},
+		recencyFeature{now: time.Now},
+		completenessFeature{},
+		reciprocalLikeFeature{},
+	}
+}
+
+// DefaultReRankers returns the built-in re-rankers
+func DefaultReRankers() []ReRanker {
+	return []ReRanker{goalDiversityReRanker{maxRun: 3}, promotionReRanker{}}
+}
+
+// RankingPipeline generates candidates, scores them with weighted features and re-ranks the result
+type RankingPipeline struct {
+	source    CandidateSource
+	features  []Feature
+	weights   RankingWeights
+	reRankers []ReRanker
+}
+
+// NewRankingPipeline creates a pipeline; source may be nil when only Rank is used
+func NewRankingPipeline(source CandidateSource, weights RankingWeights, features []Feature, reRankers []ReRanker) *RankingPipeline {
+	return &RankingPipeline{
+		source:    source,
+		features:  features,
+		weights:   weights,
+		reRankers: reRankers,
+	}
+}
+
+// Recommend ranks up to poolSize candidates from the source for the viewer
+func (p *RankingPipeline) Recommend(viewer *Viewer, poolSize int) ([]ScoredCandidate, error) {
+	candidates, err := p.source.Candidates(viewer, poolSize)
+	if err != nil {
+		return nil, err
+	}
+	return p.Rank(viewer, candidates), nil
+}
+
+// Rank scores the candidates and returns them best first
+func (p *RankingPipeline) Rank(viewer *Viewer, candidates []Candidate) []ScoredCandidate {
+	ranked := make([]ScoredCandidate, 0, len(candidates))
+	for i := range candidates {
+		scored := ScoredCandidate{Candidate: candidates[i], Features: make(map[string]float64, len(p.features))}
+		for _, feature := range p.features {
+			value := feature.Score(viewer, &candidates[i])
+			scored.Features[feature.Name()] = value
+			scored.Score += p.weights[feature.Name()] * value
+		}
+		ranked = append(ranked, scored)
+	}
+
+	// Ties keep the source order, which is most recently active first
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+
+	for _, reRanker := range p.reRankers {
+		ranked = reRanker.ReRank(viewer, ranked)
+	}
+	return ranked
+}
+
+// distanceFeature prefers closer candidates, relative to the search radius when there is one. The
+// radius is the one the search applied, which a saved maximum distance may have tightened.
+type distanceFeature struct{}
+
+func (distanceFeature) Name() string { return FeatureDistance }
+
+func (distanceFeature) Score(viewer *Viewer, candidate *Candidate) float64 {
+	distance := candidate.Location.Distance
+	if maxDistance := viewer.Filter.EffectiveMaxDistance(); maxDistance > 0 {
+		return clamp01(1 - distance/maxDistance)
+	}
+	return 1 / (1 + distance/10)
+}
+
+// sharedInterestsFeature is the Jaccard similarity of the two interest lists
+type sharedInterestsFeature struct{}
+
+func (sharedInterestsFeature) Name() string { return FeatureSharedInterests }
+
+func (sharedInterestsFeature) Score(viewer *Viewer, candidate *Candidate) float64 {
+	if viewer.Profile == nil || candidate.Profile == nil {
+		return 0
+	}
+
+	shared := 0
+	for _, interest := range candidate.Profile.Interests {
+		if viewer.Profile.Interests.Contains(interest) {
+			shared++
+		}
+	}
+	union := len(viewer.Profile.Interests) + len(candidate.Profile.Interests) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
+
+// relationshipGoalsFeature scores how compatible the two relationship goals are
+type relationshipGoalsFeature struct{}
+
+func (relationshipGoalsFeature) Name() string { return FeatureRelationshipGoals }
+
+// goalIntent places each relationship goal on a scale from short-term (0) to long-term (1)
+var goalIntent = map[string]float64{
+	"Short-term fun":           0,
+	"Short-term, open to long": 0.35,
+	"Long-term, open to short": 0.65,
+	"Long-term partner":        1,
+}
+
+func (relationshipGoalsFeature) Score(viewer *Viewer, candidate *Candidate) float64 {
+	if viewer.Profile == nil || candidate.Profile == nil {
+		return 0.5
+	}
+
+	viewerGoal, candidateGoal := viewer.Profile.RelationshipGoals, candidate.Profile.RelationshipGoals
+	if viewerGoal == "" || candidateGoal == "" {
+		return 0.5
+	}
+	if viewerGoal == candidateGoal {
+		return 1
+	}
+
+	viewerIntent, viewerDating := goalIntent[viewerGoal]
+	candidateIntent, candidateDating := goalIntent[candidateGoal]
+	switch {
+	case viewerDating && candidateDating:
+		return 1 - math.Abs(viewerIntent-candidateIntent)
+	case viewerGoal == "New friends" || candidateGoal == "New friends":
+		return 0.2
+	default:
+		// "Still figuring it out" is open to anything
+		return 0.5
+	}
+}
+
+// recencyFeature prefers recently active candidates, halving every three days
+type recencyFeature struct {
+	now func() time.Time
+}
+
+func (recencyFeature) Name() string { return FeatureRecency }
+
+func (f recencyFeature) Score(viewer *Viewer, candidate *Candidate) float64 {
+	if candidate.LastActive.IsZero() {
+		return 0
+	}
+	days := f.now().Sub(candidate.LastActive).Hours() / 24
+	if days < 0 {
+		days = 0
+	}
+	return math.Pow(0.5, days/3)
+}
+
+// completenessFeature is the share of profile sections the candidate has filled in
+type completenessFeature struct{}
+
+func (completenessFeature) Name() string { return FeatureCompleteness }
+
+func (completenessFeature) Score(viewer *Viewer, candidate *Candidate) float64 {
+	return ProfileCompleteness(candidate.Profile)
+}
+
+// ProfileCompleteness returns the share of profile sections that are filled in; three or more
+// photos count as a complete photo section
+func ProfileCompleteness(profile *models.Profile) float64 {
+	if profile == nil {
+		return 0
+	}
+
+	sections := []bool{
+		profile.AboutMe != "",
+		len(profile.Interests) > 0,
+		profile.RelationshipGoals != "",
+		profile.Height > 0,
+		profile.Language != "",
+		profile.ZodiacSign != "",
+		profile.EducationDetails != "",
+		len(profile.SocialMediaAccounts) > 0,
+	}
+
+	filled := math.Min(float64(len(profile.Photos))/3, 1)
+	for _, section := range sections {
+		if section {
+			filled++
+		}
+	}
+	return filled / float64(len(sections)+1)
+}
+
+// reciprocalLikeFeature estimates how likely the candidate is to like the viewer back: certain if
+// they already have, otherwise their smoothed historical like rate
+type reciprocalLikeFeature struct{}
+
+func (reciprocalLikeFeature) Name() string { return FeatureReciprocalLike }
+
+func (reciprocalLikeFeature) Score(viewer *Viewer, candidate *Candidate) float64 {
+	if candidate.LikedViewer {
+		return 1
+	}
+	return (float64(candidate.Likes) + 1) / (float64(candidate.Swipes) + 2)
+}
+
+// goalDiversityReRanker stops long runs of candidates with the same relationship goal by pulling
+// the next best candidate with a different goal forward
+type goalDiversityReRanker struct {
+	maxRun int
+}
+
+func (r goalDiversityReRanker) ReRank(viewer *Viewer, ranked []ScoredCandidate) []ScoredCandidate {
+	if r.maxRun <= 0 {
+		return ranked
+	}
+
+	result := make([]ScoredCandidate, 0, len(ranked))
+	remaining := append([]ScoredCandidate(nil), ranked...)
+	for len(remaining) > 0 {
+		next := 0
+		if runGoal, run := trailingGoalRun(result); run >= r.maxRun {
+			for i := range remaining {
+				if candidateGoal(&remaining[i]) != runGoal {
+					next = i
+					break
+				}
+			}
+		}
+
+		result = append(result, remaining[next])
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+	return result
+}
+
+// trailingGoalRun returns the goal shared by the last candidates in ranked and how many share it
+func trailingGoalRun(ranked []ScoredCandidate) (string, int) {
+	if len(ranked) == 0 {
+		return "", 0
+	}
+
+	goal := candidateGoal(&ranked[len(ranked)-1])
+	if goal == "" {
+		return "", 0
+	}
+
+	run := 0
+	for i := len(ranked) - 1; i >= 0 && candidateGoal(&ranked[i]) == goal; i-- {
+		run++
+	}
+	return goal, run
+}
+
+func candidateGoal(candidate *ScoredCandidate) string {
+	if candidate.Profile == nil {
+		return ""
+	}
+	return candidate.Profile.RelationshipGoals
+}
+
+// promotionReRanker moves candidates who super liked the viewer to the top of the feed, followed by
+// boosted candidates, keeping the order within each group
+type promotionReRanker struct{}
+
+func (promotionReRanker) ReRank(viewer *Viewer, ranked []ScoredCandidate) []ScoredCandidate {
+	result := make([]ScoredCandidate, 0, len(ranked))
+	for _, promoted := range []func(*ScoredCandidate) bool{
+		func(c *ScoredCandidate) bool { return c.SuperLikedViewer },
+		func(c *ScoredCandidate) bool { return !c.SuperLikedViewer && c.Boosted },
+		func(c *ScoredCandidate) bool { return !c.SuperLikedViewer && !c.Boosted },
+	} {
+		for i := range ranked {
+			if promoted(&ranked[i]) {
+				result = append(result, ranked[i])
+			}
+		}
+	}
+	return result
+}
+
+func clamp01(value float64) float64 {
+	return math.Max(0, math.Min(1, value))
+}
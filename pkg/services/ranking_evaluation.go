@@ -0,0 +1,280 @@
+// services/ranking_evaluation.go
+package services
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// EvaluationSession is one day of a user's historical swipes, replayed as a feed: the swiped
+// users are the candidates and right swipes are the positive labels
+type EvaluationSession struct {
+	Viewer     *Viewer
+	Candidates []Candidate
+	Liked      map[int]bool
+}
+
+// EvaluationReport summarises how well a ranking orders historical likes ahead of passes.
+// Metrics are averaged over sessions.
+type EvaluationReport struct {
+	Sessions     int     `json:"sessions"`
+	Swipes       int     `json:"swipes"`
+	Likes        int     `json:"likes"`
+	K            int     `json:"k"`
+	PrecisionAtK float64 `json:"precisionAtK"`
+	NDCGAtK      float64 `json:"ndcgAtK"`
+	MRR          float64 `json:"mrr"`
+	AUC          float64 `json:"auc"`
+}
+
+// RankingEvaluator rebuilds feed sessions from the swipe history for offline evaluation
+type RankingEvaluator struct {
+	swipeRepo    repository.SwipeHistoryRepository
+	profileRepo  repository.ProfileRepository
+	locationRepo repository.LocationRepository
+}
+
+// NewRankingEvaluator creates a new RankingEvaluator
+func NewRankingEvaluator(swipeRepo repository.SwipeHistoryRepository, profileRepo repository.ProfileRepository, locationRepo repository.LocationRepository) *RankingEvaluator {
+	return &RankingEvaluator{
+		swipeRepo:    swipeRepo,
+		profileRepo:  profileRepo,
+		locationRepo: locationRepo,
+	}
+}
+
+// LoadSessions groups the swipes made since the given time into per-user, per-day sessions with
+// at least minSwipes swipes and both a like and a pass. Swipe counts and earlier likes of the
+// viewer are reconstructed as of the start of each session; profiles and locations are the
+// current ones, as their history is not kept.
+func (e *RankingEvaluator) LoadSessions(since time.Time, minSwipes int) ([]EvaluationSession, error) {
+	swipes, err := e.swipeRepo.GetSwipesSince(since)
+	if err != nil {
+		return nil, err
+	}
+
+	type sessionKey struct {
+		userID int
+		day    string
+	}
+	grouped := make(map[sessionKey][]models.SwipeHistory)
+	var keys []sessionKey
+	for _, swipe := range swipes {
+		key := sessionKey{userID: swipe.SwiperUserID, day: swipe.Timestamp.UTC().Format("2006-01-02")}
+		if _, ok := grouped[key]; !ok {
+			keys = append(keys, key)
+		}
+		grouped[key] = append(grouped[key], swipe)
+	}
+
+	profiles := make(map[int]*models.Profile)
+	profileOf := func(userID int) *models.Profile {
+		if profile, ok := profiles[userID]; ok {
+			return profile
+		}
+		profile, err := e.profileRepo.GetProfileByUserID(userID)
+		if err != nil {
+			profile = nil
+		}
+		profiles[userID] = profile
+		return profile
+	}
+
+	locations := make(map[int]*models.LocationHistory)
+	locationOf := func(userID int) *models.LocationHistory {
+		if location, ok := locations[userID]; ok {
+			return location
+		}
+		location, err := e.locationRepo.GetLatestLocation(userID)
+		if err != nil {
+			location = nil
+		}
+		locations[userID] = location
+		return location
+	}
+
+	var sessions []EvaluationSession
+	for _, key := range keys {
+		sessionSwipes := grouped[key]
+		liked := make(map[int]bool, len(sessionSwipes))
+		for _, swipe := range sessionSwipes {
+			liked[swipe.SwipedUserID] = models.IsLike(swipe.SwipeDirection)
+		}
+		if len(liked) < minSwipes || !hasBothLabels(liked) {
+			continue
+		}
+
+		sessionStart := sessionSwipes[0].Timestamp
+		viewer := &Viewer{UserID: key.userID, Profile: profileOf(key.userID)}
+		viewerLocation := locationOf(key.userID)
+
+		var candidates []Candidate
+		seen := make(map[int]bool, len(liked))
+		for _, swipe := range sessionSwipes {
+			if seen[swipe.SwipedUserID] {
+				continue
+			}
+			seen[swipe.SwipedUserID] = true
+
+			candidate := Candidate{
+				Location: repository.LocationWithDistance{UserID: swipe.SwipedUserID},
+				Profile:  profileOf(swipe.SwipedUserID),
+			}
+			if location := locationOf(swipe.SwipedUserID); location != nil {
+				candidate.LastActive = location.Timestamp
+				if viewerLocation != nil {
+					candidate.Location.Distance = helpers.HaversineDistance(viewerLocation.Latitude, viewerLocation.Longitude, location.Latitude, location.Longitude)
+				}
+			}
+			candidate.Swipes, candidate.Likes, candidate.LikedViewer = swipeHistoryBefore(swipes, swipe.SwipedUserID, key.userID, sessionStart)
+			candidates = append(candidates, candidate)
+		}
+
+		sessions = append(sessions, EvaluationSession{Viewer: viewer, Candidates: candidates, Liked: liked})
+	}
+	return sessions, nil
+}
+
+// swipeHistoryBefore counts the candidate's swipes before the given time and whether one of them
+// liked the viewer
+func swipeHistoryBefore(swipes []models.SwipeHistory, candidateID, viewerID int, before time.Time) (int, int, bool) {
+	total, likes, likedViewer := 0, 0, false
+	for _, swipe := range swipes {
+		if !swipe.Timestamp.Before(before) {
+			break
+		}
+		if swipe.SwiperUserID != candidateID {
+			continue
+		}
+		total++
+		if models.IsLike(swipe.SwipeDirection) {
+			likes++
+			if swipe.SwipedUserID == viewerID {
+				likedViewer = true
+			}
+		}
+	}
+	return total, likes, likedViewer
+}
+
+func hasBothLabels(liked map[int]bool) bool {
+	likes := 0
+	for _, like := range liked {
+		if like {
+			likes++
+		}
+	}
+	return likes > 0 && likes < len(liked)
+}
+
+// EvaluateRanking ranks every session with the pipeline and measures how well likes are ordered
+// ahead of passes
+func EvaluateRanking(pipeline *RankingPipeline, sessions []EvaluationSession, k int) EvaluationReport {
+	report := EvaluationReport{K: k}
+	if len(sessions) == 0 {
+		return report
+	}
+
+	for _, session := range sessions {
+		ranked := pipeline.Rank(session.Viewer, session.Candidates)
+		labels := make([]bool, len(ranked))
+		for i, candidate := range ranked {
+			labels[i] = session.Liked[candidate.Location.UserID]
+		}
+
+		report.Sessions++
+		report.Swipes += len(labels)
+		report.Likes += countTrue(labels)
+		report.PrecisionAtK += precisionAtK(labels, k)
+		report.NDCGAtK += ndcgAtK(labels, k)
+		report.MRR += reciprocalRank(labels)
+		report.AUC += pairwiseAUC(labels)
+	}
+
+	sessionCount := float64(report.Sessions)
+	report.PrecisionAtK /= sessionCount
+	report.NDCGAtK /= sessionCount
+	report.MRR /= sessionCount
+	report.AUC /= sessionCount
+	return report
+}
+
+func countTrue(labels []bool) int {
+	count := 0
+	for _, label := range labels {
+		if label {
+			count++
+		}
+	}
+	return count
+}
+
+func precisionAtK(labels []bool, k int) float64 {
+	if k > len(labels) {
+		k = len(labels)
+	}
+	if k <= 0 {
+		return 0
+	}
+	return float64(countTrue(labels[:k])) / float64(k)
+}
+
+func ndcgAtK(labels []bool, k int) float64 {
+	if k > len(labels) {
+		k = len(labels)
+	}
+
+	dcg := 0.0
+	for i := 0; i < k; i++ {
+		if labels[i] {
+			dcg += 1 / math.Log2(float64(i+2))
+		}
+	}
+
+	ideal := append([]bool(nil), labels...)
+	sort.SliceStable(ideal, func(i, j int) bool { return ideal[i] && !ideal[j] })
+	idcg := 0.0
+	for i := 0; i < k; i++ {
+		if ideal[i] {
+			idcg += 1 / math.Log2(float64(i+2))
+		}
+	}
+
+	if idcg == 0 {
+		return 0
+	}
+	return dcg / idcg
+}
+
+func reciprocalRank(labels []bool) float64 {
+	for i, label := range labels {
+		if label {
+			return 1 / float64(i+1)
+		}
+	}
+	return 0
+}
+
+// pairwiseAUC is the share of (like, pass) pairs where the like is ranked first
+func pairwiseAUC(labels []bool) float64 {
+	passesBelow, ordered, pairs := 0, 0, 0
+	for i := len(labels) - 1; i >= 0; i-- {
+		if labels[i] {
+			ordered += passesBelow
+		} else {
+			passesBelow++
+		}
+	}
+
+	likes := countTrue(labels)
+	pairs = likes * (len(labels) - likes)
+	if pairs == 0 {
+		return 0
+	}
+	return float64(ordered) / float64(pairs)
+}
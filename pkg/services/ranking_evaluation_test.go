@@ -0,0 +1,190 @@
+// services/ranking_evaluation_test.go
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+func (r *memorySwipeRepo) GetSwipesSince(since time.Time) ([]models.SwipeHistory, error) {
+	var swipes []models.SwipeHistory
+	for _, swipe := range r.swipes {
+		if swipe.Timestamp.After(since) {
+			swipes = append(swipes, swipe)
+		}
+	}
+	return swipes, nil
+}
+
+// latestLocationRepo serves each user's latest location from a map
+type latestLocationRepo struct {
+	repository.LocationRepository
+	latest map[int]*models.LocationHistory
+}
+
+func (r *latestLocationRepo) GetLatestLocation(userID int) (*models.LocationHistory, error) {
+	if location, ok := r.latest[userID]; ok {
+		return location, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// labels parses a ranking's labels written as "+" for a like and "-" for a pass
+func labels(ranking string) []bool {
+	parsed := make([]bool, 0, len(ranking))
+	for _, label := range ranking {
+		parsed = append(parsed, label == '+')
+	}
+	return parsed
+}
+
+func TestRankingMetrics(t *testing.T) {
+	tests := []struct {
+		ranking   string
+		k         int
+		precision float64
+		ndcg      float64
+		mrr       float64
+		auc       float64
+	}{
+		{"+-+-", 2, 0.5, 1 / 1.6309297535714575, 1, 0.75},
+		{"-+", 2, 0.5, 0.6309297535714575, 0.5, 0},
+		{"++--", 2, 1, 1, 1, 1},
+		{"--++", 2, 0, 0, 1.0 / 3, 0},
+		{"+-", 5, 0.5, 1, 1, 1},
+		{"+-", 0, 0, 0, 1, 1},
+		{"--", 2, 0, 0, 0, 0},
+		{"++", 2, 1, 1, 1, 0},
+		{"", 2, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q@%d", tt.ranking, tt.k), func(t *testing.T) {
+			ranked := labels(tt.ranking)
+			if got := precisionAtK(ranked, tt.k); !almostEqual(got, tt.precision) {
+				t.Errorf("precisionAtK() = %v, want %v", got, tt.precision)
+			}
+			if got := ndcgAtK(ranked, tt.k); !almostEqual(got, tt.ndcg) {
+				t.Errorf("ndcgAtK() = %v, want %v", got, tt.ndcg)
+			}
+			if got := reciprocalRank(ranked); !almostEqual(got, tt.mrr) {
+				t.Errorf("reciprocalRank() = %v, want %v", got, tt.mrr)
+			}
+			if got := pairwiseAUC(ranked); !almostEqual(got, tt.auc) {
+				t.Errorf("pairwiseAUC() = %v, want %v", got, tt.auc)
+			}
+		})
+	}
+}
+
+func TestEvaluateRanking(t *testing.T) {
+	if report := EvaluateRanking(NewRankingPipeline(nil, DefaultRankingWeights(), DefaultFeatures(), nil), nil, 5); !reflect.DeepEqual(report, EvaluationReport{K: 5}) {
+		t.Errorf("expected an empty report without sessions, got %+v", report)
+	}
+
+	// The viewer liked those who had liked them first, and passed on the others
+	sessions := []EvaluationSession{
+		{
+			Viewer: &Viewer{UserID: 1},
+			Candidates: []Candidate{
+				{Location: repository.LocationWithDistance{UserID: 2}},
+				{Location: repository.LocationWithDistance{UserID: 3}, LikedViewer: true},
+			},
+			Liked: map[int]bool{2: false, 3: true},
+		},
+		{
+			Viewer: &Viewer{UserID: 4},
+			Candidates: []Candidate{
+				{Location: repository.LocationWithDistance{UserID: 5}},
+				{Location: repository.LocationWithDistance{UserID: 6}},
+				{Location: repository.LocationWithDistance{UserID: 7}, LikedViewer: true},
+			},
+			Liked: map[int]bool{5: false, 6: false, 7: true},
+		},
+	}
+
+	reciprocal := NewRankingPipeline(nil, RankingWeights{FeatureReciprocalLike: 1}, DefaultFeatures(), nil)
+	report := EvaluateRanking(reciprocal, sessions, 1)
+	if report.Sessions != 2 || report.Swipes != 5 || report.Likes != 2 || report.K != 1 {
+		t.Errorf("expected 2 sessions of 5 swipes and 2 likes, got %+v", report)
+	}
+	if report.PrecisionAtK != 1 || report.NDCGAtK != 1 || report.MRR != 1 || report.AUC != 1 {
+		t.Errorf("expected perfect metrics for the reciprocal ranking, got %+v", report)
+	}
+
+	// Without weights the source order is kept: the like is last in both sessions
+	unweighted := EvaluateRanking(NewRankingPipeline(nil, RankingWeights{}, DefaultFeatures(), nil), sessions, 1)
+	if unweighted.PrecisionAtK != 0 || !almostEqual(unweighted.MRR, (1.0/2+1.0/3)/2) || unweighted.AUC != 0 {
+		t.Errorf("expected the source order to put likes last, got %+v", unweighted)
+	}
+}
+
+func TestRankingEvaluator_LoadSessions(t *testing.T) {
+	day := time.Date(2024, 5, 10, 9, 0, 0, 0, time.UTC)
+	swipes := &memorySwipeRepo{swipes: []models.SwipeHistory{
+		// The day before, 3 liked user 1 and passed on 9
+		{SwiperUserID: 3, SwipedUserID: 1, SwipeDirection: models.SwipeDirectionRight, Timestamp: day.AddDate(0, 0, -1)},
+		{SwiperUserID: 3, SwipedUserID: 9, SwipeDirection: models.SwipeDirectionLeft, Timestamp: day.AddDate(0, 0, -1)},
+		// User 1's session: a pass on 2, likes for 3 and 4, and 3 again
+		{SwiperUserID: 1, SwipedUserID: 2, SwipeDirection: models.SwipeDirectionLeft, Timestamp: day},
+		{SwiperUserID: 1, SwipedUserID: 3, SwipeDirection: models.SwipeDirectionRight, Timestamp: day.Add(time.Minute)},
+		{SwiperUserID: 1, SwipedUserID: 4, SwipeDirection: models.SwipeDirectionSuper, Timestamp: day.Add(2 * time.Minute)},
+		{SwiperUserID: 1, SwipedUserID: 3, SwipeDirection: models.SwipeDirectionRight, Timestamp: day.Add(3 * time.Minute)},
+		// User 5 only liked on that day, which says nothing about the ranking
+		{SwiperUserID: 5, SwipedUserID: 2, SwipeDirection: models.SwipeDirectionRight, Timestamp: day},
+		{SwiperUserID: 5, SwipedUserID: 3, SwipeDirection: models.SwipeDirectionRight, Timestamp: day},
+		{SwiperUserID: 5, SwipedUserID: 4, SwipeDirection: models.SwipeDirectionRight, Timestamp: day},
+	}}
+	profiles := &memoryProfileRepo{profiles: map[int]*models.Profile{
+		1: {UserID: 1, RelationshipGoals: "New friends"},
+		3: {UserID: 3, RelationshipGoals: "Long-term partner"},
+	}}
+	locations := &latestLocationRepo{latest: map[int]*models.LocationHistory{
+		1: {UserID: 1, Latitude: 0, Longitude: 0, Timestamp: day},
+		3: {UserID: 3, Latitude: 0, Longitude: 0.1, Timestamp: day.Add(-time.Hour)},
+	}}
+
+	sessions, err := NewRankingEvaluator(swipes, profiles, locations).LoadSessions(day.AddDate(0, 0, -7), 3)
+	if err != nil {
+		t.Fatalf("LoadSessions() failed: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("expected only user 1's session, got %d", len(sessions))
+	}
+
+	session := sessions[0]
+	if session.Viewer.UserID != 1 || session.Viewer.Profile != profiles.profiles[1] {
+		t.Errorf("expected user 1 with their profile as the viewer, got %+v", session.Viewer)
+	}
+	if !reflect.DeepEqual(session.Liked, map[int]bool{2: false, 3: true, 4: true}) {
+		t.Errorf("expected 2 passed and 3 and 4 liked, got %v", session.Liked)
+	}
+	if got := candidateUserIDs(session.Candidates); !reflect.DeepEqual(got, []int{2, 3, 4}) {
+		t.Fatalf("expected each swiped user once in swipe order, got %v", got)
+	}
+
+	// User 3's swipes before the session are known, and the distance comes from the latest locations
+	liker := session.Candidates[1]
+	if !liker.LikedViewer || liker.Swipes != 2 || liker.Likes != 1 {
+		t.Errorf("expected 3 to have liked the viewer in 1 of 2 swipes, got %+v", liker)
+	}
+	if liker.Profile != profiles.profiles[3] || !liker.LastActive.Equal(day.Add(-time.Hour)) || liker.Location.Distance < 11 || liker.Location.Distance > 11.2 {
+		t.Errorf("expected 3's profile, activity and distance of about 11 km, got %+v", liker)
+	}
+	if stranger := session.Candidates[0]; stranger.LikedViewer || stranger.Swipes != 0 || stranger.Profile != nil || !stranger.LastActive.IsZero() {
+		t.Errorf("expected nothing known about 2, got %+v", stranger)
+	}
+}
+
+func candidateUserIDs(candidates []Candidate) []int {
+	userIDs := make([]int, 0, len(candidates))
+	for _, candidate := range candidates {
+		userIDs = append(userIDs, candidate.Location.UserID)
+	}
+	return userIDs
+}
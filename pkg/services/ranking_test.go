@@ -0,0 +1,326 @@
+// services/ranking_test.go
+package services
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestDistanceFeature(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested float64
+		saved     float64
+		distance  float64
+		want      float64
+	}{
+		{"no radius, same place", 0, 0, 0, 1},
+		{"no radius, 10 km", 0, 0, 10, 0.5},
+		{"requested radius", 20, 0, 5, 0.75},
+		{"saved radius tighter than the request", 20, 10, 5, 0.5},
+		{"saved radius only", 0, 10, 5, 0.5},
+		{"requested radius tighter than the saved one", 10, 40, 5, 0.5},
+		{"beyond the radius", 10, 0, 15, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viewer := &Viewer{Filter: repository.DiscoveryFilter{MaxDistance: tt.requested, Preferences: &models.DiscoveryPreferences{MaxDistanceKm: tt.saved}}}
+			candidate := &Candidate{Location: repository.LocationWithDistance{Distance: tt.distance}}
+			if got := (distanceFeature{}).Score(viewer, candidate); !almostEqual(got, tt.want) {
+				t.Errorf("Score() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSharedInterestsFeature(t *testing.T) {
+	tests := []struct {
+		name      string
+		viewer    *models.Profile
+		candidate *models.Profile
+		want      float64
+	}{
+		{"no viewer profile", nil, &models.Profile{Interests: models.StringList{"jazz"}}, 0},
+		{"no candidate profile", &models.Profile{Interests: models.StringList{"jazz"}}, nil, 0},
+		{"no interests", &models.Profile{}, &models.Profile{}, 0},
+		{"nothing shared", &models.Profile{Interests: models.StringList{"jazz"}}, &models.Profile{Interests: models.StringList{"hiking"}}, 0},
+		{"one of three shared", &models.Profile{Interests: models.StringList{"jazz", "hiking"}}, &models.Profile{Interests: models.StringList{"hiking", "cooking"}}, 1.0 / 3},
+		{"all shared", &models.Profile{Interests: models.StringList{"jazz", "hiking"}}, &models.Profile{Interests: models.StringList{"hiking", "jazz"}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := (sharedInterestsFeature{}).Score(&Viewer{Profile: tt.viewer}, &Candidate{Profile: tt.candidate})
+			if !almostEqual(got, tt.want) {
+				t.Errorf("Score() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelationshipGoalsFeature(t *testing.T) {
+	tests := []struct {
+		name      string
+		viewer    string
+		candidate string
+		want      float64
+	}{
+		{"unknown goal", "", "Long-term partner", 0.5},
+		{"same goal", "New friends", "New friends", 1},
+		{"opposite intents", "Short-term fun", "Long-term partner", 0},
+		{"close intents", "Long-term partner", "Long-term, open to short", 0.65},
+		{"friends and dating", "New friends", "Long-term partner", 0.2},
+		{"still figuring it out", "Still figuring it out", "Short-term fun", 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viewer := &Viewer{Profile: &models.Profile{RelationshipGoals: tt.viewer}}
+			candidate := &Candidate{Profile: &models.Profile{RelationshipGoals: tt.candidate}}
+			if got := (relationshipGoalsFeature{}).Score(viewer, candidate); !almostEqual(got, tt.want) {
+				t.Errorf("Score() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := (relationshipGoalsFeature{}).Score(&Viewer{}, &Candidate{}); got != 0.5 {
+		t.Errorf("expected 0.5 without profiles, got %v", got)
+	}
+}
+
+func TestRecencyFeature(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	feature := recencyFeature{now: func() time.Time { return now }}
+
+	tests := []struct {
+		name       string
+		lastActive time.Time
+		want       float64
+	}{
+		{"never active", time.Time{}, 0},
+		{"active now", now, 1},
+		{"active three days ago", now.AddDate(0, 0, -3), 0.5},
+		{"active six days ago", now.AddDate(0, 0, -6), 0.25},
+		{"clock skew", now.Add(time.Hour), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := feature.Score(&Viewer{}, &Candidate{LastActive: tt.lastActive}); !almostEqual(got, tt.want) {
+				t.Errorf("Score() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompletenessFeature(t *testing.T) {
+	complete := &models.Profile{
+		AboutMe:             "hello",
+		Interests:           models.StringList{"jazz"},
+		RelationshipGoals:   "New friends",
+		Height:              170,
+		Language:            "English",
+		ZodiacSign:          "Leo",
+		EducationDetails:    "BSc",
+		SocialMediaAccounts: models.SocialMediaAccounts{"instagram": "https://instagram.com/ann"},
+		Photos:              models.StringList{"/photos/1", "/photos/2", "/photos/3"},
+	}
+
+	tests := []struct {
+		name    string
+		profile *models.Profile
+		want    float64
+	}{
+		{"no profile", nil, 0},
+		{"empty profile", &models.Profile{}, 0},
+		{"about me only", &models.Profile{AboutMe: "hello"}, 1.0 / 9},
+		{"one photo of three", &models.Profile{Photos: models.StringList{"/photos/1"}}, 1.0 / 27},
+		{"complete profile", complete, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (completenessFeature{}).Score(&Viewer{}, &Candidate{Profile: tt.profile}); !almostEqual(got, tt.want) {
+				t.Errorf("Score() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReciprocalLikeFeature(t *testing.T) {
+	tests := []struct {
+		name      string
+		candidate Candidate
+		want      float64
+	}{
+		{"already liked the viewer", Candidate{LikedViewer: true, Swipes: 10}, 1},
+		{"no history", Candidate{}, 0.5},
+		{"likes most people", Candidate{Swipes: 10, Likes: 8}, 0.75},
+		{"likes nobody", Candidate{Swipes: 10}, 1.0 / 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (reciprocalLikeFeature{}).Score(&Viewer{}, &tt.candidate); !almostEqual(got, tt.want) {
+				t.Errorf("Score() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// goalCandidates returns scored candidates numbered from 1, one for each goal
+func goalCandidates(goals ...string) []ScoredCandidate {
+	ranked := make([]ScoredCandidate, 0, len(goals))
+	for i, goal := range goals {
+		candidate := Candidate{Location: repository.LocationWithDistance{UserID: i + 1}}
+		if goal != "-" {
+			candidate.Profile = &models.Profile{RelationshipGoals: goal}
+		}
+		ranked = append(ranked, ScoredCandidate{Candidate: candidate})
+	}
+	return ranked
+}
+
+func rankedUserIDs(ranked []ScoredCandidate) []int {
+	userIDs := make([]int, 0, len(ranked))
+	for _, candidate := range ranked {
+		userIDs = append(userIDs, candidate.Location.UserID)
+	}
+	return userIDs
+}
+
+func TestGoalDiversityReRanker(t *testing.T) {
+	tests := []struct {
+		name   string
+		maxRun int
+		goals  []string
+		want   []int
+	}{
+		{"breaks a long run", 2, []string{"A", "A", "A", "B"}, []int{1, 2, 4, 3}},
+		{"short runs are kept", 2, []string{"A", "A", "B", "A"}, []int{1, 2, 3, 4}},
+		{"nothing else to pull forward", 2, []string{"A", "A", "A"}, []int{1, 2, 3}},
+		{"unknown goals are no run", 1, []string{"", "", "B"}, []int{1, 2, 3}},
+		{"missing profiles are no run", 1, []string{"-", "-", "B"}, []int{1, 2, 3}},
+		{"disabled", 0, []string{"A", "A", "A", "B"}, []int{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rankedUserIDs(goalDiversityReRanker{maxRun: tt.maxRun}.ReRank(&Viewer{}, goalCandidates(tt.goals...)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReRank() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromotionReRanker(t *testing.T) {
+	tests := []struct {
+		name       string
+		superLiked []int
+		boosted    []int
+		want       []int
+	}{
+		{"nobody promoted", nil, nil, []int{1, 2, 3, 4}},
+		{"super likes first", []int{3}, nil, []int{3, 1, 2, 4}},
+		{"boosts after super likes", []int{4}, []int{2, 3}, []int{4, 2, 3, 1}},
+		{"a boosted super like counts as a super like", []int{2, 4}, []int{4, 3}, []int{2, 4, 3, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranked := goalCandidates("", "", "", "")
+			for i := range ranked {
+				ranked[i].SuperLikedViewer = containsInt(tt.superLiked, i+1)
+				ranked[i].Boosted = containsInt(tt.boosted, i+1)
+			}
+			if got := rankedUserIDs(promotionReRanker{}.ReRank(&Viewer{}, ranked)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReRank() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankingPipeline_RankWeighsFeatures(t *testing.T) {
+	candidates := []Candidate{
+		{Location: repository.LocationWithDistance{UserID: 1, Distance: 30}, Swipes: 10, Likes: 8},
+		{Location: repository.LocationWithDistance{UserID: 2, Distance: 0}},
+		{Location: repository.LocationWithDistance{UserID: 3, Distance: 30}, LikedViewer: true},
+		{Location: repository.LocationWithDistance{UserID: 4, Distance: 30}, LikedViewer: true},
+	}
+	weights := RankingWeights{FeatureDistance: 1, FeatureReciprocalLike: 2}
+	pipeline := NewRankingPipeline(nil, weights, []Feature{distanceFeature{}, reciprocalLikeFeature{}}, nil)
+
+	ranked := pipeline.Rank(&Viewer{Filter: repository.DiscoveryFilter{MaxDistance: 60}}, candidates)
+	// 3 and 4 tie and keep the source order
+	if got := rankedUserIDs(ranked); !reflect.DeepEqual(got, []int{3, 4, 1, 2}) {
+		t.Fatalf("expected [3 4 1 2], got %v", got)
+	}
+	if first := ranked[0]; !almostEqual(first.Score, 2.5) || first.Features[FeatureDistance] != 0.5 || first.Features[FeatureReciprocalLike] != 1 {
+		t.Errorf("expected a score of 2.5 from both features, got %v with %v", first.Score, first.Features)
+	}
+}
+
+func TestParseRankingWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    RankingWeights
+		wantErr string
+	}{
+		{name: "empty", spec: "", want: DefaultRankingWeights()},
+		{name: "blank", spec: "  ", want: DefaultRankingWeights()},
+		{name: "one override", spec: "distance=0.4", want: withWeights(RankingWeights{FeatureDistance: 0.4})},
+		{name: "several overrides", spec: "distance=0.4, reciprocal=0", want: withWeights(RankingWeights{FeatureDistance: 0.4, FeatureReciprocalLike: 0})},
+		{name: "missing weight", spec: "distance", wantErr: "expected name=weight"},
+		{name: "empty pair", spec: "distance=0.4,", wantErr: "expected name=weight"},
+		{name: "unknown feature", spec: "speed=1", wantErr: "unknown ranking feature"},
+		{name: "not a number", spec: "distance=far", wantErr: "invalid weight for distance"},
+		{name: "negative weight", spec: "recency=-0.1", wantErr: "invalid weight for recency"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRankingWeights(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v (%v)", tt.wantErr, err, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRankingWeights() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRankingWeights() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankingWeightsFromEnvFallsBackToTheDefaults(t *testing.T) {
+	t.Setenv(RankingWeightsEnv, "distance=0.4")
+	if weights, err := RankingWeightsFromEnv(); err != nil || weights[FeatureDistance] != 0.4 {
+		t.Errorf("expected the configured weight, got %v (%v)", weights, err)
+	}
+
+	t.Setenv(RankingWeightsEnv, "distance=-1")
+	weights, err := RankingWeightsFromEnv()
+	if err == nil {
+		t.Errorf("expected the invalid weights reported")
+	}
+	if !reflect.DeepEqual(weights, DefaultRankingWeights()) {
+		t.Errorf("expected the default weights, got %v", weights)
+	}
+}
+
+// withWeights returns the default weights with the given ones overridden
+func withWeights(overrides RankingWeights) RankingWeights {
+	weights := DefaultRankingWeights()
+	for name, weight := range overrides {
+		weights[name] = weight
+	}
+	return weights
+}
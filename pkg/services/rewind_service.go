@@ -0,0 +1,103 @@
+// services/rewind_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// RewindWindowEnv names the environment variable holding how long after a left swipe it can be undone
+const RewindWindowEnv = "REWIND_WINDOW"
+
+// DefaultRewindWindow is the rewind window used when none is configured
+const DefaultRewindWindow = 5 * time.Minute
+
+// ErrNothingToRewind is returned when the user has no left swipe recent enough to undo
+var ErrNothingToRewind = errors.New("no recent left swipe to rewind")
+
+// Rewind is an undone left swipe and the profile it passed on. Profile is nil when the profile can
+// no longer be shown, e.g. because either user has blocked the other since.
+type Rewind struct {
+	Swipe   *models.SwipeHistory `json:"swipe"`
+	Profile *PublicProfile       `json:"profile,omitempty"`
+}
+
+// RewindService undoes left swipes, spending a rewind from the user's inventory for each
+type RewindService struct {
+	userRepo             repository.UserRepository
+	swipeRepo            repository.SwipeHistoryRepository
+	inventoryService     *InventoryService
+	publicProfileService *PublicProfileService
+	entitlementsService  *EntitlementsService
+	window               time.Duration
+	now                  func() time.Time
+}
+
+// NewRewindService creates a new instance of RewindService
+func NewRewindService(
+	userRepo repository.UserRepository,
+	swipeRepo repository.SwipeHistoryRepository,
+	inventoryService *InventoryService,
+	publicProfileService *PublicProfileService,
+	entitlementsService *EntitlementsService,
+	window time.Duration,
+) *RewindService {
+	return &RewindService{
+		userRepo:             userRepo,
+		swipeRepo:            swipeRepo,
+		inventoryService:     inventoryService,
+		publicProfileService: publicProfileService,
+		entitlementsService:  entitlementsService,
+		window:               window,
+		now:                  time.Now,
+	}
+}
+
+// RewindWindowFromEnv reads the rewind window from the environment, keeping the default if it is
+// unset or invalid
+func RewindWindowFromEnv() time.Duration {
+	return positiveDurationEnv(RewindWindowEnv, DefaultRewindWindow)
+}
+
+// Rewind undoes the user's most recent left swipe within the window so the profile shows up in
+// discovery again, and returns that profile. The rewind spent on it is given back if there is
+// nothing to undo; ErrNoItemsLeft is returned when the user has no rewinds left today.
+func (s *RewindService) Rewind(userID int) (*Rewind, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	credit, err := s.inventoryService.Consume(userID, models.ItemRewind)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	swipe, err := s.swipeRepo.UndoLeftSwipe(userID, now.Add(-s.window), now.In(user.Location()))
+	if err != nil {
+		if refundErr := s.inventoryService.Refund(credit); refundErr != nil {
+			return nil, fmt.Errorf("%w (and returning the rewind failed: %v)", err, refundErr)
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNothingToRewind
+		}
+		return nil, err
+	}
+
+	rewind := &Rewind{Swipe: swipe}
+	entitlements, err := s.entitlementsService.Entitlements(userID)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := s.publicProfileService.GetPublicProfiles(userID, []int{swipe.SwipedUserID}, ViewerTierFor(entitlements))
+	if err != nil {
+		return nil, err
+	}
+	rewind.Profile = profiles[swipe.SwipedUserID]
+	return rewind, nil
+}
@@ -0,0 +1,198 @@
+// services/rewind_service_test.go
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// memorySwipeRepo keeps swipes in memory; only the methods used by rewinds are implemented. Undoing
+// a swipe forgets the profile having been shown today in views, when set.
+type memorySwipeRepo struct {
+	repository.SwipeHistoryRepository
+	swipes []models.SwipeHistory
+	views  *memoryLocationRepo
+}
+
+func (r *memorySwipeRepo) UndoLeftSwipe(userID int, since, today time.Time) (*models.SwipeHistory, error) {
+	latest := -1
+	for i, swipe := range r.swipes {
+		if swipe.SwiperUserID == userID && swipe.SwipeDirection == models.SwipeDirectionLeft && !swipe.Timestamp.Before(since) &&
+			(latest < 0 || swipe.Timestamp.After(r.swipes[latest].Timestamp)) {
+			latest = i
+		}
+	}
+	if latest < 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	swipe := r.swipes[latest]
+	r.swipes = append(r.swipes[:latest], r.swipes[latest+1:]...)
+
+	if r.views != nil {
+		day := today.Format("2006-01-02")
+		var shown []int
+		for _, shownUserID := range r.views.shown[day] {
+			if shownUserID != swipe.SwipedUserID {
+				shown = append(shown, shownUserID)
+			}
+		}
+		r.views.shown[day] = shown
+	}
+	return &swipe, nil
+}
+
+func (r *memorySwipeRepo) HasLiked(swiperUserID, swipedUserID int) (bool, error) {
+	for _, swipe := range r.swipes {
+		if swipe.SwiperUserID == swiperUserID && swipe.SwipedUserID == swipedUserID && models.IsLike(swipe.SwipeDirection) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryPassportRepo) GetActivePassport(userID int) (*models.Passport, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryLocationRepo) GetLatestLocation(userID int) (*models.LocationHistory, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryLocationRepo) GetDiscoveryLocation(userID int) (*models.LocationHistory, bool, error) {
+	return nil, false, gorm.ErrRecordNotFound
+}
+
+// newPublicProfileFixture returns a public profile service over the given profiles, with nothing
+// cached
+func newPublicProfileFixture(profiles *memoryProfileRepo, locations *memoryLocationRepo, swipes *memorySwipeRepo, blocks *memoryBlockRepo) *PublicProfileService {
+	redis := &mocks.MockRedisHandler{
+		GetFunc: func(key string, dest interface{}) error { return errors.New("cache miss") },
+		SetFunc: func(key string, value interface{}, expiration time.Duration) error { return nil },
+	}
+	return NewPublicProfileService(memoryUserRepo{}, profiles, locations, blocks, swipes, nil, &memoryPassportRepo{}, redis)
+}
+
+func TestRewindService_OnlyRecentLeftSwipesAreUndone(t *testing.T) {
+	inventoryService, _ := newInventoryFixture(nil, 0)
+	now := time.Date(2024, 3, 11, 12, 0, 0, 0, time.UTC)
+	inventoryService.now = func() time.Time { return now }
+
+	swipeRepo := &memorySwipeRepo{swipes: []models.SwipeHistory{
+		{SwipeHistoryEntityID: 1, SwiperUserID: 5, SwipedUserID: 7, SwipeDirection: models.SwipeDirectionLeft, Timestamp: now.Add(-10 * time.Minute)},
+		{SwipeHistoryEntityID: 2, SwiperUserID: 5, SwipedUserID: 8, SwipeDirection: models.SwipeDirectionRight, Timestamp: now.Add(-time.Minute)},
+	}}
+	service := NewRewindService(memoryUserRepo{}, swipeRepo, inventoryService, nil, nil, DefaultRewindWindow)
+	service.now = func() time.Time { return now }
+
+	// The left swipe is outside the window and likes cannot be rewound
+	if _, err := service.Rewind(5); !errors.Is(err, ErrNothingToRewind) {
+		t.Fatalf("expected nothing to rewind, got %v", err)
+	}
+	if len(swipeRepo.swipes) != 2 {
+		t.Fatalf("expected the swipes to be kept, got %v", swipeRepo.swipes)
+	}
+
+	// The failed rewind was given back
+	wallet, err := inventoryService.Wallet(5)
+	if err != nil {
+		t.Fatalf("wallet failed: %v", err)
+	}
+	if wallet.Balances[models.ItemRewind] != models.FreeRewindsPerDay {
+		t.Fatalf("expected %d rewinds, got %d", models.FreeRewindsPerDay, wallet.Balances[models.ItemRewind])
+	}
+}
+
+func TestRewindService_UndoesTheSwipeAndShowsTheProfileAgain(t *testing.T) {
+	inventoryService, _ := newInventoryFixture(nil, 0)
+	locations := &memoryLocationRepo{nearby: []repository.LocationWithDistance{
+		{UserID: 7, Distance: 1, Timestamp: time.Now()},
+	}}
+	swipeRepo := &memorySwipeRepo{views: locations}
+	profiles := &memoryProfileRepo{profiles: map[int]*models.Profile{7: {UserID: 7, AboutMe: "hello"}}}
+	discovery := NewDiscoveryService(memoryUserRepo{}, profiles, memoryDiscoveryPreferencesRepo{}, locations, swipeRepo, &memoryInventoryRepo{}, DefaultRankingWeights())
+	publicProfiles := newPublicProfileFixture(profiles, locations, swipeRepo, &memoryBlockRepo{})
+	service := NewRewindService(memoryUserRepo{}, swipeRepo, inventoryService, publicProfiles, inventoryService.entitlementsService, DefaultRewindWindow)
+
+	// User 7 was shown to user 5 yesterday and again today, and passed on
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	locations.shown = map[string][]int{yesterday: {7}}
+	if page, err := discovery.FindNearby(5, 50, 10); err != nil || len(page) != 1 {
+		t.Fatalf("expected user 7 to be shown, got %v (%v)", page, err)
+	}
+	swipeRepo.swipes = append(swipeRepo.swipes, models.SwipeHistory{
+		SwipeHistoryEntityID: 1, SwiperUserID: 5, SwipedUserID: 7, SwipeDirection: models.SwipeDirectionLeft, Timestamp: time.Now(),
+	})
+	if page, _ := discovery.FindNearby(5, 50, 10); len(page) != 0 {
+		t.Fatalf("expected user 7 not to be shown twice in a day, got %v", nearbyUserIDs(page))
+	}
+
+	rewind, err := service.Rewind(5)
+	if err != nil {
+		t.Fatalf("rewind failed: %v", err)
+	}
+	if rewind.Swipe.SwipedUserID != 7 || rewind.Profile == nil || rewind.Profile.UserID != 7 || rewind.Profile.AboutMe != "hello" {
+		t.Fatalf("expected the swipe on user 7 and their profile back, got %+v", rewind)
+	}
+	if len(swipeRepo.swipes) != 0 {
+		t.Fatalf("expected the swipe to be deleted, got %v", swipeRepo.swipes)
+	}
+
+	// The rewind was spent
+	wallet, err := inventoryService.Wallet(5)
+	if err != nil {
+		t.Fatalf("wallet failed: %v", err)
+	}
+	if wallet.Balances[models.ItemRewind] != models.FreeRewindsPerDay-1 {
+		t.Fatalf("expected %d rewinds left, got %d", models.FreeRewindsPerDay-1, wallet.Balances[models.ItemRewind])
+	}
+
+	// Only today's view was forgotten, so user 7 is back in discovery
+	if !containsInt(locations.shown[yesterday], 7) {
+		t.Fatalf("expected yesterday's view to be kept, got %v", locations.shown)
+	}
+	if page, err := discovery.FindNearby(5, 50, 10); err != nil || len(page) != 1 || page[0].UserID != 7 {
+		t.Fatalf("expected user 7 to be shown again, got %v (%v)", page, err)
+	}
+}
+
+func TestRewindService_AllowanceResetsAtLocalMidnight(t *testing.T) {
+	inventoryService, _ := newInventoryFixture(nil, 0)
+	// 23:30 on 11 March in Kolkata, still the afternoon of the 11th in UTC
+	now := time.Date(2024, 3, 11, 18, 0, 0, 0, time.UTC)
+	inventoryService.now = func() time.Time { return now }
+	inventoryService.userRepo = timeZoneUserRepo{timeZone: "Asia/Kolkata"}
+
+	swipeRepo := &memorySwipeRepo{}
+	publicProfiles := newPublicProfileFixture(&memoryProfileRepo{}, &memoryLocationRepo{}, swipeRepo, &memoryBlockRepo{})
+	service := NewRewindService(timeZoneUserRepo{timeZone: "Asia/Kolkata"}, swipeRepo, inventoryService, publicProfiles, inventoryService.entitlementsService, DefaultRewindWindow)
+	service.now = func() time.Time { return now }
+	swipe := func(swipedUserID int) {
+		swipeRepo.swipes = append(swipeRepo.swipes, models.SwipeHistory{
+			SwiperUserID: 5, SwipedUserID: swipedUserID, SwipeDirection: models.SwipeDirectionLeft, Timestamp: now,
+		})
+	}
+
+	for i := 0; i < models.FreeRewindsPerDay; i++ {
+		swipe(10 + i)
+		if _, err := service.Rewind(5); err != nil {
+			t.Fatalf("rewind %d failed: %v", i+1, err)
+		}
+	}
+	swipe(20)
+	if _, err := service.Rewind(5); !errors.Is(err, ErrNoItemsLeft) {
+		t.Fatalf("expected the daily rewinds to run out, got %v", err)
+	}
+
+	// Local midnight has passed an hour later, though it is still the 11th in UTC
+	now = now.Add(time.Hour)
+	swipe(21)
+	if _, err := service.Rewind(5); err != nil {
+		t.Fatalf("expected a new day's rewind, got %v", err)
+	}
+}
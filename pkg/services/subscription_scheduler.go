@@ -0,0 +1,258 @@
+// services/subscription_scheduler.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// Environment variables overriding DefaultSubscriptionSchedulerPolicy
+const (
+	SubscriptionReminderDaysEnv      = "SUBSCRIPTION_REMINDER_DAYS"
+	SubscriptionGracePeriodEnv       = "SUBSCRIPTION_GRACE_PERIOD"
+	SubscriptionSchedulerIntervalEnv = "SUBSCRIPTION_SCHEDULER_INTERVAL"
+	SubscriptionSchedulerBatchEnv    = "SUBSCRIPTION_SCHEDULER_BATCH_SIZE"
+)
+
+// Notification types sent by the subscription scheduler
+const (
+	// NotificationTypeSubscriptionExpiring reminds the user that their plan ends soon
+	NotificationTypeSubscriptionExpiring = "Subscription Expiring"
+	// NotificationTypeSubscriptionPastDue tells the user their renewal payment failed
+	NotificationTypeSubscriptionPastDue = "Subscription Past Due"
+	// NotificationTypeSubscriptionExpired tells the user their plan has ended
+	NotificationTypeSubscriptionExpired = "Subscription Expired"
+)
+
+// subscriptionSchedulerLockKey is the Redis key of the lock electing the instance that runs the
+// scheduler
+const subscriptionSchedulerLockKey = "lock:subscription-scheduler"
+
+// SubscriptionSchedulerPolicy decides when users are reminded of the end of their plan, how long
+// a failed renewal keeps the plan, and how often the scheduler runs
+type SubscriptionSchedulerPolicy struct {
+	ReminderDays int
+	GracePeriod  time.Duration
+	Interval     time.Duration
+	BatchSize    int
+}
+
+// DefaultSubscriptionSchedulerPolicy returns the policy used when none is configured
+func DefaultSubscriptionSchedulerPolicy() SubscriptionSchedulerPolicy {
+	return SubscriptionSchedulerPolicy{
+		ReminderDays: 3,
+		GracePeriod:  72 * time.Hour,
+		Interval:     time.Minute,
+		BatchSize:    500,
+	}
+}
+
+// SubscriptionSchedulerPolicyFromEnv reads the policy from the environment, keeping the default
+// for any value that is unset or invalid
+func SubscriptionSchedulerPolicyFromEnv() SubscriptionSchedulerPolicy {
+	policy := DefaultSubscriptionSchedulerPolicy()
+	policy.ReminderDays = positiveIntEnv(SubscriptionReminderDaysEnv, policy.ReminderDays)
+	policy.BatchSize = positiveIntEnv(SubscriptionSchedulerBatchEnv, policy.BatchSize)
+	policy.GracePeriod = positiveDurationEnv(SubscriptionGracePeriodEnv, policy.GracePeriod)
+	policy.Interval = positiveDurationEnv(SubscriptionSchedulerIntervalEnv, policy.Interval)
+	return policy
+}
+
+func positiveDurationEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("Invalid %s %q, using %v", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// SubscriptionSchedulerReport counts the subscriptions changed by one scheduler run
+type SubscriptionSchedulerReport struct {
+	Reminded     int
+	GraceStarted int
+	Expired      int
+}
+
+// SubscriptionScheduler moves subscriptions through the end of their period: it reminds users of
+// plans that will not renew, gives renewals that have not been paid a grace period, and expires
+// plans whose access has ended. Only the instance holding the Redis lock runs it.
+type SubscriptionScheduler struct {
+	subscriptionRepo    repository.SubscriptionRepository
+	notificationRepo    repository.NotificationRepository
+	passportRepo        repository.PassportRepository
+	entitlementsService *EntitlementsService
+	lock                *helpers.LeaderLock
+	policy              SubscriptionSchedulerPolicy
+	now                 func() time.Time
+}
+
+// NewSubscriptionScheduler creates a new instance of SubscriptionScheduler
+func NewSubscriptionScheduler(
+	subscriptionRepo repository.SubscriptionRepository,
+	notificationRepo repository.NotificationRepository,
+	passportRepo repository.PassportRepository,
+	entitlementsService *EntitlementsService,
+	redis helpers.RedisHandler,
+	policy SubscriptionSchedulerPolicy,
+) *SubscriptionScheduler {
+	// The lease outlives a few missed runs, so a slow run keeps the lock but a dead leader is
+	// replaced soon
+	return &SubscriptionScheduler{
+		subscriptionRepo:    subscriptionRepo,
+		notificationRepo:    notificationRepo,
+		passportRepo:        passportRepo,
+		entitlementsService: entitlementsService,
+		lock:                helpers.NewLeaderLock(redis, subscriptionSchedulerLockKey, 3*policy.Interval),
+		policy:              policy,
+		now:                 time.Now,
+	}
+}
+
+// Start runs the scheduler on every interval while this instance is the leader, until the
+// context is cancelled
+func (s *SubscriptionScheduler) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.policy.Interval)
+	defer ticker.Stop()
+	defer s.lock.Release()
+
+	for {
+		if s.lock.Hold() {
+			report, err := s.RunOnce(ctx)
+			if err != nil {
+				log.Printf("Subscription scheduler failed: %v", err)
+			} else if report.Reminded+report.GraceStarted+report.Expired > 0 {
+				log.Printf("Subscription scheduler reminded %d, started grace for %d and expired %d subscriptions", report.Reminded, report.GraceStarted, report.Expired)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce starts grace periods, expires lapsed subscriptions and sends due reminders, a batch at
+// a time until nothing is left to do. It stops early if the leadership is lost.
+func (s *SubscriptionScheduler) RunOnce(ctx context.Context) (SubscriptionSchedulerReport, error) {
+	var report SubscriptionSchedulerReport
+	now := s.now()
+
+	steps := []struct {
+		total *int
+		run   func() (int, error)
+	}{
+		{&report.GraceStarted, func() (int, error) { return s.startGracePeriods(now) }},
+		{&report.Expired, func() (int, error) { return s.expireLapsed(now) }},
+		{&report.Reminded, func() (int, error) { return s.sendReminders(now) }},
+	}
+
+	for _, step := range steps {
+		for {
+			changed, err := step.run()
+			*step.total += changed
+			if err != nil {
+				return report, err
+			}
+			if changed < s.policy.BatchSize {
+				break
+			}
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			if !s.lock.Hold() {
+				return report, fmt.Errorf("lost the %s lock", subscriptionSchedulerLockKey)
+			}
+		}
+	}
+	return report, nil
+}
+
+// startGracePeriods makes renewing subscriptions whose period ended without a payment past due.
+// The provider normally reports the failed payment itself; this covers webhooks that never came.
+func (s *SubscriptionScheduler) startGracePeriods(now time.Time) (int, error) {
+	subscriptions, err := s.subscriptionRepo.StartGracePeriods(now, s.policy.GracePeriod, s.policy.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, subscription := range subscriptions {
+		s.entitlementsService.Invalidate(subscription.UserID)
+	}
+	return len(subscriptions), nil
+}
+
+// expireLapsed expires subscriptions whose access has ended and takes away what the plan granted.
+// The plan is not part of the JWT or the user cache, so dropping the cached entitlements is enough
+// for every premium check to see the change.
+func (s *SubscriptionScheduler) expireLapsed(now time.Time) (int, error) {
+	subscriptions, err := s.subscriptionRepo.ExpireLapsedSubscriptions(now, s.policy.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, subscription := range subscriptions {
+		s.entitlementsService.Invalidate(subscription.UserID)
+
+		// A passport set while subscribed ends with the plan
+		if err := s.passportRepo.DeletePassport(subscription.UserID); err != nil {
+			log.Printf("Error ending passport of user %d: %v", subscription.UserID, err)
+		}
+
+		s.notify(subscription.UserID, NotificationTypeSubscriptionExpired, "Your premium plan has ended. You can subscribe again at any time.", now)
+	}
+	return len(subscriptions), nil
+}
+
+// sendReminders tells users whose plan will not renew by itself that it ends soon. Each end of
+// access is claimed before the notification is sent, so a user is reminded of it at most once.
+func (s *SubscriptionScheduler) sendReminders(now time.Time) (int, error) {
+	remindBefore := now.AddDate(0, 0, s.policy.ReminderDays)
+	subscriptions, err := s.subscriptionRepo.GetSubscriptionsDueForReminder(now, remindBefore, s.policy.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, subscription := range subscriptions {
+		endsAt := subscription.AccessEndsAt()
+		claimed, err := s.subscriptionRepo.MarkReminderSent(subscription.SubscriptionID, endsAt)
+		if err != nil {
+			return 0, err
+		}
+		if !claimed {
+			continue
+		}
+
+		notificationType := NotificationTypeSubscriptionExpiring
+		message := fmt.Sprintf("Your %s plan ends on %s.", subscription.Plan.Name, endsAt.Format(time.RFC1123))
+		if subscription.Status == models.SubscriptionStatusPastDue {
+			notificationType = NotificationTypeSubscriptionPastDue
+			message = fmt.Sprintf("We couldn't renew your %s plan. Update your payment details before %s to keep it.", subscription.Plan.Name, endsAt.Format(time.RFC1123))
+		}
+		s.notify(subscription.UserID, notificationType, message, now)
+	}
+	return len(subscriptions), nil
+}
+
+func (s *SubscriptionScheduler) notify(userID int, notificationType, message string, now time.Time) {
+	notification := &models.Notification{
+		UserID:           userID,
+		NotificationType: notificationType,
+		Message:          message,
+		Timestamp:        now,
+	}
+	if err := s.notificationRepo.CreateNotification(notification); err != nil {
+		log.Printf("Error creating %s notification for user %d: %v", notificationType, userID, err)
+	}
+}
@@ -0,0 +1,208 @@
+// services/subscription_scheduler_test.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/metabbe3/knoxsdating/pkg/helpers/mocks"
+	"github.com/metabbe3/knoxsdating/pkg/models"
+	"github.com/metabbe3/knoxsdating/pkg/repository"
+)
+
+// memoryNotificationRepo records the notifications created
+type memoryNotificationRepo struct {
+	repository.NotificationRepository
+	created []models.Notification
+}
+
+func (r *memoryNotificationRepo) CreateNotification(notification *models.Notification) error {
+	notification.NotificationID = len(r.created) + 1
+	r.created = append(r.created, *notification)
+	return nil
+}
+
+func (r *memoryNotificationRepo) ofType(notificationType string) []models.Notification {
+	var matching []models.Notification
+	for _, notification := range r.created {
+		if notification.NotificationType == notificationType {
+			matching = append(matching, notification)
+		}
+	}
+	return matching
+}
+
+// memoryPassportRepo records the passports ended
+type memoryPassportRepo struct {
+	repository.PassportRepository
+	deleted []int
+}
+
+func (r *memoryPassportRepo) DeletePassport(userID int) error {
+	r.deleted = append(r.deleted, userID)
+	return nil
+}
+
+type schedulerFixture struct {
+	scheduler     *SubscriptionScheduler
+	subscriptions *memorySubscriptionRepo
+	notifications *memoryNotificationRepo
+	passports     *memoryPassportRepo
+	redis         *mocks.MockRedisHandler
+	invalidated   map[string]int
+	now           time.Time
+}
+
+func newSchedulerFixture() *schedulerFixture {
+	fixture := &schedulerFixture{
+		subscriptions: &memorySubscriptionRepo{plans: []models.Plan{
+			{PlanID: 2, Code: "gold", Name: "Gold", Rank: 2, PeriodMonths: 1, Passport: true, IsActive: true},
+		}},
+		notifications: &memoryNotificationRepo{},
+		passports:     &memoryPassportRepo{},
+		invalidated:   make(map[string]int),
+		now:           time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC),
+	}
+	fixture.redis = &mocks.MockRedisHandler{
+		DeleteFunc: func(key string) error {
+			fixture.invalidated[key]++
+			return nil
+		},
+	}
+
+	policy := DefaultSubscriptionSchedulerPolicy()
+	policy.BatchSize = 2
+	fixture.scheduler = NewSubscriptionScheduler(fixture.subscriptions, fixture.notifications, fixture.passports,
+		NewEntitlementsService(fixture.subscriptions, fixture.redis), fixture.redis, policy)
+	fixture.scheduler.now = func() time.Time { return fixture.now }
+	return fixture
+}
+
+// add stores a gold subscription for the user; renewing ones are linked to the payment provider
+func (f *schedulerFixture) add(userID int, status string, periodEnd time.Time, renewing bool) *models.Subscription {
+	subscription := &models.Subscription{
+		UserID:             userID,
+		PlanID:             2,
+		Status:             status,
+		CurrentPeriodStart: periodEnd.AddDate(0, -1, 0),
+		CurrentPeriodEnd:   periodEnd,
+	}
+	if renewing {
+		subscription.Provider = "stripe"
+		subscription.ProviderSubscriptionID = fmt.Sprintf("sub_%d", userID)
+	}
+	f.subscriptions.SaveSubscription(subscription)
+	return subscription
+}
+
+func (f *schedulerFixture) stored(subscription *models.Subscription) *models.Subscription {
+	return f.subscriptions.subscriptions[subscription.SubscriptionID-1]
+}
+
+func TestSubscriptionScheduler_RunOnce(t *testing.T) {
+	f := newSchedulerFixture()
+	now := f.now
+
+	endingSoon := f.add(1, models.SubscriptionStatusCanceled, now.Add(48*time.Hour), false)
+	endingLater := f.add(2, models.SubscriptionStatusCanceled, now.AddDate(0, 0, 10), false)
+	renewingSoon := f.add(3, models.SubscriptionStatusActive, now.Add(24*time.Hour), true)
+	lapsed := f.add(4, models.SubscriptionStatusCanceled, now.Add(-time.Minute), false)
+	unpaid := f.add(5, models.SubscriptionStatusActive, now.Add(-time.Hour), true)
+	graceOver := f.add(6, models.SubscriptionStatusPastDue, now.Add(-96*time.Hour), true)
+	graceUntil := now.Add(-time.Second)
+	f.stored(graceOver).GraceUntil = &graceUntil
+	grantedLapsed := f.add(7, models.SubscriptionStatusActive, now.Add(-time.Hour), false)
+
+	report, err := f.scheduler.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if report.GraceStarted != 1 || report.Expired != 3 || report.Reminded != 2 {
+		t.Errorf("expected 1 grace period, 3 expiries and 2 reminders, got %+v", report)
+	}
+
+	// Renewals that were not paid keep the plan for the grace period
+	if got := f.stored(unpaid); got.Status != models.SubscriptionStatusPastDue || !got.GraceUntil.Equal(unpaid.CurrentPeriodEnd.Add(72*time.Hour)) {
+		t.Errorf("expected the unpaid renewal to be past due for 72h, got %+v", got)
+	}
+
+	// Lapsed plans expire, end their passport and drop the cached entitlements
+	for _, subscription := range []*models.Subscription{lapsed, graceOver, grantedLapsed} {
+		if got := f.stored(subscription).Status; got != models.SubscriptionStatusExpired {
+			t.Errorf("expected the subscription of user %d to expire, got %q", subscription.UserID, got)
+		}
+		if f.invalidated[entitlementsCacheKey(subscription.UserID)] == 0 {
+			t.Errorf("expected the entitlements of user %d to be invalidated", subscription.UserID)
+		}
+	}
+	if len(f.passports.deleted) != 3 || len(f.notifications.ofType(NotificationTypeSubscriptionExpired)) != 3 {
+		t.Errorf("expected 3 passports ended and 3 expiry notifications, got %v and %d", f.passports.deleted, len(f.notifications.ofType(NotificationTypeSubscriptionExpired)))
+	}
+
+	// Plans that will not renew by themselves are reminded of, renewing ones and later ones are not
+	for _, subscription := range []*models.Subscription{endingLater, renewingSoon} {
+		if got := f.stored(subscription); got.ReminderSentFor != nil || got.Status != subscription.Status {
+			t.Errorf("expected the subscription of user %d to be left alone, got %+v", subscription.UserID, got)
+		}
+	}
+	expiring := f.notifications.ofType(NotificationTypeSubscriptionExpiring)
+	if len(expiring) != 1 || expiring[0].UserID != endingSoon.UserID {
+		t.Errorf("expected one expiry reminder for user %d, got %+v", endingSoon.UserID, expiring)
+	}
+	pastDue := f.notifications.ofType(NotificationTypeSubscriptionPastDue)
+	if len(pastDue) != 1 || pastDue[0].UserID != unpaid.UserID {
+		t.Errorf("expected one past due reminder for user %d, got %+v", unpaid.UserID, pastDue)
+	}
+
+	// Running again sends nothing twice
+	created := len(f.notifications.created)
+	report, err = f.scheduler.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if report != (SubscriptionSchedulerReport{}) || len(f.notifications.created) != created {
+		t.Errorf("expected a second run to change nothing, got %+v and %d new notifications", report, len(f.notifications.created)-created)
+	}
+
+	// The reminder window moving over the later plan reminds its user
+	f.now = now.AddDate(0, 0, 8)
+	if report, _ = f.scheduler.RunOnce(context.Background()); report.Reminded != 1 {
+		t.Errorf("expected a reminder once the later plan is due, got %+v", report)
+	}
+}
+
+func TestSubscriptionScheduler_OnlyLeaderRuns(t *testing.T) {
+	f := newSchedulerFixture()
+	lapsed := f.add(1, models.SubscriptionStatusCanceled, f.now.Add(-time.Minute), false)
+
+	var released int
+	f.redis.AcquireLockFunc = func(key, token string, ttl time.Duration) (bool, error) {
+		return false, nil
+	}
+	f.redis.ReleaseLockFunc = func(key, token string) error {
+		released++
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.scheduler.Start(ctx)
+	if got := f.stored(lapsed).Status; got != models.SubscriptionStatusCanceled {
+		t.Fatalf("expected an instance without the lock to change nothing, got %q", got)
+	}
+
+	var lockTTL time.Duration
+	f.redis.AcquireLockFunc = func(key, token string, ttl time.Duration) (bool, error) {
+		lockTTL = ttl
+		return key == subscriptionSchedulerLockKey, nil
+	}
+	f.scheduler.Start(ctx)
+	if got := f.stored(lapsed).Status; got != models.SubscriptionStatusExpired {
+		t.Errorf("expected the leader to expire the subscription, got %q", got)
+	}
+	if lockTTL <= f.scheduler.policy.Interval || released != 2 {
+		t.Errorf("expected a lease longer than the interval released on stop, got %v and %d releases", lockTTL, released)
+	}
+}